@@ -5,7 +5,7 @@ EXTRABINDATA:=
 EBPF_PROBES:=
 ifeq ($(WITH_EBPF), true)
   EXTRABINDATA+=probe/ebpf/*.o
-  EBPF_PROBES+=probe/ebpf/flow.o probe/ebpf/flow-gre.o
+  EBPF_PROBES+=probe/ebpf/flow.o probe/ebpf/flow-gre.o probe/ebpf/socket.o probe/ebpf/drop.o
 endif
 
 BINDATA_DIRS := \
@@ -0,0 +1,5 @@
+api/client/typed_genclient.go: api/client/typed.go scripts/genclient/main.go
+	go generate -run genclient $<
+
+.PHONY: .genclient
+.genclient: api/client/typed_genclient.go
@@ -36,6 +36,7 @@ flow/flow.pb.go: flow/flow.proto filters/filters.proto
 	sed -e 's/type ICMPLayer struct {/\/\/ gendecoder\ntype ICMPLayer struct {/' -i $@
 	sed -e 's/type IPMetric struct {/\/\/ gendecoder\ntype IPMetric struct {/' -i $@
 	sed -e 's/type TCPMetric struct {/\/\/ gendecoder\ntype TCPMetric struct {/' -i $@
+	sed -e 's/type DropMetric struct {/\/\/ gendecoder\ntype DropMetric struct {/' -i $@
 	# This is to allow calling go generate on flow/flow.pb.go
 	sed -e 's/DO NOT EDIT./DO NOT MODIFY/' -i $@
 	sed '1 i //go:generate go run github.com/skydive-project/skydive/scripts/gendecoder' -i $@
@@ -44,6 +45,11 @@ flow/flow.pb.go: flow/flow.proto filters/filters.proto
 flow/flow.pb_easyjson.go: flow/flow.pb.go
 	go run github.com/mailru/easyjson/easyjson -all $<
 
+api/rpc/api.pb.go: api/rpc/api.proto flow/flow.proto filters/filters.proto
+	go get -u ${PROTOC_GEN_GOFAST_GITHUB}
+	protoc -I. -Iflow/layers -I$${GOPATH}/pkg/mod/github.com/gogo/protobuf@v1.3.0 --plugin=$${GOPATH}/bin/protoc-gen-gogofaster --gogofaster_out=plugins=grpc:$$GOPATH/src $<
+	gofmt -s -w $@
+
 websocket/structmessage.pb.go: websocket/structmessage.proto
 	$(call PROTOC_GEN,$<)
 
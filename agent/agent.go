@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 
 	api "github.com/skydive-project/skydive/api/server"
@@ -39,6 +40,7 @@ import (
 	"github.com/skydive-project/skydive/ondemand/server"
 	"github.com/skydive-project/skydive/packetinjector"
 	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/spool"
 	"github.com/skydive-project/skydive/topology"
 	"github.com/skydive-project/skydive/ui"
 	"github.com/skydive-project/skydive/websocket"
@@ -60,6 +62,7 @@ type Agent struct {
 	onDemandPIServer    *server.OnDemandServer
 	httpServer          *shttp.Server
 	tidMapper           *topology.TIDMapper
+	spools              map[string]*spool.Spool
 }
 
 // NewAnalyzerStructClientPool creates a new http WebSocket client Pool
@@ -99,20 +102,52 @@ type Status struct {
 	Analyzers      map[string]pod.ConnStatus
 	TopologyProbes map[string]interface{}
 	FlowProbes     []string
+	Spools         map[string]spool.Stats
+	Flows          client.Stats
 }
 
 // GetStatus returns the status of an agent
 func (a *Agent) GetStatus() interface{} {
 	podStatus := a.pod.GetStatus()
 
+	spools := make(map[string]spool.Stats)
+	for name, s := range a.spools {
+		spools[name] = s.Stats()
+	}
+
 	return &Status{
 		Clients:        podStatus.Subscribers,
 		Analyzers:      podStatus.Hubs,
 		TopologyProbes: a.topologyProbeBundle.GetStatus(),
 		FlowProbes:     a.flowProbeBundle.EnabledProbes(),
+		Spools:         spools,
+		Flows:          a.flowClientPool.GetStats(),
 	}
 }
 
+// openSpools opens the spools keeping the topology events and the flows
+// while no analyzer is reachable
+func openSpools() map[string]*spool.Spool {
+	spools := make(map[string]*spool.Spool)
+
+	maxSize := int64(config.GetInt("agent.spool.max_size")) * 1024 * 1024
+	if maxSize <= 0 {
+		return spools
+	}
+
+	for _, name := range []string{"topology", "flow"} {
+		dir := filepath.Join(config.GetString("agent.spool.path"), name)
+		s, err := spool.Open(dir, maxSize)
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to open the %s spool, events won't be kept while no analyzer is reachable: %s", name, err)
+			continue
+		}
+		spools[name] = s
+	}
+
+	return spools
+}
+
 // Start the agent services
 func (a *Agent) Start() {
 	if uid := os.Geteuid(); uid != 0 {
@@ -199,7 +234,7 @@ func NewAgent() (*Agent, error) {
 		return nil, err
 	}
 
-	api.RegisterTopologyAPI(hserver, g, tr, apiAuthBackend)
+	api.RegisterTopologyAPI(hserver, g, tr, apiAuthBackend, config.GetInt("http.rest.events_history"))
 
 	clusterAuthOptions := &shttp.AuthenticationOpts{
 		Username: config.GetString("agent.auth.cluster.username"),
@@ -217,14 +252,18 @@ func NewAgent() (*Agent, error) {
 		return nil, fmt.Errorf("Unable to instantiate a schema validator: %s", err)
 	}
 
+	spools := openSpools()
+
 	opts := pod.Opts{
 		ServerOpts: websocket.ServerOpts{
 			WriteCompression: true,
+			BatchDelay:       time.Duration(config.GetInt("http.ws.batch_delay")) * time.Millisecond,
 			QueueSize:        10000,
 			PingDelay:        2 * time.Second,
 			PongTimeout:      5 * time.Second,
 		},
-		Validator: validator,
+		Validator:     validator,
+		TopologySpool: spools["topology"],
 	}
 
 	pod, err := pod.NewPod(apiServer, analyzerClientPool, g, apiAuthBackend, clusterAuthOptions, tr, opts)
@@ -241,6 +280,9 @@ func NewAgent() (*Agent, error) {
 	expireAfter := time.Duration(config.GetInt("flow.expire")) * time.Second
 
 	flowClientPool := client.NewFlowClientPool(analyzerClientPool, clusterAuthOptions)
+	if s, ok := spools["flow"]; ok {
+		flowClientPool.SetSpool(s)
+	}
 	flowTableAllocator := flow.NewTableAllocator(updateEvery, expireAfter, flowClientPool)
 
 	// exposes a flow server through the client connections
@@ -271,6 +313,7 @@ func NewAgent() (*Agent, error) {
 		onDemandPIServer:    onDemandPIServer,
 		httpServer:          hserver,
 		tidMapper:           tm,
+		spools:              spools,
 	}
 
 	api.RegisterStatusAPI(hserver, agent, apiAuthBackend)
@@ -36,6 +36,7 @@ import (
 	"github.com/skydive-project/skydive/graffiti/graph/traversal"
 	"github.com/skydive-project/skydive/js"
 	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
 	ws "github.com/skydive-project/skydive/websocket"
 )
 
@@ -61,11 +62,22 @@ type GremlinAlert struct {
 	gremlinParser     *traversal.GremlinTraversalParser
 }
 
+// tenantGraph returns the part of the graph the tenant of the alert has
+// access to, the whole graph if the alert doesn't belong to a tenant
+func (ga *GremlinAlert) tenantGraph(lockGraph bool) (*graph.Graph, error) {
+	return rbac.GetTenantGraph(ga.graph, ga.Tenant, ga.gremlinParser, lockGraph)
+}
+
 func (ga *GremlinAlert) evaluate(server *api.Server, vm *js.Runtime, lockGraph bool) (interface{}, error) {
 	// If the alert is a simple Gremlin query, avoid
 	// converting to JavaScript
 	if ga.traversalSequence != nil {
-		result, err := ga.traversalSequence.Exec(ga.graph, lockGraph)
+		g, err := ga.tenantGraph(lockGraph)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := ga.traversalSequence.Exec(g, lockGraph)
 		if err != nil {
 			return nil, err
 		}
@@ -78,6 +90,11 @@ func (ga *GremlinAlert) evaluate(server *api.Server, vm *js.Runtime, lockGraph b
 		return nil, nil
 	}
 
+	// JavaScript expressions have access to the whole graph
+	if ga.Tenant != "" {
+		return nil, fmt.Errorf("Only Gremlin expressions are supported for the alerts of tenant %s", ga.Tenant)
+	}
+
 	// Fallback to JavaScript
 	result, err := vm.Exec(ga.Expression)
 	if err != nil {
@@ -376,7 +393,12 @@ func (a *Server) unregisterAlert(id string) {
 
 func (a *Server) onAPIWatcherEvent(action string, id string, resource types.Resource) {
 	switch action {
-	case "init", "create", "set", "update":
+	case "init", "create", "set":
+		if err := a.registerAlert(resource.(*types.Alert)); err != nil {
+			logging.GetLogger().Errorf("Failed to register alert: %s", err)
+		}
+	case "update":
+		a.unregisterAlert(id)
 		if err := a.registerAlert(resource.(*types.Alert)); err != nil {
 			logging.GetLogger().Errorf("Failed to register alert: %s", err)
 		}
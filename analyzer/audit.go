@@ -0,0 +1,149 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/skydive-project/skydive/audit"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/etcd"
+	gc "github.com/skydive-project/skydive/graffiti/common"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	gws "github.com/skydive-project/skydive/graffiti/websocket"
+	"github.com/skydive-project/skydive/logging"
+	ws "github.com/skydive-project/skydive/websocket"
+)
+
+// initAuditFromConfig sets the audit backends listed in the configuration
+func initAuditFromConfig(etcdClient *etcd.Client) error {
+	var backends []audit.Backend
+
+	for _, name := range config.GetStringSlice("audit.backends") {
+		switch name {
+		case "file":
+			path := config.GetString("audit.file.path")
+			maxSize := int64(config.GetInt("audit.file.max_size")) * 1024 * 1024
+			maxBackups := config.GetInt("audit.file.max_backups")
+
+			backend, err := audit.NewFileBackend(path, maxSize, maxBackups)
+			if err != nil {
+				return err
+			}
+			backends = append(backends, backend)
+		case "syslog":
+			backend, err := audit.NewSyslogBackend(config.GetString("audit.syslog.tag"))
+			if err != nil {
+				return err
+			}
+			backends = append(backends, backend)
+		default:
+			driver := config.GetString("storage." + name + ".driver")
+			if driver != "elasticsearch" {
+				return fmt.Errorf("Audit backend '%s' not supported", name)
+			}
+
+			backend, err := audit.NewElasticSearchBackend(NewESConfig(name), etcdClient)
+			if err != nil {
+				return err
+			}
+			backends = append(backends, backend)
+		}
+
+		logging.GetLogger().Infof("Using %s as audit backend", name)
+	}
+
+	audit.Init(backends...)
+	return nil
+}
+
+// publisherAudit records the graph mutations requested by the publishers.
+// The graph lock is held by the caller.
+func publisherAudit(g *graph.Graph) gc.PublisherAudit {
+	return func(c ws.Speaker, msgType string, obj interface{}) {
+		if !audit.Enabled() {
+			return
+		}
+
+		addr, _ := c.GetAddrPort()
+		entry := &audit.Entry{
+			User:     c.GetUsername(),
+			SourceIP: addr,
+		}
+
+		var err error
+		switch msgType {
+		case gws.SyncMsgType, gws.SyncReplyMsgType:
+			entry.Action, entry.Resource = "sync", "topology"
+			entry.ResourceID = gc.ClientOrigin(c)
+		case gws.NodeAddedMsgType, gws.NodeUpdatedMsgType, gws.NodeDeletedMsgType:
+			node := obj.(*graph.Node)
+			entry.Resource, entry.ResourceID = "node", string(node.ID)
+
+			switch msgType {
+			case gws.NodeAddedMsgType:
+				entry.Action = "create"
+				entry.Diff, err = json.Marshal(node)
+			case gws.NodeUpdatedMsgType:
+				entry.Action = "update"
+				if previous := g.GetNode(node.ID); previous != nil {
+					entry.Diff, err = audit.Diff(previous, node)
+				} else {
+					entry.Diff, err = json.Marshal(node)
+				}
+			default:
+				entry.Action = "delete"
+			}
+		case gws.EdgeAddedMsgType, gws.EdgeUpdatedMsgType, gws.EdgeDeletedMsgType:
+			edge := obj.(*graph.Edge)
+			entry.Resource, entry.ResourceID = "edge", string(edge.ID)
+
+			switch msgType {
+			case gws.EdgeAddedMsgType:
+				entry.Action = "create"
+				entry.Diff, err = json.Marshal(edge)
+			case gws.EdgeUpdatedMsgType:
+				entry.Action = "update"
+				if previous := g.GetEdge(edge.ID); previous != nil {
+					entry.Diff, err = audit.Diff(previous, edge)
+				} else {
+					entry.Diff, err = json.Marshal(edge)
+				}
+			default:
+				entry.Action = "delete"
+			}
+		case gws.NodePartiallyUpdatedMsgType, gws.EdgePartiallyUpdatedMsgType:
+			pu := obj.(*gws.PartiallyUpdatedMsg)
+			entry.Resource, entry.ResourceID = "node", string(pu.ID)
+			if msgType == gws.EdgePartiallyUpdatedMsgType {
+				entry.Resource = "edge"
+			}
+			entry.Action = "update"
+			entry.Diff, err = json.Marshal(pu.Ops)
+		default:
+			return
+		}
+
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to compute the audit diff of %s %s: %s", entry.Resource, entry.ResourceID, err)
+		}
+
+		audit.Log(entry)
+	}
+}
@@ -18,6 +18,7 @@
 package analyzer
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"sync"
@@ -44,6 +45,7 @@ import (
 	"github.com/skydive-project/skydive/ondemand/client"
 	"github.com/skydive-project/skydive/packetinjector"
 	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/rbac"
 	"github.com/skydive-project/skydive/sflow"
 	"github.com/skydive-project/skydive/topology"
 	usertopology "github.com/skydive-project/skydive/topology/enhancers"
@@ -80,6 +82,7 @@ type Status struct {
 	Subscribers map[string]ws.ConnStatus
 	Alerts      ElectionStatus
 	Captures    ElectionStatus
+	Flows       server.Stats
 	Probes      map[string]interface{}
 }
 
@@ -89,10 +92,12 @@ type Server struct {
 	uiServer        *ui.Server
 	hub             *hub.Hub
 	alertServer     *alert.Server
+	tokenAPIHandler *api.APITokenAPIHandler
 	onDemandClient  *client.OnDemandClient
 	piClient        *client.OnDemandClient
 	topologyManager *usertopology.TopologyManager
 	flowServer      *server.FlowServer
+	grpcServer      *api.GRPCServer
 	probeBundle     *probe.Bundle
 	storage         storage.Storage
 	embeddedEtcd    *etcd.EmbeddedEtcd
@@ -111,6 +116,7 @@ func (s *Server) GetStatus() interface{} {
 		Subscribers: hubStatus.Subscribers,
 		Alerts:      ElectionStatus{IsMaster: s.alertServer.IsMaster()},
 		Captures:    ElectionStatus{IsMaster: s.onDemandClient.IsMaster()},
+		Flows:       s.flowServer.GetStats(),
 		Probes:      s.probeBundle.GetStatus(),
 	}
 }
@@ -140,6 +146,7 @@ func (s *Server) Start() error {
 		return err
 	}
 
+	s.tokenAPIHandler.Start()
 	s.hub.Start()
 	s.probeBundle.Start()
 	s.onDemandClient.Start()
@@ -148,6 +155,12 @@ func (s *Server) Start() error {
 	s.topologyManager.Start()
 	s.flowServer.Start()
 
+	if s.grpcServer != nil {
+		if err := s.grpcServer.Start(); err != nil {
+			return err
+		}
+	}
+
 	s.wgServers.Add(1)
 	go func() {
 		defer s.wgServers.Done()
@@ -161,6 +174,9 @@ func (s *Server) Start() error {
 func (s *Server) Stop() {
 	s.hub.Stop()
 	s.flowServer.Stop()
+	if s.grpcServer != nil {
+		s.grpcServer.Stop()
+	}
 	s.httpServer.Stop()
 	if s.embeddedEtcd != nil {
 		s.embeddedEtcd.Stop()
@@ -173,6 +189,7 @@ func (s *Server) Stop() {
 	s.piClient.Stop()
 	s.alertServer.Stop()
 	s.topologyManager.Stop()
+	s.tokenAPIHandler.Stop()
 	s.etcdClient.Stop()
 	s.wgServers.Wait()
 	if tr, ok := http.DefaultTransport.(interface {
@@ -261,6 +278,11 @@ func NewServerFromConfig() (*Server, error) {
 	// "cluster" endpoints will be admin
 	clusterAuthBackend.SetDefaultUserRole("admin")
 
+	// API tokens are accepted by both the cluster and the API backends
+	tokenAPIHandler := api.NewAPITokenAPIHandler(etcdClient.KeysAPI)
+	rbac.SetServiceAccountResolver(tokenAPIHandler.TokenRoles)
+	clusterAuthBackend = shttp.NewAPITokenBackend(clusterAuthBackend, tokenAPIHandler)
+
 	apiAuthBackendName := config.GetString("analyzer.auth.api.backend")
 	apiAuthBackend, err := config.NewAuthenticationBackendByName(apiAuthBackendName)
 	if err != nil {
@@ -269,6 +291,8 @@ func NewServerFromConfig() (*Server, error) {
 
 	uiServer.RegisterLoginRoute(apiAuthBackend)
 
+	apiAuthBackend = shttp.NewAPITokenBackend(apiAuthBackend, tokenAPIHandler)
+
 	peers, err := config.GetAnalyzerServiceAddresses()
 	if err != nil {
 		return nil, fmt.Errorf("Unable to get the analyzers list: %s", err)
@@ -279,14 +303,24 @@ func NewServerFromConfig() (*Server, error) {
 		return nil, fmt.Errorf("Unable to instantiate a schema validator: %s", err)
 	}
 
+	if err := initAuditFromConfig(etcdClient); err != nil {
+		return nil, fmt.Errorf("Unable to initialize the audit: %s", err)
+	}
+
 	opts := hub.Opts{
 		ServerOpts: websocket.ServerOpts{
 			WriteCompression: true,
+			BatchDelay:       time.Duration(config.GetInt("http.ws.batch_delay")) * time.Millisecond,
 			QueueSize:        10000,
 			PingDelay:        2 * time.Second,
 			PongTimeout:      5 * time.Second,
 		},
 		Validator: validator,
+		// users belonging to a tenant only see the graph of their tenant
+		ScopeFilter: func(c websocket.Speaker) string {
+			return rbac.GetUserFilter(c.GetUsername())
+		},
+		PublisherAudit: publisherAudit(g),
 	}
 
 	clusterAuthOptions := ClusterAuthenticationOpts()
@@ -331,6 +365,17 @@ func NewServerFromConfig() (*Server, error) {
 		return nil, err
 	}
 
+	// users belonging to a tenant only receive the flows of the captures of their tenant
+	flowSubscriberEndpoint.SetCaptureAccess(func(c ws.Speaker, captureID string) bool {
+		tenant := rbac.GetUserTenant(c.GetUsername())
+		if tenant == nil {
+			return true
+		}
+
+		resource, found := captureAPIHandler.Get(captureID)
+		return found && resource.(*types.Capture).Tenant == tenant.Name
+	})
+
 	piAPIHandler, err := api.RegisterPacketInjectorAPI(g, apiServer, apiAuthBackend)
 	if err != nil {
 		return nil, err
@@ -356,6 +401,10 @@ func NewServerFromConfig() (*Server, error) {
 		return nil, err
 	}
 
+	if err := api.RegisterAPITokenAPI(apiServer, tokenAPIHandler, apiAuthBackend); err != nil {
+		return nil, err
+	}
+
 	onDemandClient := ondemand.NewOnDemandFlowProbeClient(g, captureAPIHandler, hub.PodServer(), hub.SubscriberServer(), etcdClient)
 
 	flowServer, err := server.NewFlowServer(hserver, g, storage, flowSubscriberEndpoint, probeBundle, clusterAuthBackend)
@@ -380,15 +429,19 @@ func NewServerFromConfig() (*Server, error) {
 		storage:         storage,
 		flowServer:      flowServer,
 		alertServer:     alertServer,
+		tokenAPIHandler: tokenAPIHandler,
 	}
 
 	s.createStartupCapture(captureAPIHandler)
 
-	api.RegisterTopologyAPI(hserver, g, tr, apiAuthBackend)
+	topologyAPI := api.RegisterTopologyAPI(hserver, g, tr, apiAuthBackend, config.GetInt("http.rest.events_history"))
 	api.RegisterPcapAPI(hserver, storage, apiAuthBackend)
+	api.RegisterPcapFileAPI(hserver, g, tr, storage, apiAuthBackend)
 	api.RegisterConfigAPI(hserver, apiAuthBackend)
 	api.RegisterStatusAPI(hserver, s, apiAuthBackend)
 	api.RegisterWorkflowCallAPI(hserver, apiAuthBackend, apiServer, g, tr)
+	api.RegisterCaptureDumpAPI(hserver, apiServer, tableClient, apiAuthBackend)
+	api.RegisterAuditAPI(hserver, apiAuthBackend)
 
 	if config.GetBool("analyzer.ssh_enabled") {
 		if err := dede.RegisterHandler("terminal", "/dede", hserver.Router); err != nil {
@@ -396,6 +449,18 @@ func NewServerFromConfig() (*Server, error) {
 		}
 	}
 
+	if addr := config.GetString("analyzer.grpc.listen"); addr != "" {
+		var tlsConfig *tls.Config
+		if config.IsTLSEnabled() {
+			if tlsConfig, err = config.GetTLSServerConfig(true); err != nil {
+				return nil, err
+			}
+		}
+
+		s.grpcServer = api.NewGRPCServer(addr, tlsConfig, topologyAPI, captureAPIHandler, tableClient, storage, apiAuthBackend)
+		flowSubscriberEndpoint.AddFlowListener(s.grpcServer)
+	}
+
 	return s, nil
 }
 
@@ -421,6 +486,7 @@ func init() {
 	graph.NodeMetadataDecoders["Vfs"] = netlink.VFSMetadataDecoder
 	graph.NodeMetadataDecoders["Metric"] = topology.InterfaceMetricMetadataDecoder
 	graph.NodeMetadataDecoders["LastUpdateMetric"] = topology.InterfaceMetricMetadataDecoder
+	graph.NodeMetadataDecoders["DropMetric"] = topology.InterfaceDropMetricMetadataDecoder
 	graph.NodeMetadataDecoders["SFlow"] = sflow.SFMetadataDecoder
 	graph.NodeMetadataDecoders["Ovs"] = ovsdb.OvsMetadataDecoder
 	graph.NodeMetadataDecoders["LLDP"] = lldp.MetadataDecoder
@@ -0,0 +1,90 @@
+//go:generate go run github.com/skydive-project/skydive/scripts/genclient -output typed_genclient.go alert=Alert capture=Capture edgerule=EdgeRule noderule=NodeRule injectpacket=PacketInjection workflow=Workflow
+
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	shttp "github.com/skydive-project/skydive/http"
+)
+
+// Client is a typed client of the Skydive REST API. The methods handling
+// the resources are generated in typed_genclient.go.
+type Client struct {
+	*shttp.CrudClient
+}
+
+// NewClient returns a typed client using the given REST client
+func NewClient(crudClient *shttp.CrudClient) *Client {
+	return &Client{CrudClient: crudClient}
+}
+
+// NewClientFromConfig creates a new typed client of the API
+func NewClientFromConfig(authOptions *shttp.AuthenticationOpts) (*Client, error) {
+	crudClient, err := NewCrudClientFromConfig(authOptions)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(crudClient), nil
+}
+
+// CallWorkflow calls the workflow with the given ID and returns its result
+func (c *Client) CallWorkflow(id string, params ...interface{}) (interface{}, error) {
+	s, err := json.Marshal(&types.WorkflowCall{Params: params})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Request("POST", "workflow/"+id+"/call", bytes.NewReader(s), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to call workflow %s, %s", id, resp.Status)
+	}
+
+	var result interface{}
+	if err := common.JSONDecode(resp.Body, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetStatus fills the passed value with the status of the service
+func (c *Client) GetStatus(status interface{}) error {
+	resp, err := c.Request("GET", "status", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to get status, %s", resp.Status)
+	}
+
+	return common.JSONDecode(resp.Body, status)
+}
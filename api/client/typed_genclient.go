@@ -0,0 +1,212 @@
+// Code generated - DO NOT EDIT.
+
+package client
+
+import (
+	"github.com/skydive-project/skydive/api/types"
+	shttp "github.com/skydive-project/skydive/http"
+)
+
+// ListAlerts returns the alerts indexed by ID
+func (c *Client) ListAlerts() (map[string]*types.Alert, error) {
+	var resources map[string]*types.Alert
+	if err := c.List("alert", &resources); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// GetAlert returns the alert with the given ID along with its ETag
+func (c *Client) GetAlert(id string) (*types.Alert, string, error) {
+	var resource types.Alert
+	etag, err := c.GetWithETag("alert", id, &resource)
+	if err != nil {
+		return nil, "", err
+	}
+	return &resource, etag, nil
+}
+
+// CreateAlert creates an alert, the passed alert is filled with the created one
+func (c *Client) CreateAlert(resource *types.Alert, opts *shttp.CreateOptions) error {
+	return c.Create("alert", resource, opts)
+}
+
+// UpdateAlert replaces an alert, the passed alert is filled with the updated one
+func (c *Client) UpdateAlert(resource *types.Alert, opts *shttp.UpdateOptions) error {
+	return c.Update("alert", resource.ID(), resource, opts)
+}
+
+// DeleteAlert deletes the alert with the given ID
+func (c *Client) DeleteAlert(id string) error {
+	return c.Delete("alert", id)
+}
+
+// ListCaptures returns the captures indexed by ID
+func (c *Client) ListCaptures() (map[string]*types.Capture, error) {
+	var resources map[string]*types.Capture
+	if err := c.List("capture", &resources); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// GetCapture returns the capture with the given ID along with its ETag
+func (c *Client) GetCapture(id string) (*types.Capture, string, error) {
+	var resource types.Capture
+	etag, err := c.GetWithETag("capture", id, &resource)
+	if err != nil {
+		return nil, "", err
+	}
+	return &resource, etag, nil
+}
+
+// CreateCapture creates a capture, the passed capture is filled with the created one
+func (c *Client) CreateCapture(resource *types.Capture, opts *shttp.CreateOptions) error {
+	return c.Create("capture", resource, opts)
+}
+
+// UpdateCapture replaces a capture, the passed capture is filled with the updated one
+func (c *Client) UpdateCapture(resource *types.Capture, opts *shttp.UpdateOptions) error {
+	return c.Update("capture", resource.ID(), resource, opts)
+}
+
+// DeleteCapture deletes the capture with the given ID
+func (c *Client) DeleteCapture(id string) error {
+	return c.Delete("capture", id)
+}
+
+// ListEdgeRules returns the edge rules indexed by ID
+func (c *Client) ListEdgeRules() (map[string]*types.EdgeRule, error) {
+	var resources map[string]*types.EdgeRule
+	if err := c.List("edgerule", &resources); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// GetEdgeRule returns the edge rule with the given ID along with its ETag
+func (c *Client) GetEdgeRule(id string) (*types.EdgeRule, string, error) {
+	var resource types.EdgeRule
+	etag, err := c.GetWithETag("edgerule", id, &resource)
+	if err != nil {
+		return nil, "", err
+	}
+	return &resource, etag, nil
+}
+
+// CreateEdgeRule creates an edge rule, the passed edge rule is filled with the created one
+func (c *Client) CreateEdgeRule(resource *types.EdgeRule, opts *shttp.CreateOptions) error {
+	return c.Create("edgerule", resource, opts)
+}
+
+// UpdateEdgeRule replaces an edge rule, the passed edge rule is filled with the updated one
+func (c *Client) UpdateEdgeRule(resource *types.EdgeRule, opts *shttp.UpdateOptions) error {
+	return c.Update("edgerule", resource.ID(), resource, opts)
+}
+
+// DeleteEdgeRule deletes the edge rule with the given ID
+func (c *Client) DeleteEdgeRule(id string) error {
+	return c.Delete("edgerule", id)
+}
+
+// ListNodeRules returns the node rules indexed by ID
+func (c *Client) ListNodeRules() (map[string]*types.NodeRule, error) {
+	var resources map[string]*types.NodeRule
+	if err := c.List("noderule", &resources); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// GetNodeRule returns the node rule with the given ID along with its ETag
+func (c *Client) GetNodeRule(id string) (*types.NodeRule, string, error) {
+	var resource types.NodeRule
+	etag, err := c.GetWithETag("noderule", id, &resource)
+	if err != nil {
+		return nil, "", err
+	}
+	return &resource, etag, nil
+}
+
+// CreateNodeRule creates a node rule, the passed node rule is filled with the created one
+func (c *Client) CreateNodeRule(resource *types.NodeRule, opts *shttp.CreateOptions) error {
+	return c.Create("noderule", resource, opts)
+}
+
+// UpdateNodeRule replaces a node rule, the passed node rule is filled with the updated one
+func (c *Client) UpdateNodeRule(resource *types.NodeRule, opts *shttp.UpdateOptions) error {
+	return c.Update("noderule", resource.ID(), resource, opts)
+}
+
+// DeleteNodeRule deletes the node rule with the given ID
+func (c *Client) DeleteNodeRule(id string) error {
+	return c.Delete("noderule", id)
+}
+
+// ListPacketInjections returns the packet injections indexed by ID
+func (c *Client) ListPacketInjections() (map[string]*types.PacketInjection, error) {
+	var resources map[string]*types.PacketInjection
+	if err := c.List("injectpacket", &resources); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// GetPacketInjection returns the packet injection with the given ID along with its ETag
+func (c *Client) GetPacketInjection(id string) (*types.PacketInjection, string, error) {
+	var resource types.PacketInjection
+	etag, err := c.GetWithETag("injectpacket", id, &resource)
+	if err != nil {
+		return nil, "", err
+	}
+	return &resource, etag, nil
+}
+
+// CreatePacketInjection creates a packet injection, the passed packet injection is filled with the created one
+func (c *Client) CreatePacketInjection(resource *types.PacketInjection, opts *shttp.CreateOptions) error {
+	return c.Create("injectpacket", resource, opts)
+}
+
+// UpdatePacketInjection replaces a packet injection, the passed packet injection is filled with the updated one
+func (c *Client) UpdatePacketInjection(resource *types.PacketInjection, opts *shttp.UpdateOptions) error {
+	return c.Update("injectpacket", resource.ID(), resource, opts)
+}
+
+// DeletePacketInjection deletes the packet injection with the given ID
+func (c *Client) DeletePacketInjection(id string) error {
+	return c.Delete("injectpacket", id)
+}
+
+// ListWorkflows returns the workflows indexed by ID
+func (c *Client) ListWorkflows() (map[string]*types.Workflow, error) {
+	var resources map[string]*types.Workflow
+	if err := c.List("workflow", &resources); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// GetWorkflow returns the workflow with the given ID along with its ETag
+func (c *Client) GetWorkflow(id string) (*types.Workflow, string, error) {
+	var resource types.Workflow
+	etag, err := c.GetWithETag("workflow", id, &resource)
+	if err != nil {
+		return nil, "", err
+	}
+	return &resource, etag, nil
+}
+
+// CreateWorkflow creates a workflow, the passed workflow is filled with the created one
+func (c *Client) CreateWorkflow(resource *types.Workflow, opts *shttp.CreateOptions) error {
+	return c.Create("workflow", resource, opts)
+}
+
+// UpdateWorkflow replaces a workflow, the passed workflow is filled with the updated one
+func (c *Client) UpdateWorkflow(resource *types.Workflow, opts *shttp.UpdateOptions) error {
+	return c.Update("workflow", resource.ID(), resource, opts)
+}
+
+// DeleteWorkflow deletes the workflow with the given ID
+func (c *Client) DeleteWorkflow(id string) error {
+	return c.Delete("workflow", id)
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// authCredentials passes the credentials of a client in the authorization
+// metadata of each call, as the HTTP authorization header would
+type authCredentials struct {
+	authorization string
+	insecure      bool
+}
+
+// GetRequestMetadata returns the authorization metadata of a call
+func (c *authCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": c.authorization}, nil
+}
+
+// RequireTransportSecurity returns whether the credentials can only be sent
+// over TLS connections
+func (c *authCredentials) RequireTransportSecurity() bool {
+	return !c.insecure
+}
+
+// NewBasicCredentials returns the credentials of a user authenticated by
+// its password. Unless insecure is set, the credentials are only sent
+// over TLS connections.
+func NewBasicCredentials(username, password string, insecure bool) credentials.PerRPCCredentials {
+	basic := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return &authCredentials{authorization: "Basic " + basic, insecure: insecure}
+}
+
+// NewTokenCredentials returns the credentials of a client authenticated by
+// a token, such as an API token or a token returned by the authentication
+// backend. Unless insecure is set, the token is only sent over TLS connections.
+func NewTokenCredentials(token string, insecure bool) credentials.PerRPCCredentials {
+	return &authCredentials{authorization: "Bearer " + token, insecure: insecure}
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	auth "github.com/abbot/go-http-auth"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/audit"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+// auditRequest records an action done through an API request. The diff
+// is computed only if the audit is enabled.
+func auditRequest(r *auth.AuthenticatedRequest, action, resource, id string, diff func() (json.RawMessage, error)) {
+	auditAction(r.Username, r.RemoteAddr, action, resource, id, diff)
+}
+
+// auditAction records an action done by a user from the given address
+func auditAction(username, remoteAddr, action, resource, id string, diff func() (json.RawMessage, error)) {
+	if !audit.Enabled() {
+		return
+	}
+
+	entry := &audit.Entry{
+		User:       username,
+		SourceIP:   audit.SourceIP(remoteAddr),
+		Action:     action,
+		Resource:   resource,
+		ResourceID: id,
+	}
+
+	if diff != nil {
+		var err error
+		if entry.Diff, err = diff(); err != nil {
+			logging.GetLogger().Errorf("Unable to compute the audit diff of %s %s: %s", resource, id, err)
+		}
+	}
+
+	audit.Log(entry)
+}
+
+// auditObject returns the diff of an audit entry holding a whole object
+func auditObject(obj interface{}) func() (json.RawMessage, error) {
+	return func() (json.RawMessage, error) {
+		return json.Marshal(obj)
+	}
+}
+
+// auditResource returns the diff of an audit entry holding a whole resource,
+// decorated so that its secrets are not recorded
+func auditResource(handler Handler, resource types.Resource) func() (json.RawMessage, error) {
+	return func() (json.RawMessage, error) {
+		handler.Decorate(resource)
+		return json.Marshal(resource)
+	}
+}
+
+// auditUpdate returns the diff of an audit entry for a resource update
+func auditUpdate(handler Handler, before, after types.Resource) func() (json.RawMessage, error) {
+	return func() (json.RawMessage, error) {
+		handler.Decorate(before)
+		handler.Decorate(after)
+		return audit.Diff(before, after)
+	}
+}
+
+func parseAuditFilter(r *http.Request) (*audit.Filter, error) {
+	query := r.URL.Query()
+
+	filter := &audit.Filter{
+		User:       query.Get("user"),
+		SourceIP:   query.Get("sourceip"),
+		Action:     query.Get("action"),
+		Resource:   query.Get("resource"),
+		ResourceID: query.Get("id"),
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *int64
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if v := query.Get(param.name); v != "" {
+			i, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s parameter: %s", param.name, err)
+			}
+			*param.value = i
+		}
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid limit parameter: %s", err)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+func auditGet(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "audit", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseAuditFilter(&r.Request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	entries, err := audit.Query(filter)
+	if err == audit.ErrNotQueryable {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if entries == nil {
+		entries = []*audit.Entry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+// RegisterAuditAPI registers the audit query API endpoint
+func RegisterAuditAPI(s *shttp.Server, authBackend shttp.AuthenticationBackend) {
+	routes := []shttp.Route{
+		{
+			Name:        "AuditGet",
+			Method:      "GET",
+			Path:        "/api/audit",
+			HandlerFunc: auditGet,
+		},
+	}
+
+	query := func(name, description, typ string) *openAPIParameter {
+		schema := &openAPISchema{Type: typ}
+		if typ == "integer" {
+			schema.Format = "int64"
+		}
+		return &openAPIParameter{Name: name, In: "query", Description: description, Schema: schema}
+	}
+
+	operations := map[string]*openAPIOperation{
+		"AuditGet": {
+			OperationID: "getAudit",
+			Summary:     "Query the audit log",
+			Tags:        []string{"Audit"},
+			Parameters: []*openAPIParameter{
+				query("user", "User who did the action", "string"),
+				query("sourceip", "IP address the action was done from", "string"),
+				query("action", "Action, like create, update or delete", "string"),
+				query("resource", "Type of the resource", "string"),
+				query("id", "ID of the resource", "string"),
+				query("from", "Lower bound of the timestamp, in milliseconds", "integer"),
+				query("to", "Upper bound of the timestamp, in milliseconds", "integer"),
+				query("limit", "Maximum number of entries, the most recent first", "integer"),
+			},
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: "Audit entries", Content: jsonContent(&openAPISchema{Type: "array", Items: schemaRef(audit.Entry{})})},
+				"400": {Description: "Invalid query"},
+				"501": {Description: "No queryable audit backend"},
+			},
+		},
+	}
+
+	registerAPIRoutes(s, routes, operations, authBackend)
+}
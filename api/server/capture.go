@@ -102,10 +102,9 @@ func (c *CaptureAPIHandler) Decorate(resource types.Resource) {
 	capture.Count = count
 }
 
-// Create tests that resource GremlinQuery does not exists already
-func (c *CaptureAPIHandler) Create(r types.Resource, opts *CreateOptions) error {
-	capture := r.(*types.Capture)
-
+// checkCapture tests the capabilities of the capture type and that no other
+// capture, except the one with the excluded ID, uses the same GremlinQuery
+func (c *CaptureAPIHandler) checkCapture(capture *types.Capture, excludedID string) error {
 	// check capabilities
 	if capture.Type != "" {
 		if capture.BPFFilter != "" {
@@ -123,10 +122,24 @@ func (c *CaptureAPIHandler) Create(r types.Resource, opts *CreateOptions) error
 				return fmt.Errorf("%s capture doesn't support extra TCP metrics capture", capture.Type)
 			}
 		}
+		if capture.PacketRingSize != 0 || capture.PacketRingDuration != 0 {
+			if !common.CheckProbeCapabilities(capture.Type, common.RawPacketsCapability) {
+				return fmt.Errorf("%s capture doesn't support packet ring", capture.Type)
+			}
+		}
+	}
+
+	// the packet rings are fed by the local target of the agents
+	if (capture.PacketRingSize != 0 || capture.PacketRingDuration != 0) && capture.TargetType != "" && capture.TargetType != "local" {
+		return fmt.Errorf("%s target doesn't support packet ring", capture.TargetType)
 	}
 
 	resources := c.Index()
-	for _, resource := range resources {
+	for id, resource := range resources {
+		if id == excludedID {
+			continue
+		}
+
 		resource := resource.(*types.Capture)
 
 		sameGremlin := resource.GremlinQuery == capture.GremlinQuery
@@ -141,9 +154,27 @@ func (c *CaptureAPIHandler) Create(r types.Resource, opts *CreateOptions) error
 		}
 	}
 
+	return nil
+}
+
+// Create tests that resource GremlinQuery does not exists already
+func (c *CaptureAPIHandler) Create(r types.Resource, opts *CreateOptions) error {
+	if err := c.checkCapture(r.(*types.Capture), ""); err != nil {
+		return err
+	}
+
 	return c.BasicAPIHandler.Create(r, opts)
 }
 
+// Update tests that the updated capture doesn't conflict with an other one
+func (c *CaptureAPIHandler) Update(id string, r types.Resource, opts *UpdateOptions) (uint64, error) {
+	if err := c.checkCapture(r.(*types.Capture), id); err != nil {
+		return 0, err
+	}
+
+	return c.BasicAPIHandler.Update(id, r, opts)
+}
+
 // RegisterCaptureAPI registers an new resource, capture
 func RegisterCaptureAPI(apiServer *Server, g *graph.Graph, authBackend shttp.AuthenticationBackend) (*CaptureAPIHandler, error) {
 	captureAPIHandler := &CaptureAPIHandler{
@@ -0,0 +1,201 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+	"github.com/google/gopacket/layers"
+	"github.com/gorilla/mux"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+// CaptureDumpAPIHandler extracts the packets kept by the packet rings of
+// the captures as pcap
+type CaptureDumpAPIHandler struct {
+	apiServer   *Server
+	tableClient *flow.WSTableClient
+}
+
+// parseDumpTime parses a bound of the time range of a dump, either a time
+// in milliseconds, a RFC3339 time or a duration before now
+func parseDumpTime(value string, now time.Time) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return ms, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return common.UnixMillis(now.Add(-d)), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time: %s, expected milliseconds, RFC3339 time or duration", value)
+	}
+	return common.UnixMillis(t), nil
+}
+
+func parsePacketRingQuery(r *http.Request) (*flow.PacketRingQuery, error) {
+	query := r.URL.Query()
+	now := time.Now()
+
+	from, err := parseDumpTime(query.Get("from"), now)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := parseDumpTime(query.Get("to"), now)
+	if err != nil {
+		return nil, err
+	}
+
+	if to != 0 && from > to {
+		return nil, errors.New("Invalid time range, from is after to")
+	}
+
+	bpfFilter := query.Get("bpf")
+	if bpfFilter != "" {
+		if _, err := flow.BPFFilterToRaw(layers.LinkTypeEthernet, flow.MaxCaptureLength, bpfFilter); err != nil {
+			return nil, fmt.Errorf("Invalid BPF filter: %s", err)
+		}
+	}
+
+	return &flow.PacketRingQuery{
+		CaptureID: mux.Vars(r)["id"],
+		From:      from,
+		To:        to,
+		BPFFilter: bpfFilter,
+	}, nil
+}
+
+func (c *CaptureDumpAPIHandler) dump(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "capture", "rawpackets") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, err := parsePacketRingQuery(&r.Request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resource, found := c.apiServer.GetHandler("capture").Get(query.CaptureID)
+	if !found || !isResourceVisible(r.Username, resource) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	capture := resource.(*types.Capture)
+	if capture.PacketRingSize == 0 && capture.PacketRingDuration == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("No packet ring enabled for this capture"))
+		return
+	}
+
+	packets, err := c.tableClient.QueryPacketRings(*query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(packets) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("No packet found in the packet rings of the capture for this time range"))
+		return
+	}
+
+	auditRequest(r, "dump", "capture", query.CaptureID, auditObject(query))
+
+	var b bytes.Buffer
+	if err := flow.NewPcapWriter(&b).WriteRawPackets(packets); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(b.Bytes()); err != nil {
+		logging.GetLogger().Errorf("Error while writing response: %s", err)
+	}
+}
+
+func (c *CaptureDumpAPIHandler) registerEndpoints(s *shttp.Server, authBackend shttp.AuthenticationBackend) {
+	routes := []shttp.Route{
+		{
+			Name:        "CaptureDump",
+			Method:      "POST",
+			Path:        "/api/capture/{id}/dump",
+			HandlerFunc: c.dump,
+		},
+	}
+
+	query := func(name, description string) *openAPIParameter {
+		return &openAPIParameter{Name: name, In: "query", Description: description, Schema: &openAPISchema{Type: "string"}}
+	}
+
+	operations := map[string]*openAPIOperation{
+		"CaptureDump": {
+			OperationID: "dumpCapture",
+			Summary:     "Extract the packets kept by the packet rings of a capture",
+			Tags:        []string{"Captures"},
+			Parameters: []*openAPIParameter{
+				query("from", "Start of the time range, in milliseconds, RFC3339 or as a duration before now"),
+				query("to", "End of the time range, in milliseconds, RFC3339 or as a duration before now"),
+				query("bpf", "BPF filter"),
+			},
+			Responses: map[string]*openAPIResponse{
+				"200": {
+					Description: "Packets of the time range",
+					Content: map[string]*openAPIMediaType{
+						"application/vnd.tcpdump.pcap": {Schema: &openAPISchema{Type: "string", Format: "binary"}},
+					},
+				},
+				"400": {Description: "Invalid parameters or no packet ring enabled"},
+				"404": {Description: "Capture or packets not found"},
+			},
+		},
+	}
+
+	registerAPIRoutes(s, routes, operations, authBackend)
+}
+
+// RegisterCaptureDumpAPI registers the API extracting the packets kept by
+// the packet rings of the captures
+func RegisterCaptureDumpAPI(s *shttp.Server, apiServer *Server, tableClient *flow.WSTableClient, authBackend shttp.AuthenticationBackend) {
+	c := &CaptureDumpAPIHandler{
+		apiServer:   apiServer,
+		tableClient: tableClient,
+	}
+
+	c.registerEndpoints(s, authBackend)
+}
@@ -73,7 +73,18 @@ func (c *configAPI) registerEndpoints(r *shttp.Server, authBackend shttp.Authent
 		},
 	}
 
-	r.RegisterRoutes(routes, authBackend)
+	operations := map[string]*openAPIOperation{
+		"ConfigGet": {
+			OperationID: "getConfig",
+			Summary:     "Get configuration value",
+			Tags:        []string{"Config"},
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: "Configuration value", Content: jsonContent(&openAPISchema{})},
+			},
+		},
+	}
+
+	registerAPIRoutes(r, routes, operations, authBackend)
 }
 
 // RegisterConfigAPI registers a configuration endpoint (read only) in API server
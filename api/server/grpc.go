@@ -0,0 +1,589 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/skydive-project/skydive/api/rpc"
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/storage"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+	"github.com/skydive-project/skydive/validator"
+)
+
+const flowStreamQueueSize = 100
+
+var errPermissionDenied = status.Error(codes.PermissionDenied, "Permission denied")
+
+type callerKey struct{}
+
+// caller describes the authenticated client of a call
+type caller struct {
+	username   string
+	remoteAddr string
+}
+
+// flowStream describes a client of the flow stream
+type flowStream struct {
+	username  string
+	captureID string
+	flows     chan []*flow.Flow
+}
+
+// GRPCServer exposes the Gremlin queries, the topology events, the flows
+// and the captures through gRPC. The clients are authenticated by the
+// authentication backend of the REST API, using the credentials passed
+// in the authorization metadata of the calls or the client certificate.
+type GRPCServer struct {
+	common.RWMutex
+	addr        string
+	server      *grpc.Server
+	topology    *TopologyAPI
+	captures    *CaptureAPIHandler
+	tableClient flow.TableClient
+	storage     storage.Storage
+	authBackend shttp.AuthenticationBackend
+	flowStreams map[*flowStream]bool
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// credentialsFromContext returns the credentials of the client of a call,
+// passed in the authorization metadata as with the HTTP authorization
+// header, along with the state of its TLS connection
+func credentialsFromContext(ctx context.Context) *shttp.Credentials {
+	creds := &shttp.Credentials{}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			s := strings.SplitN(values[0], " ", 2)
+			if len(s) == 2 {
+				switch s[0] {
+				case "Basic":
+					if b, err := base64.StdEncoding.DecodeString(s[1]); err == nil {
+						if pair := strings.SplitN(string(b), ":", 2); len(pair) == 2 {
+							creds.Username, creds.Password = pair[0], pair[1]
+						}
+					}
+				case "Bearer":
+					creds.Token = s[1]
+				}
+			}
+		}
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			creds.TLS = &tlsInfo.State
+		}
+	}
+
+	return creds
+}
+
+// authenticate authenticates the client of a call through the authentication
+// backend and returns a context holding the caller
+func (s *GRPCServer) authenticate(ctx context.Context) (context.Context, error) {
+	username, err := s.authBackend.AuthenticateCredentials(credentialsFromContext(ctx))
+	if err != nil || username == "" {
+		logging.GetLogger().Debugf("gRPC authentication error: %v", err)
+		return nil, status.Error(codes.Unauthenticated, "Authentication failed")
+	}
+
+	c := &caller{username: username}
+	if p, ok := peer.FromContext(ctx); ok {
+		c.remoteAddr = p.Addr.String()
+	}
+
+	return context.WithValue(ctx, callerKey{}, c), nil
+}
+
+func (s *GRPCServer) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *GRPCServer) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// callerFromContext returns the authenticated client of a call
+func callerFromContext(ctx context.Context) *caller {
+	return ctx.Value(callerKey{}).(*caller)
+}
+
+// Gremlin executes a Gremlin query and returns its result encoded in JSON
+func (s *GRPCServer) Gremlin(ctx context.Context, req *rpc.GremlinRequest) (*rpc.GremlinReply, error) {
+	c := callerFromContext(ctx)
+	if !rbac.Enforce(c.username, "topology", "read") {
+		return nil, errPermissionDenied
+	}
+
+	if req.GremlinQuery == "" {
+		return nil, status.Error(codes.InvalidArgument, "Empty Gremlin query")
+	}
+
+	// as for the REST API, users belonging to a tenant query the part of
+	// the graph their tenant has access to
+	g, err := userGraph(s.topology.graph, s.topology.gremlinParser, c.username, true)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	ts, err := s.topology.gremlinParser.Parse(strings.NewReader(req.GremlinQuery))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	res, err := ts.Exec(g, true)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Error while encoding response: %s", err)
+	}
+
+	return &rpc.GremlinReply{Result: data}, nil
+}
+
+// WatchTopology streams the topology events, starting with the current
+// state of the graph unless the stream is resumed
+func (s *GRPCServer) WatchTopology(req *rpc.WatchTopologyRequest, stream rpc.Skydive_WatchTopologyServer) error {
+	c := callerFromContext(stream.Context())
+	if !rbac.Enforce(c.username, "topology", "read") {
+		return errPermissionDenied
+	}
+
+	events := s.topology.events
+	client, err := events.subscribe(req.GremlinFilter, rbac.GetUserFilter(c.username), req.LastEventID)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer events.unsubscribe(client)
+
+	logging.GetLogger().Debugf("gRPC client %s subscribed to topology events with filter '%s'", c.remoteAddr, client.gremlinFilter)
+
+	for {
+		select {
+		case ev := <-client.events:
+			if err := stream.Send(&rpc.TopologyEvent{ID: ev.id, Type: ev.typ, Element: ev.data}); err != nil {
+				return err
+			}
+		case <-client.overflow:
+			logging.GetLogger().Warningf("gRPC client %s too slow, closing its topology event stream", c.remoteAddr)
+			return status.Error(codes.ResourceExhausted, "Client too slow, the stream has to be resumed from the last received event")
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// captureAccess returns a function telling whether a user has access to
+// the flows of a capture, the result being cached for each capture
+func (s *GRPCServer) captureAccess(username string) func(captureID string) bool {
+	tenant := userTenant(username)
+	if tenant == "" {
+		return func(string) bool { return true }
+	}
+
+	access := make(map[string]bool)
+	return func(captureID string) bool {
+		granted, found := access[captureID]
+		if !found {
+			resource, ok := s.captures.Get(captureID)
+			granted = ok && resource.(*types.Capture).Tenant == tenant
+			access[captureID] = granted
+		}
+		return granted
+	}
+}
+
+// SearchFlows returns the flows matching a query, looked up in the flow
+// storage if configured, in the flow tables of the agents otherwise
+func (s *GRPCServer) SearchFlows(ctx context.Context, req *rpc.SearchFlowsRequest) (*flow.FlowSearchReply, error) {
+	c := callerFromContext(ctx)
+	if !rbac.Enforce(c.username, "topology", "read") {
+		return nil, errPermissionDenied
+	}
+
+	var query filters.SearchQuery
+	if req.Query != nil {
+		query = *req.Query
+	}
+
+	var (
+		fs  *flow.FlowSet
+		err error
+	)
+	if s.storage != nil {
+		fs, err = s.storage.SearchFlows(query)
+	} else {
+		fs, err = s.tableClient.LookupFlows(query)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if fs == nil {
+		fs = &flow.FlowSet{}
+	}
+
+	allowed := s.captureAccess(c.username)
+	flows := fs.Flows[:0]
+	for _, f := range fs.Flows {
+		if allowed(f.CaptureID) {
+			flows = append(flows, f)
+		}
+	}
+	fs.Flows = flows
+
+	return &flow.FlowSearchReply{FlowSet: fs}, nil
+}
+
+// OnFlows dispatches the flows received by the analyzer to the flow streams.
+// Implements the FlowListener interface of the flow subscriber endpoint.
+func (s *GRPCServer) OnFlows(flows []*flow.Flow) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for fst := range s.flowStreams {
+		allowed := s.captureAccess(fst.username)
+
+		var selected []*flow.Flow
+		for _, f := range flows {
+			if (fst.captureID == "" || f.CaptureID == fst.captureID) && allowed(f.CaptureID) {
+				selected = append(selected, f)
+			}
+		}
+
+		if len(selected) == 0 {
+			continue
+		}
+
+		select {
+		case fst.flows <- selected:
+		default:
+			// flows are updated periodically, the next update will
+			// be received by the client
+			logging.GetLogger().Debugf("gRPC flow stream too slow, dropping %d flows", len(selected))
+		}
+	}
+}
+
+// StreamFlows streams the flows received by the analyzer
+func (s *GRPCServer) StreamFlows(req *rpc.StreamFlowsRequest, stream rpc.Skydive_StreamFlowsServer) error {
+	c := callerFromContext(stream.Context())
+	if !rbac.Enforce(c.username, "topology", "read") {
+		return errPermissionDenied
+	}
+
+	fst := &flowStream{
+		username:  c.username,
+		captureID: req.CaptureID,
+		flows:     make(chan []*flow.Flow, flowStreamQueueSize),
+	}
+
+	s.Lock()
+	s.flowStreams[fst] = true
+	s.Unlock()
+
+	defer func() {
+		s.Lock()
+		delete(s.flowStreams, fst)
+		s.Unlock()
+	}()
+
+	for {
+		select {
+		case flows := <-fst.flows:
+			if err := stream.Send(&flow.FlowSet{Flows: flows}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func captureToRPC(capture *types.Capture) *rpc.Capture {
+	return &rpc.Capture{
+		UUID:            capture.UUID,
+		GremlinQuery:    capture.GremlinQuery,
+		BPFFilter:       capture.BPFFilter,
+		Name:            capture.Name,
+		Description:     capture.Description,
+		Type:            capture.Type,
+		Count:           int64(capture.Count),
+		Port:            int64(capture.Port),
+		SamplingRate:    capture.SamplingRate,
+		PollingInterval: capture.PollingInterval,
+		RawPacketLimit:  int64(capture.RawPacketLimit),
+		HeaderSize:      int64(capture.HeaderSize),
+		ExtraTCPMetric:  capture.ExtraTCPMetric,
+		IPDefrag:        capture.IPDefrag,
+		ReassembleTCP:   capture.ReassembleTCP,
+		LayerKeyMode:    capture.LayerKeyMode,
+		ExtraLayers:     capture.ExtraLayers.Extract(),
+		Target:          capture.Target,
+		TargetType:      capture.TargetType,
+		Tenant:          capture.Tenant,
+	}
+}
+
+// captureFromRPC fills a capture resource, the fields not set keeping
+// their default values
+func captureFromRPC(c *rpc.Capture, capture *types.Capture) error {
+	capture.GremlinQuery = c.GremlinQuery
+	capture.BPFFilter = c.BPFFilter
+	capture.Name = c.Name
+	capture.Description = c.Description
+	capture.Type = c.Type
+	capture.Port = int(c.Port)
+	capture.SamplingRate = c.SamplingRate
+	capture.PollingInterval = c.PollingInterval
+	capture.RawPacketLimit = int(c.RawPacketLimit)
+	capture.HeaderSize = int(c.HeaderSize)
+	capture.ExtraTCPMetric = c.ExtraTCPMetric
+	capture.IPDefrag = c.IPDefrag
+	capture.ReassembleTCP = c.ReassembleTCP
+	capture.Target = c.Target
+	capture.TargetType = c.TargetType
+	capture.Tenant = c.Tenant
+
+	if c.LayerKeyMode != "" {
+		capture.LayerKeyMode = c.LayerKeyMode
+	}
+
+	return capture.ExtraLayers.Parse(c.ExtraLayers...)
+}
+
+// ListCaptures returns the captures the user has access to
+func (s *GRPCServer) ListCaptures(ctx context.Context, req *rpc.ListCapturesRequest) (*rpc.ListCapturesReply, error) {
+	c := callerFromContext(ctx)
+	if !rbac.Enforce(c.username, "capture", "read") {
+		return nil, errPermissionDenied
+	}
+
+	reply := &rpc.ListCapturesReply{}
+	for _, resource := range s.captures.Index() {
+		if !isResourceVisible(c.username, resource) {
+			continue
+		}
+		s.captures.Decorate(resource)
+		reply.Captures = append(reply.Captures, captureToRPC(resource.(*types.Capture)))
+	}
+
+	return reply, nil
+}
+
+// GetCapture returns a capture
+func (s *GRPCServer) GetCapture(ctx context.Context, req *rpc.CaptureRequest) (*rpc.Capture, error) {
+	c := callerFromContext(ctx)
+	if !rbac.Enforce(c.username, "capture", "read") {
+		return nil, errPermissionDenied
+	}
+
+	resource, ok := s.captures.Get(req.UUID)
+	if !ok || !isResourceVisible(c.username, resource) {
+		return nil, status.Errorf(codes.NotFound, "Capture %s not found", req.UUID)
+	}
+
+	s.captures.Decorate(resource)
+	return captureToRPC(resource.(*types.Capture)), nil
+}
+
+// CreateCapture creates a capture, the capture identifier being generated
+func (s *GRPCServer) CreateCapture(ctx context.Context, req *rpc.Capture) (*rpc.Capture, error) {
+	c := callerFromContext(ctx)
+	if !rbac.Enforce(c.username, "capture", "write") {
+		return nil, errPermissionDenied
+	}
+
+	resource := s.captures.New()
+	if err := captureFromRPC(req, resource.(*types.Capture)); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	setResourceTenant(c.username, resource)
+
+	if err := validator.Validate(resource); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := s.captures.Create(resource, nil); err == ErrDuplicatedResource {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	auditAction(c.username, c.remoteAddr, "create", "capture", resource.ID(), auditResource(s.captures, resource))
+
+	return captureToRPC(resource.(*types.Capture)), nil
+}
+
+// UpdateCapture replaces a capture, the fields not set getting their
+// default values
+func (s *GRPCServer) UpdateCapture(ctx context.Context, req *rpc.Capture) (*rpc.Capture, error) {
+	c := callerFromContext(ctx)
+	if !rbac.Enforce(c.username, "capture", "write") {
+		return nil, errPermissionDenied
+	}
+
+	current, ok := s.captures.Get(req.UUID)
+	if !ok || !isResourceVisible(c.username, current) {
+		return nil, status.Errorf(codes.NotFound, "Capture %s not found", req.UUID)
+	}
+
+	resource := s.captures.New()
+	if err := captureFromRPC(req, resource.(*types.Capture)); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	resource.SetID(req.UUID)
+	setResourceTenant(c.username, resource)
+
+	if err := validator.Validate(resource); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := s.captures.Update(req.UUID, resource, nil); err != nil {
+		switch {
+		case isKeyNotFound(err):
+			return nil, status.Errorf(codes.NotFound, "Capture %s not found", req.UUID)
+		case err == ErrDuplicatedResource:
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		case err == ErrRevisionMismatch:
+			return nil, status.Error(codes.Aborted, err.Error())
+		default:
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	auditAction(c.username, c.remoteAddr, "update", "capture", req.UUID, auditUpdate(s.captures, current, resource))
+
+	return captureToRPC(resource.(*types.Capture)), nil
+}
+
+// DeleteCapture deletes a capture
+func (s *GRPCServer) DeleteCapture(ctx context.Context, req *rpc.CaptureRequest) (*rpc.Empty, error) {
+	c := callerFromContext(ctx)
+	if !rbac.Enforce(c.username, "capture", "write") {
+		return nil, errPermissionDenied
+	}
+
+	resource, ok := s.captures.Get(req.UUID)
+	if ok && !isResourceVisible(c.username, resource) {
+		return nil, status.Errorf(codes.NotFound, "Capture %s not found", req.UUID)
+	}
+
+	if err := s.captures.Delete(req.UUID); err != nil {
+		if isKeyNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "Capture %s not found", req.UUID)
+		}
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if ok {
+		auditAction(c.username, c.remoteAddr, "delete", "capture", req.UUID, auditResource(s.captures, resource))
+	} else {
+		auditAction(c.username, c.remoteAddr, "delete", "capture", req.UUID, nil)
+	}
+
+	return &rpc.Empty{}, nil
+}
+
+// Start listens and serves the gRPC requests
+func (s *GRPCServer) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil {
+			logging.GetLogger().Errorf("gRPC server error: %s", err)
+		}
+	}()
+
+	logging.GetLogger().Infof("gRPC API listening on %s", s.addr)
+
+	return nil
+}
+
+// Stop closes the listener and the pending calls, including the streams
+func (s *GRPCServer) Stop() {
+	s.server.Stop()
+}
+
+// NewGRPCServer returns a gRPC server listening on the given address. The
+// connections are encrypted when a TLS configuration is given.
+func NewGRPCServer(addr string, tlsConfig *tls.Config, topology *TopologyAPI, captures *CaptureAPIHandler, tableClient flow.TableClient, store storage.Storage, authBackend shttp.AuthenticationBackend) *GRPCServer {
+	s := &GRPCServer{
+		addr:        addr,
+		topology:    topology,
+		captures:    captures,
+		tableClient: tableClient,
+		storage:     store,
+		authBackend: authBackend,
+		flowStreams: make(map[*flowStream]bool),
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(s.unaryInterceptor),
+		grpc.StreamInterceptor(s.streamInterceptor),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	s.server = grpc.NewServer(opts...)
+	rpc.RegisterSkydiveServer(s.server, s)
+
+	return s
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/skydive-project/skydive/api/rpc"
+	"github.com/skydive-project/skydive/graffiti/graph/traversal"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+// newTestGRPCClient serves a gRPC server on a local port and returns a
+// client connected to it along with a function stopping both
+func newTestGRPCClient(t *testing.T, authBackend shttp.AuthenticationBackend) (rpc.SkydiveClient, func()) {
+	g := newPcapTestGraph(t)
+	parser := traversal.NewGremlinTraversalParser()
+
+	topology := &TopologyAPI{
+		gremlinParser: parser,
+		graph:         g,
+		events:        NewTopologyEventsAPI(g, parser, 10),
+	}
+
+	captures := &CaptureAPIHandler{
+		BasicAPIHandler: BasicAPIHandler{
+			ResourceHandler: &CaptureResourceHandler{},
+			EtcdKeyAPI:      newFakeKeysAPI(),
+		},
+		Graph: g,
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewGRPCServer(listener.Addr().String(), nil, topology, captures, nil, &fakeFlowStorage{}, authBackend)
+	go s.server.Serve(listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		s.Stop()
+		t.Fatal(err)
+	}
+
+	return rpc.NewSkydiveClient(conn), func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func newTestBasicBackend(t *testing.T) shttp.AuthenticationBackend {
+	provider := shttp.NewHtpasswdMapProvider(map[string]string{"admin": "pass", "alice": "pass", "bob": "pass"})
+
+	backend, err := shttp.NewBasicAuthenticationBackend("basic", provider.SecretProvider(), shttp.DefaultUserRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	backend := newTestBasicBackend(t)
+
+	client, stop := newTestGRPCClient(t, backend)
+	defer stop()
+
+	token, err := backend.Authenticate("admin", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name  string
+		creds credentials.PerRPCCredentials
+		code  codes.Code
+	}{
+		{"A call without credentials", nil, codes.Unauthenticated},
+		{"A call with a wrong password", rpc.NewBasicCredentials("admin", "wrong", true), codes.Unauthenticated},
+		{"A call with an unknown user", rpc.NewBasicCredentials("eve", "pass", true), codes.Unauthenticated},
+		{"A call with a wrong token", rpc.NewTokenCredentials("wrong", true), codes.Unauthenticated},
+		{"A call with a valid password", rpc.NewBasicCredentials("admin", "pass", true), codes.OK},
+		{"A call with a valid token", rpc.NewTokenCredentials(token, true), codes.OK},
+	} {
+		var opts []grpc.CallOption
+		if test.creds != nil {
+			opts = append(opts, grpc.PerRPCCredentials(test.creds))
+		}
+
+		_, err := client.Gremlin(context.Background(), &rpc.GremlinRequest{GremlinQuery: "G.V()"}, opts...)
+		if code := status.Code(err); code != test.code {
+			t.Errorf("%s should return %s, got %s (%v)", test.name, test.code, code, err)
+		}
+	}
+}
+
+func TestGRPCGremlinTenant(t *testing.T) {
+	initPcapTestTenants(t)
+	defer rbac.InitTenants(nil, nil)
+
+	client, stop := newTestGRPCClient(t, newTestBasicBackend(t))
+	defer stop()
+
+	count := func(username string) int {
+		creds := grpc.PerRPCCredentials(rpc.NewBasicCredentials(username, "pass", true))
+		reply, err := client.Gremlin(context.Background(), &rpc.GremlinRequest{GremlinQuery: "G.V()"}, creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var nodes []interface{}
+		if err := json.Unmarshal(reply.Result, &nodes); err != nil {
+			t.Fatal(err)
+		}
+		return len(nodes)
+	}
+
+	if n := count("admin"); n != 2 {
+		t.Errorf("A user not belonging to a tenant should see the whole graph, got %d nodes", n)
+	}
+
+	if n := count("alice"); n != 1 {
+		t.Errorf("A user should only see the nodes of its tenant, got %d nodes", n)
+	}
+}
+
+func TestGRPCCaptures(t *testing.T) {
+	client, stop := newTestGRPCClient(t, shttp.NewNoAuthenticationBackend())
+	defer stop()
+
+	ctx := context.Background()
+
+	created, err := client.CreateCapture(ctx, &rpc.Capture{GremlinQuery: "G.V().Has('TID', 'tid-a')", Name: "capture1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.UUID == "" {
+		t.Fatal("The identifier of the capture should be generated")
+	}
+
+	if _, err := client.CreateCapture(ctx, &rpc.Capture{GremlinQuery: created.GremlinQuery}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("A capture using the same Gremlin query should be rejected, got %v", err)
+	}
+
+	updated, err := client.UpdateCapture(ctx, &rpc.Capture{UUID: created.UUID, GremlinQuery: created.GremlinQuery, Name: "capture2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.UUID != created.UUID || updated.Name != "capture2" {
+		t.Errorf("The capture should have been updated, got %+v", updated)
+	}
+
+	capture, err := client.GetCapture(ctx, &rpc.CaptureRequest{UUID: created.UUID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capture.Name != "capture2" {
+		t.Errorf("The updated capture should be returned, got %+v", capture)
+	}
+
+	if _, err := client.UpdateCapture(ctx, &rpc.Capture{UUID: "unknown", GremlinQuery: "G.V()"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Updating an unknown capture should return %s, got %v", codes.NotFound, err)
+	}
+
+	if _, err := client.UpdateCapture(ctx, &rpc.Capture{UUID: created.UUID, GremlinQuery: "G.V(", Name: "capture3"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("An invalid capture should be rejected, got %v", err)
+	}
+
+	list, err := client.ListCaptures(ctx, &rpc.ListCapturesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Captures) != 1 || list.Captures[0].Name != "capture2" {
+		t.Errorf("The updated capture should be listed, got %+v", list.Captures)
+	}
+
+	if _, err := client.DeleteCapture(ctx, &rpc.CaptureRequest{UUID: created.UUID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.GetCapture(ctx, &rpc.CaptureRequest{UUID: created.UUID}); status.Code(err) != codes.NotFound {
+		t.Errorf("A deleted capture should not be found, got %v", err)
+	}
+}
+
+func TestGRPCCaptureTenant(t *testing.T) {
+	initPcapTestTenants(t)
+	defer rbac.InitTenants(nil, nil)
+
+	client, stop := newTestGRPCClient(t, newTestBasicBackend(t))
+	defer stop()
+
+	alice := grpc.PerRPCCredentials(rpc.NewBasicCredentials("alice", "pass", true))
+	bob := grpc.PerRPCCredentials(rpc.NewBasicCredentials("bob", "pass", true))
+	ctx := context.Background()
+
+	created, err := client.CreateCapture(ctx, &rpc.Capture{GremlinQuery: "G.V().Has('TID', 'tid-a')", Tenant: "b"}, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Tenant != "a" {
+		t.Errorf("A capture should belong to the tenant of its creator, got '%s'", created.Tenant)
+	}
+
+	if _, err := client.GetCapture(ctx, &rpc.CaptureRequest{UUID: created.UUID}, bob); status.Code(err) != codes.NotFound {
+		t.Errorf("The capture of another tenant should not be found, got %v", err)
+	}
+
+	if _, err := client.UpdateCapture(ctx, &rpc.Capture{UUID: created.UUID, GremlinQuery: created.GremlinQuery}, bob); status.Code(err) != codes.NotFound {
+		t.Errorf("The capture of another tenant should not be updatable, got %v", err)
+	}
+
+	updated, err := client.UpdateCapture(ctx, &rpc.Capture{UUID: created.UUID, GremlinQuery: created.GremlinQuery, Name: "capture1", Tenant: "b"}, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Tenant != "a" {
+		t.Errorf("An updated capture should stay in the tenant of its user, got '%s'", updated.Tenant)
+	}
+
+	list, err := client.ListCaptures(ctx, &rpc.ListCapturesRequest{}, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Captures) != 0 {
+		t.Errorf("The captures of another tenant should not be listed, got %+v", list.Captures)
+	}
+}
@@ -20,6 +20,7 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -39,23 +40,43 @@ type Handler interface {
 	New() types.Resource
 	Index() map[string]types.Resource
 	Get(id string) (types.Resource, bool)
+	GetWithRevision(id string) (types.Resource, uint64, error)
 	Decorate(resource types.Resource)
 	Create(resource types.Resource, createOpts *CreateOptions) error
+	Update(id string, resource types.Resource, updateOpts *UpdateOptions) (uint64, error)
 	Delete(id string) error
 	AsyncWatch(f WatcherCallback) StoppableWatcher
 }
 
+// ErrRevisionMismatch is returned when a resource was modified since the revision
+// specified to an update
+var ErrRevisionMismatch = errors.New("Resource revision mismatch")
+
 // CreateOptions describes the available options when creating a resource
 type CreateOptions struct {
 	TTL time.Duration
 }
 
+// UpdateOptions describes the available options when updating a resource
+type UpdateOptions struct {
+	// Revision expected for the resource, 0 means any revision
+	Revision uint64
+	// TTL of the resource, 0 means that the current TTL is kept
+	TTL time.Duration
+}
+
 // ResourceHandler aims to creates new resource of an API
 type ResourceHandler interface {
 	Name() string
 	New() types.Resource
 }
 
+// CreateAuthorizer is implemented by the handlers restricting the resources
+// a user can create, in addition to the RBAC policy
+type CreateAuthorizer interface {
+	AuthorizeCreate(username string, resource types.Resource) error
+}
+
 // BasicAPIHandler basic implementation of an Handler, should be used as embedded struct
 // for the most part of the resource
 type BasicAPIHandler struct {
@@ -156,6 +177,24 @@ func (h *BasicAPIHandler) Get(id string) (types.Resource, bool) {
 	return resource, err == nil
 }
 
+// GetWithRevision returns a specific resource along with its revision, the
+// Etcd index of its last modification
+func (h *BasicAPIHandler) GetWithRevision(id string) (types.Resource, uint64, error) {
+	etcdPath := fmt.Sprintf("/%s/%s", h.ResourceHandler.Name(), id)
+
+	resp, err := h.EtcdKeyAPI.Get(context.Background(), etcdPath, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resource, err := h.Unmarshal([]byte(resp.Node.Value))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return resource, resp.Node.ModifiedIndex, nil
+}
+
 // Create a new resource in Etcd
 func (h *BasicAPIHandler) Create(resource types.Resource, createOpts *CreateOptions) error {
 	id, _ := uuid.NewV4()
@@ -184,16 +223,52 @@ func (h *BasicAPIHandler) Delete(id string) error {
 	return err
 }
 
-// Update a resource
-func (h *BasicAPIHandler) Update(id string, resource types.Resource) error {
+// Update a resource and returns its new revision. The update is done using
+// a compare and swap so that concurrent modifications are detected.
+func (h *BasicAPIHandler) Update(id string, resource types.Resource, updateOpts *UpdateOptions) (uint64, error) {
+	if updateOpts == nil {
+		updateOpts = &UpdateOptions{}
+	}
+
+	etcdPath := fmt.Sprintf("/%s/%s", h.ResourceHandler.Name(), id)
+
+	resp, err := h.EtcdKeyAPI.Get(context.Background(), etcdPath, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	prevIndex := resp.Node.ModifiedIndex
+	if updateOpts.Revision != 0 {
+		if updateOpts.Revision != prevIndex {
+			return 0, ErrRevisionMismatch
+		}
+		prevIndex = updateOpts.Revision
+	}
+
+	resource.SetID(id)
+
 	data, err := json.Marshal(&resource)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	etcdPath := fmt.Sprintf("/%s/%s", h.ResourceHandler.Name(), id)
-	_, err = h.EtcdKeyAPI.Update(context.Background(), etcdPath, string(data))
-	return err
+	// setting a key without TTL removes its expiration, keep the current one
+	setOptions := &etcd.SetOptions{PrevIndex: prevIndex, TTL: updateOpts.TTL}
+	if setOptions.TTL == 0 && resp.Node.Expiration != nil {
+		if setOptions.TTL = time.Until(*resp.Node.Expiration); setOptions.TTL <= 0 {
+			return 0, etcd.Error{Code: etcd.ErrorCodeKeyNotFound, Message: "Key not found", Cause: etcdPath}
+		}
+	}
+
+	resp, err = h.EtcdKeyAPI.Set(context.Background(), etcdPath, string(data), setOptions)
+	if err != nil {
+		if err, ok := err.(etcd.Error); ok && err.Code == etcd.ErrorCodeTestFailed {
+			return 0, ErrRevisionMismatch
+		}
+		return 0, err
+	}
+
+	return resp.Node.ModifiedIndex, nil
 }
 
 // AsyncWatch registers a new resource watcher
@@ -237,14 +312,19 @@ func (h *BasicAPIHandler) AsyncWatch(f WatcherCallback) StoppableWatcher {
 
 			resource := h.ResourceHandler.New()
 
-			switch resp.Action {
+			action := resp.Action
+			switch action {
 			case "expire", "delete":
 				json.Unmarshal([]byte(resp.PrevNode.Value), resource)
+			case "compareAndSwap":
+				// resources are updated using compare and swap
+				action = "update"
+				fallthrough
 			default:
 				json.Unmarshal([]byte(resp.Node.Value), resource)
 			}
 
-			f(resp.Action, id, resource)
+			f(action, id, resource)
 		}
 	}()
 
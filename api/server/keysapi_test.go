@@ -0,0 +1,134 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	etcd "github.com/coreos/etcd/client"
+)
+
+// fakeKeysAPI is an in memory implementation of the Etcd keys API
+type fakeKeysAPI struct {
+	sync.Mutex
+	index uint64
+	nodes map[string]*etcd.Node
+}
+
+type fakeWatcher struct{}
+
+func (w *fakeWatcher) Next(ctx context.Context) (*etcd.Response, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func keyNotFound(key string) error {
+	return etcd.Error{Code: etcd.ErrorCodeKeyNotFound, Message: "Key not found", Cause: key}
+}
+
+func (k *fakeKeysAPI) Get(ctx context.Context, key string, opts *etcd.GetOptions) (*etcd.Response, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	if node, found := k.nodes[key]; found {
+		n := *node
+		return &etcd.Response{Action: "get", Node: &n, Index: k.index}, nil
+	}
+
+	dir := strings.TrimSuffix(key, "/") + "/"
+
+	var nodes etcd.Nodes
+	for path, node := range k.nodes {
+		if strings.HasPrefix(path, dir) {
+			n := *node
+			nodes = append(nodes, &n)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, keyNotFound(key)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Key < nodes[j].Key })
+
+	return &etcd.Response{Action: "get", Node: &etcd.Node{Key: key, Dir: true, Nodes: nodes}, Index: k.index}, nil
+}
+
+func (k *fakeKeysAPI) Set(ctx context.Context, key, value string, opts *etcd.SetOptions) (*etcd.Response, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	prev := k.nodes[key]
+	if opts != nil && opts.PrevIndex != 0 {
+		if prev == nil {
+			return nil, keyNotFound(key)
+		}
+		if prev.ModifiedIndex != opts.PrevIndex {
+			return nil, etcd.Error{Code: etcd.ErrorCodeTestFailed, Message: "Compare failed", Cause: key}
+		}
+	}
+
+	k.index++
+	node := &etcd.Node{Key: key, Value: value, CreatedIndex: k.index, ModifiedIndex: k.index}
+	if prev != nil {
+		node.CreatedIndex = prev.CreatedIndex
+	}
+	k.nodes[key] = node
+
+	n := *node
+	return &etcd.Response{Action: "set", Node: &n, PrevNode: prev, Index: k.index}, nil
+}
+
+func (k *fakeKeysAPI) Delete(ctx context.Context, key string, opts *etcd.DeleteOptions) (*etcd.Response, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	prev, found := k.nodes[key]
+	if !found {
+		return nil, keyNotFound(key)
+	}
+	delete(k.nodes, key)
+	k.index++
+
+	return &etcd.Response{Action: "delete", PrevNode: prev, Index: k.index}, nil
+}
+
+func (k *fakeKeysAPI) Create(ctx context.Context, key, value string) (*etcd.Response, error) {
+	return k.Set(ctx, key, value, nil)
+}
+
+func (k *fakeKeysAPI) CreateInOrder(ctx context.Context, dir, value string, opts *etcd.CreateInOrderOptions) (*etcd.Response, error) {
+	k.Lock()
+	key := strings.TrimSuffix(dir, "/") + "/" + strings.Repeat("0", 10)
+	k.Unlock()
+
+	return k.Set(ctx, key, value, nil)
+}
+
+func (k *fakeKeysAPI) Update(ctx context.Context, key, value string) (*etcd.Response, error) {
+	return k.Set(ctx, key, value, nil)
+}
+
+func (k *fakeKeysAPI) Watcher(key string, opts *etcd.WatcherOptions) etcd.Watcher {
+	return &fakeWatcher{}
+}
+
+func newFakeKeysAPI() *fakeKeysAPI {
+	return &fakeKeysAPI{nodes: make(map[string]*etcd.Node)}
+}
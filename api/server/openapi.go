@@ -0,0 +1,783 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+	"github.com/gorilla/mux"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/version"
+)
+
+const openAPIVersion = "3.0.2"
+
+// openAPISchema describes the subset of the OpenAPI 3 schema object
+// used to describe the API types
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Enum                 []interface{}             `json:"enum,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema,omitempty"`
+}
+
+type openAPIHeader struct {
+	Description string         `json:"description,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Headers     map[string]*openAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+	License struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"license"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema         `json:"schemas"`
+	SecuritySchemes map[string]map[string]interface{} `json:"securitySchemes,omitempty"`
+}
+
+// openAPISpec describes an OpenAPI 3 document
+type openAPISpec struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Servers    []openAPIServer                         `json:"servers"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+	Security   []map[string][]string                   `json:"security,omitempty"`
+}
+
+// openAPISchemas generates the schemas of Go types. Named structures are
+// described as components and referenced by the schemas using them.
+type openAPISchemas struct {
+	sync.RWMutex
+	components map[string]*openAPISchema
+	types      map[reflect.Type]string
+}
+
+// openAPIBuiltinSchemas describes the types that have a custom JSON encoding
+var openAPIBuiltinSchemas = map[reflect.Type]*openAPISchema{
+	reflect.TypeOf(time.Time{}):          {Type: "string", Format: "date-time"},
+	reflect.TypeOf(graph.Time{}):         {Type: "integer", Format: "int64", Nullable: true},
+	reflect.TypeOf(graph.Metadata{}):     {Type: "object", AdditionalProperties: &openAPISchema{}, Nullable: true},
+	reflect.TypeOf(flow.ExtraLayers(0)):  {Type: "array", Items: &openAPISchema{Type: "string", Enum: []interface{}{"DNS", "DHCPv4", "VRRP"}}, Nullable: true},
+	reflect.TypeOf(json.RawMessage(nil)): {},
+}
+
+func newOpenAPISchemas() *openAPISchemas {
+	return &openAPISchemas{
+		components: make(map[string]*openAPISchema),
+		types:      make(map[reflect.Type]string),
+	}
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+func integerSchema(format string, min, max float64) *openAPISchema {
+	return &openAPISchema{Type: "integer", Format: format, Minimum: float64Ptr(min), Maximum: float64Ptr(max)}
+}
+
+// schemaOf returns the schema of a Go type
+func (s *openAPISchemas) schemaOf(t reflect.Type) *openAPISchema {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.schemaOfType(t)
+}
+
+func (s *openAPISchemas) schemaOfType(t reflect.Type) *openAPISchema {
+	if schema, found := openAPIBuiltinSchemas[t]; found {
+		c := *schema
+		return &c
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := s.schemaOfType(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int8:
+		return integerSchema("int32", math.MinInt8, math.MaxInt8)
+	case reflect.Int16:
+		return integerSchema("int32", math.MinInt16, math.MaxInt16)
+	case reflect.Int32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Uint8:
+		return integerSchema("int32", 0, math.MaxUint8)
+	case reflect.Uint16:
+		return integerSchema("int32", 0, math.MaxUint16)
+	case reflect.Uint32:
+		return integerSchema("int64", 0, math.MaxUint32)
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return &openAPISchema{Type: "integer", Format: "int64", Minimum: float64Ptr(0)}
+	case reflect.Float32:
+		return &openAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &openAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &openAPISchema{Type: "string", Format: "byte", Nullable: t.Kind() == reflect.Slice}
+		}
+		return &openAPISchema{Type: "array", Items: s.schemaOfType(t.Elem()), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: s.schemaOfType(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+
+		name, found := s.types[t]
+		if !found {
+			name = t.Name()
+			for i := 2; s.components[name] != nil; i++ {
+				name = fmt.Sprintf("%s%d", t.Name(), i)
+			}
+
+			// register the name first to support recursive types
+			s.types[t] = name
+			s.components[name] = &openAPISchema{}
+			s.components[name] = s.structSchema(t)
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + name}
+	}
+
+	// interfaces and types that can not be described accept any value
+	return &openAPISchema{}
+}
+
+func (s *openAPISchemas) addStructFields(schema *openAPISchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.addStructFields(schema, ft)
+				continue
+			}
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		schema.Properties[name] = s.schemaOfType(field.Type)
+
+		for _, rule := range strings.Split(field.Tag.Get("valid"), ",") {
+			if rule == "nonzero" {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+	}
+}
+
+func (s *openAPISchemas) structSchema(t reflect.Type) *openAPISchema {
+	schema := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+	s.addStructFields(schema, t)
+	sort.Strings(schema.Required)
+	return schema
+}
+
+func (s *openAPISchemas) resolve(schema *openAPISchema) *openAPISchema {
+	for schema.Ref != "" {
+		schema = s.components[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+// getProperty returns the property schema of an object. As the JSON decoder,
+// it prefers an exact match but accepts a case-insensitive one.
+func getProperty(schema *openAPISchema, key string) *openAPISchema {
+	if property, found := schema.Properties[key]; found {
+		return property
+	}
+	for name, property := range schema.Properties {
+		if strings.EqualFold(name, key) {
+			return property
+		}
+	}
+	return nil
+}
+
+func (s *openAPISchemas) validateValue(schema *openAPISchema, value interface{}, path string) error {
+	schema = s.resolve(schema)
+
+	if value == nil {
+		if schema.Nullable || schema.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s: must not be null", path)
+	}
+
+	if len(schema.Enum) > 0 {
+		var found bool
+		for _, e := range schema.Enum {
+			if e == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: must be one of %v", path, schema.Enum)
+		}
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be an object", path)
+		}
+
+		for _, name := range schema.Required {
+			var found bool
+			for key, v := range object {
+				if strings.EqualFold(key, name) && v != nil {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("%s: missing required property %s", path, name)
+			}
+		}
+
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			property := getProperty(schema, key)
+			if property == nil {
+				property = schema.AdditionalProperties
+			}
+			if property == nil {
+				continue
+			}
+			if err := s.validateValue(property, object[key], path+"."+key); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be an array", path)
+		}
+
+		if schema.Items != nil {
+			for i, item := range array {
+				if err := s.validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: must be a string", path)
+		}
+
+		switch schema.Format {
+		case "byte":
+			if _, err := base64.StdEncoding.DecodeString(str); err != nil {
+				return fmt.Errorf("%s: must be base64 encoded", path)
+			}
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%s: must be a RFC 3339 date", path)
+			}
+		}
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: must be a number", path)
+		}
+
+		f, err := number.Float64()
+		if err != nil {
+			return fmt.Errorf("%s: invalid number %s", path, number)
+		}
+
+		if schema.Type == "integer" && f != math.Trunc(f) {
+			return fmt.Errorf("%s: must be an integer", path)
+		}
+		if schema.Minimum != nil && f < *schema.Minimum {
+			return fmt.Errorf("%s: must be greater or equal to %v", path, *schema.Minimum)
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			return fmt.Errorf("%s: must be lower or equal to %v", path, *schema.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: must be a boolean", path)
+		}
+	}
+
+	return nil
+}
+
+// validate verifies that a decoded JSON value complies with the schema of a Go type
+func (s *openAPISchemas) validate(t reflect.Type, value interface{}) error {
+	schema := s.schemaOf(t)
+
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.validateValue(schema, value, "body")
+}
+
+// apiSchemas holds the schemas of the types used by the API. They are used
+// to generate the OpenAPI document and to validate the requests.
+var apiSchemas = newOpenAPISchemas()
+
+// validateJSON verifies that a JSON document complies with the schema of
+// the type of the given value
+func validateJSON(data []byte, v interface{}) error {
+	var value interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+
+	return apiSchemas.validate(reflect.TypeOf(v), value)
+}
+
+// normalizeYAML converts a value decoded from YAML so that it can be
+// validated like a value decoded from JSON
+func normalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprintf("%v", key)] = normalizeYAML(value)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeYAML(item)
+		}
+		return v
+	case int:
+		return json.Number(fmt.Sprintf("%d", v))
+	case int64:
+		return json.Number(fmt.Sprintf("%d", v))
+	case uint64:
+		return json.Number(fmt.Sprintf("%d", v))
+	case float64:
+		return json.Number(fmt.Sprintf("%v", v))
+	}
+	return value
+}
+
+// validateYAML verifies that a YAML document complies with the schema of
+// the type of the given value
+func validateYAML(value interface{}, v interface{}) error {
+	return apiSchemas.validate(reflect.TypeOf(v), normalizeYAML(value))
+}
+
+func jsonContent(schema *openAPISchema) map[string]*openAPIMediaType {
+	return map[string]*openAPIMediaType{"application/json": {Schema: schema}}
+}
+
+func schemaRef(v interface{}) *openAPISchema {
+	return apiSchemas.schemaOf(reflect.TypeOf(v))
+}
+
+var (
+	etagHeader = map[string]*openAPIHeader{
+		"ETag": {Description: "Revision of the resource", Schema: &openAPISchema{Type: "string"}},
+	}
+	ttlParameter = &openAPIParameter{
+		Name: "X-Resource-TTL", In: "header", Description: "Time to live of the resource, as a duration", Schema: &openAPISchema{Type: "string"},
+	}
+	ifMatchParameter = &openAPIParameter{
+		Name: "If-Match", In: "header", Description: "ETag of the resource to update", Schema: &openAPISchema{Type: "string"},
+	}
+)
+
+var resourceTags = map[string]string{
+	"alert":        "Alerts",
+	"capture":      "Captures",
+	"edgerule":     "Edge rules",
+	"injectpacket": "Injections",
+	"noderule":     "Node rules",
+	"token":        "API tokens",
+	"workflow":     "Workflows",
+}
+
+// resourceOperations returns the operations of the routes registered by
+// RegisterAPIHandler, indexed by route name
+func resourceOperations(handler Handler) map[string]*openAPIOperation {
+	name := handler.Name()
+	title := strings.Title(name)
+	resource := handler.New()
+	typ := reflect.TypeOf(resource).Elem().Name()
+	schema := schemaRef(resource)
+
+	tag, found := resourceTags[name]
+	if !found {
+		tag = title + "s"
+	}
+	tags := []string{tag}
+
+	body := &openAPIRequestBody{
+		Required: true,
+		Content: map[string]*openAPIMediaType{
+			"application/json": {Schema: schema},
+			"application/yaml": {Schema: schema},
+		},
+	}
+
+	return map[string]*openAPIOperation{
+		title + "Index": {
+			OperationID: "list" + typ + "s",
+			Summary:     "List " + name + "s",
+			Tags:        tags,
+			Responses: map[string]*openAPIResponse{
+				"200": {
+					Description: tag,
+					Content:     jsonContent(&openAPISchema{Type: "object", AdditionalProperties: schema}),
+				},
+			},
+		},
+		title + "Insert": {
+			OperationID: "create" + typ,
+			Summary:     "Create " + name,
+			Tags:        tags,
+			Parameters:  []*openAPIParameter{ttlParameter},
+			RequestBody: body,
+			Responses: map[string]*openAPIResponse{
+				"201": {Description: typ + " created", Headers: etagHeader, Content: jsonContent(schema)},
+				"400": {Description: "Invalid " + name},
+				"409": {Description: "Duplicated " + name},
+			},
+		},
+		title + "Show": {
+			OperationID: "get" + typ,
+			Summary:     "Get " + name,
+			Tags:        tags,
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: typ + " found", Headers: etagHeader, Content: jsonContent(schema)},
+				"404": {Description: typ + " not found"},
+			},
+		},
+		title + "Update": {
+			OperationID: "update" + typ,
+			Summary:     "Update " + name,
+			Tags:        tags,
+			Parameters:  []*openAPIParameter{ifMatchParameter, ttlParameter},
+			RequestBody: body,
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: typ + " updated", Headers: etagHeader, Content: jsonContent(schema)},
+				"400": {Description: "Invalid " + name},
+				"404": {Description: typ + " not found"},
+				"409": {Description: "Conflicting update"},
+				"412": {Description: typ + " modified since the specified ETag"},
+			},
+		},
+		title + "Patch": {
+			OperationID: "patch" + typ,
+			Summary:     "Apply a JSON merge patch to " + name,
+			Tags:        tags,
+			Parameters:  []*openAPIParameter{ifMatchParameter, ttlParameter},
+			RequestBody: &openAPIRequestBody{
+				Required: true,
+				Content: map[string]*openAPIMediaType{
+					"application/merge-patch+json": {Schema: &openAPISchema{Type: "object"}},
+				},
+			},
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: typ + " updated", Headers: etagHeader, Content: jsonContent(schema)},
+				"400": {Description: "Invalid " + name},
+				"404": {Description: typ + " not found"},
+				"409": {Description: "Conflicting update"},
+				"412": {Description: typ + " modified since the specified ETag"},
+			},
+		},
+		title + "Delete": {
+			OperationID: "delete" + typ,
+			Summary:     "Delete " + name,
+			Tags:        tags,
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: typ + " deleted"},
+				"404": {Description: typ + " not found"},
+			},
+		},
+	}
+}
+
+// apiRoute describes a route registered using registerAPIRoutes
+type apiRoute struct {
+	method    string
+	prefix    bool
+	operation *openAPIOperation
+}
+
+// apiRoutes holds the registered API routes by name. The OpenAPI document
+// of a server is generated from the routes of its router found here.
+var apiRoutes = struct {
+	sync.RWMutex
+	routes map[string]*apiRoute
+}{routes: make(map[string]*apiRoute)}
+
+// registerAPIRoutes registers routes of the API along with the OpenAPI
+// operations describing them, indexed by route name. The routes without
+// operation are only described by their name and path.
+func registerAPIRoutes(server *shttp.Server, routes []shttp.Route, operations map[string]*openAPIOperation, authBackend shttp.AuthenticationBackend) {
+	apiRoutes.Lock()
+	for _, route := range routes {
+		_, prefix := route.Path.(shttp.PathPrefix)
+		apiRoutes.routes[route.Name] = &apiRoute{
+			method:    strings.ToLower(route.Method),
+			prefix:    prefix,
+			operation: operations[route.Name],
+		}
+	}
+	apiRoutes.Unlock()
+
+	server.RegisterRoutes(routes, authBackend)
+}
+
+// openAPIPath returns the path of a route relative to the API base path,
+// without the regular expressions of its variables. The routes matching a
+// path prefix expect the ID of a resource.
+func openAPIPath(template string, prefix bool) string {
+	segments := strings.Split(strings.TrimPrefix(template, "/api"), "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			segments[i] = strings.SplitN(segment, ":", 2)[0]
+			if !strings.HasSuffix(segments[i], "}") {
+				segments[i] += "}"
+			}
+		}
+	}
+
+	path := strings.Join(segments, "/")
+	if prefix {
+		path = strings.TrimSuffix(path, "/") + "/{id}"
+	}
+	if path == "" {
+		path = "/"
+	}
+	return path
+}
+
+// pathParameters returns the parameters of a path template
+func pathParameters(path string) (params []*openAPIParameter) {
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, &openAPIParameter{
+				Name: segment[1 : len(segment)-1], In: "path", Required: true, Schema: &openAPISchema{Type: "string"},
+			})
+		}
+	}
+	return
+}
+
+// routeOperation returns the operation of a route, its path parameters
+// being generated from the path
+func routeOperation(name string, route *apiRoute, path string) *openAPIOperation {
+	op := &openAPIOperation{
+		OperationID: strings.Replace(name, " ", "", -1),
+		Responses:   map[string]*openAPIResponse{"200": {Description: "Success"}},
+	}
+	if route.operation != nil {
+		*op = *route.operation
+	}
+
+	op.Parameters = append(pathParameters(path), op.Parameters...)
+	return op
+}
+
+// OpenAPIDocument returns the OpenAPI 3 document describing the routes
+// registered on the API server
+func (a *Server) OpenAPIDocument() *openAPISpec {
+	doc := &openAPISpec{
+		OpenAPI: openAPIVersion,
+		Servers: []openAPIServer{{URL: "/api"}},
+		Paths:   make(map[string]map[string]*openAPIOperation),
+	}
+	doc.Info.Title = "Skydive API"
+	doc.Info.Version = version.Version
+	doc.Info.License.Name = "Apache 2.0"
+	doc.Info.License.URL = "http://www.apache.org/licenses/LICENSE-2.0"
+	doc.Components.SecuritySchemes = map[string]map[string]interface{}{
+		"basicAuth":  {"type": "http", "scheme": "basic"},
+		"cookieAuth": {"type": "apiKey", "in": "cookie", "name": "authtok"},
+	}
+	doc.Security = []map[string][]string{{"basicAuth": {}}, {"cookieAuth": {}}}
+
+	apiRoutes.RLock()
+	a.HTTPServer.Router.Walk(func(r *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		route, found := apiRoutes.routes[r.GetName()]
+		if !found {
+			return nil
+		}
+
+		template, err := r.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(template, "/api") {
+			return nil
+		}
+
+		path := openAPIPath(template, route.prefix)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*openAPIOperation)
+		}
+		doc.Paths[path][route.method] = routeOperation(r.GetName(), route, path)
+
+		return nil
+	})
+	apiRoutes.RUnlock()
+
+	apiSchemas.RLock()
+	doc.Components.Schemas = make(map[string]*openAPISchema, len(apiSchemas.components))
+	for name, schema := range apiSchemas.components {
+		doc.Components.Schemas[name] = schema
+	}
+	apiSchemas.RUnlock()
+
+	return doc
+}
+
+func (a *Server) addOpenAPIRoute(authBackend shttp.AuthenticationBackend) {
+	routes := []shttp.Route{
+		{
+			Name:   "OpenAPI",
+			Method: "GET",
+			Path:   "/api/openapi.json",
+			HandlerFunc: func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+				w.WriteHeader(http.StatusOK)
+
+				if err := json.NewEncoder(w).Encode(a.OpenAPIDocument()); err != nil {
+					logging.GetLogger().Criticalf("Failed to display OpenAPI document: %s", err)
+				}
+			},
+		},
+	}
+
+	operations := map[string]*openAPIOperation{
+		"OpenAPI": {
+			OperationID: "getOpenAPI",
+			Summary:     "Get the OpenAPI document of the API",
+			Tags:        []string{"API Info"},
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: "OpenAPI document", Content: jsonContent(&openAPISchema{Type: "object"})},
+			},
+		},
+	}
+
+	registerAPIRoutes(a.HTTPServer, routes, operations, authBackend)
+}
+
+// decodeJSON validates a JSON document against the schema of the value and decodes it
+func decodeJSON(data []byte, v interface{}) error {
+	if err := validateJSON(data, v); err != nil {
+		return err
+	}
+	return common.JSONDecode(bytes.NewReader(data), v)
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/graffiti/graph/traversal"
+	shttp "github.com/skydive-project/skydive/http"
+)
+
+func newOpenAPITestServer(t *testing.T) *Server {
+	httpServer := shttp.NewServer("testhost", common.AnalyzerService, "127.0.0.1", 0, nil)
+	authBackend := shttp.NewNoAuthenticationBackend()
+
+	apiServer, err := NewAPI(httpServer, newFakeKeysAPI(), common.Service{Type: common.AnalyzerService, ID: "testhost"}, authBackend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := newPcapTestGraph(t)
+	parser := traversal.NewGremlinTraversalParser()
+
+	if _, err := RegisterAlertAPI(apiServer, authBackend); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RegisterCaptureAPI(apiServer, g, authBackend); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RegisterWorkflowAPI(apiServer, authBackend); err != nil {
+		t.Fatal(err)
+	}
+
+	RegisterTopologyAPI(httpServer, g, parser, authBackend, 10)
+	RegisterConfigAPI(httpServer, authBackend)
+	RegisterPcapAPI(httpServer, nil, authBackend)
+	RegisterPcapFileAPI(httpServer, g, parser, nil, authBackend)
+	RegisterCaptureDumpAPI(httpServer, apiServer, nil, authBackend)
+	RegisterAuditAPI(httpServer, authBackend)
+
+	return apiServer
+}
+
+// requestPath returns a path matching a path template of the document
+func requestPath(path string) string {
+	if path == "/" {
+		return "/api"
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") {
+			segment = "test"
+		}
+		segments = append(segments, segment)
+	}
+	return "/api" + strings.Join(segments, "/")
+}
+
+func TestOpenAPIDocumentRoutes(t *testing.T) {
+	apiServer := newOpenAPITestServer(t)
+	router := apiServer.HTTPServer.Router
+	doc := apiServer.OpenAPIDocument()
+
+	// every API route of the router is documented
+	var routes int
+	router.Walk(func(r *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := r.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(template, "/api") {
+			return nil
+		}
+		routes++
+
+		apiRoutes.RLock()
+		route, found := apiRoutes.routes[r.GetName()]
+		apiRoutes.RUnlock()
+
+		if !found {
+			t.Errorf("Route %s was not registered as an API route", r.GetName())
+			return nil
+		}
+
+		if route.operation == nil {
+			t.Errorf("Route %s has no OpenAPI operation", r.GetName())
+		}
+
+		path := openAPIPath(template, route.prefix)
+		if doc.Paths[path][route.method] == nil {
+			t.Errorf("Route %s is not part of the document as %s %s", r.GetName(), route.method, path)
+		}
+
+		return nil
+	})
+
+	// every operation of the document leads to a route
+	var operations int
+	operationIDs := make(map[string]bool)
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			operations++
+
+			if operationIDs[op.OperationID] {
+				t.Errorf("Duplicated operation ID %s", op.OperationID)
+			}
+			operationIDs[op.OperationID] = true
+
+			for _, param := range pathParameters(path) {
+				var found bool
+				for _, p := range op.Parameters {
+					found = found || (p.In == "path" && p.Name == param.Name)
+				}
+				if !found {
+					t.Errorf("Parameter %s of %s %s is not documented", param.Name, method, path)
+				}
+			}
+
+			req := httptest.NewRequest(strings.ToUpper(method), requestPath(path), nil)
+
+			var match mux.RouteMatch
+			if !router.Match(req, &match) || match.Route == nil {
+				t.Errorf("No route for %s %s", method, path)
+				continue
+			}
+
+			template, _ := match.Route.GetPathTemplate()
+
+			apiRoutes.RLock()
+			route := apiRoutes.routes[match.Route.GetName()]
+			apiRoutes.RUnlock()
+
+			if route == nil || route.method != method || openAPIPath(template, route.prefix) != path {
+				t.Errorf("%s %s is handled by route %s", method, path, match.Route.GetName())
+			}
+		}
+	}
+
+	if operations != routes {
+		t.Errorf("Expected %d operations, got %d", routes, operations)
+	}
+
+	for _, path := range []string{"/", "/openapi.json", "/alert", "/alert/{id}", "/capture/{id}/dump", "/topology/events", "/config/{key}", "/pcapfile/{id}"} {
+		if doc.Paths[path] == nil {
+			t.Errorf("Path %s is missing from the document", path)
+		}
+	}
+
+	// the routes of the handlers that were not registered are not documented
+	if doc.Paths["/noderule"] != nil {
+		t.Error("The node rule routes were not registered and should not be documented")
+	}
+}
+
+func TestOpenAPIDocumentServed(t *testing.T) {
+	apiServer := newOpenAPITestServer(t)
+
+	w := httptest.NewRecorder()
+	apiServer.HTTPServer.Router.ServeHTTP(w, httptest.NewRequest("GET", "/api/openapi.json", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d", w.Code)
+	}
+
+	var doc struct {
+		OpenAPI    string
+		Paths      map[string]map[string]json.RawMessage
+		Components struct {
+			Schemas map[string]json.RawMessage
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.OpenAPI != openAPIVersion {
+		t.Errorf("Unexpected OpenAPI version: %s", doc.OpenAPI)
+	}
+
+	for _, schema := range []string{"Alert", "Capture", "Workflow", "PcapFile"} {
+		if _, found := doc.Components.Schemas[schema]; !found {
+			t.Errorf("Schema %s is missing from the document", schema)
+		}
+	}
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	for _, test := range []struct {
+		template string
+		prefix   bool
+		path     string
+		params   []string
+	}{
+		{"/api", false, "/", nil},
+		{"/api/alert", false, "/alert", nil},
+		{"/api/alert/", true, "/alert/{id}", []string{"id"}},
+		{"/api/workflow/{ID}/call", false, "/workflow/{ID}/call", []string{"ID"}},
+		{"/api/config/{key:.*}", false, "/config/{key}", []string{"key"}},
+	} {
+		path := openAPIPath(test.template, test.prefix)
+		if path != test.path {
+			t.Errorf("Expected path %s for %s, got %s", test.path, test.template, path)
+		}
+
+		var params []string
+		for _, param := range pathParameters(path) {
+			params = append(params, param.Name)
+		}
+		if strings.Join(params, ",") != strings.Join(test.params, ",") {
+			t.Errorf("Expected parameters %v for %s, got %v", test.params, path, params)
+		}
+	}
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/skydive-project/skydive/graffiti/graph"
 	ge "github.com/skydive-project/skydive/gremlin/traversal"
 	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/rbac"
 )
 
 type packetInjectorResourceHandler struct {
@@ -62,12 +63,29 @@ func (pi *PacketInjectorAPI) Create(r types.Resource, opts *CreateOptions) error
 	return e
 }
 
+// Update validates the updated packet injection
+func (pi *PacketInjectorAPI) Update(id string, r types.Resource, opts *UpdateOptions) (uint64, error) {
+	ppr := r.(*types.PacketInjection)
+
+	if err := pi.validateRequest(ppr); err != nil {
+		return 0, err
+	}
+	return pi.BasicAPIHandler.Update(id, ppr, opts)
+}
+
+// validateRequest checks the nodes of an injection, only the nodes of the
+// tenant of the injection can be used
 func (pi *PacketInjectorAPI) validateRequest(ppr *types.PacketInjection) error {
-	pi.Graph.RLock()
-	defer pi.Graph.RUnlock()
+	g, err := rbac.GetTenantGraph(pi.Graph, ppr.Tenant, nil, true)
+	if err != nil {
+		return err
+	}
+
+	g.RLock()
+	defer g.RUnlock()
 
-	srcNode := pi.getNode(ppr.Src)
-	dstNode := pi.getNode(ppr.Dst)
+	srcNode := pi.getNode(g, ppr.Src)
+	dstNode := pi.getNode(g, ppr.Dst)
 
 	if srcNode == nil {
 		return fmt.Errorf("Not able to find a source node for '%s'", ppr.Src)
@@ -122,8 +140,8 @@ func (pi *PacketInjectorAPI) validateRequest(ppr *types.PacketInjection) error {
 	return nil
 }
 
-func (pi *PacketInjectorAPI) getNode(gremlinQuery string) *graph.Node {
-	res, err := ge.TopologyGremlinQuery(pi.Graph, gremlinQuery)
+func (pi *PacketInjectorAPI) getNode(g *graph.Graph, gremlinQuery string) *graph.Node {
+	res, err := ge.TopologyGremlinQuery(g, gremlinQuery)
 	if err != nil {
 		return nil
 	}
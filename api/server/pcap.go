@@ -35,6 +35,59 @@ type PcapAPI struct {
 	Storage storage.Storage
 }
 
+// pcapTables feeds a flow table per node TID
+type pcapTables struct {
+	sender       flow.Sender
+	captureID    string
+	opts         flow.TableOpts
+	tables       []*flow.Table
+	packetsChans map[string]chan *flow.PacketSequence
+}
+
+// feed passes packets to the table of a node, returning whether the table
+// had to be created
+func (t *pcapTables) feed(tid string, ps *flow.PacketSequence) bool {
+	packetsChan, found := t.packetsChans[tid]
+	if !found {
+		updateEvery := time.Duration(config.GetInt("flow.update")) * time.Second
+		expireAfter := time.Duration(config.GetInt("flow.expire")) * time.Second
+
+		table := flow.NewTable(updateEvery, expireAfter, t.sender, flow.UUIDs{NodeTID: tid, CaptureID: t.captureID}, t.opts)
+		packetsChan, _ = table.Start(nil)
+
+		t.tables = append(t.tables, table)
+		t.packetsChans[tid] = packetsChan
+	}
+
+	packetsChan <- ps
+
+	return !found
+}
+
+// stop stops and flushes the tables
+func (t *pcapTables) stop() {
+	for _, table := range t.tables {
+		table.Stop()
+	}
+}
+
+func newPcapTables(sender flow.Sender, captureID string, opts flow.TableOpts) *pcapTables {
+	return &pcapTables{
+		sender:       sender,
+		captureID:    captureID,
+		opts:         opts,
+		packetsChans: make(map[string]chan *flow.PacketSequence),
+	}
+}
+
+// interfaceNodeTID returns the node TID of the pcapng interface of a packet
+func interfaceNodeTID(feeder *flow.PcapTableFeeder, ps *flow.PacketSequence) string {
+	if intf, ok := feeder.Interface(ps.Packets[0].GoPacket.Metadata().InterfaceIndex); ok {
+		return intf.NodeTID
+	}
+	return ""
+}
+
 // SendFlows implements the flow Sender interface
 func (p *PcapAPI) SendFlows(flows []*flow.Flow) {
 	if p.Storage != nil && len(flows) > 0 {
@@ -44,28 +97,31 @@ func (p *PcapAPI) SendFlows(flows []*flow.Flow) {
 }
 
 func (p *PcapAPI) injectPcap(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
-	updateEvery := time.Duration(config.GetInt("flow.update")) * time.Second
-	expireAfter := time.Duration(config.GetInt("flow.expire")) * time.Second
-
 	if !rbac.Enforce(r.Username, "pcap", "write") {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	flowtable := flow.NewTable(updateEvery, expireAfter, p, flow.UUIDs{}, flow.TableOpts{})
-	packetSeqChan, _ := flowtable.Start(nil)
+	// the packets of the pcapng files written by skydive get the TID of
+	// the node of their interface
+	tables := newPcapTables(p, "", flow.TableOpts{})
 
-	feeder, err := flow.NewPcapTableFeeder(r.Body, packetSeqChan, false, "")
+	var feeder *flow.PcapTableFeeder
+	feeder, err := flow.NewPcapFeeder(r.Body, func(ps *flow.PacketSequence) {
+		tables.feed(interfaceNodeTID(feeder, ps), ps)
+	}, 0, "")
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
+	auditRequest(r, "inject", "pcap", "", nil)
+
 	feeder.Start()
 	feeder.Wait()
 
-	// stop/flush flowtable
-	flowtable.Stop()
+	// stop/flush flowtables
+	tables.stop()
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusAccepted)
@@ -81,7 +137,25 @@ func (p *PcapAPI) registerEndpoints(r *shttp.Server, authBackend shttp.Authentic
 		},
 	}
 
-	r.RegisterRoutes(routes, authBackend)
+	operations := map[string]*openAPIOperation{
+		"PCAP": {
+			OperationID: "injectPCAP",
+			Summary:     "Inject PCAP",
+			Tags:        []string{"PCAP"},
+			RequestBody: &openAPIRequestBody{
+				Required: true,
+				Content: map[string]*openAPIMediaType{
+					"application/octet-stream": {Schema: &openAPISchema{Type: "string", Format: "binary"}},
+				},
+			},
+			Responses: map[string]*openAPIResponse{
+				"202": {Description: "PCAP injected"},
+				"400": {Description: "Invalid PCAP"},
+			},
+		},
+	}
+
+	registerAPIRoutes(r, routes, operations, authBackend)
 }
 
 // RegisterPcapAPI registers a new pcap injector API
@@ -0,0 +1,520 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+	"github.com/google/gopacket"
+	"github.com/gorilla/mux"
+	uuid "github.com/nu7hatch/gouuid"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/storage"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/graffiti/graph/traversal"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+// pcapNodeMapper maps the packets to the nodes of the topology owning their
+// addresses. The graph is the one of the user ingesting the file so that
+// the flows are only attached to the nodes of its tenant.
+type pcapNodeMapper struct {
+	nodes         map[string]bool
+	tids          map[string]string
+	conversations map[uint64]string
+}
+
+func newPcapNodeMapper(g *graph.Graph) *pcapNodeMapper {
+	m := &pcapNodeMapper{
+		nodes:         make(map[string]bool),
+		tids:          make(map[string]string),
+		conversations: make(map[uint64]string),
+	}
+
+	g.RLock()
+	defer g.RUnlock()
+
+	for _, node := range g.GetNodes(nil) {
+		tid, _ := node.GetFieldString("TID")
+		if tid == "" {
+			continue
+		}
+		m.nodes[tid] = true
+
+		var addrs []string
+		if mac, _ := node.GetFieldString("MAC"); mac != "" {
+			addrs = append(addrs, strings.ToLower(mac))
+		}
+		for _, field := range []string{"IPV4", "IPV6"} {
+			ips, _ := node.GetFieldStringList(field)
+			for _, ip := range ips {
+				addrs = append(addrs, strings.SplitN(ip, "/", 2)[0])
+			}
+		}
+
+		for _, addr := range addrs {
+			if _, found := m.tids[addr]; !found {
+				m.tids[addr] = tid
+			}
+		}
+	}
+
+	return m
+}
+
+// nodeTID returns the TID of the node of a packet, the addresses of the
+// source being preferred. All the packets exchanged by two hosts get the
+// node of their first packet so that both directions of a flow end up in
+// the same flow.
+func (m *pcapNodeMapper) nodeTID(packet gopacket.Packet) string {
+	var key uint64
+	var src, dst []string
+
+	if layer := packet.LinkLayer(); layer != nil {
+		s, d := layer.LinkFlow().Endpoints()
+		src, dst = append(src, s.String()), append(dst, d.String())
+		key = layer.LinkFlow().FastHash()
+	}
+	if layer := packet.NetworkLayer(); layer != nil {
+		s, d := layer.NetworkFlow().Endpoints()
+		src, dst = append(src, s.String()), append(dst, d.String())
+		key = layer.NetworkFlow().FastHash()
+	}
+
+	if tid, found := m.conversations[key]; found {
+		return tid
+	}
+
+	var tid string
+	for _, addr := range append(src, dst...) {
+		if tid = m.tids[addr]; tid != "" {
+			break
+		}
+	}
+	m.conversations[key] = tid
+
+	return tid
+}
+
+// pcapFileIngestion feeds a flow table per node with the packets of a file
+type pcapFileIngestion struct {
+	sync.RWMutex
+	pcapFile types.PcapFile
+	tenant   string
+	file     *os.File
+	feeder   *flow.PcapTableFeeder
+	mapper   *pcapNodeMapper
+	tables   *pcapTables
+	done     chan struct{}
+}
+
+// feed passes the packets to the table of their node, the node of the
+// pcapng interface being preferred to the one owning the addresses. The
+// interface nodes outside of the tenant of the ingestion are ignored.
+func (i *pcapFileIngestion) feed(ps *flow.PacketSequence) {
+	tid := interfaceNodeTID(i.feeder, ps)
+	if i.tenant != "" && !i.mapper.nodes[tid] {
+		tid = ""
+	}
+	if tid == "" {
+		tid = i.mapper.nodeTID(ps.Packets[0].GoPacket)
+	}
+
+	if i.tables.feed(tid, ps) && tid != "" {
+		i.Lock()
+		i.pcapFile.Nodes = append(i.pcapFile.Nodes, tid)
+		i.Unlock()
+	}
+}
+
+func (i *pcapFileIngestion) run() {
+	defer close(i.done)
+
+	i.feeder.Wait()
+
+	// stop/flush flowtables
+	i.tables.stop()
+
+	os.Remove(i.file.Name())
+
+	i.Lock()
+	if err := i.feeder.Err(); err != nil {
+		i.pcapFile.State = types.PcapFileFailed
+		i.pcapFile.Error = err.Error()
+	} else {
+		i.pcapFile.State = types.PcapFileDone
+	}
+	i.Unlock()
+}
+
+func (i *pcapFileIngestion) stop() {
+	i.feeder.Stop()
+	<-i.done
+}
+
+// visible returns whether a user can access an ingestion, the users
+// belonging to a tenant only access the ingestions of their tenant
+func (i *pcapFileIngestion) visible(username string) bool {
+	tenant := userTenant(username)
+	return tenant == "" || tenant == i.tenant
+}
+
+func (i *pcapFileIngestion) status() types.PcapFile {
+	i.RLock()
+	defer i.RUnlock()
+
+	pcapFile := i.pcapFile
+	pcapFile.Nodes = append([]string{}, i.pcapFile.Nodes...)
+	pcapFile.Packets = i.feeder.Packets()
+	return pcapFile
+}
+
+// PcapFileAPI exposes the pcap file ingestion API. The files larger than
+// maxSize are rejected, the finished ingestions are removed after the
+// retention delay.
+type PcapFileAPI struct {
+	sync.RWMutex
+	graph         *graph.Graph
+	gremlinParser *traversal.GremlinTraversalParser
+	pcapAPI       *PcapAPI
+	maxSize       int64
+	retention     time.Duration
+	ingestions    map[string]*pcapFileIngestion
+}
+
+func parsePcapFile(r *http.Request) (*types.PcapFile, error) {
+	query := r.URL.Query()
+
+	pcapFile := &types.PcapFile{
+		Name:           query.Get("name"),
+		BPFFilter:      query.Get("bpf"),
+		RawPacketLimit: int64(flow.MaxRawPacketLimit),
+	}
+
+	if v := query.Get("speed"); v != "" {
+		speed, err := strconv.ParseFloat(v, 64)
+		if err != nil || speed < 0 {
+			return nil, fmt.Errorf("Invalid speed parameter: %s", v)
+		}
+		pcapFile.Speed = speed
+	}
+
+	if v := query.Get("rawpackets"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 0 || limit > int64(flow.MaxRawPacketLimit) {
+			return nil, fmt.Errorf("Invalid rawpackets parameter, should be between 0 and %d", flow.MaxRawPacketLimit)
+		}
+		pcapFile.RawPacketLimit = limit
+	}
+
+	return pcapFile, nil
+}
+
+func (p *PcapFileAPI) create(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "pcap", "write") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if p.pcapAPI.Storage == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("No flow storage configured"))
+		return
+	}
+
+	pcapFile, err := parsePcapFile(&r.Request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the packets are only mapped to the nodes the user can see
+	g, err := userGraph(p.graph, p.gremlinParser, r.Username, true)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// the file is spooled so that the ingestion can outlive the request
+	file, err := ioutil.TempFile("", "skydive-pcap-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	body := r.Body
+	if p.maxSize > 0 {
+		body = http.MaxBytesReader(w, body, p.maxSize)
+	}
+
+	n, err := io.Copy(file, body)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		if p.maxSize > 0 && n >= p.maxSize {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("Pcap file larger than %d bytes", p.maxSize))
+		} else {
+			writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	id, _ := uuid.NewV4()
+	pcapFile.UUID = id.String()
+	pcapFile.State = types.PcapFileRunning
+	pcapFile.StartTime = time.Now()
+
+	ingestion := &pcapFileIngestion{
+		pcapFile: *pcapFile,
+		tenant:   userTenant(r.Username),
+		file:     file,
+		mapper:   newPcapNodeMapper(g),
+		tables:   newPcapTables(p.pcapAPI, pcapFile.UUID, flow.TableOpts{RawPacketLimit: pcapFile.RawPacketLimit}),
+		done:     make(chan struct{}),
+	}
+
+	if ingestion.feeder, err = flow.NewPcapFeeder(file, ingestion.feed, pcapFile.Speed, pcapFile.BPFFilter); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	auditRequest(r, "inject", "pcapfile", pcapFile.UUID, auditObject(pcapFile))
+
+	p.Lock()
+	p.ingestions[pcapFile.UUID] = ingestion
+	p.Unlock()
+
+	ingestion.feeder.Start()
+	go ingestion.run()
+	go p.expire(pcapFile.UUID, ingestion)
+
+	writePcapFile(w, http.StatusAccepted, ingestion.status())
+}
+
+func (p *PcapFileAPI) index(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "pcap", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	p.RLock()
+	pcapFiles := make(map[string]types.PcapFile, len(p.ingestions))
+	for id, ingestion := range p.ingestions {
+		if ingestion.visible(r.Username) {
+			pcapFiles[id] = ingestion.status()
+		}
+	}
+	p.RUnlock()
+
+	writePcapFile(w, http.StatusOK, pcapFiles)
+}
+
+func (p *PcapFileAPI) get(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "pcap", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	p.RLock()
+	ingestion, found := p.ingestions[mux.Vars(&r.Request)["id"]]
+	p.RUnlock()
+
+	if !found || !ingestion.visible(r.Username) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	writePcapFile(w, http.StatusOK, ingestion.status())
+}
+
+func (p *PcapFileAPI) delete(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "pcap", "write") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := mux.Vars(&r.Request)["id"]
+
+	p.Lock()
+	ingestion, found := p.ingestions[id]
+	if found = found && ingestion.visible(r.Username); found {
+		delete(p.ingestions, id)
+	}
+	p.Unlock()
+
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// the flows already stored are kept
+	ingestion.stop()
+
+	auditRequest(r, "delete", "pcapfile", id, nil)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// expire removes an ingestion once it has been finished for the retention
+// delay, the flows already stored are kept
+func (p *PcapFileAPI) expire(id string, ingestion *pcapFileIngestion) {
+	<-ingestion.done
+
+	if p.retention <= 0 {
+		return
+	}
+
+	time.AfterFunc(p.retention, func() {
+		p.Lock()
+		if p.ingestions[id] == ingestion {
+			delete(p.ingestions, id)
+		}
+		p.Unlock()
+	})
+}
+
+func writePcapFile(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		logging.GetLogger().Warningf("Error while writing response: %s", err)
+	}
+}
+
+func (p *PcapFileAPI) registerEndpoints(r *shttp.Server, authBackend shttp.AuthenticationBackend) {
+	routes := []shttp.Route{
+		{
+			Name:        "PcapFileCreate",
+			Method:      "POST",
+			Path:        "/api/pcapfile",
+			HandlerFunc: p.create,
+		},
+		{
+			Name:        "PcapFileIndex",
+			Method:      "GET",
+			Path:        "/api/pcapfile",
+			HandlerFunc: p.index,
+		},
+		{
+			Name:        "PcapFileGet",
+			Method:      "GET",
+			Path:        "/api/pcapfile/{id}",
+			HandlerFunc: p.get,
+		},
+		{
+			Name:        "PcapFileDelete",
+			Method:      "DELETE",
+			Path:        "/api/pcapfile/{id}",
+			HandlerFunc: p.delete,
+		},
+	}
+
+	query := func(name, description, typ string) *openAPIParameter {
+		return &openAPIParameter{Name: name, In: "query", Description: description, Schema: &openAPISchema{Type: typ}}
+	}
+
+	operations := map[string]*openAPIOperation{
+		"PcapFileCreate": {
+			OperationID: "createPcapFile",
+			Summary:     "Ingest a pcap or pcapng file",
+			Tags:        []string{"PCAP"},
+			Parameters: []*openAPIParameter{
+				query("name", "Name of the file", "string"),
+				query("speed", "Replay speed, 0 to keep the original timestamps", "number"),
+				query("bpf", "BPF filter", "string"),
+				query("rawpackets", "Maximum number of raw packets stored per flow", "integer"),
+			},
+			RequestBody: &openAPIRequestBody{
+				Required: true,
+				Content: map[string]*openAPIMediaType{
+					"application/octet-stream": {Schema: &openAPISchema{Type: "string", Format: "binary"}},
+				},
+			},
+			Responses: map[string]*openAPIResponse{
+				"202": {Description: "Ingestion started", Content: jsonContent(schemaRef(types.PcapFile{}))},
+				"400": {Description: "Invalid file or parameters"},
+				"413": {Description: "File too large"},
+				"503": {Description: "No flow storage configured"},
+			},
+		},
+		"PcapFileIndex": {
+			OperationID: "listPcapFiles",
+			Summary:     "List the pcap file ingestions",
+			Tags:        []string{"PCAP"},
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: "Pcap file ingestions", Content: jsonContent(&openAPISchema{Type: "object", AdditionalProperties: schemaRef(types.PcapFile{})})},
+			},
+		},
+		"PcapFileGet": {
+			OperationID: "getPcapFile",
+			Summary:     "Get a pcap file ingestion",
+			Tags:        []string{"PCAP"},
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: "Pcap file ingestion", Content: jsonContent(schemaRef(types.PcapFile{}))},
+				"404": {Description: "Ingestion not found"},
+			},
+		},
+		"PcapFileDelete": {
+			OperationID: "deletePcapFile",
+			Summary:     "Stop and forget a pcap file ingestion",
+			Tags:        []string{"PCAP"},
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: "Ingestion stopped"},
+				"404": {Description: "Ingestion not found"},
+			},
+		},
+	}
+
+	registerAPIRoutes(r, routes, operations, authBackend)
+}
+
+// RegisterPcapFileAPI registers the pcap file ingestion API. The files are
+// ingested in the background, the flows being stored with the UUID of the
+// ingestion as CaptureID.
+func RegisterPcapFileAPI(r *shttp.Server, g *graph.Graph, parser *traversal.GremlinTraversalParser, store storage.Storage, authBackend shttp.AuthenticationBackend) {
+	p := &PcapFileAPI{
+		graph:         g,
+		gremlinParser: parser,
+		pcapAPI:       &PcapAPI{Storage: store},
+		maxSize:       int64(config.GetInt("analyzer.pcapfile.max_size")),
+		retention:     time.Duration(config.GetInt("analyzer.pcapfile.retention")) * time.Second,
+		ingestions:    make(map[string]*pcapFileIngestion),
+	}
+
+	p.registerEndpoints(r, authBackend)
+}
@@ -0,0 +1,295 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/gorilla/mux"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/graffiti/graph/traversal"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+type fakeFlowStorage struct {
+	sync.Mutex
+	flows []*flow.Flow
+}
+
+func (s *fakeFlowStorage) Start() {}
+func (s *fakeFlowStorage) Stop()  {}
+
+func (s *fakeFlowStorage) StoreFlows(flows []*flow.Flow) error {
+	s.Lock()
+	s.flows = append(s.flows, flows...)
+	s.Unlock()
+	return nil
+}
+
+func (s *fakeFlowStorage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowSet, error) {
+	return flow.NewFlowSet(), nil
+}
+
+func (s *fakeFlowStorage) SearchMetrics(fsq filters.SearchQuery, metricFilter *filters.Filter) (map[string][]common.Metric, error) {
+	return nil, nil
+}
+
+func (s *fakeFlowStorage) SearchRawPackets(fsq filters.SearchQuery, packetFilter *filters.Filter) (map[string][]*flow.RawPacket, error) {
+	return nil, nil
+}
+
+// newPcapTestGraph returns a graph with a node per tenant
+func newPcapTestGraph(t *testing.T) *graph.Graph {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := graph.NewGraph("testhost", b, common.UnknownService)
+	g.NewNode(graph.GenID(), graph.Metadata{"TID": "tid-a", "Tenant": "a", "MAC": "00:00:00:00:00:01", "IPV4": []string{"10.0.0.1/24"}})
+	g.NewNode(graph.GenID(), graph.Metadata{"TID": "tid-b", "Tenant": "b", "IPV4": []string{"10.0.0.2/24"}})
+
+	return g
+}
+
+func initPcapTestTenants(t *testing.T) {
+	err := rbac.InitTenants(nil, []*rbac.Tenant{
+		{Name: "a", Filter: "G.V().Has('Tenant', 'a').SubGraph()", Users: []string{"alice"}},
+		{Name: "b", Filter: "G.V().Has('Tenant', 'b').SubGraph()", Users: []string{"bob"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestPacket(t *testing.T, srcMAC, dstMAC, srcIP, dstIP string) []byte {
+	src, _ := net.ParseMAC(srcMAC)
+	dst, _ := net.ParseMAC(dstMAC)
+
+	eth := &layers.Ethernet{SrcMAC: src, DstMAC: dst, EthernetType: layers.EthernetTypeIPv4}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.ParseIP(srcIP), DstIP: net.ParseIP(dstIP)}
+	udp := &layers.UDP{SrcPort: 1234, DstPort: 5678}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload([]byte("data"))); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func decodeTestPacket(data []byte) gopacket.Packet {
+	return gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+}
+
+func TestPcapNodeMapper(t *testing.T) {
+	m := newPcapNodeMapper(newPcapTestGraph(t))
+
+	request := decodeTestPacket(newTestPacket(t, "00:00:00:00:00:03", "00:00:00:00:00:04", "10.0.0.3", "10.0.0.2"))
+	if tid := m.nodeTID(request); tid != "tid-b" {
+		t.Errorf("Expected the node of the destination, got '%s'", tid)
+	}
+
+	request = decodeTestPacket(newTestPacket(t, "00:00:00:00:00:01", "00:00:00:00:00:04", "10.0.0.1", "10.0.0.2"))
+	if tid := m.nodeTID(request); tid != "tid-a" {
+		t.Errorf("Expected the node of the source, got '%s'", tid)
+	}
+
+	reply := decodeTestPacket(newTestPacket(t, "00:00:00:00:00:04", "00:00:00:00:00:01", "10.0.0.2", "10.0.0.1"))
+	if tid := m.nodeTID(reply); tid != "tid-a" {
+		t.Errorf("Expected the reply to get the node of the request, got '%s'", tid)
+	}
+}
+
+func TestPcapNodeMapperTenant(t *testing.T) {
+	initPcapTestTenants(t)
+	defer rbac.InitTenants(nil, nil)
+
+	g, err := userGraph(newPcapTestGraph(t), traversal.NewGremlinTraversalParser(), "alice", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newPcapNodeMapper(g)
+
+	packet := decodeTestPacket(newTestPacket(t, "00:00:00:00:00:03", "00:00:00:00:00:04", "10.0.0.3", "10.0.0.2"))
+	if tid := m.nodeTID(packet); tid != "" {
+		t.Errorf("The node of another tenant should not be used, got '%s'", tid)
+	}
+
+	if m.nodes["tid-b"] || !m.nodes["tid-a"] {
+		t.Errorf("Expected only the nodes of the tenant, got %v", m.nodes)
+	}
+}
+
+func newTestPcapFileAPI(t *testing.T, store *fakeFlowStorage) *PcapFileAPI {
+	return &PcapFileAPI{
+		graph:         newPcapTestGraph(t),
+		gremlinParser: traversal.NewGremlinTraversalParser(),
+		pcapAPI:       &PcapAPI{Storage: store},
+		maxSize:       1 << 20,
+		retention:     time.Hour,
+		ingestions:    make(map[string]*pcapFileIngestion),
+	}
+}
+
+// newTestPcapNg returns a pcapng file with a packet from 10.0.0.1 captured
+// on an interface of the node of the tenant b
+func newTestPcapNg(t *testing.T) []byte {
+	var b bytes.Buffer
+
+	w, err := flow.NewPcapNgWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := w.AddInterface(flow.PcapInterface{Name: "eth0", NodeTID: "tid-b", LinkType: layers.LinkTypeEthernet})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := newTestPacket(t, "00:00:00:00:00:01", "00:00:00:00:00:04", "10.0.0.1", "10.0.0.4")
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	if err := w.WritePacket(index, ci, data, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+func callPcapFileAPI(handler auth.AuthenticatedHandlerFunc, username, method, url string, body []byte, vars map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, bytes.NewReader(body))
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, &auth.AuthenticatedRequest{Request: *req, Username: username})
+	return w
+}
+
+func TestPcapFileMaxSize(t *testing.T) {
+	p := newTestPcapFileAPI(t, &fakeFlowStorage{})
+	p.maxSize = 16
+
+	w := callPcapFileAPI(p.create, "admin", "POST", "/api/pcapfile", newTestPcapNg(t), nil)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected a too large file to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if len(p.ingestions) != 0 {
+		t.Error("No ingestion should be registered for a rejected file")
+	}
+}
+
+func TestPcapFileTenant(t *testing.T) {
+	initPcapTestTenants(t)
+	defer rbac.InitTenants(nil, nil)
+
+	store := &fakeFlowStorage{}
+	p := newTestPcapFileAPI(t, store)
+
+	w := callPcapFileAPI(p.create, "alice", "POST", "/api/pcapfile", newTestPcapNg(t), nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected the ingestion to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var pcapFile types.PcapFile
+	if err := json.NewDecoder(w.Body).Decode(&pcapFile); err != nil {
+		t.Fatal(err)
+	}
+
+	p.RLock()
+	ingestion := p.ingestions[pcapFile.UUID]
+	p.RUnlock()
+	<-ingestion.done
+
+	// the interface node belongs to the tenant b, the flows are attached
+	// to the node of the tenant a owning the source address
+	if status := ingestion.status(); len(status.Nodes) != 1 || status.Nodes[0] != "tid-a" {
+		t.Errorf("Expected the flows to be attached to the node of the tenant, got %v", status.Nodes)
+	}
+
+	store.Lock()
+	for _, f := range store.flows {
+		if f.NodeTID != "tid-a" {
+			t.Errorf("Expected the flow to be attached to tid-a, got '%s'", f.NodeTID)
+		}
+	}
+	store.Unlock()
+
+	vars := map[string]string{"id": pcapFile.UUID}
+
+	if w := callPcapFileAPI(p.get, "bob", "GET", "/api/pcapfile/"+pcapFile.UUID, nil, vars); w.Code != http.StatusNotFound {
+		t.Errorf("The ingestion of another tenant should not be visible, got %d", w.Code)
+	}
+
+	var pcapFiles map[string]types.PcapFile
+	w = callPcapFileAPI(p.index, "bob", "GET", "/api/pcapfile", nil, nil)
+	if err := json.NewDecoder(w.Body).Decode(&pcapFiles); err != nil || len(pcapFiles) != 0 {
+		t.Errorf("The ingestions of another tenant should not be listed, got %v", pcapFiles)
+	}
+
+	if w := callPcapFileAPI(p.delete, "bob", "DELETE", "/api/pcapfile/"+pcapFile.UUID, nil, vars); w.Code != http.StatusNotFound {
+		t.Errorf("The ingestion of another tenant should not be deleted, got %d", w.Code)
+	}
+
+	if w := callPcapFileAPI(p.get, "alice", "GET", "/api/pcapfile/"+pcapFile.UUID, nil, vars); w.Code != http.StatusOK {
+		t.Errorf("The ingestion should be visible to its tenant, got %d", w.Code)
+	}
+}
+
+func TestPcapFileRetention(t *testing.T) {
+	p := newTestPcapFileAPI(t, &fakeFlowStorage{})
+	p.retention = 10 * time.Millisecond
+
+	w := callPcapFileAPI(p.create, "admin", "POST", "/api/pcapfile", newTestPcapNg(t), nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected the ingestion to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+
+	err := common.Retry(func() error {
+		p.RLock()
+		defer p.RUnlock()
+
+		if len(p.ingestions) != 0 {
+			return errors.New("Finished ingestion not expired")
+		}
+		return nil
+	}, 10, 50*time.Millisecond)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -37,12 +37,14 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -51,6 +53,7 @@ import (
 	auth "github.com/abbot/go-http-auth"
 	etcd "github.com/coreos/etcd/client"
 
+	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/common"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
@@ -89,6 +92,182 @@ func writeError(w http.ResponseWriter, status int, err error) {
 	w.Write([]byte(err.Error()))
 }
 
+func setETag(w http.ResponseWriter, revision uint64) {
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, revision))
+}
+
+// parseIfMatch returns the revision specified by the If-Match header,
+// 0 if no header was specified or if any revision matches
+func parseIfMatch(r *http.Request) (uint64, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return 0, nil
+	}
+
+	etag := strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+	revision, err := strconv.ParseUint(etag, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid If-Match header: %s", ifMatch)
+	}
+
+	return revision, nil
+}
+
+// decodeResource decodes the resource of a request after having validated
+// it against the resource schema
+func decodeResource(r *http.Request, resource types.Resource) error {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if contentType := r.Header.Get("Content-Type"); contentType == "application/yaml" {
+		var value interface{}
+		if err := yaml.Unmarshal(content, &value); err != nil {
+			return err
+		}
+		if err := validateYAML(value, resource); err != nil {
+			return err
+		}
+		return yaml.Unmarshal(content, resource)
+	}
+
+	if err := validateJSON(content, resource); err != nil {
+		return err
+	}
+	return common.JSONDecode(bytes.NewReader(content), &resource)
+}
+
+func isKeyNotFound(err error) bool {
+	if err, ok := err.(etcd.Error); ok && err.Code == etcd.ErrorCodeKeyNotFound {
+		return true
+	}
+	return false
+}
+
+// updateHandlerFunc returns the handler for the PUT and PATCH methods. With PUT,
+// the resource is replaced, with PATCH a JSON merge patch is applied to it.
+func updateHandlerFunc(handler Handler, patch bool) auth.AuthenticatedHandlerFunc {
+	name := handler.Name()
+
+	return func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+		if !rbac.Enforce(r.Username, name, "write") {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := r.URL.Path[len(fmt.Sprintf("/api/%s/", name)):]
+		if id == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		ifMatch, err := parseIfMatch(&r.Request)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		current, revision, err := handler.GetWithRevision(id)
+		if err != nil {
+			if isKeyNotFound(err) {
+				writeError(w, http.StatusNotFound, err)
+			} else {
+				writeError(w, http.StatusBadRequest, err)
+			}
+			return
+		}
+
+		if !isResourceVisible(r.Username, current) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if ifMatch != 0 && ifMatch != revision {
+			writeError(w, http.StatusPreconditionFailed, ErrRevisionMismatch)
+			return
+		}
+
+		resource := handler.New()
+		if patch {
+			doc, err := json.Marshal(current)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			content, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			if doc, err = common.JSONMergePatch(doc, content); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			if err = validateJSON(doc, resource); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			err = json.Unmarshal(doc, resource)
+		} else {
+			err = decodeResource(&r.Request, resource)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		resource.SetID(id)
+		setResourceTenant(r.Username, resource)
+
+		if err := validator.Validate(resource); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		updateOpts := UpdateOptions{Revision: revision}
+		if ttlHeader := r.Header.Get("X-Resource-TTL"); ttlHeader != "" {
+			if updateOpts.TTL, err = time.ParseDuration(ttlHeader); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ttl: %s", err))
+				return
+			}
+		}
+
+		if revision, err = handler.Update(id, resource, &updateOpts); err != nil {
+			switch {
+			case err == ErrRevisionMismatch && ifMatch != 0:
+				writeError(w, http.StatusPreconditionFailed, err)
+			case err == ErrRevisionMismatch, err == ErrDuplicatedResource:
+				writeError(w, http.StatusConflict, err)
+			case isKeyNotFound(err):
+				writeError(w, http.StatusNotFound, err)
+			default:
+				writeError(w, http.StatusBadRequest, err)
+			}
+			return
+		}
+
+		data, err := json.Marshal(&resource)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		auditRequest(r, "update", name, id, auditUpdate(handler, current, resource))
+
+		setETag(w, revision)
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(data); err != nil {
+			logging.GetLogger().Criticalf("Failed to update %s: %s", name, err)
+		}
+	}
+}
+
 // RegisterAPIHandler registers a new handler for an API
 func (a *Server) RegisterAPIHandler(handler Handler, authBackend shttp.AuthenticationBackend) error {
 	name := handler.Name()
@@ -109,7 +288,11 @@ func (a *Server) RegisterAPIHandler(handler Handler, authBackend shttp.Authentic
 				w.WriteHeader(http.StatusOK)
 
 				resources := handler.Index()
-				for _, resource := range resources {
+				for id, resource := range resources {
+					if !isResourceVisible(r.Username, resource) {
+						delete(resources, id)
+						continue
+					}
 					handler.Decorate(resource)
 				}
 
@@ -134,11 +317,26 @@ func (a *Server) RegisterAPIHandler(handler Handler, authBackend shttp.Authentic
 					return
 				}
 
-				resource, ok := handler.Get(id)
-				if !ok {
+				// resources not stored in Etcd, like the builtin workflows,
+				// have no revision
+				resource, revision, err := handler.GetWithRevision(id)
+				if err != nil {
+					var ok bool
+					if resource, ok = handler.Get(id); !ok {
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+				}
+
+				if !isResourceVisible(r.Username, resource) {
 					w.WriteHeader(http.StatusNotFound)
 					return
 				}
+
+				if err == nil {
+					setETag(w, revision)
+				}
+
 				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 				w.WriteHeader(http.StatusOK)
 				handler.Decorate(resource)
@@ -159,27 +357,25 @@ func (a *Server) RegisterAPIHandler(handler Handler, authBackend shttp.Authentic
 
 				resource := handler.New()
 
-				var err error
-				if contentType := r.Header.Get("Content-Type"); contentType == "application/yaml" {
-					if content, e := ioutil.ReadAll(r.Body); e == nil {
-						err = yaml.Unmarshal(content, resource)
-					} else {
-						writeError(w, http.StatusBadRequest, err)
-						return
-					}
-				} else {
-					err = common.JSONDecode(r.Body, &resource)
-				}
+				err := decodeResource(&r.Request, resource)
 				if err != nil {
 					writeError(w, http.StatusBadRequest, err)
 					return
 				}
+				setResourceTenant(r.Username, resource)
 
 				if err := validator.Validate(resource); err != nil {
 					writeError(w, http.StatusBadRequest, err)
 					return
 				}
 
+				if authorizer, ok := handler.(CreateAuthorizer); ok {
+					if err := authorizer.AuthorizeCreate(r.Username, resource); err != nil {
+						writeError(w, http.StatusForbidden, err)
+						return
+					}
+				}
+
 				var createOpts CreateOptions
 				if ttlHeader := r.Header.Get("X-Resource-TTL"); ttlHeader != "" {
 					if createOpts.TTL, err = time.ParseDuration(ttlHeader); err != nil {
@@ -202,6 +398,12 @@ func (a *Server) RegisterAPIHandler(handler Handler, authBackend shttp.Authentic
 					return
 				}
 
+				auditRequest(r, "create", name, resource.ID(), auditResource(handler, resource))
+
+				if _, revision, err := handler.GetWithRevision(resource.ID()); err == nil {
+					setETag(w, revision)
+				}
+
 				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 				w.WriteHeader(http.StatusCreated)
 				if _, err := w.Write(data); err != nil {
@@ -225,8 +427,14 @@ func (a *Server) RegisterAPIHandler(handler Handler, authBackend shttp.Authentic
 					return
 				}
 
+				resource, ok := handler.Get(id)
+				if ok && !isResourceVisible(r.Username, resource) {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+
 				if err := handler.Delete(id); err != nil {
-					if err, ok := err.(etcd.Error); ok && err.Code == etcd.ErrorCodeKeyNotFound {
+					if isKeyNotFound(err) {
 						writeError(w, http.StatusNotFound, err)
 					} else {
 						writeError(w, http.StatusBadRequest, err)
@@ -234,12 +442,30 @@ func (a *Server) RegisterAPIHandler(handler Handler, authBackend shttp.Authentic
 					return
 				}
 
+				if ok {
+					auditRequest(r, "delete", name, id, auditResource(handler, resource))
+				} else {
+					auditRequest(r, "delete", name, id, nil)
+				}
+
 				w.WriteHeader(http.StatusOK)
 			},
 		},
+		{
+			Name:        title + "Update",
+			Method:      "PUT",
+			Path:        shttp.PathPrefix(fmt.Sprintf("/api/%s/", name)),
+			HandlerFunc: updateHandlerFunc(handler, false),
+		},
+		{
+			Name:        title + "Patch",
+			Method:      "PATCH",
+			Path:        shttp.PathPrefix(fmt.Sprintf("/api/%s/", name)),
+			HandlerFunc: updateHandlerFunc(handler, true),
+		},
 	}
 
-	a.HTTPServer.RegisterRoutes(routes, authBackend)
+	registerAPIRoutes(a.HTTPServer, routes, resourceOperations(handler), authBackend)
 
 	if _, err := a.EtcdKeyAPI.Set(context.Background(), "/"+name, "", &etcd.SetOptions{Dir: true}); err != nil {
 		if _, err = a.EtcdKeyAPI.Get(context.Background(), "/"+name, nil); err != nil {
@@ -300,7 +526,18 @@ func (a *Server) addAPIRootRoute(service common.Service, authBackend shttp.Authe
 			},
 		}}
 
-	a.HTTPServer.RegisterRoutes(routes, authBackend)
+	operations := map[string]*openAPIOperation{
+		"Skydive API": {
+			OperationID: "getApi",
+			Summary:     "Get API info",
+			Tags:        []string{"API Info"},
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: "API info", Content: jsonContent(schemaRef(Info{}))},
+			},
+		},
+	}
+
+	registerAPIRoutes(a.HTTPServer, routes, operations, authBackend)
 }
 
 // GetHandler returns the hander named hname
@@ -317,6 +554,7 @@ func NewAPI(server *shttp.Server, kapi etcd.KeysAPI, service common.Service, aut
 	}
 
 	apiServer.addAPIRootRoute(service, authBackend)
+	apiServer.addOpenAPIRoute(authBackend)
 
 	return apiServer, nil
 }
@@ -73,7 +73,18 @@ func (s *statusAPI) registerEndpoints(r *shttp.Server, authBackend shttp.Authent
 		},
 	}
 
-	r.RegisterRoutes(routes, authBackend)
+	operations := map[string]*openAPIOperation{
+		"StatusGet": {
+			OperationID: "getStatus",
+			Summary:     "Get status",
+			Tags:        []string{"Status"},
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: "Status", Content: jsonContent(&openAPISchema{Type: "object"})},
+			},
+		},
+	}
+
+	registerAPIRoutes(r, routes, operations, authBackend)
 }
 
 // RegisterStatusAPI registers the status API endpoint
@@ -0,0 +1,67 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/graffiti/graph/traversal"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+// userTenant returns the name of the tenant of a user, an empty string
+// if the user doesn't belong to any tenant
+func userTenant(username string) string {
+	if tenant := rbac.GetUserTenant(username); tenant != nil {
+		return tenant.Name
+	}
+	return ""
+}
+
+// isResourceVisible returns whether a user can access a resource. Users
+// belonging to a tenant can only access the resources of their tenant.
+func isResourceVisible(username string, resource types.Resource) bool {
+	tr, ok := resource.(types.TenantResource)
+	if !ok {
+		return true
+	}
+
+	tenant := userTenant(username)
+	return tenant == "" || tr.GetTenant() == tenant
+}
+
+// setResourceTenant assigns a resource to the tenant of a user. The tenant
+// of the resources created by users not belonging to a tenant is kept as is.
+func setResourceTenant(username string, resource types.Resource) {
+	if tr, ok := resource.(types.TenantResource); ok {
+		if tenant := userTenant(username); tenant != "" {
+			tr.SetTenant(tenant)
+		}
+	}
+}
+
+// userGraph returns the part of the graph a user is allowed to see,
+// the whole graph for the users not belonging to a tenant
+func userGraph(g *graph.Graph, parser *traversal.GremlinTraversalParser, username string, lockGraph bool) (*graph.Graph, error) {
+	tenant := rbac.GetUserTenant(username)
+	if tenant == nil {
+		return g, nil
+	}
+
+	return tenant.Graph(g, parser, lockGraph)
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	auth "github.com/abbot/go-http-auth"
+	"github.com/gorilla/mux"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/graffiti/graph/traversal"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+func TestTenantResources(t *testing.T) {
+	initPcapTestTenants(t)
+	defer rbac.InitTenants(nil, nil)
+
+	resources := []types.Resource{
+		&types.Capture{},
+		&types.Alert{},
+		&types.Workflow{},
+		&types.NodeRule{},
+		&types.EdgeRule{},
+		&types.PacketInjection{},
+	}
+
+	for _, resource := range resources {
+		setResourceTenant("alice", resource)
+
+		if !isResourceVisible("alice", resource) {
+			t.Errorf("A %T should be visible to its tenant", resource)
+		}
+
+		if isResourceVisible("bob", resource) {
+			t.Errorf("A %T should not be visible to another tenant", resource)
+		}
+
+		if !isResourceVisible("admin", resource) {
+			t.Errorf("A %T should be visible to the users not belonging to a tenant", resource)
+		}
+	}
+}
+
+func TestTenantPacketInjection(t *testing.T) {
+	initPcapTestTenants(t)
+	defer rbac.InitTenants(nil, nil)
+
+	pi := &PacketInjectorAPI{Graph: newPcapTestGraph(t)}
+
+	injection := &types.PacketInjection{Src: "G.V().Has('TID', 'tid-a')", Pcap: []byte("pcap")}
+	setResourceTenant("alice", injection)
+	if err := pi.validateRequest(injection); err != nil {
+		t.Errorf("A node of the tenant should be usable as source: %s", err)
+	}
+
+	injection = &types.PacketInjection{Src: "G.V().Has('TID', 'tid-b')", Pcap: []byte("pcap")}
+	setResourceTenant("alice", injection)
+	if err := pi.validateRequest(injection); err == nil {
+		t.Error("A node of another tenant should not be usable as source")
+	}
+}
+
+func TestTenantWorkflowCall(t *testing.T) {
+	initPcapTestTenants(t)
+	defer rbac.InitTenants(nil, nil)
+
+	handler, _ := newTestWorkflowHandler(t)
+
+	workflow := &types.Workflow{Name: "count", Source: "function() { return JSON.parse(Gremlin('G.V()')).length }"}
+	setResourceTenant("alice", workflow)
+	if err := handler.Create(workflow, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	wc := &WorkflowCallAPIHandler{
+		apiServer: &Server{handlers: map[string]Handler{"workflow": handler}},
+		graph:     newPcapTestGraph(t),
+		parser:    traversal.NewGremlinTraversalParser(),
+	}
+
+	call := func(username string) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(&types.WorkflowCall{})
+		req := mux.SetURLVars(httptest.NewRequest("POST", "/api/workflow/"+workflow.ID()+"/call", bytes.NewReader(data)), map[string]string{"ID": workflow.ID()})
+
+		w := httptest.NewRecorder()
+		wc.executeWorkflow(w, &auth.AuthenticatedRequest{Request: *req, Username: username})
+		return w
+	}
+
+	if w := call("bob"); w.Code != http.StatusNotFound {
+		t.Errorf("The workflow of another tenant should not be callable, got %d", w.Code)
+	}
+
+	w := call("alice")
+	if w.Code != http.StatusOK {
+		t.Fatalf("The workflow should be callable by its tenant, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var count int
+	if err := json.NewDecoder(w.Body).Decode(&count); err != nil || count != 1 {
+		t.Errorf("The workflow should only see the nodes of the tenant, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,255 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+
+	"github.com/skydive-project/skydive/api/types"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+// ErrImmutableToken is returned when trying to modify an API token
+var ErrImmutableToken = errors.New("API tokens can not be modified, revoke the token and create a new one")
+
+// ErrServiceAccountOwner is returned when creating a token for a service
+// account created by another user
+var ErrServiceAccountOwner = errors.New("Service account was created by another user")
+
+// APITokenResourceHandler aims to creates and manage API tokens.
+type APITokenResourceHandler struct {
+	ResourceHandler
+}
+
+// APITokenAPIHandler aims to exposes the API token API. It also
+// validates the tokens for the authentication backends and resolves
+// their roles from a cache kept up to date by watching etcd.
+type APITokenAPIHandler struct {
+	BasicAPIHandler
+	tokensLock sync.RWMutex
+	tokens     map[string]*types.APIToken
+	watcher    StoppableWatcher
+}
+
+// New creates a new API token
+func (t *APITokenResourceHandler) New() types.Resource {
+	return &types.APIToken{
+		CreateTime: time.Now().UTC(),
+	}
+}
+
+// Name returns resource name "token"
+func (t *APITokenResourceHandler) Name() string {
+	return "token"
+}
+
+func hashTokenSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// parseAPIToken splits a token in the identifier of the resource
+// and the secret
+func parseAPIToken(token string) (id string, secret string, err error) {
+	if !shttp.IsAPIToken(token) {
+		return "", "", shttp.ErrWrongCredentials
+	}
+
+	s := strings.SplitN(strings.TrimPrefix(token, shttp.APITokenPrefix), "_", 2)
+	if len(s) != 2 || s[0] == "" || s[1] == "" {
+		return "", "", shttp.ErrWrongCredentials
+	}
+
+	return s[0], s[1], nil
+}
+
+// tokenOwner returns the owner of the tokens created by a user, the
+// service accounts owning tokens whatever the token they use
+func tokenOwner(username string) string {
+	if rbac.IsServiceAccount(username) {
+		account, _ := rbac.ParseServiceAccountSubject(username)
+		return rbac.ServiceAccountPrefix + account
+	}
+	return username
+}
+
+// AuthorizeCreate checks that a user only grants its own roles and only
+// to the service accounts it created
+func (h *APITokenAPIHandler) AuthorizeCreate(username string, resource types.Resource) error {
+	token := resource.(*types.APIToken)
+	token.Owner = tokenOwner(username)
+
+	for _, resource := range h.Index() {
+		if t := resource.(*types.APIToken); t.ServiceAccount == token.ServiceAccount && t.Owner != token.Owner {
+			return ErrServiceAccountOwner
+		}
+	}
+
+	for _, role := range rbac.GetUserRoles(username) {
+		if role == token.Role {
+			return nil
+		}
+	}
+	return fmt.Errorf("User %s can not grant the role %s", username, token.Role)
+}
+
+// Create generates the secret of a token and stores the token. Only the
+// hash of the secret is stored, the token is only returned to the caller.
+func (h *APITokenAPIHandler) Create(resource types.Resource, createOpts *CreateOptions) error {
+	token := resource.(*types.APIToken)
+
+	if token.IsExpired() {
+		return errors.New("Expiration time of the token is in the past")
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	secret := hex.EncodeToString(b)
+
+	token.Token, token.Hash = "", hashTokenSecret(secret)
+	if err := h.BasicAPIHandler.Create(token, createOpts); err != nil {
+		return err
+	}
+	cached := *token
+	h.cacheToken(token.ID(), &cached)
+
+	token.Token, token.Hash = shttp.APITokenPrefix+token.ID()+"_"+secret, ""
+	return nil
+}
+
+// Update refuses the modification of a token
+func (h *APITokenAPIHandler) Update(id string, resource types.Resource, updateOpts *UpdateOptions) (uint64, error) {
+	return 0, ErrImmutableToken
+}
+
+// Delete revokes a token
+func (h *APITokenAPIHandler) Delete(id string) error {
+	if err := h.BasicAPIHandler.Delete(id); err != nil {
+		return err
+	}
+	h.uncacheToken(id)
+	return nil
+}
+
+// Decorate hides the hash of the token secret
+func (h *APITokenAPIHandler) Decorate(resource types.Resource) {
+	token := resource.(*types.APIToken)
+	token.Token, token.Hash = "", ""
+}
+
+// ValidateAPIToken returns the service account authenticated by a token
+// and the identifier of the token
+func (h *APITokenAPIHandler) ValidateAPIToken(s string) (string, string, error) {
+	id, secret, err := parseAPIToken(s)
+	if err != nil {
+		return "", "", err
+	}
+
+	resource, ok := h.Get(id)
+	if !ok {
+		return "", "", shttp.ErrWrongCredentials
+	}
+
+	token := resource.(*types.APIToken)
+	if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hashTokenSecret(secret))) != 1 {
+		return "", "", shttp.ErrWrongCredentials
+	}
+
+	if token.IsExpired() {
+		return "", "", shttp.ErrWrongCredentials
+	}
+
+	return token.ServiceAccount, id, nil
+}
+
+// TokenRoles returns the role of a token of a service account, none if
+// the token was revoked or is expired. It is used by the RBAC to resolve
+// the roles of the service accounts on each request.
+func (h *APITokenAPIHandler) TokenRoles(account, id string) []string {
+	h.tokensLock.RLock()
+	defer h.tokensLock.RUnlock()
+
+	token, found := h.tokens[id]
+	if !found || token.ServiceAccount != account || token.IsExpired() {
+		return nil
+	}
+	return []string{token.Role}
+}
+
+func (h *APITokenAPIHandler) cacheToken(id string, token *types.APIToken) {
+	h.tokensLock.Lock()
+	h.tokens[id] = token
+	h.tokensLock.Unlock()
+}
+
+func (h *APITokenAPIHandler) uncacheToken(id string) {
+	h.tokensLock.Lock()
+	delete(h.tokens, id)
+	h.tokensLock.Unlock()
+}
+
+func (h *APITokenAPIHandler) onAPIWatcherEvent(action string, id string, resource types.Resource) {
+	switch action {
+	case "init", "create", "set", "update":
+		h.cacheToken(id, resource.(*types.APIToken))
+	case "expire", "delete":
+		h.uncacheToken(id)
+	}
+}
+
+// Start watching the tokens stored in etcd
+func (h *APITokenAPIHandler) Start() {
+	h.watcher = h.AsyncWatch(h.onAPIWatcherEvent)
+}
+
+// Stop watching the tokens
+func (h *APITokenAPIHandler) Stop() {
+	if h.watcher != nil {
+		h.watcher.Stop()
+	}
+}
+
+// NewAPITokenAPIHandler returns a new API token handler, the handler can be
+// used to validate tokens before being registered to the API server
+func NewAPITokenAPIHandler(kapi etcd.KeysAPI) *APITokenAPIHandler {
+	return &APITokenAPIHandler{
+		BasicAPIHandler: BasicAPIHandler{
+			ResourceHandler: &APITokenResourceHandler{},
+			EtcdKeyAPI:      kapi,
+		},
+		tokens: make(map[string]*types.APIToken),
+	}
+}
+
+// RegisterAPITokenAPI registers the API token API to a designated API Server
+func RegisterAPITokenAPI(apiServer *Server, tokenAPIHandler *APITokenAPIHandler, authBackend shttp.AuthenticationBackend) error {
+	return apiServer.RegisterAPIHandler(tokenAPIHandler, authBackend)
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+
+	"github.com/skydive-project/skydive/api/types"
+	shttp "github.com/skydive-project/skydive/http"
+)
+
+func newTestAPIToken(t *testing.T, h *APITokenAPIHandler, role string) *types.APIToken {
+	token := &types.APIToken{
+		ServiceAccount: "agent",
+		Role:           role,
+		Owner:          "alice",
+		ExpireTime:     time.Now().Add(time.Hour),
+	}
+	if err := h.Create(token, nil); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAPITokenRevoke(t *testing.T) {
+	h := NewAPITokenAPIHandler(newFakeKeysAPI())
+	token := newTestAPIToken(t, h, "admin")
+
+	backend := shttp.NewAPITokenBackend(shttp.NewNoAuthenticationBackend(), h)
+
+	var username string
+	handler := backend.Wrap(func(w http.ResponseWriter, r *auth.AuthenticatedRequest) { username = r.Username })
+
+	call := func() int {
+		username = ""
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api/topology", nil)
+		r.Header.Set("Authorization", "Bearer "+token.Token)
+		handler(w, r)
+		return w.Code
+	}
+
+	if status := call(); status == http.StatusUnauthorized || username != "sa:agent/"+token.ID() {
+		t.Fatalf("A valid token should be accepted, got status %d and user '%s'", status, username)
+	}
+
+	if roles := h.TokenRoles("agent", token.ID()); len(roles) != 1 || roles[0] != "admin" {
+		t.Fatalf("Expected the role of the token to be granted, got %v", roles)
+	}
+
+	if err := h.Delete(token.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := call(); status != http.StatusUnauthorized {
+		t.Errorf("A revoked token should be rejected, got status %d", status)
+	}
+
+	if roles := h.TokenRoles("agent", token.ID()); len(roles) != 0 {
+		t.Errorf("A revoked token should not grant any role, got %v", roles)
+	}
+}
+
+func TestAPITokenExpire(t *testing.T) {
+	h := NewAPITokenAPIHandler(newFakeKeysAPI())
+	token := newTestAPIToken(t, h, "admin")
+
+	if _, _, err := h.ValidateAPIToken(token.Token); err != nil {
+		t.Fatalf("A valid token should be accepted: %s", err)
+	}
+
+	resource, _ := h.Get(token.ID())
+	stored := resource.(*types.APIToken)
+	stored.ExpireTime = time.Now().Add(-time.Minute)
+	if _, err := h.BasicAPIHandler.Update(token.ID(), stored, nil); err != nil {
+		t.Fatal(err)
+	}
+	// as notified by the etcd watcher
+	h.onAPIWatcherEvent("update", token.ID(), stored)
+
+	if _, _, err := h.ValidateAPIToken(token.Token); err == nil {
+		t.Error("An expired token should be rejected")
+	}
+
+	if roles := h.TokenRoles("agent", token.ID()); len(roles) != 0 {
+		t.Errorf("An expired token should not grant any role, got %v", roles)
+	}
+}
+
+func TestAPITokenRoles(t *testing.T) {
+	h := NewAPITokenAPIHandler(newFakeKeysAPI())
+	admin := newTestAPIToken(t, h, "admin")
+	guest := newTestAPIToken(t, h, "guest")
+
+	// the roles of the tokens of an account are not combined
+	if roles := h.TokenRoles("agent", guest.ID()); len(roles) != 1 || roles[0] != "guest" {
+		t.Errorf("Expected only the role of the token to be granted, got %v", roles)
+	}
+
+	if roles := h.TokenRoles("other", admin.ID()); len(roles) != 0 {
+		t.Errorf("A token should not grant any role to another account, got %v", roles)
+	}
+
+	// tokens stored by another analyzer are known from the watcher
+	h = NewAPITokenAPIHandler(h.EtcdKeyAPI)
+	h.Start()
+	defer h.Stop()
+
+	if roles := h.TokenRoles("agent", admin.ID()); len(roles) != 1 || roles[0] != "admin" {
+		t.Errorf("Expected the role of a stored token to be granted, got %v", roles)
+	}
+}
+
+func TestAPITokenOwner(t *testing.T) {
+	h := NewAPITokenAPIHandler(newFakeKeysAPI())
+	newTestAPIToken(t, h, "admin")
+
+	// the owner is set by the server
+	token := &types.APIToken{ServiceAccount: "agent", Role: "guest", Owner: "alice"}
+	if err := h.AuthorizeCreate("bob", token); err != ErrServiceAccountOwner {
+		t.Errorf("A user should not be able to create a token for the account of another user, got %v", err)
+	}
+
+	token = &types.APIToken{ServiceAccount: "agent", Role: "guest"}
+	if err := h.AuthorizeCreate("alice", token); err == ErrServiceAccountOwner || token.Owner != "alice" {
+		t.Errorf("The owner of an account should be able to create tokens for it, got %v and owner '%s'", err, token.Owner)
+	}
+
+	token = &types.APIToken{ServiceAccount: "other", Role: "guest"}
+	if h.AuthorizeCreate("sa:agent/1", token); token.Owner != "sa:agent" {
+		t.Errorf("The tokens created by a service account should be owned by the account, got '%s'", token.Owner)
+	}
+}
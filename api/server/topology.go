@@ -43,6 +43,7 @@ import (
 type TopologyAPI struct {
 	graph         *graph.Graph
 	gremlinParser *traversal.GremlinTraversalParser
+	events        *TopologyEventsAPI
 }
 
 func shortID(s graph.Identifier) graph.Identifier {
@@ -52,6 +53,43 @@ func shortID(s graph.Identifier) graph.Identifier {
 	return s
 }
 
+// rawPacketsToPcapNg writes the raw packets in the pcapng format, with an
+// interface per node the packets were captured on
+func rawPacketsToPcapNg(w io.Writer, g *graph.Graph, step *ge.RawPacketsTraversalStep) error {
+	nodeTIDs, err := step.NodeTIDs()
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]string)
+	g.RLock()
+	for _, tid := range nodeTIDs {
+		if _, found := names[tid]; !found && tid != "" {
+			if node := g.LookupFirstNode(graph.Metadata{"TID": tid}); node != nil {
+				names[tid], _ = node.GetFieldString("Name")
+			}
+		}
+	}
+	g.RUnlock()
+
+	pw, err := flow.NewPcapNgWriter(w)
+	if err != nil {
+		return err
+	}
+
+	for _, pf := range step.Values() {
+		for flowUUID, fr := range pf.(map[string][]*flow.RawPacket) {
+			tid := nodeTIDs[flowUUID]
+			intf := flow.PcapInterface{Name: names[tid], NodeTID: tid}
+			if err := pw.WriteRawPackets(intf, flowUUID, fr); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func (t *TopologyAPI) graphToDot(w io.Writer, g *graph.Graph) {
 	g.RLock()
 	defer g.RUnlock()
@@ -105,6 +143,12 @@ func (t *TopologyAPI) topologyIndex(w http.ResponseWriter, r *auth.Authenticated
 		return
 	}
 
+	g, err := userGraph(t.graph, t.gremlinParser, r.Username, true)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	// use a buffer to render the result in order to limit the lock time
 	// if the client is slow
 	var b bytes.Buffer
@@ -112,17 +156,17 @@ func (t *TopologyAPI) topologyIndex(w http.ResponseWriter, r *auth.Authenticated
 	w.WriteHeader(http.StatusOK)
 	if strings.Contains(r.Header.Get("Accept"), "vnd.graphviz") {
 		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=UTF-8")
-		t.graphToDot(&b, t.graph)
+		t.graphToDot(&b, g)
 	} else {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-		t.graph.RLock()
-		if err := json.NewEncoder(&b).Encode(t.graph); err != nil {
-			t.graph.RUnlock()
+		g.RLock()
+		if err := json.NewEncoder(&b).Encode(g); err != nil {
+			g.RUnlock()
 			writeError(w, http.StatusNotAcceptable, fmt.Errorf("Error while encoding response: %s", err))
 			return
 		}
-		t.graph.RUnlock()
+		g.RUnlock()
 	}
 
 	if _, err := w.Write(b.Bytes()); err != nil {
@@ -139,7 +183,7 @@ func (t *TopologyAPI) topologySearch(w http.ResponseWriter, r *auth.Authenticate
 	resource := types.TopologyParams{}
 	data, _ := ioutil.ReadAll(r.Body)
 	if len(data) != 0 {
-		if err := json.Unmarshal(data, &resource); err != nil {
+		if err := decodeJSON(data, &resource); err != nil {
 			writeError(w, http.StatusBadRequest, err)
 			return
 		}
@@ -154,13 +198,21 @@ func (t *TopologyAPI) topologySearch(w http.ResponseWriter, r *auth.Authenticate
 		return
 	}
 
+	// users belonging to a tenant query the part of the graph their tenant
+	// has access to. Flows are then restricted to the nodes of this graph.
+	g, err := userGraph(t.graph, t.gremlinParser, r.Username, true)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	ts, err := t.gremlinParser.Parse(strings.NewReader(resource.GremlinQuery))
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	res, err := ts.Exec(t.graph, true)
+	res, err := ts.Exec(g, true)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
@@ -179,6 +231,24 @@ func (t *TopologyAPI) topologySearch(w http.ResponseWriter, r *auth.Authenticate
 			writeError(w, http.StatusNotAcceptable, errors.New("Only graph can be outputted as dot"))
 			return
 		}
+	} else if strings.Contains(r.Header.Get("Accept"), "x-pcapng") {
+		if rawPacketsTraversal, ok := res.(*ge.RawPacketsTraversalStep); ok {
+			if len(rawPacketsTraversal.Values()) == 0 {
+				writeError(w, http.StatusNotFound, errors.New("No raw packet found, please check your Gremlin request and the time context"))
+				return
+			}
+
+			if err = rawPacketsToPcapNg(&b, g, rawPacketsTraversal); err != nil {
+				writeError(w, http.StatusNotAcceptable, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/x-pcapng")
+			w.WriteHeader(http.StatusOK)
+		} else {
+			writeError(w, http.StatusNotAcceptable, errors.New("Only RawPackets step result can be outputted as pcapng"))
+			return
+		}
 	} else if strings.Contains(r.Header.Get("Accept"), "vnd.tcpdump.pcap") {
 		if rawPacketsTraversal, ok := res.(*ge.RawPacketsTraversalStep); ok {
 			values := rawPacketsTraversal.Values()
@@ -263,6 +333,7 @@ func (t *TopologyAPI) registerEndpoints(r *shttp.Server, authBackend shttp.Authe
 	// - application/json
 	// - text/vnd.graphviz
 	// - application/vnd.tcpdump.pcap
+	// - application/x-pcapng
 	//
 	// schemes:
 	// - http
@@ -283,6 +354,41 @@ func (t *TopologyAPI) registerEndpoints(r *shttp.Server, authBackend shttp.Authe
 	//   204:
 	//     description: empty query
 
+	// swagger:operation GET /topology/events streamTopologyEvents
+	//
+	// Stream topology events
+	//
+	// ---
+	// summary: Stream topology events using Server-Sent Events
+	//
+	// tags:
+	// - topology
+	//
+	// produces:
+	// - text/event-stream
+	//
+	// schemes:
+	// - http
+	// - https
+	//
+	// parameters:
+	//   - in: query
+	//     name: gremlin
+	//     description: Gremlin filter applied to the streamed events
+	//     type: string
+	//   - in: query
+	//     name: since
+	//     description: ID of the last received event, to resume the stream from
+	//     type: integer
+	//   - in: header
+	//     name: Last-Event-ID
+	//     description: ID of the last received event, to resume the stream from
+	//     type: integer
+	//
+	// responses:
+	//   200:
+	//     description: event stream
+
 	routes := []shttp.Route{
 		{
 			Name:        "TopologiesIndex",
@@ -296,17 +402,80 @@ func (t *TopologyAPI) registerEndpoints(r *shttp.Server, authBackend shttp.Authe
 			Path:        "/api/topology",
 			HandlerFunc: t.topologySearch,
 		},
+		{
+			Name:        "TopologyEvents",
+			Method:      "GET",
+			Path:        "/api/topology/events",
+			HandlerFunc: t.events.topologyEvents,
+		},
+	}
+
+	operations := map[string]*openAPIOperation{
+		"TopologiesIndex": {
+			OperationID: "getTopology",
+			Summary:     "Get topology",
+			Tags:        []string{"Topology"},
+			Responses: map[string]*openAPIResponse{
+				"200": {
+					Description: "Topology",
+					Content: map[string]*openAPIMediaType{
+						"application/json":  {Schema: schemaRef(graph.Elements{})},
+						"text/vnd.graphviz": {Schema: &openAPISchema{Type: "string"}},
+					},
+				},
+			},
+		},
+		"TopologiesSearch": {
+			OperationID: "searchTopology",
+			Summary:     "Search topology with a Gremlin query",
+			Tags:        []string{"Topology"},
+			RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(schemaRef(types.TopologyParams{}))},
+			Responses: map[string]*openAPIResponse{
+				"200": {
+					Description: "Result of the Gremlin query",
+					Content: map[string]*openAPIMediaType{
+						"application/json":             {Schema: &openAPISchema{}},
+						"text/vnd.graphviz":            {Schema: &openAPISchema{Type: "string"}},
+						"application/vnd.tcpdump.pcap": {Schema: &openAPISchema{Type: "string", Format: "binary"}},
+						"application/x-pcapng":         {Schema: &openAPISchema{Type: "string", Format: "binary"}},
+					},
+				},
+				"204": {Description: "Empty query"},
+				"400": {Description: "Invalid Gremlin query"},
+				"406": {Description: "Result can not be returned using the requested format"},
+			},
+		},
+		"TopologyEvents": {
+			OperationID: "getTopologyEvents",
+			Summary:     "Stream the topology events",
+			Tags:        []string{"Topology"},
+			Parameters: []*openAPIParameter{
+				{Name: "gremlin", In: "query", Description: "Gremlin filter of the events", Schema: &openAPISchema{Type: "string"}},
+				{Name: "since", In: "query", Description: "ID of the last received event", Schema: &openAPISchema{Type: "integer", Format: "int64"}},
+				{Name: "Last-Event-ID", In: "header", Description: "ID of the last received event", Schema: &openAPISchema{Type: "integer", Format: "int64"}},
+			},
+			Responses: map[string]*openAPIResponse{
+				"200": {
+					Description: "Server-Sent Events stream",
+					Content:     map[string]*openAPIMediaType{"text/event-stream": {Schema: &openAPISchema{Type: "string"}}},
+				},
+				"400": {Description: "Invalid filter or event ID"},
+			},
+		},
 	}
 
-	r.RegisterRoutes(routes, authBackend)
+	registerAPIRoutes(r, routes, operations, authBackend)
 }
 
 // RegisterTopologyAPI registers a new topology query API
-func RegisterTopologyAPI(r *shttp.Server, g *graph.Graph, parser *traversal.GremlinTraversalParser, authBackend shttp.AuthenticationBackend) {
+func RegisterTopologyAPI(r *shttp.Server, g *graph.Graph, parser *traversal.GremlinTraversalParser, authBackend shttp.AuthenticationBackend, eventsHistorySize int) *TopologyAPI {
 	t := &TopologyAPI{
 		gremlinParser: parser,
 		graph:         g,
+		events:        NewTopologyEventsAPI(g, parser, eventsHistorySize),
 	}
 
 	t.registerEndpoints(r, authBackend)
+
+	return t
 }
@@ -0,0 +1,522 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/graffiti/graph/traversal"
+	gws "github.com/skydive-project/skydive/graffiti/websocket"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+const (
+	eventsClientQueueSize = 1000
+	eventsKeepAliveDelay  = 15 * time.Second
+	eventsFilterDelay     = 100 * time.Millisecond
+)
+
+// topologyEvent describes a graph modification as streamed to the
+// event subscribers. The element is serialized when the event occurs
+// as the graph element may be modified later on.
+type topologyEvent struct {
+	id        int64
+	typ       string
+	elementID graph.Identifier
+	data      []byte
+}
+
+// eventsClient describes a client connected to the event stream. The graph
+// modifications are only recorded for the filtered clients, the filters being
+// evaluated later on by a dedicated goroutine, outside of the graph listeners.
+type eventsClient struct {
+	common.RWMutex
+	gremlinFilter string
+	ts            *traversal.GremlinTraversalSequence
+	scope         *traversal.GremlinTraversalSequence
+	graph         *graph.Graph
+	events        chan *topologyEvent
+	overflow      chan struct{}
+	lastID        int64
+	updated       []*topologyEvent
+	pending       chan struct{}
+	quit          chan struct{}
+}
+
+// TopologyEventsAPI streams the topology modifications using Server-Sent Events.
+// Without history, the graph events are only listened to while clients are
+// connected so that the graph modifications cost nothing otherwise.
+type TopologyEventsAPI struct {
+	common.RWMutex
+	graph.DefaultGraphListener
+	graph         *graph.Graph
+	gremlinParser *traversal.GremlinTraversalParser
+	clients       map[*eventsClient]bool
+	history       []*topologyEvent
+	historySize   int
+	lastID        int64
+	listenerLock  sync.Mutex
+	listeners     int
+}
+
+func formatEvent(id int64, typ string, data []byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "id: %d\nevent: %s\ndata: ", id, typ)
+	b.Write(data)
+	b.WriteString("\n\n")
+	return b.Bytes()
+}
+
+func (c *eventsClient) send(ev *topologyEvent) {
+	select {
+	case c.events <- ev:
+	default:
+		// the client is too slow, close its stream so that it can
+		// reconnect and resume from the last event it received
+		select {
+		case c.overflow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (c *eventsClient) sendElement(id int64, typ string, i interface{}) {
+	data, err := json.Marshal(i)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to marshal topology event: %s", err)
+		return
+	}
+	c.send(&topologyEvent{id: id, typ: typ, data: data})
+}
+
+func (c *eventsClient) sendSync(id int64) {
+	c.sendElement(id, gws.SyncMsgType, c.graph.Elements())
+}
+
+// isFiltered returns whether the client only sees a part of the graph, either
+// because of its Gremlin filter or because of the tenant of its user
+func (c *eventsClient) isFiltered() bool {
+	return c.ts != nil || c.scope != nil
+}
+
+// getGraph returns the part of the graph seen by a client, the tenant
+// filter being applied first
+func (t *TopologyEventsAPI) getGraph(c *eventsClient) (g *graph.Graph, err error) {
+	g = t.graph
+	if c.scope != nil {
+		if g, err = c.scope.ExecGraph(g, false); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.ts != nil {
+		if g, err = c.ts.ExecGraph(g, false); err != nil {
+			return nil, fmt.Errorf("Invalid Gremlin filter '%s': %s", c.gremlinFilter, err)
+		}
+	}
+
+	return g, nil
+}
+
+// record keeps track of a graph modification for a filtered client and
+// wakes up its filtering goroutine
+func (c *eventsClient) record(ev *topologyEvent) {
+	c.Lock()
+	c.lastID = ev.id
+	if ev.typ == gws.NodeUpdatedMsgType || ev.typ == gws.EdgeUpdatedMsgType {
+		if len(c.updated) >= eventsClientQueueSize {
+			// the filters can't keep up, close the stream
+			c.Unlock()
+			select {
+			case c.overflow <- struct{}{}:
+			default:
+			}
+			return
+		}
+		c.updated = append(c.updated, ev)
+	}
+	c.Unlock()
+
+	select {
+	case c.pending <- struct{}{}:
+	default:
+	}
+}
+
+// refresh sends to a filtered client the updates of the elements it sees and
+// the difference between the graph it previously saw and the current state
+// of the filtered graph
+func (t *TopologyEventsAPI) refresh(c *eventsClient) {
+	// the modifications are recorded with the graph lock held, taking it
+	// first ensures that they are consistent with the evaluated graph
+	t.graph.RLock()
+	defer t.graph.RUnlock()
+
+	c.Lock()
+	lastID, updated := c.lastID, c.updated
+	c.updated = nil
+	c.Unlock()
+
+	g, err := t.getGraph(c)
+	if err != nil {
+		logging.GetLogger().Error(err)
+		return
+	}
+
+	for _, ev := range updated {
+		switch ev.typ {
+		case gws.NodeUpdatedMsgType:
+			if c.graph.GetNode(ev.elementID) != nil && g.GetNode(ev.elementID) != nil {
+				c.send(ev)
+			}
+		case gws.EdgeUpdatedMsgType:
+			if c.graph.GetEdge(ev.elementID) != nil && g.GetEdge(ev.elementID) != nil {
+				c.send(ev)
+			}
+		}
+	}
+
+	addedNodes, removedNodes, addedEdges, removedEdges := c.graph.Diff(g)
+
+	for _, n := range addedNodes {
+		c.sendElement(lastID, gws.NodeAddedMsgType, n)
+	}
+
+	for _, n := range removedNodes {
+		c.sendElement(lastID, gws.NodeDeletedMsgType, n)
+	}
+
+	for _, e := range addedEdges {
+		c.sendElement(lastID, gws.EdgeAddedMsgType, e)
+	}
+
+	for _, e := range removedEdges {
+		c.sendElement(lastID, gws.EdgeDeletedMsgType, e)
+	}
+
+	c.graph = g
+}
+
+// filter evaluates the filters of a client when the graph was modified. The
+// evaluations are delayed so that a burst of modifications only triggers one.
+func (t *TopologyEventsAPI) filter(c *eventsClient) {
+	for {
+		select {
+		case <-c.pending:
+		case <-c.quit:
+			return
+		}
+
+		select {
+		case <-time.After(eventsFilterDelay):
+		case <-c.quit:
+			return
+		}
+
+		t.refresh(c)
+	}
+}
+
+// notifyClients records a graph modification and forwards it to the clients.
+// As it is called with the graph lock held, the filters are not evaluated here.
+func (t *TopologyEventsAPI) notifyClients(typ string, id graph.Identifier, i interface{}) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.lastID++
+
+	if t.historySize <= 0 && len(t.clients) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(i)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to marshal topology event: %s", err)
+		return
+	}
+
+	ev := &topologyEvent{id: t.lastID, typ: typ, elementID: id, data: data}
+
+	if t.historySize > 0 {
+		t.history = append(t.history, ev)
+		if len(t.history) > t.historySize {
+			t.history = t.history[len(t.history)-t.historySize:]
+		}
+	}
+
+	for c := range t.clients {
+		if c.isFiltered() {
+			c.record(ev)
+		} else {
+			c.send(ev)
+		}
+	}
+}
+
+// OnNodeUpdated graph node updated event. Implements the EventListener interface.
+func (t *TopologyEventsAPI) OnNodeUpdated(n *graph.Node) {
+	t.notifyClients(gws.NodeUpdatedMsgType, n.ID, n)
+}
+
+// OnNodeAdded graph node added event. Implements the EventListener interface.
+func (t *TopologyEventsAPI) OnNodeAdded(n *graph.Node) {
+	t.notifyClients(gws.NodeAddedMsgType, n.ID, n)
+}
+
+// OnNodeDeleted graph node deleted event. Implements the EventListener interface.
+func (t *TopologyEventsAPI) OnNodeDeleted(n *graph.Node) {
+	t.notifyClients(gws.NodeDeletedMsgType, n.ID, n)
+}
+
+// OnEdgeUpdated graph edge updated event. Implements the EventListener interface.
+func (t *TopologyEventsAPI) OnEdgeUpdated(e *graph.Edge) {
+	t.notifyClients(gws.EdgeUpdatedMsgType, e.ID, e)
+}
+
+// OnEdgeAdded graph edge added event. Implements the EventListener interface.
+func (t *TopologyEventsAPI) OnEdgeAdded(e *graph.Edge) {
+	t.notifyClients(gws.EdgeAddedMsgType, e.ID, e)
+}
+
+// OnEdgeDeleted graph edge deleted event. Implements the EventListener interface.
+func (t *TopologyEventsAPI) OnEdgeDeleted(e *graph.Edge) {
+	t.notifyClients(gws.EdgeDeletedMsgType, e.ID, e)
+}
+
+// listen registers the graph listener if it is not registered for the
+// history or for another client
+func (t *TopologyEventsAPI) listen() {
+	t.listenerLock.Lock()
+	defer t.listenerLock.Unlock()
+
+	if t.listeners == 0 && t.historySize <= 0 {
+		t.graph.AddEventListener(t)
+	}
+	t.listeners++
+}
+
+// unlisten removes the graph listener once the last client is gone,
+// unless the history is recorded
+func (t *TopologyEventsAPI) unlisten() {
+	t.listenerLock.Lock()
+	defer t.listenerLock.Unlock()
+
+	t.listeners--
+	if t.listeners == 0 && t.historySize <= 0 {
+		t.graph.RemoveEventListener(t)
+	}
+}
+
+// resumeFrom returns the events that occurred after the given event ID.
+// The boolean is false if the history doesn't cover the requested event anymore.
+func (t *TopologyEventsAPI) resumeFrom(id int64) ([]*topologyEvent, bool) {
+	if id > t.lastID {
+		// the event ID comes from a previous instance of the analyzer
+		return nil, false
+	}
+
+	if len(t.history) == 0 || id < t.history[0].id-1 {
+		return nil, id == t.lastID
+	}
+
+	return t.history[id-t.history[0].id+1:], true
+}
+
+// subscribe registers a new client. If lastEventID is positive and still part
+// of the history, the events that occurred after it are replayed, otherwise
+// the client receives a first Sync event with the current state of the graph.
+// The tenant filter, if any, restricts the part of the graph the client has
+// access to.
+func (t *TopologyEventsAPI) subscribe(gremlinFilter string, tenantFilter string, lastEventID int64) (*eventsClient, error) {
+	c := &eventsClient{
+		gremlinFilter: gremlinFilter,
+		events:        make(chan *topologyEvent, eventsClientQueueSize),
+		overflow:      make(chan struct{}, 1),
+	}
+
+	if gremlinFilter != "" {
+		ts, err := t.gremlinParser.Parse(strings.NewReader(gremlinFilter))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Gremlin filter '%s': %s", gremlinFilter, err)
+		}
+		c.ts = ts
+	}
+
+	if tenantFilter != "" {
+		scope, err := t.gremlinParser.Parse(strings.NewReader(tenantFilter))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid tenant filter '%s': %s", tenantFilter, err)
+		}
+		c.scope = scope
+	}
+
+	// the listener is registered before the graph is read so that no
+	// modification is missed
+	t.listen()
+
+	t.graph.RLock()
+	defer t.graph.RUnlock()
+
+	g, err := t.getGraph(c)
+	if err != nil {
+		t.unlisten()
+		return nil, err
+	}
+	c.graph = g
+
+	t.Lock()
+	defer t.Unlock()
+
+	// events can only be replayed as is for unfiltered clients as we don't
+	// know which elements were matching the filter at the time of the events.
+	// Filtered clients get a new Sync event instead.
+	var replayed bool
+	if lastEventID > 0 && !c.isFiltered() && t.historySize > 0 {
+		var events []*topologyEvent
+		if events, replayed = t.resumeFrom(lastEventID); replayed {
+			for _, ev := range events {
+				c.send(ev)
+			}
+		}
+	}
+
+	if !replayed {
+		c.sendSync(t.lastID)
+	}
+
+	if c.isFiltered() {
+		c.lastID = t.lastID
+		c.pending = make(chan struct{}, 1)
+		c.quit = make(chan struct{})
+		go t.filter(c)
+	} else {
+		// the unfiltered graph must not be kept by the client
+		c.graph = nil
+	}
+
+	t.clients[c] = true
+
+	return c, nil
+}
+
+func (t *TopologyEventsAPI) unsubscribe(c *eventsClient) {
+	t.Lock()
+	delete(t.clients, c)
+	t.Unlock()
+
+	t.unlisten()
+
+	if c.quit != nil {
+		close(c.quit)
+	}
+}
+
+func (t *TopologyEventsAPI) topologyEvents(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if !rbac.Enforce(r.Username, "topology", "read") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusNotAcceptable, errors.New("Streaming not supported"))
+		return
+	}
+
+	var lastEventID int64
+	lastEventIDStr := r.Header.Get("Last-Event-ID")
+	if lastEventIDStr == "" {
+		lastEventIDStr = r.URL.Query().Get("since")
+	}
+	if lastEventIDStr != "" {
+		var err error
+		if lastEventID, err = strconv.ParseInt(lastEventIDStr, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid event ID: %s", err))
+			return
+		}
+	}
+
+	c, err := t.subscribe(r.URL.Query().Get("gremlin"), rbac.GetUserFilter(r.Username), lastEventID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer t.unsubscribe(c)
+
+	logging.GetLogger().Debugf("Client %s subscribed to topology events with filter '%s'", r.RemoteAddr, c.gremlinFilter)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAliveDelay)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case ev := <-c.events:
+			if _, err := w.Write(formatEvent(ev.id, ev.typ, ev.data)); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(":\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-c.overflow:
+			logging.GetLogger().Warningf("Client %s too slow, closing its topology event stream", r.RemoteAddr)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// NewTopologyEventsAPI returns a new topology event stream API. The last
+// historySize events are kept to allow the clients to resume their stream,
+// no history being kept if not positive.
+func NewTopologyEventsAPI(g *graph.Graph, parser *traversal.GremlinTraversalParser, historySize int) *TopologyEventsAPI {
+	t := &TopologyEventsAPI{
+		graph:         g,
+		gremlinParser: parser,
+		clients:       make(map[*eventsClient]bool),
+		historySize:   historySize,
+	}
+
+	if historySize > 0 {
+		g.AddEventListener(t)
+	}
+
+	return t
+}
@@ -0,0 +1,391 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/graffiti/graph/traversal"
+	gws "github.com/skydive-project/skydive/graffiti/websocket"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+type testEvent struct {
+	id   string
+	typ  string
+	data string
+}
+
+func newTestEventsAPI(t *testing.T) (*graph.Graph, *TopologyEventsAPI) {
+	return newTestEventsAPIWithHistory(t, 10)
+}
+
+func newTestEventsAPIWithHistory(t *testing.T, historySize int) (*graph.Graph, *TopologyEventsAPI) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := graph.NewGraph("testhost", b, common.UnknownService)
+	return g, NewTopologyEventsAPI(g, traversal.NewGremlinTraversalParser(), historySize)
+}
+
+func openEventStream(t *testing.T, api *TopologyEventsAPI, username string, query string, lastEventID string) (chan *testEvent, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.topologyEvents(w, &auth.AuthenticatedRequest{Request: *r, Username: username})
+	}))
+
+	req, err := http.NewRequest("GET", server.URL+"/api/topology/events?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d", resp.StatusCode)
+	}
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Unexpected content type: %s", ct)
+	}
+
+	events := make(chan *testEvent, 100)
+	go func() {
+		defer close(events)
+
+		ev := &testEvent{}
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if ev.typ != "" {
+					events <- ev
+				}
+				ev = &testEvent{}
+			case strings.HasPrefix(line, "id: "):
+				ev.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				ev.typ = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+
+	return events, func() {
+		resp.Body.Close()
+		server.Close()
+	}
+}
+
+func nextEvent(t *testing.T, events chan *testEvent) *testEvent {
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("Event stream closed")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout while waiting for an event")
+	}
+	return nil
+}
+
+func noEvent(t *testing.T, events chan *testEvent) {
+	select {
+	case ev := <-events:
+		t.Fatalf("Unexpected event %s: %s", ev.typ, ev.data)
+	case <-time.After(5 * eventsFilterDelay):
+	}
+}
+
+func eventNode(t *testing.T, ev *testEvent) *graph.Node {
+	var n graph.Node
+	if err := json.Unmarshal([]byte(ev.data), &n); err != nil {
+		t.Fatalf("Unable to decode node from event %s: %s", ev.typ, err)
+	}
+	return &n
+}
+
+func eventElements(t *testing.T, ev *testEvent) *graph.Elements {
+	var elements graph.Elements
+	if err := json.Unmarshal([]byte(ev.data), &elements); err != nil {
+		t.Fatalf("Unable to decode elements from event %s: %s", ev.typ, err)
+	}
+	return &elements
+}
+
+func TestTopologyEventsStream(t *testing.T) {
+	g, api := newTestEventsAPI(t)
+
+	g.Lock()
+	n1, _ := g.NewNode(graph.GenID(), graph.Metadata{"Name": "n1"})
+	g.Unlock()
+
+	events, closeStream := openEventStream(t, api, "admin", "", "")
+
+	ev := nextEvent(t, events)
+	if ev.typ != gws.SyncMsgType {
+		t.Fatalf("Expected a %s event, got %s", gws.SyncMsgType, ev.typ)
+	}
+	if elements := eventElements(t, ev); len(elements.Nodes) != 1 || elements.Nodes[0].ID != n1.ID {
+		t.Fatalf("Unexpected Sync content: %s", ev.data)
+	}
+
+	g.Lock()
+	n2, _ := g.NewNode(graph.GenID(), graph.Metadata{"Name": "n2"})
+	g.AddMetadata(n1, "Type", "device")
+	g.Unlock()
+
+	ev = nextEvent(t, events)
+	if ev.typ != gws.NodeAddedMsgType || eventNode(t, ev).ID != n2.ID {
+		t.Fatalf("Expected the addition of n2, got %s: %s", ev.typ, ev.data)
+	}
+	addedID := ev.id
+
+	ev = nextEvent(t, events)
+	if ev.typ != gws.NodeUpdatedMsgType || eventNode(t, ev).ID != n1.ID {
+		t.Fatalf("Expected the update of n1, got %s: %s", ev.typ, ev.data)
+	}
+
+	closeStream()
+
+	// resume from the addition of n2, only the update of n1 should be replayed
+	events, closeStream = openEventStream(t, api, "admin", "", addedID)
+	defer closeStream()
+
+	ev = nextEvent(t, events)
+	if ev.typ != gws.NodeUpdatedMsgType || eventNode(t, ev).ID != n1.ID {
+		t.Fatalf("Expected the replay of the update of n1, got %s: %s", ev.typ, ev.data)
+	}
+
+	// an unknown event ID leads to a new Sync
+	events2, closeStream2 := openEventStream(t, api, "admin", "", "1000")
+	defer closeStream2()
+
+	if ev = nextEvent(t, events2); ev.typ != gws.SyncMsgType {
+		t.Fatalf("Expected a %s event, got %s", gws.SyncMsgType, ev.typ)
+	}
+}
+
+func TestTopologyEventsNoHistory(t *testing.T) {
+	g, api := newTestEventsAPIWithHistory(t, 0)
+
+	lastID := func() int64 {
+		api.RLock()
+		defer api.RUnlock()
+		return api.lastID
+	}
+
+	// without client, the graph is not listened to
+	g.Lock()
+	g.NewNode(graph.GenID(), graph.Metadata{"Name": "n1"})
+	g.Unlock()
+
+	if id := lastID(); id != 0 {
+		t.Fatalf("Expected no event to be handled without client, got %d", id)
+	}
+
+	events, closeStream := openEventStream(t, api, "admin", "", "")
+
+	if ev := nextEvent(t, events); ev.typ != gws.SyncMsgType {
+		t.Fatalf("Expected a %s event, got %s", gws.SyncMsgType, ev.typ)
+	}
+
+	g.Lock()
+	g.NewNode(graph.GenID(), graph.Metadata{"Name": "n2"})
+	g.Unlock()
+
+	ev := nextEvent(t, events)
+	if ev.typ != gws.NodeAddedMsgType {
+		t.Fatalf("Expected a %s event, got %s", gws.NodeAddedMsgType, ev.typ)
+	}
+
+	api.RLock()
+	historyLen := len(api.history)
+	api.RUnlock()
+	if historyLen != 0 {
+		t.Fatalf("Expected no history, got %d events", historyLen)
+	}
+
+	closeStream()
+
+	// wait for the stream handler to unsubscribe
+	for i := 0; ; i++ {
+		api.listenerLock.Lock()
+		listeners := api.listeners
+		api.listenerLock.Unlock()
+		if listeners == 0 {
+			break
+		}
+		if i == 50 {
+			t.Fatal("Client not unsubscribed")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	g.Lock()
+	g.NewNode(graph.GenID(), graph.Metadata{"Name": "n3"})
+	g.Unlock()
+
+	if id := lastID(); id != 1 {
+		t.Fatalf("Expected the listener to be removed with the last client, got event %d", id)
+	}
+
+	// streams can not be resumed without history
+	events, closeStream = openEventStream(t, api, "admin", "", ev.id)
+	defer closeStream()
+
+	if ev := nextEvent(t, events); ev.typ != gws.SyncMsgType {
+		t.Fatalf("Expected a %s event, got %s", gws.SyncMsgType, ev.typ)
+	}
+}
+
+func TestTopologyEventsFilter(t *testing.T) {
+	g, api := newTestEventsAPI(t)
+
+	events, closeStream := openEventStream(t, api, "admin", "gremlin=G.V().Has('Type','device')", "")
+	defer closeStream()
+
+	if ev := nextEvent(t, events); ev.typ != gws.SyncMsgType {
+		t.Fatalf("Expected a %s event, got %s", gws.SyncMsgType, ev.typ)
+	}
+
+	g.Lock()
+	n1, _ := g.NewNode(graph.GenID(), graph.Metadata{"Type": "netns"})
+	g.Unlock()
+
+	noEvent(t, events)
+
+	// the node enters the filtered graph
+	g.Lock()
+	g.AddMetadata(n1, "Type", "device")
+	g.Unlock()
+
+	ev := nextEvent(t, events)
+	if ev.typ != gws.NodeAddedMsgType || eventNode(t, ev).ID != n1.ID {
+		t.Fatalf("Expected the addition of n1, got %s: %s", ev.typ, ev.data)
+	}
+
+	g.Lock()
+	g.AddMetadata(n1, "Name", "eth0")
+	g.Unlock()
+
+	ev = nextEvent(t, events)
+	if ev.typ != gws.NodeUpdatedMsgType || eventNode(t, ev).ID != n1.ID {
+		t.Fatalf("Expected the update of n1, got %s: %s", ev.typ, ev.data)
+	}
+
+	// the node leaves the filtered graph
+	g.Lock()
+	g.AddMetadata(n1, "Type", "netns")
+	g.Unlock()
+
+	ev = nextEvent(t, events)
+	if ev.typ != gws.NodeDeletedMsgType || eventNode(t, ev).ID != n1.ID {
+		t.Fatalf("Expected the deletion of n1, got %s: %s", ev.typ, ev.data)
+	}
+
+	noEvent(t, events)
+}
+
+func TestTopologyEventsFilterBurst(t *testing.T) {
+	g, api := newTestEventsAPI(t)
+
+	c, err := api.subscribe("G.V().Has('Type','device')", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer api.unsubscribe(c)
+
+	if ev := <-c.events; ev.typ != gws.SyncMsgType {
+		t.Fatalf("Expected a %s event, got %s", gws.SyncMsgType, ev.typ)
+	}
+
+	// the modifications are only recorded while the graph is locked,
+	// the filter being evaluated once for the whole burst
+	g.Lock()
+	for i := 0; i < 100; i++ {
+		g.NewNode(graph.GenID(), graph.Metadata{"Type": "device"})
+	}
+	g.Unlock()
+
+	for i := 0; i < 100; i++ {
+		select {
+		case ev := <-c.events:
+			if ev.typ != gws.NodeAddedMsgType {
+				t.Fatalf("Expected a %s event, got %s", gws.NodeAddedMsgType, ev.typ)
+			}
+			if ev.id != 100 {
+				t.Fatalf("Expected the events to have the ID of the last modification, got %d", ev.id)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout while waiting for event %d", i)
+		}
+	}
+}
+
+func TestTopologyEventsTenant(t *testing.T) {
+	initPcapTestTenants(t)
+	defer rbac.InitTenants(nil, nil)
+
+	g, api := newTestEventsAPI(t)
+
+	g.Lock()
+	g.NewNode(graph.GenID(), graph.Metadata{"Tenant": "a"})
+	g.Unlock()
+
+	events, closeStream := openEventStream(t, api, "bob", "", "")
+	defer closeStream()
+
+	ev := nextEvent(t, events)
+	if elements := eventElements(t, ev); ev.typ != gws.SyncMsgType || len(elements.Nodes) != 0 {
+		t.Fatalf("Expected an empty Sync, got %s: %s", ev.typ, ev.data)
+	}
+
+	g.Lock()
+	g.NewNode(graph.GenID(), graph.Metadata{"Tenant": "a"})
+	nb, _ := g.NewNode(graph.GenID(), graph.Metadata{"Tenant": "b"})
+	g.Unlock()
+
+	ev = nextEvent(t, events)
+	if ev.typ != gws.NodeAddedMsgType || eventNode(t, ev).ID != nb.ID {
+		t.Fatalf("Expected the addition of the node of the tenant, got %s: %s", ev.typ, ev.data)
+	}
+
+	noEvent(t, events)
+}
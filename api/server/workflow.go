@@ -66,6 +66,20 @@ func (w *WorkflowAPIHandler) Create(r types.Resource, opts *CreateOptions) error
 	return w.BasicAPIHandler.Create(workflow, opts)
 }
 
+// Update tests whether the updated workflow name is not used by an other workflow
+func (w *WorkflowAPIHandler) Update(id string, r types.Resource, opts *UpdateOptions) (uint64, error) {
+	workflow := r.(*types.Workflow)
+
+	for resourceID, resource := range w.Index() {
+		w := resource.(*types.Workflow)
+		if resourceID != id && w.Name == workflow.Name {
+			return 0, fmt.Errorf("Duplicate workflow, name=%s", w.Name)
+		}
+	}
+
+	return w.BasicAPIHandler.Update(id, workflow, opts)
+}
+
 func (w *WorkflowAPIHandler) loadWorkflowAsset(name string) (*types.Workflow, error) {
 	yml, err := statics.Asset(name)
 	if err != nil {
@@ -20,6 +20,7 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -47,9 +48,14 @@ func (wc *WorkflowCallAPIHandler) executeWorkflow(w http.ResponseWriter, r *auth
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
 	var wfCall types.WorkflowCall
-	if err := decoder.Decode(&wfCall); err != nil {
+	if err := decodeJSON(data, &wfCall); err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
@@ -62,7 +68,23 @@ func (wc *WorkflowCallAPIHandler) executeWorkflow(w http.ResponseWriter, r *auth
 		return
 	}
 
-	ottoResult, err := wc.runtime.ExecFunction(workflow.Source, wfCall.Params...)
+	if !isResourceVisible(r.Username, workflow) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	runtime, err := wc.userRuntime(r.Username)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if runtime != wc.runtime {
+		defer runtime.Stop()
+	}
+
+	auditRequest(r, "call", "workflow", vars["ID"], auditObject(wfCall))
+
+	ottoResult, err := runtime.ExecFunction(workflow.Source, wfCall.Params...)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
@@ -80,6 +102,22 @@ func (wc *WorkflowCallAPIHandler) executeWorkflow(w http.ResponseWriter, r *auth
 	}
 }
 
+// userRuntime returns the runtime to execute the workflows of a user. The
+// users belonging to a tenant get their own runtime, restricted to the graph
+// and the resources of their tenant.
+func (wc *WorkflowCallAPIHandler) userRuntime(username string) (*js.Runtime, error) {
+	if rbac.GetUserTenant(username) == nil {
+		return wc.runtime, nil
+	}
+
+	g, err := userGraph(wc.graph, wc.parser, username, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return newUserWorkflowRuntime(g, wc.parser, wc.apiServer, username)
+}
+
 func (wc *WorkflowCallAPIHandler) getWorkflow(id string) (*types.Workflow, error) {
 	handler := wc.apiServer.GetHandler("workflow")
 	workflow, ok := handler.Get(id)
@@ -140,7 +178,21 @@ func (wc *WorkflowCallAPIHandler) registerEndPoints(s *shttp.Server, authBackend
 		},
 	}
 
-	s.RegisterRoutes(routes, authBackend)
+	operations := map[string]*openAPIOperation{
+		"WorkflowCall": {
+			OperationID: "callWorkflow",
+			Summary:     "Call a workflow",
+			Tags:        []string{"Workflows"},
+			RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(schemaRef(types.WorkflowCall{}))},
+			Responses: map[string]*openAPIResponse{
+				"200": {Description: "Result of the workflow", Content: jsonContent(&openAPISchema{})},
+				"400": {Description: "Workflow error"},
+				"404": {Description: "Workflow not found"},
+			},
+		},
+	}
+
+	registerAPIRoutes(s, routes, operations, authBackend)
 }
 
 // RegisterWorkflowCallAPI registers a new workflow  call api handler
@@ -32,6 +32,13 @@ import (
 
 // NewWorkflowRuntime returns a new Workflow runtime
 func NewWorkflowRuntime(g *graph.Graph, tr *traversal.GremlinTraversalParser, server *Server) (*js.Runtime, error) {
+	return newUserWorkflowRuntime(g, tr, server, "")
+}
+
+// newUserWorkflowRuntime returns a Workflow runtime only giving access to
+// the resources of the tenant of a user. The graph has to be the part of
+// the topology the user has access to.
+func newUserWorkflowRuntime(g *graph.Graph, tr *traversal.GremlinTraversalParser, server *Server, username string) (*js.Runtime, error) {
 	runtime, err := js.NewRuntime()
 	if err != nil {
 		return nil, err
@@ -105,6 +112,7 @@ func NewWorkflowRuntime(g *graph.Graph, tr *traversal.GremlinTraversalParser, se
 			if err := json.Unmarshal([]byte(data), res); err != nil {
 				return runtime.MakeCustomError("UnmarshalError", err.Error())
 			}
+			setResourceTenant(username, res)
 			if err := handler.Create(res, nil); err != nil {
 				return runtime.MakeCustomError("CreateError", err.Error())
 			}
@@ -115,17 +123,24 @@ func NewWorkflowRuntime(g *graph.Graph, tr *traversal.GremlinTraversalParser, se
 			if len(subs) < 4 {
 				return runtime.MakeCustomError("WrongArgument", "No ID specified")
 			}
-			handler.Delete(subs[3])
+			if obj, found := handler.Get(subs[3]); found && isResourceVisible(username, obj) {
+				handler.Delete(subs[3])
+			}
 
 		case "GET":
 			if len(subs) < 4 {
 				resources := handler.Index()
+				for id, obj := range resources {
+					if !isResourceVisible(username, obj) {
+						delete(resources, id)
+					}
+				}
 				b, _ := json.Marshal(resources)
 				content = string(b)
 			} else {
 				id := subs[3]
 				obj, found := handler.Get(id)
-				if !found {
+				if !found || !isResourceVisible(username, obj) {
 					return runtime.MakeCustomError("NotFound", fmt.Sprintf("%s %s could not be found", resource, id))
 				}
 				b, _ := json.Marshal(obj)
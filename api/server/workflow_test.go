@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	auth "github.com/abbot/go-http-auth"
+
+	"github.com/skydive-project/skydive/api/types"
+)
+
+func newTestWorkflowHandler(t *testing.T) (*WorkflowAPIHandler, string) {
+	handler := &WorkflowAPIHandler{
+		BasicAPIHandler: BasicAPIHandler{
+			ResourceHandler: &WorkflowResourceHandler{},
+			EtcdKeyAPI:      newFakeKeysAPI(),
+		},
+	}
+
+	workflow := &types.Workflow{Name: "wf", Title: "Workflow", Source: "function() {}"}
+	if err := handler.Create(workflow, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return handler, workflow.ID()
+}
+
+func updateWorkflow(handler Handler, method, id, contentType, ifMatch string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+
+	req := httptest.NewRequest(method, "/api/workflow/"+id, bytes.NewReader(data))
+	req.Header.Set("Content-Type", contentType)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+
+	w := httptest.NewRecorder()
+	updateHandlerFunc(handler, method == "PATCH")(w, &auth.AuthenticatedRequest{Request: *req, Username: "admin"})
+	return w
+}
+
+func TestWorkflowUpdateStaleETag(t *testing.T) {
+	handler, id := newTestWorkflowHandler(t)
+
+	_, revision, err := handler.GetWithRevision(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := fmt.Sprintf(`"%d"`, revision)
+
+	workflow := &types.Workflow{Name: "wf", Title: "Updated", Source: "function() {}"}
+
+	w := updateWorkflow(handler, "PUT", id, "application/json", etag, workflow)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the update to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if newETag := w.Header().Get("ETag"); newETag == "" || newETag == etag {
+		t.Errorf("Expected a new ETag, got %s", newETag)
+	}
+
+	// the workflow was modified since the ETag
+	workflow.Title = "Stale"
+	if w = updateWorkflow(handler, "PUT", id, "application/json", etag, workflow); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected a precondition failure, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if resource, _ := handler.Get(id); resource.(*types.Workflow).Title != "Updated" {
+		t.Errorf("Workflow modified by a stale update: %+v", resource)
+	}
+}
+
+func TestWorkflowMergePatch(t *testing.T) {
+	handler, id := newTestWorkflowHandler(t)
+
+	w := updateWorkflow(handler, "PATCH", id, "application/merge-patch+json", "", map[string]interface{}{"Title": "Patched"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the patch to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var workflow types.Workflow
+	if err := json.Unmarshal(w.Body.Bytes(), &workflow); err != nil {
+		t.Fatal(err)
+	}
+	if workflow.Title != "Patched" || workflow.Name != "wf" || workflow.Source != "function() {}" {
+		t.Errorf("Expected only the title to be patched, got %+v", workflow)
+	}
+
+	// the patch is checked against the ETag too
+	etag := w.Header().Get("ETag")
+	if w = updateWorkflow(handler, "PATCH", id, "application/merge-patch+json", etag, map[string]interface{}{"Title": "Again"}); w.Code != http.StatusOK {
+		t.Fatalf("Expected the patch to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w = updateWorkflow(handler, "PATCH", id, "application/merge-patch+json", etag, map[string]interface{}{"Title": "Stale"}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected a precondition failure, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -40,6 +40,14 @@ type Resource interface {
 	GetName() string
 }
 
+// TenantResource is a resource owned by a tenant. Users belonging to
+// a tenant can only manage the resources of their tenant.
+type TenantResource interface {
+	Resource
+	GetTenant() string
+	SetTenant(string)
+}
+
 // BasicResource is a resource with a unique identifier
 // easyjson:json
 // swagger:ignore
@@ -84,6 +92,8 @@ type Alert struct {
 	// Event that triggers the alert evaluation
 	Trigger    string `json:",omitempty" valid:"regexp=^(graph|duration:.+|)$" yaml:"Trigger"`
 	CreateTime time.Time
+	// Tenant owning the alert, set by the server
+	Tenant string `json:",omitempty" yaml:"Tenant"`
 }
 
 // GetName returns the resource name
@@ -91,6 +101,16 @@ func (a *Alert) GetName() string {
 	return "Alert"
 }
 
+// GetTenant returns the tenant owning the alert
+func (a *Alert) GetTenant() string {
+	return a.Tenant
+}
+
+// SetTenant sets the tenant owning the alert
+func (a *Alert) SetTenant(tenant string) {
+	a.Tenant = tenant
+}
+
 // NewAlert creates a New empty Alert, only CreateTime is set.
 func NewAlert() *Alert {
 	return &Alert{
@@ -117,12 +137,12 @@ type Capture struct {
 	Name string `json:"Name,omitempty" yaml:"Name"`
 	// Capture description
 	Description string `json:"Description,omitempty" yaml:"Description"`
-	// Capture type. Can be afpacket, pcap, ebpf, sflow, pcapsocket, ovsmirror, dpdk, ovssflow or ovsnetflow
+	// Capture type. Can be afpacket, pcap, afxdp, ebpf, ebpfsocket, ebpfdrop, sflow, pcapsocket, ovsmirror, dpdk, ovssflow, ovsnetflow, ipfix or erspan
 	Type string `json:"Type,omitempty" valid:"isValidCaptureType" yaml:"Type"`
 	// Number of active captures
 	// swagger:ignore
 	Count int `json:"Count" yaml:"Count"`
-	// SFlow port
+	// SFlow port, or VXLAN port of the erspan captures
 	Port int `json:"Port,omitempty" yaml:"Port"`
 	// Sampling rate for SFlow flows. 0: no flow samples
 	SamplingRate uint32 `json:"SamplingRate" yaml:"SamplingRate"`
@@ -142,10 +162,28 @@ type Capture struct {
 	LayerKeyMode string `json:"LayerKeyMode,omitempty" valid:"isValidLayerKeyMode" yaml:"LayerKeyMode"`
 	// List of extra layers to be added to the flow, available: DNS|DHCPv4|VRRP
 	ExtraLayers flow.ExtraLayers `json:"ExtraLayers,omitempty" yaml:"ExtraLayers"`
+	// Number of afpacket sockets of the fanout group, each one read by its own worker
+	Workers int `json:"Workers,omitempty" valid:"isValidWorkers" yaml:"Workers"`
+	// Fanout mode used to dispatch packets to the workers, hash or cpu
+	FanoutMode string `json:"FanoutMode,omitempty" valid:"isValidFanoutMode" yaml:"FanoutMode"`
+	// Size in bytes of the blocks of the afpacket ring buffer, a multiple of the page size
+	RingBlockSize int `json:"RingBlockSize,omitempty" yaml:"RingBlockSize"`
+	// Number of blocks of the afpacket ring buffer
+	RingBlocks int `json:"RingBlocks,omitempty" yaml:"RingBlocks"`
+	// Timeout in milliseconds after which a partially filled block is retired
+	BlockTimeout int `json:"BlockTimeout,omitempty" yaml:"BlockTimeout"`
+	// Size in bytes of the rolling buffer keeping the last packets of the capture, 0: no size bound
+	PacketRingSize int64 `json:"PacketRingSize,omitempty" valid:"isValidPacketRingBound" yaml:"PacketRingSize"`
+	// Duration in seconds of the packets kept by the rolling buffer, 0: no duration bound
+	PacketRingDuration int `json:"PacketRingDuration,omitempty" valid:"isValidPacketRingBound" yaml:"PacketRingDuration"`
+	// Storage of the rolling buffer, memory or disk
+	PacketRingStorage string `json:"PacketRingStorage,omitempty" valid:"isValidPacketRingStorage" yaml:"PacketRingStorage"`
 	// sFlow/NetFlow target, if empty the agent will be used
 	Target string `json:"Target,omitempty" valid:"isValidAddress" yaml:"Target"`
-	// target type (netflowv5, erspanv1), ignored in case of sFlow/NetFlow capture
+	// target type (netflowv5, erspanv1, ipfix), ignored in case of sFlow/NetFlow capture
 	TargetType string `json:"TargetType,omitempty" yaml:"TargetType"`
+	// Tenant owning the capture, set by the server
+	Tenant string `json:"Tenant,omitempty" yaml:"Tenant"`
 }
 
 // GetName returns the resource name
@@ -153,6 +191,16 @@ func (c *Capture) GetName() string {
 	return "Capture"
 }
 
+// GetTenant returns the tenant owning the capture
+func (c *Capture) GetTenant() string {
+	return c.Tenant
+}
+
+// SetTenant sets the tenant owning the capture
+func (c *Capture) SetTenant(tenant string) {
+	c.Tenant = tenant
+}
+
 // NewCapture creates a new capture
 func NewCapture(query string, bpfFilter string) *Capture {
 	return &Capture{
@@ -180,6 +228,8 @@ type EdgeRule struct {
 	Dst string `valid:"isGremlinExpr" yaml:"Dst"`
 	// Metadata of the edges to create
 	Metadata graph.Metadata `yaml:"Metadata"`
+	// Tenant owning the edge rule, set by the server
+	Tenant string `json:",omitempty" yaml:"Tenant"`
 }
 
 // GetName returns the resource name
@@ -187,6 +237,16 @@ func (e *EdgeRule) GetName() string {
 	return "EdgeRule"
 }
 
+// GetTenant returns the tenant owning the edge rule
+func (e *EdgeRule) GetTenant() string {
+	return e.Tenant
+}
+
+// SetTenant sets the tenant owning the edge rule
+func (e *EdgeRule) SetTenant(tenant string) {
+	e.Tenant = tenant
+}
+
 // Validate verifies the edge rule does not create invalid edges
 func (e *EdgeRule) Validate() error {
 	n1 := graph.CreateNode(graph.GenID(), nil, graph.TimeUTC(), "", "")
@@ -214,6 +274,8 @@ type NodeRule struct {
 	Action string `valid:"regexp=^(create|update)$" yaml:"Action"`
 	// Gremlin expression of the nodes to update
 	Query string `valid:"isGremlinOrEmpty" yaml:"Query"`
+	// Tenant owning the node rule, set by the server
+	Tenant string `json:",omitempty" yaml:"Tenant"`
 }
 
 // GetName returns the resource name
@@ -221,6 +283,16 @@ func (n *NodeRule) GetName() string {
 	return "NodeRule"
 }
 
+// GetTenant returns the tenant owning the node rule
+func (n *NodeRule) GetTenant() string {
+	return n.Tenant
+}
+
+// SetTenant sets the tenant owning the node rule
+func (n *NodeRule) SetTenant(tenant string) {
+	n.Tenant = tenant
+}
+
 // Validate verifies the node rule does not create invalid node or change
 // important attributes of an existing node
 func (n *NodeRule) Validate() error {
@@ -263,6 +335,7 @@ const (
 type PacketInjection struct {
 	// swagger:allOf
 	BasicResource    `yaml:",inline"`
+	Name             string `yaml:"Name"`
 	Src              string `yaml:"Src"`
 	Dst              string `yaml:"Dst"`
 	SrcIP            string `valid:"isIPOrCIDR" yaml:"SrcIP"`
@@ -281,6 +354,8 @@ type PacketInjection struct {
 	StartTime        time.Time
 	Pcap             []byte `yaml:"Pcap"`
 	TTL              uint8  `yaml:"TTL"`
+	// Tenant owning the injection, set by the server
+	Tenant string `json:",omitempty" yaml:"Tenant"`
 }
 
 // GetName returns the resource name
@@ -288,6 +363,16 @@ func (pi *PacketInjection) GetName() string {
 	return "PacketInjection"
 }
 
+// GetTenant returns the tenant owning the injection
+func (pi *PacketInjection) GetTenant() string {
+	return pi.Tenant
+}
+
+// SetTenant sets the tenant owning the injection
+func (pi *PacketInjection) SetTenant(tenant string) {
+	pi.Tenant = tenant
+}
+
 // Validate verifies the packet injection type is supported
 func (pi *PacketInjection) Validate() error {
 	allowedTypes := map[string]bool{
@@ -354,6 +439,58 @@ type Workflow struct {
 	// Workflow parameters
 	Parameters []WorkflowParam `yaml:"Parameters"`
 	Source     string          `valid:"isValidWorkflow" yaml:"Source"`
+	// Tenant owning the workflow, set by the server
+	Tenant string `json:",omitempty" yaml:"Tenant"`
+}
+
+// GetTenant returns the tenant owning the workflow
+func (w *Workflow) GetTenant() string {
+	return w.Tenant
+}
+
+// SetTenant sets the tenant owning the workflow
+func (w *Workflow) SetTenant(tenant string) {
+	w.Tenant = tenant
+}
+
+// APIToken object
+//
+// API tokens authenticate service accounts, like agents or scripts,
+// without sharing a password. The secret of a token is only returned
+// when the token is created, only its hash is kept by the server.
+//
+// easyjson:json
+// swagger:model APIToken
+type APIToken struct {
+	// swagger:allOf
+	BasicResource `yaml:",inline"`
+	// Token name
+	Name string `json:",omitempty" yaml:"Name"`
+	// Token description
+	Description string `json:",omitempty" yaml:"Description"`
+	// Service account authenticated by the token
+	ServiceAccount string `json:",omitempty" valid:"nonzero" yaml:"ServiceAccount"`
+	// Role granted to the service account
+	Role string `json:",omitempty" valid:"nonzero" yaml:"Role"`
+	// User who created the service account, set by the server
+	Owner string `json:",omitempty" yaml:"Owner"`
+	// Expiration time of the token, the token never expires if not set
+	ExpireTime time.Time `yaml:"ExpireTime"`
+	CreateTime time.Time `yaml:"CreateTime"`
+	// Token to use as password, only returned at creation
+	Token string `json:",omitempty" yaml:"Token"`
+	// Hash of the token secret, set by the server
+	Hash string `json:",omitempty" yaml:"Hash"`
+}
+
+// GetName returns the resource name
+func (t *APIToken) GetName() string {
+	return "APIToken"
+}
+
+// IsExpired returns whether the token expired
+func (t *APIToken) IsExpired() bool {
+	return !t.ExpireTime.IsZero() && time.Now().After(t.ExpireTime)
 }
 
 // WorkflowCall describes workflow call
@@ -362,6 +499,43 @@ type WorkflowCall struct {
 	Params []interface{}
 }
 
+// PcapFile states
+const (
+	PcapFileRunning = "running"
+	PcapFileDone    = "done"
+	PcapFileFailed  = "failed"
+)
+
+// PcapFile object
+//
+// PcapFile describes the ingestion of a pcap or pcapng file by the analyzer,
+// the flows being attached to the nodes of the topology owning their
+// addresses.
+//
+// swagger:model
+type PcapFile struct {
+	// UUID of the ingestion, used as CaptureID of the flows
+	UUID string
+	// Name of the file
+	Name string `json:",omitempty"`
+	// Replay speed, 0 to keep the original timestamps
+	Speed float64
+	// BPF filter
+	BPFFilter string `json:",omitempty"`
+	// Maximum number of raw packets stored per flow, -1: unlimited
+	RawPacketLimit int64
+	// State of the ingestion: running, done or failed
+	State string
+	// Error of a failed ingestion
+	Error string `json:",omitempty"`
+	// Number of packets read
+	Packets int64
+	// TIDs of the nodes the flows were attached to
+	Nodes []string
+	// Time at which the ingestion started
+	StartTime time.Time
+}
+
 func init() {
 	var err error
 	if schemaValidator, err = topology.NewSchemaValidator(); err != nil {
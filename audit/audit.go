@@ -0,0 +1,235 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package audit records the mutations done through the API and the
+// publisher endpoint: who did what, from where and what changed.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	uuid "github.com/nu7hatch/gouuid"
+
+	"github.com/skydive-project/skydive/logging"
+)
+
+// ErrNotQueryable is returned when none of the audit backends can be queried
+var ErrNotQueryable = errors.New("No queryable audit backend configured")
+
+// Entry describes an audited action. Diff holds the resource for a creation
+// or a deletion, and the JSON merge patch applied to the resource for an
+// update.
+type Entry struct {
+	ID         string
+	Timestamp  int64
+	User       string
+	SourceIP   string
+	Action     string
+	Resource   string
+	ResourceID string          `json:",omitempty"`
+	Diff       json.RawMessage `json:",omitempty"`
+}
+
+// Filter describes the criteria of an audit query, the empty
+// criteria match all the entries
+type Filter struct {
+	User       string
+	SourceIP   string
+	Action     string
+	Resource   string
+	ResourceID string
+	// Range of timestamps, in milliseconds, 0 meaning no bound
+	From int64
+	To   int64
+	// Maximum number of entries returned, the most recent first
+	Limit int
+}
+
+// Backend is the interface of an audit backend
+type Backend interface {
+	Write(entry *Entry) error
+}
+
+// QueryableBackend is the interface of an audit backend that can be queried
+type QueryableBackend interface {
+	Backend
+	Query(filter *Filter) ([]*Entry, error)
+}
+
+var auditor struct {
+	sync.RWMutex
+	backends []Backend
+}
+
+// Match returns whether an entry matches the filter
+func (f *Filter) Match(e *Entry) bool {
+	match := func(criteria, value string) bool {
+		return criteria == "" || criteria == value
+	}
+
+	return match(f.User, e.User) &&
+		match(f.SourceIP, e.SourceIP) &&
+		match(f.Action, e.Action) &&
+		match(f.Resource, e.Resource) &&
+		match(f.ResourceID, e.ResourceID) &&
+		(f.From == 0 || e.Timestamp >= f.From) &&
+		(f.To == 0 || e.Timestamp <= f.To)
+}
+
+// sortEntries sorts the entries, the most recent first, and keeps
+// at most limit of them
+func sortEntries(entries []*Entry, limit int) []*Entry {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp > entries[j].Timestamp })
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// SourceIP returns the IP address of a remote address like 10.0.0.1:4567
+func SourceIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// Init sets the backends the audit entries are written to
+func Init(backends ...Backend) {
+	auditor.Lock()
+	auditor.backends = backends
+	auditor.Unlock()
+}
+
+// Enabled returns whether at least one audit backend is configured
+func Enabled() bool {
+	auditor.RLock()
+	defer auditor.RUnlock()
+	return len(auditor.backends) > 0
+}
+
+// Log writes an entry to all the backends. The errors are logged,
+// an audit failure doesn't fail the audited action.
+func Log(entry *Entry) {
+	auditor.RLock()
+	backends := auditor.backends
+	auditor.RUnlock()
+
+	if len(backends) == 0 {
+		return
+	}
+
+	if entry.ID == "" {
+		id, _ := uuid.NewV4()
+		entry.ID = id.String()
+	}
+
+	if entry.Timestamp == 0 {
+		entry.Timestamp = time.Now().UTC().UnixNano() / int64(time.Millisecond)
+	}
+
+	for _, backend := range backends {
+		if err := backend.Write(entry); err != nil {
+			logging.GetLogger().Errorf("Unable to write audit entry %s: %s", entry.ID, err)
+		}
+	}
+}
+
+// Query returns the entries matching a filter, using the first
+// queryable backend
+func Query(filter *Filter) ([]*Entry, error) {
+	auditor.RLock()
+	defer auditor.RUnlock()
+
+	for _, backend := range auditor.backends {
+		if qb, ok := backend.(QueryableBackend); ok {
+			return qb.Query(filter)
+		}
+	}
+
+	return nil, ErrNotQueryable
+}
+
+// Diff returns the JSON merge patch (RFC 7386) transforming before into
+// after. The values are compared once marshaled to JSON.
+func Diff(before, after interface{}) (json.RawMessage, error) {
+	var b, a interface{}
+
+	for _, c := range []struct {
+		in  interface{}
+		out *interface{}
+	}{{before, &b}, {after, &a}} {
+		data, err := json.Marshal(c.in)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, c.out); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(mergePatch(b, a))
+}
+
+func mergePatch(before, after interface{}) interface{} {
+	bm, bok := before.(map[string]interface{})
+	am, aok := after.(map[string]interface{})
+	if !bok || !aok {
+		return after
+	}
+
+	patch := make(map[string]interface{})
+	for key, bv := range bm {
+		av, found := am[key]
+		if !found {
+			patch[key] = nil
+			continue
+		}
+
+		if _, isMap := av.(map[string]interface{}); isMap {
+			if p := mergePatch(bv, av); !isEmptyPatch(p) {
+				patch[key] = p
+			}
+		} else if !jsonEqual(bv, av) {
+			patch[key] = av
+		}
+	}
+
+	for key, av := range am {
+		if _, found := bm[key]; !found {
+			patch[key] = av
+		}
+	}
+
+	return patch
+}
+
+func isEmptyPatch(patch interface{}) bool {
+	m, ok := patch.(map[string]interface{})
+	return ok && len(m) == 0
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	before := map[string]interface{}{
+		"Name": "capture",
+		"Type": "pcap",
+		"Metadata": map[string]interface{}{
+			"A": 1,
+			"B": 2,
+		},
+	}
+	after := map[string]interface{}{
+		"Name": "capture",
+		"Type": "afpacket",
+		"Metadata": map[string]interface{}{
+			"A": 1,
+			"C": 3,
+		},
+	}
+
+	diff, err := Diff(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"Metadata":{"B":null,"C":3},"Type":"afpacket"}`
+	if string(diff) != expected {
+		t.Errorf("Expected diff %s, got %s", expected, string(diff))
+	}
+}
+
+func TestFileBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend, err := NewFileBackend(filepath.Join(dir, "audit.log"), 300, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Init(backend)
+	defer Init()
+
+	for i := int64(1); i <= 10; i++ {
+		user := "admin"
+		if i%2 == 0 {
+			user = "guest"
+		}
+		Log(&Entry{Timestamp: i, User: user, SourceIP: "10.0.0.1", Action: "create", Resource: "capture"})
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "audit.log.3")); !os.IsNotExist(err) {
+		t.Error("Only 2 rotated files should be kept")
+	}
+
+	entries, err := Query(&Filter{User: "admin", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[0].Timestamp != 9 || entries[1].Timestamp != 7 {
+		t.Errorf("Expected the 2 most recent entries of admin, got %+v", entries)
+	}
+
+	entries, err = Query(&Filter{From: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("Expected no entry, got %+v", entries)
+	}
+}
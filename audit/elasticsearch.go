@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package audit
+
+import (
+	"encoding/json"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/filters"
+	es "github.com/skydive-project/skydive/storage/elasticsearch"
+)
+
+const auditMapping = `
+{
+	"dynamic_templates": [
+		{
+			"strings": {
+				"match": "*",
+				"match_mapping_type": "string",
+				"mapping": {
+					"type": "keyword"
+				}
+			}
+		}
+	],
+	"properties": {
+		"Timestamp": {
+			"type": "date",
+			"format": "epoch_millis"
+		},
+		"Diff": {
+			"type": "object",
+			"enabled": false
+		}
+	}
+}`
+
+var auditIndex = es.Index{
+	Name:      "audit",
+	Type:      "audit",
+	Mapping:   auditMapping,
+	RollIndex: true,
+}
+
+// ElasticSearchBackend stores the audit entries in Elasticsearch
+type ElasticSearchBackend struct {
+	client *es.Client
+}
+
+// Write indexes an entry
+func (b *ElasticSearchBackend) Write(entry *Entry) error {
+	return b.client.BulkIndex(auditIndex, entry.ID, entry)
+}
+
+// Query returns the entries matching a filter
+func (b *ElasticSearchBackend) Query(filter *Filter) ([]*Entry, error) {
+	var terms []*filters.Filter
+	for key, value := range map[string]string{
+		"User":       filter.User,
+		"SourceIP":   filter.SourceIP,
+		"Action":     filter.Action,
+		"Resource":   filter.Resource,
+		"ResourceID": filter.ResourceID,
+	} {
+		if value != "" {
+			terms = append(terms, filters.NewTermStringFilter(key, value))
+		}
+	}
+
+	if filter.From != 0 {
+		terms = append(terms, filters.NewGteInt64Filter("Timestamp", filter.From))
+	}
+	if filter.To != 0 {
+		terms = append(terms, filters.NewLteInt64Filter("Timestamp", filter.To))
+	}
+
+	query := filters.SearchQuery{
+		Sort:      true,
+		SortBy:    "Timestamp",
+		SortOrder: string(common.SortDescending),
+	}
+	if filter.Limit > 0 {
+		query.PaginationRange = &filters.Range{To: int64(filter.Limit)}
+	}
+
+	result, err := b.client.Search(auditIndex.Type, es.FormatFilter(filters.NewAndFilter(terms...), ""), query, auditIndex.IndexWildcard())
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for _, hit := range result.Hits.Hits {
+		var entry Entry
+		if err := json.Unmarshal([]byte(*hit.Source), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
+
+// NewElasticSearchBackend returns a new Elasticsearch audit backend
+func NewElasticSearchBackend(cfg es.Config, electionService common.MasterElectionService) (*ElasticSearchBackend, error) {
+	client, err := es.NewClient([]es.Index{auditIndex}, cfg, electionService)
+	if err != nil {
+		return nil, err
+	}
+
+	go client.Start()
+
+	return &ElasticSearchBackend{client: client}, nil
+}
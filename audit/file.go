@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileBackend writes the audit entries to a file, one JSON entry per line.
+// The file is rotated once it reaches its maximum size, the previous files
+// being renamed with a .1, .2, ... suffix, .1 being the most recent.
+type FileBackend struct {
+	sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func (b *FileBackend) open() error {
+	file, err := os.OpenFile(b.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	b.file, b.size = file, fi.Size()
+	return nil
+}
+
+func (b *FileBackend) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", b.path, i)
+}
+
+func (b *FileBackend) rotate() error {
+	if err := b.file.Close(); err != nil {
+		return err
+	}
+
+	os.Remove(b.backupPath(b.maxBackups))
+	for i := b.maxBackups - 1; i > 0; i-- {
+		os.Rename(b.backupPath(i), b.backupPath(i+1))
+	}
+
+	if b.maxBackups > 0 {
+		if err := os.Rename(b.path, b.backupPath(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(b.path); err != nil {
+		return err
+	}
+
+	return b.open()
+}
+
+// Write appends an entry to the file
+func (b *FileBackend) Write(entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.maxSize > 0 && b.size > 0 && b.size+int64(len(data)) > b.maxSize {
+		if err := b.rotate(); err != nil {
+			return fmt.Errorf("Unable to rotate audit file %s: %s", b.path, err)
+		}
+	}
+
+	n, err := b.file.Write(data)
+	b.size += int64(n)
+	return err
+}
+
+func (b *FileBackend) readFile(path string, filter *Filter, entries []*Entry) ([]*Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		if filter.Match(&entry) {
+			entries = append(entries, &entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// Query returns the entries of the file and of its backups matching a filter
+func (b *FileBackend) Query(filter *Filter) ([]*Entry, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	var err error
+	var entries []*Entry
+	for i := b.maxBackups; i > 0; i-- {
+		if entries, err = b.readFile(b.backupPath(i), filter, entries); err != nil {
+			return nil, err
+		}
+	}
+
+	if entries, err = b.readFile(b.path, filter, entries); err != nil {
+		return nil, err
+	}
+
+	return sortEntries(entries, filter.Limit), nil
+}
+
+// NewFileBackend returns a new file audit backend. The file is rotated once
+// it reaches maxSize bytes, 0 meaning no rotation, and maxBackups rotated
+// files are kept.
+func NewFileBackend(path string, maxSize int64, maxBackups int) (*FileBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	b := &FileBackend{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := b.open(); err != nil {
+		return nil, fmt.Errorf("Unable to open audit file %s: %s", path, err)
+	}
+
+	return b, nil
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/skydive-project/skydive/api/client"
 	"github.com/skydive-project/skydive/api/types"
+	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/validator"
 
@@ -73,6 +74,57 @@ var AlertCreate = &cobra.Command{
 	},
 }
 
+// AlertUpdate skydive alert update command
+var AlertUpdate = &cobra.Command{
+	Use:   "update [alert]",
+	Short: "Update alert",
+	Long:  "Update alert, only the specified options are modified",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		var alert types.Alert
+		etag, err := client.GetWithETag("alert", args[0], &alert)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		flags := cmd.Flags()
+		if flags.Changed("name") {
+			alert.Name = alertName
+		}
+		if flags.Changed("description") {
+			alert.Description = alertDescription
+		}
+		if flags.Changed("expression") {
+			alert.Expression = alertExpression
+		}
+		if flags.Changed("trigger") {
+			alert.Trigger = alertTrigger
+		}
+		if flags.Changed("action") {
+			alert.Action = alertAction
+		}
+
+		if err := validator.Validate(&alert); err != nil {
+			exitOnError(err)
+		}
+
+		if err := client.Update("alert", args[0], &alert, &shttp.UpdateOptions{ETag: etag}); err != nil {
+			exitOnError(err)
+		}
+		printJSON(&alert)
+	},
+}
+
 // AlertList skydive alert list command
 var AlertList = &cobra.Command{
 	Use:   "list",
@@ -153,7 +205,9 @@ func init() {
 	AlertCmd.AddCommand(AlertList)
 	AlertCmd.AddCommand(AlertGet)
 	AlertCmd.AddCommand(AlertCreate)
+	AlertCmd.AddCommand(AlertUpdate)
 	AlertCmd.AddCommand(AlertDelete)
 
 	addAlertFlags(AlertCreate)
+	addAlertFlags(AlertUpdate)
 }
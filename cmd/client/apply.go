@@ -0,0 +1,562 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/skydive-project/skydive/api/client"
+	"github.com/skydive-project/skydive/api/types"
+	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/validator"
+
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	applyFiles  []string
+	applyDryRun bool
+	applyPrune  bool
+)
+
+// applyKind describes a kind of resource that can be part of a bundle
+type applyKind struct {
+	resource string
+	new      func() types.Resource
+	// the server also lists resources that are not stored, like the
+	// builtin workflows, which can neither be updated nor deleted
+	builtins bool
+}
+
+// applyKinds lists the supported kinds, in the order they are applied.
+// Resources are deleted in the reverse order.
+var applyKinds = []applyKind{
+	{"workflow", func() types.Resource { return &types.Workflow{} }, true},
+	{"noderule", func() types.Resource { return &types.NodeRule{} }, false},
+	{"edgerule", func() types.Resource { return &types.EdgeRule{} }, false},
+	{"alert", func() types.Resource { return &types.Alert{} }, false},
+	{"capture", func() types.Resource { return &types.Capture{} }, false},
+	{"injectpacket", func() types.Resource { return &types.PacketInjection{} }, false},
+}
+
+var applyKindAliases = map[string]string{
+	"node-rule":       "noderule",
+	"edge-rule":       "edgerule",
+	"injection":       "injectpacket",
+	"packetinjection": "injectpacket",
+}
+
+// bundleResource is a resource read from a bundle
+type bundleResource struct {
+	kind     string
+	name     string
+	resource types.Resource
+}
+
+func getApplyKind(kind string) (*applyKind, error) {
+	kind = strings.ToLower(kind)
+	if alias, ok := applyKindAliases[kind]; ok {
+		kind = alias
+	}
+
+	for i := range applyKinds {
+		if applyKinds[i].resource == kind {
+			return &applyKinds[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unsupported resource kind '%s'", kind)
+}
+
+// readBundle reads the resources of a multi-document YAML stream
+func readBundle(source string, r io.Reader) ([]*bundleResource, error) {
+	var resources []*bundleResource
+
+	decoder := yaml.NewDecoder(r)
+	for i := 1; ; i++ {
+		var doc map[string]interface{}
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: document %d: %s", source, i, err)
+		}
+
+		if len(doc) == 0 {
+			continue
+		}
+
+		kindName, _ := doc["Kind"].(string)
+		if kindName == "" {
+			return nil, fmt.Errorf("%s: document %d: no Kind specified", source, i)
+		}
+
+		kind, err := getApplyKind(kindName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: document %d: %s", source, i, err)
+		}
+
+		name, _ := doc["Name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("%s: document %d: no Name specified for %s", source, i, kind.resource)
+		}
+
+		// re-encode the document so that it can be decoded using the resource type
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: document %d: %s", source, i, err)
+		}
+
+		resource := kind.new()
+		if err := yaml.Unmarshal(data, resource); err != nil {
+			return nil, fmt.Errorf("%s: document %d: %s", source, i, err)
+		}
+
+		if err := validator.Validate(resource); err != nil {
+			return nil, fmt.Errorf("%s: invalid %s %s: %s", source, kind.resource, name, err)
+		}
+
+		resources = append(resources, &bundleResource{kind: kind.resource, name: name, resource: resource})
+	}
+
+	return resources, nil
+}
+
+// bundleFiles returns the list of files for a path. If the path is a
+// directory, the YAML files of the directory are returned.
+func bundleFiles(path string) ([]string, error) {
+	if path == "-" {
+		return []string{path}, nil
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if ext := filepath.Ext(entry.Name()); !entry.IsDir() && (ext == ".yml" || ext == ".yaml") {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// readBundleFile reads the resources of a file, '-' being the standard input
+func readBundleFile(file string) ([]*bundleResource, error) {
+	if file == "-" {
+		return readBundle(file, os.Stdin)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readBundle(file, f)
+}
+
+func loadBundle(paths []string) ([]*bundleResource, error) {
+	var resources []*bundleResource
+	names := make(map[string]bool)
+
+	for _, path := range paths {
+		files, err := bundleFiles(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			bundle, err := readBundleFile(file)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, br := range bundle {
+				key := br.kind + "/" + br.name
+				if names[key] {
+					return nil, fmt.Errorf("%s: duplicate %s %s", file, br.kind, br.name)
+				}
+				names[key] = true
+			}
+
+			resources = append(resources, bundle...)
+		}
+	}
+
+	return resources, nil
+}
+
+// resourceName returns the name of a resource
+func resourceName(resource types.Resource) string {
+	switch r := resource.(type) {
+	case *types.Workflow:
+		return r.Name
+	case *types.NodeRule:
+		return r.Name
+	case *types.EdgeRule:
+		return r.Name
+	case *types.Alert:
+		return r.Name
+	case *types.Capture:
+		return r.Name
+	case *types.PacketInjection:
+		return r.Name
+	}
+	return ""
+}
+
+// copyServerFields copies to the bundle resource the fields that are
+// filled by the server so that they are not considered as a difference
+func copyServerFields(dst, src types.Resource) {
+	dst.SetID(src.ID())
+
+	switch r := dst.(type) {
+	case *types.Alert:
+		r.CreateTime = src.(*types.Alert).CreateTime
+		if r.Tenant == "" {
+			r.Tenant = src.(*types.Alert).Tenant
+		}
+	case *types.Capture:
+		r.Count = src.(*types.Capture).Count
+		if r.Tenant == "" {
+			r.Tenant = src.(*types.Capture).Tenant
+		}
+	case *types.PacketInjection:
+		r.StartTime = src.(*types.PacketInjection).StartTime
+	}
+}
+
+// resourceFields returns the fields of a resource as a flat map, the fields
+// of nested objects being prefixed by the name of their parent field
+func resourceFields(resource types.Resource) (map[string]interface{}, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{})
+
+	var flatten func(prefix string, values map[string]interface{})
+	flatten = func(prefix string, values map[string]interface{}) {
+		for k, v := range values {
+			if m, ok := v.(map[string]interface{}); ok && len(m) > 0 {
+				flatten(prefix+k+".", m)
+			} else {
+				fields[prefix+k] = v
+			}
+		}
+	}
+	flatten("", values)
+
+	return fields, nil
+}
+
+// diffResources returns the fields that differ between the current version
+// of a resource and the desired one, formatted as removed and added lines
+func diffResources(current, desired types.Resource) ([]string, error) {
+	currentFields, err := resourceFields(current)
+	if err != nil {
+		return nil, err
+	}
+
+	desiredFields, err := resourceFields(desired)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for k := range currentFields {
+		keys = append(keys, k)
+	}
+	for k := range desiredFields {
+		if _, found := currentFields[k]; !found {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	formatField := func(k string, v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%s: %v", k, v)
+		}
+		return fmt.Sprintf("%s: %s", k, data)
+	}
+
+	var lines []string
+	for _, k := range keys {
+		cv, inCurrent := currentFields[k]
+		dv, inDesired := desiredFields[k]
+		if inCurrent && inDesired && reflect.DeepEqual(cv, dv) {
+			continue
+		}
+
+		if inCurrent {
+			lines = append(lines, "- "+formatField(k, cv))
+		}
+		if inDesired {
+			lines = append(lines, "+ "+formatField(k, dv))
+		}
+	}
+
+	return lines, nil
+}
+
+// listResources returns the named resources of a kind indexed by name. Only
+// the stored resources are returned, the ones returned without ETag, like
+// the builtin workflows, are not managed by the bundles.
+func listResources(crudClient *shttp.CrudClient, kind *applyKind) (map[string]types.Resource, error) {
+	var values map[string]json.RawMessage
+	if err := crudClient.List(kind.resource, &values); err != nil {
+		return nil, err
+	}
+
+	resources := make(map[string]types.Resource)
+	for id, value := range values {
+		resource := kind.new()
+		if err := json.Unmarshal(value, resource); err != nil {
+			return nil, fmt.Errorf("Failed to decode %s %s: %s", kind.resource, id, err)
+		}
+
+		if kind.builtins {
+			etag, err := crudClient.GetWithETag(kind.resource, id, kind.new())
+			if err != nil {
+				return nil, err
+			}
+			if etag == "" {
+				continue
+			}
+		}
+
+		if name := resourceName(resource); name != "" {
+			if _, found := resources[name]; found {
+				return nil, fmt.Errorf("Several %s resources are named %s", kind.resource, name)
+			}
+			resources[name] = resource
+		}
+	}
+
+	return resources, nil
+}
+
+// applyBundle creates, updates and optionally deletes resources so that the
+// resources on the server match the ones of the bundle. The modifications,
+// with the differences of the updated resources, are reported to w.
+func applyBundle(w io.Writer, crudClient *shttp.CrudClient, bundle []*bundleResource, dryRun, prune bool) error {
+	byKind := make(map[string][]*bundleResource)
+	for _, br := range bundle {
+		byKind[br.kind] = append(byKind[br.kind], br)
+	}
+
+	existing := make(map[string]map[string]types.Resource)
+	for i := range applyKinds {
+		kind := &applyKinds[i]
+
+		// when pruning, the kinds absent from the bundle are also
+		// listed so that all their named resources get deleted
+		if _, found := byKind[kind.resource]; !found && !prune {
+			continue
+		}
+
+		resources, err := listResources(crudClient, kind)
+		if err != nil {
+			return err
+		}
+		existing[kind.resource] = resources
+	}
+
+	report := func(action string, kind string, name string, diff ...string) {
+		if dryRun {
+			fmt.Fprintf(w, "%s/%s %s (dry run)\n", kind, name, action)
+		} else {
+			fmt.Fprintf(w, "%s/%s %s\n", kind, name, action)
+		}
+
+		for _, line := range diff {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+
+	var failed bool
+	for _, kind := range applyKinds {
+		for _, br := range byKind[kind.resource] {
+			current, found := existing[kind.resource][br.name]
+			if !found {
+				if !dryRun {
+					var opts *shttp.CreateOptions
+					if injection, ok := br.resource.(*types.PacketInjection); ok {
+						opts = &shttp.CreateOptions{TTL: injectionTTL(injection)}
+					}
+
+					if err := crudClient.Create(kind.resource, br.resource, opts); err != nil {
+						logging.GetLogger().Error(err)
+						failed = true
+						continue
+					}
+				}
+				report("created", kind.resource, br.name)
+				continue
+			}
+			id := current.ID()
+
+			// the resource is fetched to get its ETag so that a concurrent
+			// modification is not silently overwritten
+			current = kind.new()
+			etag, err := crudClient.GetWithETag(kind.resource, id, current)
+			if err != nil {
+				logging.GetLogger().Error(err)
+				failed = true
+				continue
+			}
+
+			if etag == "" {
+				logging.GetLogger().Warningf("%s %s is not stored, it can not be updated", kind.resource, br.name)
+				report("skipped", kind.resource, br.name)
+				continue
+			}
+
+			copyServerFields(br.resource, current)
+
+			diff, err := diffResources(current, br.resource)
+			if err != nil {
+				return err
+			}
+
+			if len(diff) == 0 {
+				report("unchanged", kind.resource, br.name)
+				continue
+			}
+
+			if !dryRun {
+				if err := crudClient.Update(kind.resource, id, br.resource, &shttp.UpdateOptions{ETag: etag}); err != nil {
+					logging.GetLogger().Error(err)
+					failed = true
+					continue
+				}
+			}
+			report("updated", kind.resource, br.name, diff...)
+		}
+	}
+
+	if prune {
+		for i := len(applyKinds) - 1; i >= 0; i-- {
+			kind := applyKinds[i]
+
+			names := make(map[string]bool)
+			for _, br := range byKind[kind.resource] {
+				names[br.name] = true
+			}
+
+			var pruned []string
+			for name := range existing[kind.resource] {
+				if !names[name] {
+					pruned = append(pruned, name)
+				}
+			}
+			sort.Strings(pruned)
+
+			for _, name := range pruned {
+				if !dryRun {
+					if err := crudClient.Delete(kind.resource, existing[kind.resource][name].ID()); err != nil {
+						logging.GetLogger().Error(err)
+						failed = true
+						continue
+					}
+				}
+				report("deleted", kind.resource, name)
+			}
+		}
+	}
+
+	if failed {
+		return errors.New("Some resources could not be applied")
+	}
+
+	return nil
+}
+
+// ApplyCmd skydive apply command
+var ApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply a bundle of resources",
+	Long: `Apply a bundle of resources described in YAML files. Each YAML document
+describes a resource with its Kind (capture, alert, noderule, edgerule,
+injectpacket or workflow) and its Name. Resources are matched by name
+with the existing ones, which are created or updated accordingly. With
+--prune, the named resources that are not part of the bundle are deleted,
+whatever their kind. The builtin workflows are never updated nor deleted.`,
+	SilenceUsage: false,
+
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(applyFiles) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+
+	Run: func(cmd *cobra.Command, args []string) {
+		crudClient, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		bundle, err := loadBundle(applyFiles)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		if err := applyBundle(os.Stdout, crudClient, bundle, applyDryRun, applyPrune); err != nil {
+			exitOnError(err)
+		}
+	},
+}
+
+func init() {
+	ApplyCmd.Flags().StringSliceVarP(&applyFiles, "filename", "f", nil, "YAML file or directory of YAML files to apply, '-' for standard input")
+	ApplyCmd.Flags().BoolVarP(&applyDryRun, "dry-run", "", false, "only print the modifications that would be applied")
+	ApplyCmd.Flags().BoolVarP(&applyPrune, "prune", "", false, "delete the named resources, of any kind, that are not part of the bundle")
+}
@@ -0,0 +1,401 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/skydive-project/skydive/api/types"
+	shttp "github.com/skydive-project/skydive/http"
+)
+
+// fakeCrudServer stores the resources posted to the API in memory. Like
+// the builtin workflows, the builtin resources are listed but returned
+// without ETag and can neither be modified nor deleted.
+type fakeCrudServer struct {
+	sync.Mutex
+	resources map[string]map[string]json.RawMessage
+	builtins  map[string]map[string]json.RawMessage
+	etags     map[string]int
+	lastID    int
+}
+
+func (s *fakeCrudServer) addBuiltin(kind string, resource types.Resource) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.lastID++
+	resource.SetID(fmt.Sprintf("builtin-%d", s.lastID))
+	data, _ := json.Marshal(resource)
+
+	if s.builtins[kind] == nil {
+		s.builtins[kind] = make(map[string]json.RawMessage)
+	}
+	s.builtins[kind][resource.ID()] = data
+}
+
+func (s *fakeCrudServer) add(kind string, resource types.Resource) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.lastID++
+	resource.SetID(fmt.Sprintf("id-%d", s.lastID))
+	data, _ := json.Marshal(resource)
+
+	if s.resources[kind] == nil {
+		s.resources[kind] = make(map[string]json.RawMessage)
+	}
+	s.resources[kind][resource.ID()] = data
+	s.etags[resource.ID()]++
+}
+
+func (s *fakeCrudServer) names(kind string) []string {
+	s.Lock()
+	defer s.Unlock()
+
+	var names []string
+	for _, data := range s.resources[kind] {
+		var resource struct{ Name string }
+		json.Unmarshal(data, &resource)
+		names = append(names, resource.Name)
+	}
+	return names
+}
+
+func (s *fakeCrudServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	kind := path[0]
+	if s.resources[kind] == nil {
+		s.resources[kind] = make(map[string]json.RawMessage)
+	}
+
+	if len(path) == 1 {
+		switch r.Method {
+		case "GET":
+			all := make(map[string]json.RawMessage)
+			for id, data := range s.resources[kind] {
+				all[id] = data
+			}
+			for id, data := range s.builtins[kind] {
+				all[id] = data
+			}
+			json.NewEncoder(w).Encode(all)
+		case "POST":
+			var resource map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&resource)
+			s.lastID++
+			id := fmt.Sprintf("id-%d", s.lastID)
+			resource["UUID"] = id
+			data, _ := json.Marshal(resource)
+			s.resources[kind][id] = data
+			s.etags[id]++
+			w.Write(data)
+		}
+		return
+	}
+
+	id := path[1]
+	if data, found := s.builtins[kind][id]; found {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+		return
+	}
+
+	data, found := s.resources[kind][id]
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%d"`, s.etags[id])
+	switch r.Method {
+	case "GET":
+		w.Header().Set("ETag", etag)
+		w.Write(data)
+	case "PUT":
+		if r.Header.Get("If-Match") != etag {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		data, _ = ioutil.ReadAll(r.Body)
+		s.resources[kind][id] = data
+		s.etags[id]++
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, s.etags[id]))
+		w.Write(data)
+	case "DELETE":
+		delete(s.resources[kind], id)
+	}
+}
+
+func newFakeCrudServer(t *testing.T) (*fakeCrudServer, *httptest.Server, *shttp.CrudClient) {
+	fake := &fakeCrudServer{
+		resources: make(map[string]map[string]json.RawMessage),
+		builtins:  make(map[string]map[string]json.RawMessage),
+		etags:     make(map[string]int),
+	}
+	server := httptest.NewServer(fake)
+
+	u, err := url.Parse(server.URL + "/api/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fake, server, shttp.NewCrudClient(u, nil, nil)
+}
+
+func mustReadBundle(t *testing.T, content string) []*bundleResource {
+	bundle, err := readBundle("test", strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bundle
+}
+
+func TestReadBundle(t *testing.T) {
+	bundle := mustReadBundle(t, `
+Kind: alert
+Name: a1
+Expression: G.V().Count()
+---
+Kind: Capture
+Name: c1
+GremlinQuery: G.V().Has('Name', 'eth0')
+`)
+
+	if len(bundle) != 2 {
+		t.Fatalf("Expected 2 resources, got %d", len(bundle))
+	}
+
+	if bundle[0].kind != "alert" || bundle[0].name != "a1" || bundle[0].resource.(*types.Alert).Expression != "G.V().Count()" {
+		t.Errorf("Unexpected first resource: %+v", bundle[0])
+	}
+
+	if bundle[1].kind != "capture" || bundle[1].name != "c1" || bundle[1].resource.(*types.Capture).GremlinQuery != "G.V().Has('Name', 'eth0')" {
+		t.Errorf("Unexpected second resource: %+v", bundle[1])
+	}
+
+	for _, content := range []string{
+		"Name: a1\nExpression: G.V()",
+		"Kind: alert\nExpression: G.V()",
+		"Kind: foo\nName: f1",
+		"Kind: alert\nName: a1",
+	} {
+		if _, err := readBundle("test", strings.NewReader(content)); err == nil {
+			t.Errorf("An error was expected for bundle: %s", content)
+		}
+	}
+}
+
+func TestLoadBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"alerts.yml":   "Kind: alert\nName: a1\nExpression: G.V()\n",
+		"captures.yml": "Kind: capture\nName: c1\nGremlinQuery: G.V()\n",
+		"README":       "not a bundle",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bundle, err := loadBundle([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bundle) != 2 || bundle[0].name != "a1" || bundle[1].name != "c1" {
+		t.Errorf("Unexpected bundle: %+v", bundle)
+	}
+
+	if _, err := loadBundle([]string{dir, filepath.Join(dir, "alerts.yml")}); err == nil {
+		t.Error("An error was expected for a resource defined twice")
+	}
+}
+
+func TestDiffResources(t *testing.T) {
+	current := &types.Alert{Name: "a1", Expression: "G.V()", Action: "http://localhost"}
+	desired := &types.Alert{Name: "a1", Expression: "G.V().Count()"}
+	desired.CreateTime = current.CreateTime
+
+	diff, err := diffResources(current, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`- Action: "http://localhost"`,
+		`- Expression: "G.V()"`,
+		`+ Expression: "G.V().Count()"`,
+	}
+
+	if strings.Join(diff, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected diff:\n%s", strings.Join(diff, "\n"))
+	}
+
+	if diff, _ := diffResources(current, current); len(diff) != 0 {
+		t.Errorf("No difference expected, got:\n%s", strings.Join(diff, "\n"))
+	}
+}
+
+func TestApplyBundle(t *testing.T) {
+	fake, server, crudClient := newFakeCrudServer(t)
+	defer server.Close()
+
+	fake.add("alert", &types.Alert{Name: "a1", Expression: "G.V()"})
+	fake.add("alert", &types.Alert{Name: "a2", Expression: "G.V()"})
+	fake.add("alert", &types.Alert{Name: "a4", Expression: "G.V()"})
+	fake.add("capture", &types.Capture{Name: "c1", GremlinQuery: "G.V()"})
+	fake.add("capture", &types.Capture{GremlinQuery: "G.V()"})
+
+	bundle := mustReadBundle(t, `
+Kind: alert
+Name: a1
+Expression: G.V().Count()
+---
+Kind: alert
+Name: a3
+Expression: G.V()
+---
+Kind: alert
+Name: a4
+Expression: G.V()
+`)
+
+	var out bytes.Buffer
+	if err := applyBundle(&out, crudClient, bundle, true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `alert/a1 updated (dry run)
+  - Expression: "G.V()"
+  + Expression: "G.V().Count()"
+alert/a3 created (dry run)
+alert/a4 unchanged (dry run)
+capture/c1 deleted (dry run)
+alert/a2 deleted (dry run)
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected dry run output:\n%s", out.String())
+	}
+
+	if len(fake.names("alert")) != 3 || len(fake.names("capture")) != 2 {
+		t.Fatal("A dry run should not modify the resources")
+	}
+
+	out.Reset()
+	if err := applyBundle(&out, crudClient, bundle, false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.names("alert")) != 4 || len(fake.names("capture")) != 2 {
+		t.Errorf("Only a3 should have been created, got alerts %v and captures %v", fake.names("alert"), fake.names("capture"))
+	}
+
+	out.Reset()
+	if err := applyBundle(&out, crudClient, bundle, false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = `alert/a1 unchanged
+alert/a3 unchanged
+alert/a4 unchanged
+capture/c1 deleted
+alert/a2 deleted
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+
+	// the unnamed capture is not managed by the bundles
+	if names := fake.names("capture"); len(names) != 1 || names[0] != "" {
+		t.Errorf("Only the unnamed capture should remain, got %v", names)
+	}
+
+	if names := fake.names("alert"); len(names) != 3 {
+		t.Errorf("Expected 3 alerts, got %v", names)
+	}
+}
+
+func TestApplyBundleBuiltins(t *testing.T) {
+	fake, server, crudClient := newFakeCrudServer(t)
+	defer server.Close()
+
+	fake.addBuiltin("workflow", &types.Workflow{Name: "CheckMTU", Source: "function() {}"})
+	fake.addBuiltin("workflow", &types.Workflow{Name: "FlowMatrix", Source: "function() {}"})
+	fake.add("workflow", &types.Workflow{Name: "w1", Source: "function() {}"})
+
+	// a workflow named as a builtin one is managed as any other workflow
+	bundle := mustReadBundle(t, `
+Kind: workflow
+Name: CheckMTU
+Source: function() { return 1 }
+`)
+
+	var out bytes.Buffer
+	if err := applyBundle(&out, crudClient, bundle, false, true); err != nil {
+		t.Fatalf("The builtin workflows should not be pruned: %s", err)
+	}
+
+	expected := `workflow/CheckMTU created
+workflow/w1 deleted
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+
+	out.Reset()
+	bundle[0].resource.(*types.Workflow).Source = "function() { return 2 }"
+	if err := applyBundle(&out, crudClient, bundle, false, true); err != nil {
+		t.Fatalf("The stored workflow should be updated instead of the builtin one: %s", err)
+	}
+
+	expected = `workflow/CheckMTU updated
+  - Source: "function() { return 1 }"
+  + Source: "function() { return 2 }"
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+
+	if names := fake.names("workflow"); len(names) != 1 || names[0] != "CheckMTU" {
+		t.Errorf("Only the workflow of the bundle should be stored, got %v", names)
+	}
+}
@@ -50,6 +50,14 @@ var (
 	reassembleTCP      bool
 	layerKeyMode       string
 	extraLayers        []string
+	workers            int
+	fanoutMode         string
+	ringBlockSize      int
+	ringBlocks         int
+	blockTimeout       int
+	packetRingSize     int64
+	packetRingDuration int
+	packetRingStorage  string
 	target             string
 	targetType         string
 )
@@ -100,6 +108,14 @@ var CaptureCreate = &cobra.Command{
 		capture.LayerKeyMode = layerKeyMode
 		capture.RawPacketLimit = rawPacketLimit
 		capture.ExtraLayers = layers
+		capture.Workers = workers
+		capture.FanoutMode = fanoutMode
+		capture.RingBlockSize = ringBlockSize
+		capture.RingBlocks = ringBlocks
+		capture.BlockTimeout = blockTimeout
+		capture.PacketRingSize = packetRingSize
+		capture.PacketRingDuration = packetRingDuration
+		capture.PacketRingStorage = packetRingStorage
 		capture.Target = target
 		capture.TargetType = targetType
 
@@ -121,6 +137,132 @@ var CaptureCreate = &cobra.Command{
 	},
 }
 
+// CaptureUpdate skydive capture update command
+var CaptureUpdate = &cobra.Command{
+	Use:   "update [capture]",
+	Short: "Update capture",
+	Long:  "Update capture, only the specified options are modified",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		if nodeTID != "" {
+			if gremlinQuery != "" {
+				exitOnError(errors.New("Options --node and --gremlin are exclusive"))
+			}
+			gremlinQuery = fmt.Sprintf("g.V().Has('TID', '%s')", nodeTID)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		var capture api.Capture
+		etag, err := client.GetWithETag("capture", args[0], &capture)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		flags := cmd.Flags()
+		if flags.Changed("gremlin") || flags.Changed("node") {
+			capture.GremlinQuery = gremlinQuery
+		}
+		if flags.Changed("bpf") {
+			capture.BPFFilter = bpfFilter
+		}
+		if flags.Changed("name") {
+			capture.Name = captureName
+		}
+		if flags.Changed("description") {
+			capture.Description = captureDescription
+		}
+		if flags.Changed("type") {
+			capture.Type = captureType
+		}
+		if flags.Changed("port") {
+			capture.Port = port
+		}
+		if flags.Changed("samplingrate") {
+			capture.SamplingRate = samplingRate
+		}
+		if flags.Changed("pollinginterval") {
+			capture.PollingInterval = pollingInterval
+		}
+		if flags.Changed("header-size") {
+			capture.HeaderSize = headerSize
+		}
+		if flags.Changed("rawpacket-limit") {
+			capture.RawPacketLimit = rawPacketLimit
+		}
+		if flags.Changed("extra-tcp-metric") {
+			capture.ExtraTCPMetric = extraTCPMetric
+		}
+		if flags.Changed("ip-defrag") {
+			capture.IPDefrag = ipDefrag
+		}
+		if flags.Changed("reassamble-tcp") {
+			capture.ReassembleTCP = reassembleTCP
+		}
+		if flags.Changed("layer-key-mode") {
+			capture.LayerKeyMode = layerKeyMode
+		}
+		if flags.Changed("extra-layer") {
+			var layers flow.ExtraLayers
+			if err := layers.Parse(extraLayers...); err != nil {
+				exitOnError(err)
+			}
+			capture.ExtraLayers = layers
+		}
+		if flags.Changed("workers") {
+			capture.Workers = workers
+		}
+		if flags.Changed("fanout-mode") {
+			capture.FanoutMode = fanoutMode
+		}
+		if flags.Changed("ring-block-size") {
+			capture.RingBlockSize = ringBlockSize
+		}
+		if flags.Changed("ring-blocks") {
+			capture.RingBlocks = ringBlocks
+		}
+		if flags.Changed("block-timeout") {
+			capture.BlockTimeout = blockTimeout
+		}
+		if flags.Changed("packet-ring-size") {
+			capture.PacketRingSize = packetRingSize
+		}
+		if flags.Changed("packet-ring-duration") {
+			capture.PacketRingDuration = packetRingDuration
+		}
+		if flags.Changed("packet-ring-storage") {
+			capture.PacketRingStorage = packetRingStorage
+		}
+		if flags.Changed("target") {
+			capture.Target = target
+		}
+		if flags.Changed("target-type") {
+			capture.TargetType = targetType
+		}
+
+		if err := validator.Validate(&capture); err != nil {
+			exitOnError(err)
+		}
+
+		updateOpts := &http.UpdateOptions{ETag: etag}
+		if captureTTL != 0 {
+			updateOpts.TTL = time.Duration(captureTTL) * time.Millisecond
+		}
+
+		if err := client.Update("capture", args[0], &capture, updateOpts); err != nil {
+			exitOnError(err)
+		}
+		printJSON(&capture)
+	},
+}
+
 // CaptureList skydive capture list command
 var CaptureList = &cobra.Command{
 	Use:   "list",
@@ -208,16 +350,27 @@ func addCaptureFlags(cmd *cobra.Command) {
 	cmd.Flags().BoolVarP(&reassembleTCP, "reassamble-tcp", "", false, "reassemble TCP packets, default: false")
 	cmd.Flags().StringVarP(&layerKeyMode, "layer-key-mode", "", "L2", "defines the first layer used by flow key calculation, L2 or L3")
 	cmd.Flags().StringArrayVarP(&extraLayers, "extra-layer", "", []string{}, fmt.Sprintf("list of extra layers to be added to the flow, available: %s", flow.ExtraLayers(flow.ALLLayer)))
+	cmd.Flags().IntVarP(&workers, "workers", "", 0, "number of afpacket sockets of the fanout group, each one read by its own worker, default: 1")
+	cmd.Flags().StringVarP(&fanoutMode, "fanout-mode", "", "", "fanout mode used to dispatch packets to the workers, hash or cpu, default: hash")
+	cmd.Flags().IntVarP(&ringBlockSize, "ring-block-size", "", 0, "size in bytes of the blocks of the afpacket ring buffer")
+	cmd.Flags().IntVarP(&ringBlocks, "ring-blocks", "", 0, "number of blocks of the afpacket ring buffer")
+	cmd.Flags().IntVarP(&blockTimeout, "block-timeout", "", 0, "timeout in milliseconds after which a partially filled afpacket block is retired")
+	cmd.Flags().Int64VarP(&packetRingSize, "packet-ring-size", "", 0, "size in bytes of the rolling buffer keeping the last packets of the capture, 0: no size bound")
+	cmd.Flags().IntVarP(&packetRingDuration, "packet-ring-duration", "", 0, "duration in seconds of the packets kept by the rolling buffer, 0: no duration bound")
+	cmd.Flags().StringVarP(&packetRingStorage, "packet-ring-storage", "", "", "storage of the rolling buffer, memory or disk, default: memory")
 	cmd.Flags().StringVarP(&target, "target", "", "", "sFlow/NetFlow target, if empty the agent will be used")
-	cmd.Flags().StringVarP(&targetType, "target-type", "", "", "target type (netflowv5, erspanv1), ignored in case of sFlow/NetFlow capture")
+	cmd.Flags().StringVarP(&targetType, "target-type", "", "", "target type (netflowv5, erspanv1, ipfix), ignored in case of sFlow/NetFlow capture")
 	cmd.Flags().Uint64VarP(&captureTTL, "ttl", "", 0, "capture duration in milliseconds")
 }
 
 func init() {
 	CaptureCmd.AddCommand(CaptureList)
 	CaptureCmd.AddCommand(CaptureCreate)
+	CaptureCmd.AddCommand(CaptureUpdate)
 	CaptureCmd.AddCommand(CaptureGet)
 	CaptureCmd.AddCommand(CaptureDelete)
+	CaptureCmd.AddCommand(CaptureDump)
 
 	addCaptureFlags(CaptureCreate)
+	addCaptureFlags(CaptureUpdate)
 }
@@ -0,0 +1,85 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/skydive-project/skydive/api/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dumpFrom string
+	dumpTo   string
+	dumpBPF  string
+)
+
+// CaptureDump skydive capture dump command
+var CaptureDump = &cobra.Command{
+	Use:   "dump [capture]",
+	Short: "Dump the packets kept by the packet rings of a capture as pcap",
+	Long:  "Dump the packets kept by the packet rings of a capture as pcap",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		values := url.Values{}
+		if dumpFrom != "" {
+			values.Set("from", dumpFrom)
+		}
+		if dumpTo != "" {
+			values.Set("to", dumpTo)
+		}
+		if dumpBPF != "" {
+			values.Set("bpf", dumpBPF)
+		}
+
+		resp, err := client.Request("POST", "capture/"+args[0]+"/dump?"+values.Encode(), nil, nil)
+		if err != nil {
+			exitOnError(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			data, _ := ioutil.ReadAll(resp.Body)
+			exitOnError(fmt.Errorf("%s: %s", resp.Status, string(data)))
+		}
+
+		bufio.NewReader(resp.Body).WriteTo(os.Stdout)
+	},
+}
+
+func init() {
+	CaptureDump.Flags().StringVarP(&dumpFrom, "from", "", "", "start of the time range, in milliseconds, RFC3339 or as a duration before now, e.g. 1m")
+	CaptureDump.Flags().StringVarP(&dumpTo, "to", "", "", "end of the time range, in milliseconds, RFC3339 or as a duration before now, default: now")
+	CaptureDump.Flags().StringVarP(&dumpBPF, "bpf", "", "", "BPF filter")
+}
@@ -46,12 +46,14 @@ var ClientCmd = &cobra.Command{
 // RegisterClientCommands registers the 'client' CLI subcommands
 func RegisterClientCommands(cmd *cobra.Command) {
 	cmd.AddCommand(AlertCmd)
+	cmd.AddCommand(ApplyCmd)
 	cmd.AddCommand(CaptureCmd)
 	cmd.AddCommand(PacketInjectorCmd)
 	cmd.AddCommand(PcapCmd)
 	cmd.AddCommand(QueryCmd)
 	cmd.AddCommand(ShellCmd)
 	cmd.AddCommand(StatusCmd)
+	cmd.AddCommand(TokenCmd)
 	cmd.AddCommand(TopologyCmd)
 	cmd.AddCommand(WorkflowCmd)
 	cmd.AddCommand(NodeRuleCmd)
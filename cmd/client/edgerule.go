@@ -24,6 +24,7 @@ import (
 	"github.com/skydive-project/skydive/api/client"
 	api "github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/graffiti/graph"
+	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
 	usertopology "github.com/skydive-project/skydive/topology/enhancers"
 	"github.com/skydive-project/skydive/validator"
@@ -89,6 +90,71 @@ var EdgeRuleCreate = &cobra.Command{
 	},
 }
 
+// EdgeRuleUpdate skydive edge update command
+var EdgeRuleUpdate = &cobra.Command{
+	Use:          "update",
+	Short:        "update",
+	Long:         "update, only the specified options are modified",
+	SilenceUsage: false,
+
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		var edge api.EdgeRule
+		etag, err := client.GetWithETag("edgerule", args[0], &edge)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		flags := cmd.Flags()
+		if flags.Changed("name") {
+			edge.Name = name
+		}
+		if flags.Changed("description") {
+			edge.Description = description
+		}
+		if flags.Changed("src") {
+			edge.Src = src
+		}
+		if flags.Changed("dst") {
+			edge.Dst = dst
+		}
+		if edge.Metadata == nil {
+			edge.Metadata = graph.Metadata{}
+		}
+		if flags.Changed("metadata") {
+			relationType := edge.Metadata["RelationType"]
+			if edge.Metadata, err = usertopology.DefToMetadata(metadata, graph.Metadata{}); err != nil {
+				exitOnError(err)
+			}
+			edge.Metadata["RelationType"] = relationType
+		}
+		if flags.Changed("relationtype") {
+			edge.Metadata["RelationType"] = relationType
+		}
+
+		if err = validator.Validate(&edge); err != nil {
+			exitOnError(fmt.Errorf("Error while validating edge rule: %s", err))
+		}
+
+		if err = client.Update("edgerule", args[0], &edge, &shttp.UpdateOptions{ETag: etag}); err != nil {
+			exitOnError(err)
+		}
+
+		printJSON(&edge)
+	},
+}
+
 // EdgeRuleGet skydive edge get command
 var EdgeRuleGet = &cobra.Command{
 	Use:          "get",
@@ -176,9 +242,11 @@ func addCreateEdgeRuleFlags(cmd *cobra.Command) {
 
 func init() {
 	EdgeRuleCmd.AddCommand(EdgeRuleCreate)
+	EdgeRuleCmd.AddCommand(EdgeRuleUpdate)
 	EdgeRuleCmd.AddCommand(EdgeRuleList)
 	EdgeRuleCmd.AddCommand(EdgeRuleGet)
 	EdgeRuleCmd.AddCommand(EdgeRuleDelete)
 
 	addCreateEdgeRuleFlags(EdgeRuleCreate)
+	addCreateEdgeRuleFlags(EdgeRuleUpdate)
 }
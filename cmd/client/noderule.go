@@ -25,6 +25,7 @@ import (
 	"github.com/skydive-project/skydive/api/client"
 	api "github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/graffiti/graph"
+	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
 	usertopology "github.com/skydive-project/skydive/topology/enhancers"
 	"github.com/skydive-project/skydive/validator"
@@ -97,6 +98,74 @@ var NodeRuleCreate = &cobra.Command{
 	},
 }
 
+// NodeRuleUpdate skydive node update command
+var NodeRuleUpdate = &cobra.Command{
+	Use:          "update",
+	Short:        "update",
+	Long:         "update, only the specified options are modified",
+	SilenceUsage: false,
+
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		var node api.NodeRule
+		etag, err := client.GetWithETag("noderule", args[0], &node)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		flags := cmd.Flags()
+		if flags.Changed("name") {
+			node.Name = name
+		}
+		if flags.Changed("description") {
+			node.Description = description
+		}
+		if flags.Changed("query") {
+			node.Query = query
+		}
+		if flags.Changed("action") {
+			node.Action = action
+		}
+		if flags.Changed("metadata") {
+			if node.Metadata, err = usertopology.DefToMetadata(metadata, graph.Metadata{}); err != nil {
+				exitOnError(err)
+			}
+		}
+		if node.Metadata == nil {
+			node.Metadata = graph.Metadata{}
+		}
+		if node.Action == "create" {
+			if flags.Changed("node-name") {
+				node.Metadata["Name"] = nodeName
+			}
+			if flags.Changed("node-type") {
+				node.Metadata["Type"] = nodeType
+			}
+		}
+
+		if err = validator.Validate(&node); err != nil {
+			exitOnError(fmt.Errorf("Error while validating node rule: %s", err))
+		}
+
+		if err = client.Update("noderule", args[0], &node, &shttp.UpdateOptions{ETag: etag}); err != nil {
+			exitOnError(err)
+		}
+
+		printJSON(&node)
+	},
+}
+
 // NodeRuleGet skydive node get command
 var NodeRuleGet = &cobra.Command{
 	Use:          "get",
@@ -190,9 +259,11 @@ func addCreateNodeRuleFlags(cmd *cobra.Command) {
 
 func init() {
 	NodeRuleCmd.AddCommand(NodeRuleCreate)
+	NodeRuleCmd.AddCommand(NodeRuleUpdate)
 	NodeRuleCmd.AddCommand(NodeRuleList)
 	NodeRuleCmd.AddCommand(NodeRuleGet)
 	NodeRuleCmd.AddCommand(NodeRuleDelete)
 
 	addCreateNodeRuleFlags(NodeRuleCreate)
+	addCreateNodeRuleFlags(NodeRuleUpdate)
 }
@@ -32,8 +32,9 @@ import (
 )
 
 var (
-	srcNode string
-	dstNode string
+	injectionName string
+	srcNode       string
+	dstNode       string
 )
 
 // PacketInjectorCmd skydive inject-packet root command
@@ -63,6 +64,7 @@ var PacketInjectionCreate = &cobra.Command{
 		}
 
 		packet := &api.PacketInjection{
+			Name:             injectionName,
 			Src:              srcNode,
 			Dst:              dstNode,
 			SrcPort:          request.SrcPort,
@@ -98,11 +100,7 @@ var PacketInjectionCreate = &cobra.Command{
 			exitOnError(err)
 		}
 
-		ttl := 5 * time.Second
-		if packet.Interval != 0 {
-			ttl = time.Duration(packet.Interval*packet.Count)*time.Millisecond + 5*time.Second
-		}
-		createOpts := &http.CreateOptions{TTL: ttl}
+		createOpts := &http.CreateOptions{TTL: injectionTTL(packet)}
 
 		if err := crudClient.Create("injectpacket", &packet, createOpts); err != nil {
 			exitOnError(err)
@@ -181,12 +179,23 @@ var PacketInjectionDelete = &cobra.Command{
 	},
 }
 
+// injectionTTL returns the time to live of a packet injection resource,
+// long enough for all the packets to be injected
+func injectionTTL(packet *api.PacketInjection) time.Duration {
+	ttl := 5 * time.Second
+	if packet.Interval != 0 {
+		ttl = time.Duration(packet.Interval*packet.Count)*time.Millisecond + 5*time.Second
+	}
+	return ttl
+}
+
 func init() {
 	PacketInjectorCmd.AddCommand(PacketInjectionList)
 	PacketInjectorCmd.AddCommand(PacketInjectionGet)
 	PacketInjectorCmd.AddCommand(PacketInjectionDelete)
 	PacketInjectorCmd.AddCommand(PacketInjectionCreate)
 
+	PacketInjectionCreate.Flags().StringVarP(&injectionName, "name", "", "", "injection name")
 	PacketInjectionCreate.Flags().StringVarP(&srcNode, "src", "", "", "source node gremlin expression (mandatory)")
 	PacketInjectionCreate.Flags().StringVarP(&dstNode, "dst", "", "", "destination node gremlin expression")
 	injector.AddInjectPacketInjectFlags(PacketInjectionCreate)
@@ -18,19 +18,28 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 
 	"github.com/skydive-project/skydive/api/client"
+	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/logging"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	pcapTrace string
+	pcapTrace      string
+	pcapTopology   bool
+	pcapSpeed      float64
+	pcapBPF        string
+	pcapRawPackets int
 )
 
 // PcapCmd skydive pcap root command
@@ -57,6 +66,35 @@ var PcapCmd = &cobra.Command{
 		}
 		defer file.Close()
 
+		if pcapTopology {
+			values := url.Values{
+				"name":       {filepath.Base(pcapTrace)},
+				"speed":      {strconv.FormatFloat(pcapSpeed, 'f', -1, 64)},
+				"rawpackets": {strconv.Itoa(pcapRawPackets)},
+			}
+			if pcapBPF != "" {
+				values.Set("bpf", pcapBPF)
+			}
+
+			resp, err := client.Request("POST", "pcapfile?"+values.Encode(), file, nil)
+			if err != nil {
+				exitOnError(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusAccepted {
+				content, _ := ioutil.ReadAll(resp.Body)
+				exitOnError(fmt.Errorf("Failed to import %s: %s", pcapTrace, string(content)))
+			}
+
+			var pcapFile types.PcapFile
+			if err := json.NewDecoder(resp.Body).Decode(&pcapFile); err != nil {
+				exitOnError(err)
+			}
+			printJSON(&pcapFile)
+			return
+		}
+
 		resp, err := client.Request("POST", "pcap", file, nil)
 		if err != nil {
 			exitOnError(err)
@@ -72,5 +110,9 @@ var PcapCmd = &cobra.Command{
 }
 
 func init() {
-	PcapCmd.Flags().StringVarP(&pcapTrace, "trace", "t", "", "PCAP trace file to read")
+	PcapCmd.Flags().StringVarP(&pcapTrace, "trace", "t", "", "PCAP or PCAPNG trace file to read")
+	PcapCmd.Flags().BoolVarP(&pcapTopology, "topology", "", false, "attach the flows to the nodes of the topology, the file being ingested in the background")
+	PcapCmd.Flags().Float64VarP(&pcapSpeed, "speed", "", 0, "replay speed used with --topology, 0 to keep the original timestamps")
+	PcapCmd.Flags().StringVarP(&pcapBPF, "bpf", "", "", "BPF filter used with --topology")
+	PcapCmd.Flags().IntVarP(&pcapRawPackets, "raw-packets", "", 10, "maximum number of raw packets stored per flow with --topology")
 }
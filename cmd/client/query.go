@@ -71,9 +71,13 @@ var QueryCmd = &cobra.Command{
 				exitOnError(fmt.Errorf("%s: %s", resp.Status, string(data)))
 			}
 			bufio.NewReader(resp.Body).WriteTo(os.Stdout)
-		case "pcap":
+		case "pcap", "pcapng":
 			header := make(http.Header)
-			header.Set("Accept", "vnd.tcpdump.pcap")
+			if outputFormat == "pcapng" {
+				header.Set("Accept", "application/x-pcapng")
+			} else {
+				header.Set("Accept", "vnd.tcpdump.pcap")
+			}
 			resp, err := queryHelper.Request(gremlinQuery, header)
 			if err != nil {
 				exitOnError(err)
@@ -94,5 +98,5 @@ var QueryCmd = &cobra.Command{
 }
 
 func init() {
-	QueryCmd.Flags().StringVarP(&outputFormat, "format", "", "json", "Output format (json, dot or pcap)")
+	QueryCmd.Flags().StringVarP(&outputFormat, "format", "", "json", "Output format (json, dot, pcap or pcapng)")
 }
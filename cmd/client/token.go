@@ -0,0 +1,134 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package client
+
+import (
+	"os"
+	"time"
+
+	"github.com/skydive-project/skydive/api/client"
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/validator"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	tokenName           string
+	tokenDescription    string
+	tokenServiceAccount string
+	tokenRole           string
+	tokenExpire         time.Duration
+)
+
+// TokenCmd skydive token root command
+var TokenCmd = &cobra.Command{
+	Use:          "token",
+	Short:        "Manage API tokens",
+	Long:         "Manage the API tokens of the service accounts",
+	SilenceUsage: false,
+}
+
+// TokenCreate skydive token create command
+var TokenCreate = &cobra.Command{
+	Use:   "create",
+	Short: "Create API token",
+	Long:  "Create API token, the token is only displayed once and should be used as the password of the service account",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		token := &types.APIToken{
+			Name:           tokenName,
+			Description:    tokenDescription,
+			ServiceAccount: tokenServiceAccount,
+			Role:           tokenRole,
+			CreateTime:     time.Now().UTC(),
+		}
+		if tokenExpire != 0 {
+			token.ExpireTime = time.Now().UTC().Add(tokenExpire)
+		}
+
+		if err := validator.Validate(token); err != nil {
+			exitOnError(err)
+		}
+
+		if err := client.Create("token", &token, nil); err != nil {
+			exitOnError(err)
+		}
+		printJSON(&token)
+	},
+}
+
+// TokenList skydive token list command
+var TokenList = &cobra.Command{
+	Use:   "list",
+	Short: "List API tokens",
+	Long:  "List API tokens",
+	Run: func(cmd *cobra.Command, args []string) {
+		var tokens map[string]types.APIToken
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			exitOnError(err)
+		}
+		if err := client.List("token", &tokens); err != nil {
+			exitOnError(err)
+		}
+		printJSON(tokens)
+	},
+}
+
+// TokenRevoke skydive token revoke command
+var TokenRevoke = &cobra.Command{
+	Use:   "revoke [token]",
+	Short: "Revoke API token",
+	Long:  "Revoke API token",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		for _, id := range args {
+			if err := client.Delete("token", id); err != nil {
+				logging.GetLogger().Error(err)
+			}
+		}
+	},
+}
+
+func init() {
+	TokenCmd.AddCommand(TokenCreate)
+	TokenCmd.AddCommand(TokenList)
+	TokenCmd.AddCommand(TokenRevoke)
+
+	TokenCreate.Flags().StringVarP(&tokenName, "name", "", "", "token name")
+	TokenCreate.Flags().StringVarP(&tokenDescription, "description", "", "", "description of the token")
+	TokenCreate.Flags().StringVarP(&tokenServiceAccount, "service-account", "", "", "service account authenticated by the token")
+	TokenCreate.Flags().StringVarP(&tokenRole, "role", "", "", "role granted to the service account")
+	TokenCreate.Flags().DurationVarP(&tokenExpire, "expire", "", 0, "validity duration of the token, the token never expires if not set")
+}
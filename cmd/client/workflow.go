@@ -28,6 +28,7 @@ import (
 	"github.com/skydive-project/skydive/api/client"
 	"github.com/skydive-project/skydive/api/types"
 	"github.com/skydive-project/skydive/common"
+	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/validator"
 
@@ -93,6 +94,43 @@ var WorkflowCreate = &cobra.Command{
 	},
 }
 
+// WorkflowUpdate describes the "workflow update" command
+var WorkflowUpdate = &cobra.Command{
+	Use:          "update",
+	Short:        "update workflow",
+	Long:         "update workflow",
+	SilenceUsage: false,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := client.NewCrudClientFromConfig(&AuthenticationOpts)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		workflow, err := loadWorklow(workflowPath)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		// the workflow is replaced, unless modified since it was read
+		var current types.Workflow
+		etag, err := client.GetWithETag("workflow", args[0], &current)
+		if err != nil {
+			exitOnError(err)
+		}
+
+		if err := client.Update("workflow", args[0], &workflow, &shttp.UpdateOptions{ETag: etag}); err != nil {
+			exitOnError(err)
+		}
+		printJSON(workflow)
+	},
+}
+
 // WorkflowDelete describes the "workflow delete" command
 var WorkflowDelete = &cobra.Command{
 	Use:          "delete",
@@ -188,9 +226,11 @@ var WorkflowCall = &cobra.Command{
 
 func init() {
 	WorkflowCmd.AddCommand(WorkflowCreate)
+	WorkflowCmd.AddCommand(WorkflowUpdate)
 	WorkflowCmd.AddCommand(WorkflowDelete)
 	WorkflowCmd.AddCommand(WorkflowList)
 	WorkflowCmd.AddCommand(WorkflowCall)
 
 	WorkflowCreate.Flags().StringVarP(&workflowPath, "path", "", "", "Workflow path")
+	WorkflowUpdate.Flags().StringVarP(&workflowPath, "path", "", "", "Workflow path")
 }
@@ -43,7 +43,7 @@ const (
 
 var (
 	// ProbeTypes returns a list of all the capture probes
-	ProbeTypes = []string{"ovssflow", "pcapsocket", "ovsmirror", "dpdk", "afpacket", "pcap", "ebpf", "sflow", "ovsnetflow"}
+	ProbeTypes = []string{"ovssflow", "pcapsocket", "ovsmirror", "dpdk", "afpacket", "pcap", "afxdp", "ebpf", "ebpfsocket", "ebpfdrop", "sflow", "ovsnetflow", "ipfix", "erspan"}
 
 	// CaptureTypes contains all registered capture type and associated probes
 	CaptureTypes = map[string]CaptureType{}
@@ -57,6 +57,9 @@ func initCaptureTypes() {
 	CaptureTypes["ovsport"] = CaptureType{Allowed: []string{"ovsmirror"}, Default: "ovsmirror"}
 	CaptureTypes["dpdkport"] = CaptureType{Allowed: []string{"dpdk"}, Default: "dpdk"}
 
+	// socket level and kernel drop captures are only started when explicitly requested
+	CaptureTypes["host"] = CaptureType{Allowed: []string{"ebpfsocket", "ebpfdrop"}}
+
 	// anything else will be handled by gopacket
 	types := []string{
 		"internal", "veth", "tun", "bridge", "dummy", "gre",
@@ -66,7 +69,7 @@ func initCaptureTypes() {
 	}
 
 	for _, t := range types {
-		CaptureTypes[t] = CaptureType{Allowed: []string{"afpacket", "pcap", "pcapsocket", "sflow", "ebpf"}, Default: "afpacket"}
+		CaptureTypes[t] = CaptureType{Allowed: []string{"afpacket", "pcap", "afxdp", "pcapsocket", "sflow", "ebpf", "ipfix", "erspan"}, Default: "afpacket"}
 	}
 }
 
@@ -79,13 +82,17 @@ func IsCaptureAllowed(nodeType string) bool {
 func initProbeCapabilities() {
 	ProbeCapabilities["afpacket"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability | MultipleOnSameNodeCapability
 	ProbeCapabilities["pcap"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability | MultipleOnSameNodeCapability
+	ProbeCapabilities["afxdp"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
 	ProbeCapabilities["pcapsocket"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
 	ProbeCapabilities["sflow"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
 	ProbeCapabilities["ovssflow"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
 	ProbeCapabilities["dpdk"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
 	ProbeCapabilities["ovsmirror"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
 	ProbeCapabilities["ebpf"] = ExtraTCPMetricCapability
+	ProbeCapabilities["ebpfsocket"] = 0
+	ProbeCapabilities["ebpfdrop"] = 0
 	ProbeCapabilities["ovsnetflow"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
+	ProbeCapabilities["erspan"] = BPFCapability | RawPacketsCapability | ExtraTCPMetricCapability
 }
 
 // CheckProbeCapabilities checks that a probe supports given capabilities
@@ -0,0 +1,241 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	tracingPaths  = []string{"/sys/kernel/debug/tracing", "/sys/kernel/tracing"}
+	symbolicRegex = regexp.MustCompile(`\{\s*(-?(?:0x[0-9a-fA-F]+|[0-9]+))\s*,\s*"([^"]*)"\s*\}`)
+)
+
+// KernelSymbols resolves kernel addresses to the name of the kernel
+// functions they belong to
+type KernelSymbols struct {
+	addrs []uint64
+	names []string
+}
+
+// TracepointField describes a field of the record of a kernel tracepoint
+type TracepointField struct {
+	Name   string
+	Offset int
+	Size   int
+}
+
+// TracepointFormat describes the record of a kernel tracepoint
+type TracepointFormat struct {
+	Fields   map[string]TracepointField
+	PrintFmt string
+}
+
+type kernelSymbol struct {
+	addr uint64
+	name string
+}
+
+// ParseKernelSymbols reads the kernel symbols in the /proc/kallsyms format,
+// keeping only the text symbols
+func ParseKernelSymbols(r io.Reader) (*KernelSymbols, error) {
+	var symbols []kernelSymbol
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		if fields[1] != "t" && fields[1] != "T" {
+			continue
+		}
+
+		addr, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid symbol address %s: %s", fields[0], err)
+		}
+
+		// addresses are hidden to unprivileged users
+		if addr == 0 {
+			continue
+		}
+
+		symbols = append(symbols, kernelSymbol{addr: addr, name: fields[2]})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return symbols[i].addr < symbols[j].addr
+	})
+
+	ks := &KernelSymbols{
+		addrs: make([]uint64, len(symbols)),
+		names: make([]string, len(symbols)),
+	}
+	for i, symbol := range symbols {
+		ks.addrs[i], ks.names[i] = symbol.addr, symbol.name
+	}
+
+	return ks, nil
+}
+
+// NewKernelSymbols returns the symbols of the running kernel
+func NewKernelSymbols() (*KernelSymbols, error) {
+	f, err := os.Open("/proc/kallsyms")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseKernelSymbols(f)
+}
+
+// Lookup returns the name of the kernel function containing the given
+// address, or an empty string if unknown
+func (ks *KernelSymbols) Lookup(addr uint64) string {
+	i := sort.Search(len(ks.addrs), func(i int) bool {
+		return ks.addrs[i] > addr
+	})
+	if i == 0 {
+		return ""
+	}
+
+	return ks.names[i-1]
+}
+
+// ParseTracepointFormat reads the record description of a tracepoint, as
+// exposed by the format file of the tracing events
+func ParseTracepointFormat(r io.Reader) (*TracepointFormat, error) {
+	tf := &TracepointFormat{
+		Fields: make(map[string]TracepointField),
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "print fmt:") {
+			tf.PrintFmt = strings.TrimSpace(strings.TrimPrefix(line, "print fmt:"))
+			continue
+		}
+
+		if !strings.HasPrefix(line, "field:") {
+			continue
+		}
+
+		var field TracepointField
+		for _, attr := range strings.Split(line, ";") {
+			kv := strings.SplitN(strings.TrimSpace(attr), ":", 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			switch kv[0] {
+			case "field":
+				// the name is the last word of the declaration, array size excluded
+				decl := strings.Fields(kv[1])
+				if len(decl) == 0 {
+					return nil, fmt.Errorf("Invalid tracepoint field: %s", line)
+				}
+				name := decl[len(decl)-1]
+				if i := strings.Index(name, "["); i != -1 {
+					name = name[:i]
+				}
+				field.Name = strings.TrimLeft(name, "*")
+			case "offset":
+				offset, err := strconv.Atoi(kv[1])
+				if err != nil {
+					return nil, fmt.Errorf("Invalid offset of tracepoint field: %s", line)
+				}
+				field.Offset = offset
+			case "size":
+				size, err := strconv.Atoi(kv[1])
+				if err != nil {
+					return nil, fmt.Errorf("Invalid size of tracepoint field: %s", line)
+				}
+				field.Size = size
+			}
+		}
+
+		if field.Name != "" {
+			tf.Fields[field.Name] = field
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return tf, nil
+}
+
+// GetTracepointFormat returns the record description of a tracepoint of
+// the running kernel
+func GetTracepointFormat(category, name string) (*TracepointFormat, error) {
+	for _, path := range tracingPaths {
+		f, err := os.Open(fmt.Sprintf("%s/events/%s/%s/format", path, category, name))
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		return ParseTracepointFormat(f)
+	}
+
+	return nil, fmt.Errorf("Unable to find the format of tracepoint %s:%s", category, name)
+}
+
+// Symbols returns the names of the values of a field, as given by the
+// __print_symbolic call of the print format
+func (tf *TracepointFormat) Symbols(field string) map[int64]string {
+	symbols := make(map[int64]string)
+
+	start := strings.Index(tf.PrintFmt, "__print_symbolic(REC->"+field+",")
+	if start == -1 {
+		return symbols
+	}
+
+	// the symbol list ends with the closing parenthesis of the call
+	table := tf.PrintFmt[start:]
+	if end := strings.Index(table, "})"); end != -1 {
+		table = table[:end+1]
+	}
+
+	for _, match := range symbolicRegex.FindAllStringSubmatch(table, -1) {
+		value, err := strconv.ParseInt(match[1], 0, 64)
+		if err != nil {
+			continue
+		}
+		symbols[value] = match[2]
+	}
+
+	return symbols
+}
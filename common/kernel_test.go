@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const kallsyms = `ffffffff81000000 T _stext
+ffffffff81a2c3e0 t nf_hook_slow
+ffffffff81a2c4a0 T nf_hook_slow_list
+ffffffff819f0000 d some_data
+ffffffff81b10450 T tcp_v4_rcv
+ffffffff81b12000 T tcp_v4_early_demux [some_module]
+`
+
+const kfreeSkbFormat = `name: kfree_skb
+ID: 1469
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:void * skbaddr;	offset:8;	size:8;	signed:0;
+	field:void * location;	offset:16;	size:8;	signed:0;
+	field:unsigned short protocol;	offset:24;	size:2;	signed:0;
+	field:enum skb_drop_reason reason;	offset:28;	size:4;	signed:0;
+
+print fmt: "skbaddr=%p protocol=%u location=%pS reason: %s", REC->skbaddr, REC->protocol, REC->location, __print_symbolic(REC->reason, { 2, "NOT_SPECIFIED" }, { 3, "NO_SOCKET" }, { 0x1b, "NETFILTER_DROP" })
+`
+
+func TestKernelSymbols(t *testing.T) {
+	ks, err := ParseKernelSymbols(strings.NewReader(kallsyms))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[uint64]string{
+		0xffffffff81a2c3e0: "nf_hook_slow",
+		0xffffffff81a2c412: "nf_hook_slow",
+		0xffffffff81b10500: "tcp_v4_rcv",
+		0xffffffff81b12010: "tcp_v4_early_demux",
+		0xffffffff80000000: "",
+	}
+
+	for addr, expected := range tests {
+		if name := ks.Lookup(addr); name != expected {
+			t.Errorf("Expected %s for address %x, got %s", expected, addr, name)
+		}
+	}
+}
+
+func TestTracepointFormat(t *testing.T) {
+	tf, err := ParseTracepointFormat(strings.NewReader(kfreeSkbFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]TracepointField{
+		"skbaddr":  {Name: "skbaddr", Offset: 8, Size: 8},
+		"location": {Name: "location", Offset: 16, Size: 8},
+		"protocol": {Name: "protocol", Offset: 24, Size: 2},
+		"reason":   {Name: "reason", Offset: 28, Size: 4},
+	}
+
+	for name, field := range expected {
+		if tf.Fields[name] != field {
+			t.Errorf("Expected %+v for field %s, got %+v", field, name, tf.Fields[name])
+		}
+	}
+
+	symbols := tf.Symbols("reason")
+	if !reflect.DeepEqual(symbols, map[int64]string{2: "NOT_SPECIFIED", 3: "NO_SOCKET", 27: "NETFILTER_DROP"}) {
+		t.Errorf("Wrong reason symbols: %v", symbols)
+	}
+
+	if symbols := tf.Symbols("protocol"); len(symbols) != 0 {
+		t.Errorf("Expected no protocol symbols, got %v", symbols)
+	}
+}
@@ -18,12 +18,26 @@
 package common
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"sync"
+	"time"
 )
 
+// TLSReloadInterval is the minimum interval between two checks
+// of the modification of the TLS files
+const TLSReloadInterval = 5 * time.Second
+
+// ErrCertificateRevoked is returned when a peer certificate was revoked
+var ErrCertificateRevoked = errors.New("Certificate revoked")
+
 // SetupTLSLoadCA creates an X509 certificate from file
 func SetupTLSLoadCA(certPEM string) (*x509.CertPool, error) {
 	rootPEM, err := ioutil.ReadFile(certPEM)
@@ -70,3 +84,330 @@ func SetupTLSServerConfig(certPEM string, keyPEM string) (*tls.Config, error) {
 
 	return cfgTLS, nil
 }
+
+// TLSFiles describes the files of a TLS configuration. The CA and the
+// certificate revocation list are optional.
+type TLSFiles struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	CRLFile  string
+}
+
+// TLSReloader loads the files of a TLS configuration and reloads them when
+// they are modified, so that certificates can be renewed or revoked without
+// restart. The files are checked during the handshakes, at most once per
+// TLSReloadInterval.
+type TLSReloader struct {
+	sync.RWMutex
+	files     TLSFiles
+	onError   func(error)
+	modTimes  map[string]time.Time
+	lastCheck time.Time
+	cert      *tls.Certificate
+	caPool    *x509.CertPool
+	crls      []*revocationList
+	base      *tls.Config
+	config    *tls.Config
+}
+
+// revocationList holds the serial numbers of the certificates
+// revoked by a CA
+type revocationList struct {
+	issuer  []byte
+	serials map[string]bool
+}
+
+func (f TLSFiles) paths() []string {
+	var paths []string
+	for _, path := range []string{f.CertFile, f.KeyFile, f.CAFile, f.CRLFile} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// parseCRLs parses a list of revocation lists, either a single DER
+// encoded list or one or more PEM encoded lists
+func parseCRLs(data []byte) ([]*pkix.CertificateList, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		crl, err := x509.ParseDERCRL(data)
+		if err != nil {
+			return nil, err
+		}
+		return []*pkix.CertificateList{crl}, nil
+	}
+
+	var crls []*pkix.CertificateList
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "X509 CRL" {
+			continue
+		}
+
+		crl, err := x509.ParseDERCRL(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		crls = append(crls, crl)
+	}
+	return crls, nil
+}
+
+// load reads all the files. The current configuration is only
+// replaced if all the files are valid.
+func (r *TLSReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		return fmt.Errorf("Can't read X509 key pair: cert '%s' key '%s' : %s", r.files.CertFile, r.files.KeyFile, err)
+	}
+
+	var cas []*x509.Certificate
+	var caPool *x509.CertPool
+	if r.files.CAFile != "" {
+		data, err := ioutil.ReadFile(r.files.CAFile)
+		if err != nil {
+			return fmt.Errorf("Failed to open root certificate '%s' : %s", r.files.CAFile, err)
+		}
+
+		if cas, err = parseCertificates(data); err != nil || len(cas) == 0 {
+			return fmt.Errorf("Failed to parse root certificate '%s'", r.files.CAFile)
+		}
+
+		caPool = x509.NewCertPool()
+		for _, ca := range cas {
+			caPool.AddCert(ca)
+		}
+	}
+
+	var crls []*revocationList
+	if r.files.CRLFile != "" {
+		data, err := ioutil.ReadFile(r.files.CRLFile)
+		if err != nil {
+			return fmt.Errorf("Failed to open certificate revocation list '%s' : %s", r.files.CRLFile, err)
+		}
+
+		lists, err := parseCRLs(data)
+		if err != nil {
+			return fmt.Errorf("Failed to parse certificate revocation list '%s' : %s", r.files.CRLFile, err)
+		}
+
+		// only the lists signed by one of the CAs are trusted
+		for _, list := range lists {
+			var issuer *x509.Certificate
+			for _, ca := range cas {
+				if ca.CheckCRLSignature(list) == nil {
+					issuer = ca
+					break
+				}
+			}
+			if issuer == nil {
+				return fmt.Errorf("Certificate revocation list '%s' is not signed by a trusted CA", r.files.CRLFile)
+			}
+
+			crl := &revocationList{issuer: issuer.RawSubject, serials: make(map[string]bool)}
+			for _, revoked := range list.TBSCertList.RevokedCertificates {
+				crl.serials[revoked.SerialNumber.String()] = true
+			}
+			crls = append(crls, crl)
+		}
+	}
+
+	modTimes := make(map[string]time.Time)
+	for _, path := range r.files.paths() {
+		if fi, err := os.Stat(path); err == nil {
+			modTimes[path] = fi.ModTime()
+		}
+	}
+
+	r.Lock()
+	r.cert, r.caPool, r.crls, r.modTimes = &cert, caPool, crls, modTimes
+	if r.base != nil {
+		r.config = r.newServerConfig()
+	}
+	r.Unlock()
+
+	return nil
+}
+
+// check reloads the files if one of them was modified
+func (r *TLSReloader) check() {
+	r.Lock()
+	if time.Since(r.lastCheck) < TLSReloadInterval {
+		r.Unlock()
+		return
+	}
+	r.lastCheck = time.Now()
+
+	var modified bool
+	for _, path := range r.files.paths() {
+		if fi, err := os.Stat(path); err == nil && !fi.ModTime().Equal(r.modTimes[path]) {
+			modified = true
+			break
+		}
+	}
+	r.Unlock()
+
+	if modified {
+		if err := r.load(); err != nil && r.onError != nil {
+			r.onError(err)
+		}
+	}
+}
+
+// IsRevoked returns whether a certificate is part of the revocation lists
+func (r *TLSReloader) IsRevoked(cert *x509.Certificate) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	for _, crl := range r.crls {
+		if bytes.Equal(crl.issuer, cert.RawIssuer) && crl.serials[cert.SerialNumber.String()] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VerifyPeerCertificate rejects the peer certificates that were revoked
+func (r *TLSReloader) VerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if r.IsRevoked(cert) {
+				return ErrCertificateRevoked
+			}
+		}
+	}
+	return nil
+}
+
+// GetCertificate returns the current certificate, to be used by servers
+func (r *TLSReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.check()
+
+	r.RLock()
+	defer r.RUnlock()
+	return r.cert, nil
+}
+
+// GetClientCertificate returns the current certificate, to be used by clients
+func (r *TLSReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.check()
+
+	r.RLock()
+	defer r.RUnlock()
+	return r.cert, nil
+}
+
+// newServerConfig returns the server configuration using the current files
+func (r *TLSReloader) newServerConfig() *tls.Config {
+	config := r.base.Clone()
+	config.GetConfigForClient = nil
+	config.Certificates = []tls.Certificate{*r.cert}
+	if r.caPool != nil {
+		config.ClientCAs = r.caPool
+	}
+	return config
+}
+
+// getConfigForClient returns the server configuration using the current files
+func (r *TLSReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.check()
+
+	r.RLock()
+	defer r.RUnlock()
+	return r.config, nil
+}
+
+// SetupServerConfig makes a server configuration use the reloaded files.
+// The client certificates are checked against the revocation lists.
+func (r *TLSReloader) SetupServerConfig(config *tls.Config) {
+	config.VerifyPeerCertificate = r.VerifyPeerCertificate
+
+	r.Lock()
+	r.base = config.Clone()
+	r.config = r.newServerConfig()
+	r.Unlock()
+
+	config.GetConfigForClient = r.getConfigForClient
+}
+
+// verifyServerCertificate verifies the certificates of a server against the
+// current CAs and checks them against the revocation lists
+func (r *TLSReloader) verifyServerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	r.check()
+
+	r.RLock()
+	caPool := r.caPool
+	r.RUnlock()
+
+	if caPool != nil {
+		if len(rawCerts) == 0 {
+			return errors.New("No server certificate")
+		}
+
+		var certs []*x509.Certificate
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		chains, err := certs[0].Verify(x509.VerifyOptions{Roots: caPool, Intermediates: intermediates})
+		if err != nil {
+			return err
+		}
+		verifiedChains = chains
+	}
+
+	return r.VerifyPeerCertificate(rawCerts, verifiedChains)
+}
+
+// SetupClientConfig makes a client configuration use the reloaded
+// certificate. The server certificates are also verified against the
+// reloaded CA and revocation list, in addition to the verification of
+// the server name and of the chain done during the handshake.
+func (r *TLSReloader) SetupClientConfig(config *tls.Config) {
+	config.Certificates = nil
+	config.GetClientCertificate = r.GetClientCertificate
+	config.VerifyPeerCertificate = r.verifyServerCertificate
+}
+
+// NewTLSReloader loads the files of a TLS configuration, onError is
+// called when the files can't be reloaded, the previous ones being kept
+func NewTLSReloader(files TLSFiles, onError func(error)) (*TLSReloader, error) {
+	r := &TLSReloader{
+		files:     files,
+		onError:   onError,
+		lastCheck: time.Now(),
+	}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
@@ -0,0 +1,240 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCertificate(t *testing.T, name string, serial int64, issuer *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+	}
+
+	parent, signer := template, key
+	if issuer == nil {
+		template.IsCA = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		parent, signer = issuer.cert, issuer.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCertificate{cert: cert, key: key}
+}
+
+func writePEM(t *testing.T, path string, typ string, der []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestCertificate(t *testing.T, c *testCertificate, certFile, keyFile string) {
+	writePEM(t, certFile, "CERTIFICATE", c.cert.Raw)
+
+	key, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, keyFile, "EC PRIVATE KEY", key)
+}
+
+func TestTLSReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := TLSFiles{
+		CertFile: filepath.Join(dir, "server.crt"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+		CAFile:   filepath.Join(dir, "ca.crt"),
+		CRLFile:  filepath.Join(dir, "ca.crl"),
+	}
+
+	ca := newTestCertificate(t, "ca", 1, nil)
+	server := newTestCertificate(t, "server", 2, ca)
+	revoked := newTestCertificate(t, "revoked", 3, ca)
+	valid := newTestCertificate(t, "valid", 4, ca)
+
+	writePEM(t, files.CAFile, "CERTIFICATE", ca.cert.Raw)
+	writeTestCertificate(t, server, files.CertFile, files.KeyFile)
+
+	crl, err := ca.cert.CreateCRL(rand.Reader, ca.key, []pkix.RevokedCertificate{
+		{SerialNumber: revoked.cert.SerialNumber, RevocationTime: time.Now()},
+	}, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, files.CRLFile, "X509 CRL", crl)
+
+	reloader, err := NewTLSReloader(files, func(err error) { t.Error(err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reloader.IsRevoked(revoked.cert) {
+		t.Error("The revoked certificate should be rejected")
+	}
+
+	if reloader.IsRevoked(valid.cert) {
+		t.Error("The valid certificate should be accepted")
+	}
+
+	if err := reloader.VerifyPeerCertificate(nil, [][]*x509.Certificate{{revoked.cert, ca.cert}}); err != ErrCertificateRevoked {
+		t.Errorf("Expected a revoked certificate error, got %v", err)
+	}
+
+	// renew the server certificate
+	renewed := newTestCertificate(t, "server", 5, ca)
+	writeTestCertificate(t, renewed, files.CertFile, files.KeyFile)
+
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(files.CertFile, later, later)
+	os.Chtimes(files.KeyFile, later, later)
+
+	reloader.lastCheck = time.Time{}
+
+	cert, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err != nil || leaf.SerialNumber.Cmp(renewed.cert.SerialNumber) != 0 {
+		t.Error("The renewed certificate should have been reloaded")
+	}
+}
+
+func TestTLSReloaderClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := TLSFiles{
+		CertFile: filepath.Join(dir, "client.crt"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+		CAFile:   filepath.Join(dir, "ca.crt"),
+		CRLFile:  filepath.Join(dir, "ca.crl"),
+	}
+
+	writeCRL := func(ca *testCertificate, revoked ...*testCertificate) {
+		var certs []pkix.RevokedCertificate
+		for _, c := range revoked {
+			certs = append(certs, pkix.RevokedCertificate{SerialNumber: c.cert.SerialNumber, RevocationTime: time.Now()})
+		}
+
+		crl, err := ca.cert.CreateCRL(rand.Reader, ca.key, certs, time.Now(), time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writePEM(t, files.CRLFile, "X509 CRL", crl)
+	}
+
+	ca := newTestCertificate(t, "ca", 1, nil)
+	client := newTestCertificate(t, "client", 2, ca)
+	server := newTestCertificate(t, "server", 3, ca)
+
+	writePEM(t, files.CAFile, "CERTIFICATE", ca.cert.Raw)
+	writeTestCertificate(t, client, files.CertFile, files.KeyFile)
+	writeCRL(ca)
+
+	reloader, err := NewTLSReloader(files, func(err error) { t.Error(err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &tls.Config{}
+	reloader.SetupClientConfig(config)
+
+	verify := func(c *testCertificate) error {
+		return config.VerifyPeerCertificate([][]byte{c.cert.Raw}, nil)
+	}
+
+	reload := func() {
+		later := time.Now().Add(time.Minute)
+		for _, path := range []string{files.CAFile, files.CRLFile} {
+			os.Chtimes(path, later, later)
+		}
+		reloader.lastCheck = time.Time{}
+	}
+
+	if err := verify(server); err != nil {
+		t.Fatalf("The server certificate should be accepted: %s", err)
+	}
+
+	// revoke the server certificate
+	writeCRL(ca, server)
+	reload()
+
+	if err := verify(server); err != ErrCertificateRevoked {
+		t.Errorf("Expected a revoked certificate error, got %v", err)
+	}
+
+	// replace the CA
+	newCA := newTestCertificate(t, "new-ca", 4, nil)
+	renewed := newTestCertificate(t, "server", 5, newCA)
+	writePEM(t, files.CAFile, "CERTIFICATE", newCA.cert.Raw)
+	writeCRL(newCA)
+	reload()
+
+	if err := verify(newTestCertificate(t, "server", 6, ca)); err == nil {
+		t.Error("A certificate of the previous CA should be rejected")
+	}
+
+	if err := verify(renewed); err != nil {
+		t.Errorf("A certificate of the new CA should be accepted: %s", err)
+	}
+}
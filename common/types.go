@@ -182,6 +182,41 @@ func JSONDecode(r io.Reader, i interface{}) error {
 	return decoder.Decode(i)
 }
 
+// JSONMergePatch applies a JSON merge patch, as described in RFC 7396,
+// to a JSON document
+func JSONMergePatch(doc []byte, patch []byte) ([]byte, error) {
+	var d, p interface{}
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergePatch(d, p))
+}
+
+func mergePatch(doc interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	d, ok := doc.(map[string]interface{})
+	if !ok {
+		d = make(map[string]interface{})
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(d, k)
+		} else {
+			d[k] = mergePatch(d[k], v)
+		}
+	}
+
+	return d
+}
+
 // UnixMillis returns the current time in miliseconds
 func UnixMillis(t time.Time) int64 {
 	return t.UTC().UnixNano() / 1000000
@@ -18,6 +18,7 @@
 package common
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"regexp"
@@ -302,3 +303,21 @@ func TestLookupPath(t *testing.T) {
 		t.Error("Shouldn't find the struct")
 	}
 }
+
+func TestJSONMergePatch(t *testing.T) {
+	doc := `{"Name":"capture","BPFFilter":"port 80","Metadata":{"A":1,"B":2}}`
+	patch := `{"BPFFilter":"port 443","Description":"https","Metadata":{"A":null,"C":3}}`
+
+	b, err := JSONMergePatch([]byte(doc), []byte(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result, expected interface{}
+	json.Unmarshal(b, &result)
+	json.Unmarshal([]byte(`{"Name":"capture","BPFFilter":"port 443","Description":"https","Metadata":{"B":2,"C":3}}`), &expected)
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Wrong merge patch result, expected %s, got %s", expected, string(b))
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	auth "github.com/abbot/go-http-auth"
 	shttp "github.com/skydive-project/skydive/http"
@@ -61,6 +62,42 @@ func NewAuthenticationBackendByName(name string) (backend shttp.AuthenticationBa
 		}
 
 		backend, err = shttp.NewKeystoneBackend(name, authURL, tenant, domain, role)
+	case "oidc":
+		role := GetString("auth." + name + ".role")
+		if role == "" {
+			role = shttp.DefaultUserRole
+		}
+
+		opts := shttp.OIDCOpts{
+			Issuer:        GetString("auth." + name + ".issuer"),
+			ClientID:      GetString("auth." + name + ".client_id"),
+			ClientSecret:  GetString("auth." + name + ".client_secret"),
+			RedirectURL:   GetString("auth." + name + ".redirect_url"),
+			Audience:      GetString("auth." + name + ".audience"),
+			Scopes:        GetStringSlice("auth." + name + ".scopes"),
+			UsernameClaim: GetString("auth." + name + ".username_claim"),
+			GroupsClaim:   GetString("auth." + name + ".groups_claim"),
+			RoleMapping:   GetStringMapString("auth." + name + ".role_mapping"),
+			JWKSTTL:       time.Duration(GetInt("auth."+name+".jwks_ttl")) * time.Second,
+		}
+
+		backend, err = shttp.NewOIDCBackend(name, opts, role)
+	case "tls":
+		if !IsTLSEnabled() {
+			return nil, fmt.Errorf("TLS has to be enabled to use the certificate authentication of backend %s", name)
+		}
+
+		role := GetString("auth." + name + ".role")
+		if role == "" {
+			role = shttp.DefaultUserRole
+		}
+
+		opts := shttp.TLSOpts{
+			UsernameField: GetString("auth." + name + ".username_field"),
+			RoleMapping:   GetStringMapString("auth." + name + ".role_mapping"),
+		}
+
+		backend, err = shttp.NewTLSBackend(name, opts, role)
 	case "noauth":
 		backend = shttp.NewNoAuthenticationBackend()
 	default:
@@ -97,7 +97,13 @@ func init() {
 
 	cfg.SetDefault("agent.auth.api.backend", "noauth")
 	cfg.SetDefault("agent.capture.stats_update", 1)
+	cfg.SetDefault("agent.capture.packet_ring.max_size", 256)
+	cfg.SetDefault("agent.capture.packet_ring.path", "/var/lib/skydive/packet_ring")
 	cfg.SetDefault("agent.flow.probes", []string{"gopacket", "pcapsocket"})
+	cfg.SetDefault("agent.flow.ipfix.enterprise_id", 2312)
+	cfg.SetDefault("agent.flow.ipfix.exporters", []string{})
+	cfg.SetDefault("agent.flow.ipfix.max_exporters", 64)
+	cfg.SetDefault("agent.flow.ipfix.template_refresh", 60)
 	cfg.SetDefault("agent.flow.netflow.bind_address", "127.0.0.1")
 	cfg.SetDefault("agent.flow.netflow.port_min", 6365)
 	cfg.SetDefault("agent.flow.netflow.port_max", 6375)
@@ -105,10 +111,14 @@ func init() {
 	cfg.SetDefault("agent.flow.pcapsocket.min_port", 8100)
 	cfg.SetDefault("agent.flow.pcapsocket.max_port", 8132)
 	cfg.SetDefault("agent.flow.ebpf.polling_rate", 16000)
+	cfg.SetDefault("agent.flow.ebpf.socket_update", 5)
+	cfg.SetDefault("agent.flow.ebpf.drop_update", 5)
 	cfg.SetDefault("agent.flow.sflow.bind_address", "127.0.0.1")
 	cfg.SetDefault("agent.flow.sflow.port_min", 6345)
 	cfg.SetDefault("agent.flow.sflow.port_max", 6355)
 	cfg.SetDefault("agent.listen", "127.0.0.1:8081")
+	cfg.SetDefault("agent.spool.path", "/var/lib/skydive/spool")
+	cfg.SetDefault("agent.spool.max_size", 100)
 	cfg.SetDefault("agent.topology.probes", []string{"ovsdb"})
 	cfg.SetDefault("agent.topology.docker.url", "unix:///var/run/docker.sock")
 	cfg.SetDefault("agent.topology.docker.netns.run_path", "/var/run/docker/netns")
@@ -129,13 +139,24 @@ func init() {
 	cfg.SetDefault("analyzer.flow.backend", "memory")
 	cfg.SetDefault("analyzer.flow.max_buffer_size", 100000)
 	cfg.SetDefault("analyzer.listen", "127.0.0.1:8082")
+	cfg.SetDefault("analyzer.grpc.listen", "")
+	cfg.SetDefault("analyzer.pcapfile.max_size", 1<<30)
+	cfg.SetDefault("analyzer.pcapfile.retention", 3600)
+	cfg.SetDefault("analyzer.replication.anti_entropy_interval", 30)
 	cfg.SetDefault("analyzer.replication.debug", false)
+	cfg.SetDefault("analyzer.replication.tombstone_ttl", 3600)
 	cfg.SetDefault("analyzer.topology.backend", "memory")
 	cfg.SetDefault("analyzer.topology.probes", []string{})
 	cfg.SetDefault("analyzer.topology.k8s.config_file", "/etc/skydive/kubeconfig")
 	cfg.SetDefault("analyzer.topology.ovn.address", "unix:///var/run/openvswitch/ovnnb_db.sock")
 	cfg.SetDefault("analyzer.topology.istio.config_file", "/etc/skydive/kubeconfig")
 
+	cfg.SetDefault("audit.backends", []string{})
+	cfg.SetDefault("audit.file.path", "/var/log/skydive-audit.log")
+	cfg.SetDefault("audit.file.max_size", 100)
+	cfg.SetDefault("audit.file.max_backups", 5)
+	cfg.SetDefault("audit.syslog.tag", "skydive-audit")
+
 	cfg.SetDefault("auth.basic.type", "basic") // defined for backward compatibility
 	cfg.SetDefault("auth.keystone.tenant_name", "admin")
 	cfg.SetDefault("auth.keystone.type", "keystone") // defined for backward compatibility
@@ -155,16 +176,21 @@ func init() {
 	cfg.SetDefault("flow.update", 60)
 	cfg.SetDefault("flow.max_entries", 500000)
 	cfg.SetDefault("flow.protocol", "udp")
+	cfg.SetDefault("flow.tcp.port", 8083)
+	cfg.SetDefault("flow.tcp.credit", 100)
+	cfg.SetDefault("flow.tcp.send_timeout", 5)
 	cfg.SetDefault("flow.application_timeout.arp", 10)
 	cfg.SetDefault("flow.application_timeout.dns", 10)
 
 	cfg.SetDefault("host_id", host)
 
 	cfg.SetDefault("http.rest.debug", false)
+	cfg.SetDefault("http.rest.events_history", 0)
 	cfg.SetDefault("http.ws.ping_delay", 2)
 	cfg.SetDefault("http.ws.pong_timeout", 5)
 	cfg.SetDefault("http.ws.queue_size", 10000)
 	cfg.SetDefault("http.ws.enable_write_compression", true)
+	cfg.SetDefault("http.ws.batch_delay", 0)
 
 	cfg.SetDefault("logging.backends", []string{"stderr"})
 	cfg.SetDefault("logging.color", true)
@@ -74,6 +74,20 @@ func loadConfigPolicy(model model.Model) {
 	}
 }
 
+func loadConfigTenants() []*rbac.Tenant {
+	var tenants []*rbac.Tenant
+	for name := range cfg.GetStringMap("rbac.tenants") {
+		key := "rbac.tenants." + name
+		tenants = append(tenants, &rbac.Tenant{
+			Name:   name,
+			Filter: GetString(key + ".filter"),
+			Users:  GetStringSlice(key + ".users"),
+			Roles:  GetStringSlice(key + ".roles"),
+		})
+	}
+	return tenants
+}
+
 func loadPolicy(content []byte, model model.Model) error {
 	buf := bufio.NewReader(bytes.NewReader([]byte(content)))
 	for {
@@ -103,6 +117,7 @@ func loadStaticPolicy(model model.Model) error {
 // - a policy on etcd
 // - a policy bundled in the executable
 // - additional policy rules from the configuration
+// - the tenants from the configuration and etcd
 func InitRBAC(kapi etcd.KeysAPI) error {
 	log.SetLogger(&logger{enabled: true})
 
@@ -113,11 +128,16 @@ func InitRBAC(kapi etcd.KeysAPI) error {
 	loadSection(m, "matchers", "m")
 	loadSection(m, "role_definition", "g")
 
-	return rbac.Init(m, kapi, func(m model.Model) error {
+	err := rbac.Init(m, kapi, func(m model.Model) error {
 		if err := loadStaticPolicy(m); err != nil {
 			return err
 		}
 		loadConfigPolicy(m)
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	return rbac.InitTenants(kapi, loadConfigTenants())
 }
@@ -21,8 +21,13 @@ import (
 	"crypto/tls"
 
 	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/logging"
 )
 
+func onTLSReloadError(err error) {
+	logging.GetLogger().Errorf("Unable to reload TLS files, keeping the current ones: %s", err)
+}
+
 // GetTLSClientConfig returns TLS config to be used by client
 func GetTLSClientConfig(setupRootCA bool) (*tls.Config, error) {
 	certPEM := GetString("tls.client_cert")
@@ -41,6 +46,19 @@ func GetTLSClientConfig(setupRootCA bool) (*tls.Config, error) {
 				return nil, err
 			}
 		}
+
+		files := common.TLSFiles{CertFile: certPEM, KeyFile: keyPEM}
+		if setupRootCA {
+			files.CAFile = GetString("tls.ca_cert")
+			files.CRLFile = GetString("tls.crl")
+		}
+
+		// the certificate, the CA and the revocation list are reloaded when modified
+		reloader, err := common.NewTLSReloader(files, onTLSReloadError)
+		if err != nil {
+			return nil, err
+		}
+		reloader.SetupClientConfig(tlsConfig)
 	}
 	return tlsConfig, nil
 }
@@ -54,12 +72,19 @@ func GetTLSServerConfig(setupRootCA bool) (*tls.Config, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	files := common.TLSFiles{CertFile: certPEM, KeyFile: keyPEM}
 	if setupRootCA {
-		rootCaPEM := GetString("tls.ca_cert")
-		tlsConfig.ClientCAs, err = common.SetupTLSLoadCA(rootCaPEM)
-		if err != nil {
-			return nil, err
-		}
+		files.CAFile = GetString("tls.ca_cert")
+		files.CRLFile = GetString("tls.crl")
 	}
+
+	// the certificate, the CA and the revocation list are reloaded when modified
+	reloader, err := common.NewTLSReloader(files, onTLSReloadError)
+	if err != nil {
+		return nil, err
+	}
+	reloader.SetupServerConfig(tlsConfig)
+
 	return tlsConfig, nil
 }
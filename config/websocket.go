@@ -33,6 +33,7 @@ func NewWSClient(clientType common.ServiceType, url *url.URL, opts websocket.Cli
 	// override some of the options with config value
 	opts.QueueSize = GetInt("http.ws.queue_size")
 	opts.WriteCompression = GetBool("http.ws.enable_write_compression")
+	opts.BatchDelay = time.Duration(GetInt("http.ws.batch_delay")) * time.Millisecond
 	tlsConfig, err := GetTLSClientConfig(true)
 	if err != nil {
 		return nil, err
@@ -48,6 +49,7 @@ func NewWSServer(server *shttp.Server, endpoint string, authBackend shttp.Authen
 
 	opts := websocket.ServerOpts{
 		WriteCompression: GetBool("http.ws.enable_write_compression"),
+		BatchDelay:       time.Duration(GetInt("http.ws.batch_delay")) * time.Millisecond,
 		QueueSize:        GetInt("http.ws.queue_size"),
 		PingDelay:        pingDelay,
 		PongTimeout:      time.Duration(GetInt("http.ws.pong_timeout"))*time.Second + pingDelay,
@@ -189,7 +189,7 @@ func SkydiveSend(ds *C.data_set_t, vl *C.value_list_t, ud *C.user_data_t) C.int
 		key += "." + entry.TypeInstance
 	}
 
-	msg := gws.PartiallyUpdatedMsg{
+	msg := &gws.PartiallyUpdatedMsg{
 		ID: rootNodeID,
 		Ops: []graph.PartiallyUpdatedOp{
 			{
@@ -0,0 +1,263 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package erspan
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+)
+
+const (
+	maxDgramSize = 65535
+)
+
+var (
+	// ErrCollectorAlreadyAllocated error collector already allocated for this uuid
+	ErrCollectorAlreadyAllocated = errors.New("collector already allocated for this uuid")
+)
+
+// SessionHandler is used by the collectors to get the flow table of the
+// mirror sessions
+type SessionHandler interface {
+	SessionFlowTable(addr net.IP, sessionID uint32) (*flow.Table, error)
+}
+
+// Collector describes a collector of the frames mirrored over GRE, ERSPAN
+// and VXLAN
+type Collector struct {
+	common.RWMutex
+	UUID       string
+	Addr       string
+	Port       int
+	BPFFilter  string
+	HeaderSize uint32
+	GREConn    *net.IPConn
+	VXLANConn  *net.UDPConn
+	handler    SessionHandler
+	bpf        *flow.BPF
+	wg         sync.WaitGroup
+}
+
+// CollectorAllocator manages multiple mirror collectors
+type CollectorAllocator struct {
+	common.RWMutex
+	collectors []*Collector
+}
+
+// GetTarget returns the address the collector listens on
+func (c *Collector) GetTarget() string {
+	if c.VXLANConn == nil {
+		return c.Addr
+	}
+	return fmt.Sprintf("%s:%d", c.Addr, c.Port)
+}
+
+func (c *Collector) feed(addr net.IP, m *Mirror) {
+	ft, err := c.handler.SessionFlowTable(addr, m.SessionID)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to get the flow table of session %d from %s: %s", m.SessionID, addr, err)
+		return
+	}
+
+	// the filter only applies to the mirrored ethernet frames
+	var bpf *flow.BPF
+	if m.FirstLayer == layers.LayerTypeEthernet {
+		bpf = c.bpf
+	}
+
+	// the frame is copied as the read buffer is reused
+	packet := gopacket.NewPacket(m.Data, m.FirstLayer, gopacket.Default)
+	packet.Metadata().CaptureInfo = gopacket.CaptureInfo{
+		Timestamp:     time.Now(),
+		CaptureLength: len(m.Data),
+		Length:        len(m.Data),
+	}
+
+	ft.FeedWithGoPacket(packet, bpf)
+}
+
+func (c *Collector) readGRE() {
+	defer c.wg.Done()
+
+	var buf [maxDgramSize]byte
+	for {
+		n, addr, err := c.GREConn.ReadFromIP(buf[:])
+		if err != nil {
+			return
+		}
+
+		m, err := DecodeGRE(buf[:n])
+		if err != nil {
+			logging.GetLogger().Debugf("Unable to decode GRE packet from %s: %s", addr, err)
+			continue
+		}
+
+		c.feed(addr.IP, m)
+	}
+}
+
+func (c *Collector) readVXLAN() {
+	defer c.wg.Done()
+
+	var buf [maxDgramSize]byte
+	for {
+		n, addr, err := c.VXLANConn.ReadFromUDP(buf[:])
+		if err != nil {
+			return
+		}
+
+		m, err := DecodeVXLAN(buf[:n])
+		if err != nil {
+			logging.GetLogger().Debugf("Unable to decode VXLAN packet from %s: %s", addr, err)
+			continue
+		}
+
+		c.feed(addr.IP, m)
+	}
+}
+
+// Start the collector
+func (c *Collector) Start() {
+	if b, err := flow.NewBPF(layers.LinkTypeEthernet, c.HeaderSize, c.BPFFilter); err == nil {
+		c.bpf = b
+	} else {
+		logging.GetLogger().Error(err)
+	}
+
+	c.wg.Add(1)
+	go c.readGRE()
+
+	if c.VXLANConn != nil {
+		c.wg.Add(1)
+		go c.readVXLAN()
+	}
+}
+
+// Stop the collector, once returned the flow tables are not fed anymore
+func (c *Collector) Stop() {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.GREConn != nil {
+		c.GREConn.Close()
+	}
+	if c.VXLANConn != nil {
+		c.VXLANConn.Close()
+	}
+	c.wg.Wait()
+}
+
+// NewCollector creates a new mirror collector which will pass the frames
+// to the flow tables given by the handler
+func NewCollector(u string, greConn *net.IPConn, vxlanConn *net.UDPConn, addr string, port int, bpfFilter string, headerSize uint32, handler SessionHandler) *Collector {
+	if headerSize == 0 {
+		headerSize = flow.DefaultCaptureLength
+	}
+
+	return &Collector{
+		UUID:       u,
+		Addr:       addr,
+		Port:       port,
+		BPFFilter:  bpfFilter,
+		HeaderSize: headerSize,
+		GREConn:    greConn,
+		VXLANConn:  vxlanConn,
+		handler:    handler,
+	}
+}
+
+func (a *CollectorAllocator) release(uuid string) {
+	for i, collector := range a.collectors {
+		if uuid == collector.UUID {
+			collector.Stop()
+			a.collectors = append(a.collectors[:i], a.collectors[i+1:]...)
+
+			break
+		}
+	}
+}
+
+// Release a collector
+func (a *CollectorAllocator) Release(uuid string) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.release(uuid)
+}
+
+// ReleaseAll collectors
+func (a *CollectorAllocator) ReleaseAll() {
+	a.Lock()
+	defer a.Unlock()
+
+	for _, collector := range a.collectors {
+		collector.Stop()
+	}
+	a.collectors = nil
+}
+
+// Alloc allocates a new collector receiving the GRE packets sent to the
+// given address, and the VXLAN packets sent to its port if not zero
+func (a *CollectorAllocator) Alloc(uuid string, addr *common.ServiceAddress, bpfFilter string, headerSize uint32, handler SessionHandler) (*Collector, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	for _, collector := range a.collectors {
+		if uuid == collector.UUID {
+			return collector, ErrCollectorAlreadyAllocated
+		}
+	}
+
+	ip := net.ParseIP(addr.Addr)
+
+	greConn, err := net.ListenIP("ip4:gre", &net.IPAddr{IP: ip})
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to receive GRE packets on %s: %s", addr.Addr, err)
+		return nil, err
+	}
+
+	var vxlanConn *net.UDPConn
+	if addr.Port > 0 {
+		if vxlanConn, err = net.ListenUDP("udp", &net.UDPAddr{Port: addr.Port, IP: ip}); err != nil {
+			logging.GetLogger().Errorf("Unable to listen on port %d: %s", addr.Port, err)
+			greConn.Close()
+			return nil, err
+		}
+	}
+
+	c := NewCollector(uuid, greConn, vxlanConn, addr.Addr, addr.Port, bpfFilter, headerSize, handler)
+	a.collectors = append(a.collectors, c)
+
+	c.Start()
+	return c, nil
+}
+
+// NewCollectorAllocator creates a new collector allocator
+func NewCollectorAllocator() *CollectorAllocator {
+	return &CollectorAllocator{}
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package erspan
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// GRE protocol types of the mirrored frames, see
+// https://tools.ietf.org/html/draft-foschiano-erspan
+const (
+	greProtocolERSPAN     = 0x88be
+	greProtocolERSPANIII  = 0x22eb
+	greProtocolTransEther = 0x6558
+
+	greFlagChecksum = 0x80
+	greFlagKey      = 0x20
+	greFlagSequence = 0x10
+
+	erspanIIHeaderLength  = 8
+	erspanIIIHeaderLength = 12
+	// length of the optional platform specific subheader of type III
+	erspanIIISubHeaderLength = 8
+
+	// frame types of the type III header
+	erspanFrameEthernet = 0
+	erspanFrameIP       = 2
+
+	vxlanHeaderLength = 8
+	vxlanFlagVNI      = 0x08
+)
+
+var (
+	// ErrTruncated is returned for the packets shorter than their headers
+	ErrTruncated = errors.New("truncated packet")
+)
+
+// Mirror describes a mirrored frame once decapsulated
+type Mirror struct {
+	// Version of the ERSPAN header, 0 for the plain GRE and VXLAN mirrors
+	Version int
+	// SessionID of the ERSPAN header, the GRE key or the VXLAN VNI
+	SessionID uint32
+	// FirstLayer is the type of the first layer of the frame
+	FirstLayer gopacket.LayerType
+	// Data of the mirrored frame
+	Data []byte
+}
+
+func ipLayerType(data []byte) gopacket.LayerType {
+	if len(data) > 0 && data[0]>>4 == 6 {
+		return layers.LayerTypeIPv6
+	}
+	return layers.LayerTypeIPv4
+}
+
+// DecodeGRE decapsulates the frame mirrored by a GRE packet, the IP header
+// being already stripped
+func DecodeGRE(data []byte) (*Mirror, error) {
+	if len(data) < 4 {
+		return nil, ErrTruncated
+	}
+
+	flags := data[0]
+	protocol := binary.BigEndian.Uint16(data[2:4])
+
+	offset := 4
+	if flags&greFlagChecksum != 0 {
+		offset += 4
+	}
+
+	var key uint32
+	if flags&greFlagKey != 0 {
+		if len(data) < offset+4 {
+			return nil, ErrTruncated
+		}
+		key = binary.BigEndian.Uint32(data[offset : offset+4])
+		offset += 4
+	}
+
+	hasSequence := flags&greFlagSequence != 0
+	if hasSequence {
+		offset += 4
+	}
+
+	if len(data) < offset {
+		return nil, ErrTruncated
+	}
+	payload := data[offset:]
+
+	switch protocol {
+	case greProtocolERSPAN:
+		// type I has neither sequence number nor ERSPAN header
+		if !hasSequence {
+			return &Mirror{Version: 1, FirstLayer: layers.LayerTypeEthernet, Data: payload}, nil
+		}
+		return decodeERSPANII(payload)
+	case greProtocolERSPANIII:
+		return decodeERSPANIII(payload)
+	case greProtocolTransEther:
+		return &Mirror{SessionID: key, FirstLayer: layers.LayerTypeEthernet, Data: payload}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported GRE protocol 0x%x", protocol)
+}
+
+func decodeERSPANII(data []byte) (*Mirror, error) {
+	if len(data) < erspanIIHeaderLength {
+		return nil, ErrTruncated
+	}
+
+	return &Mirror{
+		Version:    int(data[0] >> 4),
+		SessionID:  uint32(binary.BigEndian.Uint16(data[2:4]) & 0x03ff),
+		FirstLayer: layers.LayerTypeEthernet,
+		Data:       data[erspanIIHeaderLength:],
+	}, nil
+}
+
+func decodeERSPANIII(data []byte) (*Mirror, error) {
+	if len(data) < erspanIIIHeaderLength {
+		return nil, ErrTruncated
+	}
+
+	m := &Mirror{
+		Version:   int(data[0] >> 4),
+		SessionID: uint32(binary.BigEndian.Uint16(data[2:4]) & 0x03ff),
+	}
+
+	offset := erspanIIIHeaderLength
+	if data[11]&0x01 != 0 {
+		offset += erspanIIISubHeaderLength
+	}
+
+	if len(data) < offset {
+		return nil, ErrTruncated
+	}
+	m.Data = data[offset:]
+
+	switch frameType := (data[10] >> 2) & 0x1f; frameType {
+	case erspanFrameEthernet:
+		m.FirstLayer = layers.LayerTypeEthernet
+	case erspanFrameIP:
+		m.FirstLayer = ipLayerType(m.Data)
+	default:
+		return nil, fmt.Errorf("unsupported ERSPAN frame type %d", frameType)
+	}
+
+	return m, nil
+}
+
+// DecodeVXLAN decapsulates the frame mirrored by a VXLAN packet, the UDP
+// header being already stripped
+func DecodeVXLAN(data []byte) (*Mirror, error) {
+	if len(data) < vxlanHeaderLength {
+		return nil, ErrTruncated
+	}
+
+	if data[0]&vxlanFlagVNI == 0 {
+		return nil, errors.New("VXLAN header without VNI")
+	}
+
+	return &Mirror{
+		SessionID:  binary.BigEndian.Uint32(data[4:8]) >> 8,
+		FirstLayer: layers.LayerTypeEthernet,
+		Data:       data[vxlanHeaderLength:],
+	}, nil
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package erspan
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func serializeUDP(t *testing.T, withEthernet bool) []byte {
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IP{192, 168, 0, 1},
+		DstIP:    net.IP{192, 168, 0, 2},
+	}
+	udp := &layers.UDP{SrcPort: 1234, DstPort: 53}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	ls := []gopacket.SerializableLayer{ip, udp, gopacket.Payload([]byte("payload"))}
+	if withEthernet {
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x0F, 0xAA, 0xFA, 0xAA, 0x00},
+			DstMAC:       net.HardwareAddr{0x00, 0x0D, 0xBD, 0xBD, 0xBD, 0x00},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ls = append([]gopacket.SerializableLayer{eth}, ls...)
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}, ls...); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func checkMirror(t *testing.T, m *Mirror, version int, sessionID uint32, firstLayer gopacket.LayerType, frame []byte) {
+	if m.Version != version {
+		t.Errorf("Expected version %d, got %d", version, m.Version)
+	}
+	if m.SessionID != sessionID {
+		t.Errorf("Expected session %d, got %d", sessionID, m.SessionID)
+	}
+	if m.FirstLayer != firstLayer {
+		t.Errorf("Expected first layer %s, got %s", firstLayer, m.FirstLayer)
+	}
+	if !bytes.Equal(m.Data, frame) {
+		t.Errorf("Wrong mirrored frame: %v", m.Data)
+	}
+
+	packet := gopacket.NewPacket(m.Data, m.FirstLayer, gopacket.Default)
+	if packet.Layer(layers.LayerTypeUDP) == nil {
+		t.Errorf("Mirrored frame not decoded: %s", packet.Dump())
+	}
+}
+
+func TestDecodeERSPANII(t *testing.T) {
+	frame := serializeUDP(t, true)
+
+	data := []byte{
+		// GRE with sequence number
+		0x10, 0x00, 0x88, 0xbe, 0x00, 0x00, 0x00, 0x01,
+		// version 1, vlan 10, session 42, index 7
+		0x10, 0x0a, 0x00, 0x2a, 0x00, 0x00, 0x00, 0x07,
+	}
+
+	m, err := DecodeGRE(append(data, frame...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMirror(t, m, 1, 42, layers.LayerTypeEthernet, frame)
+}
+
+func TestDecodeERSPANIII(t *testing.T) {
+	frame := serializeUDP(t, false)
+
+	data := []byte{
+		// GRE with sequence number
+		0x10, 0x00, 0x22, 0xeb, 0x00, 0x00, 0x00, 0x01,
+		// version 2, session 1023, timestamp
+		0x20, 0x00, 0x03, 0xff, 0x01, 0x02, 0x03, 0x04,
+		// IP frame type with the platform specific subheader
+		0x00, 0x00, 0x08, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	m, err := DecodeGRE(append(data, frame...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMirror(t, m, 2, 1023, layers.LayerTypeIPv4, frame)
+}
+
+func TestDecodeGRE(t *testing.T) {
+	frame := serializeUDP(t, true)
+
+	data := []byte{
+		// GRE with key 5
+		0x20, 0x00, 0x65, 0x58, 0x00, 0x00, 0x00, 0x05,
+	}
+
+	m, err := DecodeGRE(append(data, frame...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMirror(t, m, 0, 5, layers.LayerTypeEthernet, frame)
+
+	if _, err := DecodeGRE([]byte{0x10, 0x00, 0x88, 0xbe, 0x00, 0x00}); err != ErrTruncated {
+		t.Errorf("Expected truncated error, got %v", err)
+	}
+
+	if _, err := DecodeGRE([]byte{0x00, 0x00, 0x08, 0x00}); err == nil {
+		t.Error("Expected unsupported protocol error")
+	}
+}
+
+func TestDecodeVXLAN(t *testing.T) {
+	frame := serializeUDP(t, true)
+
+	data := []byte{
+		// VNI 4242
+		0x08, 0x00, 0x00, 0x00, 0x00, 0x10, 0x92, 0x00,
+	}
+
+	m, err := DecodeVXLAN(append(data, frame...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMirror(t, m, 0, 4242, layers.LayerTypeEthernet, frame)
+
+	if _, err := DecodeVXLAN([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x92, 0x00}); err == nil {
+		t.Error("Expected error for VXLAN header without VNI")
+	}
+}
@@ -19,8 +19,11 @@ package flow
 
 import (
 	"net/http"
+	"sort"
 	"time"
 
+	"github.com/google/gopacket/layers"
+
 	"github.com/skydive-project/skydive/common"
 )
 
@@ -31,6 +34,7 @@ type TableAllocator struct {
 	expireAfter time.Duration
 	sender      Sender
 	tables      map[*Table]bool
+	rings       map[*PacketRing]bool
 }
 
 // ExpireAfter returns the expiration duration
@@ -74,6 +78,77 @@ func (a *TableAllocator) Release(t *Table) {
 	a.Unlock()
 }
 
+// AllocPacketRing instantiates a new packet ring
+func (a *TableAllocator) AllocPacketRing(uuids UUIDs, opts PacketRingOpts) (*PacketRing, error) {
+	r, err := NewPacketRing(uuids, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Lock()
+	a.rings[r] = true
+	a.Unlock()
+
+	return r, nil
+}
+
+// ReleasePacketRing releases and removes a packet ring
+func (a *TableAllocator) ReleasePacketRing(r *PacketRing) {
+	a.Lock()
+	delete(a.rings, r)
+	a.Unlock()
+
+	r.Close()
+}
+
+// QueryPacketRings dumps the packets of the rings of a capture matching the
+// query, sorted by timestamp
+func (a *TableAllocator) QueryPacketRings(query *PacketRingQuery) (*PacketRingReply, error) {
+	a.RLock()
+	var rings []*PacketRing
+	for r := range a.rings {
+		if r.UUIDs().CaptureID == query.CaptureID {
+			rings = append(rings, r)
+		}
+	}
+	a.RUnlock()
+
+	// the rings may hold packets of different link types
+	bpfFilters := make(map[layers.LinkType]*BPF)
+
+	reply := &PacketRingReply{}
+	for _, r := range rings {
+		err := r.Dump(query.From, query.To, func(packet *RawPacket) error {
+			if query.BPFFilter != "" {
+				bpf, ok := bpfFilters[packet.LinkType]
+				if !ok {
+					var err error
+					if bpf, err = NewBPF(packet.LinkType, MaxCaptureLength, query.BPFFilter); err != nil {
+						return err
+					}
+					bpfFilters[packet.LinkType] = bpf
+				}
+
+				if !bpf.Matches(packet.Data) {
+					return nil
+				}
+			}
+
+			reply.RawPackets = append(reply.RawPackets, packet)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(reply.RawPackets, func(i, j int) bool {
+		return reply.RawPackets[i].Timestamp < reply.RawPackets[j].Timestamp
+	})
+
+	return reply, nil
+}
+
 // NewTableAllocator creates a new flow table
 func NewTableAllocator(updateEvery, expireAfter time.Duration, sender Sender) *TableAllocator {
 	return &TableAllocator{
@@ -81,5 +156,6 @@ func NewTableAllocator(updateEvery, expireAfter time.Duration, sender Sender) *T
 		expireAfter: expireAfter,
 		sender:      sender,
 		tables:      make(map[*Table]bool),
+		rings:       make(map[*PacketRing]bool),
 	}
 }
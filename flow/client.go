@@ -18,6 +18,10 @@
 package flow
 
 import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
 	"github.com/golang/protobuf/proto"
 
 	"github.com/skydive-project/skydive/common"
@@ -133,6 +137,47 @@ func (f *WSTableClient) LookupFlowsByNodes(hnmap topology.HostNodeTIDMap, flowSe
 	return flowset, nil
 }
 
+func (f *WSTableClient) queryPacketRings(packets chan []*RawPacket, host string, query PacketRingQuery) {
+	msg := ws.NewStructMessage(Namespace, "PacketRingQuery", query)
+
+	resp, err := f.structServer.Request(host, msg, ws.DefaultRequestTimeout)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to send message to agent %s: %s", host, err)
+		packets <- nil
+		return
+	}
+
+	var reply PacketRingReply
+	if resp == nil || resp.Status != http.StatusOK || json.Unmarshal(resp.Obj, &reply) != nil {
+		logging.GetLogger().Errorf("Error returned while reading PacketRingReply from: %s", host)
+		packets <- nil
+		return
+	}
+	packets <- reply.RawPackets
+}
+
+// QueryPacketRings dumps the packets kept by the packet rings of a capture
+// on all the agents, sorted by timestamp
+func (f *WSTableClient) QueryPacketRings(query PacketRingQuery) ([]*RawPacket, error) {
+	speakers := f.structServer.GetSpeakersByType(common.AgentService)
+	ch := make(chan []*RawPacket, len(speakers))
+
+	for _, c := range speakers {
+		go f.queryPacketRings(ch, c.GetRemoteHost(), query)
+	}
+
+	var packets []*RawPacket
+	for i := 0; i != len(speakers); i++ {
+		packets = append(packets, <-ch...)
+	}
+
+	sort.SliceStable(packets, func(i, j int) bool {
+		return packets[i].Timestamp < packets[j].Timestamp
+	})
+
+	return packets, nil
+}
+
 // NewWSTableClient creates a new table client based on websocket
 func NewWSTableClient(w *ws.StructServer) *WSTableClient {
 	return &WSTableClient{structServer: w}
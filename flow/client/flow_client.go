@@ -18,27 +18,51 @@
 package client
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/transport"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/spool"
 	ws "github.com/skydive-project/skydive/websocket"
 )
 
+var errNoFlowClient = errors.New("No flow client connected")
+
 // FlowClientPool describes a flow client pool.
 type FlowClientPool struct {
 	common.RWMutex
 	ws.DefaultSpeakerEventHandler
 	flowClients []*FlowClient
 	authOpts    *shttp.AuthenticationOpts
+	spool       *spool.Spool
+	replaying   bool
+	// number of flows dropped as no analyzer was connected or as it
+	// didn't acknowledge the previous ones
+	dropped int64
+	// acknowledged connections by analyzer, kept to send the flows not
+	// acknowledged when the analyzer reconnects
+	tcpConns map[string]*FlowClientTCPConn
+}
+
+// Stats describes the flows sent by a flow client pool
+type Stats struct {
+	// number of flows dropped as no analyzer was connected or as it
+	// didn't acknowledge the previous ones
+	DroppedFlows int64
+	// statistics of the acknowledged connections, by analyzer
+	Analyzers map[string]transport.SenderStats `json:",omitempty"`
 }
 
 // FlowClient describes a flow client connection
@@ -54,6 +78,7 @@ type FlowClientConn interface {
 	Connect() error
 	Close() error
 	Send(data []byte) error
+	IsConnected() bool
 }
 
 // FlowClientUDPConn describes UDP client connection
@@ -62,6 +87,11 @@ type FlowClientUDPConn struct {
 	conn *net.UDPConn
 }
 
+// FlowClientTCPConn describes an acknowledged TCP client connection
+type FlowClientTCPConn struct {
+	sender *transport.Sender
+}
+
 // FlowClientWebSocketConn describes WebSocket client connection
 type FlowClientWebSocketConn struct {
 	ws.DefaultSpeakerEventHandler
@@ -88,6 +118,11 @@ func (c *FlowClientUDPConn) Send(data []byte) error {
 	return err
 }
 
+// IsConnected returns whether the UDP socket is opened
+func (c *FlowClientUDPConn) IsConnected() bool {
+	return c.conn != nil
+}
+
 // NewFlowClientUDPConn returns a new UDP flow client
 func NewFlowClientUDPConn(addr string, port int) (*FlowClientUDPConn, error) {
 	udpAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", addr, port))
@@ -98,6 +133,56 @@ func NewFlowClientUDPConn(addr string, port int) (*FlowClientUDPConn, error) {
 	return &FlowClientUDPConn{addr: udpAddr}, nil
 }
 
+// Close the connection, the flows not acknowledged are sent again
+// on reconnection
+func (c *FlowClientTCPConn) Close() error {
+	c.sender.Stop()
+	return nil
+}
+
+// Connect to the TCP flow server
+func (c *FlowClientTCPConn) Connect() error {
+	c.sender.Start()
+	return nil
+}
+
+// Send data over the wire. transport.ErrNoCredit is returned if the
+// analyzer didn't acknowledge the previous flows before the send timeout.
+func (c *FlowClientTCPConn) Send(data []byte) error {
+	return c.sender.Send(data)
+}
+
+// IsConnected returns whether the TCP connection is established
+func (c *FlowClientTCPConn) IsConnected() bool {
+	return c.sender.IsConnected()
+}
+
+// NewFlowClientTCPConn returns a new acknowledged TCP flow client, using TLS
+// if enabled
+func NewFlowClientTCPConn(addr string, port int) (*FlowClientTCPConn, error) {
+	var tlsConfig *tls.Config
+	if config.IsTLSEnabled() {
+		var err error
+		if tlsConfig, err = config.GetTLSClientConfig(true); err != nil {
+			return nil, err
+		}
+	}
+
+	address := fmt.Sprintf("%s:%d", addr, port)
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	dial := func() (net.Conn, error) {
+		if tlsConfig != nil {
+			return tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+		}
+		return dialer.Dial("tcp", address)
+	}
+
+	credit := config.GetInt("flow.tcp.credit")
+	timeout := time.Duration(config.GetInt("flow.tcp.send_timeout")) * time.Second
+
+	return &FlowClientTCPConn{sender: transport.NewSender(dial, credit, timeout)}, nil
+}
+
 // Close the connection
 func (c *FlowClientWebSocketConn) Close() error {
 	c.wsClient.Stop()
@@ -122,6 +207,11 @@ func (c *FlowClientWebSocketConn) Send(data []byte) error {
 	return nil
 }
 
+// IsConnected returns whether the WebSocket connection is established
+func (c *FlowClientWebSocketConn) IsConnected() bool {
+	return c.wsClient != nil && c.wsClient.IsConnected()
+}
+
 // NewFlowClientWebSocketConn returns a new WebSocket flow client
 func NewFlowClientWebSocketConn(url *url.URL, authOpts *shttp.AuthenticationOpts) (*FlowClientWebSocketConn, error) {
 	return &FlowClientWebSocketConn{url: url, authOpts: authOpts}, nil
@@ -147,8 +237,16 @@ func (c *FlowClient) SendMessage(m *flow.Message) error {
 		return err
 	}
 
+	return c.sendData(data)
+}
+
+func (c *FlowClient) sendData(data []byte) error {
 retry:
-	err = c.flowClientConn.Send(data)
+	err := c.flowClientConn.Send(data)
+	if err == transport.ErrNoCredit {
+		// the connection is fine, the analyzer being only late
+		return err
+	}
 	if err != nil {
 		logging.GetLogger().Errorf("flows connection to analyzer error %s : try to reconnect", err)
 		c.close()
@@ -159,16 +257,19 @@ retry:
 	return nil
 }
 
-// SendFlows implements the flow Sender interface
-func (c *FlowClient) SendFlows(flows []*flow.Flow) {
+// bulkSize returns the number of flows sent in a message
+func bulkSize(protocol string) int {
 	// NOTE: set it to 1 for udp to ensure that the flow can be sent
 	// even with rawpackets. Set it a bit bigger for websocket to
 	// improve performances.
-	bulkSize := 1
-	if c.protocol == "websocket" {
-		bulkSize = 10
+	if protocol == "websocket" || protocol == "tcp" {
+		return 10
 	}
+	return 1
+}
 
+// forEachBulk calls fn with the flows split in bulks
+func forEachBulk(flows []*flow.Flow, bulkSize int, fn func(msg *flow.Message)) {
 	var msg flow.Message
 	for i := 0; i < len(flows); i += bulkSize {
 		e := i + bulkSize
@@ -178,11 +279,36 @@ func (c *FlowClient) SendFlows(flows []*flow.Flow) {
 		}
 
 		msg.Flows = flows[i:e]
+		fn(&msg)
+	}
+}
 
-		err := c.SendMessage(&msg)
-		if err != nil {
+// sendFlows sends the flows, returning the messages the analyzer had no
+// credit left for along with their number of flows
+func (c *FlowClient) sendFlows(flows []*flow.Flow) (rejected [][]byte, count int) {
+	forEachBulk(flows, bulkSize(c.protocol), func(msg *flow.Message) {
+		data, err := msg.Marshal()
+		if err == nil {
+			err = c.sendData(data)
+		}
+
+		switch err {
+		case nil:
+		case transport.ErrNoCredit:
+			rejected = append(rejected, data)
+			count += len(msg.Flows)
+		default:
 			logging.GetLogger().Errorf("Unable to send flow: %s", err)
 		}
+	})
+
+	return rejected, count
+}
+
+// SendFlows implements the flow Sender interface
+func (c *FlowClient) SendFlows(flows []*flow.Flow) {
+	if _, count := c.sendFlows(flows); count > 0 {
+		logging.GetLogger().Warningf("%d flows dropped as the analyzer didn't acknowledge the previous ones", count)
 	}
 }
 
@@ -199,6 +325,8 @@ func NewFlowClient(addr string, port int, authOpts *shttp.AuthenticationOpts) (*
 	case "websocket":
 		endpoint := config.GetURL("ws", common.NormalizeAddrForURL(addr), port, "/ws/agent/flow")
 		connection, err = NewFlowClientWebSocketConn(endpoint, authOpts)
+	case "tcp":
+		connection, err = NewFlowClientTCPConn(common.NormalizeAddrForURL(addr), config.GetInt("flow.tcp.port"))
 	default:
 		return nil, fmt.Errorf("Invalid protocol %s", protocol)
 	}
@@ -213,6 +341,28 @@ func NewFlowClient(addr string, port int, authOpts *shttp.AuthenticationOpts) (*
 	return fc, nil
 }
 
+// newFlowClient returns a flow client, reusing the acknowledged
+// connection previously used for the analyzer
+func (p *FlowClientPool) newFlowClient(addr string, port int) (*FlowClient, error) {
+	key := fmt.Sprintf("%s:%d", addr, port)
+	if conn, found := p.tcpConns[key]; found {
+		fc := &FlowClient{addr: addr, port: port, protocol: "tcp", flowClientConn: conn}
+		fc.connect()
+		return fc, nil
+	}
+
+	fc, err := NewFlowClient(addr, port, p.authOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if conn, ok := fc.flowClientConn.(*FlowClientTCPConn); ok {
+		p.tcpConns[key] = conn
+	}
+
+	return fc, nil
+}
+
 // OnConnected websocket event handler
 func (p *FlowClientPool) OnConnected(c ws.Speaker) {
 	p.Lock()
@@ -228,13 +378,18 @@ func (p *FlowClientPool) OnConnected(c ws.Speaker) {
 		}
 	}
 
-	flowClient, err := NewFlowClient(addr, port, p.authOpts)
+	flowClient, err := p.newFlowClient(addr, port)
 	if err != nil {
 		logging.GetLogger().Error(err)
 		return
 	}
 
 	p.flowClients = append(p.flowClients, flowClient)
+
+	if p.spool != nil && !p.replaying && p.spool.Stats().Records > 0 {
+		p.replaying = true
+		go p.replaySpool()
+	}
 }
 
 // OnDisconnected websocket event handler
@@ -252,17 +407,139 @@ func (p *FlowClientPool) OnDisconnected(c ws.Speaker) {
 	}
 }
 
-// SendFlows implements the flow Sender interface
-func (p *FlowClientPool) SendFlows(flows []*flow.Flow) {
+// spoolFlows keeps the flows in the spool until a flow client is connected
+func (p *FlowClientPool) spoolFlows(flows []*flow.Flow) {
+	forEachBulk(flows, bulkSize(strings.ToLower(config.GetString("flow.protocol"))), func(msg *flow.Message) {
+		data, err := msg.Marshal()
+		if err == nil {
+			err = p.spool.Append(data)
+		}
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to spool flows: %s", err)
+		}
+	})
+}
+
+// replayClient returns a connected flow client to replay the spool to
+func (p *FlowClientPool) replayClient() (*FlowClient, error) {
+	for {
+		p.RLock()
+		if len(p.flowClients) == 0 {
+			p.RUnlock()
+			return nil, errNoFlowClient
+		}
+
+		for _, fc := range p.flowClients {
+			if fc.flowClientConn.IsConnected() {
+				p.RUnlock()
+				return fc, nil
+			}
+		}
+		p.RUnlock()
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// isClient returns whether a flow client is still in the pool
+func (p *FlowClientPool) isClient(c *FlowClient) bool {
 	p.RLock()
 	defer p.RUnlock()
 
-	if len(p.flowClients) == 0 {
+	for _, fc := range p.flowClients {
+		if fc == c {
+			return true
+		}
+	}
+	return false
+}
+
+// replaySpool sends the spooled flows, in order, before the flows are sent
+// again directly to the flow clients
+func (p *FlowClientPool) replaySpool() {
+	logging.GetLogger().Infof("Replay %d spooled flow messages", p.spool.Stats().Records)
+
+	for {
+		fc, err := p.replayClient()
+		if err == nil {
+			err = p.spool.Replay(func(data []byte) error {
+				if !p.isClient(fc) {
+					return errNoFlowClient
+				}
+				return fc.sendData(data)
+			})
+		}
+
+		p.Lock()
+		if p.spool.Stats().Records == 0 || len(p.flowClients) == 0 {
+			// the replay continues on the next connection
+			p.replaying = false
+			p.Unlock()
+			return
+		}
+		p.Unlock()
+
+		if err == transport.ErrNoCredit {
+			logging.GetLogger().Debugf("Flow spool replay paused as the analyzer didn't acknowledge the previous flows")
+			time.Sleep(time.Second)
+		} else if err != nil && err != errNoFlowClient {
+			logging.GetLogger().Errorf("Unable to replay the flow spool: %s", err)
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// spoolRejected keeps the flow messages the analyzer had no credit left
+// for, to be replayed once it acknowledged the previous ones. Without spool
+// the flows are dropped.
+func (p *FlowClientPool) spoolRejected(rejected [][]byte, count int) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.spool == nil {
+		atomic.AddInt64(&p.dropped, int64(count))
+		logging.GetLogger().Warningf("%d flows dropped as the analyzer didn't acknowledge the previous ones", count)
 		return
 	}
 
+	for _, data := range rejected {
+		if err := p.spool.Append(data); err != nil {
+			logging.GetLogger().Errorf("Unable to spool flows: %s", err)
+		}
+	}
+
+	// the next flows are spooled too to be sent in order
+	if !p.replaying && len(p.flowClients) > 0 {
+		p.replaying = true
+		go p.replaySpool()
+	}
+}
+
+// sendFlows sends the flows to a flow client, returning the messages the
+// analyzer had no credit left for along with their number of flows
+func (p *FlowClientPool) sendFlows(flows []*flow.Flow) ([][]byte, int) {
+	p.RLock()
+	defer p.RUnlock()
+
+	if p.spool != nil && (len(p.flowClients) == 0 || p.replaying) {
+		p.spoolFlows(flows)
+		return nil, 0
+	}
+
+	if len(p.flowClients) == 0 {
+		atomic.AddInt64(&p.dropped, int64(len(flows)))
+		return nil, 0
+	}
+
 	fc := p.flowClients[rand.Intn(len(p.flowClients))]
-	fc.SendFlows(flows)
+	return fc.sendFlows(flows)
+}
+
+// SendFlows implements the flow Sender interface
+func (p *FlowClientPool) SendFlows(flows []*flow.Flow) {
+	if rejected, count := p.sendFlows(flows); count > 0 {
+		p.spoolRejected(rejected, count)
+	}
 }
 
 // Close all connections
@@ -272,6 +549,30 @@ func (p *FlowClientPool) Close() {
 	}
 }
 
+// GetStats returns the statistics of the flows sent to the analyzers
+func (p *FlowClientPool) GetStats() Stats {
+	p.RLock()
+	defer p.RUnlock()
+
+	stats := Stats{DroppedFlows: atomic.LoadInt64(&p.dropped)}
+	if len(p.tcpConns) > 0 {
+		stats.Analyzers = make(map[string]transport.SenderStats)
+		for key, conn := range p.tcpConns {
+			stats.Analyzers[key] = conn.sender.Stats()
+		}
+	}
+
+	return stats
+}
+
+// SetSpool sets the spool used to keep the flows while no analyzer is
+// reachable. The flows spooled before a restart are replayed.
+func (p *FlowClientPool) SetSpool(s *spool.Spool) {
+	p.Lock()
+	p.spool = s
+	p.Unlock()
+}
+
 // NewFlowClientPool returns a new FlowClientPool using the websocket connections
 // to maintain the pool of client up to date according to the websocket connections
 // status.
@@ -279,6 +580,7 @@ func NewFlowClientPool(pool ws.SpeakerPool, authOpts *shttp.AuthenticationOpts)
 	p := &FlowClientPool{
 		flowClients: make([]*FlowClient, 0),
 		authOpts:    authOpts,
+		tcpConns:    make(map[string]*FlowClientTCPConn),
 	}
 	pool.AddEventHandler(p)
 	return p
@@ -0,0 +1,126 @@
+/*
+ * Copyright (C) 2015 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/flow/transport"
+	"github.com/skydive-project/skydive/spool"
+)
+
+// fakeConn accepts the flow messages only when given credit
+type fakeConn struct {
+	common.RWMutex
+	credit bool
+	sent   int
+}
+
+func (c *fakeConn) Connect() error    { return nil }
+func (c *fakeConn) Close() error      { return nil }
+func (c *fakeConn) IsConnected() bool { return true }
+
+func (c *fakeConn) Send(data []byte) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.credit {
+		return transport.ErrNoCredit
+	}
+	c.sent++
+	return nil
+}
+
+func (c *fakeConn) setCredit(credit bool) {
+	c.Lock()
+	c.credit = credit
+	c.Unlock()
+}
+
+func (c *fakeConn) sentMessages() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.sent
+}
+
+func newTestPool(conn *fakeConn) *FlowClientPool {
+	fc := &FlowClient{addr: "127.0.0.1", port: 8082, protocol: "tcp", flowClientConn: conn}
+	return &FlowClientPool{flowClients: []*FlowClient{fc}}
+}
+
+func newFlows(n int) (flows []*flow.Flow) {
+	for i := 0; i < n; i++ {
+		flows = append(flows, flow.NewFlow())
+	}
+	return
+}
+
+func TestNoCreditDropped(t *testing.T) {
+	conn := &fakeConn{}
+	p := newTestPool(conn)
+
+	p.SendFlows(newFlows(25))
+
+	if stats := p.GetStats(); stats.DroppedFlows != 25 {
+		t.Errorf("Expected 25 dropped flows, got %d", stats.DroppedFlows)
+	}
+}
+
+func TestNoCreditSpooled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-flow-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := spool.Open(dir, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := &fakeConn{}
+	p := newTestPool(conn)
+	p.SetSpool(s)
+
+	// 3 messages of 10 flows
+	p.SendFlows(newFlows(25))
+
+	if stats := p.GetStats(); stats.DroppedFlows != 0 {
+		t.Errorf("Expected no dropped flow, got %d", stats.DroppedFlows)
+	}
+
+	// the flows are replayed once the analyzer acknowledged the previous ones
+	conn.setCredit(true)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for conn.sentMessages() != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the 3 spooled messages to be replayed, got %d", conn.sentMessages())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if records := s.Stats().Records; records != 0 {
+		t.Errorf("Expected an empty spool, got %d records", records)
+	}
+}
@@ -1285,6 +1285,18 @@ func (f *Flow) GetFieldString(field string) (string, error) {
 		if f.Transport != nil {
 			return f.Transport.GetFieldString(fields[1])
 		}
+	case "NATNetwork":
+		if f.NATNetwork != nil {
+			return f.NATNetwork.GetFieldString(fields[1])
+		}
+	case "NATTransport":
+		if f.NATTransport != nil {
+			return f.NATTransport.GetFieldString(fields[1])
+		}
+	case "DropMetric":
+		if f.DropMetric != nil {
+			return f.DropMetric.GetFieldString(fields[1])
+		}
 	}
 
 	// check extra layers
@@ -1333,6 +1345,10 @@ func (f *Flow) GetFieldInt64(field string) (_ int64, err error) {
 		if f.IPMetric != nil {
 			return f.IPMetric.GetFieldInt64(fields[1])
 		}
+	case "DropMetric":
+		if f.DropMetric != nil {
+			return f.DropMetric.GetFieldInt64(fields[1])
+		}
 	case "Link":
 		if f.Link != nil {
 			return f.Link.GetFieldInt64(fields[1])
@@ -1349,6 +1365,14 @@ func (f *Flow) GetFieldInt64(field string) (_ int64, err error) {
 		if f.Transport != nil {
 			return f.Transport.GetFieldInt64(fields[1])
 		}
+	case "NATNetwork":
+		if f.NATNetwork != nil {
+			return f.NATNetwork.GetFieldInt64(fields[1])
+		}
+	case "NATTransport":
+		if f.NATTransport != nil {
+			return f.NATTransport.GetFieldInt64(fields[1])
+		}
 	case "RawPacketsCaptured":
 		return f.RawPacketsCaptured, nil
 	}
@@ -1374,6 +1398,8 @@ func (f *Flow) getFieldInterface(field string) (_ interface{}, err error) {
 		return f.LastUpdateMetric, nil
 	case "TCPMetric":
 		return f.TCPMetric, nil
+	case "DropMetric":
+		return f.DropMetric, nil
 	case "Link":
 		return f.Link, nil
 	case "Network":
@@ -1382,6 +1408,10 @@ func (f *Flow) getFieldInterface(field string) (_ interface{}, err error) {
 		return f.ICMP, nil
 	case "Transport":
 		return f.Transport, nil
+	case "NATNetwork":
+		return f.NATNetwork, nil
+	case "NATTransport":
+		return f.NATTransport, nil
 	}
 
 	// check extra layers
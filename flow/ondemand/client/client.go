@@ -32,12 +32,12 @@ import (
 	ge "github.com/skydive-project/skydive/gremlin/traversal"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/ondemand/client"
+	"github.com/skydive-project/skydive/rbac"
 	ws "github.com/skydive-project/skydive/websocket"
 )
 
 type onDemandFlowHandler struct {
-	graph         *graph.Graph
-	nodeTypeQuery string
+	graph *graph.Graph
 }
 
 func (h *onDemandFlowHandler) DecodeMessage(msg json.RawMessage) (types.Resource, error) {
@@ -79,9 +79,15 @@ func (h *onDemandFlowHandler) GetNodeResources(resource types.Resource) []client
 	if capture.Type != "" && !common.CheckProbeCapabilities(capture.Type, common.MultipleOnSameNodeCapability) {
 		query += fmt.Sprintf(".Has('Captures.Type', NEE('%s'))", capture.Type)
 	}
-	query += h.nodeTypeQuery
+	query += nodeTypeQuery(capture.Type)
 
-	if nodes := h.applyGremlinExpr(query); len(nodes) > 0 {
+	g, err := rbac.GetTenantGraph(h.graph, capture.Tenant, nil, false)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to apply capture %s: %s", capture.UUID, err)
+		return nil
+	}
+
+	if nodes := h.applyGremlinExpr(g, query); len(nodes) > 0 {
 		for _, i := range nodes {
 			switch i.(type) {
 			case *graph.Node:
@@ -98,8 +104,32 @@ func (h *onDemandFlowHandler) GetNodeResources(resource types.Resource) []client
 	return nrs
 }
 
-func (h *onDemandFlowHandler) applyGremlinExpr(query string) []interface{} {
-	res, err := ge.TopologyGremlinQuery(h.graph, query)
+// nodeTypeQuery returns the filter of the nodes a capture of the given type
+// can be started on. Without capture type, only the nodes having a default
+// capture type are selected.
+func nodeTypeQuery(captureType string) string {
+	var nodeTypes []interface{}
+	for nodeType, c := range common.CaptureTypes {
+		if captureType == "" {
+			if c.Default != "" {
+				nodeTypes = append(nodeTypes, nodeType)
+			}
+			continue
+		}
+
+		for _, allowed := range c.Allowed {
+			if allowed == captureType {
+				nodeTypes = append(nodeTypes, nodeType)
+				break
+			}
+		}
+	}
+
+	return new(gremlin.QueryString).Has("Host", gremlin.Ne(""), "Type", gremlin.Within(nodeTypes...)).String()
+}
+
+func (h *onDemandFlowHandler) applyGremlinExpr(g *graph.Graph, query string) []interface{} {
+	res, err := ge.TopologyGremlinQuery(g, query)
 	if err != nil {
 		logging.GetLogger().Errorf("Gremlin %s error: %s", query, err)
 		return nil
@@ -109,12 +139,5 @@ func (h *onDemandFlowHandler) applyGremlinExpr(query string) []interface{} {
 
 // NewOnDemandFlowProbeClient creates a new ondemand probe client based on API, graph and websocket
 func NewOnDemandFlowProbeClient(g *graph.Graph, ch api.Handler, agentPool ws.StructSpeakerPool, subscriberPool ws.StructSpeakerPool, etcdClient *etcd.Client) *client.OnDemandClient {
-	nodeTypes := make([]interface{}, len(common.CaptureTypes))
-	i := 0
-	for nodeType := range common.CaptureTypes {
-		nodeTypes[i] = nodeType
-		i++
-	}
-	nodeTypeQuery := new(gremlin.QueryString).Has("Host", gremlin.Ne(""), "Type", gremlin.Within(nodeTypes...)).String()
-	return client.NewOnDemandClient(g, ch, agentPool, subscriberPool, etcdClient, &onDemandFlowHandler{graph: g, nodeTypeQuery: nodeTypeQuery})
+	return client.NewOnDemandClient(g, ch, agentPool, subscriberPool, etcdClient, &onDemandFlowHandler{graph: g})
 }
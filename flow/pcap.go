@@ -18,8 +18,11 @@
 package flow
 
 import (
+	"bufio"
+	"bytes"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
@@ -35,15 +38,42 @@ type PcapWriter struct {
 	writer *pcapgo.Writer
 }
 
+// pcapngMagic is the type of the section header block starting pcapng files
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// PcapReader describes a reader of pcap or pcapng files
+type PcapReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+// NewPcapReader returns a reader of pcap or pcapng data, the format being
+// detected from the first bytes
+func NewPcapReader(r io.Reader) (PcapReader, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(len(pcapngMagic))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(magic, pcapngMagic) {
+		return pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+	}
+	return pcapgo.NewReader(br)
+}
+
 // PcapTableFeeder replaies a pcap file
 type PcapTableFeeder struct {
 	sync.WaitGroup
-	state       common.ServiceState
-	replay      bool
-	r           io.ReadCloser
-	handleRead  *pcapgo.Reader
-	packetsChan chan *PacketSequence
-	bpfFilter   string
+	state      common.ServiceState
+	speed      float64
+	r          io.ReadCloser
+	handleRead PcapReader
+	feed       func(ps *PacketSequence)
+	bpfFilter  string
+	packets    int64
+	err        error
 }
 
 // Start a pcap injector
@@ -73,11 +103,18 @@ func (p *PcapTableFeeder) feedFlowTable() {
 
 	defer p.Done()
 
-	var bpf *BPF
-	if b, err := NewBPF(p.handleRead.LinkType(), MaxCaptureLength, p.bpfFilter); err == nil {
-		bpf = b
-	} else {
-		logging.GetLogger().Error(err.Error())
+	// pcapng interfaces may have different link types
+	bpfs := make(map[layers.LinkType]*BPF)
+	bpfOf := func(linkType layers.LinkType) *BPF {
+		bpf, ok := bpfs[linkType]
+		if !ok {
+			var err error
+			if bpf, err = NewBPF(linkType, MaxCaptureLength, p.bpfFilter); err != nil {
+				logging.GetLogger().Error(err.Error())
+			}
+			bpfs[linkType] = bpf
+		}
+		return bpf
 	}
 
 	p.state.Store(common.RunningState)
@@ -87,15 +124,21 @@ func (p *PcapTableFeeder) feedFlowTable() {
 		if err != nil {
 			if p.state.Load() == common.RunningState && err != io.EOF {
 				logging.GetLogger().Warningf("Failed to read packet: %s\n", err)
+				p.err = err
 			}
 			p.r.Close()
 			return
 		}
 
-		packet := gopacket.NewPacket(data, p.handleRead.LinkType(), gopacket.DecodeOptions{NoCopy: true})
+		linkType := p.handleRead.LinkType()
+		if intf, ok := p.Interface(ci.InterfaceIndex); ok {
+			linkType = intf.LinkType
+		}
+
+		packet := gopacket.NewPacket(data, linkType, gopacket.DecodeOptions{NoCopy: true})
 		packet.Metadata().CaptureInfo = ci
-		if p.replay {
-			intervalInCapture := ci.Timestamp.Sub(lastTS)
+		if p.speed > 0 {
+			intervalInCapture := time.Duration(float64(ci.Timestamp.Sub(lastTS)) / p.speed)
 			elapsedTime := time.Since(lastSend)
 
 			if (intervalInCapture > elapsedTime) && !lastSend.IsZero() {
@@ -108,32 +151,77 @@ func (p *PcapTableFeeder) feedFlowTable() {
 			packet.Metadata().CaptureInfo.Timestamp = lastSend
 		}
 
-		ps := PacketSeqFromGoPacket(packet, 0, bpf, nil)
+		ps := PacketSeqFromGoPacket(packet, 0, bpfOf(linkType), nil)
 		if ps == nil {
 			logging.GetLogger().Warningf("Failed to parse packet")
 		} else if len(ps.Packets) > 0 {
 			logging.GetLogger().Debugf("Sending %d packets to chan (%d)", len(ps.Packets), pkt)
-			p.packetsChan <- ps
+			p.feed(ps)
 			logging.GetLogger().Debugf("Sent %d packets to chan (%d)", len(ps.Packets), pkt)
 		}
 		pkt++
+		atomic.AddInt64(&p.packets, 1)
 	}
 }
 
+// Interface returns an interface of a pcapng file, the node TID being set
+// for the files written by the PcapNgWriter
+func (p *PcapTableFeeder) Interface(index int) (PcapInterface, bool) {
+	ng, ok := p.handleRead.(*pcapgo.NgReader)
+	if !ok {
+		return PcapInterface{}, false
+	}
+
+	intf, err := ng.Interface(index)
+	if err != nil {
+		return PcapInterface{}, false
+	}
+
+	return PcapInterface{
+		Name:     intf.Name,
+		NodeTID:  pcapngInterfaceNodeTID(intf.Comment),
+		LinkType: intf.LinkType,
+	}, true
+}
+
+// Err returns the error that stopped the reading, once done
+func (p *PcapTableFeeder) Err() error {
+	return p.err
+}
+
+// Packets returns the number of packets read so far
+func (p *PcapTableFeeder) Packets() int64 {
+	return atomic.LoadInt64(&p.packets)
+}
+
 // NewPcapTableFeeder reads a pcap from a file reader and inject it in a flow table
 func NewPcapTableFeeder(r io.ReadCloser, packetsChan chan *PacketSequence, replay bool, bpfFilter string) (*PcapTableFeeder, error) {
-	handle, err := pcapgo.NewReader(r)
+	var speed float64
+	if replay {
+		speed = 1
+	}
+
+	return NewPcapFeeder(r, func(ps *PacketSequence) { packetsChan <- ps }, speed, bpfFilter)
+}
+
+// NewPcapFeeder reads a pcap or pcapng from a file reader and passes the
+// packets to the feed function. With a zero speed, the packets are read as
+// fast as possible and keep their original timestamps. Otherwise the packets
+// are replayed with the intervals of the capture divided by the speed and
+// are timestamped when replayed.
+func NewPcapFeeder(r io.ReadCloser, feed func(ps *PacketSequence), speed float64, bpfFilter string) (*PcapTableFeeder, error) {
+	handle, err := NewPcapReader(r)
 	if err != nil {
 		return nil, err
 	}
 
 	return &PcapTableFeeder{
-		replay:      replay,
-		r:           r,
-		handleRead:  handle,
-		state:       common.StoppedState,
-		packetsChan: packetsChan,
-		bpfFilter:   bpfFilter,
+		speed:      speed,
+		r:          r,
+		handleRead: handle,
+		state:      common.StoppedState,
+		feed:       feed,
+		bpfFilter:  bpfFilter,
 	}, nil
 }
 
@@ -0,0 +1,231 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package flow
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	pcapngBlockSectionHeader        = 0x0a0d0d0a
+	pcapngBlockInterfaceDescription = 0x00000001
+	pcapngBlockEnhancedPacket       = 0x00000006
+	pcapngByteOrderMagic            = 0x1a2b3c4d
+
+	pcapngOptEnd     = 0
+	pcapngOptComment = 1
+	pcapngOptIfName  = 2
+	pcapngOptShbApp  = 4
+
+	// prefix of the comment of the interfaces holding the TID of their node
+	pcapngNodeTIDComment = "NodeTID: "
+)
+
+// PcapInterface describes the interface, a capture node, a packet of a
+// pcapng file was captured on
+type PcapInterface struct {
+	Name     string
+	NodeTID  string
+	LinkType layers.LinkType
+}
+
+type pcapngInterfaceKey struct {
+	nodeTID  string
+	linkType layers.LinkType
+}
+
+// PcapNgWriter writes pcapng files holding one interface per capture node
+// and link type, the packets being commented with the UUID of their flow
+type PcapNgWriter struct {
+	w          io.Writer
+	interfaces map[pcapngInterfaceKey]uint32
+}
+
+type pcapngOption struct {
+	code  uint16
+	value []byte
+}
+
+func pcapngPadding(n int) int {
+	return (4 - n%4) % 4
+}
+
+func pcapngOptionsLength(options []pcapngOption) int {
+	if len(options) == 0 {
+		return 0
+	}
+
+	length := 4 // end of options
+	for _, opt := range options {
+		length += 4 + len(opt.value) + pcapngPadding(len(opt.value))
+	}
+	return length
+}
+
+// writeBlock writes a block made of a fixed part, a variable length data and
+// options
+func (p *PcapNgWriter) writeBlock(blockType uint32, fixed []byte, data []byte, options []pcapngOption) error {
+	dataPadding := pcapngPadding(len(data))
+	length := 12 + len(fixed) + len(data) + dataPadding + pcapngOptionsLength(options)
+
+	buf := make([]byte, 0, length)
+	buf = appendUint32(buf, blockType)
+	buf = appendUint32(buf, uint32(length))
+	buf = append(buf, fixed...)
+	buf = append(buf, data...)
+	buf = append(buf, make([]byte, dataPadding)...)
+
+	if len(options) > 0 {
+		for _, opt := range options {
+			buf = appendUint16(buf, opt.code)
+			buf = appendUint16(buf, uint16(len(opt.value)))
+			buf = append(buf, opt.value...)
+			buf = append(buf, make([]byte, pcapngPadding(len(opt.value)))...)
+		}
+		buf = appendUint16(buf, pcapngOptEnd)
+		buf = appendUint16(buf, 0)
+	}
+	buf = appendUint32(buf, uint32(length))
+
+	_, err := p.w.Write(buf)
+	return err
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// AddInterface adds the interface of a capture node if not already added
+// and returns its index
+func (p *PcapNgWriter) AddInterface(intf PcapInterface) (uint32, error) {
+	key := pcapngInterfaceKey{nodeTID: intf.NodeTID, linkType: intf.LinkType}
+	if index, ok := p.interfaces[key]; ok {
+		return index, nil
+	}
+
+	// link type, reserved and snap length
+	fixed := appendUint16(nil, uint16(intf.LinkType))
+	fixed = appendUint16(fixed, 0)
+	fixed = appendUint32(fixed, MaxCaptureLength)
+
+	var options []pcapngOption
+	if intf.Name != "" {
+		options = append(options, pcapngOption{code: pcapngOptIfName, value: []byte(intf.Name)})
+	}
+	if intf.NodeTID != "" {
+		options = append(options, pcapngOption{code: pcapngOptComment, value: []byte(pcapngNodeTIDComment + intf.NodeTID)})
+	}
+
+	if err := p.writeBlock(pcapngBlockInterfaceDescription, fixed, nil, options); err != nil {
+		return 0, err
+	}
+
+	index := uint32(len(p.interfaces))
+	p.interfaces[key] = index
+
+	return index, nil
+}
+
+// WritePacket writes a packet captured on an interface with a comment
+func (p *PcapNgWriter) WritePacket(index uint32, ci gopacket.CaptureInfo, data []byte, comment string) error {
+	// timestamps in microseconds, the default resolution
+	ts := uint64(ci.Timestamp.UnixNano() / int64(time.Microsecond))
+
+	fixed := appendUint32(nil, index)
+	fixed = appendUint32(fixed, uint32(ts>>32))
+	fixed = appendUint32(fixed, uint32(ts))
+	fixed = appendUint32(fixed, uint32(len(data)))
+	fixed = appendUint32(fixed, uint32(ci.Length))
+
+	var options []pcapngOption
+	if comment != "" {
+		options = append(options, pcapngOption{code: pcapngOptComment, value: []byte(comment)})
+	}
+
+	return p.writeBlock(pcapngBlockEnhancedPacket, fixed, data, options)
+}
+
+// WriteRawPackets writes the raw packets of a flow captured on an interface
+func (p *PcapNgWriter) WriteRawPackets(intf PcapInterface, flowUUID string, fr []*RawPacket) error {
+	for _, r := range fr {
+		intf.LinkType = r.LinkType
+
+		index, err := p.AddInterface(intf)
+		if err != nil {
+			return err
+		}
+
+		ci := gopacket.CaptureInfo{
+			Length:        len(r.Data),
+			CaptureLength: len(r.Data),
+			Timestamp:     time.Unix(0, r.Timestamp*int64(time.Millisecond)),
+		}
+
+		if err := p.WritePacket(index, ci, r.Data, "Flow UUID: "+flowUUID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewPcapNgWriter returns a new PcapNgWriter based on the given io.Writer,
+// writing the section header
+func NewPcapNgWriter(w io.Writer) (*PcapNgWriter, error) {
+	p := &PcapNgWriter{
+		w:          w,
+		interfaces: make(map[pcapngInterfaceKey]uint32),
+	}
+
+	// byte order magic, version 1.0 and unknown section length
+	fixed := appendUint32(nil, pcapngByteOrderMagic)
+	fixed = appendUint16(fixed, 1)
+	fixed = appendUint16(fixed, 0)
+	fixed = appendUint32(fixed, 0xffffffff)
+	fixed = appendUint32(fixed, 0xffffffff)
+
+	options := []pcapngOption{{code: pcapngOptShbApp, value: []byte("Skydive")}}
+	if err := p.writeBlock(pcapngBlockSectionHeader, fixed, nil, options); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// pcapngInterfaceNodeTID returns the TID of the node of an interface written
+// by the PcapNgWriter
+func pcapngInterfaceNodeTID(comment string) string {
+	if strings.HasPrefix(comment, pcapngNodeTIDComment) {
+		return strings.TrimPrefix(comment, pcapngNodeTIDComment)
+	}
+	return ""
+}
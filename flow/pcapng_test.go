@@ -0,0 +1,104 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestPcapNgInterfaces(t *testing.T) {
+	f, err := os.Open("pcaptraces/dns.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	handleRead, err := NewPcapReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interfaces := []PcapInterface{
+		{Name: "eth0", NodeTID: "a7b6bc2d-d90e-5a35-64a7-0c4cb93d4e6c", LinkType: layers.LinkTypeEthernet},
+		{Name: "eth1", NodeTID: "2c1a9bbd-3e1f-4f29-6a5e-1a0e4f8bd0a1", LinkType: layers.LinkTypeEthernet},
+	}
+
+	var b bytes.Buffer
+	pw, err := NewPcapNgWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// packets are spread over the interfaces
+	var written int
+	for {
+		data, ci, err := handleRead.ReadPacketData()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		index, err := pw.AddInterface(interfaces[written%len(interfaces)])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = pw.WritePacket(index, ci, data, "Flow UUID: 1"); err != nil {
+			t.Fatal(err)
+		}
+		written++
+	}
+
+	tids := make(map[string]int)
+
+	var feeder *PcapTableFeeder
+	feeder, err = NewPcapFeeder(ioutil.NopCloser(&b), func(ps *PacketSequence) {
+		intf, ok := feeder.Interface(ps.Packets[0].GoPacket.Metadata().InterfaceIndex)
+		if !ok {
+			t.Errorf("Interface of the packet not found")
+			return
+		}
+		if intf.LinkType != layers.LinkTypeEthernet {
+			t.Errorf("Wrong link type, expected %s got %s", layers.LinkTypeEthernet, intf.LinkType)
+		}
+		tids[intf.NodeTID]++
+	}, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feeder.Start()
+	feeder.Wait()
+
+	if feeder.Packets() != int64(written) {
+		t.Errorf("Expected %d packets, got %d", written, feeder.Packets())
+	}
+
+	for i, intf := range interfaces {
+		if expected := (written + len(interfaces) - 1 - i) / len(interfaces); tids[intf.NodeTID] != expected {
+			t.Errorf("Expected %d packets on %s, got %d", expected, intf.Name, tids[intf.NodeTID])
+		}
+	}
+}
@@ -30,6 +30,17 @@ import (
 	"golang.org/x/net/bpf"
 )
 
+// AFPacketOptions describes the ring buffer and fanout settings of an
+// afpacket socket, the afpacket defaults being used for zero values
+type AFPacketOptions struct {
+	BlockSize    int
+	NumBlocks    int
+	BlockTimeout time.Duration
+	// fanout mode, hash or cpu, no fanout when empty
+	FanoutMode string
+	FanoutID   uint16
+}
+
 // AFPacketHandle describes a AF network kernel packets
 type AFPacketHandle struct {
 	tpacket *afpacket.TPacket
@@ -51,19 +62,49 @@ func (h *AFPacketHandle) Close() {
 }
 
 // NewAFPacketHandle creates a new network AF packet probe
-func NewAFPacketHandle(ifName string, snaplen int32) (*AFPacketHandle, error) {
-	tpacket, err := afpacket.NewTPacket(
+func NewAFPacketHandle(ifName string, snaplen int32, opts AFPacketOptions) (*AFPacketHandle, error) {
+	tpacketOpts := []interface{}{
 		afpacket.OptInterface(ifName),
 		afpacket.OptFrameSize(snaplen),
-		afpacket.OptPollTimeout(1*time.Second),
+		afpacket.OptPollTimeout(1 * time.Second),
 		afpacket.OptAddVLANHeader(true),
-	)
+	}
 
+	if opts.BlockSize > 0 {
+		tpacketOpts = append(tpacketOpts, afpacket.OptBlockSize(opts.BlockSize))
+	}
+	if opts.NumBlocks > 0 {
+		tpacketOpts = append(tpacketOpts, afpacket.OptNumBlocks(opts.NumBlocks))
+	}
+	if opts.BlockTimeout > 0 {
+		tpacketOpts = append(tpacketOpts, afpacket.OptBlockTimeout(opts.BlockTimeout))
+	}
+
+	tpacket, err := afpacket.NewTPacket(tpacketOpts...)
 	if err != nil {
 		return nil, err
 	}
 
-	return &AFPacketHandle{tpacket: tpacket}, err
+	if opts.FanoutMode != "" {
+		var fanoutType afpacket.FanoutType
+		switch opts.FanoutMode {
+		case "hash":
+			// fragments are reassembled by the kernel before being hashed
+			fanoutType = afpacket.FanoutHashWithDefrag
+		case "cpu":
+			fanoutType = afpacket.FanoutCPU
+		default:
+			tpacket.Close()
+			return nil, fmt.Errorf("Unknown fanout mode: %s", opts.FanoutMode)
+		}
+
+		if err = tpacket.SetFanout(fanoutType, opts.FanoutID); err != nil {
+			tpacket.Close()
+			return nil, err
+		}
+	}
+
+	return &AFPacketHandle{tpacket: tpacket}, nil
 }
 
 // AfpacketPacketProbe describes an afpacket based packet probe
@@ -108,8 +149,8 @@ func (a *AfpacketPacketProbe) PacketSource() *gopacket.PacketSource {
 }
 
 // NewAfpacketPacketProbe returns a new afpacket capture probe
-func NewAfpacketPacketProbe(ifName string, headerSize int, layerType gopacket.LayerType, linkType layers.LinkType) (*AfpacketPacketProbe, error) {
-	handle, err := NewAFPacketHandle(ifName, int32(headerSize))
+func NewAfpacketPacketProbe(ifName string, headerSize int, layerType gopacket.LayerType, linkType layers.LinkType, opts AFPacketOptions) (*AfpacketPacketProbe, error) {
+	handle, err := NewAFPacketHandle(ifName, int32(headerSize), opts)
 	if err != nil {
 		return nil, fmt.Errorf("Error while opening device %s: %s", ifName, err)
 	}
@@ -0,0 +1,423 @@
+// +build linux
+
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package probes
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/afpacket"
+	"github.com/google/gopacket/layers"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// definitions of linux/if_xdp.h
+const (
+	afXDP  = 44
+	solXDP = 283
+
+	xdpMmapOffsets        = 1
+	xdpRxRing             = 2
+	xdpUmemReg            = 4
+	xdpUmemFillRing       = 5
+	xdpUmemCompletionRing = 6
+	xdpStatistics         = 7
+
+	xdpCopy     = 1 << 1
+	xdpZeroCopy = 1 << 2
+
+	xdpPgoffRxRing       = 0
+	xdpUmemPgoffFillRing = 0x100000000
+)
+
+const (
+	afxdpFrameSize = 2048
+	afxdpRingSize  = 2048
+	// frames are either owned by the kernel through the fill ring or
+	// waiting to be read in the receive ring
+	afxdpNumFrames   = 2 * afxdpRingSize
+	afxdpPollTimeout = 1000
+)
+
+type xdpUmemRegT struct {
+	Addr      uint64
+	Len       uint64
+	ChunkSize uint32
+	Headroom  uint32
+}
+
+type xdpRingOffset struct {
+	Producer uint64
+	Consumer uint64
+	Desc     uint64
+}
+
+type xdpMmapOffsetsT struct {
+	Rx xdpRingOffset
+	Tx xdpRingOffset
+	Fr xdpRingOffset
+	Cr xdpRingOffset
+}
+
+type sockaddrXDP struct {
+	Family       uint16
+	Flags        uint16
+	Ifindex      uint32
+	QueueID      uint32
+	SharedUmemFD uint32
+}
+
+type xdpDesc struct {
+	Addr    uint64
+	Len     uint32
+	Options uint32
+}
+
+type xdpStatisticsT struct {
+	RxDropped      uint64
+	RxInvalidDescs uint64
+	TxInvalidDescs uint64
+}
+
+// afxdpRing describes a ring shared with the kernel
+type afxdpRing struct {
+	mem      []byte
+	producer *uint32
+	consumer *uint32
+	descs    unsafe.Pointer
+	mask     uint32
+}
+
+func xdpSockopt(call uintptr, fd int, opt int, value unsafe.Pointer, size uintptr) error {
+	var errno unix.Errno
+	if call == unix.SYS_GETSOCKOPT {
+		_, _, errno = unix.Syscall6(call, uintptr(fd), solXDP, uintptr(opt), uintptr(value), uintptr(unsafe.Pointer(&size)), 0)
+	} else {
+		_, _, errno = unix.Syscall6(call, uintptr(fd), solXDP, uintptr(opt), uintptr(value), size, 0)
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func mapXDPRing(fd int, pgoff int64, off xdpRingOffset, descSize int) (*afxdpRing, error) {
+	mem, err := unix.Mmap(fd, pgoff, int(off.Desc)+afxdpRingSize*descSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return nil, err
+	}
+
+	return newXDPRing(mem, off), nil
+}
+
+// newXDPRing returns the ring stored in mem at the given offsets
+func newXDPRing(mem []byte, off xdpRingOffset) *afxdpRing {
+	return &afxdpRing{
+		mem:      mem,
+		producer: (*uint32)(unsafe.Pointer(&mem[off.Producer])),
+		consumer: (*uint32)(unsafe.Pointer(&mem[off.Consumer])),
+		descs:    unsafe.Pointer(&mem[off.Desc]),
+		mask:     afxdpRingSize - 1,
+	}
+}
+
+// AFXDPSocket describes an AF_XDP socket receiving the packets of one
+// queue of an interface
+type AFXDPSocket struct {
+	fd       int
+	umem     []byte
+	fill     *afxdpRing
+	rx       *afxdpRing
+	snaplen  int
+	received int64
+	bpf      *flow.BPF
+	zeroCopy bool
+}
+
+func (s *AFXDPSocket) refill(addr uint64) {
+	prod := *s.fill.producer
+	*(*uint64)(unsafe.Pointer(uintptr(s.fill.descs) + uintptr(prod&s.fill.mask)*8)) = addr &^ (afxdpFrameSize - 1)
+	atomic.StoreUint32(s.fill.producer, prod+1)
+}
+
+// ReadPacketData reads one packet
+func (s *AFXDPSocket) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		cons := *s.rx.consumer
+		if cons == atomic.LoadUint32(s.rx.producer) {
+			fds := []unix.PollFd{{Fd: int32(s.fd), Events: unix.POLLIN}}
+			n, err := unix.Poll(fds, afxdpPollTimeout)
+			if err != nil && err != unix.EINTR {
+				return nil, gopacket.CaptureInfo{}, afpacket.ErrPoll
+			}
+			if n == 0 {
+				return nil, gopacket.CaptureInfo{}, afpacket.ErrTimeout
+			}
+			continue
+		}
+
+		desc := (*xdpDesc)(unsafe.Pointer(uintptr(s.rx.descs) + uintptr(cons&s.rx.mask)*unsafe.Sizeof(xdpDesc{})))
+
+		length := int(desc.Len)
+		captureLength := length
+		if captureLength > s.snaplen {
+			captureLength = s.snaplen
+		}
+
+		// the frame is given back to the kernel right away
+		data := make([]byte, captureLength)
+		copy(data, s.umem[desc.Addr:])
+		addr := desc.Addr
+
+		atomic.StoreUint32(s.rx.consumer, cons+1)
+		s.refill(addr)
+
+		if s.bpf != nil && !s.bpf.Matches(data) {
+			continue
+		}
+		atomic.AddInt64(&s.received, 1)
+
+		return data, gopacket.CaptureInfo{
+			Timestamp:     time.Now(),
+			CaptureLength: captureLength,
+			Length:        length,
+		}, nil
+	}
+}
+
+// Stats returns the number of received and dropped packets
+func (s *AFXDPSocket) Stats() (*CaptureStats, error) {
+	var stats xdpStatisticsT
+	if err := xdpSockopt(unix.SYS_GETSOCKOPT, s.fd, xdpStatistics, unsafe.Pointer(&stats), unsafe.Sizeof(stats)); err != nil {
+		return nil, fmt.Errorf("Cannot get AF_XDP capture stats: %s", err)
+	}
+
+	return &CaptureStats{
+		PacketsReceived: atomic.LoadInt64(&s.received),
+		PacketsDropped:  int64(stats.RxDropped),
+	}, nil
+}
+
+// Close the socket
+func (s *AFXDPSocket) Close() {
+	if s.rx != nil {
+		unix.Munmap(s.rx.mem)
+	}
+	if s.fill != nil {
+		unix.Munmap(s.fill.mem)
+	}
+	unix.Close(s.fd)
+	if s.umem != nil {
+		unix.Munmap(s.umem)
+	}
+}
+
+func (s *AFXDPSocket) bind(ifIndex, queue int, flags uint16) error {
+	sa := sockaddrXDP{
+		Family:  afXDP,
+		Flags:   flags,
+		Ifindex: uint32(ifIndex),
+		QueueID: uint32(queue),
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_BIND, uintptr(s.fd), uintptr(unsafe.Pointer(&sa)), unsafe.Sizeof(sa)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// NewAFXDPSocket returns a new AF_XDP socket bound to a queue of an
+// interface, in zero copy mode when allowed and in copy mode otherwise
+func NewAFXDPSocket(ifIndex, queue int, snaplen int, copyMode bool) (*AFXDPSocket, error) {
+	fd, err := unix.Socket(afXDP, unix.SOCK_RAW, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create AF_XDP socket: %s", err)
+	}
+	s := &AFXDPSocket{fd: fd, snaplen: snaplen}
+
+	if s.umem, err = unix.Mmap(-1, 0, afxdpNumFrames*afxdpFrameSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_POPULATE); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("Unable to allocate AF_XDP memory: %s", err)
+	}
+
+	reg := xdpUmemRegT{
+		Addr:      uint64(uintptr(unsafe.Pointer(&s.umem[0]))),
+		Len:       uint64(len(s.umem)),
+		ChunkSize: afxdpFrameSize,
+	}
+	if err = xdpSockopt(unix.SYS_SETSOCKOPT, fd, xdpUmemReg, unsafe.Pointer(&reg), unsafe.Sizeof(reg)); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("Unable to register AF_XDP memory: %s", err)
+	}
+
+	// the completion ring is mandatory even if nothing is sent
+	for _, opt := range []int{xdpUmemFillRing, xdpUmemCompletionRing, xdpRxRing} {
+		if err = unix.SetsockoptInt(fd, solXDP, opt, afxdpRingSize); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("Unable to set AF_XDP ring size: %s", err)
+		}
+	}
+
+	var offsets xdpMmapOffsetsT
+	if err = xdpSockopt(unix.SYS_GETSOCKOPT, fd, xdpMmapOffsets, unsafe.Pointer(&offsets), unsafe.Sizeof(offsets)); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("Unable to get AF_XDP rings offsets: %s", err)
+	}
+
+	if s.fill, err = mapXDPRing(fd, xdpUmemPgoffFillRing, offsets.Fr, 8); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("Unable to map AF_XDP fill ring: %s", err)
+	}
+	if s.rx, err = mapXDPRing(fd, xdpPgoffRxRing, offsets.Rx, int(unsafe.Sizeof(xdpDesc{}))); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("Unable to map AF_XDP receive ring: %s", err)
+	}
+
+	err = unix.EOPNOTSUPP
+	if !copyMode {
+		if err = s.bind(ifIndex, queue, xdpZeroCopy); err == nil {
+			s.zeroCopy = true
+		}
+	}
+	if err != nil {
+		if err = s.bind(ifIndex, queue, xdpCopy); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("Unable to bind AF_XDP socket to queue %d: %s", queue, err)
+		}
+	}
+
+	for i := 0; i < afxdpRingSize; i++ {
+		s.refill(uint64(i * afxdpFrameSize))
+	}
+
+	return s, nil
+}
+
+// AFXDPPacketProbe describes an AF_XDP based packet probe reading one queue
+// of an interface
+type AFXDPPacketProbe struct {
+	socket       *AFXDPSocket
+	program      *xdpProgram
+	packetSource *gopacket.PacketSource
+}
+
+// Close the probe
+func (a *AFXDPPacketProbe) Close() {
+	a.socket.Close()
+	a.program.release()
+}
+
+// Stats returns statistics about captured packets
+func (a *AFXDPPacketProbe) Stats() (*CaptureStats, error) {
+	return a.socket.Stats()
+}
+
+// SetBPFFilter applies a BPF filter to the probe. The filter is applied
+// when the sockets are created, either by the XDP program or in userspace.
+func (a *AFXDPPacketProbe) SetBPFFilter(filter string) error {
+	return nil
+}
+
+// PacketSource returns the Gopacket packet source for the probe
+func (a *AFXDPPacketProbe) PacketSource() *gopacket.PacketSource {
+	return a.packetSource
+}
+
+// NewAFXDPPacketProbes returns AF_XDP capture probes, one per receive queue
+// of the interface. The packets are redirected to the sockets by an XDP
+// program, also filtering the packets when the BPF filter can be translated.
+// Otherwise the filter is applied in userspace.
+// The redirected packets don't reach the kernel network stack anymore.
+func NewAFXDPPacketProbes(ifName string, headerSize int, layerType gopacket.LayerType, linkType layers.LinkType, bpfFilter string) ([]PacketProbe, error) {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("Error while opening device %s: %s", ifName, err)
+	}
+
+	queues := link.Attrs().NumRxQueues
+	if queues <= 0 {
+		queues = 1
+	}
+
+	// the maps and programs are accounted in locked memory
+	unix.Setrlimit(unix.RLIMIT_MEMLOCK, &unix.Rlimit{Cur: math.MaxUint64, Max: math.MaxUint64})
+
+	// instructions that can't be decoded are refused by the translation
+	var filter []bpf.Instruction
+	var userspaceBPF *flow.BPF
+	if bpfFilter != "" {
+		rawBPF, err := flow.BPFFilterToRaw(linkType, uint32(headerSize), bpfFilter)
+		if err != nil {
+			return nil, err
+		}
+		filter, _ = bpf.Disassemble(rawBPF)
+	}
+
+	program, err := newXDPProgram(link, queues, filter)
+	if err != nil && filter != nil {
+		logging.GetLogger().Warningf("Unable to filter packets of %s with XDP, filtering in userspace: %s", ifName, err)
+
+		if userspaceBPF, err = flow.NewBPF(linkType, uint32(headerSize), bpfFilter); err != nil {
+			return nil, err
+		}
+		program, err = newXDPProgram(link, queues, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer program.release()
+
+	var probes []PacketProbe
+	for queue := 0; queue < queues; queue++ {
+		socket, err := NewAFXDPSocket(link.Attrs().Index, queue, headerSize, program.generic())
+		if err == nil {
+			if err = program.register(queue, socket.fd); err != nil {
+				socket.Close()
+			}
+		}
+		if err != nil {
+			for _, probe := range probes {
+				probe.Close()
+			}
+			return nil, err
+		}
+		socket.bpf = userspaceBPF
+
+		logging.GetLogger().Debugf("AF_XDP socket bound to queue %d of %s, zero copy: %v", queue, ifName, socket.zeroCopy)
+
+		program.ref()
+		probes = append(probes, &AFXDPPacketProbe{
+			socket:       socket,
+			program:      program,
+			packetSource: gopacket.NewPacketSource(socket, layerType),
+		})
+	}
+
+	return probes, nil
+}
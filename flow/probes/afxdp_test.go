@@ -0,0 +1,146 @@
+// +build linux
+
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package probes
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+
+	"github.com/google/gopacket/afpacket"
+	"golang.org/x/sys/unix"
+)
+
+func newTestXDPRing(descSize int) *afxdpRing {
+	off := xdpRingOffset{Producer: 0, Consumer: 64, Desc: 128}
+	return newXDPRing(make([]byte, int(off.Desc)+afxdpRingSize*descSize), off)
+}
+
+// newTestAFXDPSocket returns a socket reading rings and frames held in
+// memory, its file descriptor being the read end of a pipe
+func newTestAFXDPSocket(t *testing.T, snaplen int) (*AFXDPSocket, int) {
+	var fds [2]int
+	if err := unix.Pipe(fds[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	return &AFXDPSocket{
+		fd:      fds[0],
+		umem:    make([]byte, afxdpNumFrames*afxdpFrameSize),
+		fill:    newTestXDPRing(8),
+		rx:      newTestXDPRing(int(unsafe.Sizeof(xdpDesc{}))),
+		snaplen: snaplen,
+	}, fds[1]
+}
+
+// receive stores a packet in the frame at addr and produces its descriptor
+// in the receive ring as the kernel does
+func (s *AFXDPSocket) receive(addr uint64, packet []byte) {
+	copy(s.umem[addr:], packet)
+
+	prod := *s.rx.producer
+	desc := (*xdpDesc)(unsafe.Pointer(uintptr(s.rx.descs) + uintptr(prod&s.rx.mask)*unsafe.Sizeof(xdpDesc{})))
+	desc.Addr = addr
+	desc.Len = uint32(len(packet))
+	*s.rx.producer = prod + 1
+}
+
+func (s *AFXDPSocket) filled(i uint32) uint64 {
+	return *(*uint64)(unsafe.Pointer(uintptr(s.fill.descs) + uintptr(i&s.fill.mask)*8))
+}
+
+func testPacket(size int) []byte {
+	packet := make([]byte, size)
+	for i := range packet {
+		packet[i] = byte(i)
+	}
+	return packet
+}
+
+func TestAFXDPReadPacketData(t *testing.T) {
+	s, w := newTestAFXDPSocket(t, 64)
+	defer unix.Close(w)
+	defer unix.Close(s.fd)
+
+	// start at the end of the receive ring to read wrapping descriptors
+	*s.rx.producer = afxdpRingSize - 1
+	*s.rx.consumer = afxdpRingSize - 1
+
+	small, large := testPacket(42), testPacket(100)
+	s.receive(3*afxdpFrameSize+256, small)
+	s.receive(5*afxdpFrameSize, large)
+
+	data, ci, err := s.ReadPacketData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, small) {
+		t.Errorf("Expected packet %v, got %v", small, data)
+	}
+	if ci.CaptureLength != 42 || ci.Length != 42 {
+		t.Errorf("Expected capture length and length of 42, got %d and %d", ci.CaptureLength, ci.Length)
+	}
+
+	// the frame is given back to the kernel, the packet has to be a copy
+	copy(s.umem[3*afxdpFrameSize+256:], make([]byte, 42))
+	if !bytes.Equal(data, small) {
+		t.Error("Packet data should not be shared with the frame")
+	}
+
+	data, ci, err = s.ReadPacketData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, large[:64]) {
+		t.Errorf("Expected packet truncated to the snaplen %v, got %v", large[:64], data)
+	}
+	if ci.CaptureLength != 64 || ci.Length != 100 {
+		t.Errorf("Expected capture length 64 and length 100, got %d and %d", ci.CaptureLength, ci.Length)
+	}
+
+	if cons := *s.rx.consumer; cons != afxdpRingSize+1 {
+		t.Errorf("Expected receive ring consumer %d, got %d", afxdpRingSize+1, cons)
+	}
+	if prod := *s.fill.producer; prod != 2 {
+		t.Errorf("Expected fill ring producer 2, got %d", prod)
+	}
+	for i, addr := range []uint64{3 * afxdpFrameSize, 5 * afxdpFrameSize} {
+		if filled := s.filled(uint32(i)); filled != addr {
+			t.Errorf("Expected frame %d to be refilled at its start %d, got %d", i, addr, filled)
+		}
+	}
+
+	if s.received != 2 {
+		t.Errorf("Expected 2 received packets, got %d", s.received)
+	}
+}
+
+func TestAFXDPReadPacketDataTimeout(t *testing.T) {
+	s, w := newTestAFXDPSocket(t, 64)
+	defer unix.Close(w)
+	defer unix.Close(s.fd)
+
+	if _, _, err := s.ReadPacketData(); err != afpacket.ErrTimeout {
+		t.Errorf("Expected a timeout on an empty receive ring, got %v", err)
+	}
+	if prod := *s.fill.producer; prod != 0 {
+		t.Errorf("Expected no refilled frame, got %d", prod)
+	}
+}
@@ -0,0 +1,578 @@
+// +build ebpf
+
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package probes
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/iovisor/gobpf/elf"
+	"github.com/pierrec/xxHash/xxHash64"
+	"golang.org/x/sys/unix"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/statics"
+	"github.com/skydive-project/skydive/topology"
+)
+
+/*
+#cgo CFLAGS: -I../../probe/ebpf
+#include <sys/resource.h>
+#include <linux/if_ether.h>
+#include "drop.h"
+*/
+import "C"
+
+const (
+	// reason reported by the kernels without drop reasons for the packets
+	// dropped by the netfilter hooks
+	netfilterDropReason   = "NETFILTER_DROP"
+	netfilterDropLocation = "nf_hook_slow"
+)
+
+// EBPFDropProbe reports the packets dropped by the kernel of a host, as
+// traced by the kfree_skb tracepoint, on the interfaces dropping them and
+// on the flows they belong to
+type EBPFDropProbe struct {
+	Ctx        Context
+	node       *graph.Node
+	uuids      flow.UUIDs
+	flowTable  *flow.Table
+	module     *elf.Module
+	statsMap   *elf.Map
+	symbols    *common.KernelSymbols
+	reasons    map[int64]string
+	rootNetNS  uint64
+	period     time.Duration
+	expire     time.Duration
+	counters   map[C.struct_drop_key]C.struct_drop_stats
+	flows      map[uint64]*dropFlow
+	interfaces map[graph.Identifier]*topology.InterfaceDropMetric
+	quit       chan struct{}
+	// drops read from the kernel waiting to be reported, the reporting
+	// being done apart from the polling as it locks the graph
+	pendingLock sync.Mutex
+	pending     []*dropDelta
+	notify      chan struct{}
+}
+
+// EBPFDropProbesHandler creates new eBPF drop probes
+type EBPFDropProbesHandler struct {
+	Ctx Context
+	wg  sync.WaitGroup
+}
+
+// drop counters of a kernel table entry since the previous poll
+type dropDelta struct {
+	key     C.struct_drop_key
+	packets int64
+	bytes   int64
+	start   int64
+	last    int64
+}
+
+// dropFlow holds the drops accumulated for a flow
+type dropFlow struct {
+	flow     *flow.Flow
+	lastSeen time.Time
+}
+
+func dropAddress(protocol C.__u16, addr [16]C.__u8) net.IP {
+	if protocol == C.ETH_P_IP {
+		return net.IPv4(byte(addr[0]), byte(addr[1]), byte(addr[2]), byte(addr[3])).To4()
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	for i := range ip {
+		ip[i] = byte(addr[i])
+	}
+	return ip
+}
+
+func dropTransportProtocol(ipProto C.__u8) (flow.FlowProtocol, bool) {
+	switch ipProto {
+	case syscall.IPPROTO_TCP:
+		return flow.FlowProtocol_TCP, true
+	case syscall.IPPROTO_UDP:
+		return flow.FlowProtocol_UDP, true
+	case syscall.IPPROTO_SCTP:
+		return flow.FlowProtocol_SCTP, true
+	}
+	return 0, false
+}
+
+// reason returns the name of the drop reason of an entry
+func (p *EBPFDropProbe) reason(key *C.struct_drop_key, location string) string {
+	if reason, found := p.reasons[int64(key.reason)]; found {
+		return reason
+	}
+
+	if location == netfilterDropLocation {
+		return netfilterDropReason
+	}
+
+	return ""
+}
+
+// interfaceNode returns the interface node of a network namespace with the
+// given index. Drops of the host namespace are looked up under the host node.
+func (p *EBPFDropProbe) interfaceNode(netns uint64, ifindex int64) *graph.Node {
+	if ifindex == 0 {
+		return nil
+	}
+
+	parent := p.node
+	if netns != 0 && netns != p.rootNetNS {
+		if parent = p.Ctx.Graph.LookupFirstNode(graph.Metadata{"Type": "netns", "Inode": int64(netns)}); parent == nil {
+			return nil
+		}
+	}
+
+	return p.Ctx.Graph.LookupFirstChild(parent, graph.Metadata{"IfIndex": ifindex})
+}
+
+// newFlow returns the flow of the dropped packets of an entry, if they are
+// IP packets, with its table key
+func (p *EBPFDropProbe) newFlow(delta *dropDelta, tid string, reason, location string) (*flow.Flow, uint64) {
+	key := &delta.key
+
+	var networkProtocol flow.FlowProtocol
+	switch key.protocol {
+	case C.ETH_P_IP:
+		networkProtocol = flow.FlowProtocol_IPV4
+	case C.ETH_P_IPV6:
+		networkProtocol = flow.FlowProtocol_IPV6
+	default:
+		return nil, 0
+	}
+
+	uuids := flow.UUIDs{NodeTID: tid, CaptureID: p.uuids.CaptureID}
+
+	f := flow.NewFlow()
+	f.Init(delta.start, "", &uuids)
+	f.Last = delta.last
+	f.Metric.Last = delta.last
+
+	f.Network = &flow.FlowLayer{
+		Protocol: networkProtocol,
+		A:        dropAddress(key.protocol, key.saddr).String(),
+		B:        dropAddress(key.protocol, key.daddr).String(),
+	}
+	f.Application = networkProtocol.String()
+
+	if transportProtocol, ok := dropTransportProtocol(key.ip_proto); ok {
+		f.Transport = &flow.TransportLayer{
+			Protocol: transportProtocol,
+			A:        int64(key.sport),
+			B:        int64(key.dport),
+		}
+		f.Application = transportProtocol.String()
+	}
+
+	f.DropMetric = &flow.DropMetric{
+		Packets:  delta.packets,
+		Bytes:    delta.bytes,
+		Reason:   reason,
+		Location: location,
+		Start:    delta.start,
+		Last:     delta.last,
+	}
+
+	l2, l3 := f.SetUUIDs(0, flow.Opts{LayerKeyMode: flow.L3PreferredKeyMode})
+
+	// flows dropped on distinct interfaces have to be distinct in the table
+	return f, xxHash64.Checksum([]byte(tid), l2^l3)
+}
+
+// accumulate adds the drops of the previous polls of a flow
+func (p *EBPFDropProbe) accumulate(key uint64, f *flow.Flow, now time.Time) {
+	if prev, ok := p.flows[key]; ok {
+		f.Start, f.Metric.Start, f.DropMetric.Start = prev.flow.Start, prev.flow.Start, prev.flow.DropMetric.Start
+		f.UUID = prev.flow.UUID
+		f.DropMetric.Packets += prev.flow.DropMetric.Packets
+		f.DropMetric.Bytes += prev.flow.DropMetric.Bytes
+	}
+
+	p.flows[key] = &dropFlow{flow: f, lastSeen: now}
+}
+
+// readCounters returns the drops of the kernel table since the previous poll,
+// removing the entries without drops for longer than the flow expiration
+func (p *EBPFDropProbe) readCounters(bootTime int64, now time.Time) []*dropDelta {
+	var key, nextKey C.struct_drop_key
+	var stats C.struct_drop_stats
+	var idle []C.struct_drop_key
+	var deltas []*dropDelta
+
+	for {
+		found, err := p.module.LookupNextElement(p.statsMap, unsafe.Pointer(&key), unsafe.Pointer(&nextKey), unsafe.Pointer(&stats))
+		if err != nil {
+			p.Ctx.Logger.Errorf("Unable to read drop stats: %s", err)
+			break
+		}
+		if !found {
+			break
+		}
+		key = nextKey
+
+		last := time.Unix(0, bootTime+int64(stats.last))
+
+		prev := p.counters[key]
+		if stats.packets == prev.packets {
+			if now.Sub(last) > p.expire {
+				idle = append(idle, key)
+			}
+			continue
+		}
+		p.counters[key] = stats
+
+		deltas = append(deltas, &dropDelta{
+			key:     key,
+			packets: int64(stats.packets - prev.packets),
+			bytes:   int64(stats.bytes - prev.bytes),
+			start:   common.UnixMillis(time.Unix(0, bootTime+int64(stats.start))),
+			last:    common.UnixMillis(last),
+		})
+	}
+
+	for _, key := range idle {
+		p.module.DeleteElement(p.statsMap, unsafe.Pointer(&key))
+		delete(p.counters, key)
+	}
+
+	return deltas
+}
+
+// poll reads the drops of the kernel table and passes them to the reporter
+func (p *EBPFDropProbe) poll() {
+	// offset between the monotonic clock used by the kernel and the wall clock
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		p.Ctx.Logger.Errorf("Unable to get monotonic time: %s", err)
+		return
+	}
+	now := time.Now()
+	bootTime := now.UnixNano() - ts.Nano()
+
+	deltas := p.readCounters(bootTime, now)
+	if len(deltas) == 0 {
+		return
+	}
+
+	p.pendingLock.Lock()
+	p.pending = append(p.pending, deltas...)
+	p.pendingLock.Unlock()
+
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// report reports the pending drops on the interface nodes and on the flows
+// of the dropped packets
+func (p *EBPFDropProbe) report(extFlowChan chan *flow.ExtFlow) {
+	p.pendingLock.Lock()
+	deltas := p.pending
+	p.pending = nil
+	p.pendingLock.Unlock()
+
+	now := time.Now()
+
+	var flows []*flow.Operation
+
+	p.Ctx.Graph.Lock()
+	updated := make(map[graph.Identifier]*graph.Node)
+	for _, delta := range deltas {
+		location := p.symbols.Lookup(uint64(delta.key.location))
+		reason := p.reason(&delta.key, location)
+
+		tid := p.uuids.NodeTID
+		if node := p.interfaceNode(uint64(delta.key.netns), int64(delta.key.ifindex)); node != nil {
+			if nodeTID, _ := node.GetFieldString("TID"); nodeTID != "" {
+				tid = nodeTID
+			}
+
+			metric, found := p.interfaces[node.ID]
+			if !found {
+				metric = &topology.InterfaceDropMetric{Start: delta.start, Reasons: make(map[string]int64)}
+				p.interfaces[node.ID] = metric
+			}
+			metric.Packets += delta.packets
+			metric.Bytes += delta.bytes
+			if reason != "" {
+				metric.Reasons[reason] += delta.packets
+			}
+			if delta.last > metric.Last {
+				metric.Last = delta.last
+			}
+			updated[node.ID] = node
+		}
+
+		if f, key := p.newFlow(delta, tid, reason, location); f != nil {
+			p.accumulate(key, f, now)
+			flows = append(flows, &flow.Operation{Type: flow.ReplaceOperation, Flow: f, Key: key})
+		}
+	}
+
+	for id, node := range updated {
+		// the graph owns the metadata, give it a copy
+		metric := *p.interfaces[id]
+		metric.Reasons = make(map[string]int64, len(p.interfaces[id].Reasons))
+		for reason, packets := range p.interfaces[id].Reasons {
+			metric.Reasons[reason] = packets
+		}
+
+		if err := p.Ctx.Graph.AddMetadata(node, "DropMetric", &metric); err != nil {
+			p.Ctx.Logger.Errorf("Unable to update drops of node %s: %s", id, err)
+		}
+	}
+	p.Ctx.Graph.Unlock()
+
+	for key, df := range p.flows {
+		if now.Sub(df.lastSeen) > p.expire {
+			delete(p.flows, key)
+		}
+	}
+
+	for _, op := range flows {
+		extFlowChan <- &flow.ExtFlow{Type: flow.OperationExtFlowType, Obj: op}
+	}
+}
+
+func (p *EBPFDropProbe) run() {
+	_, extFlowChan := p.flowTable.Start(nil)
+	defer p.flowTable.Stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-p.quit:
+				return
+			case <-p.notify:
+				p.report(extFlowChan)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(p.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+			p.poll()
+		}
+	}
+}
+
+// stop does not wait for the probe as the reporter may be waiting for the
+// graph lock held by the caller
+func (p *EBPFDropProbe) stop() {
+	close(p.quit)
+}
+
+// dropConfig returns the kernel program configuration matching the format
+// of the kfree_skb tracepoint of the running kernel, with the names of the
+// drop reasons
+func (p *EBPFDropProbesHandler) dropConfig() (*C.struct_drop_config, map[int64]string, error) {
+	format, err := common.GetTracepointFormat("skb", "kfree_skb")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := &C.struct_drop_config{}
+	if field, found := format.Fields["reason"]; found {
+		switch field.Offset {
+		case 28, 36:
+			config.reason_offset = C.__u32(field.Offset)
+		default:
+			p.Ctx.Logger.Warningf("Unsupported offset %d of the drop reason, reasons won't be reported", field.Offset)
+		}
+	}
+
+	return config, format.Symbols("reason"), nil
+}
+
+func (p *EBPFDropProbesHandler) loadModule() (*elf.Module, error) {
+	err := syscall.Setrlimit(C.RLIMIT_MEMLOCK, &syscall.Rlimit{
+		Cur: math.MaxUint64,
+		Max: math.MaxUint64,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to adjust rlimit map lock")
+	}
+
+	data, err := statics.Asset("probe/ebpf/drop.o")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find eBPF elf binary in bindata")
+	}
+
+	module := elf.NewModuleFromReader(bytes.NewReader(data))
+	if err = module.Load(nil); err != nil {
+		return nil, fmt.Errorf("Unable to load eBPF drop module: %s", err)
+	}
+
+	p.Ctx.Logger.Infof("Loaded eBPF module probe/ebpf/drop.o")
+
+	return module, nil
+}
+
+func rootNetNSInode() (uint64, error) {
+	fi, err := os.Stat("/proc/self/ns/net")
+	if err != nil {
+		return 0, err
+	}
+
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return stat.Ino, nil
+	}
+
+	return 0, fmt.Errorf("Unable to get the inode of the network namespace")
+}
+
+// RegisterProbe registers an eBPF drop probe on a host
+func (p *EBPFDropProbesHandler) RegisterProbe(n *graph.Node, capture *types.Capture, e ProbeEventHandler) (Probe, error) {
+	tid, _ := n.GetFieldString("TID")
+	if tid == "" {
+		return nil, fmt.Errorf("No tid for node %s", n.ID)
+	}
+
+	config, reasons, err := p.dropConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	symbols, err := common.NewKernelSymbols()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read kernel symbols: %s", err)
+	}
+
+	rootNetNS, err := rootNetNSInode()
+	if err != nil {
+		return nil, err
+	}
+
+	module, err := p.loadModule()
+	if err != nil {
+		return nil, err
+	}
+
+	configMap, statsMap := module.Map("drop_config"), module.Map("drop_stats_table")
+	if configMap == nil || statsMap == nil {
+		module.Close()
+		return nil, fmt.Errorf("Unable to find drop maps")
+	}
+
+	var zero C.__u32
+	if err = module.UpdateElement(configMap, unsafe.Pointer(&zero), unsafe.Pointer(config), 0); err != nil {
+		module.Close()
+		return nil, fmt.Errorf("Unable to configure drop module: %s", err)
+	}
+
+	// only enabled once configured, drops being ignored until then
+	if err = module.EnableTracepoint("tracepoint/skb/kfree_skb"); err != nil {
+		module.Close()
+		return nil, fmt.Errorf("Unable to enable kfree_skb tracepoint: %s", err)
+	}
+
+	uuids := flow.UUIDs{NodeTID: tid, CaptureID: capture.UUID}
+	ft := p.Ctx.FTA.Alloc(uuids, tableOptsFromCapture(capture))
+
+	probe := &EBPFDropProbe{
+		Ctx:        p.Ctx,
+		node:       n,
+		uuids:      uuids,
+		flowTable:  ft,
+		module:     module,
+		statsMap:   statsMap,
+		symbols:    symbols,
+		reasons:    reasons,
+		rootNetNS:  rootNetNS,
+		period:     time.Duration(p.Ctx.Config.GetInt("agent.flow.ebpf.drop_update")) * time.Second,
+		expire:     time.Duration(p.Ctx.Config.GetInt("flow.expire")) * time.Second,
+		counters:   make(map[C.struct_drop_key]C.struct_drop_stats),
+		flows:      make(map[uint64]*dropFlow),
+		interfaces: make(map[graph.Identifier]*topology.InterfaceDropMetric),
+		quit:       make(chan struct{}),
+		notify:     make(chan struct{}, 1),
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		e.OnStarted(&CaptureMetadata{})
+
+		probe.run()
+
+		module.Close()
+		p.Ctx.FTA.Release(ft)
+
+		e.OnStopped()
+	}()
+
+	return probe, nil
+}
+
+// UnregisterProbe stops an eBPF drop probe
+func (p *EBPFDropProbesHandler) UnregisterProbe(n *graph.Node, e ProbeEventHandler, fp Probe) error {
+	fp.(*EBPFDropProbe).stop()
+	return nil
+}
+
+// Start the probe handler
+func (p *EBPFDropProbesHandler) Start() {
+}
+
+// Stop the probe handler
+func (p *EBPFDropProbesHandler) Stop() {
+	p.wg.Wait()
+}
+
+// CaptureTypes supported
+func (p *EBPFDropProbesHandler) CaptureTypes() []string {
+	return []string{"ebpfdrop"}
+}
+
+// Init initializes a new eBPF drop probe handler
+func (p *EBPFDropProbesHandler) Init(ctx Context, bundle *probe.Bundle) (FlowProbeHandler, error) {
+	p.Ctx = ctx
+	return p, nil
+}
@@ -0,0 +1,359 @@
+// +build ebpf
+
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package probes
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/iovisor/gobpf/elf"
+	"golang.org/x/sys/unix"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/statics"
+	"github.com/skydive-project/skydive/topology/probes/socketinfo"
+)
+
+/*
+#cgo CFLAGS: -I../../probe/ebpf
+#include <sys/resource.h>
+#include <sys/socket.h>
+#include "socket.h"
+*/
+import "C"
+
+// EBPFSocketProbe measures, from the socket calls of the processes, the
+// connection setup time, the amount of data and the request/response
+// latency of the TCP connections of a host
+type EBPFSocketProbe struct {
+	Ctx        Context
+	uuids      flow.UUIDs
+	flowTable  *flow.Table
+	module     *elf.Module
+	statsMap   *elf.Map
+	socketInfo *socketinfo.ProbeHandler
+	period     time.Duration
+	quit       chan bool
+}
+
+// EBPFSocketProbesHandler creates new eBPF socket probes
+type EBPFSocketProbesHandler struct {
+	Ctx        Context
+	socketInfo *socketinfo.ProbeHandler
+	wg         sync.WaitGroup
+}
+
+// socketConnection holds the connection and the flow of a kernel socket entry
+type socketConnection struct {
+	conn *socketinfo.ConnectionInfo
+	flow *flow.Flow
+	key  uint64
+}
+
+func socketAddress(family C.__u16, addr [16]C.__u8) net.IP {
+	if family == C.AF_INET {
+		return net.IPv4(byte(addr[0]), byte(addr[1]), byte(addr[2]), byte(addr[3])).To4()
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	for i := range ip {
+		ip[i] = byte(addr[i])
+	}
+	return ip
+}
+
+func (p *EBPFSocketProbe) processInfo(pid int, comm string, processes map[int]socketinfo.ProcessInfo) socketinfo.ProcessInfo {
+	if processInfo, found := processes[pid]; found {
+		return processInfo
+	}
+
+	processInfo := socketinfo.ProcessInfo{Pid: int64(pid), Name: comm}
+	if pi, err := common.GetProcessInfo(pid); err == nil {
+		processInfo.Process, processInfo.Name = pi.Process, pi.Name
+	}
+	processes[pid] = processInfo
+
+	return processInfo
+}
+
+// newConnection returns the connection and the flow of a kernel socket entry,
+// the flow going from the client to the server
+func (p *EBPFSocketProbe) newConnection(stats *C.struct_socket_stats, bootTime int64, processes map[int]socketinfo.ProcessInfo) *socketConnection {
+	localIP, remoteIP := socketAddress(stats.family, stats.local_addr), socketAddress(stats.family, stats.remote_addr)
+	localPort, remotePort := int64(stats.local_port), int64(stats.remote_port)
+
+	metric := socketinfo.ConnectionMetric{
+		ConnectLatency:    int64(stats.connect_latency),
+		BytesSent:         int64(stats.bytes_sent),
+		BytesReceived:     int64(stats.bytes_received),
+		Requests:          int64(stats.requests),
+		MaxRequestLatency: int64(stats.latency_max),
+	}
+	if metric.Requests > 0 {
+		metric.RequestLatency = int64(stats.latency_sum) / metric.Requests
+	}
+
+	state := socketinfo.ConnectionState("ESTABLISHED")
+	if stats.closed != 0 {
+		state = "CLOSE"
+	}
+
+	comm := C.GoString((*C.char)(unsafe.Pointer(&stats.comm[0])))
+
+	conn := &socketinfo.ConnectionInfo{
+		ProcessInfo:      p.processInfo(int(stats.pid), comm, processes),
+		ConnectionMetric: metric,
+		LocalAddress:     localIP.String(),
+		LocalPort:        localPort,
+		RemoteAddress:    remoteIP.String(),
+		RemotePort:       remotePort,
+		Protocol:         flow.FlowProtocol_TCP,
+		State:            state,
+	}
+
+	f := flow.NewFlow()
+	f.Init(common.UnixMillis(time.Unix(0, bootTime+int64(stats.start))), "", &p.uuids)
+	f.Last = common.UnixMillis(time.Unix(0, bootTime+int64(stats.last)))
+
+	networkProtocol := flow.FlowProtocol_IPV4
+	if stats.family == C.AF_INET6 {
+		networkProtocol = flow.FlowProtocol_IPV6
+	}
+
+	f.Network = &flow.FlowLayer{Protocol: networkProtocol, A: conn.LocalAddress, B: conn.RemoteAddress}
+	f.Transport = &flow.TransportLayer{Protocol: flow.FlowProtocol_TCP, A: localPort, B: remotePort}
+	f.Application = "TCP"
+	f.Metric = &flow.FlowMetric{
+		ABBytes: metric.BytesSent,
+		BABytes: metric.BytesReceived,
+		RTT:     metric.ConnectLatency,
+		Start:   f.Start,
+		Last:    f.Last,
+	}
+
+	if stats.role == C.SOCKET_ROLE_SERVER {
+		f.Network.A, f.Network.B = f.Network.B, f.Network.A
+		f.Transport.A, f.Transport.B = f.Transport.B, f.Transport.A
+		f.Metric.ABBytes, f.Metric.BABytes = f.Metric.BABytes, f.Metric.ABBytes
+	}
+
+	l2, l3 := f.SetUUIDs(0, flow.Opts{LayerKeyMode: flow.L3PreferredKeyMode})
+
+	return &socketConnection{conn: conn, flow: f, key: l2 ^ l3}
+}
+
+// poll reports the connections of the kernel table, removing the closed ones
+func (p *EBPFSocketProbe) poll(extFlowChan chan *flow.ExtFlow) {
+	// offset between the monotonic clock used by the kernel and the wall clock
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		p.Ctx.Logger.Errorf("Unable to get monotonic time: %s", err)
+		return
+	}
+	bootTime := time.Now().UnixNano() - ts.Nano()
+
+	var key, nextKey uint64
+	var stats C.struct_socket_stats
+	var closed []uint64
+	var conns []*socketinfo.ConnectionInfo
+
+	processes := make(map[int]socketinfo.ProcessInfo)
+	for {
+		found, err := p.module.LookupNextElement(p.statsMap, unsafe.Pointer(&key), unsafe.Pointer(&nextKey), unsafe.Pointer(&stats))
+		if err != nil {
+			p.Ctx.Logger.Errorf("Unable to read socket stats: %s", err)
+			break
+		}
+		if !found {
+			break
+		}
+		key = nextKey
+
+		if stats.closed != 0 {
+			closed = append(closed, key)
+		}
+
+		// connection not established yet, or failed
+		if stats.family == 0 {
+			continue
+		}
+
+		sc := p.newConnection(&stats, bootTime, processes)
+		conns = append(conns, sc.conn)
+
+		extFlowChan <- &flow.ExtFlow{
+			Type: flow.OperationExtFlowType,
+			Obj: &flow.Operation{
+				Type: flow.ReplaceOperation,
+				Flow: sc.flow,
+				Key:  sc.key,
+			},
+		}
+	}
+
+	for _, key := range closed {
+		p.module.DeleteElement(p.statsMap, unsafe.Pointer(&key))
+	}
+
+	if p.socketInfo != nil {
+		p.socketInfo.UpdateConnectionMetrics(conns)
+	}
+}
+
+func (p *EBPFSocketProbe) run() {
+	_, extFlowChan := p.flowTable.Start(nil)
+	defer p.flowTable.Stop()
+
+	ticker := time.NewTicker(p.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+			p.poll(extFlowChan)
+		}
+	}
+}
+
+func (p *EBPFSocketProbe) stop() {
+	p.quit <- true
+}
+
+func (p *EBPFSocketProbesHandler) loadModule() (*elf.Module, error) {
+	err := syscall.Setrlimit(C.RLIMIT_MEMLOCK, &syscall.Rlimit{
+		Cur: math.MaxUint64,
+		Max: math.MaxUint64,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to adjust rlimit map lock")
+	}
+
+	data, err := statics.Asset("probe/ebpf/socket.o")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find eBPF elf binary in bindata")
+	}
+
+	module := elf.NewModuleFromReader(bytes.NewReader(data))
+	if err = module.Load(nil); err != nil {
+		return nil, fmt.Errorf("Unable to load eBPF socket module: %s", err)
+	}
+
+	if err = module.EnableKprobes(0); err != nil {
+		module.Close()
+		return nil, fmt.Errorf("Unable to enable socket kprobes: %s", err)
+	}
+
+	p.Ctx.Logger.Infof("Loaded eBPF module probe/ebpf/socket.o")
+
+	return module, nil
+}
+
+// RegisterProbe registers an eBPF socket probe on a host
+func (p *EBPFSocketProbesHandler) RegisterProbe(n *graph.Node, capture *types.Capture, e ProbeEventHandler) (Probe, error) {
+	tid, _ := n.GetFieldString("TID")
+	if tid == "" {
+		return nil, fmt.Errorf("No tid for node %s", n.ID)
+	}
+
+	module, err := p.loadModule()
+	if err != nil {
+		return nil, err
+	}
+
+	statsMap := module.Map("socket_stats_table")
+	if statsMap == nil {
+		module.Close()
+		return nil, fmt.Errorf("Unable to find socket_stats_table map")
+	}
+
+	uuids := flow.UUIDs{NodeTID: tid, CaptureID: capture.UUID}
+	ft := p.Ctx.FTA.Alloc(uuids, tableOptsFromCapture(capture))
+
+	probe := &EBPFSocketProbe{
+		Ctx:        p.Ctx,
+		uuids:      uuids,
+		flowTable:  ft,
+		module:     module,
+		statsMap:   statsMap,
+		socketInfo: p.socketInfo,
+		period:     time.Duration(p.Ctx.Config.GetInt("agent.flow.ebpf.socket_update")) * time.Second,
+		quit:       make(chan bool),
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		e.OnStarted(&CaptureMetadata{})
+
+		probe.run()
+
+		module.Close()
+		p.Ctx.FTA.Release(ft)
+
+		e.OnStopped()
+	}()
+
+	return probe, nil
+}
+
+// UnregisterProbe stops an eBPF socket probe
+func (p *EBPFSocketProbesHandler) UnregisterProbe(n *graph.Node, e ProbeEventHandler, fp Probe) error {
+	fp.(*EBPFSocketProbe).stop()
+	return nil
+}
+
+// Start the probe handler
+func (p *EBPFSocketProbesHandler) Start() {
+}
+
+// Stop the probe handler
+func (p *EBPFSocketProbesHandler) Stop() {
+	p.wg.Wait()
+}
+
+// CaptureTypes supported
+func (p *EBPFSocketProbesHandler) CaptureTypes() []string {
+	return []string{"ebpfsocket"}
+}
+
+// Init initializes a new eBPF socket probe handler. The metrics are attached
+// to the connections of the socketinfo topology probe when enabled.
+func (p *EBPFSocketProbesHandler) Init(ctx Context, bundle *probe.Bundle) (FlowProbeHandler, error) {
+	p.Ctx = ctx
+
+	if handler := ctx.TB.GetHandler("socketinfo"); handler != nil {
+		p.socketInfo, _ = handler.(*socketinfo.ProbeHandler)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package probes
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/erspan"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/probe"
+)
+
+type erspanProbe struct {
+	common.RWMutex
+	handler *ERSpanProbesHandler
+	tid     string
+	capture *types.Capture
+	fta     *flow.TableAllocator
+	tables  map[string]*flow.Table
+	stopped bool
+}
+
+// ERSpanProbesHandler describes a probe collecting the frames mirrored
+// over GRE, ERSPAN and VXLAN by remote devices
+type ERSpanProbesHandler struct {
+	common.RWMutex
+	graph.DefaultGraphListener
+	Ctx       Context
+	allocator *erspan.CollectorAllocator
+	static    map[uint32]graph.Metadata
+	// TIDs of the nodes of the sessions, kept up to date from the graph
+	// events so that the frames are mapped without locking the graph
+	staticTIDs map[uint32]string
+	ruleTIDs   map[uint32]string
+}
+
+// updateSessions maps the sessions onto a node, the ones given by the
+// configuration and the one of its metadata, as set by a node rule
+func (d *ERSpanProbesHandler) updateSessions(n *graph.Node, deleted bool) {
+	tid, _ := n.GetFieldString("TID")
+	if tid == "" {
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	for id, m := range d.static {
+		if !deleted && m.Match(n) {
+			d.staticTIDs[id] = tid
+		} else if d.staticTIDs[id] == tid {
+			delete(d.staticTIDs, id)
+		}
+	}
+
+	for id, t := range d.ruleTIDs {
+		if t == tid {
+			delete(d.ruleTIDs, id)
+		}
+	}
+
+	if !deleted {
+		// node rules set the metadata as strings
+		if v, err := n.GetField("ERSpanSessionID"); err == nil {
+			if id, err := common.ToInt64(v); err == nil {
+				d.ruleTIDs[uint32(id)] = tid
+			}
+		}
+	}
+}
+
+// sessionNodeTID returns the TID of the node of a session, the one given
+// by the configuration being preferred
+func (d *ERSpanProbesHandler) sessionNodeTID(sessionID uint32) string {
+	d.RLock()
+	defer d.RUnlock()
+
+	if tid, ok := d.staticTIDs[sessionID]; ok {
+		return tid
+	}
+	return d.ruleTIDs[sessionID]
+}
+
+// OnNodeAdded event
+func (d *ERSpanProbesHandler) OnNodeAdded(n *graph.Node) {
+	d.updateSessions(n, false)
+}
+
+// OnNodeUpdated event
+func (d *ERSpanProbesHandler) OnNodeUpdated(n *graph.Node) {
+	d.updateSessions(n, false)
+}
+
+// OnNodeDeleted event
+func (d *ERSpanProbesHandler) OnNodeDeleted(n *graph.Node) {
+	d.updateSessions(n, true)
+}
+
+// SessionFlowTable returns the flow table of the node of a session, the
+// frames of the unknown sessions going to the table of the capture node
+func (p *erspanProbe) SessionFlowTable(addr net.IP, sessionID uint32) (*flow.Table, error) {
+	tid := p.handler.sessionNodeTID(sessionID)
+	if tid == "" {
+		tid = p.tid
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.stopped {
+		return nil, errors.New("capture stopped")
+	}
+
+	ft, ok := p.tables[tid]
+	if !ok {
+		uuids := flow.UUIDs{NodeTID: tid, CaptureID: p.capture.UUID}
+		ft = p.fta.Alloc(uuids, tableOptsFromCapture(p.capture))
+		ft.Start(nil)
+
+		p.tables[tid] = ft
+	}
+
+	return ft, nil
+}
+
+func (p *erspanProbe) releaseTables() {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, ft := range p.tables {
+		ft.Stop()
+		p.fta.Release(ft)
+	}
+	p.tables = make(map[string]*flow.Table)
+	p.stopped = true
+}
+
+// UnregisterProbe unregisters a probe from the graph
+func (d *ERSpanProbesHandler) UnregisterProbe(n *graph.Node, e ProbeEventHandler, p Probe) error {
+	probe := p.(*erspanProbe)
+
+	// the collector readers never lock the graph, so waiting for them is
+	// safe while the caller holds the graph lock
+	d.allocator.Release(probe.capture.UUID)
+	probe.releaseTables()
+
+	if e != nil {
+		go e.OnStopped()
+	}
+
+	return nil
+}
+
+// RegisterProbe registers a probe in the graph
+func (d *ERSpanProbesHandler) RegisterProbe(n *graph.Node, capture *types.Capture, e ProbeEventHandler) (Probe, error) {
+	tid, _ := n.GetFieldString("TID")
+	if tid == "" {
+		return nil, fmt.Errorf("No TID for node %v", n)
+	}
+
+	addresses, _ := n.GetFieldStringList("IPV4")
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("No IP for node %v", n)
+	}
+
+	address := "0.0.0.0"
+	if len(addresses) == 1 {
+		address = strings.Split(addresses[0], "/")[0]
+	}
+
+	probe := &erspanProbe{
+		handler: d,
+		tid:     tid,
+		capture: capture,
+		fta:     d.Ctx.FTA,
+		tables:  make(map[string]*flow.Table),
+	}
+
+	// the VXLAN mirrors are only received when a port is given
+	addr := common.ServiceAddress{Addr: address, Port: capture.Port}
+	if _, err := d.allocator.Alloc(capture.UUID, &addr, capture.BPFFilter, uint32(capture.HeaderSize), probe); err != nil {
+		return nil, err
+	}
+
+	go e.OnStarted(&CaptureMetadata{})
+
+	return probe, nil
+}
+
+// Start a probe
+func (d *ERSpanProbesHandler) Start() {
+	d.Ctx.Graph.RLock()
+	defer d.Ctx.Graph.RUnlock()
+
+	d.Ctx.Graph.AddEventListener(d)
+	for _, n := range d.Ctx.Graph.GetNodes(nil) {
+		d.updateSessions(n, false)
+	}
+}
+
+// Stop a probe
+func (d *ERSpanProbesHandler) Stop() {
+	d.Ctx.Graph.RemoveEventListener(d)
+	d.allocator.ReleaseAll()
+}
+
+// CaptureTypes supported
+func (d *ERSpanProbesHandler) CaptureTypes() []string {
+	return []string{"erspan"}
+}
+
+// parseSessions reads the nodes of the sessions from the configuration,
+// each one being defined by its metadata in the k1=v1,k2=v2 format
+func parseSessions(defs map[string]string) (map[uint32]graph.Metadata, error) {
+	sessions := make(map[uint32]graph.Metadata)
+	for id, def := range defs {
+		sessionID, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ERSPAN session ID %s: %s", id, err)
+		}
+
+		m := graph.Metadata{}
+		for _, pair := range strings.Split(def, ",") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("Metadata of ERSPAN session %s must be defined by pair k=v: %s", id, def)
+			}
+
+			key, value := strings.Trim(kv[0], `"`), strings.Trim(kv[1], `"`)
+			if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+				m[key] = i
+			} else {
+				m[key] = value
+			}
+		}
+		sessions[uint32(sessionID)] = m
+	}
+
+	return sessions, nil
+}
+
+// Init initializes a new ERSPAN probe
+func (d *ERSpanProbesHandler) Init(ctx Context, bundle *probe.Bundle) (FlowProbeHandler, error) {
+	static, err := parseSessions(config.GetStringMapString("agent.flow.erspan.sessions"))
+	if err != nil {
+		return nil, err
+	}
+
+	d.Ctx = ctx
+	d.allocator = erspan.NewCollectorAllocator()
+	d.static = static
+	d.staticTIDs = make(map[uint32]string)
+	d.ruleTIDs = make(map[uint32]string)
+
+	return d, nil
+}
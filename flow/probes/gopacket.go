@@ -22,7 +22,9 @@ package probes
 import (
 	"fmt"
 	"io"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
@@ -43,8 +45,13 @@ const (
 	AFPacket = "afpacket"
 	// PCAP probe type
 	PCAP = "pcap"
+	// AFXDP probe type
+	AFXDP = "afxdp"
 )
 
+// fanoutID is used to give a distinct fanout group to each capture
+var fanoutID = uint32(os.Getpid())
+
 // PacketProbe describes a probe responsible for capturing packets
 type PacketProbe interface {
 	Stats() (*CaptureStats, error)
@@ -55,17 +62,21 @@ type PacketProbe interface {
 
 // GoPacketProbe describes a new probe that store packets from gopacket pcap library in a flowtable
 type GoPacketProbe struct {
-	Ctx         Context
-	n           *graph.Node
-	packetProbe PacketProbe
-	state       common.ServiceState
-	ifName      string
-	bpfFilter   string
-	nsPath      string
-	captureType string
-	layerType   gopacket.LayerType
-	linkType    layers.LinkType
-	headerSize  uint32
+	Ctx          Context
+	n            *graph.Node
+	packetProbes []PacketProbe
+	state        common.ServiceState
+	ifName       string
+	bpfFilter    string
+	nsPath       string
+	captureType  string
+	layerType    gopacket.LayerType
+	linkType     layers.LinkType
+	headerSize   uint32
+	// number of afpacket sockets of the fanout group, each one read by
+	// its own goroutine
+	workers         int
+	afpacketOptions AFPacketOptions
 }
 
 type ftProbe struct {
@@ -85,7 +96,7 @@ func (p *GoPacketProbe) updateStats(g *graph.Graph, n *graph.Node, captureStats
 	for {
 		select {
 		case <-ticker.C:
-			if stats, err := p.packetProbe.Stats(); err != nil {
+			if stats, err := p.stats(); err != nil {
 				p.Ctx.Logger.Error(err)
 			} else if p.state.Load() == common.RunningState {
 				g.Lock()
@@ -103,8 +114,31 @@ func (p *GoPacketProbe) updateStats(g *graph.Graph, n *graph.Node, captureStats
 	}
 }
 
-func (p *GoPacketProbe) listen(packetCallback func(gopacket.Packet)) error {
-	packetSource := p.packetProbe.PacketSource()
+// stats returns the sum of the statistics of the packet probes
+func (p *GoPacketProbe) stats() (*CaptureStats, error) {
+	captureStats := &CaptureStats{}
+	for _, packetProbe := range p.packetProbes {
+		stats, err := packetProbe.Stats()
+		if err != nil {
+			return nil, err
+		}
+
+		captureStats.PacketsReceived += stats.PacketsReceived
+		captureStats.PacketsDropped += stats.PacketsDropped
+		captureStats.PacketsIfDropped += stats.PacketsIfDropped
+	}
+	return captureStats, nil
+}
+
+func (p *GoPacketProbe) closePacketProbes() {
+	for _, packetProbe := range p.packetProbes {
+		packetProbe.Close()
+	}
+	p.packetProbes = nil
+}
+
+func (p *GoPacketProbe) listen(packetProbe PacketProbe, packetCallback func(gopacket.Packet)) error {
+	packetSource := packetProbe.PacketSource()
 
 	var errs int
 	for p.state.Load() == common.RunningState {
@@ -135,8 +169,50 @@ func (p *GoPacketProbe) listen(packetCallback func(gopacket.Packet)) error {
 	return nil
 }
 
+// listenAll reads the packets of all the packet probes, each one from its own
+// goroutine. The first error stops all the packet probes and is returned, the
+// following ones being logged.
+func (p *GoPacketProbe) listenAll(packetCallback func(gopacket.Packet)) error {
+	errs := make(chan error, len(p.packetProbes))
+
+	// AF_XDP uses a socket per queue whatever the number of workers
+	if len(p.packetProbes) > 1 && p.workers <= 1 && packetCallback != nil {
+		var lock sync.Mutex
+		callback := packetCallback
+		packetCallback = func(packet gopacket.Packet) {
+			lock.Lock()
+			callback(packet)
+			lock.Unlock()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, packetProbe := range p.packetProbes {
+		wg.Add(1)
+		go func(packetProbe PacketProbe) {
+			defer wg.Done()
+
+			if err := p.listen(packetProbe, packetCallback); err != nil {
+				errs <- err
+				p.state.Store(common.StoppingState)
+			}
+		}(packetProbe)
+	}
+	wg.Wait()
+
+	close(errs)
+
+	err := <-errs
+	for e := range errs {
+		p.Ctx.Logger.Errorf("Error while capturing packets on %s: %s", p.ifName, e)
+	}
+	return err
+}
+
 // Run starts capturing packet, calling the passed callback for every packet
-// and notifying the flow probe handler when the capture has started
+// and notifying the flow probe handler when the capture has started. When
+// several workers are used, the callback is called from several goroutines,
+// otherwise the calls are serialized.
 func (p *GoPacketProbe) Run(packetCallback func(gopacket.Packet), e ProbeEventHandler) error {
 	p.state.Store(common.RunningState)
 
@@ -152,19 +228,43 @@ func (p *GoPacketProbe) Run(packetCallback func(gopacket.Packet), e ProbeEventHa
 
 	switch p.captureType {
 	case PCAP:
-		p.packetProbe, err = NewPcapPacketProbe(p.ifName, int(p.headerSize))
+		packetProbe, err := NewPcapPacketProbe(p.ifName, int(p.headerSize))
 		if err != nil {
 			return err
 		}
+		p.packetProbes = []PacketProbe{packetProbe}
 		p.Ctx.Logger.Infof("PCAP Capture started on %s with First layer: %s", p.ifName, p.layerType)
-	default:
-		if err = common.Retry(func() error {
-			p.packetProbe, err = NewAfpacketPacketProbe(p.ifName, int(p.headerSize), p.layerType, p.linkType)
-			return err
-		}, 2, 100*time.Millisecond); err != nil {
+	case AFXDP:
+		packetProbes, err := NewAFXDPPacketProbes(p.ifName, int(p.headerSize), p.layerType, p.linkType, p.bpfFilter)
+		if err != nil {
 			return err
 		}
-		p.Ctx.Logger.Infof("AfPacket Capture started on %s with First layer: %s", p.ifName, p.layerType)
+		p.packetProbes = packetProbes
+		p.Ctx.Logger.Infof("AF_XDP Capture started on %s with First layer: %s, %d queue(s)", p.ifName, p.layerType, len(packetProbes))
+	default:
+		workers, opts := 1, p.afpacketOptions
+		if p.workers > 1 {
+			workers = p.workers
+			if opts.FanoutMode == "" {
+				opts.FanoutMode = "hash"
+			}
+			opts.FanoutID = uint16(atomic.AddUint32(&fanoutID, 1))
+		} else {
+			opts.FanoutMode = ""
+		}
+
+		for i := 0; i < workers; i++ {
+			var packetProbe *AfpacketPacketProbe
+			if err = common.Retry(func() error {
+				packetProbe, err = NewAfpacketPacketProbe(p.ifName, int(p.headerSize), p.layerType, p.linkType, opts)
+				return err
+			}, 2, 100*time.Millisecond); err != nil {
+				p.closePacketProbes()
+				return err
+			}
+			p.packetProbes = append(p.packetProbes, packetProbe)
+		}
+		p.Ctx.Logger.Infof("AfPacket Capture started on %s with First layer: %s, %d worker(s)", p.ifName, p.layerType, workers)
 	}
 
 	// leave the namespace, stay lock in the current thread
@@ -179,8 +279,11 @@ func (p *GoPacketProbe) Run(packetCallback func(gopacket.Packet), e ProbeEventHa
 
 	// manage BPF outside namespace because of syscall
 	if p.bpfFilter != "" {
-		if err := p.packetProbe.SetBPFFilter(p.bpfFilter); err != nil {
-			return fmt.Errorf("Failed to set BPF filter: %s", err)
+		for _, packetProbe := range p.packetProbes {
+			if err := packetProbe.SetBPFFilter(p.bpfFilter); err != nil {
+				p.closePacketProbes()
+				return fmt.Errorf("Failed to set BPF filter: %s", err)
+			}
 		}
 	}
 
@@ -191,13 +294,13 @@ func (p *GoPacketProbe) Run(packetCallback func(gopacket.Packet), e ProbeEventHa
 	wg.Add(1)
 	go p.updateStats(p.Ctx.Graph, p.n, &metadata.CaptureStats, statsTicker, statsDone, &wg)
 
-	err = p.listen(packetCallback)
+	err = p.listenAll(packetCallback)
 
 	close(statsDone)
 	wg.Wait()
 	statsTicker.Stop()
 
-	p.packetProbe.Close()
+	p.closePacketProbes()
 	p.state.Store(common.StoppedState)
 
 	return err
@@ -208,7 +311,7 @@ func (p *GoPacketProbe) Stop() {
 	p.state.Store(common.StoppingState)
 }
 
-// NewGoPacketProbe returns a new Gopacket flow probe. It can use either `pcap` or `afpacket`
+// NewGoPacketProbe returns a new Gopacket flow probe. It can use either `pcap`, `afpacket` or `afxdp`
 func NewGoPacketProbe(ctx Context, n *graph.Node, captureType, bpfFilter string, headerSize uint32) (*GoPacketProbe, error) {
 	ifName, _ := n.GetFieldString("Name")
 	if ifName == "" {
@@ -278,6 +381,14 @@ func (p *GoPacketProbesHandler) RegisterProbe(n *graph.Node, capture *types.Capt
 		return nil, err
 	}
 
+	probe.workers = capture.Workers
+	probe.afpacketOptions = AFPacketOptions{
+		BlockSize:    capture.RingBlockSize,
+		NumBlocks:    capture.RingBlocks,
+		BlockTimeout: time.Duration(capture.BlockTimeout) * time.Millisecond,
+		FanoutMode:   capture.FanoutMode,
+	}
+
 	// Apply temporarely the BPF in userspace to prevent non expected packet
 	// between capture creation and the filter apply.
 	var bpf *flow.BPF
@@ -302,15 +413,15 @@ func (p *GoPacketProbesHandler) RegisterProbe(n *graph.Node, capture *types.Capt
 		target.Start()
 		defer target.Stop()
 
-		count := 0
+		var count int64
 		err := probe.Run(func(packet gopacket.Packet) {
-			target.SendPacket(packet, bpf)
 			// NOTE: bpf userspace filter is applied to the few first packets in order to avoid
 			// to get unexpected packets between capture start and bpf applying
-			if count > 50 {
-				bpf = nil
+			if atomic.AddInt64(&count, 1) > 51 {
+				target.SendPacket(packet, nil)
+			} else {
+				target.SendPacket(packet, bpf)
 			}
-			count++
 		}, e)
 
 		if err != nil {
@@ -353,7 +464,7 @@ func (p *GoPacketProbesHandler) Stop() {
 
 // CaptureTypes supported
 func (p *GoPacketProbesHandler) CaptureTypes() []string {
-	return []string{"afpacket", "pcap"}
+	return []string{"afpacket", "pcap", "afxdp"}
 }
 
 // Init initializes a new GoPacket probe
@@ -0,0 +1,142 @@
+// +build linux
+
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package probes
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/afpacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// fakePacketSource returns the same error for every read. If a barrier is
+// given, the read making the worker fail waits for the other sources so that
+// all the workers fail before the capture is stopped.
+type fakePacketSource struct {
+	err     error
+	reads   int
+	barrier *sync.WaitGroup
+}
+
+func (s *fakePacketSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if s.reads++; s.barrier != nil && s.reads == 21 {
+		s.barrier.Done()
+		s.barrier.Wait()
+	}
+	return nil, gopacket.CaptureInfo{}, s.err
+}
+
+type fakePacketProbe struct {
+	source *gopacket.PacketSource
+}
+
+func (p *fakePacketProbe) Stats() (*CaptureStats, error) {
+	return &CaptureStats{}, nil
+}
+
+func (p *fakePacketProbe) SetBPFFilter(bpf string) error {
+	return nil
+}
+
+func (p *fakePacketProbe) PacketSource() *gopacket.PacketSource {
+	return p.source
+}
+
+func (p *fakePacketProbe) Close() {
+}
+
+func newFakePacketProbe(err error, barrier *sync.WaitGroup) *fakePacketProbe {
+	return &fakePacketProbe{source: gopacket.NewPacketSource(&fakePacketSource{err: err, barrier: barrier}, layers.LayerTypeEthernet)}
+}
+
+type fakeErrorLogger struct {
+	logging.Logger
+	sync.Mutex
+	errors int
+}
+
+func (l *fakeErrorLogger) Errorf(format string, args ...interface{}) {
+	l.Lock()
+	l.errors++
+	l.Unlock()
+}
+
+func listenAllProbes(t *testing.T, packetProbes ...PacketProbe) (*GoPacketProbe, *fakeErrorLogger, error) {
+	logger := &fakeErrorLogger{Logger: logging.GetLogger()}
+	p := &GoPacketProbe{
+		Ctx:          Context{Logger: logger},
+		ifName:       "eth0",
+		packetProbes: packetProbes,
+		workers:      len(packetProbes),
+	}
+	p.state.Store(common.RunningState)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- p.listenAll(nil)
+	}()
+
+	select {
+	case err := <-errs:
+		return p, logger, err
+	case <-time.After(10 * time.Second):
+		p.Stop()
+		t.Fatal("Packet probes not stopped")
+	}
+	return nil, nil, nil
+}
+
+func TestListenAllError(t *testing.T) {
+	// the failing worker stops the idle one
+	p, logger, err := listenAllProbes(t, newFakePacketProbe(io.EOF, nil), newFakePacketProbe(afpacket.ErrPoll, nil))
+	if err != afpacket.ErrPoll {
+		t.Fatalf("Expected the error of the failing worker, got %v", err)
+	}
+
+	if state := p.state.Load(); state != common.StoppingState {
+		t.Errorf("Expected the capture to be stopping, got %v", state)
+	}
+
+	if logger.errors != 0 {
+		t.Errorf("Expected no error to be logged, got %d", logger.errors)
+	}
+}
+
+func TestListenAllErrors(t *testing.T) {
+	// only the first error is returned, the other ones are logged
+	var barrier sync.WaitGroup
+	barrier.Add(3)
+
+	_, logger, err := listenAllProbes(t, newFakePacketProbe(afpacket.ErrPoll, &barrier), newFakePacketProbe(afpacket.ErrPoll, &barrier), newFakePacketProbe(afpacket.ErrPoll, &barrier))
+	if err != afpacket.ErrPoll {
+		t.Fatalf("Expected the error of a failing worker, got %v", err)
+	}
+
+	if logger.errors != 2 {
+		t.Errorf("Expected 2 errors to be logged, got %d", logger.errors)
+	}
+}
@@ -0,0 +1,245 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package probes
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/netflow"
+	"github.com/skydive-project/skydive/probe"
+	"github.com/skydive-project/skydive/topology"
+)
+
+const (
+	defaultIPFIXPort = 4739
+)
+
+type ipfixExporter struct {
+	node     *graph.Node
+	lastSeen time.Time
+}
+
+type ipfixProbe struct {
+	common.RWMutex
+	g            *graph.Graph
+	node         *graph.Node
+	uuid         string
+	ft           *flow.Table
+	exporters    map[string]*ipfixExporter
+	maxExporters int
+	stopped      bool
+}
+
+// IPFIXProbesHandler describes an IPFIX and NetFlow v9 collector probe
+// in the graph
+type IPFIXProbesHandler struct {
+	Ctx          Context
+	allocator    *netflow.CollectorAllocator
+	maxExporters int
+}
+
+// removeOldestExporter removes the node of the exporter which sent records
+// the least recently, to be called with the graph lock held
+func (p *ipfixProbe) removeOldestExporter() {
+	var oldest string
+	for addr, exporter := range p.exporters {
+		if oldest == "" || exporter.lastSeen.Before(p.exporters[oldest].lastSeen) {
+			oldest = addr
+		}
+	}
+
+	if exporter, ok := p.exporters[oldest]; ok {
+		if err := p.g.DelNode(exporter.node); err != nil {
+			logging.GetLogger().Errorf("Unable to remove exporter node %s: %s", exporter.node.ID, err)
+		}
+		delete(p.exporters, oldest)
+	}
+}
+
+// ExporterNodeTID returns the TID of the node of an exporter, creating the
+// node and linking it to the capture node on its first records. The number
+// of exporter nodes is bounded, the least recently seen exporter being
+// removed when exceeded.
+func (p *ipfixProbe) ExporterNodeTID(addr net.IP) (string, error) {
+	// the graph lock is taken first, as when the probe is unregistered
+	p.g.Lock()
+	defer p.g.Unlock()
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.stopped {
+		return "", errors.New("capture stopped")
+	}
+
+	if exporter, ok := p.exporters[addr.String()]; ok {
+		exporter.lastSeen = time.Now()
+		tid, _ := exporter.node.GetFieldString("TID")
+		return tid, nil
+	}
+
+	if p.maxExporters > 0 && len(p.exporters) >= p.maxExporters {
+		p.removeOldestExporter()
+	}
+
+	id := graph.GenID(string(p.node.ID), "ipfix", addr.String())
+
+	node := p.g.GetNode(id)
+	if node == nil {
+		m := graph.Metadata{
+			"Name":  addr.String(),
+			"Type":  "flowexporter",
+			"Probe": "ipfix",
+			// the TID has to be known before the TID mapper handles the node
+			"TID": string(id),
+		}
+
+		if addr.To4() != nil {
+			m["IPV4"] = []string{addr.String()}
+		} else {
+			m["IPV6"] = []string{addr.String()}
+		}
+
+		var err error
+		if node, err = p.g.NewNode(id, m); err != nil {
+			return "", err
+		}
+
+		if _, err = topology.AddLink(p.g, p.node, node, "ipfix", nil); err != nil {
+			return "", err
+		}
+	}
+	p.exporters[addr.String()] = &ipfixExporter{node: node, lastSeen: time.Now()}
+
+	return string(id), nil
+}
+
+// removeExporters removes the exporter nodes, to be called with the graph
+// lock held as done when unregistering the probe
+func (p *ipfixProbe) removeExporters() {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, exporter := range p.exporters {
+		if err := p.g.DelNode(exporter.node); err != nil {
+			logging.GetLogger().Errorf("Unable to remove exporter node %s: %s", exporter.node.ID, err)
+		}
+	}
+	p.exporters = make(map[string]*ipfixExporter)
+	p.stopped = true
+}
+
+// UnregisterProbe unregisters a probe from the graph, the caller holding
+// the graph lock
+func (d *IPFIXProbesHandler) UnregisterProbe(n *graph.Node, e ProbeEventHandler, p Probe) error {
+	probe := p.(*ipfixProbe)
+
+	d.allocator.Release(probe.uuid)
+	d.Ctx.FTA.Release(probe.ft)
+	probe.removeExporters()
+
+	if e != nil {
+		go e.OnStopped()
+	}
+
+	return nil
+}
+
+// RegisterProbe registers a probe in the graph
+func (d *IPFIXProbesHandler) RegisterProbe(n *graph.Node, capture *types.Capture, e ProbeEventHandler) (Probe, error) {
+	tid, _ := n.GetFieldString("TID")
+	if tid == "" {
+		return nil, fmt.Errorf("No TID for node %v", n)
+	}
+
+	addresses, _ := n.GetFieldStringList("IPV4")
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("No IP for node %v", n)
+	}
+
+	address := "0.0.0.0"
+	if len(addresses) == 1 {
+		address = strings.Split(addresses[0], "/")[0]
+	}
+
+	port := capture.Port
+	if capture.Port <= 0 {
+		port = defaultIPFIXPort
+	}
+
+	// the table belongs to the capture node while the flows get the TID
+	// of the node of their exporter
+	uuids := flow.UUIDs{NodeTID: tid, CaptureID: capture.UUID}
+	ft := d.Ctx.FTA.Alloc(uuids, tableOptsFromCapture(capture))
+
+	probe := &ipfixProbe{
+		g:            d.Ctx.Graph,
+		node:         n,
+		uuid:         capture.UUID,
+		ft:           ft,
+		exporters:    make(map[string]*ipfixExporter),
+		maxExporters: d.maxExporters,
+	}
+
+	addr := common.ServiceAddress{Addr: address, Port: port}
+	if _, err := d.allocator.Alloc(capture.UUID, ft, &addr, uuids, probe); err != nil {
+		d.Ctx.FTA.Release(ft)
+		return nil, err
+	}
+
+	go e.OnStarted(&CaptureMetadata{})
+
+	return probe, nil
+}
+
+// Start a probe
+func (d *IPFIXProbesHandler) Start() {
+}
+
+// Stop a probe
+func (d *IPFIXProbesHandler) Stop() {
+	d.allocator.ReleaseAll()
+}
+
+// CaptureTypes supported
+func (d *IPFIXProbesHandler) CaptureTypes() []string {
+	return []string{"ipfix"}
+}
+
+// Init initializes a new IPFIX probe
+func (d *IPFIXProbesHandler) Init(ctx Context, bundle *probe.Bundle) (FlowProbeHandler, error) {
+	exporters, err := netflow.ParseExporters(ctx.Config.GetStringSlice("agent.flow.ipfix.exporters"))
+	if err != nil {
+		return nil, err
+	}
+
+	d.Ctx = ctx
+	d.allocator = netflow.NewCollectorAllocator(exporters)
+	d.maxExporters = ctx.Config.GetInt("agent.flow.ipfix.max_exporters")
+
+	return d, nil
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package probes
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/netflow"
+)
+
+type fakeProbeEventHandler struct{}
+
+func (h *fakeProbeEventHandler) OnStarted(*CaptureMetadata) {}
+func (h *fakeProbeEventHandler) OnStopped()                 {}
+func (h *fakeProbeEventHandler) OnError(err error)          {}
+
+func exporterNodes(g *graph.Graph) []*graph.Node {
+	g.RLock()
+	defer g.RUnlock()
+
+	return g.GetNodes(graph.Metadata{"Type": "flowexporter"})
+}
+
+func newIPFIXProbe(t *testing.T, maxExporters int) (*graph.Graph, *IPFIXProbesHandler, *graph.Node, Probe) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("testhost", b, common.UnknownService)
+
+	// pick a free port for the collector
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	handler := &IPFIXProbesHandler{
+		Ctx: Context{
+			Graph: g,
+			FTA:   flow.NewTableAllocator(time.Hour, time.Hour, nil),
+		},
+		allocator:    netflow.NewCollectorAllocator(nil),
+		maxExporters: maxExporters,
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	n, err := g.NewNode(graph.GenID(), graph.Metadata{"Name": "host", "TID": "host-tid", "IPV4": []string{"127.0.0.1/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capture := &types.Capture{Type: "ipfix", Port: port}
+	capture.UUID = "ipfix-capture"
+
+	p, err := handler.RegisterProbe(n, capture, &fakeProbeEventHandler{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return g, handler, n, p
+}
+
+func TestIPFIXExportersBounded(t *testing.T) {
+	g, handler, n, p := newIPFIXProbe(t, 2)
+	probe := p.(*ipfixProbe)
+
+	for _, addr := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1", "10.0.0.3"} {
+		if _, err := probe.ExporterNodeTID(net.ParseIP(addr)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nodes := exporterNodes(g)
+	if len(nodes) != 2 {
+		t.Fatalf("Expected 2 exporter nodes, got %v", nodes)
+	}
+
+	// the least recently seen exporter is the one removed
+	for _, node := range nodes {
+		if name, _ := node.GetFieldString("Name"); name == "10.0.0.2" {
+			t.Errorf("Exporter 10.0.0.2 should have been removed")
+		}
+	}
+
+	g.Lock()
+	handler.UnregisterProbe(n, nil, p)
+	g.Unlock()
+}
+
+func TestIPFIXProbeStop(t *testing.T) {
+	g, handler, n, p := newIPFIXProbe(t, 0)
+	probe := p.(*ipfixProbe)
+
+	if _, err := probe.ExporterNodeTID(net.ParseIP("10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// records keep coming while the probe is stopped
+	exporting := make(chan struct{})
+	go func() {
+		defer close(exporting)
+		for {
+			if _, err := probe.ExporterNodeTID(net.ParseIP("10.0.0.2")); err != nil {
+				return
+			}
+		}
+	}()
+
+	// the probes are unregistered with the graph lock held
+	stopped := make(chan struct{})
+	go func() {
+		g.Lock()
+		defer g.Unlock()
+
+		handler.UnregisterProbe(n, nil, p)
+		close(stopped)
+	}()
+
+	for _, done := range []chan struct{}{stopped, exporting} {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timeout while stopping the probe")
+		}
+	}
+
+	if nodes := exporterNodes(g); len(nodes) != 0 {
+		t.Errorf("Expected no exporter node, got %v", nodes)
+	}
+
+	if _, err := probe.ExporterNodeTID(net.ParseIP("10.0.0.3")); err == nil {
+		t.Error("Expected an error once the probe stopped")
+	}
+}
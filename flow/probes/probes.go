@@ -67,7 +67,7 @@ type ProbeEventHandler interface {
 
 // NewFlowProbeBundle returns a new bundle of flow probes
 func NewFlowProbeBundle(tb *probe.Bundle, g *graph.Graph, fta *flow.TableAllocator) *probe.Bundle {
-	list := []string{"pcapsocket", "ovssflow", "sflow", "gopacket", "dpdk", "ebpf", "ovsmirror", "ovsnetflow"}
+	list := []string{"pcapsocket", "ovssflow", "sflow", "gopacket", "dpdk", "ebpf", "ebpfsocket", "ebpfdrop", "ovsmirror", "ovsnetflow", "ipfix", "erspan"}
 	logging.GetLogger().Infof("Flow probes: %v", list)
 
 	var handler FlowProbeHandler
@@ -100,10 +100,18 @@ func NewFlowProbeBundle(tb *probe.Bundle, g *graph.Graph, fta *flow.TableAllocat
 			handler, err = new(SFlowProbesHandler).Init(ctx, bundle)
 		case "ovsnetflow":
 			handler, err = new(OvsNetFlowProbesHandler).Init(ctx, bundle)
+		case "ipfix":
+			handler, err = new(IPFIXProbesHandler).Init(ctx, bundle)
+		case "erspan":
+			handler, err = new(ERSpanProbesHandler).Init(ctx, bundle)
 		case "dpdk":
 			handler, err = new(DPDKProbesHandler).Init(ctx, bundle)
 		case "ebpf":
 			handler, err = new(EBPFProbesHandler).Init(ctx, bundle)
+		case "ebpfsocket":
+			handler, err = new(EBPFSocketProbesHandler).Init(ctx, bundle)
+		case "ebpfdrop":
+			handler, err = new(EBPFDropProbesHandler).Init(ctx, bundle)
 		default:
 			err = fmt.Errorf("unknown probe type %s", t)
 		}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package targets
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/netflow"
+)
+
+const (
+	// keep the UDP messages below the usual MTU to avoid fragmentation
+	ipfixUDPMessageSize = 1400
+	ipfixTCPMessageSize = 65535
+)
+
+// IPFIXTarget defines an IPFIX target, exporting the flows over UDP or TCP
+type IPFIXTarget struct {
+	sync.RWMutex
+	network         string
+	target          string
+	conn            net.Conn
+	table           *flow.Table
+	encoder         *netflow.IPFIXEncoder
+	templateRefresh time.Duration
+	templatesSent   time.Time
+}
+
+// SendPacket implements the Target interface
+func (ipf *IPFIXTarget) SendPacket(packet gopacket.Packet, bpf *flow.BPF) {
+	ipf.table.FeedWithGoPacket(packet, bpf)
+}
+
+func (ipf *IPFIXTarget) connect() error {
+	conn, err := net.Dial(ipf.network, ipf.target)
+	if err != nil {
+		return err
+	}
+
+	ipf.conn = conn
+
+	// templates have to be sent again to the new connection
+	ipf.templatesSent = time.Time{}
+
+	return nil
+}
+
+// needTemplates returns whether the templates have to be sent, once per
+// connection for TCP and periodically for UDP
+func (ipf *IPFIXTarget) needTemplates(now time.Time) bool {
+	if ipf.templatesSent.IsZero() {
+		return true
+	}
+	return ipf.network == "udp" && now.Sub(ipf.templatesSent) >= ipf.templateRefresh
+}
+
+// SendFlows implements the flow Sender interface
+func (ipf *IPFIXTarget) SendFlows(flows []*flow.Flow) {
+	ipf.Lock()
+	defer ipf.Unlock()
+
+	if ipf.conn == nil {
+		if err := ipf.connect(); err != nil {
+			logging.GetLogger().Errorf("IPFIX connection error to %s: %s", ipf.target, err)
+			return
+		}
+	}
+
+	now := time.Now()
+
+	withTemplates := ipf.needTemplates(now)
+	for _, msg := range ipf.encoder.Encode(flows, now, withTemplates) {
+		if _, err := ipf.conn.Write(msg); err != nil {
+			logging.GetLogger().Errorf("IPFIX write error to %s: %s", ipf.target, err)
+
+			// reconnect on the next flows
+			ipf.conn.Close()
+			ipf.conn = nil
+			return
+		}
+	}
+
+	if withTemplates {
+		ipf.templatesSent = now
+	}
+}
+
+// Start start the target
+func (ipf *IPFIXTarget) Start() {
+	ipf.Lock()
+	defer ipf.Unlock()
+
+	if err := ipf.connect(); err != nil {
+		logging.GetLogger().Errorf("IPFIX connection error to %s: %s", ipf.target, err)
+	}
+
+	ipf.table.Start(nil)
+}
+
+// Stop stops the target
+func (ipf *IPFIXTarget) Stop() {
+	ipf.table.Stop()
+
+	ipf.Lock()
+	defer ipf.Unlock()
+
+	if ipf.conn != nil {
+		ipf.conn.Close()
+		ipf.conn = nil
+	}
+}
+
+// NewIPFIXTarget returns a new IPFIX target. The target address can be
+// prefixed by tcp:// or udp://, UDP being used by default.
+func NewIPFIXTarget(g *graph.Graph, n *graph.Node, capture *types.Capture, uuids flow.UUIDs) (*IPFIXTarget, error) {
+	network, target := "udp", capture.Target
+	if i := strings.Index(target, "://"); i != -1 {
+		network, target = target[:i], target[i+3:]
+	}
+
+	messageSize := ipfixUDPMessageSize
+	switch network {
+	case "udp":
+	case "tcp":
+		messageSize = ipfixTCPMessageSize
+	default:
+		return nil, fmt.Errorf("Invalid IPFIX transport: %s", network)
+	}
+
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return nil, fmt.Errorf("Invalid target address %s: %s", capture.Target, err)
+	}
+
+	updateEvery := time.Duration(config.GetInt("flow.update")) * time.Second
+	expireAfter := time.Duration(config.GetInt("flow.expire")) * time.Second
+
+	// each capture is a distinct observation domain
+	domainID := crc32.ChecksumIEEE([]byte(capture.UUID))
+	enterpriseID := uint32(config.GetInt("agent.flow.ipfix.enterprise_id"))
+
+	ipf := &IPFIXTarget{
+		network:         network,
+		target:          target,
+		encoder:         netflow.NewIPFIXEncoder(domainID, enterpriseID, messageSize),
+		templateRefresh: time.Duration(config.GetInt("agent.flow.ipfix.template_refresh")) * time.Second,
+	}
+
+	ipf.table = flow.NewTable(updateEvery, expireAfter, ipf, uuids, tableOptsFromCapture(capture))
+
+	return ipf, nil
+}
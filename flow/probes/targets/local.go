@@ -18,40 +18,142 @@
 package targets
 
 import (
+	"path/filepath"
+	"sync"
+	"time"
+
 	"github.com/google/gopacket"
 	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/flow"
 	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/logging"
 )
 
-// LocalTarget send packet to an agent flow table
+type shardPacket struct {
+	packet gopacket.Packet
+	bpf    *flow.BPF
+}
+
+// LocalTarget send packet to an agent flow table. When the capture uses
+// several workers, the packets are dispatched to as many tables according
+// to their flows, the tables being merged by the table allocator on query.
+// The packets are also kept by the packet ring of the capture if enabled.
 type LocalTarget struct {
-	table *flow.Table
-	fta   *flow.TableAllocator
+	tables []*flow.Table
+	shards []chan *shardPacket
+	ring   *flow.PacketRing
+	fta    *flow.TableAllocator
+	wg     sync.WaitGroup
+}
+
+// packetShard returns the shard of a packet, using a symmetric hash of its
+// addresses so that both directions and the fragments of a flow are handled
+// by the same table
+func packetShard(packet gopacket.Packet, shards int) int {
+	var hash uint64
+	if layer := packet.NetworkLayer(); layer != nil {
+		hash = layer.NetworkFlow().FastHash()
+	} else if layer := packet.LinkLayer(); layer != nil {
+		hash = layer.LinkFlow().FastHash()
+	}
+	return int(hash % uint64(shards))
 }
 
 // SendPacket implements the Target interface
 func (l *LocalTarget) SendPacket(packet gopacket.Packet, bpf *flow.BPF) {
-	l.table.FeedWithGoPacket(packet, bpf)
+	if l.ring != nil && (bpf == nil || bpf.Matches(packet.Data())) {
+		if err := l.ring.AddGoPacket(packet); err != nil {
+			logging.GetLogger().Errorf("Failed to add packet to the packet ring: %s", err)
+		}
+	}
+
+	if len(l.shards) == 0 {
+		l.tables[0].FeedWithGoPacket(packet, bpf)
+		return
+	}
+
+	l.shards[packetShard(packet, len(l.shards))] <- &shardPacket{packet: packet, bpf: bpf}
 }
 
 // Start target
 func (l *LocalTarget) Start() {
-	l.table.Start(nil)
+	for _, table := range l.tables {
+		table.Start(nil)
+	}
+
+	for i, shard := range l.shards {
+		l.wg.Add(1)
+		go func(table *flow.Table, shard chan *shardPacket) {
+			defer l.wg.Done()
+
+			for sp := range shard {
+				table.FeedWithGoPacket(sp.packet, sp.bpf)
+			}
+		}(l.tables[i], shard)
+	}
 }
 
 // Stop target
 func (l *LocalTarget) Stop() {
-	l.table.Stop()
-	l.fta.Release(l.table)
+	for _, shard := range l.shards {
+		close(shard)
+	}
+	l.wg.Wait()
+
+	for _, table := range l.tables {
+		table.Stop()
+		l.fta.Release(table)
+	}
+
+	if l.ring != nil {
+		l.fta.ReleasePacketRing(l.ring)
+	}
+}
+
+// packetRingOptsFromCapture returns the options of the packet ring of a
+// capture node. The size of the rings is bounded by the agent configuration.
+func packetRingOptsFromCapture(capture *types.Capture, uuids flow.UUIDs) flow.PacketRingOpts {
+	opts := flow.PacketRingOpts{
+		Size:     capture.PacketRingSize,
+		Duration: time.Duration(capture.PacketRingDuration) * time.Second,
+	}
+
+	maxSize := int64(config.GetInt("agent.capture.packet_ring.max_size")) * 1024 * 1024
+	if maxSize > 0 && (opts.Size == 0 || opts.Size > maxSize) {
+		opts.Size = maxSize
+	}
+
+	if capture.PacketRingStorage == "disk" {
+		opts.Path = filepath.Join(config.GetString("agent.capture.packet_ring.path"), uuids.CaptureID+"-"+uuids.NodeTID)
+	}
+
+	return opts
 }
 
 // NewLocalTarget returns a new local target
 func NewLocalTarget(g *graph.Graph, n *graph.Node, capture *types.Capture, uuids flow.UUIDs, fta *flow.TableAllocator) (*LocalTarget, error) {
-	table := fta.Alloc(uuids, tableOptsFromCapture(capture))
+	l := &LocalTarget{fta: fta}
+
+	if capture.Workers > 1 {
+		for i := 0; i < capture.Workers; i++ {
+			l.tables = append(l.tables, fta.Alloc(uuids, tableOptsFromCapture(capture)))
+			l.shards = append(l.shards, make(chan *shardPacket, 1000))
+		}
+	} else {
+		l.tables = []*flow.Table{fta.Alloc(uuids, tableOptsFromCapture(capture))}
+	}
+
+	if capture.PacketRingSize > 0 || capture.PacketRingDuration > 0 {
+		ring, err := fta.AllocPacketRing(uuids, packetRingOptsFromCapture(capture, uuids))
+		if err != nil {
+			for _, table := range l.tables {
+				fta.Release(table)
+			}
+			return nil, err
+		}
+		l.ring = ring
+	}
 
-	return &LocalTarget{
-		table: table,
-		fta:   fta,
-	}, nil
+	return l, nil
 }
@@ -0,0 +1,202 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package targets
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/api/types"
+	"github.com/skydive-project/skydive/filters"
+	"github.com/skydive-project/skydive/flow"
+)
+
+type fakeFlowSender struct {
+	sync.Mutex
+	flows map[string]int
+}
+
+func (s *fakeFlowSender) SendFlows(flows []*flow.Flow) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, f := range flows {
+		s.flows[f.UUID]++
+	}
+}
+
+// udpPacket returns an UDP packet between two hosts, in the direction of
+// the reply if reply is true
+func udpPacket(t *testing.T, host1, host2 int, reply bool) gopacket.Packet {
+	srcMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, byte(host1)}
+	dstMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, byte(host2)}
+	srcIP := net.IP{10, 0, 0, byte(host1)}
+	dstIP := net.IP{10, 0, 1, byte(host2)}
+	srcPort, dstPort := layers.UDPPort(10000+host1), layers.UDPPort(53)
+
+	if reply {
+		srcMAC, dstMAC = dstMAC, srcMAC
+		srcIP, dstIP = dstIP, srcIP
+		srcPort, dstPort = dstPort, srcPort
+	}
+
+	eth := &layers.Ethernet{SrcMAC: srcMAC, DstMAC: dstMAC, EthernetType: layers.EthernetTypeIPv4}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: srcIP, DstIP: dstIP}
+	udp := &layers.UDP{SrcPort: srcPort, DstPort: dstPort}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	buffer := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, opts, eth, ip, udp, gopacket.Payload([]byte("skydive"))); err != nil {
+		t.Fatal(err)
+	}
+
+	return gopacket.NewPacket(buffer.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+}
+
+func newShardedTarget(t *testing.T, workers int) (*LocalTarget, *flow.TableAllocator, *fakeFlowSender) {
+	sender := &fakeFlowSender{flows: make(map[string]int)}
+	fta := flow.NewTableAllocator(time.Hour, time.Hour, sender)
+
+	capture := &types.Capture{Workers: workers, LayerKeyMode: "L2"}
+	target, err := NewLocalTarget(nil, nil, capture, flow.UUIDs{NodeTID: "probe-1"}, fta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(target.tables) != workers || len(target.shards) != workers {
+		t.Fatalf("Expected %d tables and shards, got %d and %d", workers, len(target.tables), len(target.shards))
+	}
+
+	target.Start()
+
+	// wait for the tables to be running so that they are queried and
+	// drained on stop
+	for _, table := range target.tables {
+		for i := 0; table.Query(&flow.TableQuery{Type: "SearchQuery", Query: &filters.SearchQuery{}}) == nil; i++ {
+			if i == 50 {
+				t.Fatal("Flow table not started")
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	return target, fta, sender
+}
+
+// queryFlows returns the flows of all the tables of the allocator
+func queryFlows(t *testing.T, fta *flow.TableAllocator) []*flow.Flow {
+	reply := fta.QueryTable(&flow.TableQuery{Type: "SearchQuery", Query: &filters.SearchQuery{}})
+
+	var flows []*flow.Flow
+	for _, b := range reply.FlowSetBytes {
+		fs := flow.NewFlowSet()
+		if err := fs.Unmarshal(b); err != nil {
+			t.Fatalf("Unable to decode flow set: %s", err)
+		}
+		flows = append(flows, fs.Flows...)
+	}
+	return flows
+}
+
+func TestPacketShard(t *testing.T) {
+	for _, shards := range []int{2, 3, 4, 8} {
+		used := make(map[int]bool)
+		for host := 1; host < 64; host++ {
+			request := packetShard(udpPacket(t, host, host+1, false), shards)
+			reply := packetShard(udpPacket(t, host, host+1, true), shards)
+
+			if request != reply {
+				t.Fatalf("Both directions of a flow should go to the same shard, got %d and %d", request, reply)
+			}
+			if request < 0 || request >= shards {
+				t.Fatalf("Invalid shard %d for %d shards", request, shards)
+			}
+			used[request] = true
+		}
+
+		if len(used) < 2 {
+			t.Errorf("Expected the flows to be spread over the %d shards, only %d used", shards, len(used))
+		}
+	}
+}
+
+func TestLocalTargetShardsQuery(t *testing.T) {
+	target, fta, _ := newShardedTarget(t, 4)
+	defer target.Stop()
+
+	const hosts = 32
+	for host := 1; host <= hosts; host++ {
+		target.SendPacket(udpPacket(t, host, host+1, false), nil)
+		target.SendPacket(udpPacket(t, host, host+1, true), nil)
+	}
+
+	// the packets are processed asynchronously by the tables
+	var flows []*flow.Flow
+	for i := 0; i < 50; i++ {
+		if flows = queryFlows(t, fta); len(flows) == hosts {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if len(flows) != hosts {
+		t.Fatalf("Expected %d flows from the merged tables, got %d", hosts, len(flows))
+	}
+
+	uuids := make(map[string]bool)
+	for _, f := range flows {
+		if uuids[f.UUID] {
+			t.Fatalf("Flow %s returned by several tables", f.UUID)
+		}
+		uuids[f.UUID] = true
+
+		if f.Metric.ABPackets != 1 || f.Metric.BAPackets != 1 {
+			t.Errorf("Expected both directions of flow %s in the same table, got %+v", f.UUID, f.Metric)
+		}
+	}
+}
+
+func TestLocalTargetShardsDrain(t *testing.T) {
+	target, fta, sender := newShardedTarget(t, 4)
+
+	const hosts = 200
+	for host := 1; host <= hosts; host++ {
+		target.SendPacket(udpPacket(t, host, host+1, false), nil)
+	}
+
+	// the queued packets are processed before the tables expire their
+	// flows on stop
+	target.Stop()
+
+	sender.Lock()
+	defer sender.Unlock()
+
+	if len(sender.flows) != hosts {
+		t.Errorf("Expected %d flows to be sent on stop, got %d", hosts, len(sender.flows))
+	}
+
+	if flows := queryFlows(t, fta); len(flows) != 0 {
+		t.Errorf("Expected the tables to be released, got %d flows", len(flows))
+	}
+}
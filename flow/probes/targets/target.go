@@ -19,6 +19,7 @@ package targets
 
 import (
 	"errors"
+	"sync"
 
 	"github.com/google/gopacket"
 	"github.com/skydive-project/skydive/api/types"
@@ -38,6 +39,20 @@ type Target interface {
 	Stop()
 }
 
+// lockedTarget serializes the packets sent to a target, for the captures
+// using several workers
+type lockedTarget struct {
+	sync.Mutex
+	Target
+}
+
+// SendPacket implements the Target interface
+func (t *lockedTarget) SendPacket(packet gopacket.Packet, bpf *flow.BPF) {
+	t.Lock()
+	t.Target.SendPacket(packet, bpf)
+	t.Unlock()
+}
+
 func tableOptsFromCapture(capture *types.Capture) flow.TableOpts {
 	layerKeyMode, _ := flow.LayerKeyModeByName(capture.LayerKeyMode)
 
@@ -53,14 +68,30 @@ func tableOptsFromCapture(capture *types.Capture) flow.TableOpts {
 
 // NewTarget returns target according to the given type
 func NewTarget(typ string, g *graph.Graph, n *graph.Node, capture *types.Capture, uuids flow.UUIDs, bpf *flow.BPF, fta *flow.TableAllocator) (Target, error) {
+	var target Target
+	var err error
+
 	switch typ {
 	case "netflowv5":
-		return NewNetFlowV5Target(g, n, capture, uuids)
+		target, err = NewNetFlowV5Target(g, n, capture, uuids)
+	case "ipfix":
+		target, err = NewIPFIXTarget(g, n, capture, uuids)
 	case "erspanv1":
-		return NewERSpanTarget(g, n, capture)
+		target, err = NewERSpanTarget(g, n, capture)
 	case "", "local":
+		// the local target shards its tables, packets can be sent concurrently
 		return NewLocalTarget(g, n, capture, uuids, fta)
+	default:
+		return nil, ErrTargetTypeUnknown
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if capture.Workers > 1 {
+		target = &lockedTarget{Target: target}
 	}
 
-	return nil, ErrTargetTypeUnknown
+	return target, nil
 }
@@ -0,0 +1,392 @@
+// +build linux
+
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package probes
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/newtools/ebpf"
+	"github.com/newtools/ebpf/asm"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/bpf"
+)
+
+const (
+	// map type of the AF_XDP sockets, not known by the ebpf library
+	xskMapType = ebpf.MapType(17)
+
+	xdpFlagsUpdateIfNoExist = 1 << 0
+	xdpFlagsSKBMode         = 1 << 1
+	xdpFlagsDrvMode         = 1 << 2
+
+	xdpPass = 2
+
+	// offsets of the fields of the xdp_md context
+	xdpMdData         = 0
+	xdpMdDataEnd      = 4
+	xdpMdRxQueueIndex = 16
+
+	// number of the scratch memory slots of the classic BPF programs
+	bpfScratchSlots = 16
+)
+
+// registers used by the translated classic BPF programs
+const (
+	regCtx     = asm.R6
+	regData    = asm.R7
+	regDataEnd = asm.R8
+	regA       = asm.R3
+	regX       = asm.R4
+	regTmp     = asm.R5
+	regTmp2    = asm.R1
+)
+
+var bpfALUOps = map[bpf.ALUOp]asm.ALUOp{
+	bpf.ALUOpAdd:        asm.Add,
+	bpf.ALUOpSub:        asm.Sub,
+	bpf.ALUOpMul:        asm.Mul,
+	bpf.ALUOpDiv:        asm.Div,
+	bpf.ALUOpOr:         asm.Or,
+	bpf.ALUOpAnd:        asm.And,
+	bpf.ALUOpShiftLeft:  asm.LSh,
+	bpf.ALUOpShiftRight: asm.RSh,
+	bpf.ALUOpMod:        asm.Mod,
+	bpf.ALUOpXor:        asm.Xor,
+}
+
+// xdpProgram describes the XDP program redirecting the packets of an
+// interface to the AF_XDP sockets of its receive queues
+type xdpProgram struct {
+	sync.Mutex
+	link  netlink.Link
+	flags int
+	xsks  *ebpf.Map
+	prog  *ebpf.Program
+	refs  int
+}
+
+func bpfLabel(i int) string {
+	return fmt.Sprintf("bpf%d", i)
+}
+
+func bpfSize(size int) (asm.Size, error) {
+	switch size {
+	case 1:
+		return asm.Byte, nil
+	case 2:
+		return asm.Half, nil
+	case 4:
+		return asm.Word, nil
+	}
+	return 0, fmt.Errorf("Invalid load size %d", size)
+}
+
+func bpfRegister(reg bpf.Register) asm.Register {
+	if reg == bpf.RegX {
+		return regX
+	}
+	return regA
+}
+
+func bpfScratchOffset(n int) int16 {
+	return int16(-4 * (n + 1))
+}
+
+// loadPacket returns the instructions loading size bytes in network order at
+// base + off into A, the program returning no match when the packet is too
+// short as classic BPF does
+func loadPacket(base asm.Register, off uint32, size int) (asm.Instructions, error) {
+	sz, err := bpfSize(size)
+	if err != nil {
+		return nil, err
+	}
+	if off+uint32(size) > 0x7fff {
+		return nil, fmt.Errorf("Packet offset %d out of range", off)
+	}
+
+	insns := asm.Instructions{
+		asm.Mov.Reg(regTmp2, base),
+		asm.Add.Imm(regTmp2, int32(off)+int32(size)),
+		asm.JGT.Reg(regTmp2, regDataEnd, "nomatch"),
+		asm.LoadMem(regA, base, int16(off), sz),
+	}
+	if size > 1 {
+		insns = append(insns, asm.HostTo(binary.BigEndian, regA, sz))
+	}
+	return insns, nil
+}
+
+// translateBPF translates a classic BPF program into eBPF instructions
+// jumping to the "match" label when the packet is accepted and to the
+// "nomatch" label otherwise
+func translateBPF(filter []bpf.Instruction) (asm.Instructions, error) {
+	insns := asm.Instructions{
+		asm.Mov.Imm(regA, 0),
+		asm.Mov.Imm(regX, 0),
+	}
+
+	// the verifier refuses reads from uninitialized stack
+	for i := 0; i < bpfScratchSlots; i++ {
+		insns = append(insns, asm.StoreImm(asm.RFP, bpfScratchOffset(i), 0, asm.Word))
+	}
+
+	for i, ins := range filter {
+		jumpTo := func(skip uint8) string {
+			return bpfLabel(i + 1 + int(skip))
+		}
+
+		var block asm.Instructions
+		switch ins := ins.(type) {
+		case bpf.LoadAbsolute:
+			b, err := loadPacket(regData, ins.Off, ins.Size)
+			if err != nil {
+				return nil, err
+			}
+			block = b
+		case bpf.LoadIndirect:
+			// bound X so that the verifier accepts the variable offset
+			block = asm.Instructions{
+				asm.JGT.Imm(regX, 0xffff, "nomatch"),
+				asm.Mov.Reg(regTmp, regData),
+				asm.Add.Reg(regTmp, regX),
+			}
+			b, err := loadPacket(regTmp, ins.Off, ins.Size)
+			if err != nil {
+				return nil, err
+			}
+			block = append(block, b...)
+		case bpf.LoadMemShift:
+			b, err := loadPacket(regData, ins.Off, 1)
+			if err != nil {
+				return nil, err
+			}
+			block = append(b,
+				asm.Mov.Reg32(regX, regA),
+				asm.And.Imm32(regX, 0xf),
+				asm.LSh.Imm32(regX, 2),
+			)
+		case bpf.LoadConstant:
+			block = asm.Instructions{asm.Mov.Imm32(bpfRegister(ins.Dst), int32(ins.Val))}
+		case bpf.LoadScratch:
+			block = asm.Instructions{asm.LoadMem(bpfRegister(ins.Dst), asm.RFP, bpfScratchOffset(ins.N), asm.Word)}
+		case bpf.StoreScratch:
+			block = asm.Instructions{asm.StoreMem(asm.RFP, bpfScratchOffset(ins.N), bpfRegister(ins.Src), asm.Word)}
+		case bpf.ALUOpConstant:
+			op, ok := bpfALUOps[ins.Op]
+			if !ok {
+				return nil, fmt.Errorf("Unsupported ALU operation %v", ins.Op)
+			}
+			block = asm.Instructions{op.Imm32(regA, int32(ins.Val))}
+		case bpf.ALUOpX:
+			op, ok := bpfALUOps[ins.Op]
+			if !ok {
+				return nil, fmt.Errorf("Unsupported ALU operation %v", ins.Op)
+			}
+			block = asm.Instructions{op.Reg32(regA, regX)}
+		case bpf.NegateA:
+			block = asm.Instructions{asm.Neg.Imm32(regA, 0)}
+		case bpf.Jump:
+			block = asm.Instructions{asm.Ja.Label(bpfLabel(i + 1 + int(ins.Skip)))}
+		case bpf.JumpIf:
+			// compare with a zero extended register as the immediate
+			// values are sign extended
+			block = asm.Instructions{asm.LoadImm(regTmp, int64(ins.Val), asm.DWord)}
+			b, err := jumpIf(ins.Cond, regTmp, jumpTo(ins.SkipTrue), jumpTo(ins.SkipFalse))
+			if err != nil {
+				return nil, err
+			}
+			block = append(block, b...)
+		case bpf.JumpIfX:
+			b, err := jumpIf(ins.Cond, regX, jumpTo(ins.SkipTrue), jumpTo(ins.SkipFalse))
+			if err != nil {
+				return nil, err
+			}
+			block = b
+		case bpf.TAX:
+			block = asm.Instructions{asm.Mov.Reg32(regX, regA)}
+		case bpf.TXA:
+			block = asm.Instructions{asm.Mov.Reg32(regA, regX)}
+		case bpf.RetA:
+			block = asm.Instructions{
+				asm.JEq.Imm(regA, 0, "nomatch"),
+				asm.Ja.Label("match"),
+			}
+		case bpf.RetConstant:
+			if ins.Val == 0 {
+				block = asm.Instructions{asm.Ja.Label("nomatch")}
+			} else {
+				block = asm.Instructions{asm.Ja.Label("match")}
+			}
+		default:
+			return nil, fmt.Errorf("Unsupported BPF instruction %v", ins)
+		}
+
+		block[0] = block[0].Sym(bpfLabel(i))
+		insns = append(insns, block...)
+	}
+
+	return insns, nil
+}
+
+func jumpIf(cond bpf.JumpTest, src asm.Register, trueLabel, falseLabel string) (asm.Instructions, error) {
+	var op asm.JumpOp
+	switch cond {
+	case bpf.JumpEqual:
+		op = asm.JEq
+	case bpf.JumpNotEqual:
+		op = asm.JNE
+	case bpf.JumpGreaterThan:
+		op = asm.JGT
+	case bpf.JumpLessThan:
+		op = asm.JLT
+	case bpf.JumpGreaterOrEqual:
+		op = asm.JGE
+	case bpf.JumpLessOrEqual:
+		op = asm.JLE
+	case bpf.JumpBitsSet:
+		op = asm.JSet
+	case bpf.JumpBitsNotSet:
+		op = asm.JSet
+		trueLabel, falseLabel = falseLabel, trueLabel
+	default:
+		return nil, fmt.Errorf("Unsupported jump condition %v", cond)
+	}
+
+	return asm.Instructions{
+		op.Reg(regA, src, trueLabel),
+		asm.Ja.Label(falseLabel),
+	}, nil
+}
+
+// xdpInstructions returns the XDP program redirecting the packets matching
+// the filter to the socket of their receive queue, the other packets being
+// passed to the kernel network stack
+func xdpInstructions(xsks *ebpf.Map, filter []bpf.Instruction) (asm.Instructions, error) {
+	insns := asm.Instructions{
+		asm.Mov.Reg(regCtx, asm.R1),
+		asm.LoadMem(regData, regCtx, xdpMdData, asm.Word),
+		asm.LoadMem(regDataEnd, regCtx, xdpMdDataEnd, asm.Word),
+	}
+
+	if len(filter) > 0 {
+		prefilter, err := translateBPF(filter)
+		if err != nil {
+			return nil, err
+		}
+		insns = append(insns, prefilter...)
+	}
+
+	return append(insns,
+		asm.LoadMem(asm.R2, regCtx, xdpMdRxQueueIndex, asm.Word).Sym("match"),
+		asm.LoadMapPtr(asm.R1, xsks.FD()),
+		// recent kernels pass the packet to the stack when there is no
+		// socket for the queue, older ones drop it
+		asm.Mov.Imm(asm.R3, xdpPass),
+		asm.FnRedirectMap.Call(),
+		asm.Return(),
+		asm.Mov.Imm(asm.R0, xdpPass).Sym("nomatch"),
+		asm.Return(),
+	), nil
+}
+
+// newXDPProgram loads and attaches to the interface the XDP program
+// redirecting the packets to the AF_XDP sockets, in native mode when the
+// driver supports it or in generic mode otherwise. The program is referenced
+// once by its creator.
+func newXDPProgram(link netlink.Link, queues int, filter []bpf.Instruction) (*xdpProgram, error) {
+	xsks, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       xskMapType,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: uint32(queues),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create AF_XDP sockets map: %s", err)
+	}
+
+	insns, err := xdpInstructions(xsks, filter)
+	if err != nil {
+		xsks.Close()
+		return nil, err
+	}
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:         ebpf.XDP,
+		License:      "GPL",
+		Instructions: insns,
+	})
+	if err != nil {
+		xsks.Close()
+		return nil, fmt.Errorf("Unable to load XDP program: %s", err)
+	}
+
+	flags := xdpFlagsUpdateIfNoExist | xdpFlagsDrvMode
+	if err = netlink.LinkSetXdpFdWithFlags(link, prog.FD(), flags); err != nil {
+		flags = xdpFlagsUpdateIfNoExist | xdpFlagsSKBMode
+		if err = netlink.LinkSetXdpFdWithFlags(link, prog.FD(), flags); err != nil {
+			prog.Close()
+			xsks.Close()
+			return nil, fmt.Errorf("Unable to attach XDP program to %s: %s", link.Attrs().Name, err)
+		}
+	}
+
+	return &xdpProgram{
+		link:  link,
+		flags: flags,
+		xsks:  xsks,
+		prog:  prog,
+		refs:  1,
+	}, nil
+}
+
+// generic returns whether the program runs in generic mode, only copy mode
+// being then available for the sockets
+func (x *xdpProgram) generic() bool {
+	return x.flags&xdpFlagsSKBMode != 0
+}
+
+// register redirects the packets of a receive queue to a socket
+func (x *xdpProgram) register(queue int, fd int) error {
+	value := uint32(fd)
+	return x.xsks.Put(uint32(queue), &value)
+}
+
+func (x *xdpProgram) ref() {
+	x.Lock()
+	x.refs++
+	x.Unlock()
+}
+
+// release detaches the program from the interface once released by all the
+// sockets
+func (x *xdpProgram) release() {
+	x.Lock()
+	defer x.Unlock()
+
+	if x.refs--; x.refs > 0 {
+		return
+	}
+
+	netlink.LinkSetXdpFdWithFlags(x.link, -1, x.flags&^xdpFlagsUpdateIfNoExist)
+	x.prog.Close()
+	x.xsks.Close()
+}
@@ -0,0 +1,189 @@
+// +build linux
+
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package probes
+
+import (
+	"testing"
+
+	"github.com/newtools/ebpf/asm"
+	"golang.org/x/net/bpf"
+)
+
+// checkLabels checks that each classic instruction starts a block labelled
+// after its index and that the program only jumps forward, to one of these
+// blocks or to the match and nomatch labels
+func checkLabels(t *testing.T, filter []bpf.Instruction, insns asm.Instructions) {
+	symbols := make(map[string]int)
+	for i, ins := range insns {
+		if ins.Symbol == "" {
+			continue
+		}
+		if _, found := symbols[ins.Symbol]; found {
+			t.Errorf("Label %s defined twice", ins.Symbol)
+		}
+		symbols[ins.Symbol] = i
+	}
+
+	prev := 0
+	for i := range filter {
+		offset, found := symbols[bpfLabel(i)]
+		if !found {
+			t.Fatalf("No block for instruction %d", i)
+		}
+		if offset <= prev {
+			t.Errorf("Block of instruction %d is not after the previous one", i)
+		}
+		prev = offset
+	}
+
+	for i, ins := range insns {
+		switch ins.Reference {
+		case "", "match", "nomatch":
+		default:
+			offset, found := symbols[ins.Reference]
+			if !found {
+				t.Errorf("Instruction %d jumps to unknown label %s", i, ins.Reference)
+			} else if offset <= i {
+				t.Errorf("Instruction %d jumps backward to %s", i, ins.Reference)
+			}
+		}
+	}
+}
+
+func TestTranslateBPF(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter []bpf.Instruction
+	}{
+		{
+			name: "ip proto tcp",
+			filter: []bpf.Instruction{
+				bpf.LoadAbsolute{Off: 12, Size: 2},
+				bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x800, SkipFalse: 3},
+				bpf.LoadAbsolute{Off: 23, Size: 1},
+				bpf.JumpIf{Cond: bpf.JumpEqual, Val: 6, SkipFalse: 1},
+				bpf.RetConstant{Val: 65535},
+				bpf.RetConstant{Val: 0},
+			},
+		},
+		{
+			name: "tcp dst port 80",
+			filter: []bpf.Instruction{
+				bpf.LoadAbsolute{Off: 12, Size: 2},
+				bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x800, SkipFalse: 4},
+				bpf.LoadMemShift{Off: 14},
+				bpf.LoadIndirect{Off: 16, Size: 2},
+				bpf.JumpIf{Cond: bpf.JumpEqual, Val: 80, SkipFalse: 1},
+				bpf.RetConstant{Val: 65535},
+				bpf.RetConstant{Val: 0},
+			},
+		},
+		{
+			name: "registers and scratch memory",
+			filter: []bpf.Instruction{
+				bpf.LoadConstant{Dst: bpf.RegA, Val: 1},
+				bpf.StoreScratch{Src: bpf.RegA, N: 3},
+				bpf.LoadScratch{Dst: bpf.RegX, N: 3},
+				bpf.ALUOpX{Op: bpf.ALUOpAdd},
+				bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 3},
+				bpf.NegateA{},
+				bpf.TAX{},
+				bpf.TXA{},
+				bpf.Jump{Skip: 0},
+				bpf.JumpIf{Cond: bpf.JumpBitsNotSet, Val: 1, SkipTrue: 1},
+				bpf.JumpIfX{Cond: bpf.JumpGreaterThan, SkipFalse: 1},
+				bpf.RetA{},
+				bpf.RetConstant{Val: 0},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			insns, err := translateBPF(test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// registers and scratch memory are initialized first
+			if first := 2 + bpfScratchSlots; len(insns) <= first || insns[first].Symbol != bpfLabel(0) {
+				t.Errorf("Expected the first instruction to start after %d initialization instructions", first)
+			}
+
+			checkLabels(t, test.filter, insns)
+		})
+	}
+}
+
+func TestTranslateBPFErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		ins  bpf.Instruction
+	}{
+		{name: "extension", ins: bpf.LoadExtension{Num: bpf.ExtLen}},
+		{name: "load size", ins: bpf.LoadAbsolute{Off: 12, Size: 3}},
+		{name: "load offset", ins: bpf.LoadAbsolute{Off: 0x7fff, Size: 2}},
+		{name: "alu operation", ins: bpf.ALUOpConstant{Op: bpf.ALUOp(0xf0), Val: 1}},
+		{name: "jump condition", ins: bpf.JumpIf{Cond: bpf.JumpTest(42), Val: 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := []bpf.Instruction{test.ins, bpf.RetConstant{Val: 65535}}
+			if _, err := translateBPF(filter); err == nil {
+				t.Errorf("Expected %v to be refused", test.ins)
+			}
+		})
+	}
+}
+
+func TestLoadPacket(t *testing.T) {
+	for size, length := range map[int]int{1: 4, 2: 5, 4: 5} {
+		insns, err := loadPacket(regData, 14, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(insns) != length {
+			t.Errorf("Expected %d instructions to load %d bytes, got %d", length, size, len(insns))
+		}
+		// short packets don't match
+		if insns[2].Reference != "nomatch" {
+			t.Errorf("Expected the bounds check to jump to nomatch, got %s", insns[2].Reference)
+		}
+	}
+}
+
+func TestJumpIf(t *testing.T) {
+	insns, err := jumpIf(bpf.JumpBitsSet, regX, "true", "false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if insns[0].Reference != "true" || insns[1].Reference != "false" {
+		t.Errorf("Expected jumps to true then false, got %s then %s", insns[0].Reference, insns[1].Reference)
+	}
+
+	// there is no eBPF jump when bits are not set, the labels are swapped
+	if insns, err = jumpIf(bpf.JumpBitsNotSet, regX, "true", "false"); err != nil {
+		t.Fatal(err)
+	}
+	if insns[0].Reference != "false" || insns[1].Reference != "true" {
+		t.Errorf("Expected jumps to false then true, got %s then %s", insns[0].Reference, insns[1].Reference)
+	}
+}
@@ -0,0 +1,355 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package flow
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+const (
+	// number of segments a packet ring is split into, the oldest segment
+	// being dropped when a bound of the ring is reached
+	segmentsPerRing      = 16
+	minRingSegmentSize   = 64 * 1024
+	ringRecordHeaderSize = 4
+	ringSegmentSuffix    = ".ring"
+)
+
+// PacketRingOpts defines the bounds and the storage of a packet ring
+type PacketRingOpts struct {
+	// maximum size in bytes of the packets, 0 for no size bound
+	Size int64
+	// maximum age of the packets relative to the last one, 0 for no age bound
+	Duration time.Duration
+	// directory of the segment files, the packets are kept in memory if empty
+	Path string
+}
+
+// PacketRingQuery describes the packets to dump from the rings of a capture
+type PacketRingQuery struct {
+	CaptureID string
+	// time range in milliseconds, 0 meaning unbounded
+	From      int64
+	To        int64
+	BPFFilter string
+}
+
+// PacketRingReply holds the packets dumped from the rings of a capture
+type PacketRingReply struct {
+	RawPackets []*RawPacket
+}
+
+type ringSegment struct {
+	id uint64
+	// timestamps of the first and the last packets
+	first int64
+	last  int64
+	size  int64
+	// packets of the in memory rings
+	packets []*RawPacket
+	// file of the on disk rings
+	path string
+}
+
+// PacketRing keeps the last packets of a capture node, whole, in memory or in
+// segment files. The oldest segment is dropped when the size or the age
+// bound of the ring is reached.
+type PacketRing struct {
+	sync.Mutex
+	uuids           UUIDs
+	opts            PacketRingOpts
+	segmentSize     int64
+	segmentDuration int64
+	segments        []*ringSegment
+	file            *os.File
+	writer          *bufio.Writer
+	size            int64
+}
+
+// UUIDs returns the node and the capture of the ring
+func (r *PacketRing) UUIDs() UUIDs {
+	return r.uuids
+}
+
+func (r *PacketRing) closeWriter() {
+	if r.file != nil {
+		r.writer.Flush()
+		r.file.Close()
+		r.file, r.writer = nil, nil
+	}
+}
+
+func (r *PacketRing) newSegment(timestamp int64) error {
+	var id uint64
+	if len(r.segments) > 0 {
+		id = r.segments[len(r.segments)-1].id + 1
+	}
+
+	seg := &ringSegment{id: id, first: timestamp}
+
+	if r.opts.Path != "" {
+		r.closeWriter()
+
+		seg.path = filepath.Join(r.opts.Path, fmt.Sprintf("%016d%s", id, ringSegmentSuffix))
+		file, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		r.file, r.writer = file, bufio.NewWriter(file)
+	}
+
+	r.segments = append(r.segments, seg)
+	return nil
+}
+
+func (r *PacketRing) removeOldestSegment() {
+	seg := r.segments[0]
+	r.segments = r.segments[1:]
+
+	r.size -= seg.size
+	if seg.path != "" {
+		os.Remove(seg.path)
+	}
+}
+
+// Add appends a packet to the ring, dropping the oldest segments if needed
+func (r *PacketRing) Add(packet *RawPacket) error {
+	r.Lock()
+	defer r.Unlock()
+
+	size := int64(ringRecordHeaderSize + packet.ProtoSize())
+
+	if len(r.segments) == 0 {
+		if err := r.newSegment(packet.Timestamp); err != nil {
+			return err
+		}
+	} else {
+		seg := r.segments[len(r.segments)-1]
+		if (r.segmentSize > 0 && seg.size+size > r.segmentSize) ||
+			(r.segmentDuration > 0 && packet.Timestamp-seg.first >= r.segmentDuration) {
+			if err := r.newSegment(packet.Timestamp); err != nil {
+				return err
+			}
+		}
+	}
+
+	seg := r.segments[len(r.segments)-1]
+	if r.writer != nil {
+		data, err := packet.Marshal()
+		if err != nil {
+			return err
+		}
+
+		var header [ringRecordHeaderSize]byte
+		binary.BigEndian.PutUint32(header[:], uint32(len(data)))
+		if _, err := r.writer.Write(append(header[:], data...)); err != nil {
+			return err
+		}
+	} else {
+		seg.packets = append(seg.packets, packet)
+	}
+
+	seg.size += size
+	seg.last = packet.Timestamp
+	r.size += size
+
+	for len(r.segments) > 1 {
+		oldest := r.segments[0]
+		if (r.opts.Size == 0 || r.size <= r.opts.Size) &&
+			(r.opts.Duration == 0 || oldest.last >= packet.Timestamp-int64(r.opts.Duration/time.Millisecond)) {
+			break
+		}
+		r.removeOldestSegment()
+	}
+
+	return nil
+}
+
+// AddGoPacket appends a gopacket to the ring
+func (r *PacketRing) AddGoPacket(packet gopacket.Packet) error {
+	linkType := layers.LinkTypeEthernet
+	if l := packet.Layers(); len(l) > 0 {
+		switch l[0].LayerType() {
+		case layers.LayerTypeIPv4:
+			linkType = layers.LinkTypeIPv4
+		case layers.LayerTypeIPv6:
+			linkType = layers.LinkTypeIPv6
+		}
+	}
+
+	return r.Add(&RawPacket{
+		Timestamp: common.UnixMillis(packet.Metadata().CaptureInfo.Timestamp),
+		Data:      packet.Data(),
+		LinkType:  linkType,
+	})
+}
+
+// readSegment calls fn for each packet of a segment file, up to the given size
+func readSegment(file *os.File, size int64, fn func(packet *RawPacket) error) error {
+	defer file.Close()
+
+	br := bufio.NewReader(io.LimitReader(file, size))
+	for {
+		var header [ringRecordHeaderSize]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		data := make([]byte, binary.BigEndian.Uint32(header[:]))
+		if _, err := io.ReadFull(br, data); err != nil {
+			return err
+		}
+
+		packet := &RawPacket{}
+		if err := packet.Unmarshal(data); err != nil {
+			return err
+		}
+
+		if err := fn(packet); err != nil {
+			return err
+		}
+	}
+}
+
+// Dump calls fn for each packet of the ring within the given time range in
+// milliseconds, from the oldest to the newest. A zero bound means unbounded.
+func (r *PacketRing) Dump(from, to int64, fn func(packet *RawPacket) error) error {
+	type dumpSegment struct {
+		packets []*RawPacket
+		file    *os.File
+		size    int64
+	}
+
+	// the segments to read are selected, and their files opened, while
+	// locked so that they survive the drop of the segments while read
+	var segments []dumpSegment
+
+	r.Lock()
+	if r.writer != nil {
+		r.writer.Flush()
+	}
+
+	var err error
+	for _, seg := range r.segments {
+		if seg.last < from || (to != 0 && seg.first > to) {
+			continue
+		}
+
+		ds := dumpSegment{packets: seg.packets, size: seg.size}
+		if seg.path != "" {
+			if ds.file, err = os.Open(seg.path); err != nil {
+				break
+			}
+		}
+		segments = append(segments, ds)
+	}
+	r.Unlock()
+
+	filter := func(packet *RawPacket) error {
+		if packet.Timestamp < from || (to != 0 && packet.Timestamp > to) {
+			return nil
+		}
+		return fn(packet)
+	}
+
+	for _, ds := range segments {
+		if err != nil {
+			if ds.file != nil {
+				ds.file.Close()
+			}
+			continue
+		}
+
+		if ds.file != nil {
+			err = readSegment(ds.file, ds.size, filter)
+			continue
+		}
+
+		for _, packet := range ds.packets {
+			if err = filter(packet); err != nil {
+				break
+			}
+		}
+	}
+
+	return err
+}
+
+// Close removes all the packets of the ring
+func (r *PacketRing) Close() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.closeWriter()
+	for len(r.segments) > 0 {
+		r.removeOldestSegment()
+	}
+
+	if r.opts.Path != "" {
+		os.RemoveAll(r.opts.Path)
+	}
+}
+
+// NewPacketRing returns a new packet ring. The files of a previous ring
+// stored in the same directory are removed.
+func NewPacketRing(uuids UUIDs, opts PacketRingOpts) (*PacketRing, error) {
+	r := &PacketRing{
+		uuids: uuids,
+		opts:  opts,
+	}
+
+	if opts.Size > 0 {
+		r.segmentSize = opts.Size / segmentsPerRing
+		if r.segmentSize < minRingSegmentSize {
+			r.segmentSize = minRingSegmentSize
+		}
+	}
+
+	if opts.Duration > 0 {
+		r.segmentDuration = int64(opts.Duration/time.Millisecond) / segmentsPerRing
+		if r.segmentDuration == 0 {
+			r.segmentDuration = 1
+		}
+	}
+
+	if opts.Path != "" {
+		if err := os.RemoveAll(opts.Path); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(opts.Path, 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package flow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func dumpRing(t *testing.T, r *PacketRing, from, to int64) []int64 {
+	var timestamps []int64
+	if err := r.Dump(from, to, func(packet *RawPacket) error {
+		timestamps = append(timestamps, packet.Timestamp)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return timestamps
+}
+
+func testPacketRing(t *testing.T, path string) {
+	// 10 packets per second during 60 seconds, the ring keeping 30 seconds
+	r, err := NewPacketRing(UUIDs{CaptureID: "capture"}, PacketRingOpts{Duration: 30 * time.Second, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	data := make([]byte, 100)
+	for ts := int64(0); ts < 60000; ts += 100 {
+		if err := r.Add(&RawPacket{Timestamp: ts, Data: data, LinkType: layers.LinkTypeEthernet}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	timestamps := dumpRing(t, r, 0, 0)
+	if len(timestamps) == 0 || timestamps[len(timestamps)-1] != 59900 {
+		t.Fatalf("Last packet should be kept, got %v", timestamps)
+	}
+
+	// the oldest segment may be older than the duration of the ring
+	if first := timestamps[0]; first > 59900-30000 || first < 59900-30000-30000/segmentsPerRing {
+		t.Errorf("Packets older than the duration of the ring should be dropped, first packet: %d", first)
+	}
+
+	for i := 1; i < len(timestamps); i++ {
+		if timestamps[i] <= timestamps[i-1] {
+			t.Fatalf("Packets should be sorted, got %v", timestamps)
+		}
+	}
+
+	if timestamps = dumpRing(t, r, 50000, 50900); len(timestamps) != 10 || timestamps[0] != 50000 {
+		t.Errorf("Expected 10 packets from 50000, got %v", timestamps)
+	}
+}
+
+func TestPacketRingMemory(t *testing.T) {
+	testPacketRing(t, "")
+}
+
+func TestPacketRingDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skydive-ring-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ring")
+	testPacketRing(t, path)
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Ring files should be removed on close")
+	}
+}
+
+func TestPacketRingSize(t *testing.T) {
+	r, err := NewPacketRing(UUIDs{}, PacketRingOpts{Size: 1024 * 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	data := make([]byte, 1000)
+	for ts := int64(0); ts < 5000; ts++ {
+		if err := r.Add(&RawPacket{Timestamp: ts, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if r.size > r.opts.Size {
+		t.Errorf("Ring size %d should be bounded by %d", r.size, r.opts.Size)
+	}
+
+	if timestamps := dumpRing(t, r, 0, 0); timestamps[len(timestamps)-1] != 4999 {
+		t.Errorf("Last packet should be kept")
+	}
+}
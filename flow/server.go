@@ -18,6 +18,9 @@
 package flow
 
 import (
+	"encoding/json"
+	"net/http"
+
 	"github.com/golang/protobuf/proto"
 	"github.com/skydive-project/skydive/logging"
 	ws "github.com/skydive-project/skydive/websocket"
@@ -46,11 +49,31 @@ func (s *WSTableServer) OnTableQuery(c ws.Speaker, msg *ws.StructMessage) {
 	c.SendMessage(reply)
 }
 
+// OnPacketRingQuery event
+func (s *WSTableServer) OnPacketRingQuery(c ws.Speaker, msg *ws.StructMessage) {
+	var query PacketRingQuery
+	if err := json.Unmarshal(msg.Obj, &query); err != nil {
+		logging.GetLogger().Errorf("Unable to decode packet ring query message %v", msg)
+		return
+	}
+
+	reply, err := s.TableAllocator.QueryPacketRings(&query)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to dump the packet rings of capture %s: %s", query.CaptureID, err)
+		c.SendMessage(msg.Reply(&PacketRingReply{}, "PacketRingReply", http.StatusInternalServerError))
+		return
+	}
+
+	c.SendMessage(msg.Reply(reply, "PacketRingReply", http.StatusOK))
+}
+
 // OnStructMessage TableQuery
 func (s *WSTableServer) OnStructMessage(c ws.Speaker, msg *ws.StructMessage) {
 	switch msg.Type {
 	case "TableQuery":
 		s.OnTableQuery(c, msg)
+	case "PacketRingQuery":
+		s.OnPacketRingQuery(c, msg)
 	}
 }
 
@@ -18,18 +18,21 @@
 package server
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/flow"
 	"github.com/skydive-project/skydive/flow/storage"
+	"github.com/skydive-project/skydive/flow/transport"
 	"github.com/skydive-project/skydive/graffiti/graph"
 	shttp "github.com/skydive-project/skydive/http"
 	"github.com/skydive-project/skydive/logging"
@@ -58,25 +61,67 @@ func max(a, b int) int {
 // FlowServerConn describes a flow server connection
 type FlowServerConn interface {
 	Serve(ch chan *flow.Flow, quit chan struct{}, wg *sync.WaitGroup)
+	DroppedFlows() int64
 }
 
-// FlowServerUDPConn describes a UDP flow server connection
-type FlowServerUDPConn struct {
-	conn                   *net.UDPConn
+// Stats describes the flows received by a flow server
+type Stats struct {
+	// number of flows handed over to the storage
+	ReceivedFlows int64
+	// number of flows dropped as the buffer was full
+	DroppedFlows int64
+	// statistics of the acknowledged connections of the agents
+	Transport *transport.ReceiverStats `json:",omitempty"`
+}
+
+// lostFlows counts the flows dropped as the buffer is full
+type lostFlows struct {
 	timeOfLastLostFlowsLog time.Time
 	numOfLostFlows         int
-	maxFlowBufferSize      int
+	dropped                int64
+}
+
+func (l *lostFlows) drop(count int) {
+	atomic.AddInt64(&l.dropped, int64(count))
+
+	l.numOfLostFlows += count
+	if l.timeOfLastLostFlowsLog.IsZero() ||
+		(time.Now().Sub(l.timeOfLastLostFlowsLog) >= time.Second) {
+		logging.GetLogger().Errorf("Buffer overflow - too many flow updates, removing and not storing flows: %d", l.numOfLostFlows)
+		l.timeOfLastLostFlowsLog = time.Now()
+		l.numOfLostFlows = 0
+	}
+}
+
+// DroppedFlows returns the number of flows dropped as the buffer was full
+func (l *lostFlows) DroppedFlows() int64 {
+	return atomic.LoadInt64(&l.dropped)
+}
+
+// FlowServerUDPConn describes a UDP flow server connection
+type FlowServerUDPConn struct {
+	lostFlows
+	conn              *net.UDPConn
+	maxFlowBufferSize int
+}
+
+// FlowServerTCPConn describes an acknowledged TCP flow server connection.
+// Its flows are never dropped, the agents being slowed down instead when
+// the buffer is full.
+type FlowServerTCPConn struct {
+	receiver *transport.Receiver
+	ch       chan *flow.Flow
+	done     chan struct{}
 }
 
 // FlowServerWebSocketConn describes a WebSocket flow server connection
 type FlowServerWebSocketConn struct {
 	ws.DefaultSpeakerEventHandler
-	server                 *shttp.Server
-	ch                     chan *flow.Flow
-	timeOfLastLostFlowsLog time.Time
-	numOfLostFlows         int
-	maxFlowBufferSize      int
-	auth                   shttp.AuthenticationBackend
+	lostFlows
+	server            *shttp.Server
+	ch                chan *flow.Flow
+	maxFlowBufferSize int
+	auth              shttp.AuthenticationBackend
 }
 
 // FlowServer describes a flow server
@@ -91,6 +136,7 @@ type FlowServer struct {
 	quit               chan struct{}
 	auth               shttp.AuthenticationBackend
 	subscriberEndpoint *FlowSubscriberEndpoint
+	received           int64
 }
 
 // OnMessage event
@@ -107,15 +153,9 @@ func (c *FlowServerWebSocketConn) OnMessage(client ws.Speaker, m ws.Message) {
 	logging.GetLogger().Debugf("New flow message from Websocket connection: %+v", msg)
 
 	// TODO(safchain) handle mutliple type of message
-	for _, f := range msg.Flows {
+	for i, f := range msg.Flows {
 		if len(c.ch) >= c.maxFlowBufferSize {
-			c.numOfLostFlows++
-			if c.timeOfLastLostFlowsLog.IsZero() ||
-				(time.Now().Sub(c.timeOfLastLostFlowsLog) >= time.Second) {
-				logging.GetLogger().Errorf("Buffer overflow - too many flow updates, removing and not storing flows: %d", c.numOfLostFlows)
-				c.timeOfLastLostFlowsLog = time.Now()
-				c.numOfLostFlows = 0
-			}
+			c.drop(len(msg.Flows) - i)
 			return
 		}
 
@@ -171,16 +211,10 @@ func (c *FlowServerUDPConn) Serve(ch chan *flow.Flow, quit chan struct{}, wg *sy
 
 				logging.GetLogger().Debugf("New flow message from UDP connection: %+v", msg)
 
-				for _, f := range msg.Flows {
+				for i, f := range msg.Flows {
 					if len(ch) >= c.maxFlowBufferSize {
-						c.numOfLostFlows++
-						if c.timeOfLastLostFlowsLog.IsZero() ||
-							(time.Now().Sub(c.timeOfLastLostFlowsLog) >= time.Second) {
-							logging.GetLogger().Errorf("Buffer overflow - too many flow updates, removing and not storing flows: %d", c.numOfLostFlows)
-							c.timeOfLastLostFlowsLog = time.Now()
-							c.numOfLostFlows = 0
-						}
-						return
+						c.drop(len(msg.Flows) - i)
+						break
 					}
 					ch <- f
 				}
@@ -207,6 +241,73 @@ func NewFlowServerUDPConn(addr string, port int) (*FlowServerUDPConn, error) {
 	return &FlowServerUDPConn{conn: conn, maxFlowBufferSize: flowsMax}, err
 }
 
+// handleMessage pushes the flows of a message to the buffer, waiting for
+// the buffer to have some room. The message is acknowledged on return.
+func (c *FlowServerTCPConn) handleMessage(data []byte) error {
+	var msg flow.Message
+	if err := msg.Unmarshal(data); err != nil {
+		// acknowledged anyway, as it would fail again
+		logging.GetLogger().Errorf("Error while parsing flow: %s", err)
+		return nil
+	}
+
+	logging.GetLogger().Debugf("New flow message from TCP connection: %+v", msg)
+
+	for _, f := range msg.Flows {
+		select {
+		case c.ch <- f:
+		case <-c.done:
+			return errors.New("Flow server stopped")
+		}
+	}
+
+	return nil
+}
+
+// Serve acknowledged TCP connections
+func (c *FlowServerTCPConn) Serve(ch chan *flow.Flow, quit chan struct{}, wg *sync.WaitGroup) {
+	c.ch = ch
+	c.done = make(chan struct{})
+
+	go func() {
+		go c.receiver.Serve()
+		<-quit
+		close(c.done)
+		c.receiver.Stop()
+	}()
+}
+
+// DroppedFlows returns 0 as the flows received through TCP are never dropped
+func (c *FlowServerTCPConn) DroppedFlows() int64 {
+	return 0
+}
+
+// NewFlowServerTCPConn returns a new acknowledged TCP flow server, using TLS
+// if enabled, the agents having then to provide a certificate
+func NewFlowServerTCPConn(addr string, port int) (*FlowServerTCPConn, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", common.NormalizeAddrForURL(addr), port))
+	if err != nil {
+		return nil, err
+	}
+
+	if config.IsTLSEnabled() {
+		tlsConfig, err := config.GetTLSServerConfig(true)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	logging.GetLogger().Infof("Analyzer listen agents on TCP socket %s:%d", addr, port)
+
+	c := &FlowServerTCPConn{}
+	c.receiver = transport.NewReceiver(listener, config.GetInt("flow.tcp.credit"), c.handleMessage)
+	return c, nil
+}
+
 func (s *FlowServer) storeFlows(flows []*flow.Flow) {
 	if len(flows) > 0 {
 		if s.storage != nil {
@@ -244,6 +345,7 @@ func (s *FlowServer) Start() {
 				s.storeFlows(flows)
 				flows = flows[:0]
 			case f := <-s.ch:
+				atomic.AddInt64(&s.received, 1)
 				flows = append(flows, f)
 				if len(flows) >= s.bulkInsert {
 					s.storeFlows(flows)
@@ -263,6 +365,21 @@ func (s *FlowServer) Stop() {
 	}
 }
 
+// GetStats returns the statistics of the received flows
+func (s *FlowServer) GetStats() Stats {
+	stats := Stats{
+		ReceivedFlows: atomic.LoadInt64(&s.received),
+		DroppedFlows:  s.conn.DroppedFlows(),
+	}
+
+	if c, ok := s.conn.(*FlowServerTCPConn); ok {
+		transportStats := c.receiver.Stats()
+		stats.Transport = &transportStats
+	}
+
+	return stats
+}
+
 func (s *FlowServer) setupBulkConfigFromBackend() error {
 	s.bulkInsert = FlowBulkInsertDefault
 	s.bulkInsertDeadline = time.Duration(FlowBulkInsertDeadlineDefault) * time.Second
@@ -296,6 +413,8 @@ func NewFlowServer(s *shttp.Server, g *graph.Graph, store storage.Storage, endpo
 		conn, err = NewFlowServerUDPConn(s.Addr, s.Port)
 	case "websocket":
 		conn, err = NewFlowServerWebSocketConn(s, auth)
+	case "tcp":
+		conn, err = NewFlowServerTCPConn(s.Addr, config.GetInt("flow.tcp.port"))
 	default:
 		err = fmt.Errorf("Invalid protocol %s", protocol)
 	}
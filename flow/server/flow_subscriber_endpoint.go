@@ -24,11 +24,21 @@ import (
 	ws "github.com/skydive-project/skydive/websocket"
 )
 
+// CaptureAccess returns whether a subscriber has access to the flows of a capture
+type CaptureAccess func(c ws.Speaker, captureID string) bool
+
+// FlowListener is notified of the flows received by the analyzer
+type FlowListener interface {
+	OnFlows(flows []*flow.Flow)
+}
+
 // FlowSubscriberEndpoint sends all the flows to its subscribers.
 type FlowSubscriberEndpoint struct {
 	common.RWMutex
-	pool         ws.StructSpeakerPool
-	nsSubscriber map[string][]ws.Speaker
+	pool          ws.StructSpeakerPool
+	nsSubscriber  map[string][]ws.Speaker
+	captureAccess CaptureAccess
+	listeners     []FlowListener
 }
 
 const flowNS = "flow"
@@ -39,14 +49,62 @@ func (fs *FlowSubscriberEndpoint) sendFlows(ns string, flows []*flow.Flow) {
 	fs.RUnlock()
 
 	// at least one speaker for the flow namespace
-	if ok {
+	if !ok {
+		return
+	}
+
+	if fs.captureAccess == nil {
 		msg := ws.NewStructMessage(ns, "store", flows)
 		fs.pool.BroadcastMessage(msg)
+		return
+	}
+
+	for _, c := range fs.pool.GetSpeakers() {
+		access := make(map[string]bool)
+
+		var allowed []*flow.Flow
+		for _, f := range flows {
+			granted, found := access[f.CaptureID]
+			if !found {
+				granted = fs.captureAccess(c, f.CaptureID)
+				access[f.CaptureID] = granted
+			}
+
+			if granted {
+				allowed = append(allowed, f)
+			}
+		}
+
+		if len(allowed) > 0 {
+			c.SendMessage(ws.NewStructMessage(ns, "store", allowed))
+		}
 	}
 }
 
+// SetCaptureAccess sets the function used to restrict the flows sent
+// to each subscriber to the ones of the captures it has access to
+func (fs *FlowSubscriberEndpoint) SetCaptureAccess(captureAccess CaptureAccess) {
+	fs.captureAccess = captureAccess
+}
+
+// AddFlowListener registers a listener notified of all the flows sent
+// to the subscribers
+func (fs *FlowSubscriberEndpoint) AddFlowListener(l FlowListener) {
+	fs.Lock()
+	fs.listeners = append(fs.listeners, l)
+	fs.Unlock()
+}
+
 // SendFlows sends flow to the subscribers
 func (fs *FlowSubscriberEndpoint) SendFlows(flows []*flow.Flow) {
+	fs.RLock()
+	listeners := fs.listeners
+	fs.RUnlock()
+
+	for _, l := range listeners {
+		l.OnFlows(flows)
+	}
+
 	fs.sendFlows(flowNS, flows)
 	flowsByCaptureMap := make(map[string][]*flow.Flow)
 	for _, f := range flows {
@@ -0,0 +1,234 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package transport
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skydive-project/skydive/logging"
+)
+
+const (
+	// sessionTTL is the time after which the session of a sender that
+	// didn't reconnect is forgotten
+	sessionTTL       = time.Hour
+	handshakeTimeout = 10 * time.Second
+)
+
+// Handler is called with the flow message of each data frame. The frame is
+// acknowledged once the handler returns, an error closing the connection
+// without acknowledging it.
+type Handler func(data []byte) error
+
+// ReceiverStats describes the activity of a receiver
+type ReceiverStats struct {
+	// number of data frames handled
+	Received int64
+	// number of data frames received again after a reconnection
+	Duplicated int64
+	// number of connected senders
+	Senders int
+}
+
+type session struct {
+	sync.Mutex
+	lastSeq  uint64
+	lastSeen time.Time
+	conn     *frameConn
+}
+
+// Receiver accepts the connections of the senders and hands over the flow
+// messages they send
+type Receiver struct {
+	sync.RWMutex
+	listener   net.Listener
+	credit     int
+	handler    Handler
+	sessions   map[string]*session
+	wg         sync.WaitGroup
+	received   int64
+	duplicated int64
+}
+
+// getSession returns the session with the given ID, taking it over from a
+// previous connection
+func (r *Receiver) getSession(id string, fc *frameConn) *session {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	for id, s := range r.sessions {
+		if s.conn == nil && now.Sub(s.lastSeen) > sessionTTL {
+			delete(r.sessions, id)
+		}
+	}
+
+	s, found := r.sessions[id]
+	if !found {
+		s = &session{}
+		r.sessions[id] = s
+	}
+
+	if s.conn != nil {
+		s.conn.close()
+	}
+	s.conn, s.lastSeen = fc, now
+
+	return s
+}
+
+func (r *Receiver) releaseSession(s *session, fc *frameConn) {
+	r.Lock()
+	if s.conn == fc {
+		s.conn, s.lastSeen = nil, time.Now()
+	}
+	r.Unlock()
+}
+
+func (r *Receiver) serveConn(conn net.Conn) {
+	defer r.wg.Done()
+
+	fc := newFrameConn(conn)
+	defer fc.close()
+
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	typ, payload, err := fc.readFrame()
+	if err != nil || typ != helloFrame || len(payload) == 0 {
+		logging.GetLogger().Errorf("Invalid flow transport handshake from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	s := r.getSession(string(payload), fc)
+	defer r.releaseSession(s, fc)
+
+	s.Lock()
+	lastSeq := s.lastSeq
+	s.Unlock()
+
+	if err := fc.writeFrame(helloFrame, encodeUint64(lastSeq), encodeUint32(uint32(r.credit))); err != nil {
+		return
+	}
+	if err := fc.flush(); err != nil {
+		return
+	}
+
+	logging.GetLogger().Debugf("Flow sender %s connected, last sequence %d", conn.RemoteAddr(), lastSeq)
+
+	for {
+		typ, payload, err := fc.readFrame()
+		if err != nil {
+			return
+		}
+
+		if typ != dataFrame {
+			continue
+		}
+
+		seq, data, err := decodeData(payload)
+		if err != nil {
+			logging.GetLogger().Errorf("Invalid flow transport frame from %s: %s", conn.RemoteAddr(), err)
+			return
+		}
+
+		s.Lock()
+		if seq > s.lastSeq {
+			if err := r.handler(data); err != nil {
+				s.Unlock()
+				return
+			}
+			s.lastSeq = seq
+			atomic.AddInt64(&r.received, 1)
+		} else {
+			atomic.AddInt64(&r.duplicated, 1)
+		}
+		s.Unlock()
+
+		if err := fc.writeFrame(ackFrame, encodeUint64(seq)); err != nil {
+			return
+		}
+		if err := fc.flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Serve accepts the connections of the senders until the receiver is stopped
+func (r *Receiver) Serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return
+		}
+
+		r.wg.Add(1)
+		go r.serveConn(conn)
+	}
+}
+
+// Stop closes the listener and the connections of the senders
+func (r *Receiver) Stop() {
+	r.listener.Close()
+
+	r.RLock()
+	for _, s := range r.sessions {
+		if s.conn != nil {
+			s.conn.close()
+		}
+	}
+	r.RUnlock()
+
+	r.wg.Wait()
+}
+
+// Stats returns the statistics of the receiver
+func (r *Receiver) Stats() ReceiverStats {
+	r.RLock()
+	var senders int
+	for _, s := range r.sessions {
+		if s.conn != nil {
+			senders++
+		}
+	}
+	r.RUnlock()
+
+	return ReceiverStats{
+		Received:   atomic.LoadInt64(&r.received),
+		Duplicated: atomic.LoadInt64(&r.duplicated),
+		Senders:    senders,
+	}
+}
+
+// NewReceiver returns a receiver accepting connections on the given
+// listener. The credit is the number of data frames a sender can send
+// without being acknowledged.
+func NewReceiver(listener net.Listener, credit int, handler Handler) *Receiver {
+	return &Receiver{
+		listener: listener,
+		credit:   credit,
+		handler:  handler,
+		sessions: make(map[string]*session),
+	}
+}
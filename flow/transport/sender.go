@@ -0,0 +1,360 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/skydive-project/skydive/logging"
+)
+
+const reconnectDelay = time.Second
+
+var (
+	// ErrNoCredit is returned when a flow message could not be sent before
+	// the timeout as the receiver didn't acknowledge the previous ones
+	ErrNoCredit = errors.New("No credit left to send flows")
+	// ErrSenderStopped is returned when sending through a stopped sender
+	ErrSenderStopped = errors.New("Flow sender stopped")
+)
+
+// SenderStats describes the activity of a sender
+type SenderStats struct {
+	// number of flow messages sent
+	Sent int64
+	// number of flow messages acknowledged by the receiver
+	Acknowledged int64
+	// number of flow messages sent again after a reconnection
+	Resent int64
+	// number of flow messages dropped as no credit was left
+	Dropped int64
+	// number of flow messages waiting to be acknowledged
+	Pending int
+}
+
+type pendingFrame struct {
+	seq  uint64
+	data []byte
+}
+
+// Dialer opens a connection to the receiver
+type Dialer func() (net.Conn, error)
+
+// Sender sends flow messages to a receiver, reconnecting when the connection
+// is lost. The messages are kept until acknowledged, the sender blocking
+// when the receiver didn't acknowledge as many messages as its credit.
+type Sender struct {
+	sync.Mutex
+	// serializes the writes, the sequence numbers being sent in order
+	writeLock sync.Mutex
+	dial      Dialer
+	timeout   time.Duration
+	session   string
+	credit    int
+	seq       uint64
+	pending   []pendingFrame
+	conn      *frameConn
+	// closed when some credit is given back
+	creditCh chan struct{}
+	running  bool
+	quit     chan struct{}
+	wg       sync.WaitGroup
+	stats    SenderStats
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// notifyCredit wakes up the senders waiting for credit. The lock has to
+// be held.
+func (s *Sender) notifyCredit() {
+	close(s.creditCh)
+	s.creditCh = make(chan struct{})
+}
+
+// acknowledge removes the frames acknowledged by the receiver. The lock has
+// to be held.
+func (s *Sender) acknowledge(seq uint64) {
+	var i int
+	for i < len(s.pending) && s.pending[i].seq <= seq {
+		i++
+	}
+
+	if i > 0 {
+		s.pending = s.pending[i:]
+		s.stats.Acknowledged += int64(i)
+		s.notifyCredit()
+	}
+}
+
+// handshake opens the session and sends again the frames not acknowledged
+func (s *Sender) handshake(fc *frameConn) error {
+	if err := fc.writeFrame(helloFrame, []byte(s.session)); err != nil {
+		return err
+	}
+	if err := fc.flush(); err != nil {
+		return err
+	}
+
+	fc.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	typ, payload, err := fc.readFrame()
+	if err != nil {
+		return err
+	}
+	fc.conn.SetReadDeadline(time.Time{})
+
+	if typ != helloFrame {
+		return ErrMalformedFrame
+	}
+
+	lastSeq, credit, err := decodeServerHello(payload)
+	if err != nil {
+		return err
+	}
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.Lock()
+	s.acknowledge(lastSeq)
+	if credit > 0 {
+		s.credit = credit
+	}
+	pending := s.pending
+	s.Unlock()
+
+	for _, frame := range pending {
+		if err := fc.writeFrame(dataFrame, encodeUint64(frame.seq), frame.data); err != nil {
+			return err
+		}
+	}
+	if err := fc.flush(); err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.stats.Resent += int64(len(pending))
+	s.conn = fc
+	s.notifyCredit()
+	s.Unlock()
+
+	return nil
+}
+
+// readAcks handles the acknowledgements until the connection is closed
+func (s *Sender) readAcks(fc *frameConn) {
+	for {
+		typ, payload, err := fc.readFrame()
+		if err != nil {
+			return
+		}
+
+		if typ != ackFrame {
+			continue
+		}
+
+		seq, err := decodeAck(payload)
+		if err != nil {
+			return
+		}
+
+		s.Lock()
+		s.acknowledge(seq)
+		s.Unlock()
+	}
+}
+
+func (s *Sender) run() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.dial()
+		if err == nil {
+			fc := newFrameConn(conn)
+
+			if err = s.handshake(fc); err == nil {
+				s.readAcks(fc)
+			} else {
+				logging.GetLogger().Errorf("Flow transport handshake failed: %s", err)
+			}
+
+			s.Lock()
+			if s.conn == fc {
+				s.conn = nil
+			}
+			s.Unlock()
+
+			fc.close()
+		} else {
+			logging.GetLogger().Debugf("Unable to connect the flow receiver: %s", err)
+		}
+
+		select {
+		case <-s.quit:
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// waitCredit waits until a frame can be sent
+func (s *Sender) waitCredit(deadline <-chan time.Time) error {
+	for {
+		s.Lock()
+		if !s.running {
+			s.Unlock()
+			return ErrSenderStopped
+		}
+
+		if len(s.pending) < s.credit {
+			s.Unlock()
+			return nil
+		}
+		creditCh := s.creditCh
+		s.Unlock()
+
+		select {
+		case <-creditCh:
+		case <-deadline:
+			s.Lock()
+			s.stats.Dropped++
+			s.Unlock()
+			return ErrNoCredit
+		}
+	}
+}
+
+// Send sends a flow message. The message is kept until acknowledged, even
+// if the connection to the receiver is lost. ErrNoCredit is returned if the
+// message could not be sent before the timeout.
+func (s *Sender) Send(data []byte) error {
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+
+	// the credit is checked again once allowed to write as another
+	// message may have been sent meanwhile
+	for {
+		if err := s.waitCredit(timer.C); err != nil {
+			return err
+		}
+
+		s.writeLock.Lock()
+		s.Lock()
+		if s.running && len(s.pending) < s.credit {
+			break
+		}
+		s.Unlock()
+		s.writeLock.Unlock()
+	}
+	defer s.writeLock.Unlock()
+
+	s.seq++
+	seq := s.seq
+	s.pending = append(s.pending, pendingFrame{seq: seq, data: data})
+	s.stats.Sent++
+	fc := s.conn
+	s.Unlock()
+
+	if fc == nil {
+		// sent on reconnection
+		return nil
+	}
+
+	err := fc.writeFrame(dataFrame, encodeUint64(seq), data)
+	if err == nil {
+		err = fc.flush()
+	}
+	if err != nil {
+		// the frame will be sent again on reconnection
+		fc.close()
+	}
+
+	return nil
+}
+
+// IsConnected returns whether the sender is connected to the receiver
+func (s *Sender) IsConnected() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.conn != nil
+}
+
+// Stats returns the statistics of the sender
+func (s *Sender) Stats() SenderStats {
+	s.Lock()
+	defer s.Unlock()
+
+	stats := s.stats
+	stats.Pending = len(s.pending)
+	return stats
+}
+
+// Start connects the sender to the receiver
+func (s *Sender) Start() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.running {
+		return
+	}
+
+	s.running = true
+	s.quit = make(chan struct{})
+	s.wg.Add(1)
+	go s.run()
+}
+
+// Stop closes the connection to the receiver. The frames not acknowledged
+// are sent again if the sender is restarted.
+func (s *Sender) Stop() {
+	s.Lock()
+	if !s.running {
+		s.Unlock()
+		return
+	}
+
+	s.running = false
+	close(s.quit)
+	if s.conn != nil {
+		s.conn.close()
+	}
+	s.notifyCredit()
+	s.Unlock()
+
+	s.wg.Wait()
+}
+
+// NewSender returns a sender using the given dialer to connect the receiver.
+// The sender waits at most for the timeout for some credit when sending.
+func NewSender(dial Dialer, credit int, timeout time.Duration) *Sender {
+	return &Sender{
+		dial:     dial,
+		timeout:  timeout,
+		session:  newSessionID(),
+		credit:   credit,
+		creditCh: make(chan struct{}),
+	}
+}
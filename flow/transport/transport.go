@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package transport implements an acknowledged flow transport over TCP.
+//
+// Both directions of a connection are a deflate compressed stream of frames,
+// made of a type, a length and a payload. The sender opens a session with a
+// hello frame, the receiver answering with the last sequence number it
+// acknowledged for this session and the number of data frames the sender
+// can send without being acknowledged, its credit. Each data frame holds a
+// sequence number and a flow message. The receiver acknowledges a data frame
+// once its flows are handed over, so that a slow receiver throttles the
+// sender. On reconnection, the frames not acknowledged are sent again, the
+// receiver ignoring the ones it already got.
+package transport
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+)
+
+const (
+	helloFrame byte = iota + 1
+	dataFrame
+	ackFrame
+)
+
+const (
+	frameHeaderSize = 5
+	// maximum size of a frame payload
+	maxFrameSize = 64 * 1024 * 1024
+)
+
+// ErrMalformedFrame is returned when a frame can not be decoded
+var ErrMalformedFrame = errors.New("Malformed flow transport frame")
+
+// frameConn reads and writes the frames of a connection
+type frameConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *flate.Writer
+}
+
+func newFrameConn(conn net.Conn) *frameConn {
+	// the compression level favors the speed as the flows are sent
+	// as soon as they are updated
+	writer, _ := flate.NewWriter(conn, flate.BestSpeed)
+
+	return &frameConn{
+		conn:   conn,
+		reader: bufio.NewReader(flate.NewReader(conn)),
+		writer: writer,
+	}
+}
+
+// writeFrame writes a frame. The frame is sent on flush.
+func (c *frameConn) writeFrame(typ byte, parts ...[]byte) error {
+	var size int
+	for _, part := range parts {
+		size += len(part)
+	}
+
+	var header [frameHeaderSize]byte
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:], uint32(size))
+
+	if _, err := c.writer.Write(header[:]); err != nil {
+		return err
+	}
+
+	for _, part := range parts {
+		if _, err := c.writer.Write(part); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *frameConn) flush() error {
+	return c.writer.Flush()
+}
+
+func (c *frameConn) readFrame() (byte, []byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxFrameSize {
+		return 0, nil, ErrMalformedFrame
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], payload, nil
+}
+
+func (c *frameConn) close() error {
+	return c.conn.Close()
+}
+
+func encodeUint64(i uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, i)
+	return b
+}
+
+func encodeUint32(i uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, i)
+	return b
+}
+
+// decodeServerHello returns the last acknowledged sequence number and the
+// credit of a hello frame sent by the receiver
+func decodeServerHello(payload []byte) (uint64, int, error) {
+	if len(payload) != 12 {
+		return 0, 0, ErrMalformedFrame
+	}
+	return binary.BigEndian.Uint64(payload), int(binary.BigEndian.Uint32(payload[8:])), nil
+}
+
+// decodeData returns the sequence number and the flow message of a data frame
+func decodeData(payload []byte) (uint64, []byte, error) {
+	if len(payload) < 8 {
+		return 0, nil, ErrMalformedFrame
+	}
+	return binary.BigEndian.Uint64(payload), payload[8:], nil
+}
+
+func decodeAck(payload []byte) (uint64, error) {
+	if len(payload) != 8 {
+		return 0, ErrMalformedFrame
+	}
+	return binary.BigEndian.Uint64(payload), nil
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package transport
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	sync.Mutex
+	messages []string
+	block    chan struct{}
+}
+
+func (r *recorder) handle(data []byte) error {
+	if r.block != nil {
+		<-r.block
+	}
+
+	r.Lock()
+	r.messages = append(r.messages, string(data))
+	r.Unlock()
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.messages)
+}
+
+func newReceiver(t *testing.T, credit int, r *recorder) (*Receiver, Dialer) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiver := NewReceiver(listener, credit, r.handle)
+	go receiver.Serve()
+
+	addr := listener.Addr().String()
+	return receiver, func() (net.Conn, error) {
+		return net.DialTimeout("tcp", addr, time.Second)
+	}
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	for i := 0; i < 100; i++ {
+		if fn() {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("Timeout")
+}
+
+func TestTransportInOrder(t *testing.T) {
+	r := &recorder{}
+	receiver, dial := newReceiver(t, 10, r)
+	defer receiver.Stop()
+
+	sender := NewSender(dial, 10, 5*time.Second)
+	sender.Start()
+	defer sender.Stop()
+
+	for i := 0; i < 1000; i++ {
+		if err := sender.Send([]byte(fmt.Sprintf("message-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, func() bool { return sender.Stats().Pending == 0 })
+
+	if len(r.messages) != 1000 {
+		t.Fatalf("Expected 1000 messages, got %d", len(r.messages))
+	}
+
+	for i, msg := range r.messages {
+		if msg != fmt.Sprintf("message-%d", i) {
+			t.Fatalf("Expected message-%d, got %s", i, msg)
+		}
+	}
+
+	if stats := sender.Stats(); stats.Sent != 1000 || stats.Acknowledged != 1000 || stats.Dropped != 0 {
+		t.Errorf("Unexpected sender stats: %+v", stats)
+	}
+}
+
+func TestTransportReconnect(t *testing.T) {
+	r := &recorder{}
+	receiver, dial := newReceiver(t, 100, r)
+	defer receiver.Stop()
+
+	sender := NewSender(dial, 100, 5*time.Second)
+	sender.Start()
+	defer sender.Stop()
+
+	for i := 0; i < 200; i++ {
+		if i == 100 {
+			// the connection is lost, the session being kept by the receiver
+			receiver.RLock()
+			for _, s := range receiver.sessions {
+				s.conn.close()
+			}
+			receiver.RUnlock()
+		}
+
+		if err := sender.Send([]byte(fmt.Sprintf("message-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, func() bool { return sender.Stats().Pending == 0 })
+
+	if len(r.messages) != 200 {
+		t.Fatalf("Expected each message once, got %d messages", len(r.messages))
+	}
+
+	for i, msg := range r.messages {
+		if msg != fmt.Sprintf("message-%d", i) {
+			t.Fatalf("Expected message-%d, got %s", i, msg)
+		}
+	}
+}
+
+func TestTransportBackpressure(t *testing.T) {
+	r := &recorder{block: make(chan struct{})}
+	receiver, dial := newReceiver(t, 2, r)
+	defer receiver.Stop()
+
+	sender := NewSender(dial, 2, 200*time.Millisecond)
+	sender.Start()
+	defer sender.Stop()
+
+	waitFor(t, sender.IsConnected)
+
+	for i := 0; i < 2; i++ {
+		if err := sender.Send([]byte("message")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := sender.Send([]byte("message")); err != ErrNoCredit {
+		t.Fatalf("Expected the sender to run out of credit, got %v", err)
+	}
+
+	if stats := sender.Stats(); stats.Dropped != 1 || stats.Pending != 2 {
+		t.Errorf("Unexpected sender stats: %+v", stats)
+	}
+
+	close(r.block)
+	waitFor(t, func() bool { return sender.Stats().Pending == 0 })
+
+	if err := sender.Send([]byte("message")); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool { return r.count() == 3 })
+}
@@ -49,7 +49,7 @@ require (
 	github.com/imdario/mergo v0.3.7 // indirect
 	github.com/inconshreveable/mousetrap v0.0.0-20141017200713-76626ae9c91c // indirect
 	github.com/intel-go/nff-go v0.0.0-20190620122648-8ab691c21da9
-	github.com/iovisor/gobpf v0.0.0-20190329163444-e0d8d785d368 // indirect
+	github.com/iovisor/gobpf v0.0.0-20190329163444-e0d8d785d368
 	github.com/jbowtie/gokogiri v0.0.0-20190301021639-37f655d3078f // indirect
 	github.com/jteeuwen/go-bindata v0.0.0-20180305030458-6025e8de665b
 	github.com/juju/clock v0.0.0-20190205081909-9c5c9712527c // indirect
@@ -43,6 +43,7 @@ var (
 	hubListen        string
 	writeCompression bool
 	queueSize        int
+	batchDelay       int
 	pingDelay        int
 	pongTimeout      int
 )
@@ -100,11 +101,12 @@ var HubCmd = &cobra.Command{
 			logging.GetLogger().Error(err)
 			os.Exit(1)
 		}
-		api.RegisterTopologyAPI(httpServer, g, tr, authBackend)
+		api.RegisterTopologyAPI(httpServer, g, tr, authBackend, 0)
 
 		serverOpts := websocket.ServerOpts{
 			WriteCompression: writeCompression,
 			QueueSize:        queueSize,
+			BatchDelay:       time.Millisecond * time.Duration(batchDelay),
 			PingDelay:        time.Second * time.Duration(pingDelay),
 			PongTimeout:      time.Second * time.Duration(pongTimeout),
 		}
@@ -135,6 +137,7 @@ var HubCmd = &cobra.Command{
 func init() {
 	HubCmd.Flags().StringVarP(&hubListen, "listen", "l", "127.0.0.1:8082", "address and port for the hub server")
 	HubCmd.Flags().IntVar(&queueSize, "queueSize", 10000, "websocket queue size")
+	HubCmd.Flags().IntVar(&batchDelay, "batchDelay", 0, "websocket batching window in milliseconds")
 	HubCmd.Flags().IntVar(&pingDelay, "pingDelay", 2, "websocket ping delay")
 	HubCmd.Flags().IntVar(&pongTimeout, "pongTimeout", 10, "websocket pong timeout")
 }
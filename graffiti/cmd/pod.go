@@ -105,7 +105,7 @@ var PodCmd = &cobra.Command{
 			logging.GetLogger().Error(err)
 			os.Exit(1)
 		}
-		api.RegisterTopologyAPI(httpServer, g, tr, authBackend)
+		api.RegisterTopologyAPI(httpServer, g, tr, authBackend, 0)
 
 		var addresses []common.ServiceAddress
 		for _, address := range hubServers {
@@ -125,6 +125,7 @@ var PodCmd = &cobra.Command{
 			AuthOpts:         clusterAuthOptions,
 			WriteCompression: writeCompression,
 			QueueSize:        queueSize,
+			BatchDelay:       time.Millisecond * time.Duration(batchDelay),
 		}
 
 		clientPool := newHubClientPool(hostname, addresses, clientOpts)
@@ -133,6 +134,7 @@ var PodCmd = &cobra.Command{
 			ServerOpts: websocket.ServerOpts{
 				WriteCompression: writeCompression,
 				QueueSize:        queueSize,
+				BatchDelay:       time.Millisecond * time.Duration(batchDelay),
 				PingDelay:        time.Second * time.Duration(pingDelay),
 				PongTimeout:      time.Second * time.Duration(pongTimeout),
 			},
@@ -164,6 +166,7 @@ func init() {
 	PodCmd.Flags().StringArrayVar(&hubServers, "hubs", nil, "address and port for the pod server")
 	PodCmd.Flags().StringVarP(&podListen, "listen", "l", "127.0.0.1:8081", "address and port for the pod server")
 	PodCmd.Flags().IntVar(&queueSize, "queueSize", 10000, "websocket queue size")
+	PodCmd.Flags().IntVar(&batchDelay, "batchDelay", 0, "websocket batching window in milliseconds")
 	PodCmd.Flags().IntVar(&pingDelay, "pingDelay", 2, "websocket ping delay")
 	PodCmd.Flags().IntVar(&pongTimeout, "pongTimeout", 10, "websocket pong timeout")
 }
@@ -18,18 +18,45 @@
 package common
 
 import (
+	"encoding/json"
+	"errors"
+
+	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/graffiti/graph"
 	gws "github.com/skydive-project/skydive/graffiti/websocket"
 	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/spool"
 	ws "github.com/skydive-project/skydive/websocket"
 )
 
 // Forwarder forwards the topology to only one master server.
 // When switching from one analyzer to another one the agent does a full
-// re-sync since some messages could have been lost.
+// re-sync since some messages could have been lost. When a spool is set,
+// the events occurring while no master is reachable are spooled, along
+// with the graph at the time the master was lost, and replayed in order
+// to the next master.
 type Forwarder struct {
+	common.RWMutex
 	masterElection *ws.MasterElection
 	graph          *graph.Graph
+	listening      bool
+	spool          *spool.Spool
+	spooling       bool
+	// master the spool is being replayed to, the events being spooled
+	// until the end of the replay
+	replaying  ws.Speaker
+	replayDone chan struct{}
+	// number of records dropped by the spool when spooling started
+	dropped int64
+}
+
+// errReplayAborted is returned when the master is lost during a replay
+var errReplayAborted = errors.New("master changed during the replay")
+
+// spooledMessage is the record of a message in the spool
+type spooledMessage struct {
+	Type string
+	Obj  json.RawMessage
 }
 
 func (t *Forwarder) triggerResync() {
@@ -39,60 +66,244 @@ func (t *Forwarder) triggerResync() {
 	msg := &gws.SyncMsg{
 		Elements: t.graph.Elements(),
 	}
-	t.masterElection.SendMessageToMaster(gws.NewStructMessage(gws.SyncMsgType, msg))
+	t.sendMessage(gws.SyncMsgType, msg)
+}
+
+// sendMessage sends a message to the master or spools it while
+// there is no master
+func (t *Forwarder) sendMessage(typ string, obj interface{}) {
+	// the lock is held while spooling so that the message is replayed
+	t.RLock()
+	defer t.RUnlock()
+
+	if !t.spooling {
+		t.masterElection.SendMessageToMaster(gws.NewStructMessage(typ, obj))
+		return
+	}
+
+	data, err := json.Marshal(obj)
+	if err == nil {
+		data, err = json.Marshal(&spooledMessage{Type: typ, Obj: data})
+	}
+	if err == nil {
+		err = t.spool.Append(data)
+	}
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to spool topology message %s: %s", typ, err)
+	}
+}
+
+// startSpooling spools the graph then its events until a new master is
+// elected. The graph lock has to be held.
+func (t *Forwarder) startSpooling() {
+	t.spool.Reset()
+
+	t.Lock()
+	t.dropped = t.spool.Stats().Dropped
+	t.spooling = true
+	t.Unlock()
+
+	t.sendMessage(gws.SyncMsgType, &gws.SyncMsg{Elements: t.graph.Elements()})
+}
+
+// replayTo sends a spooled message to the master being replayed to
+func (t *Forwarder) replayTo(c ws.Speaker, data []byte) error {
+	t.RLock()
+	replaying := t.replaying
+	t.RUnlock()
+
+	if replaying != c {
+		return errReplayAborted
+	}
+	return sendSpooled(c, data)
+}
+
+// sendSpooled sends a spooled message to a master
+func sendSpooled(c ws.Speaker, data []byte) error {
+	var msg spooledMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+	return c.SendMessage(ws.NewStructMessage(gws.Namespace, msg.Type, msg.Obj))
+}
+
+// replaySpool sends the spooled messages to the new master without holding
+// the graph lock, the events being spooled meanwhile. The graph lock is only
+// taken to replay the last events before sending them directly again. The
+// replay of a previous master is waited for, a replay interrupted by the
+// loss of the master being resumed from the last replayed message.
+func (t *Forwarder) replaySpool(c ws.Speaker, previous chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	if previous != nil {
+		<-previous
+	}
+
+	logging.GetLogger().Infof("Replay %d spooled topology messages", t.spool.Stats().Records)
+
+	err := t.spool.Replay(func(data []byte) error { return t.replayTo(c, data) })
+	if err == errReplayAborted {
+		return
+	}
+
+	t.graph.RLock()
+	defer t.graph.RUnlock()
+
+	t.Lock()
+	if t.replaying != c {
+		t.Unlock()
+		return
+	}
+
+	// the messages spooled meanwhile are replayed with the graph locked,
+	// the next events being sent directly
+	if err == nil {
+		err = t.spool.Replay(func(data []byte) error { return sendSpooled(c, data) })
+	}
+
+	dropped := t.spool.Stats().Dropped - t.dropped
+	t.replaying = nil
+	t.spooling = false
+	t.spool.Reset()
+	t.Unlock()
+
+	switch {
+	case err != nil:
+		logging.GetLogger().Errorf("Unable to replay the topology spool: %s", err)
+		t.triggerResync()
+	case dropped != 0:
+		logging.GetLogger().Warningf("Topology spool overflowed, %d messages dropped", dropped)
+		t.triggerResync()
+	}
 }
 
 // OnNewMaster is called by the master election mechanism when a new master is elected. In
-// such case a "Re-sync" is triggered in order to be in sync with the new master.
+// such case a "Re-sync" is triggered in order to be in sync with the new master, unless
+// the events spooled while no master was reachable can be replayed.
 func (t *Forwarder) OnNewMaster(c ws.Speaker) {
+	t.graph.RLock()
+	defer t.graph.RUnlock()
+
 	if c == nil {
 		logging.GetLogger().Warning("Lost connection to master")
 
-		// do not forward message before re-sync
-		t.graph.RemoveEventListener(t)
+		if t.spool == nil {
+			if t.listening {
+				// do not forward message before re-sync
+				t.graph.RemoveEventListener(t)
+				t.listening = false
+			}
+			return
+		}
+
+		t.Lock()
+		replaying := t.replaying != nil
+		t.replaying = nil
+		t.Unlock()
+
+		// an interrupted replay is resumed by the next master, the
+		// events being still spooled
+		if !replaying {
+			t.startSpooling()
+		}
+		return
+	}
+
+	addr, port := c.GetAddrPort()
+	logging.GetLogger().Infof("Using %s:%d as master of topology forwarder", addr, port)
+
+	t.Lock()
+	spooling := t.spooling
+	overflowed := spooling && t.spool.Stats().Dropped != t.dropped
+	if spooling && !overflowed {
+		done := make(chan struct{})
+		go t.replaySpool(c, t.replayDone, done)
+		t.replaying, t.replayDone = c, done
 	} else {
-		addr, port := c.GetAddrPort()
-		logging.GetLogger().Infof("Using %s:%d as master of topology forwarder", addr, port)
+		t.spooling = false
+		t.replaying = nil
+	}
+	t.Unlock()
 
-		t.graph.RLock()
+	if overflowed {
+		logging.GetLogger().Warningf("Topology spool overflowed, %d messages dropped", t.spool.Stats().Dropped-t.dropped)
+	}
 
+	if !spooling || overflowed {
+		if t.spool != nil {
+			t.spool.Reset()
+		}
 		t.triggerResync()
+	}
 
-		// synced can now listen the graph
+	// synced can now listen the graph
+	if !t.listening {
 		t.graph.AddEventListener(t)
-
-		t.graph.RUnlock()
+		t.listening = true
 	}
 }
 
+// SetSpool sets the spool used to keep the events while no master is
+// reachable. The records of a previous spool are dropped.
+func (t *Forwarder) SetSpool(s *spool.Spool) {
+	s.Reset()
+	t.spool = s
+}
+
 // OnNodeUpdated graph node updated event. Implements the EventListener interface.
 func (t *Forwarder) OnNodeUpdated(n *graph.Node) {
-	t.masterElection.SendMessageToMaster(gws.NewStructMessage(gws.NodeUpdatedMsgType, n))
+	t.sendMessage(gws.NodeUpdatedMsgType, n)
+}
+
+// OnNodePartiallyUpdated graph node partially updated event. Implements the PartialUpdateListener interface.
+func (t *Forwarder) OnNodePartiallyUpdated(n *graph.Node, ops []graph.PartiallyUpdatedOp) {
+	t.sendMessage(gws.NodePartiallyUpdatedMsgType, gws.NewNodePartiallyUpdatedMsg(n, ops))
 }
 
 // OnNodeAdded graph node added event. Implements the EventListener interface.
 func (t *Forwarder) OnNodeAdded(n *graph.Node) {
-	t.masterElection.SendMessageToMaster(gws.NewStructMessage(gws.NodeAddedMsgType, n))
+	t.sendMessage(gws.NodeAddedMsgType, n)
 }
 
 // OnNodeDeleted graph node deleted event. Implements the EventListener interface.
 func (t *Forwarder) OnNodeDeleted(n *graph.Node) {
-	t.masterElection.SendMessageToMaster(gws.NewStructMessage(gws.NodeDeletedMsgType, n))
+	t.sendMessage(gws.NodeDeletedMsgType, n)
 }
 
 // OnEdgeUpdated graph edge updated event. Implements the EventListener interface.
 func (t *Forwarder) OnEdgeUpdated(e *graph.Edge) {
-	t.masterElection.SendMessageToMaster(gws.NewStructMessage(gws.EdgeUpdatedMsgType, e))
+	t.sendMessage(gws.EdgeUpdatedMsgType, e)
+}
+
+// OnEdgePartiallyUpdated graph edge partially updated event. Implements the PartialUpdateListener interface.
+func (t *Forwarder) OnEdgePartiallyUpdated(e *graph.Edge, ops []graph.PartiallyUpdatedOp) {
+	t.sendMessage(gws.EdgePartiallyUpdatedMsgType, gws.NewEdgePartiallyUpdatedMsg(e, ops))
 }
 
 // OnEdgeAdded graph edge added event. Implements the EventListener interface.
 func (t *Forwarder) OnEdgeAdded(e *graph.Edge) {
-	t.masterElection.SendMessageToMaster(gws.NewStructMessage(gws.EdgeAddedMsgType, e))
+	t.sendMessage(gws.EdgeAddedMsgType, e)
 }
 
 // OnEdgeDeleted graph edge deleted event. Implements the EventListener interface.
 func (t *Forwarder) OnEdgeDeleted(e *graph.Edge) {
-	t.masterElection.SendMessageToMaster(gws.NewStructMessage(gws.EdgeDeletedMsgType, e))
+	t.sendMessage(gws.EdgeDeletedMsgType, e)
+}
+
+// OnStructMessage is triggered by a message coming from the master. The
+// master requests a re-sync when it is not able to apply a partial update.
+func (t *Forwarder) OnStructMessage(c ws.Speaker, msg *ws.StructMessage) {
+	if msg.Type != gws.SyncRequestMsgType {
+		return
+	}
+
+	if master := t.GetMaster(); master == nil || master.GetURL().String() != c.GetURL().String() {
+		return
+	}
+
+	t.graph.RLock()
+	t.triggerResync()
+	t.graph.RUnlock()
 }
 
 // GetMaster returns the current analyzer the agent is sending its events to
@@ -112,5 +323,8 @@ func NewForwarder(g *graph.Graph, pool ws.StructSpeakerPool) *Forwarder {
 
 	masterElection.AddEventHandler(t)
 
+	// handle the re-sync requests of the master
+	pool.AddStructMessageHandler(t, []string{gws.Namespace})
+
 	return t
 }
@@ -38,6 +38,10 @@ const (
 	DeleteOnDisconnect PersistencePolicy = "DeleteOnDisconnect"
 )
 
+// PublisherAudit is called with the graph lock held for each mutation
+// requested by a publisher, before it is applied to the graph
+type PublisherAudit func(c ws.Speaker, msgType string, obj interface{})
+
 // PublisherEndpoint serves the graph for external publishers, for instance
 // an external program that interacts with the Skydive graph.
 type PublisherEndpoint struct {
@@ -47,15 +51,19 @@ type PublisherEndpoint struct {
 	Graph     *graph.Graph
 	validator validator.Validator
 	authors   map[string]bool
+	audit     PublisherAudit
+	// publishers a re-sync was requested to
+	resyncs map[string]bool
 }
 
 // OnDisconnected called when a publisher got disconnected.
 func (t *PublisherEndpoint) OnDisconnected(c ws.Speaker) {
 	origin := ClientOrigin(c)
 
-	t.RLock()
+	t.Lock()
 	_, ok := t.authors[origin]
-	t.RUnlock()
+	delete(t.resyncs, origin)
+	t.Unlock()
 
 	// not an author so do not delete resources
 	if !ok {
@@ -76,6 +84,62 @@ func (t *PublisherEndpoint) OnDisconnected(c ws.Speaker) {
 	t.Unlock()
 }
 
+// requestResync asks a publisher to send its whole graph, unless a re-sync
+// is already pending. Until then, the partial updates that can not be
+// applied are dropped.
+func (t *PublisherEndpoint) requestResync(c ws.Speaker) {
+	origin := ClientOrigin(c)
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.resyncs[origin] {
+		return
+	}
+	t.resyncs[origin] = true
+
+	logging.GetLogger().Infof("Graph of %s out of sync, request a re-sync", origin)
+	c.SendMessage(gws.NewStructMessage(gws.SyncRequestMsgType, gws.SyncRequestMsg{}))
+}
+
+// validateNodePartialUpdate validates the node as it would be once the
+// partial update applied, the update being applied to a copy of the node.
+// The graph lock has to be held.
+func (t *PublisherEndpoint) validateNodePartialUpdate(pu *gws.PartiallyUpdatedMsg) error {
+	node := t.Graph.GetNode(pu.ID)
+	if t.validator == nil || node == nil {
+		return nil
+	}
+
+	metadata, err := node.Metadata.ApplyPartialUpdates(pu.Ops)
+	if err != nil {
+		return err
+	}
+
+	updated := *node
+	updated.Metadata = metadata
+	return t.validator.ValidateNode(&updated)
+}
+
+// validateEdgePartialUpdate validates the edge as it would be once the
+// partial update applied, the update being applied to a copy of the edge.
+// The graph lock has to be held.
+func (t *PublisherEndpoint) validateEdgePartialUpdate(pu *gws.PartiallyUpdatedMsg) error {
+	edge := t.Graph.GetEdge(pu.ID)
+	if t.validator == nil || edge == nil {
+		return nil
+	}
+
+	metadata, err := edge.Metadata.ApplyPartialUpdates(pu.Ops)
+	if err != nil {
+		return err
+	}
+
+	updated := *edge
+	updated.Metadata = metadata
+	return t.validator.ValidateEdge(&updated)
+}
+
 // OnStructMessage is triggered by message coming from a publisher.
 func (t *PublisherEndpoint) OnStructMessage(c ws.Speaker, msg *ws.StructMessage) {
 	msgType, obj, err := gws.UnmarshalMessage(msg)
@@ -113,6 +177,10 @@ func (t *PublisherEndpoint) OnStructMessage(c ws.Speaker, msg *ws.StructMessage)
 	t.Graph.Lock()
 	defer t.Graph.Unlock()
 
+	if t.audit != nil && msgType != gws.SyncRequestMsgType {
+		t.audit(c, msgType, obj)
+	}
+
 	switch msgType {
 	case gws.SyncRequestMsgType:
 		reply := msg.Reply(t.Graph, gws.SyncReplyMsgType, http.StatusOK)
@@ -120,7 +188,11 @@ func (t *PublisherEndpoint) OnStructMessage(c ws.Speaker, msg *ws.StructMessage)
 	case gws.SyncMsgType, gws.SyncReplyMsgType:
 		r := obj.(*gws.SyncMsg)
 
-		DelSubGraphOfOrigin(t.Graph, ClientOrigin(c))
+		t.Lock()
+		delete(t.resyncs, origin)
+		t.Unlock()
+
+		DelSubGraphOfOrigin(t.Graph, origin)
 
 		for _, n := range r.Nodes {
 			if t.Graph.GetNode(n.ID) == nil {
@@ -150,6 +222,24 @@ func (t *PublisherEndpoint) OnStructMessage(c ws.Speaker, msg *ws.StructMessage)
 		}
 	case gws.EdgeAddedMsgType:
 		err = t.Graph.EdgeAdded(obj.(*graph.Edge))
+	case gws.NodePartiallyUpdatedMsgType:
+		pu := obj.(*gws.PartiallyUpdatedMsg)
+		if err = t.validateNodePartialUpdate(pu); err != nil {
+			break
+		}
+		if err = t.Graph.NodePartiallyUpdated(pu.ID, pu.Revision, pu.UpdatedAt, pu.Ops); pu.Revision != 0 && (err == graph.ErrOutOfSync || err == graph.ErrNodeNotFound) {
+			t.requestResync(c)
+			return
+		}
+	case gws.EdgePartiallyUpdatedMsgType:
+		pu := obj.(*gws.PartiallyUpdatedMsg)
+		if err = t.validateEdgePartialUpdate(pu); err != nil {
+			break
+		}
+		if err = t.Graph.EdgePartiallyUpdated(pu.ID, pu.Revision, pu.UpdatedAt, pu.Ops); pu.Revision != 0 && (err == graph.ErrOutOfSync || err == graph.ErrEdgeNotFound) {
+			t.requestResync(c)
+			return
+		}
 	}
 
 	if err != nil {
@@ -157,6 +247,11 @@ func (t *PublisherEndpoint) OnStructMessage(c ws.Speaker, msg *ws.StructMessage)
 	}
 }
 
+// SetAudit sets the function called for each mutation requested by a publisher
+func (t *PublisherEndpoint) SetAudit(audit PublisherAudit) {
+	t.audit = audit
+}
+
 // NewPublisherEndpoint returns a new server for external publishers.
 func NewPublisherEndpoint(pool ws.StructSpeakerPool, g *graph.Graph, validator validator.Validator) (*PublisherEndpoint, error) {
 	t := &PublisherEndpoint{
@@ -164,6 +259,7 @@ func NewPublisherEndpoint(pool ws.StructSpeakerPool, g *graph.Graph, validator v
 		pool:      pool,
 		validator: validator,
 		authors:   make(map[string]bool),
+		resyncs:   make(map[string]bool),
 	}
 
 	pool.AddEventHandler(t)
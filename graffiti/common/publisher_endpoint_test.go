@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	gws "github.com/skydive-project/skydive/graffiti/websocket"
+	ws "github.com/skydive-project/skydive/websocket"
+)
+
+type fakeSpeaker struct {
+	ws.Speaker
+}
+
+func (s *fakeSpeaker) GetServiceType() common.ServiceType { return common.AgentService }
+func (s *fakeSpeaker) GetRemoteHost() string              { return "host1" }
+
+// nameValidator rejects the nodes and edges without name
+type nameValidator struct{}
+
+func (v *nameValidator) ValidateNode(n *graph.Node) error {
+	if _, err := n.GetFieldString("Name"); err != nil {
+		return errors.New("node without name")
+	}
+	return nil
+}
+
+func (v *nameValidator) ValidateEdge(e *graph.Edge) error {
+	if _, err := e.GetFieldString("Name"); err != nil {
+		return errors.New("edge without name")
+	}
+	return nil
+}
+
+func TestPartialUpdateValidation(t *testing.T) {
+	b, err := graph.NewMemoryBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := graph.NewGraph("testhost", b, common.AnalyzerService)
+
+	endpoint := &PublisherEndpoint{
+		Graph:     g,
+		validator: &nameValidator{},
+		authors:   make(map[string]bool),
+		resyncs:   make(map[string]bool),
+	}
+
+	g.Lock()
+	n, err := g.NewNode(graph.GenID(), graph.Metadata{"Name": "eth0", "MTU": 1500})
+	g.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(ops ...graph.PartiallyUpdatedOp) {
+		data, err := json.Marshal(&gws.PartiallyUpdatedMsg{ID: n.ID, Ops: ops})
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoint.OnStructMessage(&fakeSpeaker{}, &ws.StructMessage{Namespace: gws.Namespace, Type: gws.NodePartiallyUpdatedMsgType, Obj: data})
+	}
+
+	check := func(mtu int64) {
+		g.RLock()
+		defer g.RUnlock()
+
+		if name, _ := n.GetFieldString("Name"); name != "eth0" {
+			t.Errorf("Expected the node name to be kept, got %s", name)
+		}
+		if value, _ := n.GetFieldInt64("MTU"); value != mtu {
+			t.Errorf("Expected MTU %d, got %d", mtu, value)
+		}
+	}
+
+	// the update removing the name is rejected as a whole
+	update(
+		graph.PartiallyUpdatedOp{Type: graph.PartiallyUpdatedAddOpType, Key: "MTU", Value: 9000},
+		graph.PartiallyUpdatedOp{Type: graph.PartiallyUpdatedDelOpType, Key: "Name"},
+	)
+	check(1500)
+
+	update(graph.PartiallyUpdatedOp{Type: graph.PartiallyUpdatedAddOpType, Key: "MTU", Value: 9000})
+	check(9000)
+}
@@ -32,10 +32,15 @@ import (
 	ws "github.com/skydive-project/skydive/websocket"
 )
 
+// ScopeFilter returns the Gremlin filter restricting the part of the graph
+// a subscriber has access to, an empty string for the whole graph
+type ScopeFilter func(c ws.Speaker) string
+
 type subscriber struct {
 	graph         *graph.Graph
 	gremlinFilter string
 	ts            *traversal.GremlinTraversalSequence
+	scope         *traversal.GremlinTraversalSequence
 }
 
 // SubscriberEndpoint sends all the modifications to its subscribers.
@@ -47,34 +52,69 @@ type SubscriberEndpoint struct {
 	wg            sync.WaitGroup
 	gremlinParser *traversal.GremlinTraversalParser
 	subscribers   map[ws.Speaker]*subscriber
+	scopeFilter   ScopeFilter
+	// subscribers that accept partial updates
+	partials map[ws.Speaker]bool
 }
 
-func (t *SubscriberEndpoint) getGraph(gremlinQuery string, ts *traversal.GremlinTraversalSequence, lockGraph bool) (*graph.Graph, error) {
-	res, err := ts.Exec(t.Graph, lockGraph)
-	if err != nil {
-		return nil, err
+// getGraph returns the part of the graph seen by a subscriber, the scope
+// filter being applied first
+func (t *SubscriberEndpoint) getGraph(s *subscriber, lockGraph bool) (g *graph.Graph, err error) {
+	g = t.Graph
+	if s.scope != nil {
+		if g, err = s.scope.ExecGraph(g, lockGraph); err != nil {
+			return nil, err
+		}
 	}
 
-	tv, ok := res.(*traversal.GraphTraversal)
-	if !ok {
-		return nil, fmt.Errorf("Gremlin query '%s' did not return a graph", gremlinQuery)
+	if s.ts != nil {
+		if g, err = s.ts.ExecGraph(g, lockGraph); err != nil {
+			return nil, fmt.Errorf("Error while executing Gremlin filter '%s': %s", s.gremlinFilter, err)
+		}
 	}
 
-	return tv.Graph, nil
+	return g, nil
 }
 
-func (t *SubscriberEndpoint) newSubscriber(host string, gremlinFilter string, lockGraph bool) (*subscriber, error) {
-	ts, err := t.gremlinParser.Parse(strings.NewReader(gremlinFilter))
-	if err != nil {
-		return nil, fmt.Errorf("Invalid Gremlin filter '%s' for client %s", gremlinFilter, host)
+func (t *SubscriberEndpoint) newSubscriber(host string, gremlinFilter string, scopeFilter string, lockGraph bool) (*subscriber, error) {
+	s := &subscriber{gremlinFilter: gremlinFilter}
+
+	if gremlinFilter != "" {
+		ts, err := t.gremlinParser.Parse(strings.NewReader(gremlinFilter))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Gremlin filter '%s' for client %s", gremlinFilter, host)
+		}
+		s.ts = ts
+	}
+
+	if scopeFilter != "" {
+		scope, err := t.gremlinParser.Parse(strings.NewReader(scopeFilter))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid scope filter '%s' for client %s", scopeFilter, host)
+		}
+		s.scope = scope
 	}
 
-	g, err := t.getGraph(gremlinFilter, ts, lockGraph)
+	g, err := t.getGraph(s, lockGraph)
 	if err != nil {
 		return nil, err
 	}
+	s.graph = g
+
+	return s, nil
+}
+
+func (t *SubscriberEndpoint) getScopeFilter(c ws.Speaker) string {
+	if t.scopeFilter == nil {
+		return ""
+	}
+	return t.scopeFilter(c)
+}
 
-	return &subscriber{graph: g, ts: ts, gremlinFilter: gremlinFilter}, nil
+// SetScopeFilter sets the function returning the part of the graph
+// each subscriber has access to
+func (t *SubscriberEndpoint) SetScopeFilter(scopeFilter ScopeFilter) {
+	t.scopeFilter = scopeFilter
 }
 
 // OnConnected called when a subscriber got connected.
@@ -84,12 +124,30 @@ func (t *SubscriberEndpoint) OnConnected(c ws.Speaker) {
 		gremlinFilter = c.GetURL().Query().Get("x-gremlin-filter")
 	}
 
-	if gremlinFilter != "" {
+	partialUpdates := c.GetHeaders().Get("X-Partial-Updates")
+	if partialUpdates == "" {
+		partialUpdates = c.GetURL().Query().Get("x-partial-updates")
+	}
+
+	if partialUpdates == "true" {
+		t.Lock()
+		t.partials[c] = true
+		t.Unlock()
+	}
+
+	scopeFilter := t.getScopeFilter(c)
+
+	if gremlinFilter != "" || scopeFilter != "" {
 		host := c.GetRemoteHost()
 
-		subscriber, err := t.newSubscriber(host, gremlinFilter, false)
+		subscriber, err := t.newSubscriber(host, gremlinFilter, scopeFilter, false)
 		if err != nil {
 			logging.GetLogger().Error(err)
+
+			// a scoped subscriber must not receive the whole graph
+			if scopeFilter != "" {
+				t.subscribers[c] = nil
+			}
 			return
 		}
 
@@ -102,6 +160,7 @@ func (t *SubscriberEndpoint) OnConnected(c ws.Speaker) {
 func (t *SubscriberEndpoint) OnDisconnected(c ws.Speaker) {
 	t.Lock()
 	delete(t.subscribers, c)
+	delete(t.partials, c)
 	t.Unlock()
 }
 
@@ -129,15 +188,16 @@ func (t *SubscriberEndpoint) OnStructMessage(c ws.Speaker, msg *ws.StructMessage
 		}
 
 		host := c.GetRemoteHost()
+		scopeFilter := t.getScopeFilter(c)
 
 		if syncMsg.GremlinFilter != nil {
 			// filter reset
-			if *syncMsg.GremlinFilter == "" {
+			if *syncMsg.GremlinFilter == "" && scopeFilter == "" {
 				t.Lock()
 				delete(t.subscribers, c)
 				t.Unlock()
 			} else {
-				subscriber, err := t.newSubscriber(host, *syncMsg.GremlinFilter, false)
+				subscriber, err := t.newSubscriber(host, *syncMsg.GremlinFilter, scopeFilter, false)
 				if err != nil {
 					logging.GetLogger().Error(err)
 
@@ -165,6 +225,10 @@ func (t *SubscriberEndpoint) OnStructMessage(c ws.Speaker, msg *ws.StructMessage
 
 			if subscriber != nil {
 				result = subscriber.graph
+			} else if scopeFilter != "" {
+				reply := msg.Reply("Unable to compute the graph of the subscriber", gws.SyncReplyMsgType, http.StatusInternalServerError)
+				c.SendMessage(reply)
+				return
 			}
 		}
 
@@ -177,20 +241,23 @@ func (t *SubscriberEndpoint) OnStructMessage(c ws.Speaker, msg *ws.StructMessage
 
 // notifyClients forwards local graph modification to subscribers. If a subscriber
 // specified a Gremlin filter, a 'Diff' is applied between the previous graph state
-// for this subscriber and the current graph state.
-func (t *SubscriberEndpoint) notifyClients(typ string, i interface{}) {
+// for this subscriber and the current graph state. The partial update message,
+// if any, is sent instead of the update to the subscribers without filter that
+// accept partial updates.
+func (t *SubscriberEndpoint) notifyClients(typ string, i interface{}, partial *ws.StructMessage) {
 	for _, c := range t.pool.GetSpeakers() {
 		t.RLock()
 		subscriber, found := t.subscribers[c]
+		acceptPartial := t.partials[c]
 		t.RUnlock()
 
 		if found {
 			// in the case of an error during the subscription we got a nil subscriber
 			if subscriber == nil {
-				return
+				continue
 			}
 
-			g, err := t.getGraph(subscriber.gremlinFilter, subscriber.ts, false)
+			g, err := t.getGraph(subscriber, false)
 			if err != nil {
 				logging.GetLogger().Error(err)
 				continue
@@ -227,6 +294,8 @@ func (t *SubscriberEndpoint) notifyClients(typ string, i interface{}) {
 			}
 
 			subscriber.graph = g
+		} else if partial != nil && acceptPartial {
+			c.SendMessage(partial)
 		} else {
 			c.SendMessage(gws.NewStructMessage(typ, i))
 		}
@@ -235,32 +304,44 @@ func (t *SubscriberEndpoint) notifyClients(typ string, i interface{}) {
 
 // OnNodeUpdated graph node updated event. Implements the GraphEventListener interface.
 func (t *SubscriberEndpoint) OnNodeUpdated(n *graph.Node) {
-	t.notifyClients(gws.NodeUpdatedMsgType, n)
+	t.notifyClients(gws.NodeUpdatedMsgType, n, nil)
+}
+
+// OnNodePartiallyUpdated graph node partially updated event. Implements the PartialUpdateListener interface.
+func (t *SubscriberEndpoint) OnNodePartiallyUpdated(n *graph.Node, ops []graph.PartiallyUpdatedOp) {
+	partial := gws.NewStructMessage(gws.NodePartiallyUpdatedMsgType, gws.NewNodePartiallyUpdatedMsg(n, ops))
+	t.notifyClients(gws.NodeUpdatedMsgType, n, partial)
 }
 
 // OnNodeAdded graph node added event. Implements the GraphEventListener interface.
 func (t *SubscriberEndpoint) OnNodeAdded(n *graph.Node) {
-	t.notifyClients(gws.NodeAddedMsgType, n)
+	t.notifyClients(gws.NodeAddedMsgType, n, nil)
 }
 
 // OnNodeDeleted graph node deleted event. Implements the GraphEventListener interface.
 func (t *SubscriberEndpoint) OnNodeDeleted(n *graph.Node) {
-	t.notifyClients(gws.NodeDeletedMsgType, n)
+	t.notifyClients(gws.NodeDeletedMsgType, n, nil)
 }
 
 // OnEdgeUpdated graph edge updated event. Implements the GraphEventListener interface.
 func (t *SubscriberEndpoint) OnEdgeUpdated(e *graph.Edge) {
-	t.notifyClients(gws.EdgeUpdatedMsgType, e)
+	t.notifyClients(gws.EdgeUpdatedMsgType, e, nil)
+}
+
+// OnEdgePartiallyUpdated graph edge partially updated event. Implements the PartialUpdateListener interface.
+func (t *SubscriberEndpoint) OnEdgePartiallyUpdated(e *graph.Edge, ops []graph.PartiallyUpdatedOp) {
+	partial := gws.NewStructMessage(gws.EdgePartiallyUpdatedMsgType, gws.NewEdgePartiallyUpdatedMsg(e, ops))
+	t.notifyClients(gws.EdgeUpdatedMsgType, e, partial)
 }
 
 // OnEdgeAdded graph edge added event. Implements the GraphEventListener interface.
 func (t *SubscriberEndpoint) OnEdgeAdded(e *graph.Edge) {
-	t.notifyClients(gws.EdgeAddedMsgType, e)
+	t.notifyClients(gws.EdgeAddedMsgType, e, nil)
 }
 
 // OnEdgeDeleted graph edge deleted event. Implements the GraphEventListener interface.
 func (t *SubscriberEndpoint) OnEdgeDeleted(e *graph.Edge) {
-	t.notifyClients(gws.EdgeDeletedMsgType, e)
+	t.notifyClients(gws.EdgeDeletedMsgType, e, nil)
 }
 
 // NewSubscriberEndpoint returns a new server to be used by external subscribers,
@@ -270,6 +351,7 @@ func NewSubscriberEndpoint(pool ws.StructSpeakerPool, g *graph.Graph, tr *traver
 		Graph:         g,
 		pool:          pool,
 		subscribers:   make(map[ws.Speaker]*subscriber),
+		partials:      make(map[ws.Speaker]bool),
 		gremlinParser: tr,
 	}
 
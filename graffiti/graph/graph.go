@@ -61,6 +61,8 @@ type graphEvent struct {
 	kind     graphEventType
 	element  interface{}
 	listener EventListener
+	// metadata operations of an update, when known
+	ops []PartiallyUpdatedOp
 }
 
 type graphElement struct {
@@ -118,10 +120,13 @@ type Backend interface {
 	IsHistorySupported() bool
 }
 
-// Context describes within time slice
+// Context describes within time slice. A restricted graph only contains
+// the part of the topology a user has access to, the steps querying data
+// outside of the graph, like the flows, have to be limited to its nodes.
 type Context struct {
-	TimeSlice *common.TimeSlice
-	TimePoint bool
+	TimeSlice  *common.TimeSlice
+	TimePoint  bool
+	Restricted bool
 }
 
 var liveContext = Context{TimePoint: true}
@@ -242,13 +247,21 @@ func (g *EventHandler) notifyListeners(ge graphEvent) {
 		case NodeAdded:
 			g.currentEventListener.OnNodeAdded(ge.element.(*Node))
 		case NodeUpdated:
-			g.currentEventListener.OnNodeUpdated(ge.element.(*Node))
+			if pl, ok := g.currentEventListener.(PartialUpdateListener); ok && ge.ops != nil {
+				pl.OnNodePartiallyUpdated(ge.element.(*Node), ge.ops)
+			} else {
+				g.currentEventListener.OnNodeUpdated(ge.element.(*Node))
+			}
 		case NodeDeleted:
 			g.currentEventListener.OnNodeDeleted(ge.element.(*Node))
 		case EdgeAdded:
 			g.currentEventListener.OnEdgeAdded(ge.element.(*Edge))
 		case EdgeUpdated:
-			g.currentEventListener.OnEdgeUpdated(ge.element.(*Edge))
+			if pl, ok := g.currentEventListener.(PartialUpdateListener); ok && ge.ops != nil {
+				pl.OnEdgePartiallyUpdated(ge.element.(*Edge), ge.ops)
+			} else {
+				g.currentEventListener.OnEdgeUpdated(ge.element.(*Edge))
+			}
 		case EdgeDeleted:
 			g.currentEventListener.OnEdgeDeleted(ge.element.(*Edge))
 		}
@@ -258,10 +271,19 @@ func (g *EventHandler) notifyListeners(ge graphEvent) {
 // NotifyEvent notifies all the listeners of an event. NotifyEvent
 // makes sure that we don't enter a notify endless loop.
 func (g *EventHandler) NotifyEvent(kind graphEventType, element interface{}) {
+	g.notifyEvent(graphEvent{kind: kind, element: element})
+}
+
+// notifyPartialUpdate notifies all the listeners of an update along with
+// its metadata operations
+func (g *EventHandler) notifyPartialUpdate(kind graphEventType, element interface{}, ops []PartiallyUpdatedOp) {
+	g.notifyEvent(graphEvent{kind: kind, element: element, ops: ops})
+}
+
+func (g *EventHandler) notifyEvent(ge graphEvent) {
 	// push event to chan so that nested notification will be sent in the
 	// right order. Associate the event with the current event listener so
 	// we can avoid loop by not triggering event for the current listener.
-	ge := graphEvent{kind: kind, element: element}
 	ge.listener = g.currentEventListener
 	g.eventChan <- ge
 
@@ -498,21 +520,11 @@ func (e *graphElement) normalize(metadata map[string]json.RawMessage, decoders m
 			continue
 		}
 
-		if decoder, ok := decoders[k]; ok {
-			v, err := decoder(r)
-			if err != nil {
-				return err
-			}
-			e.Metadata[k] = v
-			continue
-		}
-
-		var i interface{}
-		if err := json.Unmarshal(r, &i); err != nil {
+		v, err := DecodeMetadataValue(k, r, decoders)
+		if err != nil {
 			return err
 		}
-
-		e.Metadata[k] = normalizeNumbers(i)
+		e.Metadata[k] = v
 	}
 
 	return nil
@@ -689,6 +701,7 @@ func (g *Graph) EdgeUpdated(e *Edge) error {
 		if edge.Revision < e.Revision {
 			edge.Metadata = e.Metadata
 			edge.UpdatedAt = e.UpdatedAt
+			edge.Revision = e.Revision
 
 			if err := g.backend.MetadataUpdated(edge); err != nil {
 				return err
@@ -701,6 +714,55 @@ func (g *Graph) EdgeUpdated(e *Edge) error {
 	return ErrEdgeNotFound
 }
 
+// ReplaceNode replaces the node having the same ID, whatever their revisions.
+// It is used to resolve the conflicts between replicas of a graph.
+func (g *Graph) ReplaceNode(n *Node) error {
+	node := g.GetNode(n.ID)
+	if node == nil {
+		return ErrNodeNotFound
+	}
+
+	node.Metadata = n.Metadata
+	node.CreatedAt = n.CreatedAt
+	node.UpdatedAt = n.UpdatedAt
+	node.Revision = n.Revision
+
+	if err := g.backend.MetadataUpdated(node); err != nil {
+		return err
+	}
+
+	g.eventHandler.NotifyEvent(NodeUpdated, node)
+	return nil
+}
+
+// ReplaceEdge replaces the edge having the same ID, whatever their revisions.
+// It is used to resolve the conflicts between replicas of a graph.
+func (g *Graph) ReplaceEdge(e *Edge) error {
+	edge := g.GetEdge(e.ID)
+	if edge == nil {
+		return ErrEdgeNotFound
+	}
+
+	if edge.Parent != e.Parent || edge.Child != e.Child {
+		if err := g.EdgeDeleted(edge); err != nil {
+			return err
+		}
+		return g.EdgeAdded(e)
+	}
+
+	edge.Metadata = e.Metadata
+	edge.CreatedAt = e.CreatedAt
+	edge.UpdatedAt = e.UpdatedAt
+	edge.Revision = e.Revision
+
+	if err := g.backend.MetadataUpdated(edge); err != nil {
+		return err
+	}
+
+	g.eventHandler.NotifyEvent(EdgeUpdated, edge)
+	return nil
+}
+
 // SetMetadata associate metadata to an edge or node
 func (g *Graph) SetMetadata(i interface{}, m Metadata) error {
 	var e *graphElement
@@ -719,6 +781,13 @@ func (g *Graph) SetMetadata(i interface{}, m Metadata) error {
 		return nil
 	}
 
+	// the keys containing a dot can not be expressed as operations,
+	// a full update is notified in that case
+	var ops []PartiallyUpdatedOp
+	if !hasDottedKey(e.Metadata) && !hasDottedKey(m) {
+		ops = diffMetadata("", e.Metadata, m, metadataDecoders(kind))
+	}
+
 	e.Metadata = m
 	e.UpdatedAt = TimeUTC()
 	e.Revision++
@@ -727,7 +796,7 @@ func (g *Graph) SetMetadata(i interface{}, m Metadata) error {
 		return err
 	}
 
-	g.eventHandler.NotifyEvent(kind, i)
+	g.eventHandler.notifyPartialUpdate(kind, i, ops)
 	return nil
 }
 
@@ -756,7 +825,8 @@ func (g *Graph) DelMetadata(i interface{}, k string) error {
 		return err
 	}
 
-	g.eventHandler.NotifyEvent(kind, i)
+	op := e.metadataOp(PartiallyUpdatedDelOpType, k, nil, metadataDecoders(kind))
+	g.eventHandler.notifyPartialUpdate(kind, i, []PartiallyUpdatedOp{op})
 	return nil
 }
 
@@ -788,7 +858,8 @@ func (g *Graph) addMetadata(i interface{}, k string, v interface{}, t Time) erro
 		return err
 	}
 
-	g.eventHandler.NotifyEvent(kind, i)
+	op := e.metadataOp(PartiallyUpdatedAddOpType, k, v, metadataDecoders(kind))
+	g.eventHandler.notifyPartialUpdate(kind, i, []PartiallyUpdatedOp{op})
 	return nil
 }
 
@@ -800,12 +871,15 @@ func (g *Graph) AddMetadata(i interface{}, k string, v interface{}) error {
 // UpdateMetadata retrieves a value and calls a callback that can modify it then notify listeners of the update
 func (g *Graph) UpdateMetadata(i interface{}, key string, mutator func(obj interface{}) bool) error {
 	var e *graphElement
+	var kind graphEventType
 
 	switch i.(type) {
 	case *Node:
 		e = &i.(*Node).graphElement
+		kind = NodeUpdated
 	case *Edge:
 		e = &i.(*Edge).graphElement
+		kind = EdgeUpdated
 	}
 
 	field, err := e.GetField(key)
@@ -813,16 +887,19 @@ func (g *Graph) UpdateMetadata(i interface{}, key string, mutator func(obj inter
 		return err
 	}
 
+	if updated := mutator(field); !updated {
+		return nil
+	}
+
 	e.UpdatedAt = TimeUTC()
 	e.Revision++
 
-	if updated := mutator(field); updated {
-		if err := g.backend.MetadataUpdated(i); err != nil {
-			return err
-		}
-		g.eventHandler.NotifyEvent(NodeUpdated, i)
+	if err := g.backend.MetadataUpdated(i); err != nil {
+		return err
 	}
 
+	op := e.metadataOp(PartiallyUpdatedAddOpType, key, field, metadataDecoders(kind))
+	g.eventHandler.notifyPartialUpdate(kind, i, []PartiallyUpdatedOp{op})
 	return nil
 }
 
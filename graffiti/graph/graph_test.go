@@ -19,6 +19,7 @@ package graph
 
 import (
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
@@ -548,3 +549,110 @@ func TestRecursiveEvents(t *testing.T) {
 		t.Error("Events are not in the right order")
 	}
 }
+
+type FakePartialListener struct {
+	DefaultGraphListener
+	nodeOps [][]PartiallyUpdatedOp
+	updates int
+}
+
+func (f *FakePartialListener) OnNodeUpdated(n *Node) {
+	f.updates++
+}
+
+func (f *FakePartialListener) OnNodePartiallyUpdated(n *Node, ops []PartiallyUpdatedOp) {
+	f.nodeOps = append(f.nodeOps, ops)
+}
+
+func (f *FakePartialListener) OnEdgePartiallyUpdated(e *Edge, ops []PartiallyUpdatedOp) {
+}
+
+func TestPartialUpdates(t *testing.T) {
+	g := newGraph(t)
+
+	l := &FakePartialListener{}
+	g.AddEventListener(l)
+
+	n, _ := g.NewNode(GenID(), Metadata{"Type": "intf", "Labels": map[string]interface{}{"A": "1", "B": "2"}})
+
+	replica := newGraph(t)
+	r, _ := replica.NewNode(n.ID, Metadata{"Type": "intf", "Labels": map[string]interface{}{"A": "1", "B": "2"}})
+	r.Revision = n.Revision
+
+	g.AddMetadata(n, "Name", "eth0")
+	g.AddMetadata(n, "Labels.C", "3")
+	g.DelMetadata(n, "Labels.A")
+	g.SetMetadata(n, Metadata{"Type": "intf", "Name": "eth1", "Labels": map[string]interface{}{"B": "2", "C": "3", "D": "4"}})
+
+	tr := g.StartMetadataTransaction(n)
+	tr.AddMetadata("MTU", 1500)
+	tr.DelMetadata("Labels.D")
+	tr.Commit()
+
+	if l.updates != 0 || len(l.nodeOps) != 5 {
+		t.Fatalf("Expected 5 partial updates, got %d partial and %d full updates", len(l.nodeOps), l.updates)
+	}
+
+	if len(l.nodeOps[3]) != 2 {
+		t.Errorf("Expected only the changes of SetMetadata, got: %+v", l.nodeOps[3])
+	}
+
+	for i, ops := range l.nodeOps {
+		if err := replica.NodePartiallyUpdated(n.ID, r.Revision+1, n.UpdatedAt, ops); err != nil {
+			t.Fatalf("Unable to apply partial update %d: %s", i, err)
+		}
+	}
+
+	if !reflect.DeepEqual(n.Metadata, r.Metadata) || n.Revision != r.Revision {
+		t.Errorf("Replica out of sync, expected %+v (revision %d), got %+v (revision %d)", n.Metadata, n.Revision, r.Metadata, r.Revision)
+	}
+
+	// already applied revision
+	if err := replica.NodePartiallyUpdated(n.ID, r.Revision, n.UpdatedAt, l.nodeOps[0]); err != nil {
+		t.Errorf("Already applied update should be ignored, got: %s", err)
+	}
+
+	// missing revision
+	if err := replica.NodePartiallyUpdated(n.ID, r.Revision+2, n.UpdatedAt, l.nodeOps[0]); err != ErrOutOfSync {
+		t.Errorf("Expected out of sync error, got: %v", err)
+	}
+}
+
+func TestApplyPartialUpdates(t *testing.T) {
+	m := Metadata{"Name": "eth0", "Labels": map[string]interface{}{"A": "1", "B": "2"}}
+
+	updated, err := m.ApplyPartialUpdates([]PartiallyUpdatedOp{
+		{Type: PartiallyUpdatedAddOpType, Key: "Labels.C", Value: "3"},
+		{Type: PartiallyUpdatedDelOpType, Key: "Labels.A"},
+		{Type: PartiallyUpdatedDelOpType, Key: "Name"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Metadata{"Labels": map[string]interface{}{"B": "2", "C": "3"}}
+	if !reflect.DeepEqual(updated, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, updated)
+	}
+
+	// the original metadata, nested ones included, is left untouched
+	original := Metadata{"Name": "eth0", "Labels": map[string]interface{}{"A": "1", "B": "2"}}
+	if !reflect.DeepEqual(m, original) {
+		t.Errorf("Metadata modified by the partial updates: %+v", m)
+	}
+
+	// a failing operation leaves the node untouched
+	g := newGraph(t)
+	n, _ := g.NewNode(GenID(), Metadata{"Name": "eth0", "MTU": 1500})
+
+	err = g.NodePartiallyUpdated(n.ID, 0, TimeUTC(), []PartiallyUpdatedOp{
+		{Type: PartiallyUpdatedDelOpType, Key: "Name"},
+		{Type: PartiallyUpdatedAddOpType, Key: "MTU.Value", Value: 9000},
+	})
+	if err != ErrOutOfSync {
+		t.Errorf("Expected out of sync error, got: %v", err)
+	}
+	if name, _ := n.GetFieldString("Name"); name != "eth0" {
+		t.Errorf("Node modified by a failed partial update: %+v", n.Metadata)
+	}
+}
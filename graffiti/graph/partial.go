@@ -0,0 +1,256 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/skydive-project/skydive/common"
+)
+
+// ErrOutOfSync is returned when partial updates can not be applied to a node
+// or an edge, as it is not at the revision the updates apply to
+var ErrOutOfSync = errors.New("Graph element out of sync")
+
+// PartialUpdateListener is implemented by the event listeners that want to
+// be notified of the metadata operations of an update, instead of the whole
+// node or edge, when these operations are known
+type PartialUpdateListener interface {
+	OnNodePartiallyUpdated(n *Node, ops []PartiallyUpdatedOp)
+	OnEdgePartiallyUpdated(e *Edge, ops []PartiallyUpdatedOp)
+}
+
+func asMap(i interface{}) (map[string]interface{}, bool) {
+	switch m := i.(type) {
+	case map[string]interface{}:
+		return m, true
+	case Metadata:
+		return m, true
+	}
+	return nil, false
+}
+
+func hasDottedKey(m map[string]interface{}) bool {
+	for k := range m {
+		if strings.Contains(k, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// diffMetadata returns the operations transforming a metadata into another
+// one. The nested maps are compared recursively unless they are decoded by a
+// metadata decoder or one of their keys can not be used in a dotted key.
+func diffMetadata(prefix string, before, after map[string]interface{}, decoders map[string]MetadataDecoder) (ops []PartiallyUpdatedOp) {
+	for k := range before {
+		if _, found := after[k]; !found {
+			ops = append(ops, PartiallyUpdatedOp{Type: PartiallyUpdatedDelOpType, Key: prefix + k})
+		}
+	}
+
+	for k, v := range after {
+		o, found := before[k]
+		if found && reflect.DeepEqual(o, v) {
+			continue
+		}
+
+		if _, decoded := decoders[k]; found && !decoded {
+			bm, bok := asMap(o)
+			am, aok := asMap(v)
+
+			// an empty map is set as a whole as the deletion of its last
+			// key would delete it
+			if bok && aok && len(am) > 0 && !hasDottedKey(bm) && !hasDottedKey(am) {
+				ops = append(ops, diffMetadata(prefix+k+".", bm, am, nil)...)
+				continue
+			}
+		}
+
+		ops = append(ops, PartiallyUpdatedOp{Type: PartiallyUpdatedAddOpType, Key: prefix + k, Value: v})
+	}
+
+	return
+}
+
+func metadataDecoders(kind graphEventType) map[string]MetadataDecoder {
+	if kind == NodeUpdated {
+		return NodeMetadataDecoders
+	}
+	return EdgeMetadataDecoders
+}
+
+// metadataOp returns the operation for a metadata that was just set or
+// deleted. As a nested key can not be applied to a metadata decoded by a
+// decoder, the whole decoded metadata is set instead.
+func (e *graphElement) metadataOp(opType PartiallyUpdatedOpType, key string, value interface{}, decoders map[string]MetadataDecoder) PartiallyUpdatedOp {
+	if i := strings.Index(key, "."); i != -1 {
+		if _, decoded := decoders[key[:i]]; decoded {
+			key = key[:i]
+			if value, found := e.Metadata[key]; found {
+				return PartiallyUpdatedOp{Type: PartiallyUpdatedAddOpType, Key: key, Value: value}
+			}
+			return PartiallyUpdatedOp{Type: PartiallyUpdatedDelOpType, Key: key}
+		}
+	}
+
+	if opType == PartiallyUpdatedDelOpType {
+		return PartiallyUpdatedOp{Type: opType, Key: key}
+	}
+	return PartiallyUpdatedOp{Type: opType, Key: key, Value: value}
+}
+
+// DecodeMetadataValue decodes the JSON value of a metadata, using the
+// decoder registered for its key if any
+func DecodeMetadataValue(key string, raw json.RawMessage, decoders map[string]MetadataDecoder) (interface{}, error) {
+	if decoder, ok := decoders[key]; ok {
+		return decoder(raw)
+	}
+
+	var i interface{}
+	if err := json.Unmarshal(raw, &i); err != nil {
+		return nil, err
+	}
+
+	return normalizeNumbers(i), nil
+}
+
+// copyPath copies the nested maps along a dotted key, so that the key can be
+// set or deleted without modifying the maps it was copied from
+func copyPath(m map[string]interface{}, key string) {
+	components := strings.Split(key, ".")
+	for _, component := range components[:len(components)-1] {
+		nested, ok := m[component].(map[string]interface{})
+		if !ok {
+			return
+		}
+
+		c := make(map[string]interface{}, len(nested))
+		for k, v := range nested {
+			c[k] = v
+		}
+		m[component], m = c, c
+	}
+}
+
+// ApplyPartialUpdates returns a copy of the metadata with the operations of
+// a partial update applied, the metadata being left untouched
+func (m Metadata) ApplyPartialUpdates(ops []PartiallyUpdatedOp) (Metadata, error) {
+	c := make(Metadata, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+
+	for _, op := range ops {
+		copyPath(c, op.Key)
+
+		switch op.Type {
+		case PartiallyUpdatedAddOpType:
+			if !c.SetField(op.Key, op.Value) {
+				return nil, ErrOutOfSync
+			}
+		case PartiallyUpdatedDelOpType:
+			common.DelField(c, op.Key)
+		}
+	}
+
+	return c, nil
+}
+
+// applyPartialUpdates applies operations to the metadata of an element.
+// A revision of 0 means that the operations apply to any revision.
+func (e *graphElement) applyPartialUpdates(revision int64, updatedAt Time, ops []PartiallyUpdatedOp) (bool, error) {
+	if revision != 0 {
+		if e.Revision >= revision {
+			// already applied
+			return false, nil
+		}
+
+		if e.Revision != revision-1 {
+			return false, ErrOutOfSync
+		}
+	}
+
+	// the operations are applied to a copy so that the element is left
+	// untouched if one of them fails
+	metadata, err := e.Metadata.ApplyPartialUpdates(ops)
+	if err != nil {
+		return false, err
+	}
+	e.Metadata = metadata
+
+	if revision != 0 {
+		e.Revision = revision
+	} else {
+		e.Revision++
+	}
+
+	if updatedAt.IsZero() {
+		updatedAt = TimeUTC()
+	}
+	e.UpdatedAt = updatedAt
+
+	return true, nil
+}
+
+// NodePartiallyUpdated applies the operations of a partial update to a node.
+// The operations apply to the revision preceding the given one, ErrOutOfSync
+// being returned if the node is at another revision.
+func (g *Graph) NodePartiallyUpdated(id Identifier, revision int64, updatedAt Time, ops []PartiallyUpdatedOp) error {
+	node := g.GetNode(id)
+	if node == nil {
+		return ErrNodeNotFound
+	}
+
+	updated, err := node.applyPartialUpdates(revision, updatedAt, ops)
+	if !updated {
+		return err
+	}
+
+	if err := g.backend.MetadataUpdated(node); err != nil {
+		return err
+	}
+
+	g.eventHandler.notifyPartialUpdate(NodeUpdated, node, ops)
+	return err
+}
+
+// EdgePartiallyUpdated applies the operations of a partial update to an edge.
+// The operations apply to the revision preceding the given one, ErrOutOfSync
+// being returned if the edge is at another revision.
+func (g *Graph) EdgePartiallyUpdated(id Identifier, revision int64, updatedAt Time, ops []PartiallyUpdatedOp) error {
+	edge := g.GetEdge(id)
+	if edge == nil {
+		return ErrEdgeNotFound
+	}
+
+	updated, err := edge.applyPartialUpdates(revision, updatedAt, ops)
+	if !updated {
+		return err
+	}
+
+	if err := g.backend.MetadataUpdated(edge); err != nil {
+		return err
+	}
+
+	g.eventHandler.notifyPartialUpdate(EdgeUpdated, edge, ops)
+	return err
+}
@@ -55,24 +55,35 @@ func (t *MetadataTransaction) Commit() error {
 		kind = EdgeUpdated
 	}
 
-	var updated bool
+	var added, removed []string
 	for k, v := range t.adds {
 		if o, ok := e.Metadata[k]; ok && reflect.DeepEqual(o, v) {
 			continue
 		}
 
 		if e.Metadata.SetField(k, v) {
-			updated = true
+			added = append(added, k)
 		}
 	}
 
 	for _, k := range t.removes {
-		updated = common.DelField(e.Metadata, k) || updated
+		if common.DelField(e.Metadata, k) {
+			removed = append(removed, k)
+		}
 	}
-	if !updated {
+	if len(added) == 0 && len(removed) == 0 {
 		return nil
 	}
 
+	decoders := metadataDecoders(kind)
+	ops := make([]PartiallyUpdatedOp, 0, len(added)+len(removed))
+	for _, k := range added {
+		ops = append(ops, e.metadataOp(PartiallyUpdatedAddOpType, k, t.adds[k], decoders))
+	}
+	for _, k := range removed {
+		ops = append(ops, e.metadataOp(PartiallyUpdatedDelOpType, k, nil, decoders))
+	}
+
 	e.UpdatedAt = TimeUTC()
 	e.Revision++
 
@@ -80,7 +91,7 @@ func (t *MetadataTransaction) Commit() error {
 		return err
 	}
 
-	t.graph.eventHandler.NotifyEvent(kind, t.graphElement)
+	t.graph.eventHandler.notifyPartialUpdate(kind, t.graphElement, ops)
 
 	return nil
 }
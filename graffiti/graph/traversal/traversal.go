@@ -551,8 +551,9 @@ func (t *GraphTraversal) Context(s ...interface{}) *GraphTraversal {
 	defer t.RUnlock()
 
 	g, err := t.Graph.CloneWithContext(graph.Context{
-		TimePoint: len(s) == 1,
-		TimeSlice: common.NewTimeSlice(common.UnixMillis(at.Add(-duration)), common.UnixMillis(at)),
+		TimePoint:  len(s) == 1,
+		TimeSlice:  common.NewTimeSlice(common.UnixMillis(at.Add(-duration)), common.UnixMillis(at)),
+		Restricted: t.Graph.GetContext().Restricted,
 	})
 	if err != nil {
 		return &GraphTraversal{error: err}
@@ -904,6 +904,22 @@ func (s *GremlinTraversalSequence) Exec(g *graph.Graph, lockGraph bool) (GraphTr
 	return res, nil
 }
 
+// ExecGraph executes the sequence and returns the resulting graph. An error
+// is returned if the sequence doesn't return a graph, using SubGraph for instance.
+func (s *GremlinTraversalSequence) ExecGraph(g *graph.Graph, lockGraph bool) (*graph.Graph, error) {
+	res, err := s.Exec(g, lockGraph)
+	if err != nil {
+		return nil, err
+	}
+
+	tv, ok := res.(*GraphTraversal)
+	if !ok {
+		return nil, errors.New("Gremlin query did not return a graph")
+	}
+
+	return tv.Graph, nil
+}
+
 // AddTraversalExtension registers a new gremlin traversal extension
 func (p *GremlinTraversalParser) AddTraversalExtension(e GremlinTraversalExtension) {
 	p.extensions = append(p.extensions, e)
@@ -0,0 +1,508 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	gws "github.com/skydive-project/skydive/graffiti/websocket"
+	"github.com/skydive-project/skydive/logging"
+	ws "github.com/skydive-project/skydive/websocket"
+)
+
+// Anti-entropy message types. Periodically, a hub sends the digest of its
+// graph to the peers it connected to. A peer replies with the entries of the
+// buckets that differ, then the hub sends the nodes and edges the peer is
+// missing and requests the ones it is missing itself.
+const (
+	DigestMsgType        = "Digest"
+	DigestBucketsMsgType = "DigestBuckets"
+	DigestRepairMsgType  = "DigestRepair"
+)
+
+// digestBuckets is the number of buckets the nodes and edges are spread
+// into according to the hash of their ID
+const digestBuckets = 256
+
+// DigestMsg describes the digest of a graph, the hash of each bucket being
+// the combination of the hashes of the versions of its nodes and edges
+type DigestMsg struct {
+	Root    uint64
+	Buckets []uint64
+}
+
+// DigestEntry describes the version of a node or an edge, or its deletion.
+// The times are in milliseconds.
+type DigestEntry struct {
+	ID        graph.Identifier
+	Edge      bool `json:",omitempty"`
+	CreatedAt int64
+	UpdatedAt int64
+	Revision  int64
+	DeletedAt int64 `json:",omitempty"`
+}
+
+// DigestBucketsMsg lists the buckets that differ from the received digest
+// along with the entries of these buckets, an empty list meaning that the
+// graphs are consistent
+type DigestBucketsMsg struct {
+	Buckets []int
+	Entries []DigestEntry
+}
+
+// DigestRepairMsg holds the nodes, edges and deletions superseding the ones
+// of the peer, and the IDs of the elements requested to the peer
+type DigestRepairMsg struct {
+	Nodes   []*graph.Node
+	Edges   []*graph.Edge
+	Deleted []DigestEntry
+	Request []graph.Identifier
+}
+
+// ReplicationStatus describes the consistency of the graph with a peer
+type ReplicationStatus struct {
+	// time of the last digest comparison in milliseconds
+	LastCheck int64
+	// whether the graphs matched during the last comparison
+	Consistent bool
+	// number of buckets that differed during the last comparison
+	DivergentBuckets int
+	// number of nodes and edges repaired since the connection
+	Repaired int64
+	// delay in milliseconds between the last update of a node or an edge
+	// and its reception from the peer
+	Lag int64
+}
+
+func nowMillis() int64 {
+	return common.UnixMillis(time.Now())
+}
+
+func nodeEntry(n *graph.Node) DigestEntry {
+	return DigestEntry{
+		ID:        n.ID,
+		CreatedAt: n.CreatedAt.Unix(),
+		UpdatedAt: n.UpdatedAt.Unix(),
+		Revision:  n.Revision,
+	}
+}
+
+func edgeEntry(e *graph.Edge) DigestEntry {
+	return DigestEntry{
+		ID:        e.ID,
+		Edge:      true,
+		CreatedAt: e.CreatedAt.Unix(),
+		UpdatedAt: e.UpdatedAt.Unix(),
+		Revision:  e.Revision,
+	}
+}
+
+func bucketOf(id graph.Identifier) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % digestBuckets)
+}
+
+func (d *DigestEntry) hash() uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%t/%s/%d/%d/%d", d.Edge, d.ID, d.CreatedAt, d.Revision, d.UpdatedAt)
+	return h.Sum64()
+}
+
+// supersedes returns whether an entry wins over another one for the same
+// node or edge. A re-created element wins over the previous one, then the
+// highest revision wins, then the latest update. A deletion wins over the
+// versions that were not updated after it.
+func (d *DigestEntry) supersedes(o *DigestEntry) bool {
+	switch {
+	case d.DeletedAt != 0 && o.DeletedAt != 0:
+		return false
+	case d.DeletedAt != 0:
+		return d.DeletedAt >= o.UpdatedAt
+	case o.DeletedAt != 0:
+		return d.UpdatedAt > o.DeletedAt
+	case d.CreatedAt != o.CreatedAt:
+		return d.CreatedAt > o.CreatedAt
+	case d.Revision != o.Revision:
+		return d.Revision > o.Revision
+	}
+	return d.UpdatedAt > o.UpdatedAt
+}
+
+// digestEntries returns the entries of the nodes, edges and tombstones
+// of the given buckets, all the buckets if nil. The graph lock has to be held.
+func (t *ReplicationEndpoint) digestEntries(buckets map[int]bool) (entries []DigestEntry) {
+	match := func(id graph.Identifier) bool {
+		return buckets == nil || buckets[bucketOf(id)]
+	}
+
+	for _, n := range t.Graph.GetNodes(nil) {
+		if match(n.ID) {
+			entries = append(entries, nodeEntry(n))
+		}
+	}
+
+	for _, e := range t.Graph.GetEdges(nil) {
+		if match(e.ID) {
+			entries = append(entries, edgeEntry(e))
+		}
+	}
+
+	if buckets != nil {
+		t.tombstonesLock.Lock()
+		for _, tombstone := range t.tombstones {
+			if match(tombstone.ID) {
+				entries = append(entries, tombstone)
+			}
+		}
+		t.tombstonesLock.Unlock()
+	}
+
+	return
+}
+
+// digest returns the digest of the graph. The graph lock has to be held.
+func (t *ReplicationEndpoint) digest() *DigestMsg {
+	digest := &DigestMsg{Buckets: make([]uint64, digestBuckets)}
+	for _, entry := range t.digestEntries(nil) {
+		digest.Buckets[bucketOf(entry.ID)] ^= entry.hash()
+	}
+
+	h := fnv.New64a()
+	for _, bucket := range digest.Buckets {
+		fmt.Fprintf(h, "%x/", bucket)
+	}
+	digest.Root = h.Sum64()
+
+	return digest
+}
+
+func (t *ReplicationEndpoint) addTombstone(entry DigestEntry) {
+	if entry.DeletedAt <= 0 {
+		entry.DeletedAt = nowMillis()
+	}
+
+	t.tombstonesLock.Lock()
+	t.tombstones[entry.ID] = entry
+	t.tombstonesLock.Unlock()
+}
+
+func (t *ReplicationEndpoint) delTombstone(id graph.Identifier) {
+	t.tombstonesLock.Lock()
+	delete(t.tombstones, id)
+	t.tombstonesLock.Unlock()
+}
+
+func (t *ReplicationEndpoint) getTombstone(id graph.Identifier) (DigestEntry, bool) {
+	t.tombstonesLock.Lock()
+	defer t.tombstonesLock.Unlock()
+
+	tombstone, found := t.tombstones[id]
+	return tombstone, found
+}
+
+func (t *ReplicationEndpoint) pruneTombstones() {
+	expire := nowMillis() - int64(t.tombstoneTTL/time.Millisecond)
+
+	t.tombstonesLock.Lock()
+	for id, tombstone := range t.tombstones {
+		if tombstone.DeletedAt < expire {
+			delete(t.tombstones, id)
+		}
+	}
+	t.tombstonesLock.Unlock()
+}
+
+func (t *ReplicationEndpoint) updateStatus(host string, update func(status *ReplicationStatus)) {
+	t.statusLock.Lock()
+	defer t.statusLock.Unlock()
+
+	status, found := t.statuses[host]
+	if !found {
+		status = &ReplicationStatus{}
+		t.statuses[host] = status
+	}
+	update(status)
+}
+
+func (t *ReplicationEndpoint) setConsistency(host string, divergentBuckets int) {
+	t.updateStatus(host, func(status *ReplicationStatus) {
+		status.LastCheck = nowMillis()
+		status.Consistent = divergentBuckets == 0
+		status.DivergentBuckets = divergentBuckets
+	})
+}
+
+// ReplicationStatus returns the consistency of the graph with each peer
+func (t *ReplicationEndpoint) ReplicationStatus() map[string]ReplicationStatus {
+	t.statusLock.Lock()
+	defer t.statusLock.Unlock()
+
+	statuses := make(map[string]ReplicationStatus, len(t.statuses))
+	for host, status := range t.statuses {
+		statuses[host] = *status
+	}
+	return statuses
+}
+
+// sendDigests sends the digest of the graph to the peers this hub is
+// connected to. The peers connected to this hub send theirs, so that the
+// digests are exchanged only once per pair of hubs.
+func (t *ReplicationEndpoint) sendDigests() {
+	speakers := t.out.GetSpeakers()
+	if len(speakers) == 0 {
+		return
+	}
+
+	t.Graph.RLock()
+	digest := t.digest()
+	t.Graph.RUnlock()
+
+	for _, speaker := range speakers {
+		speaker.SendMessage(gws.NewStructMessage(DigestMsgType, digest))
+	}
+}
+
+func (t *ReplicationEndpoint) antiEntropy(quit chan bool) {
+	ticker := time.NewTicker(t.antiEntropyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			t.pruneTombstones()
+			t.sendDigests()
+		}
+	}
+}
+
+// onDigest compares the digest of a peer with the one of the local graph
+func (t *ReplicationEndpoint) onDigest(c ws.Speaker, digest *DigestMsg) {
+	local := t.digest()
+
+	reply := &DigestBucketsMsg{}
+	if local.Root != digest.Root {
+		buckets := make(map[int]bool)
+		for i, bucket := range local.Buckets {
+			if i >= len(digest.Buckets) || bucket != digest.Buckets[i] {
+				reply.Buckets = append(reply.Buckets, i)
+				buckets[i] = true
+			}
+		}
+		reply.Entries = t.digestEntries(buckets)
+	}
+
+	t.setConsistency(c.GetRemoteHost(), len(reply.Buckets))
+
+	c.SendMessage(gws.NewStructMessage(DigestBucketsMsgType, reply))
+}
+
+// onDigestBuckets compares the entries of the buckets that differ
+// and sends the repairs to the peer
+func (t *ReplicationEndpoint) onDigestBuckets(c ws.Speaker, msg *DigestBucketsMsg) {
+	t.setConsistency(c.GetRemoteHost(), len(msg.Buckets))
+	if len(msg.Buckets) == 0 {
+		return
+	}
+
+	remotes := make(map[graph.Identifier]DigestEntry, len(msg.Entries))
+	for _, entry := range msg.Entries {
+		remotes[entry.ID] = entry
+	}
+
+	buckets := make(map[int]bool, len(msg.Buckets))
+	for _, bucket := range msg.Buckets {
+		buckets[bucket] = true
+	}
+
+	repair := &DigestRepairMsg{}
+	for _, local := range t.digestEntries(buckets) {
+		remote, found := remotes[local.ID]
+		delete(remotes, local.ID)
+
+		switch {
+		case !found && local.DeletedAt == 0, found && local.supersedes(&remote):
+			t.addToRepair(repair, local)
+		case found && remote.supersedes(&local):
+			repair.Request = append(repair.Request, local.ID)
+		}
+	}
+
+	for _, remote := range remotes {
+		if remote.DeletedAt == 0 {
+			repair.Request = append(repair.Request, remote.ID)
+		}
+	}
+
+	if len(repair.Nodes) > 0 || len(repair.Edges) > 0 || len(repair.Deleted) > 0 || len(repair.Request) > 0 {
+		c.SendMessage(gws.NewStructMessage(DigestRepairMsgType, repair))
+	}
+}
+
+func (t *ReplicationEndpoint) addToRepair(repair *DigestRepairMsg, entry DigestEntry) {
+	switch {
+	case entry.DeletedAt != 0:
+		repair.Deleted = append(repair.Deleted, entry)
+	case entry.Edge:
+		if e := t.Graph.GetEdge(entry.ID); e != nil {
+			repair.Edges = append(repair.Edges, e)
+		}
+	default:
+		if n := t.Graph.GetNode(entry.ID); n != nil {
+			repair.Nodes = append(repair.Nodes, n)
+		}
+	}
+}
+
+func (t *ReplicationEndpoint) repairNode(n *graph.Node) (bool, error) {
+	entry := nodeEntry(n)
+
+	local := t.Graph.GetNode(n.ID)
+	if local == nil {
+		if tombstone, found := t.getTombstone(n.ID); found && tombstone.supersedes(&entry) {
+			return false, nil
+		}
+		return true, t.Graph.NodeAdded(n)
+	}
+
+	if localEntry := nodeEntry(local); entry.supersedes(&localEntry) {
+		return true, t.Graph.ReplaceNode(n)
+	}
+	return false, nil
+}
+
+func (t *ReplicationEndpoint) repairEdge(e *graph.Edge) (bool, error) {
+	entry := edgeEntry(e)
+
+	local := t.Graph.GetEdge(e.ID)
+	if local == nil {
+		if tombstone, found := t.getTombstone(e.ID); found && tombstone.supersedes(&entry) {
+			return false, nil
+		}
+		return true, t.Graph.EdgeAdded(e)
+	}
+
+	if localEntry := edgeEntry(local); entry.supersedes(&localEntry) {
+		return true, t.Graph.ReplaceEdge(e)
+	}
+	return false, nil
+}
+
+func (t *ReplicationEndpoint) repairDeletion(deletion DigestEntry) (bool, error) {
+	deletedAt := graph.Unix(0, deletion.DeletedAt*int64(time.Millisecond))
+
+	if deletion.Edge {
+		if local := t.Graph.GetEdge(deletion.ID); local != nil {
+			if localEntry := edgeEntry(local); deletion.supersedes(&localEntry) {
+				local.DeletedAt = deletedAt
+				return true, t.Graph.EdgeDeleted(local)
+			}
+		}
+		return false, nil
+	}
+
+	if local := t.Graph.GetNode(deletion.ID); local != nil {
+		if localEntry := nodeEntry(local); deletion.supersedes(&localEntry) {
+			local.DeletedAt = deletedAt
+			return true, t.Graph.NodeDeleted(local)
+		}
+	}
+	return false, nil
+}
+
+// onDigestRepair applies the repairs sent by a peer and replies with the
+// requested nodes and edges
+func (t *ReplicationEndpoint) onDigestRepair(c ws.Speaker, repair *DigestRepairMsg) {
+	var repaired int64
+	count := func(updated bool, err error) {
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to repair graph element from %s: %s", c.GetURL().String(), err)
+		} else if updated {
+			repaired++
+		}
+	}
+
+	// nodes first as the edges need their parent and child
+	for _, n := range repair.Nodes {
+		count(t.repairNode(n))
+	}
+	for _, e := range repair.Edges {
+		count(t.repairEdge(e))
+	}
+	for _, deletion := range repair.Deleted {
+		count(t.repairDeletion(deletion))
+	}
+
+	if repaired > 0 {
+		logging.GetLogger().Infof("Repaired %d graph elements from %s", repaired, c.GetURL().String())
+		t.updateStatus(c.GetRemoteHost(), func(status *ReplicationStatus) {
+			status.Repaired += repaired
+		})
+	}
+
+	if len(repair.Request) == 0 {
+		return
+	}
+
+	reply := &DigestRepairMsg{}
+	for _, id := range repair.Request {
+		if n := t.Graph.GetNode(id); n != nil {
+			reply.Nodes = append(reply.Nodes, n)
+		} else if e := t.Graph.GetEdge(id); e != nil {
+			reply.Edges = append(reply.Edges, e)
+		} else if tombstone, found := t.getTombstone(id); found {
+			reply.Deleted = append(reply.Deleted, tombstone)
+		}
+	}
+
+	c.SendMessage(gws.NewStructMessage(DigestRepairMsgType, reply))
+}
+
+// onAntiEntropyMessage handles the anti-entropy messages. The graph lock
+// has to be held.
+func (t *ReplicationEndpoint) onAntiEntropyMessage(c ws.Speaker, msg *ws.StructMessage) error {
+	switch msg.Type {
+	case DigestMsgType:
+		var digest DigestMsg
+		if err := json.Unmarshal(msg.Obj, &digest); err != nil {
+			return err
+		}
+		t.onDigest(c, &digest)
+	case DigestBucketsMsgType:
+		var buckets DigestBucketsMsg
+		if err := json.Unmarshal(msg.Obj, &buckets); err != nil {
+			return err
+		}
+		t.onDigestBuckets(c, &buckets)
+	case DigestRepairMsgType:
+		var repair DigestRepairMsg
+		if err := json.Unmarshal(msg.Obj, &repair); err != nil {
+			return err
+		}
+		t.onDigestRepair(c, &repair)
+	}
+
+	return nil
+}
@@ -0,0 +1,327 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package hub
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/graffiti/graph"
+	gws "github.com/skydive-project/skydive/graffiti/websocket"
+	ws "github.com/skydive-project/skydive/websocket"
+)
+
+// fakePeer is the connection to a peer, keeping the messages sent to it
+type fakePeer struct {
+	ws.Speaker
+	host     string
+	messages []*ws.StructMessage
+}
+
+func (p *fakePeer) GetRemoteHost() string { return p.host }
+func (p *fakePeer) GetURL() *url.URL      { return &url.URL{Scheme: "ws", Host: p.host} }
+
+func (p *fakePeer) SendMessage(m ws.Message) error {
+	p.messages = append(p.messages, m.(*ws.StructMessage))
+	return nil
+}
+
+// deliver decodes the messages sent to a peer, as received from the wire,
+// and passes them to its endpoint
+func (p *fakePeer) deliver(t *testing.T, endpoint *ReplicationEndpoint, from *fakePeer) {
+	messages := p.messages
+	p.messages = nil
+
+	for _, m := range messages {
+		data, err := m.Bytes(ws.JSONProtocol)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var msg ws.StructMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+
+		endpoint.OnStructMessage(from, &msg)
+	}
+}
+
+func newTestReplicationEndpoint(t *testing.T, host string) *ReplicationEndpoint {
+	cached, err := graph.NewCachedBackend(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := graph.NewGraph(host, cached, common.AnalyzerService)
+
+	endpoint := &ReplicationEndpoint{
+		Graph:        g,
+		cached:       cached,
+		in:           ws.NewStructClientPool("in", ws.PoolOpts{}),
+		out:          ws.NewStructClientPool("out", ws.PoolOpts{}),
+		peerStates:   make(map[string]*peerState),
+		tombstoneTTL: time.Hour,
+		tombstones:   make(map[graph.Identifier]DigestEntry),
+		statuses:     make(map[string]*ReplicationStatus),
+	}
+	endpoint.replicateMsg.Store(true)
+	g.AddEventListener(endpoint)
+
+	return endpoint
+}
+
+// antiEntropyRound runs an anti-entropy round initiated by the hub a,
+// exchanging the messages with the hub b until both are done
+func antiEntropyRound(t *testing.T, a, b *ReplicationEndpoint) {
+	// the connection of b to a and the one of a to b
+	toA, toB := &fakePeer{host: "hubA"}, &fakePeer{host: "hubB"}
+
+	a.Graph.RLock()
+	toB.SendMessage(gws.NewStructMessage(DigestMsgType, a.digest()))
+	a.Graph.RUnlock()
+
+	for i := 0; len(toA.messages) > 0 || len(toB.messages) > 0; i++ {
+		if i == 5 {
+			t.Fatal("The anti-entropy round should be over")
+		}
+		toB.deliver(t, b, toA)
+		toA.deliver(t, a, toB)
+	}
+}
+
+func addTestNode(t *testing.T, endpoint *ReplicationEndpoint, id string, revision int64, createdAt, updatedAt time.Time, state string) {
+	n := graph.CreateNode(graph.Identifier(id), graph.Metadata{"Name": id, "State": state}, graph.Time(createdAt), "host1", common.AgentService)
+	n.UpdatedAt = graph.Time(updatedAt)
+	n.Revision = revision
+
+	endpoint.Graph.Lock()
+	defer endpoint.Graph.Unlock()
+
+	if err := endpoint.Graph.NodeAdded(n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func addTestEdge(t *testing.T, endpoint *ReplicationEndpoint, id, parent, child string, createdAt time.Time) {
+	endpoint.Graph.Lock()
+	defer endpoint.Graph.Unlock()
+
+	p, c := endpoint.Graph.GetNode(graph.Identifier(parent)), endpoint.Graph.GetNode(graph.Identifier(child))
+	e := graph.CreateEdge(graph.Identifier(id), p, c, graph.Metadata{"RelationType": "layer2"}, graph.Time(createdAt), "host1", common.AgentService)
+
+	if err := endpoint.Graph.EdgeAdded(e); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func delTestNode(t *testing.T, endpoint *ReplicationEndpoint, id string, deletedAt time.Time) {
+	endpoint.Graph.Lock()
+	defer endpoint.Graph.Unlock()
+
+	n := endpoint.Graph.GetNode(graph.Identifier(id))
+	n.DeletedAt = graph.Time(deletedAt)
+	if err := endpoint.Graph.NodeDeleted(n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nodeState returns the state of a node, an empty string if not found
+func nodeState(endpoint *ReplicationEndpoint, id string) string {
+	endpoint.Graph.RLock()
+	defer endpoint.Graph.RUnlock()
+
+	if n := endpoint.Graph.GetNode(graph.Identifier(id)); n != nil {
+		state, _ := n.GetFieldString("State")
+		return state
+	}
+	return ""
+}
+
+func TestDigestEntrySupersedes(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		entry    DigestEntry
+		other    DigestEntry
+		expected bool
+	}{
+		{"A newer revision", DigestEntry{CreatedAt: 1, Revision: 3, UpdatedAt: 10}, DigestEntry{CreatedAt: 1, Revision: 2, UpdatedAt: 20}, true},
+		{"An older revision", DigestEntry{CreatedAt: 1, Revision: 2, UpdatedAt: 20}, DigestEntry{CreatedAt: 1, Revision: 3, UpdatedAt: 10}, false},
+		{"A later update of the same revision", DigestEntry{CreatedAt: 1, Revision: 2, UpdatedAt: 20}, DigestEntry{CreatedAt: 1, Revision: 2, UpdatedAt: 10}, true},
+		{"A re-created element", DigestEntry{CreatedAt: 5, Revision: 1, UpdatedAt: 5}, DigestEntry{CreatedAt: 1, Revision: 4, UpdatedAt: 4}, true},
+		{"The same version", DigestEntry{CreatedAt: 1, Revision: 2, UpdatedAt: 10}, DigestEntry{CreatedAt: 1, Revision: 2, UpdatedAt: 10}, false},
+		{"A tombstone of an earlier update", DigestEntry{CreatedAt: 1, Revision: 1, UpdatedAt: 1, DeletedAt: 15}, DigestEntry{CreatedAt: 1, Revision: 2, UpdatedAt: 10}, true},
+		{"A tombstone of a late update", DigestEntry{CreatedAt: 1, Revision: 1, UpdatedAt: 1, DeletedAt: 15}, DigestEntry{CreatedAt: 1, Revision: 2, UpdatedAt: 20}, false},
+		{"A late update of a tombstone", DigestEntry{CreatedAt: 1, Revision: 2, UpdatedAt: 20}, DigestEntry{CreatedAt: 1, Revision: 1, UpdatedAt: 1, DeletedAt: 15}, true},
+		{"An earlier update of a tombstone", DigestEntry{CreatedAt: 1, Revision: 2, UpdatedAt: 10}, DigestEntry{CreatedAt: 1, Revision: 1, UpdatedAt: 1, DeletedAt: 15}, false},
+		{"A tombstone of a tombstone", DigestEntry{DeletedAt: 20}, DigestEntry{DeletedAt: 15}, false},
+	} {
+		if superseded := test.entry.supersedes(&test.other); superseded != test.expected {
+			t.Errorf("%s should supersede: %t, got %t", test.name, test.expected, superseded)
+		}
+	}
+}
+
+func TestTombstoneExpiry(t *testing.T) {
+	endpoint := newTestReplicationEndpoint(t, "hubA")
+	endpoint.tombstoneTTL = time.Minute
+
+	createdAt := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	addTestNode(t, endpoint, "node1", 1, createdAt, createdAt, "up")
+	delTestNode(t, endpoint, "node1", time.Now().Add(-2*time.Minute))
+
+	addTestNode(t, endpoint, "node2", 1, createdAt, createdAt, "up")
+	delTestNode(t, endpoint, "node2", time.Now())
+
+	// a peer not aware of the deletion sends the node back
+	repair := func(id string) bool {
+		n := graph.CreateNode(graph.Identifier(id), graph.Metadata{"Name": id}, graph.Time(createdAt), "host1", common.AgentService)
+
+		endpoint.Graph.Lock()
+		defer endpoint.Graph.Unlock()
+
+		repaired, err := endpoint.repairNode(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repaired
+	}
+
+	if repair("node1") {
+		t.Error("A deleted node should not be re-added while its tombstone is kept")
+	}
+
+	endpoint.pruneTombstones()
+
+	if _, found := endpoint.getTombstone("node1"); found {
+		t.Error("An expired tombstone should be pruned")
+	}
+	if _, found := endpoint.getTombstone("node2"); !found {
+		t.Error("A recent tombstone should be kept")
+	}
+
+	if !repair("node1") {
+		t.Error("A node should be re-added once its tombstone expired")
+	}
+	if repair("node2") {
+		t.Error("A deleted node should not be re-added while its tombstone is kept")
+	}
+}
+
+func TestAntiEntropyRepair(t *testing.T) {
+	a, b := newTestReplicationEndpoint(t, "hubA"), newTestReplicationEndpoint(t, "hubB")
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	at := func(seconds int) time.Time { return base.Add(time.Duration(seconds) * time.Second) }
+
+	// same version on both hubs
+	addTestNode(t, a, "node1", 1, base, base, "up")
+	addTestNode(t, b, "node1", 1, base, base, "up")
+
+	// node and edge missing on b
+	addTestNode(t, a, "node2", 1, base, base, "up")
+	addTestEdge(t, a, "edge1", "node1", "node2", base)
+
+	// newer revision on b
+	addTestNode(t, a, "node3", 1, base, base, "old")
+	addTestNode(t, b, "node3", 3, base, at(1), "new")
+
+	// newer revision on a
+	addTestNode(t, a, "node4", 2, base, at(1), "new")
+	addTestNode(t, b, "node4", 1, base, base, "old")
+
+	// deleted on a after the last update known by b
+	addTestNode(t, a, "node5", 1, base, base, "up")
+	addTestNode(t, b, "node5", 1, base, base, "up")
+	delTestNode(t, a, "node5", at(5))
+
+	// deleted on a but updated later on b
+	addTestNode(t, a, "node6", 1, base, base, "up")
+	addTestNode(t, b, "node6", 2, base, at(10), "late")
+	delTestNode(t, a, "node6", at(5))
+
+	// node missing on a
+	addTestNode(t, b, "node7", 1, base, base, "up")
+
+	antiEntropyRound(t, a, b)
+
+	a.Graph.RLock()
+	rootA := a.digest().Root
+	a.Graph.RUnlock()
+
+	b.Graph.RLock()
+	rootB := b.digest().Root
+	edge := b.Graph.GetEdge("edge1")
+	b.Graph.RUnlock()
+
+	if rootA != rootB {
+		t.Error("The digests of the hubs should match once repaired")
+	}
+
+	if nodeState(b, "node2") != "up" || edge == nil {
+		t.Error("The node and the edge missing on b should have been sent by a")
+	}
+
+	if state := nodeState(a, "node3"); state != "new" {
+		t.Errorf("The newer revision of b should win, got '%s' on a", state)
+	}
+
+	if state := nodeState(b, "node4"); state != "new" {
+		t.Errorf("The newer revision of a should win, got '%s' on b", state)
+	}
+
+	if nodeState(b, "node5") != "" {
+		t.Error("The deletion of a node should win over the earlier updates")
+	}
+
+	if state := nodeState(a, "node6"); state != "late" {
+		t.Errorf("An update done after the deletion should win over the tombstone, got '%s' on a", state)
+	}
+	if _, found := a.getTombstone("node6"); found {
+		t.Error("The tombstone of a re-added node should be removed")
+	}
+
+	if nodeState(a, "node7") != "up" {
+		t.Error("The node missing on a should have been requested to b")
+	}
+
+	status := a.ReplicationStatus()["hubB"]
+	if status.Consistent || status.DivergentBuckets == 0 {
+		t.Errorf("The divergence of the hubs should be reported, got %+v", status)
+	}
+	if status.Repaired != 3 {
+		t.Errorf("Expected 3 elements repaired on a, got %d", status.Repaired)
+	}
+
+	if repaired := b.ReplicationStatus()["hubA"].Repaired; repaired != 4 {
+		t.Errorf("Expected 4 elements repaired on b, got %d", repaired)
+	}
+
+	// the next round finds the hubs consistent
+	antiEntropyRound(t, a, b)
+
+	if status := a.ReplicationStatus()["hubB"]; !status.Consistent || status.DivergentBuckets != 0 {
+		t.Errorf("The hubs should be consistent after the repair, got %+v", status)
+	}
+	if status := b.ReplicationStatus()["hubA"]; !status.Consistent {
+		t.Errorf("The hubs should be consistent after the repair, got %+v", status)
+	}
+}
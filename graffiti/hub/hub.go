@@ -30,8 +30,12 @@ import (
 
 // Opts Hub options
 type Opts struct {
-	ServerOpts websocket.ServerOpts
-	Validator  validator.Validator
+	ServerOpts  websocket.ServerOpts
+	Validator   validator.Validator
+	ScopeFilter gc.ScopeFilter
+	// PublisherAudit is called for each mutation requested through
+	// the publisher endpoint
+	PublisherAudit gc.PublisherAudit
 }
 
 // Hub describes a graph hub that accepts incoming connections
@@ -49,8 +53,9 @@ type Hub struct {
 
 // PeersStatus describes the state of a peer
 type PeersStatus struct {
-	Incomers map[string]websocket.ConnStatus
-	Outgoers map[string]websocket.ConnStatus
+	Incomers    map[string]websocket.ConnStatus
+	Outgoers    map[string]websocket.ConnStatus
+	Replication map[string]ReplicationStatus
 }
 
 // Status describes the status of a hub
@@ -64,8 +69,9 @@ type Status struct {
 // GetStatus returns the status of a hub
 func (h *Hub) GetStatus() *Status {
 	peersStatus := PeersStatus{
-		Incomers: make(map[string]websocket.ConnStatus),
-		Outgoers: make(map[string]websocket.ConnStatus),
+		Incomers:    make(map[string]websocket.ConnStatus),
+		Outgoers:    make(map[string]websocket.ConnStatus),
+		Replication: h.replicationEndpoint.ReplicationStatus(),
 	}
 
 	for _, speaker := range h.replicationEndpoint.in.GetSpeakers() {
@@ -96,6 +102,7 @@ func (h *Hub) Start() {
 // Stop the hub
 func (h *Hub) Stop() {
 	h.podWSServer.Stop()
+	h.replicationEndpoint.DisconnectPeers()
 	h.replicationWSServer.Stop()
 	h.publisherWSServer.Stop()
 	h.subscriberWSServer.Stop()
@@ -124,10 +131,11 @@ func NewHub(server *shttp.Server, g *graph.Graph, cached *graph.CachedBackend, a
 	}
 
 	publisherWSServer := websocket.NewStructServer(newWSServer("/ws/publisher", apiAuthBackend))
-	_, err = gc.NewPublisherEndpoint(publisherWSServer, g, opts.Validator)
+	publisherEndpoint, err := gc.NewPublisherEndpoint(publisherWSServer, g, opts.Validator)
 	if err != nil {
 		return nil, err
 	}
+	publisherEndpoint.SetAudit(opts.PublisherAudit)
 
 	replicationWSServer := websocket.NewStructServer(newWSServer("/ws/replication", clusterAuthBackend))
 	replicationEndpoint, err := NewReplicationEndpoint(replicationWSServer, clusterAuthOptions, cached, g, peers)
@@ -140,7 +148,8 @@ func NewHub(server *shttp.Server, g *graph.Graph, cached *graph.CachedBackend, a
 	tr.AddTraversalExtension(ge.NewDescendantsTraversalExtension())
 
 	subscriberWSServer := websocket.NewStructServer(newWSServer("/ws/subscriber", apiAuthBackend))
-	gc.NewSubscriberEndpoint(subscriberWSServer, g, tr)
+	subscriberEndpoint := gc.NewSubscriberEndpoint(subscriberWSServer, g, tr)
+	subscriberEndpoint.SetScopeFilter(opts.ScopeFilter)
 
 	return &Hub{
 		server:              server,
@@ -21,6 +21,7 @@ import (
 	"net/url"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/config"
@@ -61,6 +62,17 @@ type ReplicationEndpoint struct {
 	cached       *graph.CachedBackend
 	replicateMsg atomic.Value
 	wg           sync.WaitGroup
+	// anti-entropy
+	antiEntropyInterval time.Duration
+	tombstoneTTL        time.Duration
+	tombstones          map[graph.Identifier]DigestEntry
+	tombstonesLock      sync.Mutex
+	statuses            map[string]*ReplicationStatus
+	statusLock          sync.Mutex
+	quit                chan bool
+	// set while deleting the resources of a disconnected peer, these
+	// deletions are not recorded as tombstones
+	cleaning bool
 }
 
 func (t *ReplicationEndpoint) debug() bool {
@@ -127,12 +139,11 @@ func (p *ReplicatorPeer) OnDisconnected(c ws.Speaker) {
 
 	logging.GetLogger().Debugf("Peer unregistered, delete resources of %s", origin)
 
-	p.Graph.Lock()
-	gcommon.DelSubGraphOfOrigin(p.Graph, origin)
-	p.Graph.Unlock()
+	p.endpoint.delSubGraphOfPeer(origin)
 
 	p.endpoint.out.RemoveClient(c)
 	delete(p.endpoint.peerStates, host)
+	p.endpoint.delStatus(host)
 }
 
 func (p *ReplicatorPeer) connect(wg *sync.WaitGroup) {
@@ -186,6 +197,11 @@ func (t *ReplicationEndpoint) ConnectPeers() {
 		}
 		go candidate.connect(&t.wg)
 	}
+
+	if t.antiEntropyInterval > 0 && t.quit == nil {
+		t.quit = make(chan bool)
+		go t.antiEntropy(t.quit)
+	}
 }
 
 // DisconnectPeers disconnects all the peers and wait until all disconnected.
@@ -200,6 +216,11 @@ func (t *ReplicationEndpoint) DisconnectPeers() {
 		candidate.disconnect()
 	}
 	t.wg.Wait()
+
+	if t.quit != nil {
+		close(t.quit)
+		t.quit = nil
+	}
 }
 
 // OnStructMessage is triggered by message coming from an other peer.
@@ -218,6 +239,10 @@ func (t *ReplicationEndpoint) OnStructMessage(c ws.Speaker, msg *ws.StructMessag
 	t.Graph.Lock()
 	defer t.Graph.Unlock()
 
+	if msgType == "" {
+		msgType = msg.Type
+	}
+
 	t.replicateMsg.Store(false)
 	defer t.replicateMsg.Store(true)
 
@@ -262,6 +287,15 @@ func (t *ReplicationEndpoint) OnStructMessage(c ws.Speaker, msg *ws.StructMessag
 		}
 	case gws.EdgeAddedMsgType:
 		err = t.Graph.EdgeAdded(obj.(*graph.Edge))
+	case DigestMsgType, DigestBucketsMsgType, DigestRepairMsgType:
+		err = t.onAntiEntropyMessage(c, msg)
+	}
+
+	switch msgType {
+	case gws.NodeAddedMsgType, gws.NodeUpdatedMsgType:
+		t.updateLag(c.GetRemoteHost(), obj.(*graph.Node).UpdatedAt)
+	case gws.EdgeAddedMsgType, gws.EdgeUpdatedMsgType:
+		t.updateLag(c.GetRemoteHost(), obj.(*graph.Edge).UpdatedAt)
 	}
 
 	if err != nil {
@@ -269,6 +303,12 @@ func (t *ReplicationEndpoint) OnStructMessage(c ws.Speaker, msg *ws.StructMessag
 	}
 }
 
+func (t *ReplicationEndpoint) updateLag(host string, updatedAt graph.Time) {
+	t.updateStatus(host, func(status *ReplicationStatus) {
+		status.Lag = nowMillis() - updatedAt.Unix()
+	})
+}
+
 // SendToPeers sends the message to all the peers
 func (t *ReplicationEndpoint) notifyPeers(msg *ws.StructMessage) {
 	if t.debug() {
@@ -290,6 +330,8 @@ func (t *ReplicationEndpoint) OnNodeUpdated(n *graph.Node) {
 
 // OnNodeAdded graph node added event. Implements the EventListener interface.
 func (t *ReplicationEndpoint) OnNodeAdded(n *graph.Node) {
+	t.delTombstone(n.ID)
+
 	if t.replicateMsg.Load() == true {
 		msg := gws.NewStructMessage(gws.NodeAddedMsgType, n)
 		t.notifyPeers(msg)
@@ -298,6 +340,12 @@ func (t *ReplicationEndpoint) OnNodeAdded(n *graph.Node) {
 
 // OnNodeDeleted graph node deleted event. Implements the EventListener interface.
 func (t *ReplicationEndpoint) OnNodeDeleted(n *graph.Node) {
+	if !t.cleaning {
+		entry := nodeEntry(n)
+		entry.DeletedAt = n.DeletedAt.Unix()
+		t.addTombstone(entry)
+	}
+
 	if t.replicateMsg.Load() == true {
 		msg := gws.NewStructMessage(gws.NodeDeletedMsgType, n)
 		t.notifyPeers(msg)
@@ -314,6 +362,8 @@ func (t *ReplicationEndpoint) OnEdgeUpdated(e *graph.Edge) {
 
 // OnEdgeAdded graph edge added event. Implements the EventListener interface.
 func (t *ReplicationEndpoint) OnEdgeAdded(e *graph.Edge) {
+	t.delTombstone(e.ID)
+
 	if t.replicateMsg.Load() == true {
 		msg := gws.NewStructMessage(gws.EdgeAddedMsgType, e)
 		t.notifyPeers(msg)
@@ -322,6 +372,12 @@ func (t *ReplicationEndpoint) OnEdgeAdded(e *graph.Edge) {
 
 // OnEdgeDeleted graph edge deleted event. Implements the EventListener interface.
 func (t *ReplicationEndpoint) OnEdgeDeleted(e *graph.Edge) {
+	if !t.cleaning {
+		entry := edgeEntry(e)
+		entry.DeletedAt = e.DeletedAt.Unix()
+		t.addTombstone(entry)
+	}
+
 	if t.replicateMsg.Load() == true {
 		msg := gws.NewStructMessage(gws.EdgeDeletedMsgType, e)
 		t.notifyPeers(msg)
@@ -387,21 +443,38 @@ func (t *ReplicationEndpoint) OnDisconnected(c ws.Speaker) {
 
 	logging.GetLogger().Debugf("Peer unregistered, delete resources of %s", origin)
 
+	t.delSubGraphOfPeer(origin)
+
+	delete(t.peerStates, host)
+	t.delStatus(host)
+}
+
+func (t *ReplicationEndpoint) delSubGraphOfPeer(origin string) {
 	t.Graph.Lock()
+	t.cleaning = true
 	gcommon.DelSubGraphOfOrigin(t.Graph, origin)
+	t.cleaning = false
 	t.Graph.Unlock()
+}
 
-	delete(t.peerStates, host)
+func (t *ReplicationEndpoint) delStatus(host string) {
+	t.statusLock.Lock()
+	delete(t.statuses, host)
+	t.statusLock.Unlock()
 }
 
 // NewReplicationEndpoint returns a new server to be used by other analyzers for replication.
 func NewReplicationEndpoint(pool ws.StructSpeakerPool, auth *shttp.AuthenticationOpts, cached *graph.CachedBackend, g *graph.Graph, peers []common.ServiceAddress) (*ReplicationEndpoint, error) {
 	t := &ReplicationEndpoint{
-		Graph:      g,
-		cached:     cached,
-		in:         pool,
-		out:        ws.NewStructClientPool("ReplicationEndpoint", ws.PoolOpts{}),
-		peerStates: make(map[string]*peerState),
+		Graph:               g,
+		cached:              cached,
+		in:                  pool,
+		out:                 ws.NewStructClientPool("ReplicationEndpoint", ws.PoolOpts{}),
+		peerStates:          make(map[string]*peerState),
+		antiEntropyInterval: time.Duration(config.GetInt("analyzer.replication.anti_entropy_interval")) * time.Second,
+		tombstoneTTL:        time.Duration(config.GetInt("analyzer.replication.tombstone_ttl")) * time.Second,
+		tombstones:          make(map[graph.Identifier]DigestEntry),
+		statuses:            make(map[string]*ReplicationStatus),
 	}
 	t.replicateMsg.Store(true)
 
@@ -24,6 +24,7 @@ import (
 	"github.com/skydive-project/skydive/graffiti/graph/traversal"
 	"github.com/skydive-project/skydive/graffiti/validator"
 	shttp "github.com/skydive-project/skydive/http"
+	"github.com/skydive-project/skydive/spool"
 	"github.com/skydive-project/skydive/websocket"
 )
 
@@ -31,6 +32,8 @@ import (
 type Opts struct {
 	ServerOpts websocket.ServerOpts
 	Validator  validator.Validator
+	// spool keeping the topology events while no hub is reachable
+	TopologySpool *spool.Spool
 }
 
 // Pod describes a graph pod. It maintains a local graph
@@ -108,6 +111,9 @@ func NewPod(server *api.Server, clientPool *websocket.StructClientPool, g *graph
 	common.NewSubscriberEndpoint(subscriberWSServer, g, tr)
 
 	forwarder := common.NewForwarder(g, clientPool)
+	if opts.TopologySpool != nil {
+		forwarder.SetSpool(opts.TopologySpool)
+	}
 
 	publisherWSServer := websocket.NewStructServer(newWSServer("/ws/publisher", apiAuthBackend))
 	if _, err := common.NewPublisherEndpoint(publisherWSServer, g, opts.Validator); err != nil {
@@ -50,6 +50,7 @@ type Seed struct {
 	g          *graph.Graph
 	logger     logging.Logger
 	listeners  []EventHandler
+	resyncing  bool
 }
 
 // OnConnected websocket listener
@@ -85,11 +86,15 @@ func (s *Seed) OnStructMessage(c ws.Speaker, msg *ws.StructMessage) {
 
 		s.g.DelNodes(graph.Metadata{"Origin": origin})
 
+		// the nodes and edges already known are updated as the sync
+		// may be a re-sync of a graph out of sync
 		for _, n := range r.Nodes {
 			if s.g.GetNode(n.ID) == nil {
 				if err := s.g.NodeAdded(n); err != nil {
 					s.logger.Errorf("%s, %+v", err, n)
 				}
+			} else if err := s.g.NodeUpdated(n); err != nil {
+				s.logger.Errorf("%s, %+v", err, n)
 			}
 		}
 		for _, e := range r.Edges {
@@ -97,8 +102,12 @@ func (s *Seed) OnStructMessage(c ws.Speaker, msg *ws.StructMessage) {
 				if err := s.g.EdgeAdded(e); err != nil {
 					s.logger.Errorf("%s, %+v", err, e)
 				}
+			} else if err := s.g.EdgeUpdated(e); err != nil && err != graph.ErrEdgeNotFound {
+				s.logger.Errorf("%s, %+v", err, e)
 			}
 		}
+
+		s.resyncing = false
 		for _, listener := range s.listeners {
 			listener.OnSynchronized()
 		}
@@ -116,6 +125,18 @@ func (s *Seed) OnStructMessage(c ws.Speaker, msg *ws.StructMessage) {
 		}
 	case gws.EdgeAddedMsgType:
 		err = s.g.EdgeAdded(obj.(*graph.Edge))
+	case gws.NodePartiallyUpdatedMsgType:
+		pu := obj.(*gws.PartiallyUpdatedMsg)
+		if err = s.g.NodePartiallyUpdated(pu.ID, pu.Revision, pu.UpdatedAt, pu.Ops); err == graph.ErrOutOfSync || err == graph.ErrNodeNotFound {
+			s.requestResync()
+			return
+		}
+	case gws.EdgePartiallyUpdatedMsgType:
+		pu := obj.(*gws.PartiallyUpdatedMsg)
+		if err = s.g.EdgePartiallyUpdated(pu.ID, pu.Revision, pu.UpdatedAt, pu.Ops); err == graph.ErrOutOfSync || err == graph.ErrEdgeNotFound {
+			s.requestResync()
+			return
+		}
 	}
 
 	if err != nil {
@@ -123,6 +144,18 @@ func (s *Seed) OnStructMessage(c ws.Speaker, msg *ws.StructMessage) {
 	}
 }
 
+// requestResync requests the whole graph when a partial update can not be
+// applied, unless a re-sync is already pending
+func (s *Seed) requestResync() {
+	if s.resyncing {
+		return
+	}
+	s.resyncing = true
+
+	s.logger.Infof("graph out of sync, request a re-sync")
+	s.subscriber.SendMessage(gws.NewStructMessage(gws.SyncRequestMsgType, gws.SyncRequestMsg{}))
+}
+
 // AddEventHandler register an event handler
 func (s *Seed) AddEventHandler(handler EventHandler) {
 	s.listeners = append(s.listeners, handler)
@@ -171,7 +204,8 @@ func NewSeed(g *graph.Graph, clientType common.ServiceType, address, filter stri
 	}
 
 	headers = http.Header{
-		"X-Gremlin-Filter": {filter},
+		"X-Gremlin-Filter":  {filter},
+		"X-Partial-Updates": {"true"},
 	}
 
 	pool := ws.NewStructClientPool("publisher", ws.PoolOpts{Logger: logger})
@@ -63,15 +63,90 @@ type SyncMsg struct {
 	*graph.Elements
 }
 
-// PartiallyUpdatedMsg describes multiple graph modifications
+// PartiallyUpdatedMsg describes multiple graph modifications. The operations
+// apply to the revision preceding Revision, a Revision of 0 meaning that they
+// apply to any revision.
 type PartiallyUpdatedMsg struct {
-	ID  graph.Identifier
-	Ops []graph.PartiallyUpdatedOp
+	ID        graph.Identifier
+	Revision  int64      `json:",omitempty"`
+	UpdatedAt graph.Time `json:",omitempty"`
+	Ops       []graph.PartiallyUpdatedOp
 }
 
-// NewStructMessage returns a new graffiti websocket StructMessage
+// NewNodePartiallyUpdatedMsg returns the message of a node partial update
+func NewNodePartiallyUpdatedMsg(n *graph.Node, ops []graph.PartiallyUpdatedOp) *PartiallyUpdatedMsg {
+	return &PartiallyUpdatedMsg{ID: n.ID, Revision: n.Revision, UpdatedAt: n.UpdatedAt, Ops: ops}
+}
+
+// NewEdgePartiallyUpdatedMsg returns the message of an edge partial update
+func NewEdgePartiallyUpdatedMsg(e *graph.Edge, ops []graph.PartiallyUpdatedOp) *PartiallyUpdatedMsg {
+	return &PartiallyUpdatedMsg{ID: e.ID, Revision: e.Revision, UpdatedAt: e.UpdatedAt, Ops: ops}
+}
+
+// UnmarshalPartiallyUpdatedMsg decodes a partial update message, the values
+// of the operations being decoded like the metadata of a node or an edge
+func UnmarshalPartiallyUpdatedMsg(b []byte, decoders map[string]graph.MetadataDecoder) (*PartiallyUpdatedMsg, error) {
+	raw := struct {
+		ID        graph.Identifier
+		Revision  int64
+		UpdatedAt graph.Time
+		Ops       []struct {
+			Type  graph.PartiallyUpdatedOpType
+			Key   string
+			Value json.RawMessage
+		}
+	}{}
+
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+
+	pu := &PartiallyUpdatedMsg{
+		ID:        raw.ID,
+		Revision:  raw.Revision,
+		UpdatedAt: raw.UpdatedAt,
+		Ops:       make([]graph.PartiallyUpdatedOp, len(raw.Ops)),
+	}
+
+	for i, op := range raw.Ops {
+		pu.Ops[i] = graph.PartiallyUpdatedOp{Type: op.Type, Key: op.Key}
+		if op.Type == graph.PartiallyUpdatedAddOpType && len(op.Value) != 0 {
+			value, err := graph.DecodeMetadataValue(op.Key, op.Value, decoders)
+			if err != nil {
+				return nil, err
+			}
+			pu.Ops[i].Value = value
+		}
+	}
+
+	return pu, nil
+}
+
+// NewStructMessage returns a new graffiti websocket StructMessage. The
+// messages about a node or an edge are keyed by its ID so that, when batched,
+// successive updates of the same node or edge are coalesced.
 func NewStructMessage(typ string, i interface{}) *ws.StructMessage {
-	return ws.NewStructMessage(Namespace, typ, i)
+	msg := ws.NewStructMessage(Namespace, typ, i)
+
+	switch obj := i.(type) {
+	case *graph.Node:
+		msg.SetBatchKey("Node/"+string(obj.ID), typ == NodeUpdatedMsgType)
+	case *graph.Edge:
+		msg.SetBatchKey("Edge/"+string(obj.ID), typ == EdgeUpdatedMsgType)
+	case PartiallyUpdatedMsg:
+		msg.SetBatchKey(partiallyUpdatedKey(typ, obj.ID), false)
+	case *PartiallyUpdatedMsg:
+		msg.SetBatchKey(partiallyUpdatedKey(typ, obj.ID), false)
+	}
+
+	return msg
+}
+
+func partiallyUpdatedKey(typ string, id graph.Identifier) string {
+	if typ == EdgePartiallyUpdatedMsgType {
+		return "Edge/" + string(id)
+	}
+	return "Node/" + string(id)
 }
 
 // UnmarshalJSON custom unmarshal function
@@ -120,13 +195,18 @@ func UnmarshalMessage(msg *ws.StructMessage) (string, interface{}, error) {
 			return "", msg, err
 		}
 		return msg.Type, &edge, nil
-	case NodePartiallyUpdatedMsgType, EdgePartiallyUpdatedMsgType:
-		var pu PartiallyUpdatedMsg
-		if err := json.Unmarshal(msg.Obj, &pu); err != nil {
+	case NodePartiallyUpdatedMsgType:
+		pu, err := UnmarshalPartiallyUpdatedMsg(msg.Obj, graph.NodeMetadataDecoders)
+		if err != nil {
 			return "", msg, err
 		}
-
-		return msg.Type, &pu, nil
+		return msg.Type, pu, nil
+	case EdgePartiallyUpdatedMsgType:
+		pu, err := UnmarshalPartiallyUpdatedMsg(msg.Obj, graph.EdgeMetadataDecoders)
+		if err != nil {
+			return "", msg, err
+		}
+		return msg.Type, pu, nil
 	}
 
 	return "", msg, nil
@@ -480,3 +480,87 @@ func TestBothStepOp(t *testing.T) {
 		t.Fatalf("Should return 1 result, returned: %v", res.Values())
 	}
 }
+
+// nodesTableClient returns the flows of the requested nodes
+type nodesTableClient struct {
+	flows []*flow.Flow
+}
+
+func (tc *nodesTableClient) LookupFlows(flowSearchQuery filters.SearchQuery) (*flow.FlowSet, error) {
+	fs := flow.NewFlowSet()
+	fs.Flows = append(fs.Flows, tc.flows...)
+	return fs, nil
+}
+
+func (tc *nodesTableClient) LookupFlowsByNodes(hnmap topology.HostNodeTIDMap, flowSearchQuery filters.SearchQuery) (*flow.FlowSet, error) {
+	fs := flow.NewFlowSet()
+	for _, f := range tc.flows {
+		for _, tids := range hnmap {
+			for _, tid := range tids {
+				if f.NodeTID == tid {
+					fs.Flows = append(fs.Flows, f)
+				}
+			}
+		}
+	}
+	return fs, nil
+}
+
+func TestRestrictedGraphFlows(t *testing.T) {
+	flowA, flowB := newICMPFlow(111), newICMPFlow(222)
+	flowA.NodeTID, flowB.NodeTID = "tid-a", "tid-b"
+
+	tc := &nodesTableClient{flows: []*flow.Flow{flowA, flowB}}
+
+	tr := traversal.NewGremlinTraversalParser()
+	tr.AddTraversalExtension(NewFlowTraversalExtension(tc, nil))
+
+	g := newGraph(t)
+	g.NewNode(graph.GenID(), graph.Metadata{"TID": "tid-a", "Type": "veth", "Tenant": "a"}, "host1")
+	g.NewNode(graph.GenID(), graph.Metadata{"TID": "tid-b", "Type": "veth", "Tenant": "b"}, "host1")
+
+	sub, err := tr.Parse(strings.NewReader("G.V().Has('Tenant', 'a').SubGraph()"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenantGraph, err := sub.ExecGraph(g, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	context := tenantGraph.GetContext()
+	context.Restricted = true
+	if tenantGraph, err = tenantGraph.CloneWithContext(context); err != nil {
+		t.Fatal(err)
+	}
+
+	exec := func(g *graph.Graph, query string) []interface{} {
+		ts, err := tr.Parse(strings.NewReader(query))
+		if err != nil {
+			t.Fatalf("%s: %s", query, err)
+		}
+
+		res, err := ts.Exec(g, false)
+		if err != nil {
+			t.Fatalf("%s: %s", query, err)
+		}
+		return res.Values()
+	}
+
+	if values := exec(g, "G.Flows()"); len(values) != 2 {
+		t.Errorf("Should return the flows of all the nodes, returned: %v", values)
+	}
+
+	for _, query := range []string{"G.Flows()", "G.V().Flows()", "G.Flows().Has('ICMP.ID', 222)"} {
+		for _, value := range exec(tenantGraph, query) {
+			if f := value.(*flow.Flow); f.NodeTID != "tid-a" {
+				t.Errorf("%s: should only return the flows of the tenant nodes, returned a flow of %s", query, f.NodeTID)
+			}
+		}
+	}
+
+	if values := exec(tenantGraph, "G.Flows()"); len(values) != 1 {
+		t.Errorf("Should return the flows of the tenant nodes, returned: %v", values)
+	}
+}
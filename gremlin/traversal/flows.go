@@ -603,6 +603,11 @@ func (f *FlowTraversalStep) RawPackets(ctx traversal.StepContext) *RawPacketsTra
 
 	rawPackets := make(map[string][]*flow.RawPacket)
 
+	flowset := f.flowset
+	lookupFlows := func() (*flow.FlowSet, error) {
+		return flowset, nil
+	}
+
 	context := f.GraphTraversal.Graph.GetContext()
 	if context.TimeSlice != nil {
 		// two cases, either we have a flowset and we need to use it in order to filter
@@ -613,6 +618,12 @@ func (f *FlowTraversalStep) RawPackets(ctx traversal.StepContext) *RawPacketsTra
 			f.flowSearchQuery.Filter = filters.NewAndFilter(f.flowSearchQuery.Filter, flowFilter)
 		} else if f.flowSearchQuery.Filter == nil {
 			return &RawPacketsTraversalStep{error: errors.New("Unable to filter flows")}
+		} else {
+			// flows are only retrieved when their nodes are requested
+			flowSearchQuery := f.flowSearchQuery
+			lookupFlows = func() (*flow.FlowSet, error) {
+				return f.Storage.SearchFlows(flowSearchQuery)
+			}
 		}
 
 		fr := filters.Range{To: context.TimeSlice.Last}
@@ -641,7 +652,7 @@ func (f *FlowTraversalStep) RawPackets(ctx traversal.StepContext) *RawPacketsTra
 		}
 	}
 
-	return &RawPacketsTraversalStep{GraphTraversal: f.GraphTraversal, rawPackets: rawPackets}
+	return &RawPacketsTraversalStep{GraphTraversal: f.GraphTraversal, rawPackets: rawPackets, lookupFlows: lookupFlows}
 }
 
 // Sockets returns the sockets at both sides of the specified flows
@@ -871,8 +882,16 @@ func (s *FlowGremlinTraversalStep) Exec(last traversal.GraphTraversalStep) (trav
 	switch tv := last.(type) {
 	case *traversal.GraphTraversal:
 		graphTraversal = tv
+
 		graphTraversal.RLock()
 		context = graphTraversal.Graph.GetContext()
+		// a restricted graph only gives access to the flows of its nodes
+		if context.Restricted {
+			if nodes = captureAllowedNodes(graphTraversal.Graph.GetNodes(nil)); len(nodes) == 0 {
+				graphTraversal.RUnlock()
+				return &FlowTraversalStep{GraphTraversal: graphTraversal, Storage: s.Storage, flowset: flowset, flowSearchQuery: flowSearchQuery}, nil
+			}
+		}
 		graphTraversal.RUnlock()
 	case *traversal.GraphTraversalV:
 		graphTraversal = tv.GraphTraversal
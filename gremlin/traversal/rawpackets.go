@@ -41,6 +41,7 @@ type RawPacketsGremlinTraversalStep struct {
 type RawPacketsTraversalStep struct {
 	GraphTraversal *traversal.GraphTraversal
 	rawPackets     map[string][]*flow.RawPacket
+	lookupFlows    func() (*flow.FlowSet, error)
 	error          error
 }
 
@@ -98,6 +99,28 @@ func (r *RawPacketsTraversalStep) Values() []interface{} {
 	return []interface{}{r.rawPackets}
 }
 
+// NodeTIDs returns the TID of the node the flow of the raw packets were
+// captured on, by flow UUID
+func (r *RawPacketsTraversalStep) NodeTIDs() (map[string]string, error) {
+	nodeTIDs := make(map[string]string)
+	if r.lookupFlows == nil {
+		return nodeTIDs, nil
+	}
+
+	flowset, err := r.lookupFlows()
+	if err != nil || flowset == nil {
+		return nodeTIDs, err
+	}
+
+	for _, fl := range flowset.Flows {
+		if _, found := r.rawPackets[fl.UUID]; found {
+			nodeTIDs[fl.UUID] = fl.NodeTID
+		}
+	}
+
+	return nodeTIDs, nil
+}
+
 // MarshalJSON serialize in JSON
 func (r *RawPacketsTraversalStep) MarshalJSON() ([]byte, error) {
 	values := r.Values()
@@ -151,5 +174,5 @@ func (r *RawPacketsTraversalStep) BPF(ctx traversal.StepContext, s ...interface{
 		rawPackets[key] = filteredPackets
 	}
 
-	return &RawPacketsTraversalStep{GraphTraversal: r.GraphTraversal, rawPackets: rawPackets}
+	return &RawPacketsTraversalStep{GraphTraversal: r.GraphTraversal, rawPackets: rawPackets, lookupFlows: r.lookupFlows}
 }
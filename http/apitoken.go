@@ -0,0 +1,180 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package http
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	auth "github.com/abbot/go-http-auth"
+
+	"github.com/skydive-project/skydive/rbac"
+)
+
+// APITokenPrefix is the prefix of the API tokens, it allows to tell
+// them apart from the passwords and the tokens of the other backends
+const APITokenPrefix = "sdt_"
+
+// APITokenValidator is the interface of the API token store. It returns
+// the service account authenticated by a token and the identifier of
+// the token.
+type APITokenValidator interface {
+	ValidateAPIToken(token string) (account string, id string, err error)
+}
+
+// APITokenAuthenticationBackend adds API tokens authentication to a backend.
+// A token can be used as a bearer token, as the password of its service
+// account or as the authentication cookie. The other credentials are
+// handled by the wrapped backend.
+// The requests authenticated by a token are made by the
+// "sa:<account>/<token>" subject. No role is stored for it, the role of
+// the token is resolved on each request, so that a revoked or expired
+// token does not grant anything anymore.
+type APITokenAuthenticationBackend struct {
+	AuthenticationBackend
+	validator APITokenValidator
+}
+
+// IsAPIToken returns whether a string has the format of an API token
+func IsAPIToken(s string) bool {
+	return strings.HasPrefix(s, APITokenPrefix)
+}
+
+// apiTokenFromRequest returns the API token of a request along with the
+// username specified with it, if any
+func apiTokenFromRequest(r *http.Request) (username string, token string) {
+	if cookie, err := r.Cookie(tokenName); err == nil && IsAPIToken(cookie.Value) {
+		return "", cookie.Value
+	}
+
+	s := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(s) != 2 {
+		return "", ""
+	}
+
+	switch s[0] {
+	case "Bearer":
+		if IsAPIToken(s[1]) {
+			return "", s[1]
+		}
+	case "Basic":
+		b, err := base64.StdEncoding.DecodeString(s[1])
+		if err != nil {
+			return "", ""
+		}
+		if pair := strings.SplitN(string(b), ":", 2); len(pair) == 2 && IsAPIToken(pair[1]) {
+			return pair[0], pair[1]
+		}
+	}
+
+	return "", ""
+}
+
+// authenticateToken validates a token and returns the subject of its
+// service account bound to the token
+func (b *APITokenAuthenticationBackend) authenticateToken(username, token string) (string, error) {
+	account, id, err := b.validator.ValidateAPIToken(token)
+	if err != nil {
+		return "", err
+	}
+
+	if username != "" && username != account && username != rbac.ServiceAccountPrefix+account {
+		return "", ErrWrongCredentials
+	}
+
+	return rbac.ServiceAccountSubject(account, id), nil
+}
+
+// Authenticate accepts an API token as the password of its service account.
+// The users of the wrapped backend can not use the service account subjects.
+func (b *APITokenAuthenticationBackend) Authenticate(username string, password string) (string, error) {
+	if !IsAPIToken(password) {
+		if rbac.IsServiceAccount(username) {
+			return "", ErrWrongCredentials
+		}
+		return b.AuthenticationBackend.Authenticate(username, password)
+	}
+
+	if _, err := b.authenticateToken(username, password); err != nil {
+		return "", err
+	}
+
+	return password, nil
+}
+
+// AuthenticateCredentials accepts an API token, either as token or as the
+// password of its service account. The other credentials are checked by
+// the wrapped backend, its users can not use the service account subjects.
+func (b *APITokenAuthenticationBackend) AuthenticateCredentials(creds *Credentials) (string, error) {
+	if IsAPIToken(creds.Token) {
+		return b.authenticateToken("", creds.Token)
+	}
+
+	if IsAPIToken(creds.Password) {
+		return b.authenticateToken(creds.Username, creds.Password)
+	}
+
+	username, err := b.AuthenticationBackend.AuthenticateCredentials(creds)
+	if err != nil {
+		return "", err
+	}
+
+	if rbac.IsServiceAccount(username) {
+		return "", ErrWrongCredentials
+	}
+
+	return username, nil
+}
+
+// Wrap an HTTP handler with API token authentication, falling back to
+// the wrapped backend when no token is provided
+func (b *APITokenAuthenticationBackend) Wrap(wrapped auth.AuthenticatedHandlerFunc) http.HandlerFunc {
+	fallback := b.AuthenticationBackend.Wrap(func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+		if rbac.IsServiceAccount(r.Username) {
+			Unauthorized(w, &r.Request)
+			return
+		}
+		wrapped(w, r)
+	})
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, token := apiTokenFromRequest(r)
+		if token == "" {
+			fallback(w, r)
+			return
+		}
+
+		subject, err := b.authenticateToken(username, token)
+		if err != nil {
+			Unauthorized(w, r)
+			return
+		}
+
+		authCallWrapped(w, r, subject, wrapped)
+	}
+}
+
+// NewAPITokenBackend returns a backend accepting the API tokens in
+// addition to the credentials of the given backend
+func NewAPITokenBackend(backend AuthenticationBackend, validator APITokenValidator) *APITokenAuthenticationBackend {
+	return &APITokenAuthenticationBackend{
+		AuthenticationBackend: backend,
+		validator:             validator,
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package http
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	auth "github.com/abbot/go-http-auth"
+)
+
+// fakeTokenValidator maps the tokens to their service account
+type fakeTokenValidator map[string]string
+
+func (v fakeTokenValidator) ValidateAPIToken(token string) (string, string, error) {
+	if account, found := v[token]; found {
+		return account, strings.SplitN(strings.TrimPrefix(token, APITokenPrefix), "_", 2)[0], nil
+	}
+	return "", "", ErrWrongCredentials
+}
+
+func TestAPITokenAuthenticate(t *testing.T) {
+	provider := NewHtpasswdMapProvider(map[string]string{"user1": "pass1", "sa:agent": "pass2"})
+
+	basic, err := NewBasicAuthenticationBackend("basic", provider.SecretProvider(), DefaultUserRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewAPITokenBackend(basic, fakeTokenValidator{"sdt_1_secret": "agent"})
+
+	var username string
+	handler := backend.Wrap(func(w http.ResponseWriter, r *auth.AuthenticatedRequest) { username = r.Username })
+
+	call := func(setAuth func(r *http.Request)) int {
+		username = ""
+		w := &fakeResponseWriter{headers: make(http.Header)}
+		r := &http.Request{Header: make(http.Header)}
+		setAuth(r)
+		handler(w, r)
+		return w.status
+	}
+
+	if status := call(func(r *http.Request) { r.Header.Set("Authorization", "Bearer sdt_1_secret") }); status == http.StatusUnauthorized || username != "sa:agent/1" {
+		t.Fatalf("A valid bearer token should be accepted, got status %d and user '%s'", status, username)
+	}
+
+	if status := call(func(r *http.Request) { r.SetBasicAuth("agent", "sdt_1_secret") }); status == http.StatusUnauthorized || username != "sa:agent/1" {
+		t.Fatalf("A valid token used as password should be accepted, got status %d and user '%s'", status, username)
+	}
+
+	if status := call(func(r *http.Request) { r.SetBasicAuth("user1", "sdt_1_secret") }); status != http.StatusUnauthorized {
+		t.Error("A token used with another account should be rejected")
+	}
+
+	if status := call(func(r *http.Request) { r.Header.Set("Authorization", "Bearer sdt_2_secret") }); status != http.StatusUnauthorized {
+		t.Error("An unknown token should be rejected")
+	}
+
+	if status := call(func(r *http.Request) { r.SetBasicAuth("user1", "pass1") }); status == http.StatusUnauthorized || username != "user1" {
+		t.Fatalf("The credentials of the wrapped backend should be accepted, got status %d and user '%s'", status, username)
+	}
+
+	if _, err := backend.Authenticate("sa:agent", "pass2"); err == nil {
+		t.Error("A user of the wrapped backend should not be able to use a service account subject")
+	}
+}
+
+func TestAPITokenAuthenticateCredentials(t *testing.T) {
+	provider := NewHtpasswdMapProvider(map[string]string{"user1": "pass1", "sa:agent": "pass2"})
+
+	basic, err := NewBasicAuthenticationBackend("basic", provider.SecretProvider(), DefaultUserRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewAPITokenBackend(basic, fakeTokenValidator{"sdt_1_secret": "agent"})
+
+	for _, test := range []struct {
+		name     string
+		creds    Credentials
+		username string
+	}{
+		{"A valid token", Credentials{Token: "sdt_1_secret"}, "sa:agent/1"},
+		{"A valid token used as password", Credentials{Username: "agent", Password: "sdt_1_secret"}, "sa:agent/1"},
+		{"A token used with another account", Credentials{Username: "user1", Password: "sdt_1_secret"}, ""},
+		{"An unknown token", Credentials{Token: "sdt_2_secret"}, ""},
+		{"The credentials of the wrapped backend", Credentials{Username: "user1", Password: "pass1"}, "user1"},
+		{"A service account subject of the wrapped backend", Credentials{Username: "sa:agent", Password: "pass2"}, ""},
+	} {
+		username, err := backend.AuthenticateCredentials(&test.creds)
+		if test.username == "" && err == nil {
+			t.Errorf("%s should be rejected, got user '%s'", test.name, username)
+		} else if test.username != "" && (err != nil || username != test.username) {
+			t.Errorf("%s should be accepted as '%s', got user '%s' and error %v", test.name, test.username, username, err)
+		}
+	}
+}
@@ -19,6 +19,7 @@ package http
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -51,6 +52,16 @@ type AuthenticationOpts struct {
 	Cookie   map[string]string
 }
 
+// Credentials describes the credentials of a client not using HTTP, such
+// as a gRPC client. It holds either a username/password couple or a token,
+// along with the state of the TLS connection for the client certificates.
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+	TLS      *tls.ConnectionState
+}
+
 // AuthCookie returns a authentication cookie
 func AuthCookie(token, path string) *http.Cookie {
 	return &http.Cookie{Name: tokenName, Value: token, Path: path}
@@ -85,6 +96,7 @@ type AuthenticationBackend interface {
 	DefaultUserRole(user string) string
 	SetDefaultUserRole(role string)
 	Authenticate(username string, password string) (string, error)
+	AuthenticateCredentials(creds *Credentials) (string, error)
 	Wrap(wrapped auth.AuthenticatedHandlerFunc) http.HandlerFunc
 }
 
@@ -97,6 +109,56 @@ func setPermissionsCookie(w http.ResponseWriter, username string) {
 	})
 }
 
+// roleChanges returns the roles to grant and to revoke so that a user gets
+// the given roles, the default one if none. Only the managed roles, the ones
+// an authentication backend can grant, are revoked.
+func roleChanges(current, roles []string, defaultRole string, managed map[string]bool) (grant, revoke []string) {
+	if len(roles) == 0 {
+		roles = []string{defaultRole}
+	}
+
+	wanted := make(map[string]bool)
+	for _, role := range roles {
+		wanted[role] = true
+	}
+
+	has := make(map[string]bool)
+	for _, role := range current {
+		has[role] = true
+		if !wanted[role] && (managed[role] || role == defaultRole) {
+			revoke = append(revoke, role)
+		}
+	}
+
+	for _, role := range roles {
+		if !has[role] {
+			grant = append(grant, role)
+			has[role] = true
+		}
+	}
+
+	return grant, revoke
+}
+
+// syncRoles grants the given roles to a user, the default role if none, and
+// revokes the managed roles it doesn't have anymore
+func syncRoles(username string, roles []string, defaultRole string, managed map[string]bool) {
+	grant, revoke := roleChanges(rbac.GetUserRoles(username), roles, defaultRole, managed)
+	for _, role := range revoke {
+		rbac.DeleteRoleForUser(username, role)
+	}
+	for _, role := range grant {
+		rbac.AddRoleForUser(username, role)
+	}
+}
+
+// grantDefaultRole grants the default role of a backend to a user without any role
+func grantDefaultRole(backend AuthenticationBackend, username string) {
+	if roles := rbac.GetUserRoles(username); len(roles) == 0 {
+		rbac.AddRoleForUser(username, backend.DefaultUserRole(username))
+	}
+}
+
 func authCallWrapped(w http.ResponseWriter, r *http.Request, username string, wrapped auth.AuthenticatedHandlerFunc) {
 	ar := &auth.AuthenticatedRequest{Request: *r, Username: username}
 	copyRequestVars(r, &ar.Request)
@@ -113,8 +175,13 @@ func Authenticate(backend AuthenticationBackend, w http.ResponseWriter, username
 		return "", err
 	}
 
-	if roles := rbac.GetUserRoles(username); len(roles) == 0 {
-		rbac.AddRoleForUser(username, backend.DefaultUserRole(username))
+	if IsAPIToken(password) {
+		// authenticated as the service account of the token
+		if username, err = backend.AuthenticateCredentials(&Credentials{Username: username, Password: password}); err != nil {
+			return "", err
+		}
+	} else {
+		grantDefaultRole(backend, username)
 	}
 
 	if token != "" {
@@ -20,6 +20,7 @@ package http
 import (
 	"encoding/base64"
 	"net/http"
+	"strings"
 
 	"github.com/abbot/go-http-auth"
 )
@@ -64,6 +65,31 @@ func (b *BasicAuthenticationBackend) Authenticate(username string, password stri
 	return creds, nil
 }
 
+// AuthenticateCredentials returns the user authenticated by its username and
+// password, or by the token returned by Authenticate
+func (b *BasicAuthenticationBackend) AuthenticateCredentials(creds *Credentials) (string, error) {
+	username, password := creds.Username, creds.Password
+	if creds.Token != "" {
+		decoded, err := base64.StdEncoding.DecodeString(creds.Token)
+		if err != nil {
+			return "", ErrWrongCredentials
+		}
+
+		pair := strings.SplitN(string(decoded), ":", 2)
+		if len(pair) != 2 {
+			return "", ErrWrongCredentials
+		}
+		username, password = pair[0], pair[1]
+	}
+
+	if _, err := b.Authenticate(username, password); err != nil {
+		return "", err
+	}
+	grantDefaultRole(b, username)
+
+	return username, nil
+}
+
 // Wrap an HTTP handler with BasicAuth authentication
 func (b *BasicAuthenticationBackend) Wrap(wrapped auth.AuthenticatedHandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
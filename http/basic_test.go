@@ -95,3 +95,33 @@ func TestBasicAuthenticate(t *testing.T) {
 	// second check with authentication cookie
 	checkAuth(false)
 }
+
+func TestBasicAuthenticateCredentials(t *testing.T) {
+	provider := NewHtpasswdMapProvider(map[string]string{"user1": "pass1"})
+
+	basic, err := NewBasicAuthenticationBackend("basic", provider.SecretProvider(), DefaultUserRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if username, err := basic.AuthenticateCredentials(&Credentials{Username: "user1", Password: "pass1"}); err != nil || username != "user1" {
+		t.Fatalf("Valid credentials should be accepted, got user '%s' and error %v", username, err)
+	}
+
+	token, err := basic.Authenticate("user1", "pass1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if username, err := basic.AuthenticateCredentials(&Credentials{Token: token}); err != nil || username != "user1" {
+		t.Fatalf("The authentication token should be accepted, got user '%s' and error %v", username, err)
+	}
+
+	if _, err := basic.AuthenticateCredentials(&Credentials{Username: "user1", Password: "pass2"}); err == nil {
+		t.Error("A wrong password should be rejected")
+	}
+
+	if _, err := basic.AuthenticateCredentials(&Credentials{Token: "not base64"}); err == nil {
+		t.Error("An invalid token should be rejected")
+	}
+}
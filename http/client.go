@@ -22,11 +22,13 @@ import (
 	"compress/gzip"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/skydive-project/skydive/common"
@@ -49,6 +51,17 @@ type CreateOptions struct {
 	TTL time.Duration
 }
 
+// UpdateOptions describes the options available when updating a resource
+type UpdateOptions struct {
+	// ETag of the resource, the update fails if the resource was modified since
+	ETag string
+	TTL  time.Duration
+}
+
+// ErrPreconditionFailed is returned when a resource was modified since the
+// ETag specified to an update
+var ErrPreconditionFailed = errors.New("Resource was modified since the specified ETag")
+
 func readBody(resp *http.Response) string {
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -76,9 +89,14 @@ func NewRestClient(url *url.URL, authOpts *AuthenticationOpts, tlsConfig *tls.Co
 	}
 }
 
-// Request issues a request to the API
+// Request issues a request to the API. The path can hold a query string.
 func (c *RestClient) Request(method, path string, body io.Reader, header http.Header) (*http.Response, error) {
-	url := c.url.ResolveReference(&url.URL{Path: path})
+	ref := &url.URL{Path: path}
+	if i := strings.IndexByte(path, '?'); i != -1 {
+		ref.Path, ref.RawQuery = path[:i], path[i+1:]
+	}
+
+	url := c.url.ResolveReference(ref)
 	req, err := http.NewRequest(method, url.String(), body)
 	if err != nil {
 		return nil, err
@@ -88,8 +106,8 @@ func (c *RestClient) Request(method, path string, body io.Reader, header http.He
 		SetAuthHeaders(&req.Header, c.authOpts)
 	}
 
-	if header != nil {
-		req.Header = header
+	for k, v := range header {
+		req.Header[k] = v
 	}
 	if req.Header.Get("Content-Type") == "" {
 		req.Header.Set("Content-Type", "application/json")
@@ -179,27 +197,76 @@ func (c *CrudClient) Create(resource string, value interface{}, opts *CreateOpti
 	return common.JSONDecode(resp.Body, value)
 }
 
-// Update modify a resource using a PUT call to the API
-func (c *CrudClient) Update(resource string, id string, value interface{}) error {
-	s, err := json.Marshal(value)
+// GetWithETag fills the passed value with the resource with the specified ID
+// and returns the ETag of the resource
+func (c *CrudClient) GetWithETag(resource string, id string, value interface{}) (string, error) {
+	resp, err := c.Request("GET", resource+"/"+id, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to get %s, %s: %s", resource, resp.Status, readBody(resp))
+	}
+
+	return resp.Header.Get("ETag"), common.JSONDecode(resp.Body, value)
+}
+
+func (c *CrudClient) update(method string, resource string, id string, body interface{}, value interface{}, opts *UpdateOptions) error {
+	s, err := json.Marshal(body)
 	if err != nil {
 		return err
 	}
 
+	header := http.Header{}
+	if method == "PATCH" {
+		header.Set("Content-Type", "application/merge-patch+json")
+	}
+	if opts != nil {
+		if opts.ETag != "" {
+			header.Set("If-Match", opts.ETag)
+		}
+		if opts.TTL != 0 {
+			header.Set("X-Resource-TTL", opts.TTL.String())
+		}
+	}
+
 	contentReader := bytes.NewReader(s)
-	resp, err := c.Request("PUT", resource+"/"+id, contentReader, nil)
+	resp, err := c.Request(method, resource+"/"+id, contentReader, header)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPreconditionFailed:
+		return ErrPreconditionFailed
+	default:
 		return fmt.Errorf("Failed to update %s, %s: %s", resource, resp.Status, readBody(resp))
 	}
 
+	if opts != nil {
+		opts.ETag = resp.Header.Get("ETag")
+	}
+
 	return common.JSONDecode(resp.Body, value)
 }
 
+// Update modify a resource using a PUT call to the API. If an ETag is
+// specified in the options, the update fails if the resource was modified
+// in the meantime. On success, the options are filled with the new ETag.
+func (c *CrudClient) Update(resource string, id string, value interface{}, opts *UpdateOptions) error {
+	return c.update("PUT", resource, id, value, value, opts)
+}
+
+// Patch applies a JSON merge patch to a resource using a PATCH call to
+// the API and fills the passed value with the updated resource
+func (c *CrudClient) Patch(resource string, id string, patch interface{}, value interface{}, opts *UpdateOptions) error {
+	return c.update("PATCH", resource, id, patch, value, opts)
+}
+
 // Delete removes a resource using a DELETE call to the API
 func (c *CrudClient) Delete(resource string, id string) error {
 	resp, err := c.Request("DELETE", resource+"/"+id, nil, nil)
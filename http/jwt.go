@@ -0,0 +1,387 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package http
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // register the SHA-256 hash
+	_ "crypto/sha512" // register the SHA-384 and SHA-512 hashes
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwtLeeway is the clock skew tolerated when checking the token validity
+const jwtLeeway = time.Minute
+
+// jwksMinRefreshInterval limits the rate at which the keys are fetched when
+// a token is signed by an unknown key
+const jwksMinRefreshInterval = 10 * time.Second
+
+var (
+	// ErrInvalidToken is returned when a token is malformed or its signature is invalid
+	ErrInvalidToken = errors.New("Invalid token")
+	// ErrExpiredToken is returned when a token is expired or not valid yet
+	ErrExpiredToken = errors.New("Expired token")
+)
+
+// jwtClaims holds the claims of a JSON Web Token
+type jwtClaims map[string]interface{}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jsonWebKey describes a public key as defined by RFC 7517
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwksCache fetches and caches the keys of a JSON Web Key Set
+type jwksCache struct {
+	sync.RWMutex
+	url       string
+	client    *http.Client
+	ttl       time.Duration
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("Unsupported curve %s", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported key type %s", k.Kty)
+}
+
+func (c *jwksCache) fetch() error {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return fmt.Errorf("Unable to fetch keys from %s: %s", c.url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unable to fetch keys from %s: %s", c.url, resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("Unable to decode keys from %s: %s", c.url, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		// keys with an unsupported type are skipped
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	c.keys, c.fetchedAt = keys, time.Now()
+
+	return nil
+}
+
+// getKey returns the key with the given identifier. The keys are fetched again
+// when the cache expired or when the key is unknown, the provider
+// may have rotated its keys.
+func (c *jwksCache) getKey(kid string) (crypto.PublicKey, error) {
+	c.RLock()
+	key, found := c.keys[kid]
+	expired := time.Since(c.fetchedAt) > c.ttl
+	c.RUnlock()
+
+	if found && !expired {
+		return key, nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if time.Since(c.fetchedAt) > jwksMinRefreshInterval {
+		if err := c.fetch(); err != nil {
+			if !found {
+				return nil, err
+			}
+			// keep using the cached key if the provider is not reachable
+			return key, nil
+		}
+	}
+
+	if key, found = c.keys[kid]; !found {
+		return nil, fmt.Errorf("Unknown key '%s'", kid)
+	}
+
+	return key, nil
+}
+
+func newJWKSCache(url string, client *http.Client, ttl time.Duration) *jwksCache {
+	return &jwksCache{
+		url:    url,
+		client: client,
+		ttl:    ttl,
+		keys:   make(map[string]crypto.PublicKey),
+	}
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed []byte, signature []byte) error {
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("Unsupported algorithm %s", alg)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrInvalidToken
+		}
+
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(rsaKey, hash, digest, signature, nil)
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrInvalidToken
+		}
+
+		// each algorithm is bound to a curve, ES512 using P-521
+		curves := map[string]string{"256": "P-256", "384": "P-384", "512": "P-521"}
+		if ecKey.Curve.Params().Name != curves[alg[2:]] {
+			return ErrInvalidToken
+		}
+
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ErrInvalidToken
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return ErrInvalidToken
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Unsupported algorithm %s", alg)
+}
+
+// parseJWT checks the signature of a JSON Web Token and returns its claims.
+// Only asymmetric algorithms are supported.
+func parseJWT(token string, getKey func(kid string) (crypto.PublicKey, error)) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var header jwtHeader
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if len(header.Alg) != 5 {
+		return nil, fmt.Errorf("Unsupported algorithm %s", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := getKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// get returns the value of a claim. Nested claims can be accessed
+// using a dotted path like realm_access.roles.
+func (c jwtClaims) get(path string) interface{} {
+	var value interface{} = map[string]interface{}(c)
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+func (c jwtClaims) getString(path string) string {
+	s, _ := c.get(path).(string)
+	return s
+}
+
+// getStrings returns the values of a claim being either a string
+// or a list of strings
+func (c jwtClaims) getStrings(path string) []string {
+	switch v := c.get(path).(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, i := range v {
+			if s, ok := i.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func (c jwtClaims) getTime(name string) (time.Time, bool) {
+	f, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// validate checks the issuer, the audience and the validity period of the claims
+func (c jwtClaims) validate(issuer, audience string, now time.Time) error {
+	if iss := c.getString("iss"); iss != issuer {
+		return fmt.Errorf("Unexpected token issuer '%s'", iss)
+	}
+
+	var found bool
+	for _, aud := range c.getStrings("aud") {
+		if aud == audience {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("Token not issued for audience '%s'", audience)
+	}
+
+	exp, ok := c.getTime("exp")
+	if !ok || now.After(exp.Add(jwtLeeway)) {
+		return ErrExpiredToken
+	}
+
+	if nbf, ok := c.getTime("nbf"); ok && now.Add(jwtLeeway).Before(nbf) {
+		return ErrExpiredToken
+	}
+
+	return nil
+}
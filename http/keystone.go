@@ -160,6 +160,29 @@ func (b *KeystoneAuthenticationBackend) Authenticate(username string, password s
 	return provider.TokenID, nil
 }
 
+// AuthenticateCredentials returns the user authenticated by a token, or by its
+// username and password if no token is given
+func (b *KeystoneAuthenticationBackend) AuthenticateCredentials(creds *Credentials) (string, error) {
+	token := creds.Token
+	if token == "" {
+		var err error
+		if token, err = b.Authenticate(creds.Username, creds.Password); err != nil {
+			return "", err
+		}
+	}
+
+	username, err := b.CheckUser(token)
+	if err != nil {
+		return "", err
+	}
+	if username == "" {
+		return "", ErrWrongCredentials
+	}
+	grantDefaultRole(b, username)
+
+	return username, nil
+}
+
 // Wrap an HTTP handler with Keystone authentication
 func (b *KeystoneAuthenticationBackend) Wrap(wrapped auth.AuthenticatedHandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,524 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package http
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
+)
+
+const (
+	// OIDCLoginPath is the path redirecting the users to the login page of the provider
+	OIDCLoginPath = "/login/oidc"
+	// OIDCCallbackPath is the path the provider redirects the users to once logged in
+	OIDCCallbackPath = "/login/oidc/callback"
+
+	oidcStateCookie        = "oidcstate"
+	oidcStateTTL           = 10 * time.Minute
+	oidcRequestTimeout     = 10 * time.Second
+	oidcDiscoveryRetry     = 10 * time.Second
+	defaultOIDCJWKSTTL     = time.Hour
+	defaultOIDCUserClaim   = "sub"
+	defaultOIDCGroupsClaim = "groups"
+)
+
+// OIDCOpts describes the options of an OpenID Connect authentication backend
+type OIDCOpts struct {
+	// URL of the provider, used to retrieve its configuration
+	Issuer string
+	// Client identifier and secret registered on the provider
+	ClientID     string
+	ClientSecret string
+	// URL the provider redirects the users to once logged in, built from
+	// the request if not specified
+	RedirectURL string
+	// Audience of the tokens, the client identifier if not specified
+	Audience string
+	// Scopes requested in addition to openid
+	Scopes []string
+	// Claim holding the user name, sub if not specified. The claim has to
+	// be unique and not modifiable by the users.
+	UsernameClaim string
+	// Claim holding the groups of the user, groups if not specified
+	GroupsClaim string
+	// RBAC roles granted to the members of a group, the group names
+	// being compared case insensitively
+	RoleMapping map[string]string
+	// Duration the provider keys are cached
+	JWKSTTL time.Duration
+}
+
+// oidcProviderConfig describes the configuration published by the provider
+type oidcProviderConfig struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// OIDCAuthenticationBackend describes an OpenID Connect based authentication backend.
+// It validates the JSON Web Tokens issued by the provider, passed either as bearer
+// token or as authentication cookie, and maps the groups of the users to RBAC roles.
+type OIDCAuthenticationBackend struct {
+	sync.Mutex
+	opts     OIDCOpts
+	name     string
+	role     string
+	client   *http.Client
+	provider *oidcProviderConfig
+	jwks     *jwksCache
+	// failure of the last discovery, returned until it can be retried
+	discoveryErr   error
+	discoveryErrAt time.Time
+	// closed once the discovery in progress, if any, is done
+	discovering chan struct{}
+	// roles granted by the backend, revoked when not mapped anymore
+	managedRoles map[string]bool
+}
+
+// Name returns the name of the backend
+func (b *OIDCAuthenticationBackend) Name() string {
+	return b.name
+}
+
+// DefaultUserRole returns the default user role
+func (b *OIDCAuthenticationBackend) DefaultUserRole(user string) string {
+	return b.role
+}
+
+// SetDefaultUserRole defines the default user role
+func (b *OIDCAuthenticationBackend) SetDefaultUserRole(role string) {
+	b.role = role
+}
+
+// discover retrieves the configuration published by the provider
+func (b *OIDCAuthenticationBackend) discover() (*oidcProviderConfig, error) {
+	discoveryURL := strings.TrimSuffix(b.opts.Issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := b.client.Get(discoveryURL)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve OpenID configuration: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to retrieve OpenID configuration: %s", resp.Status)
+	}
+
+	var provider oidcProviderConfig
+	if err := json.NewDecoder(resp.Body).Decode(&provider); err != nil {
+		return nil, fmt.Errorf("Unable to decode OpenID configuration: %s", err)
+	}
+
+	if provider.Issuer != b.opts.Issuer {
+		return nil, fmt.Errorf("OpenID configuration issuer '%s' doesn't match '%s'", provider.Issuer, b.opts.Issuer)
+	}
+
+	return &provider, nil
+}
+
+// getProvider returns the configuration of the provider, retrieved
+// on first use so that the provider doesn't have to be reachable
+// when the backend is created. The configuration is retrieved by a
+// single request at a time, without holding the lock, and a failure
+// is returned to all the requests until it can be retried.
+func (b *OIDCAuthenticationBackend) getProvider() (*oidcProviderConfig, *jwksCache, error) {
+	b.Lock()
+	for b.provider == nil && b.discovering != nil {
+		// wait for the discovery in progress
+		discovering := b.discovering
+		b.Unlock()
+		<-discovering
+		b.Lock()
+	}
+
+	if b.provider != nil {
+		defer b.Unlock()
+		return b.provider, b.jwks, nil
+	}
+
+	if b.discoveryErr != nil && time.Since(b.discoveryErrAt) < oidcDiscoveryRetry {
+		defer b.Unlock()
+		return nil, nil, b.discoveryErr
+	}
+
+	discovering := make(chan struct{})
+	b.discovering = discovering
+	b.Unlock()
+
+	provider, err := b.discover()
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.discovering = nil
+	close(discovering)
+
+	if err != nil {
+		b.discoveryErr, b.discoveryErrAt = err, time.Now()
+		return nil, nil, err
+	}
+
+	b.provider, b.discoveryErr = provider, nil
+	b.jwks = newJWKSCache(provider.JWKSURI, b.client, b.opts.JWKSTTL)
+
+	return b.provider, b.jwks, nil
+}
+
+// verifyToken validates a token and returns its claims. If not empty,
+// the nonce of the token has to match the given one.
+func (b *OIDCAuthenticationBackend) verifyToken(token string, nonce string) (jwtClaims, error) {
+	_, jwks, err := b.getProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := parseJWT(token, func(kid string) (crypto.PublicKey, error) {
+		return jwks.getKey(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := claims.validate(b.opts.Issuer, b.opts.Audience, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if nonce != "" && claims.getString("nonce") != nonce {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// authenticateToken validates a token and syncs the roles of its user with
+// the ones mapped to its groups. It returns the name of the user.
+func (b *OIDCAuthenticationBackend) authenticateToken(token string, nonce string) (string, error) {
+	claims, err := b.verifyToken(token, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	username := claims.getString(b.opts.UsernameClaim)
+	if username == "" {
+		return "", ErrInvalidToken
+	}
+
+	var roles []string
+	for _, group := range claims.getStrings(b.opts.GroupsClaim) {
+		if role, found := b.opts.RoleMapping[strings.ToLower(group)]; found {
+			roles = append(roles, role)
+		}
+	}
+	// the roles of the groups the user left are revoked
+	syncRoles(username, roles, b.DefaultUserRole(username), b.managedRoles)
+
+	return username, nil
+}
+
+// requestToken requests a token to the provider and returns the one
+// to use for authentication
+func (b *OIDCAuthenticationBackend) requestToken(values url.Values) (string, error) {
+	provider, _, err := b.getProvider()
+	if err != nil {
+		return "", err
+	}
+
+	values.Set("client_id", b.opts.ClientID)
+	if b.opts.ClientSecret != "" {
+		values.Set("client_secret", b.opts.ClientSecret)
+	}
+
+	resp, err := b.client.PostForm(provider.TokenEndpoint, values)
+	if err != nil {
+		return "", fmt.Errorf("Unable to request a token: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens oidcTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("Unable to decode token response: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		logging.GetLogger().Noticef("OpenID token request error: %s %s", tokens.Error, tokens.ErrorDescription)
+		return "", ErrWrongCredentials
+	}
+
+	// the ID token is always issued for the client
+	if tokens.IDToken != "" {
+		return tokens.IDToken, nil
+	}
+
+	return tokens.AccessToken, nil
+}
+
+// passwordToken requests a token for a user and its password, using the
+// resource owner password credentials grant. The provider has to allow
+// this grant for the client.
+func (b *OIDCAuthenticationBackend) passwordToken(username string, password string) (string, error) {
+	values := url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
+		"scope":      {b.scope()},
+	}
+
+	return b.requestToken(values)
+}
+
+// Authenticate the user and its password, using the resource owner password
+// credentials grant
+func (b *OIDCAuthenticationBackend) Authenticate(username string, password string) (string, error) {
+	token, err := b.passwordToken(username, password)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := b.authenticateToken(token, ""); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// AuthenticateCredentials returns the user authenticated by a token, or by
+// its username and password if no token is given
+func (b *OIDCAuthenticationBackend) AuthenticateCredentials(creds *Credentials) (string, error) {
+	token := creds.Token
+	if token == "" {
+		var err error
+		if token, err = b.passwordToken(creds.Username, creds.Password); err != nil {
+			return "", err
+		}
+	}
+
+	return b.authenticateToken(token, "")
+}
+
+// Wrap an HTTP handler with OpenID Connect authentication
+func (b *OIDCAuthenticationBackend) Wrap(wrapped auth.AuthenticatedHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+			token = strings.TrimPrefix(authorization, "Bearer ")
+		} else {
+			var err error
+			if token, err = authenticateWithHeaders(b, w, r); err != nil || token == "" {
+				Unauthorized(w, r)
+				return
+			}
+		}
+
+		username, err := b.authenticateToken(token, "")
+		if err != nil {
+			logging.GetLogger().Debugf("OpenID authentication error: %s", err)
+			Unauthorized(w, r)
+			return
+		}
+
+		authCallWrapped(w, r, username, wrapped)
+	}
+}
+
+func (b *OIDCAuthenticationBackend) scope() string {
+	return strings.Join(append([]string{"openid"}, b.opts.Scopes...), " ")
+}
+
+func (b *OIDCAuthenticationBackend) redirectURL(r *http.Request) string {
+	if b.opts.RedirectURL != "" {
+		return b.opts.RedirectURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + OIDCCallbackPath
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// LoginHandler redirects the user to the login page of the provider,
+// using the authorization code flow
+func (b *OIDCAuthenticationBackend) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, _, err := b.getProvider()
+	if err != nil {
+		logging.GetLogger().Error(err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	state, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state + "." + nonce,
+		Path:     OIDCCallbackPath,
+		Expires:  time.Now().Add(oidcStateTTL),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+
+	values := url.Values{
+		"response_type": {"code"},
+		"client_id":     {b.opts.ClientID},
+		"redirect_uri":  {b.redirectURL(r)},
+		"scope":         {b.scope()},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	http.Redirect(w, r, provider.AuthorizationEndpoint+separator+values.Encode(), http.StatusFound)
+}
+
+// CallbackHandler handles the redirection of the provider once the user
+// logged in. The authorization code is exchanged for a token set as
+// authentication cookie.
+func (b *OIDCAuthenticationBackend) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(w, "No login in progress", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: OIDCCallbackPath, MaxAge: -1})
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		logging.GetLogger().Noticef("OpenID login error: %s %s", e, query.Get("error_description"))
+		Unauthorized(w, r)
+		return
+	}
+
+	pair := strings.SplitN(cookie.Value, ".", 2)
+	if len(pair) != 2 || query.Get("state") != pair[0] {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+	nonce := pair[1]
+
+	values := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {query.Get("code")},
+		"redirect_uri": {b.redirectURL(r)},
+	}
+
+	token, err := b.requestToken(values)
+	if err != nil {
+		logging.GetLogger().Noticef("OpenID login error: %s", err)
+		Unauthorized(w, r)
+		return
+	}
+
+	username, err := b.authenticateToken(token, nonce)
+	if err != nil {
+		logging.GetLogger().Noticef("OpenID login error: %s", err)
+		Unauthorized(w, r)
+		return
+	}
+
+	http.SetCookie(w, AuthCookie(token, "/"))
+	setPermissionsCookie(w, username)
+
+	logging.GetLogger().Infof("User %s authenticated with %s backend with roles %s", username, b.name, rbac.GetUserRoles(username))
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// NewOIDCBackend returns a new OpenID Connect authentication backend
+func NewOIDCBackend(name string, opts OIDCOpts, role string) (*OIDCAuthenticationBackend, error) {
+	if opts.Issuer == "" {
+		return nil, errors.New("Issuer URL empty")
+	}
+
+	if opts.ClientID == "" {
+		return nil, errors.New("Client ID empty")
+	}
+
+	if opts.Audience == "" {
+		opts.Audience = opts.ClientID
+	}
+
+	if opts.UsernameClaim == "" {
+		opts.UsernameClaim = defaultOIDCUserClaim
+	}
+
+	if opts.GroupsClaim == "" {
+		opts.GroupsClaim = defaultOIDCGroupsClaim
+	}
+
+	if opts.JWKSTTL == 0 {
+		opts.JWKSTTL = defaultOIDCJWKSTTL
+	}
+
+	roleMapping := make(map[string]string)
+	managedRoles := make(map[string]bool)
+	for group, role := range opts.RoleMapping {
+		roleMapping[strings.ToLower(group)] = role
+		managedRoles[role] = true
+	}
+	opts.RoleMapping = roleMapping
+
+	return &OIDCAuthenticationBackend{
+		opts:         opts,
+		name:         name,
+		role:         role,
+		client:       &http.Client{Timeout: oidcRequestTimeout},
+		managedRoles: managedRoles,
+	}, nil
+}
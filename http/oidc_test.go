@@ -0,0 +1,303 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package http
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+)
+
+func signJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newOIDCProvider(t *testing.T, key *rsa.PrivateKey, kid string) *httptest.Server {
+	var server *httptest.Server
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/auth",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": kid,
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+				},
+			},
+		})
+	})
+
+	server = httptest.NewServer(mux)
+	return server
+}
+
+func TestOIDCBearerToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := newOIDCProvider(t, key, "key1")
+	defer provider.Close()
+
+	backend, err := NewOIDCBackend("oidc", OIDCOpts{Issuer: provider.URL, ClientID: "skydive"}, DefaultUserRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var username string
+	handler := backend.Wrap(func(w http.ResponseWriter, r *auth.AuthenticatedRequest) { username = r.Username })
+
+	claims := func(aud string, exp time.Duration) map[string]interface{} {
+		return map[string]interface{}{
+			"iss":                provider.URL,
+			"aud":                aud,
+			"sub":                "1234",
+			"preferred_username": "user1",
+			"exp":                time.Now().Add(exp).Unix(),
+		}
+	}
+
+	call := func(token string) int {
+		username = ""
+		w := &fakeResponseWriter{headers: make(http.Header)}
+		r := &http.Request{Header: make(http.Header)}
+		r.Header.Set("Authorization", "Bearer "+token)
+		handler(w, r)
+		return w.status
+	}
+
+	// the user is identified by its subject, not by a claim it could modify
+	if status := call(signJWT(t, key, "key1", claims("skydive", time.Hour))); status == http.StatusUnauthorized || username != "1234" {
+		t.Fatalf("A valid token should be accepted, got status %d and user '%s'", status, username)
+	}
+
+	noSubject := claims("skydive", time.Hour)
+	delete(noSubject, "sub")
+	if status := call(signJWT(t, key, "key1", noSubject)); status != http.StatusUnauthorized {
+		t.Error("A token without the user name claim should be rejected")
+	}
+
+	if status := call(signJWT(t, key, "key1", claims("other", time.Hour))); status != http.StatusUnauthorized {
+		t.Error("A token issued for another audience should be rejected")
+	}
+
+	if status := call(signJWT(t, key, "key1", claims("skydive", -time.Hour))); status != http.StatusUnauthorized {
+		t.Error("An expired token should be rejected")
+	}
+
+	if status := call(signJWT(t, key, "key2", claims("skydive", time.Hour))); status != http.StatusUnauthorized {
+		t.Error("A token signed by an unknown key should be rejected")
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := call(signJWT(t, other, "key1", claims("skydive", time.Hour))); status != http.StatusUnauthorized {
+		t.Error("A token with an invalid signature should be rejected")
+	}
+}
+
+func TestOIDCDiscoveryFailure(t *testing.T) {
+	var lock sync.Mutex
+	var requests int
+	available := false
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests++
+		ok := available
+		lock.Unlock()
+
+		// let the concurrent requests pile up
+		time.Sleep(100 * time.Millisecond)
+
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   server.URL,
+			"jwks_uri": server.URL + "/keys",
+		})
+	}))
+	defer server.Close()
+
+	backend, err := NewOIDCBackend("oidc", OIDCOpts{Issuer: server.URL, ClientID: "skydive"}, DefaultUserRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getRequests := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return requests
+	}
+
+	// the concurrent requests share a single discovery
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := backend.getProvider()
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err == nil {
+			t.Fatal("The discovery should fail while the provider is not available")
+		}
+	}
+
+	if n := getRequests(); n != 1 {
+		t.Fatalf("Expected a single discovery request, got %d", n)
+	}
+
+	// the failure is cached until the discovery can be retried
+	lock.Lock()
+	available = true
+	lock.Unlock()
+
+	if _, _, err := backend.getProvider(); err == nil || getRequests() != 1 {
+		t.Fatalf("Expected the cached failure, got %v after %d requests", err, getRequests())
+	}
+
+	backend.Lock()
+	backend.discoveryErrAt = backend.discoveryErrAt.Add(-oidcDiscoveryRetry)
+	backend.Unlock()
+
+	if _, _, err := backend.getProvider(); err != nil || getRequests() != 2 {
+		t.Fatalf("Expected the discovery to be retried, got %v after %d requests", err, getRequests())
+	}
+}
+
+func TestJWTECDSACurve(t *testing.T) {
+	sign := func(key *ecdsa.PrivateKey, hash crypto.Hash, signed []byte) []byte {
+		h := hash.New()
+		h.Write(signed)
+
+		r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		size := (key.Curve.Params().BitSize + 7) / 8
+		signature := make([]byte, 2*size)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[size-len(rb):size], rb)
+		copy(signature[2*size-len(sb):], sb)
+		return signature
+	}
+
+	signed := []byte("header.payload")
+	for _, test := range []struct {
+		alg   string
+		hash  crypto.Hash
+		curve elliptic.Curve
+	}{
+		{"ES256", crypto.SHA256, elliptic.P256()},
+		{"ES384", crypto.SHA384, elliptic.P384()},
+		{"ES512", crypto.SHA512, elliptic.P521()},
+	} {
+		for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+			key, err := ecdsa.GenerateKey(curve, rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = verifySignature(test.alg, &key.PublicKey, signed, sign(key, test.hash, signed))
+			if curve == test.curve && err != nil {
+				t.Errorf("%s signature with a %s key should be accepted, got %s", test.alg, curve.Params().Name, err)
+			} else if curve != test.curve && err == nil {
+				t.Errorf("%s signature with a %s key should be rejected", test.alg, curve.Params().Name)
+			}
+		}
+	}
+}
+
+func TestOIDCRoleChanges(t *testing.T) {
+	managed := map[string]bool{"admin": true, "guest": true}
+
+	// the role of the group the user left is revoked, the roles not granted
+	// by the backend being kept
+	grant, revoke := roleChanges([]string{"admin", "custom"}, []string{"guest"}, "guest", managed)
+	if !reflect.DeepEqual(grant, []string{"guest"}) || !reflect.DeepEqual(revoke, []string{"admin"}) {
+		t.Errorf("Expected guest to be granted and admin revoked, got %v and %v", grant, revoke)
+	}
+
+	// without mapped group the user gets the default role only
+	grant, revoke = roleChanges([]string{"admin"}, nil, "guest", managed)
+	if !reflect.DeepEqual(grant, []string{"guest"}) || !reflect.DeepEqual(revoke, []string{"admin"}) {
+		t.Errorf("Expected the default role only, got %v granted and %v revoked", grant, revoke)
+	}
+
+	// the default role is revoked once the user belongs to a mapped group
+	grant, revoke = roleChanges([]string{"guest"}, []string{"admin"}, "guest", managed)
+	if !reflect.DeepEqual(grant, []string{"admin"}) || !reflect.DeepEqual(revoke, []string{"guest"}) {
+		t.Errorf("Expected admin to replace guest, got %v granted and %v revoked", grant, revoke)
+	}
+
+	if grant, revoke = roleChanges([]string{"admin"}, []string{"admin"}, "guest", managed); len(grant) != 0 || len(revoke) != 0 {
+		t.Errorf("Expected no change, got %v granted and %v revoked", grant, revoke)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+
+	auth "github.com/abbot/go-http-auth"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// TLSOpts describes the options of a client certificate authentication backend
+type TLSOpts struct {
+	// Field of the certificate holding the user name: cn for the common
+	// name of the subject, dns, email or uri for the first subject
+	// alternative name of this type. cn if not specified.
+	UsernameField string
+	// RBAC roles granted according to the organizational units of the
+	// subject, the units being compared case insensitively
+	RoleMapping map[string]string
+}
+
+// TLSAuthenticationBackend authenticates the users by their client
+// certificate, verified by the TLS server against the configured CA
+// and revocation list. No password is accepted.
+type TLSAuthenticationBackend struct {
+	opts         TLSOpts
+	name         string
+	role         string
+	managedRoles map[string]bool
+}
+
+// Name returns the name of the backend
+func (b *TLSAuthenticationBackend) Name() string {
+	return b.name
+}
+
+// DefaultUserRole returns the default user role
+func (b *TLSAuthenticationBackend) DefaultUserRole(user string) string {
+	return b.role
+}
+
+// SetDefaultUserRole defines the default user role
+func (b *TLSAuthenticationBackend) SetDefaultUserRole(role string) {
+	b.role = role
+}
+
+// certificateUsername returns the user name held by a certificate
+func certificateUsername(cert *x509.Certificate, field string) string {
+	switch field {
+	case "cn":
+		return cert.Subject.CommonName
+	case "dns":
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case "email":
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case "uri":
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	}
+	return ""
+}
+
+// certificateRoles returns the roles mapped to the organizational units of
+// a certificate
+func (b *TLSAuthenticationBackend) certificateRoles(cert *x509.Certificate) []string {
+	var roles []string
+	for _, unit := range cert.Subject.OrganizationalUnit {
+		if role, found := b.opts.RoleMapping[strings.ToLower(unit)]; found {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// authenticateCertificate returns the user of the verified client certificate
+// of a connection and syncs its roles with the ones mapped to its
+// organizational units, so that a reissued certificate with other units
+// doesn't keep the previous roles
+func (b *TLSAuthenticationBackend) authenticateCertificate(state *tls.ConnectionState) (string, error) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", ErrWrongCredentials
+	}
+	cert := state.VerifiedChains[0][0]
+
+	username := certificateUsername(cert, b.opts.UsernameField)
+	if username == "" {
+		return "", fmt.Errorf("No %s in certificate %s", b.opts.UsernameField, cert.Subject)
+	}
+
+	syncRoles(username, b.certificateRoles(cert), b.DefaultUserRole(username), b.managedRoles)
+
+	return username, nil
+}
+
+// Authenticate always fails, the users can only be authenticated by certificate
+func (b *TLSAuthenticationBackend) Authenticate(username string, password string) (string, error) {
+	return "", ErrWrongCredentials
+}
+
+// AuthenticateCredentials returns the user of the client certificate, the
+// other credentials being ignored
+func (b *TLSAuthenticationBackend) AuthenticateCredentials(creds *Credentials) (string, error) {
+	return b.authenticateCertificate(creds.TLS)
+}
+
+// Wrap an HTTP handler with client certificate authentication
+func (b *TLSAuthenticationBackend) Wrap(wrapped auth.AuthenticatedHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, err := b.authenticateCertificate(r.TLS)
+		if err != nil {
+			logging.GetLogger().Debugf("Certificate authentication error: %s", err)
+			Unauthorized(w, r)
+			return
+		}
+
+		authCallWrapped(w, r, username, wrapped)
+	}
+}
+
+// NewTLSBackend returns a new client certificate authentication backend
+func NewTLSBackend(name string, opts TLSOpts, role string) (*TLSAuthenticationBackend, error) {
+	switch opts.UsernameField {
+	case "":
+		opts.UsernameField = "cn"
+	case "cn", "dns", "email", "uri":
+	default:
+		return nil, fmt.Errorf("Unsupported certificate username field '%s'", opts.UsernameField)
+	}
+
+	mapping := make(map[string]string, len(opts.RoleMapping))
+	managedRoles := make(map[string]bool)
+	for unit, role := range opts.RoleMapping {
+		mapping[strings.ToLower(unit)] = role
+		managedRoles[role] = true
+	}
+	opts.RoleMapping = mapping
+
+	return &TLSAuthenticationBackend{
+		opts:         opts,
+		name:         name,
+		role:         role,
+		managedRoles: managedRoles,
+	}, nil
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"testing"
+
+	auth "github.com/abbot/go-http-auth"
+)
+
+func TestTLSAuthenticate(t *testing.T) {
+	backend, err := NewTLSBackend("tls", TLSOpts{UsernameField: "dns"}, DefaultUserRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var username string
+	handler := backend.Wrap(func(w http.ResponseWriter, r *auth.AuthenticatedRequest) { username = r.Username })
+
+	call := func(state *tls.ConnectionState) int {
+		username = ""
+		w := &fakeResponseWriter{headers: make(http.Header)}
+		r := &http.Request{Header: make(http.Header), TLS: state}
+		handler(w, r)
+		return w.status
+	}
+
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "agent1", OrganizationalUnit: []string{"Agents"}},
+		DNSNames: []string{"agent1.example.com"},
+	}
+
+	if status := call(&tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}); status == http.StatusUnauthorized || username != "agent1.example.com" {
+		t.Fatalf("A verified certificate should be accepted, got status %d and user '%s'", status, username)
+	}
+
+	if status := call(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}); status != http.StatusUnauthorized {
+		t.Error("A certificate not verified should be rejected")
+	}
+
+	if status := call(nil); status != http.StatusUnauthorized {
+		t.Error("A request without certificate should be rejected")
+	}
+
+	if _, err := NewTLSBackend("tls", TLSOpts{UsernameField: "serial"}, DefaultUserRole); err == nil {
+		t.Error("An unsupported username field should be rejected")
+	}
+}
+
+func TestTLSAuthenticateCredentials(t *testing.T) {
+	backend, err := NewTLSBackend("tls", TLSOpts{}, DefaultUserRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "agent1"}}
+
+	state := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	if username, err := backend.AuthenticateCredentials(&Credentials{TLS: state}); err != nil || username != "agent1" {
+		t.Fatalf("A verified certificate should be accepted, got user '%s' and error %v", username, err)
+	}
+
+	if _, err := backend.AuthenticateCredentials(&Credentials{Username: "agent1", Password: "pass1"}); err == nil {
+		t.Error("A password should be rejected")
+	}
+}
+
+func TestTLSRoleDowngrade(t *testing.T) {
+	backend, err := NewTLSBackend("tls", TLSOpts{RoleMapping: map[string]string{"Admins": "admin", "Agents": "agent"}}, "guest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "agent1", OrganizationalUnit: []string{"admins"}}}
+	if roles := backend.certificateRoles(cert); len(roles) != 1 || roles[0] != "admin" {
+		t.Fatalf("Expected the role mapped to the unit of the certificate, got %v", roles)
+	}
+
+	// the certificate is reissued with a lower unit
+	cert.Subject.OrganizationalUnit = []string{"Agents"}
+	grant, revoke := roleChanges([]string{"admin"}, backend.certificateRoles(cert), "guest", backend.managedRoles)
+	if len(grant) != 1 || grant[0] != "agent" || len(revoke) != 1 || revoke[0] != "admin" {
+		t.Errorf("Expected agent to be granted and admin revoked, got %v and %v", grant, revoke)
+	}
+
+	// then without any mapped unit
+	cert.Subject.OrganizationalUnit = []string{"Others"}
+	grant, revoke = roleChanges([]string{"agent", "custom"}, backend.certificateRoles(cert), "guest", backend.managedRoles)
+	if len(grant) != 1 || grant[0] != "guest" || len(revoke) != 1 || revoke[0] != "agent" {
+		t.Errorf("Expected the default role to be granted and agent revoked, got %v and %v", grant, revoke)
+	}
+}
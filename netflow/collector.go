@@ -0,0 +1,610 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package netflow
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/pierrec/xxHash/xxHash64"
+
+	"github.com/skydive-project/skydive/common"
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/flow"
+	"github.com/skydive-project/skydive/logging"
+)
+
+// information elements mapped onto the flows, see
+// https://www.iana.org/assignments/ipfix/ipfix.xhtml
+const (
+	ieOctetDeltaCount          = 1
+	iePacketDeltaCount         = 2
+	ieProtocolIdentifier       = 4
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieFlowEndSysUpTime         = 21
+	ieFlowStartSysUpTime       = 22
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieICMPTypeCodeIPv4         = 32
+	ieSourceMacAddress         = 56
+	ieVlanID                   = 58
+	ieDestinationMacAddress    = 80
+	ieOctetTotalCount          = 85
+	iePacketTotalCount         = 86
+	ieICMPTypeCodeIPv6         = 139
+	ieFlowStartSeconds         = 150
+	ieFlowEndSeconds           = 151
+	ieFlowStartMilliseconds    = 152
+	ieFlowEndMilliseconds      = 153
+	iePostNATSourceIPv4        = 225
+	iePostNATDestinationIPv4   = 226
+	iePostNAPTSourcePort       = 227
+	iePostNAPTDestinationPort  = 228
+	ieDot1qVlanID              = 243
+	iePostNATSourceIPv6        = 281
+	iePostNATDestinationIPv6   = 282
+
+	// reversePEN is the enterprise number used for the reverse direction
+	// information elements of the biflows, see RFC 5103
+	reversePEN = 29305
+)
+
+var (
+	// ErrCollectorAlreadyAllocated error collector already allocated for this uuid
+	ErrCollectorAlreadyAllocated = errors.New("collector already allocated for this uuid")
+	// ErrNoNetworkLayer is returned for the records without any address
+	ErrNoNetworkLayer = errors.New("record without network layer")
+)
+
+// ExporterHandler is used by the collectors to get the TID of the graph node
+// of the exporters sending records
+type ExporterHandler interface {
+	ExporterNodeTID(addr net.IP) (string, error)
+}
+
+// counters of a record, totals being used over deltas when both are sent
+type recordCounters struct {
+	abBytes, abPackets, baBytes, baPackets int64
+	total                                  bool
+}
+
+// collectorFlow keeps the last flow sent for a key so that the delta
+// counters of the next records can be accumulated
+type collectorFlow struct {
+	flow     *flow.Flow
+	lastSeen time.Time
+}
+
+// Collector describes an IPFIX and NetFlow v9 collector
+type Collector struct {
+	common.RWMutex
+	UUID      string
+	Addr      string
+	Port      int
+	FlowTable *flow.Table
+	Conn      *net.UDPConn
+	UUIDs     flow.UUIDs
+	handler   ExporterHandler
+	templates *TemplateCache
+	flows     map[uint64]*collectorFlow
+	expire    time.Duration
+	exporters []*net.IPNet
+}
+
+// CollectorAllocator manages multiple IPFIX collectors
+type CollectorAllocator struct {
+	common.RWMutex
+	collectors []*Collector
+	exporters  []*net.IPNet
+}
+
+// ParseExporters parses a list of exporter addresses or networks
+func ParseExporters(defs []string) ([]*net.IPNet, error) {
+	var exporters []*net.IPNet
+	for _, def := range defs {
+		if !strings.Contains(def, "/") {
+			ip := net.ParseIP(def)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid exporter address %s", def)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			exporters = append(exporters, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipnet, err := net.ParseCIDR(def)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid exporter network %s: %s", def, err)
+		}
+		exporters = append(exporters, ipnet)
+	}
+
+	return exporters, nil
+}
+
+// isExporterAllowed returns whether the records of an exporter are accepted,
+// any exporter being accepted without configured exporters
+func (c *Collector) isExporterAllowed(ip net.IP) bool {
+	if len(c.exporters) == 0 {
+		return true
+	}
+
+	for _, exporter := range c.exporters {
+		if exporter.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTarget returns the current used connection
+func (c *Collector) GetTarget() string {
+	return fmt.Sprintf("%s:%d", c.Addr, c.Port)
+}
+
+func decodeUint(b []byte) (v uint64) {
+	// reduced-size encoding, see RFC 7011 section 6.2
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return
+}
+
+func decodeIP(b []byte) string {
+	switch len(b) {
+	case net.IPv4len:
+		return net.IP(b).To4().String()
+	case net.IPv6len:
+		return net.IP(b).String()
+	}
+	return ""
+}
+
+func icmpLayer(protocol flow.FlowProtocol, typeCode uint64) *flow.ICMPLayer {
+	kind, code := uint8(typeCode>>8), uint32(typeCode&0xff)
+	if protocol == flow.FlowProtocol_IPV4 {
+		return &flow.ICMPLayer{Type: flow.ICMPv4TypeToFlowICMPType(kind), Code: code}
+	}
+	return &flow.ICMPLayer{Type: flow.ICMPv6TypeToFlowICMPType(kind), Code: code}
+}
+
+// recordToFlow maps a data record onto a flow, returning its counters
+func recordToFlow(msg *Message, record *Record, uuids *flow.UUIDs, now time.Time) (*flow.Flow, *recordCounters, error) {
+	var (
+		counters                recordCounters
+		networkA, networkB      string
+		natA, natB              string
+		natPortA, natPortB      int64
+		portA, portB            int64
+		macA, macB              string
+		vlan                    int64
+		protocol                uint64
+		icmpTypeCode            uint64
+		hasPorts, hasNATPorts   bool
+		hasVlan, hasICMP        bool
+		start, last             int64
+		startUptime, lastUptime int64
+		hasStartUptime, hasLast bool
+	)
+
+	for _, field := range record.Fields {
+		v := field.Value
+
+		if field.EnterpriseID == reversePEN {
+			switch field.Type {
+			case ieOctetDeltaCount, ieOctetTotalCount:
+				counters.baBytes = int64(decodeUint(v))
+			case iePacketDeltaCount, iePacketTotalCount:
+				counters.baPackets = int64(decodeUint(v))
+			}
+			continue
+		} else if field.EnterpriseID != 0 {
+			// other enterprise-specific elements are not mapped
+			continue
+		}
+
+		switch field.Type {
+		case ieOctetDeltaCount:
+			if !counters.total {
+				counters.abBytes = int64(decodeUint(v))
+			}
+		case iePacketDeltaCount:
+			if !counters.total {
+				counters.abPackets = int64(decodeUint(v))
+			}
+		case ieOctetTotalCount:
+			counters.abBytes, counters.total = int64(decodeUint(v)), true
+		case iePacketTotalCount:
+			counters.abPackets, counters.total = int64(decodeUint(v)), true
+		case ieProtocolIdentifier:
+			protocol = decodeUint(v)
+		case ieSourceTransportPort:
+			portA, hasPorts = int64(decodeUint(v)), true
+		case ieDestinationTransportPort:
+			portB, hasPorts = int64(decodeUint(v)), true
+		case ieSourceIPv4Address, ieSourceIPv6Address:
+			networkA = decodeIP(v)
+		case ieDestinationIPv4Address, ieDestinationIPv6Address:
+			networkB = decodeIP(v)
+		case ieSourceMacAddress:
+			macA = net.HardwareAddr(v).String()
+		case ieDestinationMacAddress:
+			macB = net.HardwareAddr(v).String()
+		case ieVlanID, ieDot1qVlanID:
+			vlan, hasVlan = int64(decodeUint(v)&0x0fff), true
+		case ieICMPTypeCodeIPv4, ieICMPTypeCodeIPv6:
+			icmpTypeCode, hasICMP = decodeUint(v), true
+		case iePostNATSourceIPv4, iePostNATSourceIPv6:
+			natA = decodeIP(v)
+		case iePostNATDestinationIPv4, iePostNATDestinationIPv6:
+			natB = decodeIP(v)
+		case iePostNAPTSourcePort:
+			natPortA, hasNATPorts = int64(decodeUint(v)), true
+		case iePostNAPTDestinationPort:
+			natPortB, hasNATPorts = int64(decodeUint(v)), true
+		case ieFlowStartSeconds:
+			start = int64(decodeUint(v)) * 1000
+		case ieFlowEndSeconds:
+			last, hasLast = int64(decodeUint(v))*1000, true
+		case ieFlowStartMilliseconds:
+			start = int64(decodeUint(v))
+		case ieFlowEndMilliseconds:
+			last, hasLast = int64(decodeUint(v)), true
+		case ieFlowStartSysUpTime:
+			startUptime, hasStartUptime = int64(decodeUint(v)), true
+		case ieFlowEndSysUpTime:
+			lastUptime = int64(decodeUint(v))
+		}
+	}
+
+	if networkA == "" || networkB == "" {
+		return nil, nil, ErrNoNetworkLayer
+	}
+
+	// relative timestamps are based on the uptime of the NetFlow v9 exporters
+	if start == 0 && hasStartUptime && msg.Version == VersionNetFlowV9 {
+		bootTime := int64(msg.ExportTime)*1000 - int64(msg.SysUptime)
+		start, last, hasLast = bootTime+startUptime, bootTime+lastUptime, true
+	}
+	if start == 0 {
+		start = common.UnixMillis(now)
+	}
+	if !hasLast || last < start {
+		last = start
+	}
+
+	f := flow.NewFlow()
+	f.Init(start, "", uuids)
+	f.Last = last
+
+	if macA != "" || macB != "" || hasVlan {
+		f.Link = &flow.FlowLayer{
+			Protocol: flow.FlowProtocol_ETHERNET,
+			A:        macA,
+			B:        macB,
+			ID:       vlan,
+		}
+	}
+
+	networkProtocol := flow.FlowProtocol_IPV4
+	if net.ParseIP(networkA).To4() == nil {
+		networkProtocol = flow.FlowProtocol_IPV6
+	}
+
+	f.Network = &flow.FlowLayer{
+		Protocol: networkProtocol,
+		A:        networkA,
+		B:        networkB,
+	}
+
+	var transportProtocol flow.FlowProtocol
+	switch layers.IPProtocol(protocol) {
+	case layers.IPProtocolTCP:
+		transportProtocol, f.Application = flow.FlowProtocol_TCP, "TCP"
+	case layers.IPProtocolUDP:
+		transportProtocol, f.Application = flow.FlowProtocol_UDP, "UDP"
+	case layers.IPProtocolSCTP:
+		transportProtocol, f.Application = flow.FlowProtocol_SCTP, "SCTP"
+	case layers.IPProtocolICMPv4:
+		f.Application = "ICMPv4"
+		hasPorts = false
+	case layers.IPProtocolICMPv6:
+		f.Application = "ICMPv6"
+		hasPorts = false
+	default:
+		f.Application = networkProtocol.String()
+		hasPorts = false
+	}
+
+	if hasPorts {
+		f.Transport = &flow.TransportLayer{
+			Protocol: transportProtocol,
+			A:        portA,
+			B:        portB,
+		}
+	}
+
+	if hasICMP && f.Transport == nil {
+		f.ICMP = icmpLayer(networkProtocol, icmpTypeCode)
+	}
+
+	if natA != "" || natB != "" {
+		natAddr := natA
+		if natAddr == "" {
+			natAddr = natB
+		}
+
+		natProtocol := flow.FlowProtocol_IPV4
+		if net.ParseIP(natAddr).To4() == nil {
+			natProtocol = flow.FlowProtocol_IPV6
+		}
+
+		f.NATNetwork = &flow.FlowLayer{
+			Protocol: natProtocol,
+			A:        natA,
+			B:        natB,
+		}
+	}
+
+	if hasNATPorts && f.Transport != nil {
+		f.NATTransport = &flow.TransportLayer{
+			Protocol: f.Transport.Protocol,
+			A:        natPortA,
+			B:        natPortB,
+		}
+	}
+
+	f.Metric = &flow.FlowMetric{
+		ABBytes:   counters.abBytes,
+		ABPackets: counters.abPackets,
+		BABytes:   counters.baBytes,
+		BAPackets: counters.baPackets,
+		Start:     f.Start,
+		Last:      f.Last,
+	}
+
+	return f, &counters, nil
+}
+
+// accumulate adds the delta counters of a record to the previous flow
+// having the same key, the flow table replacing flows as a whole
+func (c *Collector) accumulate(key uint64, f *flow.Flow, counters *recordCounters, now time.Time) {
+	if prev, ok := c.flows[key]; ok {
+		if prev.flow.Start < f.Start {
+			f.Start, f.Metric.Start = prev.flow.Start, prev.flow.Start
+		}
+		if prev.flow.Last > f.Last {
+			f.Last, f.Metric.Last = prev.flow.Last, prev.flow.Last
+		}
+
+		if !counters.total {
+			f.Metric.ABBytes += prev.flow.Metric.ABBytes
+			f.Metric.ABPackets += prev.flow.Metric.ABPackets
+			f.Metric.BABytes += prev.flow.Metric.BABytes
+			f.Metric.BAPackets += prev.flow.Metric.BAPackets
+		}
+	}
+
+	c.flows[key] = &collectorFlow{flow: f, lastSeen: now}
+}
+
+func (c *Collector) expireFlows(now time.Time) {
+	for key, cf := range c.flows {
+		if now.Sub(cf.lastSeen) > c.expire {
+			delete(c.flows, key)
+		}
+	}
+}
+
+func (c *Collector) feedFlowTable(extFlowChan chan *flow.ExtFlow) {
+	var buf [maxDgramSize]byte
+
+	lastExpire := time.Now()
+	for {
+		n, addr, err := c.Conn.ReadFromUDP(buf[:])
+		if err != nil {
+			return
+		}
+
+		exporter := addr.IP.String()
+
+		// the templates of unknown exporters are not even cached
+		if !c.isExporterAllowed(addr.IP) {
+			logging.GetLogger().Debugf("Message from %s dropped, exporter not allowed", exporter)
+			continue
+		}
+
+		msg, err := c.templates.Decode(exporter, buf[0:n])
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to decode IPFIX message from %s: %s", exporter, err)
+			continue
+		}
+
+		if msg.MissingTemplates > 0 {
+			logging.GetLogger().Debugf("%d data sets from %s dropped, template unknown", msg.MissingTemplates, exporter)
+		}
+
+		if len(msg.Records) == 0 {
+			continue
+		}
+
+		now := time.Now()
+		uuids := flow.UUIDs{CaptureID: c.UUIDs.CaptureID}
+
+		var flows []*flow.Flow
+		var counters []*recordCounters
+		for _, record := range msg.Records {
+			f, rc, err := recordToFlow(msg, record, &uuids, now)
+			if err != nil {
+				continue
+			}
+			flows, counters = append(flows, f), append(counters, rc)
+		}
+
+		// the exporter gets a node only once it sent valid records
+		if len(flows) == 0 {
+			continue
+		}
+
+		tid, err := c.handler.ExporterNodeTID(addr.IP)
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to get the node of the exporter %s: %s", exporter, err)
+			continue
+		}
+
+		for i, f := range flows {
+			f.NodeTID = tid
+
+			l2, l3 := f.SetUUIDs(uint64(msg.DomainID), flow.Opts{LayerKeyMode: flow.L3PreferredKeyMode})
+
+			// flows of distinct exporters have to be distinct in the table
+			key := xxHash64.Checksum([]byte(tid), l2^l3)
+			c.accumulate(key, f, counters[i], now)
+
+			extFlowChan <- &flow.ExtFlow{
+				Type: flow.OperationExtFlowType,
+				Obj: &flow.Operation{
+					Type: flow.ReplaceOperation,
+					Flow: f,
+					Key:  key,
+				},
+			}
+		}
+
+		if now.Sub(lastExpire) > c.expire {
+			c.expireFlows(now)
+			lastExpire = now
+		}
+	}
+}
+
+func (c *Collector) start() error {
+	_, extFlowChan := c.FlowTable.Start(nil)
+	defer c.FlowTable.Stop()
+
+	c.feedFlowTable(extFlowChan)
+
+	return nil
+}
+
+// Start the collector
+func (c *Collector) Start() {
+	go c.start()
+}
+
+// Stop the collector
+func (c *Collector) Stop() {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.Conn != nil {
+		c.Conn.Close()
+	}
+}
+
+// NewCollector creates a new IPFIX and NetFlow v9 collector which will
+// populate the given flowtable
+func NewCollector(u string, conn *net.UDPConn, addr string, port int, ft *flow.Table, uuids flow.UUIDs, handler ExporterHandler, exporters []*net.IPNet) *Collector {
+	return &Collector{
+		UUID:      u,
+		Addr:      addr,
+		Port:      port,
+		Conn:      conn,
+		FlowTable: ft,
+		UUIDs:     uuids,
+		handler:   handler,
+		templates: NewTemplateCache(),
+		flows:     make(map[uint64]*collectorFlow),
+		expire:    time.Duration(config.GetInt("flow.expire")) * time.Second,
+		exporters: exporters,
+	}
+}
+
+func (a *CollectorAllocator) release(uuid string) {
+	for i, collector := range a.collectors {
+		if uuid == collector.UUID {
+			collector.Stop()
+			a.collectors = append(a.collectors[:i], a.collectors[i+1:]...)
+
+			break
+		}
+	}
+}
+
+// Release a collector
+func (a *CollectorAllocator) Release(uuid string) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.release(uuid)
+}
+
+// ReleaseAll collectors
+func (a *CollectorAllocator) ReleaseAll() {
+	a.Lock()
+	defer a.Unlock()
+
+	for _, collector := range a.collectors {
+		collector.Stop()
+	}
+	a.collectors = nil
+}
+
+// Alloc allocates a new collector listening on the given address
+func (a *CollectorAllocator) Alloc(uuid string, ft *flow.Table, addr *common.ServiceAddress, uuids flow.UUIDs, handler ExporterHandler) (*Collector, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	for _, collector := range a.collectors {
+		if uuid == collector.UUID {
+			return collector, ErrCollectorAlreadyAllocated
+		}
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{
+		Port: addr.Port,
+		IP:   net.ParseIP(addr.Addr),
+	})
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to listen on port %d: %s", addr.Port, err)
+		return nil, err
+	}
+
+	c := NewCollector(uuid, conn, addr.Addr, addr.Port, ft, uuids, handler, a.exporters)
+	a.collectors = append(a.collectors, c)
+
+	c.Start()
+	return c, nil
+}
+
+// NewCollectorAllocator creates a new collector allocator, the collectors
+// only accepting the records of the given exporters if any
+func NewCollectorAllocator(exporters []*net.IPNet) *CollectorAllocator {
+	return &CollectorAllocator{exporters: exporters}
+}
@@ -0,0 +1,360 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package netflow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/skydive-project/skydive/flow"
+)
+
+const (
+	ieTCPControlBits = 6
+	ieFlowEndReason  = 136
+
+	ipv4TemplateID = 256
+	ipv6TemplateID = 257
+
+	// flow end reasons, see RFC 5102
+	endReasonIdleTimeout   = 0x01
+	endReasonActiveTimeout = 0x02
+	endReasonEndOfFlow     = 0x03
+
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+)
+
+// Skydive specific information elements, exported under the configured
+// enterprise number
+const (
+	ieSkydiveNodeTID      = 1
+	ieSkydiveTrackingID   = 2
+	ieSkydiveL3TrackingID = 3
+	ieSkydiveUUID         = 4
+	// round trip time in nanoseconds
+	ieSkydiveRTT         = 5
+	ieSkydiveApplication = 6
+)
+
+// IPFIXEncoder encodes flows as IPFIX messages, IPv4 and IPv6 flows using
+// distinct templates
+type IPFIXEncoder struct {
+	domainID       uint32
+	enterpriseID   uint32
+	maxMessageSize int
+	seqNum         uint32
+	templates      map[uint16][]TemplateField
+}
+
+func (e *IPFIXEncoder) newTemplate(srcAddr, dstAddr uint16, addrLen uint16) []TemplateField {
+	fields := []TemplateField{
+		{Type: srcAddr, Length: addrLen},
+		{Type: dstAddr, Length: addrLen},
+		{Type: ieSourceTransportPort, Length: 2},
+		{Type: ieDestinationTransportPort, Length: 2},
+		{Type: ieProtocolIdentifier, Length: 1},
+		{Type: ieTCPControlBits, Length: 2},
+		{Type: ieTCPControlBits, Length: 2, EnterpriseID: reversePEN},
+		{Type: ieOctetTotalCount, Length: 8},
+		{Type: iePacketTotalCount, Length: 8},
+		{Type: ieOctetTotalCount, Length: 8, EnterpriseID: reversePEN},
+		{Type: iePacketTotalCount, Length: 8, EnterpriseID: reversePEN},
+		{Type: ieFlowStartMilliseconds, Length: 8},
+		{Type: ieFlowEndMilliseconds, Length: 8},
+		{Type: ieFlowEndReason, Length: 1},
+		{Type: ieSourceMacAddress, Length: 6},
+		{Type: ieDestinationMacAddress, Length: 6},
+		{Type: ieVlanID, Length: 2},
+	}
+
+	if e.enterpriseID != 0 {
+		fields = append(fields,
+			TemplateField{Type: ieSkydiveNodeTID, Length: variableLength, EnterpriseID: e.enterpriseID},
+			TemplateField{Type: ieSkydiveTrackingID, Length: variableLength, EnterpriseID: e.enterpriseID},
+			TemplateField{Type: ieSkydiveL3TrackingID, Length: variableLength, EnterpriseID: e.enterpriseID},
+			TemplateField{Type: ieSkydiveUUID, Length: variableLength, EnterpriseID: e.enterpriseID},
+			TemplateField{Type: ieSkydiveRTT, Length: 8, EnterpriseID: e.enterpriseID},
+			TemplateField{Type: ieSkydiveApplication, Length: variableLength, EnterpriseID: e.enterpriseID},
+		)
+	}
+
+	return fields
+}
+
+func (e *IPFIXEncoder) templateSet() []byte {
+	set := new(bytes.Buffer)
+
+	for _, id := range []uint16{ipv4TemplateID, ipv6TemplateID} {
+		fields := e.templates[id]
+
+		binary.Write(set, binary.BigEndian, id)
+		binary.Write(set, binary.BigEndian, uint16(len(fields)))
+		for _, field := range fields {
+			if field.EnterpriseID != 0 {
+				binary.Write(set, binary.BigEndian, field.Type|enterpriseBit)
+				binary.Write(set, binary.BigEndian, field.Length)
+				binary.Write(set, binary.BigEndian, field.EnterpriseID)
+			} else {
+				binary.Write(set, binary.BigEndian, field.Type)
+				binary.Write(set, binary.BigEndian, field.Length)
+			}
+		}
+	}
+
+	return set.Bytes()
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	if len(s) < 255 {
+		buf.WriteByte(uint8(len(s)))
+	} else {
+		if len(s) > 0xffff {
+			s = s[:0xffff]
+		}
+		buf.WriteByte(255)
+		binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	}
+	buf.WriteString(s)
+}
+
+func writeMAC(buf *bytes.Buffer, mac string) {
+	addr, err := net.ParseMAC(mac)
+	if err != nil || len(addr) != 6 {
+		addr = make(net.HardwareAddr, 6)
+	}
+	buf.Write(addr)
+}
+
+func tcpFlags(syn, fin, rst int64) (flags uint16) {
+	if syn != 0 {
+		flags |= tcpFlagSYN
+	}
+	if fin != 0 {
+		flags |= tcpFlagFIN
+	}
+	if rst != 0 {
+		flags |= tcpFlagRST
+	}
+	return
+}
+
+// encodeRecord returns the template ID and the data record of a flow
+func (e *IPFIXEncoder) encodeRecord(f *flow.Flow) (uint16, []byte) {
+	if f.Network == nil {
+		return 0, nil
+	}
+
+	ipA, ipB := net.ParseIP(f.Network.A), net.ParseIP(f.Network.B)
+	if ipA == nil || ipB == nil {
+		return 0, nil
+	}
+
+	record := new(bytes.Buffer)
+
+	templateID := uint16(ipv4TemplateID)
+	if f.Network.Protocol == flow.FlowProtocol_IPV6 {
+		templateID = ipv6TemplateID
+		record.Write(ipA.To16())
+		record.Write(ipB.To16())
+	} else {
+		if ipA.To4() == nil || ipB.To4() == nil {
+			return 0, nil
+		}
+		record.Write(ipA.To4())
+		record.Write(ipB.To4())
+	}
+
+	var portA, portB uint16
+	var protocol uint8
+	if f.Transport != nil {
+		portA, portB = uint16(f.Transport.A), uint16(f.Transport.B)
+		switch f.Transport.Protocol {
+		case flow.FlowProtocol_TCP:
+			protocol = 6
+		case flow.FlowProtocol_UDP:
+			protocol = 17
+		case flow.FlowProtocol_SCTP:
+			protocol = 132
+		}
+	} else if f.ICMP != nil {
+		if f.Network.Protocol == flow.FlowProtocol_IPV6 {
+			protocol = 58
+		} else {
+			protocol = 1
+		}
+	}
+	binary.Write(record, binary.BigEndian, portA)
+	binary.Write(record, binary.BigEndian, portB)
+	record.WriteByte(protocol)
+
+	var abFlags, baFlags uint16
+	if m := f.TCPMetric; m != nil {
+		abFlags = tcpFlags(m.ABSynStart, m.ABFinStart, m.ABRstStart)
+		baFlags = tcpFlags(m.BASynStart, m.BAFinStart, m.BARstStart)
+	}
+	binary.Write(record, binary.BigEndian, abFlags)
+	binary.Write(record, binary.BigEndian, baFlags)
+
+	binary.Write(record, binary.BigEndian, uint64(f.Metric.ABBytes))
+	binary.Write(record, binary.BigEndian, uint64(f.Metric.ABPackets))
+	binary.Write(record, binary.BigEndian, uint64(f.Metric.BABytes))
+	binary.Write(record, binary.BigEndian, uint64(f.Metric.BAPackets))
+
+	binary.Write(record, binary.BigEndian, uint64(f.Start))
+	binary.Write(record, binary.BigEndian, uint64(f.Last))
+
+	switch f.FinishType {
+	case flow.FlowFinishType_TCP_FIN, flow.FlowFinishType_TCP_RST:
+		record.WriteByte(endReasonEndOfFlow)
+	case flow.FlowFinishType_TIMEOUT:
+		record.WriteByte(endReasonIdleTimeout)
+	default:
+		record.WriteByte(endReasonActiveTimeout)
+	}
+
+	var macA, macB string
+	var vlan uint16
+	if f.Link != nil {
+		macA, macB, vlan = f.Link.A, f.Link.B, uint16(f.Link.ID)
+	}
+	writeMAC(record, macA)
+	writeMAC(record, macB)
+	binary.Write(record, binary.BigEndian, vlan)
+
+	if e.enterpriseID != 0 {
+		writeString(record, f.NodeTID)
+		writeString(record, f.TrackingID)
+		writeString(record, f.L3TrackingID)
+		writeString(record, f.UUID)
+		binary.Write(record, binary.BigEndian, uint64(f.Metric.RTT))
+		writeString(record, f.Application)
+	}
+
+	return templateID, record.Bytes()
+}
+
+type messageBuilder struct {
+	sets    map[uint16]*bytes.Buffer
+	order   []uint16
+	size    int
+	records uint32
+}
+
+func newMessageBuilder() *messageBuilder {
+	return &messageBuilder{
+		sets: make(map[uint16]*bytes.Buffer),
+		size: ipfixHeaderSize,
+	}
+}
+
+func (m *messageBuilder) add(setID uint16, data []byte) {
+	set, ok := m.sets[setID]
+	if !ok {
+		set = new(bytes.Buffer)
+		m.sets[setID] = set
+		m.order = append(m.order, setID)
+		m.size += setHeaderSize
+	}
+	set.Write(data)
+	m.size += len(data)
+}
+
+// sizeWith returns the size of the message once the data added
+func (m *messageBuilder) sizeWith(setID uint16, data []byte) int {
+	if _, ok := m.sets[setID]; ok {
+		return m.size + len(data)
+	}
+	return m.size + setHeaderSize + len(data)
+}
+
+func (e *IPFIXEncoder) bytes(m *messageBuilder, now time.Time) []byte {
+	msg := new(bytes.Buffer)
+
+	binary.Write(msg, binary.BigEndian, uint16(VersionIPFIX))
+	binary.Write(msg, binary.BigEndian, uint16(m.size))
+	binary.Write(msg, binary.BigEndian, uint32(now.Unix()))
+	binary.Write(msg, binary.BigEndian, e.seqNum)
+	binary.Write(msg, binary.BigEndian, e.domainID)
+
+	for _, id := range m.order {
+		set := m.sets[id]
+		binary.Write(msg, binary.BigEndian, id)
+		binary.Write(msg, binary.BigEndian, uint16(set.Len()+setHeaderSize))
+		msg.Write(set.Bytes())
+	}
+
+	// the sequence number counts the data records sent before the message
+	e.seqNum += m.records
+
+	return msg.Bytes()
+}
+
+// Encode encodes the flows in as many messages as needed to not exceed the
+// maximum message size. The templates are sent in the first message when
+// requested, the collectors needing them to decode the data records.
+func (e *IPFIXEncoder) Encode(flows []*flow.Flow, now time.Time, withTemplates bool) [][]byte {
+	var messages [][]byte
+
+	m := newMessageBuilder()
+	if withTemplates {
+		m.add(ipfixTemplateSetID, e.templateSet())
+	}
+
+	for _, f := range flows {
+		templateID, record := e.encodeRecord(f)
+		if record == nil {
+			continue
+		}
+
+		if m.records > 0 && m.sizeWith(templateID, record) > e.maxMessageSize {
+			messages = append(messages, e.bytes(m, now))
+			m = newMessageBuilder()
+		}
+
+		m.add(templateID, record)
+		m.records++
+	}
+
+	if m.records > 0 || withTemplates {
+		messages = append(messages, e.bytes(m, now))
+	}
+
+	return messages
+}
+
+// NewIPFIXEncoder returns a new IPFIX encoder for the given observation
+// domain. The Skydive specific elements are exported under the given
+// enterprise number, none being exported if 0.
+func NewIPFIXEncoder(domainID uint32, enterpriseID uint32, maxMessageSize int) *IPFIXEncoder {
+	e := &IPFIXEncoder{
+		domainID:       domainID,
+		enterpriseID:   enterpriseID,
+		maxMessageSize: maxMessageSize,
+	}
+
+	e.templates = map[uint16][]TemplateField{
+		ipv4TemplateID: e.newTemplate(ieSourceIPv4Address, ieDestinationIPv4Address, net.IPv4len),
+		ipv6TemplateID: e.newTemplate(ieSourceIPv6Address, ieDestinationIPv6Address, net.IPv6len),
+	}
+
+	return e
+}
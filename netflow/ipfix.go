@@ -0,0 +1,385 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package netflow
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// VersionNetFlowV9 is the version of the NetFlow v9 messages
+	VersionNetFlowV9 = 9
+	// VersionIPFIX is the version of the IPFIX messages
+	VersionIPFIX = 10
+
+	v9HeaderSize    = 20
+	ipfixHeaderSize = 16
+	setHeaderSize   = 4
+
+	v9TemplateSetID           = 0
+	v9OptionsTemplateSetID    = 1
+	ipfixTemplateSetID        = 2
+	ipfixOptionsTemplateSetID = 3
+	minDataSetID              = 256
+
+	// variableLength is the length of the IPFIX variable length fields
+	variableLength = 0xffff
+	enterpriseBit  = 0x8000
+)
+
+var (
+	// ErrMessageTruncated is returned when a message is shorter than announced
+	ErrMessageTruncated = errors.New("NetFlow message truncated")
+	// ErrInvalidTemplate is returned when a template can't be decoded
+	ErrInvalidTemplate = errors.New("Invalid NetFlow template")
+)
+
+// TemplateField describes a field of a template. The enterprise number is
+// only set for the enterprise-specific IPFIX information elements.
+type TemplateField struct {
+	Type         uint16
+	Length       uint16
+	EnterpriseID uint32
+}
+
+// Template describes the layout of the data records of a data set
+type Template struct {
+	ID     uint16
+	Fields []TemplateField
+	// options templates describe the exporter itself, not flows
+	Options bool
+}
+
+// FieldValue holds the raw value of a field of a data record
+type FieldValue struct {
+	TemplateField
+	Value []byte
+}
+
+// Record is a data record decoded using its template
+type Record struct {
+	Fields []FieldValue
+}
+
+// Message is a decoded NetFlow v9 or IPFIX message. Only the flow data
+// records are returned, the records of unknown templates being counted
+// as missing.
+type Message struct {
+	Version uint16
+	// export time in seconds
+	ExportTime uint32
+	// uptime of the exporter in milliseconds, NetFlow v9 only
+	SysUptime uint32
+	// observation domain for IPFIX, source ID for NetFlow v9
+	DomainID uint32
+	Records  []*Record
+	// number of data sets dropped as their template is not known yet
+	MissingTemplates int
+}
+
+type templateKey struct {
+	exporter string
+	version  uint16
+	domainID uint32
+}
+
+// TemplateCache keeps the templates announced by each exporter and
+// observation domain, and decodes the messages using them
+type TemplateCache struct {
+	sync.RWMutex
+	templates map[templateKey]map[uint16]*Template
+}
+
+func (c *TemplateCache) setTemplate(key templateKey, t *Template) {
+	c.Lock()
+	defer c.Unlock()
+
+	templates, ok := c.templates[key]
+	if !ok {
+		templates = make(map[uint16]*Template)
+		c.templates[key] = templates
+	}
+	templates[t.ID] = t
+}
+
+func (c *TemplateCache) withdrawTemplate(key templateKey, id uint16) {
+	c.Lock()
+	defer c.Unlock()
+
+	if templates, ok := c.templates[key]; ok {
+		delete(templates, id)
+	}
+}
+
+func (c *TemplateCache) getTemplate(key templateKey, id uint16) *Template {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.templates[key][id]
+}
+
+// Forget removes the templates of an exporter
+func (c *TemplateCache) Forget(exporter string) {
+	c.Lock()
+	defer c.Unlock()
+
+	for key := range c.templates {
+		if key.exporter == exporter {
+			delete(c.templates, key)
+		}
+	}
+}
+
+// decodeV9Templates decodes a NetFlow v9 template or options template set
+func (c *TemplateCache) decodeV9Templates(key templateKey, setID uint16, data []byte) error {
+	for len(data) >= 4 {
+		t := &Template{ID: binary.BigEndian.Uint16(data[0:2])}
+
+		var count int
+		if setID == v9OptionsTemplateSetID {
+			if len(data) < 6 {
+				return ErrInvalidTemplate
+			}
+			scopeLength, optionLength := binary.BigEndian.Uint16(data[2:4]), binary.BigEndian.Uint16(data[4:6])
+			count = int(scopeLength+optionLength) / 4
+			t.Options = true
+			data = data[6:]
+		} else {
+			count = int(binary.BigEndian.Uint16(data[2:4]))
+			data = data[4:]
+		}
+
+		if t.ID < minDataSetID || len(data) < count*4 {
+			return ErrInvalidTemplate
+		}
+
+		for i := 0; i < count; i++ {
+			t.Fields = append(t.Fields, TemplateField{
+				Type:   binary.BigEndian.Uint16(data[0:2]),
+				Length: binary.BigEndian.Uint16(data[2:4]),
+			})
+			data = data[4:]
+		}
+
+		c.setTemplate(key, t)
+
+		// options template sets are padded
+		if setID == v9OptionsTemplateSetID {
+			break
+		}
+	}
+
+	return nil
+}
+
+// decodeIPFIXTemplates decodes an IPFIX template or options template set
+func (c *TemplateCache) decodeIPFIXTemplates(key templateKey, setID uint16, data []byte) error {
+	// a set can be padded with less bytes than a template header
+	for len(data) >= 4 {
+		id, count := binary.BigEndian.Uint16(data[0:2]), int(binary.BigEndian.Uint16(data[2:4]))
+		data = data[4:]
+
+		if count == 0 {
+			// template withdrawal
+			c.withdrawTemplate(key, id)
+			continue
+		}
+
+		t := &Template{ID: id, Options: setID == ipfixOptionsTemplateSetID}
+		if t.Options {
+			// skip the scope field count
+			if len(data) < 2 {
+				return ErrInvalidTemplate
+			}
+			data = data[2:]
+		}
+
+		if id < minDataSetID {
+			return ErrInvalidTemplate
+		}
+
+		for i := 0; i < count; i++ {
+			if len(data) < 4 {
+				return ErrInvalidTemplate
+			}
+
+			field := TemplateField{
+				Type:   binary.BigEndian.Uint16(data[0:2]),
+				Length: binary.BigEndian.Uint16(data[2:4]),
+			}
+			data = data[4:]
+
+			if field.Type&enterpriseBit != 0 {
+				if len(data) < 4 {
+					return ErrInvalidTemplate
+				}
+				field.Type &^= enterpriseBit
+				field.EnterpriseID = binary.BigEndian.Uint32(data[0:4])
+				data = data[4:]
+			}
+
+			t.Fields = append(t.Fields, field)
+		}
+
+		c.setTemplate(key, t)
+	}
+
+	return nil
+}
+
+// decodeRecord decodes a data record, returning the remaining bytes of the set
+func decodeRecord(t *Template, data []byte) (*Record, []byte, error) {
+	record := &Record{Fields: make([]FieldValue, 0, len(t.Fields))}
+
+	for _, field := range t.Fields {
+		length := int(field.Length)
+		if field.Length == variableLength {
+			if len(data) < 1 {
+				return nil, nil, ErrMessageTruncated
+			}
+			length, data = int(data[0]), data[1:]
+			if length == 255 {
+				if len(data) < 2 {
+					return nil, nil, ErrMessageTruncated
+				}
+				length, data = int(binary.BigEndian.Uint16(data[0:2])), data[2:]
+			}
+		}
+
+		if len(data) < length {
+			return nil, nil, ErrMessageTruncated
+		}
+
+		record.Fields = append(record.Fields, FieldValue{TemplateField: field, Value: data[:length]})
+		data = data[length:]
+	}
+
+	return record, data, nil
+}
+
+// minRecordLength returns the minimum length of the records of a template,
+// used to detect the padding at the end of the data sets
+func (t *Template) minRecordLength() int {
+	var length int
+	for _, field := range t.Fields {
+		if field.Length == variableLength {
+			length++
+		} else {
+			length += int(field.Length)
+		}
+	}
+	return length
+}
+
+func (c *TemplateCache) decodeDataSet(key templateKey, setID uint16, data []byte, msg *Message) error {
+	t := c.getTemplate(key, setID)
+	if t == nil {
+		msg.MissingTemplates++
+		return nil
+	}
+
+	minLength := t.minRecordLength()
+	if minLength == 0 {
+		return ErrInvalidTemplate
+	}
+
+	for len(data) >= minLength {
+		record, remaining, err := decodeRecord(t, data)
+		if err != nil {
+			return err
+		}
+		data = remaining
+
+		if !t.Options {
+			msg.Records = append(msg.Records, record)
+		}
+	}
+
+	return nil
+}
+
+// Decode decodes a NetFlow v9 or IPFIX message sent by an exporter. The
+// templates found in the message are cached for the next messages.
+func (c *TemplateCache) Decode(exporter string, data []byte) (*Message, error) {
+	if len(data) < 2 {
+		return nil, ErrMessageTruncated
+	}
+
+	msg := &Message{Version: binary.BigEndian.Uint16(data[0:2])}
+
+	switch msg.Version {
+	case VersionNetFlowV9:
+		if len(data) < v9HeaderSize {
+			return nil, ErrMessageTruncated
+		}
+		msg.SysUptime = binary.BigEndian.Uint32(data[4:8])
+		msg.ExportTime = binary.BigEndian.Uint32(data[8:12])
+		msg.DomainID = binary.BigEndian.Uint32(data[16:20])
+		data = data[v9HeaderSize:]
+	case VersionIPFIX:
+		if len(data) < ipfixHeaderSize {
+			return nil, ErrMessageTruncated
+		}
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if length < ipfixHeaderSize || length > len(data) {
+			return nil, ErrMessageTruncated
+		}
+		msg.ExportTime = binary.BigEndian.Uint32(data[4:8])
+		msg.DomainID = binary.BigEndian.Uint32(data[12:16])
+		data = data[ipfixHeaderSize:length]
+	default:
+		return nil, fmt.Errorf("Unsupported NetFlow version %d", msg.Version)
+	}
+
+	key := templateKey{exporter: exporter, version: msg.Version, domainID: msg.DomainID}
+
+	for len(data) >= setHeaderSize {
+		setID, length := binary.BigEndian.Uint16(data[0:2]), int(binary.BigEndian.Uint16(data[2:4]))
+		if length < setHeaderSize || length > len(data) {
+			return nil, ErrMessageTruncated
+		}
+		set := data[setHeaderSize:length]
+		data = data[length:]
+
+		var err error
+		switch {
+		case msg.Version == VersionNetFlowV9 && setID <= v9OptionsTemplateSetID:
+			err = c.decodeV9Templates(key, setID, set)
+		case msg.Version == VersionIPFIX && (setID == ipfixTemplateSetID || setID == ipfixOptionsTemplateSetID):
+			err = c.decodeIPFIXTemplates(key, setID, set)
+		case setID >= minDataSetID:
+			err = c.decodeDataSet(key, setID, set, msg)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return msg, nil
+}
+
+// NewTemplateCache returns a new empty template cache
+func NewTemplateCache() *TemplateCache {
+	return &TemplateCache{
+		templates: make(map[templateKey]map[uint16]*Template),
+	}
+}
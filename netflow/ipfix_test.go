@@ -0,0 +1,358 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package netflow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/skydive-project/skydive/flow"
+)
+
+type testMessage struct {
+	bytes.Buffer
+}
+
+func (b *testMessage) put(values ...interface{}) *testMessage {
+	for _, v := range values {
+		binary.Write(&b.Buffer, binary.BigEndian, v)
+	}
+	return b
+}
+
+func (b *testMessage) set(id uint16, content []byte) *testMessage {
+	return b.put(id, uint16(len(content)+setHeaderSize)).put(content)
+}
+
+func ipfixMessage(domainID uint32, sets ...[]byte) []byte {
+	var body testMessage
+	for i := 0; i < len(sets); i += 2 {
+		body.set(binary.BigEndian.Uint16(sets[i]), sets[i+1])
+	}
+
+	var msg testMessage
+	msg.put(uint16(VersionIPFIX), uint16(ipfixHeaderSize+body.Len()), uint32(1500000000), uint32(1), domainID)
+	msg.put(body.Bytes())
+	return msg.Bytes()
+}
+
+func setID(id uint16) []byte {
+	return []byte{byte(id >> 8), byte(id)}
+}
+
+func ipfixTemplate() []byte {
+	var t testMessage
+	t.put(uint16(256), uint16(8))
+	t.put(uint16(ieSourceIPv4Address), uint16(4))
+	t.put(uint16(ieDestinationIPv4Address), uint16(4))
+	t.put(uint16(ieSourceTransportPort), uint16(2))
+	t.put(uint16(ieDestinationTransportPort), uint16(2))
+	t.put(uint16(ieProtocolIdentifier), uint16(1))
+	t.put(uint16(ieOctetDeltaCount), uint16(8))
+	// reverse direction bytes, enterprise-specific
+	t.put(uint16(ieOctetDeltaCount|enterpriseBit), uint16(8), uint32(reversePEN))
+	// variable length enterprise-specific field
+	t.put(uint16(12|enterpriseBit), uint16(variableLength), uint32(9))
+	return t.Bytes()
+}
+
+func ipfixRecord(srcPort uint16, abBytes, baBytes uint64, name string) []byte {
+	var r testMessage
+	r.put([]byte{192, 168, 0, 1}, []byte{192, 168, 0, 2}, srcPort, uint16(80), uint8(6), abBytes, baBytes)
+	r.put(uint8(len(name)), []byte(name))
+	return r.Bytes()
+}
+
+func TestIPFIXTemplateCache(t *testing.T) {
+	cache := NewTemplateCache()
+
+	data := append(ipfixRecord(40000, 100, 200, "eth0"), ipfixRecord(40001, 300, 400, "eth1")...)
+
+	// data before its template is dropped
+	msg, err := cache.Decode("10.0.0.1", ipfixMessage(1, setID(256), data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Records) != 0 || msg.MissingTemplates != 1 {
+		t.Fatalf("Expected the data set to be dropped, got %d records", len(msg.Records))
+	}
+
+	// padding at the end of the data set
+	data = append(data, 0, 0, 0)
+
+	msg, err = cache.Decode("10.0.0.1", ipfixMessage(1, setID(ipfixTemplateSetID), ipfixTemplate(), setID(256), data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(msg.Records))
+	}
+
+	fields := msg.Records[1].Fields
+	if len(fields) != 8 {
+		t.Fatalf("Expected 8 fields, got %d", len(fields))
+	}
+	if fields[6].EnterpriseID != reversePEN || decodeUint(fields[6].Value) != 400 {
+		t.Errorf("Wrong reverse field: %+v", fields[6])
+	}
+	if fields[7].EnterpriseID != 9 || string(fields[7].Value) != "eth1" {
+		t.Errorf("Wrong variable length field: %+v", fields[7])
+	}
+
+	// templates are scoped per exporter and observation domain
+	if msg, _ = cache.Decode("10.0.0.2", ipfixMessage(1, setID(256), data)); len(msg.Records) != 0 {
+		t.Error("Template of another exporter used")
+	}
+	if msg, _ = cache.Decode("10.0.0.1", ipfixMessage(2, setID(256), data)); len(msg.Records) != 0 {
+		t.Error("Template of another observation domain used")
+	}
+
+	// template withdrawal
+	withdrawal := new(testMessage).put(uint16(256), uint16(0)).Bytes()
+	msg, err = cache.Decode("10.0.0.1", ipfixMessage(1, setID(ipfixTemplateSetID), withdrawal, setID(256), data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Records) != 0 {
+		t.Error("Withdrawn template used")
+	}
+}
+
+func TestNetFlowV9Decode(t *testing.T) {
+	cache := NewTemplateCache()
+
+	var template testMessage
+	template.put(uint16(300), uint16(6))
+	template.put(uint16(ieSourceIPv6Address), uint16(16))
+	template.put(uint16(ieDestinationIPv6Address), uint16(16))
+	template.put(uint16(ieProtocolIdentifier), uint16(1))
+	template.put(uint16(iePacketDeltaCount), uint16(4))
+	template.put(uint16(ieFlowStartSysUpTime), uint16(4))
+	template.put(uint16(ieFlowEndSysUpTime), uint16(4))
+
+	var record testMessage
+	record.put(bytes.Repeat([]byte{0xfe, 0x80}, 8), bytes.Repeat([]byte{0xfe, 0x81}, 8))
+	record.put(uint8(58), uint32(3), uint32(1000), uint32(4000))
+
+	var msg testMessage
+	msg.put(uint16(VersionNetFlowV9), uint16(2), uint32(10000), uint32(1500000000), uint32(1), uint32(7))
+	msg.set(v9TemplateSetID, template.Bytes())
+	msg.set(300, record.Bytes())
+
+	decoded, err := cache.Decode("10.0.0.1", msg.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.DomainID != 7 || len(decoded.Records) != 1 {
+		t.Fatalf("Wrong decoded message: %+v", decoded)
+	}
+
+	f, counters, err := recordToFlow(decoded, decoded.Records[0], &flow.UUIDs{NodeTID: "tid"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Network.Protocol != flow.FlowProtocol_IPV6 || f.Network.A != "fe80:fe80:fe80:fe80:fe80:fe80:fe80:fe80" {
+		t.Errorf("Wrong network layer: %+v", f.Network)
+	}
+	if f.Application != "ICMPv6" || f.Transport != nil {
+		t.Errorf("Wrong application %s", f.Application)
+	}
+	if counters.abPackets != 3 || counters.total {
+		t.Errorf("Wrong counters: %+v", counters)
+	}
+
+	// exporter booted 10 seconds before the export time
+	if f.Start != 1500000000*1000-10000+1000 || f.Last != 1500000000*1000-10000+4000 {
+		t.Errorf("Wrong flow times: %d %d", f.Start, f.Last)
+	}
+}
+
+func TestRecordToFlowNAT(t *testing.T) {
+	record := &Record{
+		Fields: []FieldValue{
+			{TemplateField{Type: ieSourceIPv4Address, Length: 4}, []byte{10, 0, 0, 1}},
+			{TemplateField{Type: ieDestinationIPv4Address, Length: 4}, []byte{8, 8, 8, 8}},
+			{TemplateField{Type: ieProtocolIdentifier, Length: 1}, []byte{17}},
+			{TemplateField{Type: ieSourceTransportPort, Length: 2}, []byte{0x9c, 0x40}},
+			{TemplateField{Type: ieDestinationTransportPort, Length: 2}, []byte{0, 53}},
+			{TemplateField{Type: ieOctetTotalCount, Length: 8}, []byte{0, 0, 0, 0, 0, 0, 1, 0}},
+			{TemplateField{Type: ieOctetDeltaCount, Length: 4}, []byte{0, 0, 0, 1}},
+			{TemplateField{Type: ieDot1qVlanID, Length: 2}, []byte{0, 42}},
+			{TemplateField{Type: iePostNATSourceIPv4, Length: 4}, []byte{192, 0, 2, 1}},
+			{TemplateField{Type: iePostNAPTSourcePort, Length: 2}, []byte{0x75, 0x30}},
+		},
+	}
+
+	f, counters, err := recordToFlow(&Message{Version: VersionIPFIX}, record, &flow.UUIDs{NodeTID: "tid"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Transport == nil || f.Transport.Protocol != flow.FlowProtocol_UDP || f.Transport.A != 40000 || f.Transport.B != 53 {
+		t.Errorf("Wrong transport layer: %+v", f.Transport)
+	}
+	if f.Link == nil || f.Link.ID != 42 {
+		t.Errorf("Wrong link layer: %+v", f.Link)
+	}
+	if f.NATNetwork == nil || f.NATNetwork.A != "192.0.2.1" || f.NATNetwork.B != "" {
+		t.Errorf("Wrong NAT network layer: %+v", f.NATNetwork)
+	}
+	if f.NATTransport == nil || f.NATTransport.A != 30000 {
+		t.Errorf("Wrong NAT transport layer: %+v", f.NATTransport)
+	}
+	if !counters.total || f.Metric.ABBytes != 256 {
+		t.Errorf("Total counters expected over deltas: %+v", counters)
+	}
+
+	if _, _, err := recordToFlow(&Message{Version: VersionIPFIX}, &Record{}, &flow.UUIDs{}, time.Now()); err != ErrNoNetworkLayer {
+		t.Errorf("Expected an error for a record without addresses, got %v", err)
+	}
+}
+
+func TestIPFIXEncoder(t *testing.T) {
+	newFlow := func(a, b string, protocol flow.FlowProtocol) *flow.Flow {
+		f := flow.NewFlow()
+		f.Init(1500000000000, "", &flow.UUIDs{NodeTID: "node-tid"})
+		f.Network = &flow.FlowLayer{Protocol: protocol, A: a, B: b}
+		f.Transport = &flow.TransportLayer{Protocol: flow.FlowProtocol_TCP, A: 40000, B: 443}
+		f.TCPMetric = &flow.TCPMetric{ABSynStart: 1, BARstStart: 2}
+		f.Metric = &flow.FlowMetric{ABBytes: 1000, ABPackets: 10, BABytes: 2000, BAPackets: 20, RTT: 1500000}
+		f.Last = 1500000001000
+		f.TrackingID = "tracking-id"
+		return f
+	}
+
+	var flows []*flow.Flow
+	for i := 0; i < 20; i++ {
+		flows = append(flows, newFlow("10.0.0.1", "10.0.0.2", flow.FlowProtocol_IPV4), newFlow("fe80::1", "fe80::2", flow.FlowProtocol_IPV6))
+	}
+	// flows without network layer are not exported
+	flows = append(flows, flow.NewFlow())
+
+	encoder := NewIPFIXEncoder(42, 2312, 1400)
+	messages := encoder.Encode(flows, time.Now(), true)
+	if len(messages) < 2 {
+		t.Fatalf("Expected flows to be split over several messages, got %d", len(messages))
+	}
+
+	cache := NewTemplateCache()
+
+	var records []*Record
+	var seqNum uint32
+	for _, data := range messages {
+		if len(data) > 1400 {
+			t.Errorf("Message exceeding the maximum size: %d", len(data))
+		}
+		if seq := binary.BigEndian.Uint32(data[8:12]); seq != seqNum {
+			t.Errorf("Wrong sequence number, expected %d got %d", seqNum, seq)
+		}
+
+		msg, err := cache.Decode("10.0.0.1", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.DomainID != 42 || msg.MissingTemplates != 0 {
+			t.Fatalf("Wrong decoded message: %+v", msg)
+		}
+
+		records = append(records, msg.Records...)
+		seqNum += uint32(len(msg.Records))
+	}
+
+	if len(records) != 40 {
+		t.Fatalf("Expected 40 records, got %d", len(records))
+	}
+
+	// data sets of both templates are in the messages
+	var ipv6Record *Record
+	for _, record := range records {
+		if record.Fields[0].Type == ieSourceIPv6Address {
+			ipv6Record = record
+		}
+	}
+	if ipv6Record == nil {
+		t.Fatal("No IPv6 record found")
+	}
+
+	f, counters, err := recordToFlow(&Message{Version: VersionIPFIX}, ipv6Record, &flow.UUIDs{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Network.Protocol != flow.FlowProtocol_IPV6 || f.Network.B != "fe80::2" || f.Transport.B != 443 {
+		t.Errorf("Wrong flow layers: %+v %+v", f.Network, f.Transport)
+	}
+	if !counters.total || counters.abBytes != 1000 || counters.baPackets != 20 {
+		t.Errorf("Wrong counters: %+v", counters)
+	}
+	if f.Start != 1500000000000 || f.Last != 1500000001000 {
+		t.Errorf("Wrong flow times: %d %d", f.Start, f.Last)
+	}
+
+	var trackingID string
+	var abFlags, baFlags uint64
+	for _, field := range records[0].Fields {
+		switch {
+		case field.EnterpriseID == 2312 && field.Type == ieSkydiveTrackingID:
+			trackingID = string(field.Value)
+		case field.Type == ieTCPControlBits && field.EnterpriseID == 0:
+			abFlags = decodeUint(field.Value)
+		case field.Type == ieTCPControlBits && field.EnterpriseID == reversePEN:
+			baFlags = decodeUint(field.Value)
+		}
+	}
+	if trackingID != "tracking-id" || abFlags != tcpFlagSYN || baFlags != tcpFlagRST {
+		t.Errorf("Wrong fields: %s %d %d", trackingID, abFlags, baFlags)
+	}
+
+	// templates are only sent when requested
+	if messages = encoder.Encode(nil, time.Now(), false); len(messages) != 0 {
+		t.Errorf("Expected no message, got %d", len(messages))
+	}
+}
+
+func TestParseExporters(t *testing.T) {
+	exporters, err := ParseExporters([]string{"10.0.0.1", "192.168.0.0/24", "fe80::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Collector{exporters: exporters}
+	for addr, allowed := range map[string]bool{
+		"10.0.0.1":    true,
+		"10.0.0.2":    false,
+		"192.168.0.7": true,
+		"fe80::1":     true,
+		"fe80::2":     false,
+	} {
+		if c.isExporterAllowed(net.ParseIP(addr)) != allowed {
+			t.Errorf("Exporter %s expected to be allowed: %v", addr, allowed)
+		}
+	}
+
+	if !(&Collector{}).isExporterAllowed(net.ParseIP("10.0.0.2")) {
+		t.Error("Any exporter expected to be allowed without configured exporters")
+	}
+
+	if _, err := ParseExporters([]string{"10.0.0"}); err == nil {
+		t.Error("Expected an error for an invalid exporter")
+	}
+}
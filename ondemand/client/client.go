@@ -120,8 +120,10 @@ func (o *OnDemandClient) OnStructMessage(c ws.Speaker, m *ws.StructMessage) {
 		}
 		o.subscriberPool.BroadcastMessage(ws.NewStructMessage(o.wsNotificationNamespace, "NodeUpdated", resource))
 	case "StopReply":
+		// the task was unregistered when the stop request was sent, a task
+		// registered since then, when the resource is updated, has to be kept
 		if m.Status == http.StatusOK {
-			o.removeRegisteredNode(rawQuery.NodeID, resource.ID())
+			logging.GetLogger().Debugf("%s stop request succeeded %v", o.resourceName, m.Debug())
 		} else {
 			logging.GetLogger().Debugf("%s stop request failed %v", o.resourceName, m.Debug())
 		}
@@ -350,6 +352,25 @@ func (o *OnDemandClient) onResourceDeleted(resource types.Resource) {
 	o.unregisterResource(resource)
 }
 
+// onResourceUpdated stops the tasks started with the previous version of
+// the resource and starts new ones with the updated resource
+func (o *OnDemandClient) onResourceUpdated(resource types.Resource) {
+	if !o.IsMaster() {
+		return
+	}
+
+	o.graph.RLock()
+	filter := filters.NewTermStringFilter(fmt.Sprintf("%ss.ID", o.resourceName), resource.ID())
+	nodes := o.graph.GetNodes(graph.NewElementFilter(filter))
+	o.graph.RUnlock()
+
+	for _, node := range nodes {
+		o.unregisterTask(node, resource)
+	}
+
+	o.registerResource(resource)
+}
+
 // OnStartAsMaster event
 func (o *OnDemandClient) OnStartAsMaster() {
 }
@@ -379,9 +400,12 @@ func (o *OnDemandClient) OnSwitchToSlave() {
 func (o *OnDemandClient) onAPIWatcherEvent(action string, id string, resource types.Resource) {
 	logging.GetLogger().Debugf("New watcher event %s for %s", action, id)
 	switch action {
-	case "init", "create", "set", "update":
+	case "init", "create", "set":
 		o.subscriberPool.BroadcastMessage(ws.NewStructMessage(o.wsNotificationNamespace, "Added", resource))
 		o.onResourceAdded(resource)
+	case "update":
+		o.subscriberPool.BroadcastMessage(ws.NewStructMessage(o.wsNotificationNamespace, "Updated", resource))
+		o.onResourceUpdated(resource)
 	case "expire", "delete":
 		o.subscriberPool.BroadcastMessage(ws.NewStructMessage(o.wsNotificationNamespace, "Deleted", resource))
 		o.onResourceDeleted(resource)
@@ -32,6 +32,7 @@ import (
 	ge "github.com/skydive-project/skydive/gremlin/traversal"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/ondemand/client"
+	"github.com/skydive-project/skydive/rbac"
 	"github.com/skydive-project/skydive/topology"
 	"github.com/skydive-project/skydive/validator"
 	ws "github.com/skydive-project/skydive/websocket"
@@ -61,8 +62,8 @@ type onDemandPacketInjectionHandler struct {
 	graph *graph.Graph
 }
 
-func (h *onDemandPacketInjectionHandler) getNode(gremlinQuery string) *graph.Node {
-	values := h.applyGremlinExpr(gremlinQuery)
+func (h *onDemandPacketInjectionHandler) getNode(g *graph.Graph, gremlinQuery string) *graph.Node {
+	values := h.applyGremlinExpr(g, gremlinQuery)
 	for _, value := range values {
 		switch value.(type) {
 		case *graph.Node:
@@ -85,7 +86,12 @@ func (h *onDemandPacketInjectionHandler) createRequest(nodeID graph.Identifier,
 
 	var dstNode *graph.Node
 	if pi.Dst != "" {
-		dstNode = h.getNode(pi.Dst)
+		// the destination is looked up within the nodes of the tenant
+		g, err := rbac.GetTenantGraph(h.graph, pi.Tenant, nil, false)
+		if err != nil {
+			return "", nil, err
+		}
+		dstNode = h.getNode(g, pi.Dst)
 	}
 
 	srcMAC, _ := net.ParseMAC(pi.SrcMAC)
@@ -240,7 +246,14 @@ func (h *onDemandPacketInjectionHandler) GetNodeResources(resource types.Resourc
 	query := pi.Src
 	query += fmt.Sprintf(".Dedup('TID').Has('PacketInjections.ID', NEE('%s'))", resource.ID())
 
-	if nodes := h.applyGremlinExpr(query); len(nodes) > 0 {
+	// the injections of a tenant are only started on the nodes of the tenant
+	g, err := rbac.GetTenantGraph(h.graph, pi.Tenant, nil, false)
+	if err != nil {
+		logging.GetLogger().Errorf("Unable to apply packet injection %s: %s", pi.UUID, err)
+		return nil
+	}
+
+	if nodes := h.applyGremlinExpr(g, query); len(nodes) > 0 {
 		id := pi.ICMPID
 		srcPort := pi.SrcPort
 
@@ -285,8 +298,8 @@ func (h *onDemandPacketInjectionHandler) GetNodeResources(resource types.Resourc
 	return nrs
 }
 
-func (h *onDemandPacketInjectionHandler) applyGremlinExpr(query string) []interface{} {
-	res, err := ge.TopologyGremlinQuery(h.graph, query)
+func (h *onDemandPacketInjectionHandler) applyGremlinExpr(g *graph.Graph, query string) []interface{} {
+	res, err := ge.TopologyGremlinQuery(g, query)
 	if err != nil {
 		logging.GetLogger().Errorf("Gremlin %s error: %s", query, err)
 		return nil
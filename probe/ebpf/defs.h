@@ -3,8 +3,10 @@
 
 #define _GNU_SOURCE
 
-// from iovisor/gobpf
+// from iovisor/gobpf, unless already defined by the kernel headers
+#ifndef _UAPI__LINUX_BPF_H__
 #include "bpf.h"
+#endif
 #include "bpf_map.h"
 
 #ifndef __inline
@@ -58,7 +60,26 @@ static __inline int discret_memcmp(char *s1, char *s2, int n)
  */
 #define MAP(NAME) struct bpf_map_def __section("maps/" #NAME) NAME =
 #define SOCKET(NAME) __section("socket_" #NAME)
+#define KPROBE(NAME) __section("kprobe/" #NAME)
+#define KRETPROBE(NAME) __section("kretprobe/" #NAME)
+#define TRACEPOINT(CATEGORY, NAME) __section("tracepoint/" #CATEGORY "/" #NAME)
 #define LICENSE __section("license")
+/* let the loader use the version of the running kernel */
+#define VERSION __section("version")
+#define ANY_KERNEL_VERSION 0xFFFFFFFE
+
+/* arguments of the kprobed functions */
+#if defined(__x86_64__)
+#define PT_REGS_PARM1(x) ((x)->di)
+#define PT_REGS_PARM2(x) ((x)->si)
+#define PT_REGS_PARM3(x) ((x)->dx)
+#define PT_REGS_RC(x) ((x)->ax)
+#elif defined(__aarch64__)
+#define PT_REGS_PARM1(x) ((x)->regs[0])
+#define PT_REGS_PARM2(x) ((x)->regs[1])
+#define PT_REGS_PARM3(x) ((x)->regs[2])
+#define PT_REGS_RC(x) ((x)->regs[0])
+#endif
 
 /* llvm built-in functions */
 unsigned long long load_byte(void *skb,
@@ -95,6 +116,10 @@ static unsigned long long (*bpf_get_current_pid_tgid)(void) =
     (void *)BPF_FUNC_get_current_pid_tgid;
 static unsigned long long (*bpf_get_current_uid_gid)(void) =
     (void *)BPF_FUNC_get_current_uid_gid;
+static int (*bpf_get_current_comm)(void *buf, int buf_size) =
+    (void *)BPF_FUNC_get_current_comm;
+static int (*bpf_probe_read)(void *dst, int size, void *unsafe_ptr) =
+    (void *)BPF_FUNC_probe_read;
 
 #define DEBUG
 #ifdef DEBUG
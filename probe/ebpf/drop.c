@@ -0,0 +1,211 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+#include <linux/skbuff.h>
+#include <linux/netdevice.h>
+#include <linux/if_ether.h>
+#include <linux/in.h>
+#include <linux/ip.h>
+#include <linux/ipv6.h>
+#include <net/net_namespace.h>
+
+#include "defs.h"
+#include "drop.h"
+
+/* the skbaddr and location fields of the kfree_skb record keep the same
+ * offsets across the kernel versions, unlike the reason field */
+#define KFREE_SKB_SKBADDR_OFFSET 8
+#define KFREE_SKB_LOCATION_OFFSET 16
+
+MAP(drop_config){
+	.type = BPF_MAP_TYPE_ARRAY,
+	.key_size = sizeof(__u32),
+	.value_size = sizeof(struct drop_config),
+	.max_entries = 1,
+};
+
+MAP(drop_stats_table){
+	.type = BPF_MAP_TYPE_HASH,
+	.key_size = sizeof(struct drop_key),
+	.value_size = sizeof(struct drop_stats),
+	.max_entries = 65536,
+};
+
+static inline void fill_interface(struct sk_buff *skb, struct drop_key *key)
+{
+	struct net_device *dev = NULL;
+
+	bpf_probe_read(&dev, sizeof(dev), &skb->dev);
+	if (dev == NULL)
+	{
+		return;
+	}
+
+	bpf_probe_read(&key->ifindex, sizeof(key->ifindex), &dev->ifindex);
+
+#ifdef CONFIG_NET_NS
+	struct net *net = NULL;
+
+	bpf_probe_read(&net, sizeof(net), &dev->nd_net.net);
+	if (net != NULL)
+	{
+		bpf_probe_read(&key->netns, sizeof(key->netns), &net->ns.inum);
+	}
+#endif
+}
+
+static inline void fill_ports(struct drop_key *key, unsigned char *transport)
+{
+	__u16 sport = 0, dport = 0;
+
+	switch (key->ip_proto)
+	{
+	case IPPROTO_TCP:
+	case IPPROTO_UDP:
+	case IPPROTO_SCTP:
+		// the ports are the first fields of these headers
+		bpf_probe_read(&sport, sizeof(sport), transport);
+		bpf_probe_read(&dport, sizeof(dport), transport + 2);
+		key->sport = bpf_ntohs(sport);
+		key->dport = bpf_ntohs(dport);
+		break;
+	}
+}
+
+static inline void fill_addresses(struct sk_buff *skb, struct drop_key *key)
+{
+	unsigned char *head = NULL;
+	__u16 network_header = 0;
+
+	bpf_probe_read(&head, sizeof(head), &skb->head);
+	bpf_probe_read(&network_header, sizeof(network_header), &skb->network_header);
+
+	// network header not parsed yet
+	if (head == NULL || network_header == (__u16)~0U)
+	{
+		return;
+	}
+
+	unsigned char *network = head + network_header;
+
+	switch (key->protocol)
+	{
+	case ETH_P_IP:
+	{
+		struct iphdr iph;
+
+		bpf_probe_read(&iph, sizeof(iph), network);
+		if (iph.version != 4)
+		{
+			return;
+		}
+
+		key->ip_proto = iph.protocol;
+		memcpy(key->saddr, &iph.saddr, 4);
+		memcpy(key->daddr, &iph.daddr, 4);
+
+		fill_ports(key, network + iph.ihl * 4);
+		break;
+	}
+	case ETH_P_IPV6:
+	{
+		struct ipv6hdr ip6h;
+
+		bpf_probe_read(&ip6h, sizeof(ip6h), network);
+		if (ip6h.version != 6)
+		{
+			return;
+		}
+
+		// extension headers are not followed
+		key->ip_proto = ip6h.nexthdr;
+		memcpy(key->saddr, &ip6h.saddr, 16);
+		memcpy(key->daddr, &ip6h.daddr, 16);
+
+		fill_ports(key, network + sizeof(ip6h));
+		break;
+	}
+	}
+}
+
+TRACEPOINT(skb, kfree_skb)
+int tracepoint__kfree_skb(void *ctx)
+{
+	struct sk_buff *skb = NULL;
+	struct drop_key key;
+	struct drop_stats *stats, new_stats;
+	__u64 tm = bpf_ktime_get_ns();
+	__u32 zero = 0;
+	__u32 len = 0;
+	__u16 protocol = 0;
+
+	struct drop_config *config = bpf_map_lookup_element(&drop_config, &zero);
+	if (config == NULL)
+	{
+		return 0;
+	}
+
+	__builtin_memset(&key, 0, sizeof(key));
+
+	bpf_probe_read(&skb, sizeof(skb), ctx + KFREE_SKB_SKBADDR_OFFSET);
+	bpf_probe_read(&key.location, sizeof(key.location), ctx + KFREE_SKB_LOCATION_OFFSET);
+	if (skb == NULL)
+	{
+		return 0;
+	}
+
+	// constant offsets for the verifier, the reason field moving once
+	// the receiving socket was added to the record
+	switch (config->reason_offset)
+	{
+	case 28:
+		bpf_probe_read(&key.reason, sizeof(key.reason), ctx + 28);
+		break;
+	case 36:
+		bpf_probe_read(&key.reason, sizeof(key.reason), ctx + 36);
+		break;
+	}
+
+	bpf_probe_read(&len, sizeof(len), &skb->len);
+	bpf_probe_read(&protocol, sizeof(protocol), &skb->protocol);
+	key.protocol = bpf_ntohs(protocol);
+
+	fill_interface(skb, &key);
+	fill_addresses(skb, &key);
+
+	stats = bpf_map_lookup_element(&drop_stats_table, &key);
+	if (stats != NULL)
+	{
+		__sync_fetch_and_add(&stats->packets, 1);
+		__sync_fetch_and_add(&stats->bytes, len);
+		stats->last = tm;
+
+		return 0;
+	}
+
+	new_stats.start = tm;
+	new_stats.last = tm;
+	new_stats.packets = 1;
+	new_stats.bytes = len;
+
+	bpf_map_update_element(&drop_stats_table, &key, &new_stats, BPF_NOEXIST);
+
+	return 0;
+}
+
+char _license[] LICENSE = "GPL";
+__u32 _version VERSION = ANY_KERNEL_VERSION;
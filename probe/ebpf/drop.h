@@ -0,0 +1,60 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+#ifndef __DROP_H
+#define __DROP_H
+
+#include <linux/types.h>
+
+/* offsets of the fields of the kfree_skb tracepoint record, which depend on
+ * the kernel version, filled from the tracepoint format by the agent. A zero
+ * reason offset means that the kernel doesn't report drop reasons. */
+struct drop_config {
+	__u32 protocol_offset;
+	__u32 reason_offset;
+};
+
+struct drop_key {
+	/* address of the kernel function dropping the packet */
+	__u64 location;
+	__u32 netns;
+	__u32 ifindex;
+	__u32 reason;
+
+	/* ethernet type, host byte order */
+	__u16 protocol;
+	__u8 ip_proto;
+	__u8 _pad;
+
+	/* IPv4 addresses use the first 4 bytes */
+	__u8 saddr[16];
+	__u8 daddr[16];
+	__u16 sport;
+	__u16 dport;
+	__u32 _pad2;
+};
+
+struct drop_stats {
+	/* times in ns, from the monotonic clock */
+	__u64 start;
+	__u64 last;
+
+	__u64 packets;
+	__u64 bytes;
+};
+
+#endif
@@ -0,0 +1,209 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+#include <linux/ptrace.h>
+#include <net/sock.h>
+#include <net/tcp_states.h>
+
+#include "defs.h"
+#include "socket.h"
+
+MAP(socket_stats_table){
+	.type = BPF_MAP_TYPE_HASH,
+	.key_size = sizeof(__u64),
+	.value_size = sizeof(struct socket_stats),
+	.max_entries = 65536,
+};
+
+static inline void fill_addresses(struct sock *sk, struct socket_stats *stats)
+{
+	__u16 family = 0;
+	__u16 dport = 0;
+
+	bpf_probe_read(&family, sizeof(family), &sk->__sk_common.skc_family);
+	bpf_probe_read(&stats->local_port, sizeof(stats->local_port), &sk->__sk_common.skc_num);
+	bpf_probe_read(&dport, sizeof(dport), &sk->__sk_common.skc_dport);
+
+	stats->family = family;
+	stats->remote_port = bpf_ntohs(dport);
+
+	switch (family)
+	{
+	case AF_INET:
+		bpf_probe_read(stats->local_addr, 4, &sk->__sk_common.skc_rcv_saddr);
+		bpf_probe_read(stats->remote_addr, 4, &sk->__sk_common.skc_daddr);
+		break;
+	case AF_INET6:
+		bpf_probe_read(stats->local_addr, 16, &sk->__sk_common.skc_v6_rcv_saddr);
+		bpf_probe_read(stats->remote_addr, 16, &sk->__sk_common.skc_v6_daddr);
+		break;
+	}
+}
+
+static inline void new_socket(struct sock *sk, __u8 role, __u64 tm)
+{
+	struct socket_stats stats;
+	__u64 key = (__u64)sk;
+
+	__builtin_memset(&stats, 0, sizeof(stats));
+
+	// called from the context of the process connecting or accepting
+	stats.pid = bpf_get_current_pid_tgid() >> 32;
+	bpf_get_current_comm(stats.comm, sizeof(stats.comm));
+
+	stats.role = role;
+	stats.start = tm;
+	stats.last = tm;
+
+	if (role == SOCKET_ROLE_CLIENT)
+	{
+		// the ports are not allocated yet
+		stats.connect_start = tm;
+	}
+	else
+	{
+		fill_addresses(sk, &stats);
+	}
+
+	bpf_map_update_element(&socket_stats_table, &key, &stats, BPF_ANY);
+}
+
+static inline void switch_phase(struct socket_stats *stats, __u8 phase, __u8 response_role, __u64 tm)
+{
+	if (stats->phase == phase)
+	{
+		return;
+	}
+
+	// a client receiving after sending, or a server sending after receiving,
+	// got or sent the response of a request
+	if (stats->phase != SOCKET_PHASE_NONE && stats->role == response_role)
+	{
+		__u64 latency = tm - stats->phase_start;
+
+		stats->requests++;
+		stats->latency_sum += latency;
+		if (latency > stats->latency_max)
+		{
+			stats->latency_max = latency;
+		}
+	}
+
+	stats->phase = phase;
+	stats->phase_start = tm;
+}
+
+KPROBE(tcp_set_state)
+int kprobe__tcp_set_state(struct pt_regs *ctx)
+{
+	struct sock *sk = (struct sock *)PT_REGS_PARM1(ctx);
+	int state = (int)PT_REGS_PARM2(ctx);
+	__u64 key = (__u64)sk;
+	__u64 tm = bpf_ktime_get_ns();
+	struct socket_stats *stats;
+
+	switch (state)
+	{
+	case TCP_SYN_SENT:
+		new_socket(sk, SOCKET_ROLE_CLIENT, tm);
+		break;
+	case TCP_ESTABLISHED:
+		stats = bpf_map_lookup_element(&socket_stats_table, &key);
+		if (stats != NULL && stats->role == SOCKET_ROLE_CLIENT && stats->connect_latency == 0)
+		{
+			stats->connect_latency = tm - stats->connect_start;
+			stats->last = tm;
+			fill_addresses(sk, stats);
+		}
+		break;
+	case TCP_CLOSE:
+		stats = bpf_map_lookup_element(&socket_stats_table, &key);
+		if (stats != NULL)
+		{
+			// removed from the table once reported
+			stats->closed = 1;
+			stats->last = tm;
+		}
+		break;
+	}
+
+	return 0;
+}
+
+KRETPROBE(inet_csk_accept)
+int kretprobe__inet_csk_accept(struct pt_regs *ctx)
+{
+	struct sock *sk = (struct sock *)PT_REGS_RC(ctx);
+
+	if (sk != NULL)
+	{
+		new_socket(sk, SOCKET_ROLE_SERVER, bpf_ktime_get_ns());
+	}
+
+	return 0;
+}
+
+KPROBE(tcp_sendmsg)
+int kprobe__tcp_sendmsg(struct pt_regs *ctx)
+{
+	struct sock *sk = (struct sock *)PT_REGS_PARM1(ctx);
+	size_t size = (size_t)PT_REGS_PARM3(ctx);
+	__u64 key = (__u64)sk;
+	__u64 tm = bpf_ktime_get_ns();
+
+	struct socket_stats *stats = bpf_map_lookup_element(&socket_stats_table, &key);
+	if (stats == NULL)
+	{
+		return 0;
+	}
+
+	__sync_fetch_and_add(&stats->bytes_sent, size);
+	switch_phase(stats, SOCKET_PHASE_SEND, SOCKET_ROLE_SERVER, tm);
+	stats->last = tm;
+
+	return 0;
+}
+
+// called with the number of bytes copied to the process once received
+KPROBE(tcp_cleanup_rbuf)
+int kprobe__tcp_cleanup_rbuf(struct pt_regs *ctx)
+{
+	struct sock *sk = (struct sock *)PT_REGS_PARM1(ctx);
+	int copied = (int)PT_REGS_PARM2(ctx);
+	__u64 key = (__u64)sk;
+	__u64 tm = bpf_ktime_get_ns();
+
+	if (copied <= 0)
+	{
+		return 0;
+	}
+
+	struct socket_stats *stats = bpf_map_lookup_element(&socket_stats_table, &key);
+	if (stats == NULL)
+	{
+		return 0;
+	}
+
+	__sync_fetch_and_add(&stats->bytes_received, copied);
+	switch_phase(stats, SOCKET_PHASE_RECV, SOCKET_ROLE_CLIENT, tm);
+	stats->last = tm;
+
+	return 0;
+}
+
+char _license[] LICENSE = "GPL";
+__u32 _version VERSION = ANY_KERNEL_VERSION;
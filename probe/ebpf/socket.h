@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy ofthe License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specificlanguage governing permissions and
+ * limitations under the License.
+ *
+ */
+
+#ifndef __SOCKET_H
+#define __SOCKET_H
+
+#include <linux/types.h>
+
+#define SOCKET_COMM_LEN 16
+
+enum {
+	SOCKET_ROLE_CLIENT = 1,
+	SOCKET_ROLE_SERVER = 2,
+};
+
+/* a connection alternates between sending and receiving phases, the
+ * delay between a request and its response being measured when switching
+ * from one to the other */
+enum {
+	SOCKET_PHASE_NONE = 0,
+	SOCKET_PHASE_SEND = 1,
+	SOCKET_PHASE_RECV = 2,
+};
+
+struct socket_stats {
+	/* times in ns, from the monotonic clock */
+	__u64 start;
+	__u64 last;
+	__u64 connect_start;
+	__u64 connect_latency;
+
+	__u64 bytes_sent;
+	__u64 bytes_received;
+
+	__u64 phase_start;
+	__u64 requests;
+	__u64 latency_sum;
+	__u64 latency_max;
+
+	__u64 pid;
+	char comm[SOCKET_COMM_LEN];
+
+	/* IPv4 addresses use the first 4 bytes */
+	__u8 local_addr[16];
+	__u8 remote_addr[16];
+	__u16 local_port;
+	__u16 remote_port;
+	__u16 family;
+
+	__u8 role;
+	__u8 phase;
+	__u8 closed;
+	__u8 _pad[7];
+};
+
+#endif
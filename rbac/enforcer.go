@@ -18,6 +18,8 @@
 package rbac
 
 import (
+	"strings"
+
 	"github.com/casbin/casbin"
 	"github.com/casbin/casbin/model"
 	etcd "github.com/coreos/etcd/client"
@@ -30,7 +32,56 @@ type Permission struct {
 	Allowed bool
 }
 
-var enforcer *casbin.SyncedEnforcer
+// ServiceAccountPrefix is the prefix of the subjects of the service
+// accounts. Their roles are never stored in the policy, they are resolved
+// on each request from the API token used to authenticate.
+const ServiceAccountPrefix = "sa:"
+
+// ServiceAccountResolver returns the roles currently granted by a token
+// of a service account
+type ServiceAccountResolver func(account, tokenID string) []string
+
+var (
+	enforcer               *casbin.SyncedEnforcer
+	serviceAccountResolver ServiceAccountResolver
+)
+
+// ServiceAccountSubject returns the subject of the requests authenticated
+// by a token of a service account, "sa:<account>/<token>"
+func ServiceAccountSubject(account, tokenID string) string {
+	return ServiceAccountPrefix + account + "/" + tokenID
+}
+
+// ParseServiceAccountSubject returns the service account and the token
+// of a service account subject
+func ParseServiceAccountSubject(sub string) (account, tokenID string) {
+	s := strings.TrimPrefix(sub, ServiceAccountPrefix)
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// IsServiceAccount returns whether a subject is a service account
+func IsServiceAccount(sub string) bool {
+	return strings.HasPrefix(sub, ServiceAccountPrefix)
+}
+
+// SetServiceAccountResolver sets the function used to resolve the roles
+// of the service accounts
+func SetServiceAccountResolver(resolver ServiceAccountResolver) {
+	serviceAccountResolver = resolver
+}
+
+// serviceAccountRoles returns the roles granted by the token of a service
+// account subject, none if no resolver was set
+func serviceAccountRoles(sub string) []string {
+	account, tokenID := ParseServiceAccountSubject(sub)
+	if serviceAccountResolver == nil || tokenID == "" {
+		return []string{}
+	}
+	return serviceAccountResolver(account, tokenID)
+}
 
 // Init loads the model from the configuration file then the policies.
 // 3 policies are applied, in that order :
@@ -75,24 +126,47 @@ func Enforce(sub, obj, act string) bool {
 		return true
 	}
 
+	if IsServiceAccount(sub) {
+		for _, role := range serviceAccountRoles(sub) {
+			if enforcer.Enforce(role, obj, act) {
+				return true
+			}
+		}
+		return false
+	}
+
 	return enforcer.Enforce(sub, obj, act)
 }
 
-// AddRoleForUser registers a role for a user
+// AddRoleForUser registers a role for a user. No role can be registered
+// for a service account.
 func AddRoleForUser(user, role string) bool {
-	if enforcer == nil {
+	if enforcer == nil || IsServiceAccount(user) {
 		return false
 	}
 
 	return enforcer.AddRoleForUser(user, role)
 }
 
+// DeleteRoleForUser revokes a role of a user
+func DeleteRoleForUser(user, role string) bool {
+	if enforcer == nil {
+		return false
+	}
+
+	return enforcer.DeleteRoleForUser(user, role)
+}
+
 // GetUserRoles returns the roles of a user
 func GetUserRoles(user string) []string {
 	if enforcer == nil {
 		return []string{}
 	}
 
+	if IsServiceAccount(user) {
+		return serviceAccountRoles(user)
+	}
+
 	return enforcer.GetRolesForUser(user)
 }
 
@@ -102,8 +176,12 @@ func GetPermissionsForUser(user string) []Permission {
 		return nil
 	}
 
-	subjects := enforcer.GetRolesForUser(user)
-	subjects = append(subjects, user)
+	var subjects []string
+	if IsServiceAccount(user) {
+		subjects = serviceAccountRoles(user)
+	} else {
+		subjects = append(enforcer.GetRolesForUser(user), user)
+	}
 
 	mperms := make(map[string]Permission)
 	for _, subject := range subjects {
@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rbac
+
+import (
+	"testing"
+
+	"github.com/casbin/casbin"
+)
+
+func TestServiceAccountRoles(t *testing.T) {
+	m := casbin.NewModel()
+	m.AddDef("r", "r", "sub, obj, act")
+	m.AddDef("p", "p", "sub, obj, act, eft")
+	m.AddDef("g", "g", "_, _")
+	m.AddDef("e", "e", "some(where (p.eft == allow)) && !some(where (p.eft == deny))")
+	m.AddDef("m", "m", "g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act")
+
+	enforcer = casbin.NewSyncedEnforcer(m)
+	enforcer.AddPolicy("admin", "topology", "write", "allow")
+	enforcer.BuildRoleLinks()
+	defer func() { enforcer, serviceAccountResolver = nil, nil }()
+
+	tokens := map[string][]string{"1": {"admin"}, "2": {"guest"}}
+	SetServiceAccountResolver(func(account, tokenID string) []string {
+		if account != "agent" {
+			return nil
+		}
+		return tokens[tokenID]
+	})
+
+	subject := ServiceAccountSubject("agent", "1")
+	if !Enforce(subject, "topology", "write") {
+		t.Fatal("The role of a valid token should be granted to its service account")
+	}
+
+	if Enforce("agent", "topology", "write") {
+		t.Error("The role of a token should not be granted to a user with the same name")
+	}
+
+	// the roles of the other tokens of the account are not combined
+	if Enforce(ServiceAccountSubject("agent", "2"), "topology", "write") {
+		t.Error("The role of a token should only be granted to the requests made with it")
+	}
+
+	if Enforce(ServiceAccountPrefix+"agent", "topology", "write") {
+		t.Error("A service account subject without token should not have any role")
+	}
+
+	// the token was revoked or expired
+	delete(tokens, "1")
+
+	if Enforce(subject, "topology", "write") {
+		t.Error("The role of a revoked token should not be granted anymore")
+	}
+
+	if AddRoleForUser(subject, "admin") {
+		t.Error("No role should be stored for a service account")
+	}
+
+	if Enforce(subject, "topology", "write") || len(GetUserRoles(subject)) != 0 {
+		t.Error("A service account should not have any role without a valid token")
+	}
+}
@@ -0,0 +1,284 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+
+	"github.com/skydive-project/skydive/graffiti/graph"
+	"github.com/skydive-project/skydive/graffiti/graph/traversal"
+	"github.com/skydive-project/skydive/logging"
+)
+
+const etcdTenantsKey = "/tenants"
+
+// Tenant defines a set of users and roles sharing the same view of the
+// topology. The view is defined by a Gremlin filter returning a graph,
+// for instance G.V().Has('Tenant', 'team-a').SubGraph()
+type Tenant struct {
+	Name   string
+	Filter string
+	Users  []string
+	Roles  []string
+}
+
+var tenants struct {
+	sync.RWMutex
+	config map[string]*Tenant
+	etcd   map[string]*Tenant
+}
+
+// parseTenants decodes the tenants stored in etcd, a JSON object
+// with the tenant names as keys
+func parseTenants(value string) (map[string]*Tenant, error) {
+	m := make(map[string]*Tenant)
+	if value == "" {
+		return m, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &m); err != nil {
+		return nil, fmt.Errorf("Unable to decode tenants: %s", err)
+	}
+
+	for name, tenant := range m {
+		if tenant.Filter == "" {
+			return nil, fmt.Errorf("No filter specified for tenant %s", name)
+		}
+		tenant.Name = name
+	}
+
+	return m, nil
+}
+
+func setEtcdTenants(value string) {
+	m, err := parseTenants(value)
+	if err != nil {
+		logging.GetLogger().Error(err)
+		return
+	}
+
+	tenants.Lock()
+	tenants.etcd = m
+	tenants.Unlock()
+}
+
+// loadEtcdTenants reads the tenants stored in etcd and returns the index
+// to watch the modifications from
+func loadEtcdTenants(kapi etcd.KeysAPI) (uint64, error) {
+	resp, err := kapi.Get(context.Background(), etcdTenantsKey, nil)
+	if err != nil {
+		if etcdErr, ok := err.(etcd.Error); ok && etcdErr.Code == etcd.ErrorCodeKeyNotFound {
+			setEtcdTenants("")
+			return etcdErr.Index, nil
+		}
+		return 0, err
+	}
+
+	setEtcdTenants(resp.Node.Value)
+	return resp.Index, nil
+}
+
+// watchTenants applies the modifications of the tenants stored in etcd.
+// When the watcher fails, for instance when etcd restarts or when the
+// index was cleared, the tenants are read again and a new watcher is
+// created so that no modification is missed.
+func watchTenants(kapi etcd.KeysAPI, index uint64) {
+	for {
+		watcher := kapi.Watcher(etcdTenantsKey, &etcd.WatcherOptions{AfterIndex: index})
+		for {
+			res, err := watcher.Next(context.Background())
+			if err != nil {
+				logging.GetLogger().Errorf("Error while watching tenants: %s", err)
+				break
+			}
+			index = res.Node.ModifiedIndex
+
+			switch res.Action {
+			case "set", "update", "create", "compareAndSwap":
+				setEtcdTenants(res.Node.Value)
+			case "delete", "expire", "compareAndDelete":
+				setEtcdTenants("")
+			}
+		}
+
+		time.Sleep(1 * time.Second)
+
+		if i, err := loadEtcdTenants(kapi); err != nil {
+			logging.GetLogger().Errorf("Unable to read tenants: %s", err)
+		} else {
+			index = i
+		}
+	}
+}
+
+// InitTenants registers the tenants defined in the configuration and
+// watches the ones stored in etcd. Tenants stored in etcd take precedence
+// over the ones with the same name in the configuration.
+func InitTenants(kapi etcd.KeysAPI, configTenants []*Tenant) error {
+	m := make(map[string]*Tenant)
+	for _, tenant := range configTenants {
+		if tenant.Filter == "" {
+			return fmt.Errorf("No filter specified for tenant %s", tenant.Name)
+		}
+		m[tenant.Name] = tenant
+	}
+
+	tenants.Lock()
+	tenants.config = m
+	tenants.Unlock()
+
+	if kapi == nil {
+		return nil
+	}
+
+	index, err := loadEtcdTenants(kapi)
+	if err != nil {
+		return err
+	}
+
+	go watchTenants(kapi, index)
+
+	return nil
+}
+
+// GetTenants returns all the tenants
+func GetTenants() []*Tenant {
+	tenants.RLock()
+	defer tenants.RUnlock()
+
+	var all []*Tenant
+	for name, tenant := range tenants.config {
+		if _, found := tenants.etcd[name]; !found {
+			all = append(all, tenant)
+		}
+	}
+	for _, tenant := range tenants.etcd {
+		all = append(all, tenant)
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+
+	return all
+}
+
+// GetTenant returns the tenant with the given name
+func GetTenant(name string) *Tenant {
+	tenants.RLock()
+	defer tenants.RUnlock()
+
+	if tenant, found := tenants.etcd[name]; found {
+		return tenant
+	}
+	return tenants.config[name]
+}
+
+// GetUserTenant returns the tenant of a user, either because the user is
+// a member of the tenant or because one of its roles is. Tenants listing
+// the user explicitly take precedence over the ones listing its roles.
+// A nil value is returned for the users not belonging to any tenant,
+// they are not restricted to a part of the topology.
+func GetUserTenant(user string) *Tenant {
+	all := GetTenants()
+
+	// service accounts are listed without the token of the subject
+	member := user
+	if IsServiceAccount(user) {
+		account, _ := ParseServiceAccountSubject(user)
+		member = ServiceAccountPrefix + account
+	}
+
+	for _, tenant := range all {
+		for _, u := range tenant.Users {
+			if u == member {
+				return tenant
+			}
+		}
+	}
+
+	roles := GetUserRoles(user)
+	for _, tenant := range all {
+		for _, r := range tenant.Roles {
+			for _, role := range roles {
+				if r == role {
+					return tenant
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Graph returns the part of a graph the tenant has access to. The returned
+// graph is restricted so that the flows are only looked up for its nodes.
+// The core Gremlin steps are used to parse the filter if no parser is given.
+func (t *Tenant) Graph(g *graph.Graph, parser *traversal.GremlinTraversalParser, lockGraph bool) (*graph.Graph, error) {
+	if t.Filter == "" {
+		return nil, fmt.Errorf("No filter specified for tenant %s", t.Name)
+	}
+
+	if parser == nil {
+		parser = traversal.NewGremlinTraversalParser()
+	}
+
+	ts, err := parser.Parse(strings.NewReader(t.Filter))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter for tenant %s: %s", t.Name, err)
+	}
+
+	sub, err := ts.ExecGraph(g, lockGraph)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to apply the filter of tenant %s: %s", t.Name, err)
+	}
+
+	context := sub.GetContext()
+	context.Restricted = true
+	return sub.CloneWithContext(context)
+}
+
+// GetTenantGraph returns the part of a graph the tenant with the given name
+// has access to, the whole graph if no name is given
+func GetTenantGraph(g *graph.Graph, name string, parser *traversal.GremlinTraversalParser, lockGraph bool) (*graph.Graph, error) {
+	if name == "" {
+		return g, nil
+	}
+
+	tenant := GetTenant(name)
+	if tenant == nil {
+		return nil, fmt.Errorf("Unknown tenant %s", name)
+	}
+
+	return tenant.Graph(g, parser, lockGraph)
+}
+
+// GetUserFilter returns the Gremlin filter restricting the view of a user,
+// an empty string if the user doesn't belong to any tenant
+func GetUserFilter(user string) string {
+	if tenant := GetUserTenant(user); tenant != nil {
+		return tenant.Filter
+	}
+	return ""
+}
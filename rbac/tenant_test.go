@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rbac
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+)
+
+func TestTenantEmptyFilter(t *testing.T) {
+	if _, err := parseTenants(`{"team-a": {"Users": ["alice"]}}`); err == nil {
+		t.Error("A tenant without filter should be rejected")
+	}
+
+	if err := InitTenants(nil, []*Tenant{{Name: "team-a", Users: []string{"alice"}}}); err == nil {
+		t.Error("A tenant without filter should be rejected")
+	}
+
+	tenant := &Tenant{Name: "team-a"}
+	if _, err := tenant.Graph(nil, nil, false); err == nil {
+		t.Error("A tenant without filter should not give access to the graph")
+	}
+}
+
+// fakeTenantsKeysAPI stores the tenants key, its first watcher failing
+// once the fail channel is closed and the other ones never returning
+type fakeTenantsKeysAPI struct {
+	etcd.KeysAPI
+	sync.Mutex
+	value    string
+	index    uint64
+	watchers []uint64
+	fail     chan struct{}
+}
+
+type fakeTenantsWatcher struct {
+	fail chan struct{}
+}
+
+func (w *fakeTenantsWatcher) Next(ctx context.Context) (*etcd.Response, error) {
+	<-w.fail
+	return nil, etcd.Error{Code: etcd.ErrorCodeEventIndexCleared, Message: "The event in requested index is outdated and cleared"}
+}
+
+func (k *fakeTenantsKeysAPI) Get(ctx context.Context, key string, opts *etcd.GetOptions) (*etcd.Response, error) {
+	k.Lock()
+	defer k.Unlock()
+	return &etcd.Response{Action: "get", Node: &etcd.Node{Key: key, Value: k.value}, Index: k.index}, nil
+}
+
+func (k *fakeTenantsKeysAPI) Watcher(key string, opts *etcd.WatcherOptions) etcd.Watcher {
+	k.Lock()
+	defer k.Unlock()
+
+	k.watchers = append(k.watchers, opts.AfterIndex)
+	if len(k.watchers) == 1 {
+		return &fakeTenantsWatcher{fail: k.fail}
+	}
+	return &fakeTenantsWatcher{fail: make(chan struct{})}
+}
+
+func TestTenantWatchRetry(t *testing.T) {
+	k := &fakeTenantsKeysAPI{
+		value: `{"team-a": {"Filter": "G.V()", "Users": ["alice"]}}`,
+		index: 1,
+		fail:  make(chan struct{}),
+	}
+
+	if err := InitTenants(k, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer setEtcdTenants("")
+
+	if GetTenant("team-a") == nil {
+		t.Fatal("The tenant stored in etcd should be registered")
+	}
+
+	// the tenants are modified while the watcher fails
+	k.Lock()
+	k.value, k.index = `{"team-b": {"Filter": "G.V()", "Users": ["alice"]}}`, 5
+	k.Unlock()
+	close(k.fail)
+
+	for i := 0; i < 50 && GetTenant("team-b") == nil; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if GetTenant("team-b") == nil || GetTenant("team-a") != nil {
+		t.Fatal("The tenants should have been read again after the watcher failure")
+	}
+
+	for i := 0; i < 50; i++ {
+		k.Lock()
+		watchers := append([]uint64{}, k.watchers...)
+		k.Unlock()
+
+		if len(watchers) == 2 {
+			if watchers[1] != 5 {
+				t.Errorf("Expected the new watcher to start after index 5, got %d", watchers[1])
+			}
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Error("A new watcher should have been created")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+var (
+	output  string
+	pkgname string
+)
+
+type resource struct {
+	Resource string
+	Type     string
+}
+
+// Name returns the human readable name of the resource type, 'edge rule' for EdgeRule
+func (r *resource) Name() string {
+	var words []string
+	start := 0
+	for i, c := range r.Type {
+		if i > 0 && unicode.IsUpper(c) {
+			words = append(words, strings.ToLower(r.Type[start:i]))
+			start = i
+		}
+	}
+	words = append(words, strings.ToLower(r.Type[start:]))
+	return strings.Join(words, " ")
+}
+
+// Article returns the indefinite article of the resource name
+func (r *resource) Article() string {
+	if strings.ContainsAny(r.Name()[:1], "aeiou") {
+		return "an"
+	}
+	return "a"
+}
+
+var tmpl = template.Must(template.New("client").Parse(`// Code generated - DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"github.com/skydive-project/skydive/api/types"
+	shttp "github.com/skydive-project/skydive/http"
+)
+{{range .Resources}}
+// List{{.Type}}s returns the {{.Name}}s indexed by ID
+func (c *Client) List{{.Type}}s() (map[string]*types.{{.Type}}, error) {
+	var resources map[string]*types.{{.Type}}
+	if err := c.List("{{.Resource}}", &resources); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// Get{{.Type}} returns the {{.Name}} with the given ID along with its ETag
+func (c *Client) Get{{.Type}}(id string) (*types.{{.Type}}, string, error) {
+	var resource types.{{.Type}}
+	etag, err := c.GetWithETag("{{.Resource}}", id, &resource)
+	if err != nil {
+		return nil, "", err
+	}
+	return &resource, etag, nil
+}
+
+// Create{{.Type}} creates {{.Article}} {{.Name}}, the passed {{.Name}} is filled with the created one
+func (c *Client) Create{{.Type}}(resource *types.{{.Type}}, opts *shttp.CreateOptions) error {
+	return c.Create("{{.Resource}}", resource, opts)
+}
+
+// Update{{.Type}} replaces {{.Article}} {{.Name}}, the passed {{.Name}} is filled with the updated one
+func (c *Client) Update{{.Type}}(resource *types.{{.Type}}, opts *shttp.UpdateOptions) error {
+	return c.Update("{{.Resource}}", resource.ID(), resource, opts)
+}
+
+// Delete{{.Type}} deletes the {{.Name}} with the given ID
+func (c *Client) Delete{{.Type}}(id string) error {
+	return c.Delete("{{.Resource}}", id)
+}
+{{end}}`))
+
+func main() {
+	if output == "" || flag.NArg() == 0 {
+		log.Fatalf("Usage: genclient -output <file> <resource>=<type>...")
+	}
+
+	var resources []*resource
+	for _, arg := range flag.Args() {
+		fields := strings.SplitN(arg, "=", 2)
+		if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+			log.Fatalf("Invalid resource '%s', expected <resource>=<type>", arg)
+		}
+		resources = append(resources, &resource{Resource: fields[0], Type: fields[1]})
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, struct {
+		Package   string
+		Resources []*resource
+	}{pkgname, resources}); err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func init() {
+	flag.StringVar(&pkgname, "package", os.Getenv("GOPACKAGE"), "Go package name")
+	flag.StringVar(&output, "output", "", "Go generated file")
+	flag.Parse()
+}
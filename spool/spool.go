@@ -0,0 +1,416 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package spool implements a bounded on-disk queue used to keep the
+// messages that can not be sent while a remote peer is unreachable.
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	segmentSuffix = ".spool"
+	// file holding the segment and the position of the next record to
+	// replay, so that the records are not replayed twice after a restart
+	offsetFileName = "replay.offset"
+	offsetSize     = 16
+	// number of segments a spool is split into, the oldest segment
+	// being dropped when the spool is full
+	segmentsPerSpool = 16
+	minSegmentSize   = 64 * 1024
+	recordHeaderSize = 4
+)
+
+// ErrRecordTooLarge is returned when a record doesn't fit in the spool
+var ErrRecordTooLarge = errors.New("Record larger than the spool")
+
+// Stats describes the state of a spool
+type Stats struct {
+	// size of the spool on disk in bytes
+	Size int64
+	// number of records waiting to be replayed
+	Records int64
+	// number of records dropped as the spool was full
+	Dropped int64
+}
+
+type segment struct {
+	id      uint64
+	path    string
+	size    int64
+	records int64
+	// position and number of the records already replayed
+	offset   int64
+	replayed int64
+	removed  bool
+}
+
+// Spool is a bounded FIFO of records stored in segment files. When full,
+// the oldest segment is dropped. The records survive a restart.
+type Spool struct {
+	sync.Mutex
+	dir         string
+	maxSize     int64
+	segmentSize int64
+	segments    []*segment
+	writer      *os.File
+	offsetFile  *os.File
+	size        int64
+	records     int64
+	dropped     int64
+}
+
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016d%s", id, segmentSuffix))
+}
+
+// readRecord reads a record, returning io.EOF at the end of the segment or
+// io.ErrUnexpectedEOF if the last record is truncated
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// load counts the records of an existing segment, the ones before the
+// given offset being already replayed. A truncated record, written while
+// the process was stopped, is removed.
+func (s *Spool) load(seg *segment, offset int64) error {
+	file, err := os.OpenFile(seg.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	for {
+		data, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return file.Truncate(seg.size)
+		}
+		if err != nil {
+			return err
+		}
+
+		if seg.size < offset {
+			seg.offset = seg.size + int64(recordHeaderSize+len(data))
+			seg.replayed++
+		}
+		seg.size += int64(recordHeaderSize + len(data))
+		seg.records++
+	}
+}
+
+// saveOffset records the position of the next record to replay
+func (s *Spool) saveOffset(id uint64, offset int64) error {
+	var buf [offsetSize]byte
+	binary.BigEndian.PutUint64(buf[:8], id)
+	binary.BigEndian.PutUint64(buf[8:], uint64(offset))
+
+	_, err := s.offsetFile.WriteAt(buf[:], 0)
+	return err
+}
+
+// loadOffset returns the segment and the position of the next record to
+// replay, as saved by the previous spool
+func (s *Spool) loadOffset() (uint64, int64) {
+	var buf [offsetSize]byte
+	if _, err := s.offsetFile.ReadAt(buf[:], 0); err != nil {
+		return 0, 0
+	}
+
+	return binary.BigEndian.Uint64(buf[:8]), int64(binary.BigEndian.Uint64(buf[8:]))
+}
+
+func (s *Spool) closeWriter() {
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+}
+
+func (s *Spool) newSegment() error {
+	s.closeWriter()
+
+	var id uint64
+	if len(s.segments) > 0 {
+		id = s.segments[len(s.segments)-1].id + 1
+	}
+
+	seg := &segment{id: id, path: s.segmentPath(id)}
+	writer, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	s.writer = writer
+	s.segments = append(s.segments, seg)
+	return nil
+}
+
+func (s *Spool) removeSegment(seg *segment) {
+	for i, sg := range s.segments {
+		if sg == seg {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			break
+		}
+	}
+
+	if len(s.segments) == 0 {
+		s.closeWriter()
+	}
+
+	seg.removed = true
+	s.size -= seg.size
+	s.records -= seg.records - seg.replayed
+	os.Remove(seg.path)
+
+	// the identifiers are reused once the spool is empty
+	if len(s.segments) == 0 {
+		s.saveOffset(0, 0)
+	}
+}
+
+// Append adds a record at the end of the spool. The oldest records are
+// dropped if the spool is full.
+func (s *Spool) Append(data []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	size := int64(recordHeaderSize + len(data))
+	if size > s.segmentSize {
+		s.dropped++
+		return ErrRecordTooLarge
+	}
+
+	if s.writer == nil || s.segments[len(s.segments)-1].size+size > s.segmentSize {
+		if err := s.newSegment(); err != nil {
+			return err
+		}
+	}
+
+	var header [recordHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(data)))
+	if _, err := s.writer.Write(append(header[:], data...)); err != nil {
+		return err
+	}
+
+	seg := s.segments[len(s.segments)-1]
+	seg.size += size
+	seg.records++
+	s.size += size
+	s.records++
+
+	for s.size > s.maxSize && len(s.segments) > 1 {
+		oldest := s.segments[0]
+		s.dropped += oldest.records - oldest.replayed
+		s.removeSegment(oldest)
+	}
+
+	return nil
+}
+
+// replaySegment calls fn for each record of the segment not replayed yet
+func (s *Spool) replaySegment(seg *segment, fn func(data []byte) error) error {
+	file, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	s.Lock()
+	offset := seg.offset
+	s.Unlock()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(file)
+	for {
+		data, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(data); err != nil {
+			return err
+		}
+
+		s.Lock()
+		if seg.removed {
+			s.Unlock()
+			return nil
+		}
+		seg.offset += int64(recordHeaderSize + len(data))
+		seg.replayed++
+		s.records--
+		err = s.saveOffset(seg.id, seg.offset)
+		s.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Replay calls fn for each record, from the oldest to the newest, and
+// removes the replayed records. The records appended during the replay are
+// replayed too. Replay stops at the first error returned by fn, the next
+// replay restarting from the record that failed.
+func (s *Spool) Replay(fn func(data []byte) error) error {
+	for {
+		s.Lock()
+		if len(s.segments) == 0 {
+			s.Unlock()
+			return nil
+		}
+
+		seg := s.segments[0]
+		if len(s.segments) == 1 {
+			// no more records will be written to a segment being replayed
+			s.closeWriter()
+		}
+		s.Unlock()
+
+		if err := s.replaySegment(seg, fn); err != nil {
+			return err
+		}
+
+		s.Lock()
+		if !seg.removed {
+			s.removeSegment(seg)
+		}
+		s.Unlock()
+	}
+}
+
+// Reset removes all the records
+func (s *Spool) Reset() {
+	s.Lock()
+	defer s.Unlock()
+
+	for len(s.segments) > 0 {
+		s.removeSegment(s.segments[0])
+	}
+}
+
+// Stats returns the size, the number of records and the number of
+// dropped records of the spool
+func (s *Spool) Stats() Stats {
+	s.Lock()
+	defer s.Unlock()
+
+	return Stats{Size: s.size, Records: s.records, Dropped: s.dropped}
+}
+
+// Open returns a spool stored in the given directory, holding at most
+// maxSize bytes. The records of a previous spool in the directory are kept.
+func Open(dir string, maxSize int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segmentSize := maxSize / segmentsPerSpool
+	if segmentSize < minSegmentSize {
+		segmentSize = minSegmentSize
+	}
+
+	offsetFile, err := os.OpenFile(filepath.Join(dir, offsetFileName), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		dir:         dir,
+		maxSize:     maxSize,
+		segmentSize: segmentSize,
+		offsetFile:  offsetFile,
+	}
+	offsetID, offset := s.loadOffset()
+
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seg := &segment{id: id, path: s.segmentPath(id)}
+
+		// the segments before the one being replayed were fully replayed
+		if id < offsetID {
+			os.Remove(seg.path)
+			continue
+		}
+
+		var replayedOffset int64
+		if id == offsetID {
+			replayedOffset = offset
+		}
+
+		if err := s.load(seg, replayedOffset); err != nil {
+			return nil, fmt.Errorf("Unable to load spool segment %s: %s", seg.path, err)
+		}
+
+		s.segments = append(s.segments, seg)
+		s.size += seg.size
+		s.records += seg.records - seg.replayed
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].id < s.segments[j].id })
+
+	return s, nil
+}
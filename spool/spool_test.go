@@ -0,0 +1,168 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func newSpool(t *testing.T, maxSize int64) (*Spool, string) {
+	dir, err := ioutil.TempDir("", "skydive-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Open(dir, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, dir
+}
+
+func replayAll(t *testing.T, s *Spool) (records []string) {
+	err := s.Replay(func(data []byte) error {
+		records = append(records, string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestSpoolReplay(t *testing.T) {
+	s, dir := newSpool(t, 1024*1024)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 100; i++ {
+		if err := s.Append([]byte(fmt.Sprintf("record-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stats := s.Stats(); stats.Records != 100 || stats.Dropped != 0 {
+		t.Fatalf("Expected 100 records, got %+v", stats)
+	}
+
+	// stop the replay in the middle
+	var count int
+	errStop := errors.New("stop")
+	err := s.Replay(func(data []byte) error {
+		if count == 40 {
+			return errStop
+		}
+		count++
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("Expected replay to be stopped, got %v", err)
+	}
+
+	records := replayAll(t, s)
+	if len(records) != 60 || records[0] != "record-40" || records[59] != "record-99" {
+		t.Errorf("Expected the 60 last records in order, got %v", records)
+	}
+
+	if stats := s.Stats(); stats.Records != 0 || stats.Size != 0 {
+		t.Errorf("Expected an empty spool, got %+v", stats)
+	}
+}
+
+func TestSpoolOverflow(t *testing.T) {
+	// the spool holds 16 segments of 64KB
+	s, dir := newSpool(t, 1024*1024)
+	defer os.RemoveAll(dir)
+
+	record := make([]byte, 1020)
+	for i := 0; i < 2048; i++ {
+		if err := s.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := s.Stats()
+	if stats.Size > 1024*1024 || stats.Dropped == 0 || stats.Records+stats.Dropped != 2048 {
+		t.Errorf("Expected the oldest records to be dropped, got %+v", stats)
+	}
+
+	if records := replayAll(t, s); int64(len(records)) != stats.Records {
+		t.Errorf("Expected %d records, got %d", stats.Records, len(records))
+	}
+}
+
+func TestSpoolReopen(t *testing.T) {
+	s, dir := newSpool(t, 1024*1024)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 10; i++ {
+		s.Append([]byte(fmt.Sprintf("record-%d", i)))
+	}
+	s.closeWriter()
+
+	s, err := Open(dir, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Append([]byte("record-10"))
+
+	records := replayAll(t, s)
+	if len(records) != 11 || records[0] != "record-0" || records[10] != "record-10" {
+		t.Errorf("Expected the records of the previous spool first, got %v", records)
+	}
+}
+
+func TestSpoolReopenReplayed(t *testing.T) {
+	s, dir := newSpool(t, 1024*1024)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 10; i++ {
+		s.Append([]byte(fmt.Sprintf("record-%d", i)))
+	}
+
+	// the replay is interrupted after 4 records
+	errStop := errors.New("stop")
+	var replayed int
+	s.Replay(func(data []byte) error {
+		if replayed == 4 {
+			return errStop
+		}
+		replayed++
+		return nil
+	})
+	s.closeWriter()
+
+	s, err := Open(dir, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if records := s.Stats().Records; records != 6 {
+		t.Errorf("Expected 6 records left to replay, got %d", records)
+	}
+
+	records := replayAll(t, s)
+	if len(records) != 6 || records[0] != "record-4" || records[5] != "record-9" {
+		t.Errorf("Expected the records not replayed yet, got %v", records)
+	}
+}
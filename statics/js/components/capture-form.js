@@ -64,7 +64,7 @@ Vue.component('capture-form', {
                 <option v-for="option in options" :value="option.type">{{ option.type }} ({{option.desc}})</option>\
               </select>\
             </div>\
-            <div class="form-group" v-if="captureType == \'sflow\'">\
+            <div class="form-group" v-if="captureType == \'sflow\' || captureType == \'ipfix\' || captureType == \'erspan\'">\
               <label for="port">Port</label>\
               <input id="port" type="number" class="form-control input-sm" v-model.number="port" min="0"/>\
             </div>\
@@ -83,6 +83,7 @@ Vue.component('capture-form', {
               <select id="targetType" v-model="targetType" class="form-control custom-select">\
                 <option disabled value="">Select target type</option >\
                 <option value="netflowv5">NetFlow</option>\
+                <option value="ipfix">IPFIX</option>\
                 <option value="erspanv1">Erspan</option>\
               </select>\
             </div>\
@@ -178,8 +179,11 @@ Vue.component('capture-form', {
         options[t] = [
           {"type": "afpacket", "desc": "MMap'd AF_PACKET socket reading"},
           {"type": "pcap", "desc": "Packet Capture library based probe"},
+          {"type": "afxdp", "desc": "AF_XDP socket reading - experimental"},
           {"type": "pcapsocket", "desc": "Socket reading PCAP format data"},
           {"type": "sflow", "desc": "Socket reading sFlow frames"},
+          {"type": "ipfix", "desc": "Socket collecting IPFIX and NetFlow v9 records"},
+          {"type": "erspan", "desc": "Socket collecting GRE, ERSPAN and VXLAN mirrors"},
           {"type": "ebpf", "desc": "Flow capture within kernel - experimental"},
           {"type": "ovsmirror", "desc": "Leverages mirroring to capture - experimental"}
         ];
@@ -192,6 +196,10 @@ Vue.component('capture-form', {
       options.dpdkport = [
         {"type": "dpdk", "desc": "DPDK based probe - experimental"}
       ];
+      options.host = [
+        {"type": "ebpfsocket", "desc": "Connection latency from the socket calls - experimental"},
+        {"type": "ebpfdrop", "desc": "Packets dropped by the kernel - experimental"}
+      ];
       return options[this.nodeType];
     },
 
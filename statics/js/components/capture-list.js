@@ -219,6 +219,7 @@ Vue.component('capture-list', {
           Vue.delete(this.captures, msg.Obj.UUID);
           break;
         case "Added":
+        case "Updated":
           Vue.set(this.captures, msg.Obj.UUID, msg.Obj);
           break;
         case "NodeUpdated":
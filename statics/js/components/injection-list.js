@@ -138,6 +138,7 @@ Vue.component('injection-list', {
           Vue.delete(this.injectors, msg.Obj.UUID);
           break;
         case "Added":
+        case "Updated":
           Vue.set(this.injectors, msg.Obj.UUID, msg.Obj);
           break;
         case "NodeUpdated":
@@ -8,7 +8,8 @@ var LoginComponent = {
 
   data: function() {
     return {
-      "username": ""
+      "username": "",
+      "ssoLogin": globalVars["sso-login"]
     };
   },
 
@@ -22,6 +23,7 @@ var LoginComponent = {
             <input id="password" type="password" name="password" class="form-control" placeholder="Password" required>\
           </div>\
         <button id="signin" class="btn btn-lg btn-primary btn-block" type="submit">Sign in</button>\
+        <a v-if="ssoLogin" id="signin-sso" class="btn btn-lg btn-default btn-block" :href="ssoLogin">Sign in with SSO</a>\
       </form>\
   ',
 
@@ -29,6 +29,7 @@ import (
 	"github.com/skydive-project/skydive/graffiti/graph"
 	ge "github.com/skydive-project/skydive/gremlin/traversal"
 	"github.com/skydive-project/skydive/logging"
+	"github.com/skydive-project/skydive/rbac"
 	"github.com/skydive-project/skydive/topology"
 )
 
@@ -41,6 +42,7 @@ type TopologyManager struct {
 	nodeHandler *apiServer.NodeRuleAPI
 	edgeHandler *apiServer.EdgeRuleAPI
 	graph       *graph.Graph
+	rules       map[string]types.Resource
 }
 
 // DefToMetadata converts a string in k1=v1,k2=v2,... format to a metadata object
@@ -98,8 +100,8 @@ func (tm *TopologyManager) syncTopology() {
 }
 
 func (tm *TopologyManager) createEdge(edge *types.EdgeRule) error {
-	src := tm.getNodes(edge.Src)
-	dst := tm.getNodes(edge.Dst)
+	src := tm.getNodes(edge.Tenant, edge.Src)
+	dst := tm.getNodes(edge.Tenant, edge.Dst)
 	if len(src) < 1 || len(dst) < 1 {
 		logging.GetLogger().Errorf("Source or Destination node not found")
 		return errors.New("Source or Destination node not found")
@@ -149,8 +151,8 @@ func (tm *TopologyManager) createNode(node *types.NodeRule) error {
 	return nil
 }
 
-func (tm *TopologyManager) updateMetadata(query string, mdata graph.Metadata) error {
-	nodes := tm.getNodes(query)
+func (tm *TopologyManager) updateMetadata(tenant, query string, mdata graph.Metadata) error {
+	nodes := tm.getNodes(tenant, query)
 	for _, n := range nodes {
 		mt := tm.graph.StartMetadataTransaction(n)
 		for k, v := range mdata {
@@ -161,8 +163,8 @@ func (tm *TopologyManager) updateMetadata(query string, mdata graph.Metadata) er
 	return nil
 }
 
-func (tm *TopologyManager) deleteMetadata(query string, mdata graph.Metadata) error {
-	nodes := tm.getNodes(query)
+func (tm *TopologyManager) deleteMetadata(tenant, query string, mdata graph.Metadata) error {
+	nodes := tm.getNodes(tenant, query)
 	for _, n := range nodes {
 		mt := tm.graph.StartMetadataTransaction(n)
 		for k := range mdata {
@@ -180,7 +182,7 @@ func (tm *TopologyManager) handleCreateNode(node *types.NodeRule) error {
 	case "create":
 		return tm.createNode(node)
 	case "update":
-		return tm.updateMetadata(node.Query, node.Metadata)
+		return tm.updateMetadata(node.Tenant, node.Query, node.Metadata)
 	default:
 		logging.GetLogger().Errorf("Query format is wrong. supported prefixes: create and update")
 		return errors.New("Query format is wrong")
@@ -188,8 +190,16 @@ func (tm *TopologyManager) handleCreateNode(node *types.NodeRule) error {
 }
 
 /*This needs to be replaced by gremlin + JS query*/
-func (tm *TopologyManager) getNodes(gremlinQuery string) []*graph.Node {
-	res, err := ge.TopologyGremlinQuery(tm.graph, gremlinQuery)
+// getNodes returns the nodes matching a query within the part of the graph
+// the tenant of a rule has access to
+func (tm *TopologyManager) getNodes(tenant, gremlinQuery string) []*graph.Node {
+	g, err := rbac.GetTenantGraph(tm.graph, tenant, nil, false)
+	if err != nil {
+		logging.GetLogger().Error(err)
+		return nil
+	}
+
+	res, err := ge.TopologyGremlinQuery(g, gremlinQuery)
 	if err != nil {
 		return nil
 	}
@@ -219,7 +229,7 @@ func (tm *TopologyManager) handleNodeRuleRequest(action string, resource types.R
 				tm.graph.DelNode(n)
 			}
 		case "update":
-			tm.deleteMetadata(node.Query, node.Metadata)
+			tm.deleteMetadata(node.Tenant, node.Query, node.Metadata)
 		}
 	}
 	return nil
@@ -231,8 +241,8 @@ func (tm *TopologyManager) handleEdgeRuleRequest(action string, resource types.R
 	case "create", "set":
 		return tm.createEdge(edge)
 	case "delete":
-		src := tm.getNodes(edge.Src)
-		dst := tm.getNodes(edge.Dst)
+		src := tm.getNodes(edge.Tenant, edge.Src)
+		dst := tm.getNodes(edge.Tenant, edge.Dst)
 		if len(src) < 1 || len(dst) < 1 {
 			logging.GetLogger().Errorf("Source or Destination node not found")
 			return nil
@@ -250,20 +260,39 @@ func (tm *TopologyManager) handleEdgeRuleRequest(action string, resource types.R
 	return nil
 }
 
-func (tm *TopologyManager) onAPIWatcherEvent(action string, id string, resource types.Resource) {
+func (tm *TopologyManager) handleRuleRequest(action string, resource types.Resource) {
 	switch resource.(type) {
 	case *types.NodeRule:
-		tm.graph.Lock()
 		tm.handleNodeRuleRequest(action, resource)
-		tm.graph.Unlock()
 	case *types.EdgeRule:
 		logging.GetLogger().Debugf("onAPIWatcherEvent edgerule")
-		tm.graph.Lock()
 		tm.handleEdgeRuleRequest(action, resource)
-		tm.graph.Unlock()
 	}
 }
 
+func (tm *TopologyManager) onAPIWatcherEvent(action string, id string, resource types.Resource) {
+	tm.graph.Lock()
+	defer tm.graph.Unlock()
+
+	// an update is handled as the removal of the previous version
+	// of the rule followed by the creation of the new one
+	if action == "update" {
+		if previous, found := tm.rules[id]; found {
+			tm.handleRuleRequest("delete", previous)
+		}
+		action = "set"
+	}
+
+	switch action {
+	case "init", "create", "set":
+		tm.rules[id] = resource
+	case "expire", "delete":
+		delete(tm.rules, id)
+	}
+
+	tm.handleRuleRequest(action, resource)
+}
+
 // OnNodeAdded event
 func (tm *TopologyManager) OnNodeAdded(n *graph.Node) {
 	tm.syncTopology()
@@ -300,6 +329,7 @@ func NewTopologyManager(etcdClient *etcd.Client, nodeHandler *apiServer.NodeRule
 		nodeHandler: nodeHandler,
 		edgeHandler: edgeHandler,
 		graph:       g,
+		rules:       make(map[string]types.Resource),
 	}
 
 	tm.MasterElection = etcdClient.NewElection("topology-manager")
@@ -232,3 +232,25 @@ func (im *InterfaceMetric) Split(cut int64) (common.Metric, common.Metric) {
 
 	return m1, m2
 }
+
+// InterfaceDropMetric the packets of an interface dropped by the kernel,
+// Reasons giving the number of packets dropped for each drop reason
+// easyjson:json
+// gendecoder
+type InterfaceDropMetric struct {
+	Packets int64            `json:"Packets,omitempty"`
+	Bytes   int64            `json:"Bytes,omitempty"`
+	Reasons map[string]int64 `json:"Reasons,omitempty"`
+	Start   int64            `json:"Start,omitempty"`
+	Last    int64            `json:"Last,omitempty"`
+}
+
+// InterfaceDropMetricMetadataDecoder implements a json message raw decoder
+func InterfaceDropMetricMetadataDecoder(raw json.RawMessage) (common.Getter, error) {
+	var metric InterfaceDropMetric
+	if err := json.Unmarshal(raw, &metric); err != nil {
+		return nil, err
+	}
+
+	return &metric, nil
+}
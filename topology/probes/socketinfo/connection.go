@@ -44,17 +44,31 @@ type ProcessInfo struct {
 // ConnectionState describes the state of a connection
 type ConnectionState string
 
+// ConnectionMetric describes the latency and the amount of data of a
+// connection, as seen from the socket calls of its process. Latencies are in
+// nanoseconds, the request latency being the average delay between a request
+// and its response.
+type ConnectionMetric struct {
+	ConnectLatency    int64 `json:",omitempty"`
+	BytesSent         int64 `json:",omitempty"`
+	BytesReceived     int64 `json:",omitempty"`
+	Requests          int64 `json:",omitempty"`
+	RequestLatency    int64 `json:",omitempty"`
+	MaxRequestLatency int64 `json:",omitempty"`
+}
+
 // ConnectionInfo describes a connection and its corresponding process
 // easyjson:json
 // gendecoder
 type ConnectionInfo struct {
-	ProcessInfo   `mapstructure:",squash"`
-	LocalAddress  string
-	LocalPort     int64
-	RemoteAddress string
-	RemotePort    int64
-	Protocol      flow.FlowProtocol
-	State         ConnectionState
+	ProcessInfo      `mapstructure:",squash"`
+	ConnectionMetric `mapstructure:",squash"`
+	LocalAddress     string
+	LocalPort        int64
+	RemoteAddress    string
+	RemotePort       int64
+	Protocol         flow.FlowProtocol
+	State            ConnectionState
 }
 
 // Hash computes the hash of a connection
@@ -37,6 +37,10 @@ func (s *ProbeHandler) Start() {
 func (s *ProbeHandler) Stop() {
 }
 
+// UpdateConnectionMetrics sets the metrics of connections
+func (s *ProbeHandler) UpdateConnectionMetrics(conns []*ConnectionInfo) {
+}
+
 // Init initializes a new SocketInfo Probe
 func (s *ProbeHandler) Init(ctx tp.Context, bundle *probe.Bundle) (probe.Handler, error) {
 	return nil, common.ErrNotImplemented
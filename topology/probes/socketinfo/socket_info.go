@@ -27,3 +27,13 @@ import (
 type ProbeHandler struct {
 	probe.Handler
 }
+
+// UpdateConnectionMetrics sets the metrics of connections, as reported by the
+// socket captures
+func (s *ProbeHandler) UpdateConnectionMetrics(conns []*ConnectionInfo) {
+	if updater, ok := s.Handler.(interface {
+		UpdateConnectionMetrics(conns []*ConnectionInfo)
+	}); ok {
+		updater.UpdateConnectionMetrics(conns)
+	}
+}
@@ -32,6 +32,8 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/pmylund/go-cache"
+
 	"github.com/skydive-project/skydive/common"
 	"github.com/skydive-project/skydive/flow"
 	tp "github.com/skydive-project/skydive/topology/probes"
@@ -54,10 +56,11 @@ var tcpStates = []string{
 
 // ProcProbe describes a probe that collects active connections
 type ProcProbe struct {
-	Ctx       tp.Context
-	connCache *ConnectionCache
-	quit      chan bool
-	procGlob  string
+	Ctx         tp.Context
+	connCache   *ConnectionCache
+	metricCache *cache.Cache
+	quit        chan bool
+	procGlob    string
 }
 
 func getProcessInfo(pid int) (*ProcessInfo, error) {
@@ -230,16 +233,37 @@ func (s *ProcProbe) scanProc() error {
 
 func (s *ProcProbe) updateMetadata() {
 	var sockets []*ConnectionInfo
-	for _, item := range s.connCache.Items() {
+	for hash, item := range s.connCache.Items() {
 		conn := item.Object.(*ConnectionInfo)
+		if metric, found := s.metricCache.Get(hash); found {
+			withMetric := *conn
+			withMetric.ConnectionMetric = metric.(*ConnectionInfo).ConnectionMetric
+			conn = &withMetric
+		}
 		sockets = append(sockets, conn)
 	}
 
+	// connections only known by the socket captures, already closed for instance
+	for hash, item := range s.metricCache.Items() {
+		if _, found := s.connCache.Cache.Get(hash); !found {
+			sockets = append(sockets, item.Object.(*ConnectionInfo))
+		}
+	}
+
 	s.Ctx.Graph.Lock()
 	s.Ctx.Graph.AddMetadata(s.Ctx.RootNode, "Sockets", sockets)
 	s.Ctx.Graph.Unlock()
 }
 
+// UpdateConnectionMetrics sets the metrics of connections, as reported by the
+// socket captures. The metrics of a connection are dropped if not updated
+// during two metadata updates.
+func (s *ProcProbe) UpdateConnectionMetrics(conns []*ConnectionInfo) {
+	for _, conn := range conns {
+		s.metricCache.Set(conn.Hash(), conn, cache.DefaultExpiration)
+	}
+}
+
 // MapTCP returns the sending and receiving processes for a pair of TCP addresses
 // When using /proc, if the connection was not found at the first try, we scan
 // /proc again
@@ -286,10 +310,13 @@ func NewProcProbe(ctx tp.Context) *ProcProbe {
 		procGlob = "/proc/[0-9]*/net"
 	}
 
+	expire := 2 * time.Duration(ctx.Config.GetInt("agent.topology.socketinfo.host_update")) * time.Second
+
 	return &ProcProbe{
-		Ctx:       ctx,
-		procGlob:  procGlob,
-		connCache: NewConnectionCache(),
-		quit:      make(chan bool),
+		Ctx:         ctx,
+		procGlob:    procGlob,
+		connCache:   NewConnectionCache(),
+		metricCache: cache.New(expire, expire),
+		quit:        make(chan bool),
 	}
 }
@@ -18,7 +18,9 @@
 package socketinfo
 
 import (
+	"encoding/json"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/skydive-project/skydive/flow"
@@ -54,3 +56,51 @@ func TestConnectionCache(t *testing.T) {
 		t.Errorf("No entry expected for %s -> %s, got %+v", addr1.String(), addr2.String(), c)
 	}
 }
+
+func TestConnectionMetricDecode(t *testing.T) {
+	conn := &ConnectionInfo{
+		ProcessInfo: ProcessInfo{Pid: 1234, Name: "curl"},
+		ConnectionMetric: ConnectionMetric{
+			ConnectLatency: 1500000,
+			BytesSent:      120,
+			BytesReceived:  4096,
+			Requests:       2,
+			RequestLatency: 3000000,
+		},
+		LocalAddress:  "127.0.0.1",
+		LocalPort:     1234,
+		RemoteAddress: "8.8.8.8",
+		RemotePort:    80,
+		Protocol:      flow.FlowProtocol_TCP,
+	}
+
+	data, err := json.Marshal(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sockets are decoded from the node metadata
+	var obj map[string]interface{}
+	if err = json.Unmarshal(data, &obj); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded ConnectionInfo
+	if err = decoded.Decode(obj); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.ConnectionMetric != conn.ConnectionMetric {
+		t.Errorf("Expected metric %+v, got %+v", conn.ConnectionMetric, decoded.ConnectionMetric)
+	}
+
+	if latency, err := decoded.GetFieldInt64("RequestLatency"); err != nil || latency != conn.RequestLatency {
+		t.Errorf("Expected request latency %d, got %d (%v)", conn.RequestLatency, latency, err)
+	}
+
+	// connections without metric are unchanged
+	conn.ConnectionMetric = ConnectionMetric{}
+	if data, _ = json.Marshal(conn); strings.Contains(string(data), "Latency") {
+		t.Errorf("No metric expected, got %s", string(data))
+	}
+}
@@ -244,6 +244,13 @@ func (s *Server) RegisterLoginRoute(authBackend shttp.AuthenticationBackend) {
 	//     description: Unauthorized
 
 	s.httpServer.Router.HandleFunc("/login", s.serveLoginHandlerFunc(authBackend))
+
+	// backends relying on an external login page
+	if oidc, ok := authBackend.(*shttp.OIDCAuthenticationBackend); ok {
+		s.httpServer.Router.HandleFunc(shttp.OIDCLoginPath, oidc.LoginHandler)
+		s.httpServer.Router.HandleFunc(shttp.OIDCCallbackPath, oidc.CallbackHandler)
+		s.AddGlobalVar("sso-login", shttp.OIDCLoginPath)
+	}
 }
 
 // NewServer returns a new Web server that serves the Skydive UI
@@ -32,6 +32,9 @@ import (
 	ge "github.com/skydive-project/skydive/gremlin/traversal"
 )
 
+// maxCaptureWorkers is the maximum number of afpacket sockets of a capture
+const maxCaptureWorkers = 64
+
 // Validator interface used to validate value type in Gremlin expression
 type Validator interface {
 	Validate() error
@@ -64,6 +67,22 @@ var (
 	LayerKeyModeNotValid = func() error {
 		return valid.TextErr{Err: errors.New("Not a valid layer key mode")}
 	}
+	//WorkersNotValid validator
+	WorkersNotValid = func(min, max int) error {
+		return valid.TextErr{Err: fmt.Errorf("A valid number of workers is >= %d && <= %d", min, max)}
+	}
+	//FanoutModeNotValid validator
+	FanoutModeNotValid = func(mode string) error {
+		return valid.TextErr{Err: fmt.Errorf("Not a valid fanout mode: %s, available modes: hash, cpu", mode)}
+	}
+	//PacketRingBoundNotValid validator
+	PacketRingBoundNotValid = func() error {
+		return valid.TextErr{Err: errors.New("A valid packet ring bound is >= 0")}
+	}
+	//PacketRingStorageNotValid validator
+	PacketRingStorageNotValid = func(storage string) error {
+		return valid.TextErr{Err: fmt.Errorf("Not a valid packet ring storage: %s, available storages: memory, disk", storage)}
+	}
 	//CaptureTypeNotValid validator
 	CaptureTypeNotValid = func(t string) error {
 		return valid.TextErr{Err: fmt.Errorf("Not a valid capture type: %s, available types: %v", t, common.ProbeTypes)}
@@ -241,6 +260,61 @@ func isValidLayerKeyMode(v interface{}, param string) error {
 	return nil
 }
 
+func isValidWorkers(v interface{}, param string) error {
+	workers, ok := v.(int)
+	if !ok || workers < 0 || workers > maxCaptureWorkers {
+		return WorkersNotValid(0, maxCaptureWorkers)
+	}
+
+	return nil
+}
+
+func isValidFanoutMode(v interface{}, param string) error {
+	mode, ok := v.(string)
+	if !ok {
+		return FanoutModeNotValid("null")
+	}
+
+	switch mode {
+	case "", "hash", "cpu":
+		return nil
+	}
+
+	return FanoutModeNotValid(mode)
+}
+
+func isValidPacketRingBound(v interface{}, param string) error {
+	var bound int64
+	switch v := v.(type) {
+	case int:
+		bound = int64(v)
+	case int64:
+		bound = v
+	default:
+		return PacketRingBoundNotValid()
+	}
+
+	if bound < 0 {
+		return PacketRingBoundNotValid()
+	}
+
+	return nil
+}
+
+func isValidPacketRingStorage(v interface{}, param string) error {
+	storage, ok := v.(string)
+	if !ok {
+		return PacketRingStorageNotValid("null")
+	}
+
+	switch storage {
+	case "", "memory", "disk":
+		return nil
+	}
+
+	return PacketRingStorageNotValid(storage)
+}
+
 func isValidWorkflow(v interface{}, param string) error {
 	// Check that `v` is valid JS code that returns
 	// a promise
@@ -289,6 +363,10 @@ func init() {
 	skydiveValidator.SetValidationFunc("isValidCaptureHeaderSize", isValidCaptureHeaderSize)
 	skydiveValidator.SetValidationFunc("isValidRawPacketLimit", isValidRawPacketLimit)
 	skydiveValidator.SetValidationFunc("isValidLayerKeyMode", isValidLayerKeyMode)
+	skydiveValidator.SetValidationFunc("isValidWorkers", isValidWorkers)
+	skydiveValidator.SetValidationFunc("isValidFanoutMode", isValidFanoutMode)
+	skydiveValidator.SetValidationFunc("isValidPacketRingBound", isValidPacketRingBound)
+	skydiveValidator.SetValidationFunc("isValidPacketRingStorage", isValidPacketRingStorage)
 	skydiveValidator.SetValidationFunc("isValidWorkflow", isValidWorkflow)
 	skydiveValidator.SetValidationFunc("isValidCaptureType", isValidCaptureType)
 	skydiveValidator.SetValidationFunc("isValidAddress", isValidAddress)
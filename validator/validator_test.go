@@ -66,3 +66,48 @@ func TestIP(t *testing.T) {
 		t.Error("Should return an error")
 	}
 }
+
+type fanoutTest struct {
+	Workers    int    `valid:"isValidWorkers"`
+	FanoutMode string `valid:"isValidFanoutMode"`
+}
+
+func TestFanout(t *testing.T) {
+	f := fanoutTest{Workers: 4, FanoutMode: "cpu"}
+	if err := Validate(f); err != nil {
+		t.Errorf("Should not return an error: %s", err.Error())
+	}
+
+	f = fanoutTest{Workers: -1}
+	if err := Validate(f); err == nil {
+		t.Error("Should return an error")
+	}
+
+	f = fanoutTest{Workers: 2, FanoutMode: "random"}
+	if err := Validate(f); err == nil {
+		t.Error("Should return an error")
+	}
+}
+
+type packetRingTest struct {
+	Size     int64  `valid:"isValidPacketRingBound"`
+	Duration int    `valid:"isValidPacketRingBound"`
+	Storage  string `valid:"isValidPacketRingStorage"`
+}
+
+func TestPacketRing(t *testing.T) {
+	r := packetRingTest{Size: 1 << 20, Duration: 60, Storage: "disk"}
+	if err := Validate(r); err != nil {
+		t.Errorf("Should not return an error: %s", err.Error())
+	}
+
+	r = packetRingTest{Duration: -1}
+	if err := Validate(r); err == nil {
+		t.Error("Should return an error")
+	}
+
+	r = packetRingTest{Size: 1 << 20, Storage: "tape"}
+	if err := Validate(r); err == nil {
+		t.Error("Should return an error")
+	}
+}
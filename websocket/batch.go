@@ -0,0 +1,136 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// maxBatchSize is the size above which a batch is sent without waiting
+// for the end of the batching window
+const maxBatchSize = 1024 * 1024
+
+// batchingHeader is used by both sides of a connection to announce that
+// they support batch frames. When both do, every frame is a batch frame.
+const batchingHeader = "X-Websocket-Batching"
+
+// ErrMalformedBatch is returned when a batch frame can not be decoded
+var ErrMalformedBatch = errors.New("Malformed batch frame")
+
+// BatchableMessage is the interface of the messages that can be coalesced
+// while waiting in a batch. A message replaces the pending message with the
+// same key if both are replaceable. A message with a key that is not
+// replaceable, like a deletion, prevents the following messages with the same
+// key from being merged with the previous ones.
+type BatchableMessage interface {
+	Message
+	BatchKey() (key string, replaceable bool)
+}
+
+// outgoingMessage is a serialized message waiting to be sent
+type outgoingMessage struct {
+	data        []byte
+	key         string
+	replaceable bool
+}
+
+// messageBatch holds the messages to send at the end of a batching window
+type messageBatch struct {
+	messages [][]byte
+	size     int
+	// index of the pending replaceable messages by key
+	keys map[string]int
+}
+
+func newMessageBatch() *messageBatch {
+	return &messageBatch{keys: make(map[string]int)}
+}
+
+// add appends a message to the batch, or replaces the previous message with
+// the same key. A message without key acts as a barrier, no message queued
+// before it can be replaced.
+func (b *messageBatch) add(m outgoingMessage) {
+	if m.key == "" {
+		if len(b.keys) > 0 {
+			b.keys = make(map[string]int)
+		}
+	} else if i, found := b.keys[m.key]; found && m.replaceable {
+		b.size += len(m.data) - len(b.messages[i])
+		b.messages[i] = m.data
+		return
+	} else if m.replaceable {
+		b.keys[m.key] = len(b.messages)
+	} else {
+		delete(b.keys, m.key)
+	}
+
+	b.messages = append(b.messages, m.data)
+	b.size += len(m.data)
+}
+
+func (b *messageBatch) empty() bool {
+	return len(b.messages) == 0
+}
+
+func (b *messageBatch) full() bool {
+	return b.size >= maxBatchSize
+}
+
+func (b *messageBatch) reset() {
+	b.messages, b.size = nil, 0
+	if len(b.keys) > 0 {
+		b.keys = make(map[string]int)
+	}
+}
+
+// encodeBatch returns a batch frame, the messages prefixed by their length
+// encoded as varints
+func encodeBatch(messages [][]byte) []byte {
+	size := 0
+	for _, m := range messages {
+		size += binary.MaxVarintLen64 + len(m)
+	}
+
+	frame := make([]byte, 0, size)
+	prefix := make([]byte, binary.MaxVarintLen64)
+	for _, m := range messages {
+		n := binary.PutUvarint(prefix, uint64(len(m)))
+		frame = append(frame, prefix[:n]...)
+		frame = append(frame, m...)
+	}
+
+	return frame
+}
+
+// decodeBatch returns the messages of a batch frame
+func decodeBatch(frame []byte) ([][]byte, error) {
+	var messages [][]byte
+	for len(frame) > 0 {
+		size, n := binary.Uvarint(frame)
+		if n <= 0 || uint64(len(frame)-n) < size {
+			return nil, ErrMalformedBatch
+		}
+
+		frame = frame[n:]
+		messages = append(messages, frame[:size])
+		frame = frame[size:]
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package websocket
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMessageBatch(t *testing.T) {
+	batch := newMessageBatch()
+
+	for _, m := range []outgoingMessage{
+		{data: []byte("add1"), key: "Node/1"},
+		{data: []byte("update1-1"), key: "Node/1", replaceable: true},
+		{data: []byte("update2-1"), key: "Node/2", replaceable: true},
+		{data: []byte("update1-2"), key: "Node/1", replaceable: true},
+		{data: []byte("update2-2"), key: "Node/2", replaceable: true},
+		{data: []byte("delete1"), key: "Node/1"},
+		{data: []byte("update1-3"), key: "Node/1", replaceable: true},
+		{data: []byte("sync")},
+		{data: []byte("update2-3"), key: "Node/2", replaceable: true},
+	} {
+		batch.add(m)
+	}
+
+	expected := []string{"add1", "update1-2", "update2-2", "delete1", "update1-3", "sync", "update2-3"}
+
+	var messages []string
+	for _, m := range batch.messages {
+		messages = append(messages, string(m))
+	}
+
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected messages %v, got %v", expected, messages)
+	}
+
+	frame := encodeBatch(batch.messages)
+	decoded, err := decodeBatch(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, batch.messages) {
+		t.Errorf("Expected decoded messages %v, got %v", batch.messages, decoded)
+	}
+
+	if _, err := decodeBatch(frame[:len(frame)-1]); err != ErrMalformedBatch {
+		t.Errorf("Expected a malformed batch error, got %v", err)
+	}
+
+	batch.reset()
+	if !batch.empty() || batch.size != 0 {
+		t.Error("The batch should be empty once reset")
+	}
+}
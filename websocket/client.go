@@ -57,6 +57,7 @@ type ConnStatus struct {
 	ConnectTime       time.Time
 	RemoteHost        string             `json:",omitempty"`
 	RemoteServiceType common.ServiceType `json:",omitempty"`
+	Username          string             `json:",omitempty"`
 }
 
 // Store atomatically stores the state
@@ -134,6 +135,7 @@ type Speaker interface {
 	AddEventHandler(SpeakerEventHandler)
 	GetRemoteHost() string
 	GetRemoteServiceType() common.ServiceType
+	GetUsername() string
 }
 
 // Conn is the connection object of a Speaker
@@ -141,7 +143,7 @@ type Conn struct {
 	common.RWMutex
 	ConnStatus
 	flush            chan struct{}
-	send             chan []byte
+	send             chan outgoingMessage
 	read             chan []byte
 	quit             chan bool
 	wg               sync.WaitGroup
@@ -152,6 +154,8 @@ type Conn struct {
 	wsSpeaker        Speaker // speaker owning the connection
 	writeCompression bool
 	messageType      int
+	batchDelay       time.Duration
+	batchFraming     bool // whether the frames are batch frames
 	logger           logging.Logger
 }
 
@@ -177,6 +181,7 @@ type ClientOpts struct {
 	Headers          http.Header
 	QueueSize        int
 	WriteCompression bool
+	BatchDelay       time.Duration
 	TLSConfig        *tls.Config
 	Logger           logging.Logger
 }
@@ -251,7 +256,12 @@ func (c *Conn) SendMessage(m Message) error {
 		return err
 	}
 
-	c.send <- b
+	msg := outgoingMessage{data: b}
+	if bm, ok := m.(BatchableMessage); ok {
+		msg.key, msg.replaceable = bm.BatchKey()
+	}
+
+	c.send <- msg
 
 	return nil
 }
@@ -262,7 +272,7 @@ func (c *Conn) SendRaw(b []byte) error {
 		return errors.New("Not connected")
 	}
 
-	c.send <- b
+	c.send <- outgoingMessage{data: b}
 
 	return nil
 }
@@ -292,22 +302,45 @@ func (c *Conn) GetRemoteServiceType() common.ServiceType {
 	return c.RemoteServiceType
 }
 
-// write sends a message directly over the wire.
-func (c *Conn) write(msg []byte) error {
+// GetUsername returns the name of the authenticated user of the connection.
+func (c *Conn) GetUsername() string {
+	return c.Username
+}
+
+// write sends a frame directly over the wire.
+func (c *Conn) write(messageType int, data []byte) error {
 	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 	c.conn.EnableWriteCompression(c.writeCompression)
-	w, err := c.conn.NextWriter(c.messageType)
+	w, err := c.conn.NextWriter(messageType)
 	if err != nil {
 		return err
 	}
 
-	if _, err = w.Write(msg); err != nil {
+	if _, err = w.Write(data); err != nil {
 		return err
 	}
 
 	return w.Close()
 }
 
+// writeBatch sends the messages of a batch, in a single batch frame if the
+// remote side supports it
+func (c *Conn) writeBatch(batch *messageBatch) error {
+	defer batch.reset()
+
+	if c.batchFraming {
+		return c.write(websocket.BinaryMessage, encodeBatch(batch.messages))
+	}
+
+	for _, m := range batch.messages {
+		if err := c.write(c.messageType, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Run the main loop
 func (c *Conn) Run() {
 	c.wg.Add(2)
@@ -343,6 +376,31 @@ func (c *Conn) run() {
 		}
 	}
 
+	// the messages sent are gathered in a batch, until the end of the batching
+	// window or, without window, as long as messages are waiting in the queue
+	batch := newMessageBatch()
+	var batchTimer *time.Timer
+	var batchTimeout <-chan time.Time
+
+	fillBatch := func() {
+		for !batch.full() {
+			select {
+			case m := <-c.send:
+				batch.add(m)
+			default:
+				return
+			}
+		}
+	}
+
+	sendBatch := func() error {
+		if batchTimer != nil {
+			batchTimer.Stop()
+			batchTimer, batchTimeout = nil, nil
+		}
+		return c.writeBatch(batch)
+	}
+
 	// notify all the listeners that a message was received
 	handleReceivedMessage := func(m []byte) error {
 		for _, l := range c.cloneEventHandlers() {
@@ -363,11 +421,29 @@ func (c *Conn) run() {
 				}
 				break
 			}
-			c.read <- m
+
+			if !c.batchFraming {
+				c.read <- m
+				continue
+			}
+
+			messages, err := decodeBatch(m)
+			if err != nil {
+				c.logger.Errorf("Error while decoding message from %+v: %s", c, err)
+				continue
+			}
+
+			for _, m := range messages {
+				c.read <- m
+			}
 		}
 	}()
 
 	defer func() {
+		if batchTimer != nil {
+			batchTimer.Stop()
+		}
+
 		c.conn.Close()
 		c.State.Store(common.StoppedState)
 
@@ -388,15 +464,37 @@ func (c *Conn) run() {
 		case m := <-c.read:
 			handleReceivedMessage(m)
 		case m := <-c.send:
-			if err := c.write(m); err != nil {
+			batch.add(m)
+			if c.batchDelay > 0 && !batch.full() {
+				if batchTimer == nil {
+					batchTimer = time.NewTimer(c.batchDelay)
+					batchTimeout = batchTimer.C
+				}
+				continue
+			}
+
+			fillBatch()
+			if err := sendBatch(); err != nil {
 				c.logger.Errorf("Error while sending message to %+v: %s", c, err)
 				return
 			}
-		case <-c.flush:
-			if err := flushChannel(c.send, c.write); err != nil {
-				c.logger.Errorf("Error while flushing send queue for %+v: %s", c, err)
+		case <-batchTimeout:
+			fillBatch()
+			if err := sendBatch(); err != nil {
+				c.logger.Errorf("Error while sending message to %+v: %s", c, err)
 				return
 			}
+		case <-c.flush:
+			for {
+				fillBatch()
+				if batch.empty() {
+					break
+				}
+				if err := sendBatch(); err != nil {
+					c.logger.Errorf("Error while flushing send queue for %+v: %s", c, err)
+					return
+				}
+			}
 		case <-c.pingTicker.C:
 			if err := c.sendPing(); err != nil {
 				c.logger.Errorf("Error while sending ping to %+v: %s", c, err)
@@ -465,12 +563,13 @@ func newConn(host string, clientType common.ServiceType, clientProtocol Protocol
 			Headers:        headers,
 			ConnectTime:    time.Now(),
 		},
-		send:             make(chan []byte, opts.QueueSize),
+		send:             make(chan outgoingMessage, opts.QueueSize),
 		read:             make(chan []byte, opts.QueueSize),
 		flush:            make(chan struct{}),
 		quit:             make(chan bool, 2),
 		pingTicker:       &time.Ticker{},
 		writeCompression: opts.WriteCompression,
+		batchDelay:       opts.BatchDelay,
 		logger:           opts.Logger,
 	}
 
@@ -502,6 +601,7 @@ func (c *Client) Connect() error {
 		"X-Client-Type":         {c.ServiceType.String()},
 		"X-Client-Protocol":     {c.ClientProtocol.String()},
 		"X-Websocket-Namespace": {WildcardNamespace},
+		batchingHeader:          {"true"},
 	}
 
 	for k, v := range c.Headers {
@@ -515,9 +615,10 @@ func (c *Client) Connect() error {
 	}
 
 	d := websocket.Dialer{
-		Proxy:           http.ProxyFromEnvironment,
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
+		Proxy:             http.ProxyFromEnvironment,
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		EnableCompression: c.writeCompression,
 	}
 	d.TLSClientConfig = c.TLSConfig
 
@@ -542,6 +643,8 @@ func (c *Client) Connect() error {
 		c.RemoteHost = c.conn.RemoteAddr().String()
 	}
 
+	c.batchFraming = resp.Header.Get(batchingHeader) == "true"
+
 	c.RemoteServiceType = common.ServiceType(resp.Header.Get("X-Service-Type"))
 	if c.RemoteServiceType == "" {
 		c.RemoteServiceType = common.UnknownService
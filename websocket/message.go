@@ -64,6 +64,10 @@ type structMessageState struct {
 	// the call flow
 	jsonCache     []byte
 	protobufCache []byte
+
+	// identify the object the message is about when batched
+	batchKey    string
+	replaceable bool
 }
 
 func (p *Protocol) parse(s string) error {
@@ -146,6 +150,19 @@ func (g *StructMessage) jsonBytes() ([]byte, error) {
 	return msgBytes, nil
 }
 
+// SetBatchKey sets the key of the object the message is about. While batched,
+// a replaceable message replaces the previous replaceable message with the
+// same key, see BatchableMessage.
+func (g *StructMessage) SetBatchKey(key string, replaceable bool) {
+	g.XXX_state.batchKey = key
+	g.XXX_state.replaceable = replaceable
+}
+
+// BatchKey implements the BatchableMessage interface
+func (g *StructMessage) BatchKey() (string, bool) {
+	return g.XXX_state.batchKey, g.XXX_state.replaceable
+}
+
 // Bytes implements the message interface
 func (g *StructMessage) Bytes(protocol Protocol) ([]byte, error) {
 	if protocol == ProtobufProtocol {
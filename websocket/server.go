@@ -50,6 +50,7 @@ type Server struct {
 // ServerOpts defines server options
 type ServerOpts struct {
 	WriteCompression bool
+	BatchDelay       time.Duration
 	QueueSize        int
 	PingDelay        time.Duration
 	PongTimeout      time.Duration
@@ -94,8 +95,20 @@ func (s *Server) serveMessages(w http.ResponseWriter, r *auth.AuthenticatedReque
 	header := http.Header{}
 	header.Set("X-Host-ID", s.server.Host)
 	header.Set("X-Service-Type", s.server.ServiceType.String())
+	if getRequestParameter(&r.Request, batchingHeader) == "true" {
+		header.Set(batchingHeader, "true")
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		EnableCompression: s.opts.WriteCompression,
+		// the origin is not checked and the errors are reported below
+		CheckOrigin: func(r *http.Request) bool { return true },
+		Error:       func(w http.ResponseWriter, r *http.Request, status int, reason error) {},
+	}
 
-	conn, err := websocket.Upgrade(w, &r.Request, header, 1024, 1024)
+	conn, err := upgrader.Upgrade(w, &r.Request, header)
 	if err != nil {
 		s.opts.Logger.Errorf("Unable to upgrade the websocket connection for %s: %s", r.RemoteAddr, err)
 		w.Header().Set("Connection", "close")
@@ -133,12 +146,15 @@ func (s *Server) newIncomingClient(conn *websocket.Conn, r *auth.AuthenticatedRe
 	opts := ClientOpts{
 		QueueSize:        s.opts.QueueSize,
 		WriteCompression: s.opts.WriteCompression,
+		BatchDelay:       s.opts.BatchDelay,
 		Logger:           s.opts.Logger,
 	}
 
 	wsconn := newConn(s.server.Host, clientType, clientProtocol, url, r.Header, opts)
 	wsconn.conn = conn
 	wsconn.RemoteHost = getRequestParameter(&r.Request, "X-Host-ID")
+	wsconn.Username = r.Username
+	wsconn.batchFraming = getRequestParameter(&r.Request, batchingHeader) == "true"
 
 	// NOTE(safchain): fallback to remote addr if host id not provided
 	// should be removed, connection should be refused if host id not provided